@@ -0,0 +1,65 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func validateAccelerate(opts *S3TarS3Options) error {
+	if !opts.Accelerate {
+		return nil
+	}
+	switch {
+	case opts.DstPath != "":
+		return fmt.Errorf("%w: --accelerate needs an Amazon S3 destination", ErrInvalidArgument)
+	case opts.EndpointUrl != "" || (opts.Provider != "" && opts.provider.Name != "aws"):
+		return fmt.Errorf("%w: --accelerate can't be used with a custom endpoint or provider", ErrInvalidArgument)
+	case opts.provider.PathStyle:
+		return fmt.Errorf("%w: --accelerate needs virtual-hosted addressing", ErrInvalidArgument)
+	case strings.Contains(opts.DstBucket, "."):
+		return fmt.Errorf("%w: --accelerate can't be used with bucket %s, its name has dots", ErrInvalidArgument, opts.DstBucket)
+	}
+	return nil
+}
+
+// checkAccelerate fails when transfer acceleration isn't enabled on the
+// destination bucket, which rejects requests to its acceleration endpoint. A
+// caller that can't read the configuration of the bucket is only warned.
+func checkAccelerate(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	if !opts.Accelerate {
+		return nil
+	}
+	out, err := svc.GetBucketAccelerateConfiguration(ctx, &s3.GetBucketAccelerateConfigurationInput{Bucket: &opts.DstBucket})
+	if err != nil {
+		if err = classifyError(err); errors.Is(err, ErrAccessDenied) {
+			Warnf(ctx, "unable to check that transfer acceleration is enabled on s3://%s: %s", opts.DstBucket, err.Error())
+			return nil
+		}
+		return err
+	}
+	if out.Status != types.BucketAccelerateStatusEnabled {
+		return fmt.Errorf("%w: transfer acceleration isn't enabled on s3://%s", ErrInvalidArgument, opts.DstBucket)
+	}
+	return nil
+}
+
+// accelerate returns the options that send the uploads of the archive to the
+// transfer acceleration endpoint of the bucket, with opts.Accelerate.
+func accelerate(opts *S3TarS3Options) []func(*s3.Options) {
+	if !opts.Accelerate {
+		return nil
+	}
+	return []func(*s3.Options){useAccelerate}
+}
+
+func useAccelerate(o *s3.Options) {
+	o.UseAccelerate = true
+}
@@ -0,0 +1,47 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestValidateAccelerate(t *testing.T) {
+	tests := map[string]struct {
+		opts    S3TarS3Options
+		wantErr bool
+	}{
+		"off":             {opts: S3TarS3Options{DstBucket: "my.bucket", EndpointUrl: "http://localhost:9000"}},
+		"bucket":          {opts: S3TarS3Options{Accelerate: true, DstBucket: "bucket"}},
+		"local":           {opts: S3TarS3Options{Accelerate: true, DstPath: "/tmp/archive.tar"}, wantErr: true},
+		"endpoint":        {opts: S3TarS3Options{Accelerate: true, DstBucket: "bucket", EndpointUrl: "http://localhost:9000"}, wantErr: true},
+		"provider":        {opts: S3TarS3Options{Accelerate: true, DstBucket: "bucket", Provider: "minio"}, wantErr: true},
+		"dots":            {opts: S3TarS3Options{Accelerate: true, DstBucket: "my.bucket"}, wantErr: true},
+		"aws provider":    {opts: S3TarS3Options{Accelerate: true, DstBucket: "bucket", Provider: "aws"}},
+		"no acceleration": {opts: S3TarS3Options{DstBucket: "bucket"}},
+	}
+	for name, tt := range tests {
+		if err := validateProvider(&tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		err := validateAccelerate(&tt.opts)
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidArgument)) {
+			t.Errorf("%s: validateAccelerate() error = %v, wantErr %v", name, err, tt.wantErr)
+		}
+	}
+
+	if optFns := accelerate(&S3TarS3Options{}); len(optFns) != 0 {
+		t.Errorf("accelerate() without --accelerate = %d options", len(optFns))
+	}
+	o := &s3.Options{}
+	for _, fn := range accelerate(&S3TarS3Options{Accelerate: true}) {
+		fn(o)
+	}
+	if !o.UseAccelerate {
+		t.Errorf("accelerate() doesn't use the acceleration endpoint")
+	}
+}
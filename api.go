@@ -7,6 +7,7 @@ import (
 	"archive/tar"
 	"context"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"strings"
@@ -19,10 +20,47 @@ type Archiver interface {
 	List(context.Context, string, *S3TarS3Options, ...func(*S3TarS3Options)) (TOC, error)
 }
 
+// NewArchiveClient returns an Archiver that writes with client, configured by
+// the caller with its credentials, middleware or tracing. The options of every
+// run are validated against it, see checkClient.
 func NewArchiveClient(client *s3.Client) Archiver {
 	return &ArchiveClient{client}
 }
 
+// NewArchiveClientFromConfig returns an Archiver whose client is built from
+// cfg, with its credentials, retryer and HTTP client, and optFns.
+func NewArchiveClientFromConfig(cfg aws.Config, optFns ...func(*s3.Options)) Archiver {
+	return NewArchiveClient(s3.NewFromConfig(cfg, optFns...))
+}
+
+// checkClient validates opts against the client they run with. The region
+// and the endpoint of opts default to those of the client and, when both are
+// set, must match them.
+func checkClient(client *s3.Client, opts *S3TarS3Options) error {
+	if client == nil {
+		return fmt.Errorf("%w: an s3.Client is required", ErrInvalidArgument)
+	}
+	o := client.Options()
+	switch {
+	case opts.Region == "":
+		opts.Region = o.Region
+	case o.Region != "" && opts.Region != o.Region:
+		return fmt.Errorf("%w: region %s doesn't match the region %s of the client", ErrInvalidArgument, opts.Region, o.Region)
+	}
+	if opts.Region == "" {
+		return fmt.Errorf("%w: the client has no region", ErrInvalidArgument)
+	}
+	if o.BaseEndpoint != nil {
+		switch {
+		case opts.EndpointUrl == "":
+			opts.EndpointUrl = *o.BaseEndpoint
+		case opts.EndpointUrl != *o.BaseEndpoint:
+			return fmt.Errorf("%w: endpoint %s doesn't match the endpoint %s of the client", ErrInvalidArgument, opts.EndpointUrl, *o.BaseEndpoint)
+		}
+	}
+	return nil
+}
+
 type ArchiveClient struct {
 	client *s3.Client
 }
@@ -34,7 +72,7 @@ func (a *ArchiveClient) Create(ctx context.Context, options *S3TarS3Options, opt
 	if err != nil {
 		return err
 	}
-	return ServerSideTar(ctx, a.client, opts)
+	return classifyError(ServerSideTar(ctx, a.client, opts))
 
 }
 
@@ -45,7 +83,7 @@ func (a *ArchiveClient) CreateFromList(ctx context.Context, objectList []*S3Obj,
 		return err
 	}
 
-	return createFromList(ctx, a.client, objectList, opts)
+	return classifyError(createFromList(ctx, a.client, objectList, opts))
 }
 
 func (a *ArchiveClient) checkArgs(options *S3TarS3Options, optFns []func(s3Options *S3TarS3Options)) (*S3TarS3Options, error) {
@@ -63,6 +101,9 @@ func (a *ArchiveClient) checkArgs(options *S3TarS3Options, optFns []func(s3Optio
 	if err := validateStorageClass(&opts); err != nil {
 		return nil, err
 	}
+	if err := checkClient(a.client, &opts); err != nil {
+		return nil, err
+	}
 
 	return &opts, nil
 
@@ -78,8 +119,11 @@ func (a *ArchiveClient) Extract(ctx context.Context, options *S3TarS3Options, op
 	for _, fn := range optFns {
 		fn(&opts)
 	}
+	if err := checkClient(a.client, &opts); err != nil {
+		return err
+	}
 
-	return Extract(ctx, a.client, opts.extractPrefix, &opts)
+	return classifyError(Extract(ctx, a.client, opts.extractPrefix, &opts))
 }
 
 func (a *ArchiveClient) List(ctx context.Context, archiveS3Url string, options *S3TarS3Options, optFns ...func(options *S3TarS3Options)) (TOC, error) {
@@ -94,8 +138,12 @@ func (a *ArchiveClient) List(ctx context.Context, archiveS3Url string, options *
 	for _, fn := range optFns {
 		fn(&opts)
 	}
+	if err := checkClient(a.client, &opts); err != nil {
+		return TOC{}, err
+	}
 
-	return List(ctx, a.client, opts.SrcBucket, opts.SrcKey, &opts)
+	toc, err := List(ctx, a.client, opts.SrcBucket, opts.SrcKey, &opts)
+	return toc, classifyError(err)
 }
 
 func WithStorageClass(sc string) func(*S3TarS3Options) {
@@ -105,15 +153,38 @@ func WithStorageClass(sc string) func(*S3TarS3Options) {
 	}
 }
 
+// WithSourceClient sets a separate client to read source objects with, for
+// sources in another account or region than the destination.
+func WithSourceClient(client *s3.Client) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.srcClient = client
+	}
+}
+
 func WithExtractPrefix(prefix string) func(*S3TarS3Options) {
 	return func(opts *S3TarS3Options) {
 		opts.extractPrefix = prefix
 	}
 }
 
+// WithProgress registers fn to receive the aggregated throughput, completed parts
+// and ETA of the run every time progress is reported.
+func WithProgress(fn func(Progress)) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.ProgressFn = fn
+	}
+}
+
+// WithSummary registers fn to receive the summary of the archive once it is created.
+func WithSummary(fn func(*RunSummary)) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.SummaryFn = fn
+	}
+}
+
 func validateStorageClass(opts *S3TarS3Options) error {
 	if !containsClass(string(opts.storageClass)) {
-		return fmt.Errorf("storage class not valid")
+		return fmt.Errorf("%w: storage class not valid", ErrInvalidArgument)
 	}
 	return nil
 }
@@ -154,45 +225,48 @@ func WithKMS(kmsKeyID, sseAlgo string) func(options *S3TarS3Options) {
 }
 
 func checkCreateArgs(opts *S3TarS3Options) error {
-	if opts.SrcBucket == "" && opts.SrcManifest == "" {
-		return fmt.Errorf("src bucket or src manifest required")
+	if opts.SrcBucket == "" && opts.SrcManifest == "" && opts.SrcPath == "" && len(opts.Sources) == 0 {
+		return fmt.Errorf("%w: src bucket, src manifest, src path or sources required", ErrInvalidArgument)
 	}
-	if opts.DstBucket == "" {
-		return fmt.Errorf("destination bucket required")
+	if opts.DstBucket == "" && opts.DstPath == "" {
+		return fmt.Errorf("%w: destination bucket or destination path required", ErrInvalidArgument)
 	}
-	if opts.DstKey == "" {
-		return fmt.Errorf("destination key required")
+	if opts.DstKey == "" && opts.DstPath == "" {
+		return fmt.Errorf("%w: destination key required", ErrInvalidArgument)
 	}
 	if opts.storageClass == "" {
 		opts.storageClass = types.StorageClassStandard
 	}
 	if opts.Threads == 0 {
-		opts.Threads = 100
+		opts.Threads = defaultThreads
 	}
 	opts.tarFormat = tar.FormatPAX
 	return nil
 }
 func checkExtractArgs(opts *S3TarS3Options) error {
 	if opts.SrcBucket == "" && opts.SrcManifest == "" {
-		return fmt.Errorf("src bucket or src manifest required")
+		return fmt.Errorf("%w: src bucket or src manifest required", ErrInvalidArgument)
+	}
+	if opts.DstPath == "" && opts.DstBucket == "" {
+		return fmt.Errorf("%w: destination bucket required", ErrInvalidArgument)
 	}
-	if opts.DstBucket == "" {
-		return fmt.Errorf("destination bucket required")
+	if opts.DstPath == "" && opts.DstPrefix == "" {
+		return fmt.Errorf("%w: destination prefix required", ErrInvalidArgument)
 	}
-	if opts.DstPrefix == "" {
-		return fmt.Errorf("destination prefix required")
+	if err := validateConflictPolicy(opts); err != nil {
+		return err
 	}
 	if opts.Threads == 0 {
-		opts.Threads = 100
+		opts.Threads = defaultThreads
 	}
 	return nil
 }
 func checkListArgs(opts *S3TarS3Options) error {
 	if opts.SrcBucket == "" && opts.SrcKey == "" {
-		return fmt.Errorf("s3url required s3://bucket/key.tar")
+		return fmt.Errorf("%w: s3url required s3://bucket/key.tar", ErrInvalidArgument)
 	}
 	if opts.Threads == 0 {
-		opts.Threads = 100
+		opts.Threads = defaultThreads
 	}
 	return nil
 }
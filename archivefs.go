@@ -0,0 +1,237 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ArchiveFS is a read-only fs.FS of the members of an archive in Amazon S3,
+// read at the offsets of its TOC with range requests. It implements
+// fs.ReadFileFS, fs.ReadDirFS and fs.StatFS, and the files it opens
+// io.ReaderAt and io.Seeker. Directories are the prefixes of the member
+// names. Every file has the mode 0444 and the last modified time of the
+// archive, FileInfo.Sys is its *FileMetadata.
+type ArchiveFS struct {
+	archive *archiveReader
+	files   map[string]*FileMetadata
+	dirs    map[string][]fs.DirEntry
+}
+
+// OpenArchive reads the TOC of s3://bucket/key and returns the members of the
+// archive as a filesystem. The archive must have a toc.csv, or a TOC next to
+// it for archives built in memory. ctx is used for every read of the
+// filesystem.
+func OpenArchive(ctx context.Context, client *s3.Client, bucket, key string) (*ArchiveFS, error) {
+	archive, err := headArchive(ctx, client, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	toc, err := readToc(ctx, client, archive)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return newArchiveFS(archive, toc), nil
+}
+
+func newArchiveFS(archive *archiveReader, toc TOC) *ArchiveFS {
+	fsys := &ArchiveFS{archive: archive, files: map[string]*FileMetadata{}, dirs: map[string][]fs.DirEntry{}}
+	children := map[string]map[string]bool{".": {}}
+	for _, m := range toc {
+		name := path.Clean("/" + m.Filename)[1:]
+		isDir := strings.HasSuffix(m.Filename, "/")
+		if name == "" || !fs.ValidPath(name) {
+			continue
+		}
+		if !isDir {
+			fsys.files[name] = m
+		}
+		// add name, as a file or a directory, and its parents
+		for dir := path.Dir(name); ; name, dir = dir, path.Dir(dir) {
+			if children[dir] == nil {
+				children[dir] = map[string]bool{}
+			}
+			children[dir][path.Base(name)] = true
+			if isDir && children[name] == nil {
+				children[name] = map[string]bool{}
+			}
+			isDir = true
+			if dir == "." {
+				break
+			}
+		}
+	}
+	for dir := range children {
+		fsys.dirs[dir] = nil
+	}
+	for dir, names := range children {
+		var entries []fs.DirEntry
+		for name := range names {
+			entries = append(entries, fs.FileInfoToDirEntry(fsys.info(path.Join(dir, name))))
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		fsys.dirs[dir] = entries
+	}
+	return fsys
+}
+
+// info returns the FileInfo of name, nil when it doesn't exist. A name that
+// is both a member and a directory is a directory.
+func (fsys *ArchiveFS) info(name string) *archiveFileInfo {
+	if _, ok := fsys.dirs[name]; ok {
+		return &archiveFileInfo{name: path.Base(name), modTime: fsys.archive.modTime}
+	}
+	if m, ok := fsys.files[name]; ok {
+		return &archiveFileInfo{name: path.Base(name), m: m, modTime: fsys.archive.modTime}
+	}
+	return nil
+}
+
+func (fsys *ArchiveFS) lookup(op, name string) (*archiveFileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	info := fsys.info(name)
+	if info == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+// Open opens the member or directory name.
+func (fsys *ArchiveFS) Open(name string) (fs.File, error) {
+	info, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &archiveDir{info: info, entries: fsys.dirs[name]}, nil
+	}
+	return &archiveFile{SectionReader: io.NewSectionReader(fsys.archive, info.m.Start, info.m.Size), info: info}, nil
+}
+
+// Member returns a reader of the data of the member name.
+func (fsys *ArchiveFS) Member(name string) (*io.SectionReader, error) {
+	info, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	return io.NewSectionReader(fsys.archive, info.m.Start, info.m.Size), nil
+}
+
+// ReadFile reads the member name with a single range request.
+func (fsys *ArchiveFS) ReadFile(name string) ([]byte, error) {
+	info, err := fsys.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	data, err := fsys.archive.member(info.m.Start, info.m.Size)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// ReadDir returns the entries of the directory name, sorted by name.
+func (fsys *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	return append([]fs.DirEntry{}, fsys.dirs[name]...), nil
+}
+
+// Stat returns the FileInfo of the member or directory name.
+func (fsys *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// archiveFileInfo is the FileInfo of a member, or of a directory when m is
+// nil.
+type archiveFileInfo struct {
+	name    string
+	m       *FileMetadata
+	modTime time.Time
+}
+
+func (i *archiveFileInfo) Name() string       { return i.name }
+func (i *archiveFileInfo) ModTime() time.Time { return i.modTime }
+func (i *archiveFileInfo) IsDir() bool        { return i.m == nil }
+func (i *archiveFileInfo) Sys() any           { return i.m }
+
+func (i *archiveFileInfo) Size() int64 {
+	if i.m == nil {
+		return 0
+	}
+	return i.m.Size
+}
+
+func (i *archiveFileInfo) Mode() fs.FileMode {
+	if i.m == nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// archiveFile is an open member of an ArchiveFS.
+type archiveFile struct {
+	*io.SectionReader
+	info *archiveFileInfo
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *archiveFile) Close() error               { return nil }
+
+// archiveDir is an open directory of an ArchiveFS.
+type archiveDir struct {
+	info    *archiveFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *archiveDir) Close() error               { return nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := d.entries[d.offset:]
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	d.offset += len(entries)
+	return append([]fs.DirEntry{}, entries...), nil
+}
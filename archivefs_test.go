@@ -0,0 +1,69 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestArchiveFS(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	data := testArchive(t, func(lines []string) []string {
+		lines[1] = "dir/" + lines[1]
+		lines[2] = "./dir/sub/" + lines[2]
+		return lines
+	}, 100, 1024, 0, 700)
+	var ranges [][2]int64
+	archive := bytesArchive(data, &ranges)
+	toc, err := readToc(ctx, nil, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := newArchiveFS(archive, toc)
+
+	if err := fstest.TestFS(fsys, "file0.txt", "dir/file1.txt", "dir/sub/file2.txt", "file3.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(fsys, "dir/file1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := toc[1]
+	if !bytes.Equal(got, data[m.Start:m.Start+m.Size]) {
+		t.Errorf("ReadFile(dir/file1.txt) returned the wrong bytes")
+	}
+	r, err := fsys.Member("file3.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err := r.ReadAt(buf, 690); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if want := data[toc[3].Start+690 : toc[3].Start+700]; !bytes.Equal(buf, want) {
+		t.Errorf("Member(file3.txt).ReadAt() = %q, want %q", buf, want)
+	}
+
+	info, err := fs.Stat(fsys, "dir/sub/file2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 || info.Sys().(*FileMetadata).Etag != "etag2" {
+		t.Errorf("Stat(dir/sub/file2.txt) = %d bytes, %v", info.Size(), info.Sys())
+	}
+	if _, err := fsys.Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(missing.txt) error = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := fsys.ReadFile("dir"); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("ReadFile(dir) error = %v, want is a directory", err)
+	}
+}
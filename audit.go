@@ -0,0 +1,200 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// AuditRecord is a line of the audit log: an attempt of an Amazon S3
+// operation. A request that is retried has a line per attempt, with the same
+// Operation, Bucket and Key.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"op"`
+	Bucket    string    `json:"bucket,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	// BytesSent and BytesReceived are the lengths of the bodies of the
+	// request and the response.
+	BytesSent     int64   `json:"bytes_sent"`
+	BytesReceived int64   `json:"bytes_received"`
+	DurationMs    float64 `json:"duration_ms"`
+	Status        int     `json:"status,omitempty"`
+	RequestID     string  `json:"request_id,omitempty"`
+	HostID        string  `json:"host_id,omitempty"`
+	// Outcome is ok, the error code of the service, e.g. SlowDown, or error
+	// for the requests that got no response.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditLog records every Amazon S3 request of the clients it's added to with
+// ClientOptions as NDJSON lines of AuditRecord, to reconcile a run against
+// AWS CloudTrail, which has the same request ids, and to find the requests
+// that were throttled.
+type AuditLog struct {
+	location string
+	mu       sync.Mutex
+	w        io.Writer
+	file     *os.File
+	buf      *bytes.Buffer
+	closed   bool
+}
+
+// NewAuditLog returns an audit log written to location, a local file, written
+// as requests complete, or an s3:// url, written by Close.
+func NewAuditLog(location string) (*AuditLog, error) {
+	l := &AuditLog{location: location}
+	if strings.HasPrefix(location, "s3://") {
+		l.buf = &bytes.Buffer{}
+		l.w = l.buf
+		return l, nil
+	}
+	f, err := os.Create(location)
+	if err != nil {
+		return nil, fmt.Errorf("%w: audit log: %w", ErrInvalidArgument, err)
+	}
+	l.file, l.w = f, f
+	return l, nil
+}
+
+// ClientOptions adds the audit log to the options of an Amazon S3 client, as
+// in s3.New(options, l.ClientOptions).
+func (l *AuditLog) ClientOptions(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, l.addMiddleware)
+}
+
+func (l *AuditLog) addMiddleware(stack *middleware.Stack) error {
+	if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("AuditLogTarget", auditTarget), middleware.Before); err != nil {
+		return err
+	}
+	// after the retries, to record every attempt
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("AuditLog", l.handleFinalize), "Retry", middleware.After)
+}
+
+type auditTargetKey struct{}
+
+// auditTarget records the bucket and the key of the input of the operation,
+// which the attempts don't have.
+func auditTarget(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	bucket, key := inputTarget(in.Parameters)
+	ctx = middleware.WithStackValue(ctx, auditTargetKey{}, [2]string{bucket, key})
+	return next.HandleInitialize(ctx, in)
+}
+
+// inputTarget returns the Bucket and the Key of the input of an operation,
+// empty when it has none.
+func inputTarget(params any) (string, string) {
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "", ""
+	}
+	field := func(name string) string {
+		f := v.FieldByName(name)
+		if !f.IsValid() || f.Kind() != reflect.Pointer || f.IsNil() || f.Elem().Kind() != reflect.String {
+			return ""
+		}
+		return f.Elem().String()
+	}
+	return field("Bucket"), field("Key")
+}
+
+func (l *AuditLog) handleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	start := time.Now()
+	out, metadata, err := next.HandleFinalize(ctx, in)
+	rec := &AuditRecord{
+		Time:       start.UTC(),
+		Operation:  awsmiddleware.GetOperationName(ctx),
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if target, ok := middleware.GetStackValue(ctx, auditTargetKey{}).([2]string); ok {
+		rec.Bucket, rec.Key = target[0], target[1]
+	}
+	if req, ok := in.Request.(*smithyhttp.Request); ok && req.ContentLength > 0 {
+		rec.BytesSent = req.ContentLength
+	}
+	if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+		rec.Status = resp.StatusCode
+		if resp.ContentLength > 0 {
+			rec.BytesReceived = resp.ContentLength
+		}
+	}
+	rec.RequestID, _ = awsmiddleware.GetRequestIDMetadata(metadata)
+	rec.HostID, _ = s3.GetHostIDMetadata(metadata)
+	setAuditOutcome(rec, err)
+	l.write(rec)
+	return out, metadata, err
+}
+
+// setAuditOutcome sets the outcome of rec, an attempt that failed with err.
+func setAuditOutcome(rec *AuditRecord, err error) {
+	if err == nil {
+		rec.Outcome = "ok"
+		return
+	}
+	rec.Outcome = "error"
+	rec.Error = err.Error()
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		if rec.Status == 0 {
+			rec.Status = re.HTTPStatusCode()
+		}
+		if rec.RequestID == "" {
+			rec.RequestID = re.ServiceRequestID()
+		}
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() != "" {
+		rec.Outcome = ae.ErrorCode()
+	}
+}
+
+func (l *AuditLog) write(rec *AuditRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		// the requests that write the log itself
+		return
+	}
+	l.w.Write(append(line, '\n'))
+}
+
+// Close stops recording requests and writes the log, with svc when it's in
+// Amazon S3.
+func (l *AuditLog) Close(ctx context.Context, svc *s3.Client) error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	if l.file != nil {
+		return l.file.Close()
+	}
+	if err := saveFile(ctx, svc, l.location, l.buf.Bytes()); err != nil {
+		return fmt.Errorf("writing the audit log %s: %w", l.location, classifyError(err))
+	}
+	return nil
+}
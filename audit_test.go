@@ -0,0 +1,113 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// doFunc is an HTTP client that answers every request with a function.
+type doFunc func(*http.Request) (*http.Response, error)
+
+func (f doFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestAuditLog(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "audit.ndjson")
+	audit, err := NewAuditLog(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first attempt is throttled
+	attempts := 0
+	do := doFunc(func(req *http.Request) (*http.Response, error) {
+		io.Copy(io.Discard, req.Body)
+		attempts++
+		header := http.Header{"X-Amz-Request-Id": {"req" + string(rune('0'+attempts))}}
+		if attempts == 1 {
+			body := `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`
+			return &http.Response{StatusCode: 503, Header: header, Body: io.NopCloser(strings.NewReader(body)), ContentLength: int64(len(body)), Request: req}, nil
+		}
+		header.Set("ETag", `"etag"`)
+		return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})
+	client := s3.New(s3.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String("http://s3.local"),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   do,
+		Retryer: retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		}),
+	}, audit.ClientOptions)
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String("b"), Key: aws.String("dir/k"), Body: strings.NewReader("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.Close(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	want := []AuditRecord{
+		{Operation: "PutObject", Bucket: "b", Key: "dir/k", BytesSent: 5, Status: 503, RequestID: "req1", Outcome: "SlowDown"},
+		{Operation: "PutObject", Bucket: "b", Key: "dir/k", BytesSent: 5, Status: 200, RequestID: "req2", Outcome: "ok"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("the audit log has %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, rec := range records {
+		w := want[i]
+		if rec.Operation != w.Operation || rec.Bucket != w.Bucket || rec.Key != w.Key || rec.BytesSent != w.BytesSent ||
+			rec.Status != w.Status || rec.RequestID != w.RequestID || rec.Outcome != w.Outcome || rec.Time.IsZero() {
+			t.Errorf("record %d = %+v, want %+v", i, rec, w)
+		}
+	}
+}
+
+func TestInputTarget(t *testing.T) {
+	tests := map[string]struct {
+		params      any
+		bucket, key string
+	}{
+		"object":    {params: &s3.HeadObjectInput{Bucket: aws.String("b"), Key: aws.String("k")}, bucket: "b", key: "k"},
+		"bucket":    {params: &s3.ListObjectsV2Input{Bucket: aws.String("b")}, bucket: "b"},
+		"no bucket": {params: &s3.ListBucketsInput{}},
+		"nil":       {params: nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			bucket, key := inputTarget(tt.params)
+			if bucket != tt.bucket || key != tt.key {
+				t.Errorf("inputTarget() = %q, %q, want %q, %q", bucket, key, tt.bucket, tt.key)
+			}
+		})
+	}
+}
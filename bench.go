@@ -0,0 +1,389 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// benchMemoryInterval is how often the memory of a benchmark run is sampled.
+const benchMemoryInterval = 100 * time.Millisecond
+
+// BenchOptions describes the synthetic objects Bench archives.
+type BenchOptions struct {
+	// Objects is the number of objects generated.
+	Objects int
+	// Sizes is the distribution the size of every object is drawn from.
+	Sizes []BenchSize
+	// Seed makes the sizes drawn reproducible, 1 when zero.
+	Seed int64
+}
+
+// BenchSize is a size of the objects of a benchmark and its weight in the
+// distribution.
+type BenchSize struct {
+	Size   int64
+	Weight int
+}
+
+// ParseBenchSizes parses a size distribution such as 4KiB:80,1MiB:15,64MiB:5,
+// sizes as in ParseSize, each with an optional weight, 1 by default.
+func ParseBenchSizes(s string) ([]BenchSize, error) {
+	var sizes []BenchSize
+	for _, field := range strings.Split(s, ",") {
+		size, weight, found := strings.Cut(strings.TrimSpace(field), ":")
+		b := BenchSize{Weight: 1}
+		var err error
+		if b.Size, err = ParseSize(size); err != nil {
+			return nil, err
+		}
+		if b.Size == 0 {
+			return nil, fmt.Errorf("%w: invalid size %q", ErrInvalidArgument, size)
+		}
+		if found {
+			if b.Weight, err = strconv.Atoi(weight); err != nil || b.Weight <= 0 {
+				return nil, fmt.Errorf("%w: invalid weight %q", ErrInvalidArgument, weight)
+			}
+		}
+		sizes = append(sizes, b)
+	}
+	return sizes, nil
+}
+
+func validateBench(b *BenchOptions) error {
+	if b.Objects <= 0 {
+		return fmt.Errorf("%w: a benchmark needs at least one object", ErrInvalidArgument)
+	}
+	if len(b.Sizes) == 0 {
+		return fmt.Errorf("%w: a benchmark needs a size distribution", ErrInvalidArgument)
+	}
+	for _, s := range b.Sizes {
+		if s.Size <= 0 || s.Size > partSizeMax || s.Weight <= 0 {
+			return fmt.Errorf("%w: benchmark objects are 1 byte to 5GiB with a positive weight, got %d bytes with weight %d", ErrInvalidArgument, s.Size, s.Weight)
+		}
+	}
+	if b.Seed == 0 {
+		b.Seed = 1
+	}
+	return nil
+}
+
+// benchSizes draws the sizes of the objects of b.
+func benchSizes(b BenchOptions) []int64 {
+	total := 0
+	for _, s := range b.Sizes {
+		total += s.Weight
+	}
+	r := rand.New(rand.NewSource(b.Seed))
+	sizes := make([]int64, b.Objects)
+	for i := range sizes {
+		n := r.Intn(total)
+		for _, s := range b.Sizes {
+			if n < s.Weight {
+				sizes[i] = s.Size
+				break
+			}
+			n -= s.Weight
+		}
+	}
+	return sizes
+}
+
+// BenchReport is the result of Bench. Durations are in seconds, latencies in
+// milliseconds.
+type BenchReport struct {
+	RunID            string                    `json:"run_id"`
+	Archive          string                    `json:"archive"`
+	Objects          int                       `json:"objects"`
+	Bytes            int64                     `json:"bytes"`
+	ArchiveSize      int64                     `json:"archive_size"`
+	GenerateSeconds  float64                   `json:"generate_seconds"`
+	DurationSeconds  float64                   `json:"duration_seconds"`
+	MiBPerSecond     float64                   `json:"mib_per_second"`
+	ObjectsPerSecond float64                   `json:"objects_per_second"`
+	Retries          int64                     `json:"retries"`
+	PeakHeapBytes    uint64                    `json:"peak_heap_bytes"`
+	Requests         map[string]*LatencyReport `json:"requests"`
+}
+
+// LatencyReport is the latency of the requests of a method, from the start of
+// the request to the headers of the response.
+type LatencyReport struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// Bench generates the objects of b under <archive>.bench/<run id>/, next to
+// the archive of options or under its scratch location, archives them with
+// options and optFns, and reports the throughput, the latency of the requests
+// and the memory of the run. The objects and the archive are deleted at the
+// end unless KeepScratch is set, and the archive must be a new key.
+func Bench(ctx context.Context, svc *s3.Client, b BenchOptions, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) (*BenchReport, error) {
+	if err := validateBench(&b); err != nil {
+		return nil, err
+	}
+	opts := options.Copy()
+	if opts.DstBucket == "" || opts.DstKey == "" {
+		return nil, fmt.Errorf("%w: destination bucket and key required", ErrInvalidArgument)
+	}
+	if opts.RunID == "" {
+		opts.RunID = NewRunID()
+	}
+	if opts.Threads <= 0 {
+		opts.Threads = defaultThreads
+	}
+	if err := checkBenchDestination(ctx, svc, &opts); err != nil {
+		return nil, err
+	}
+	bucket, prefix := benchLocation(&opts)
+	opts.SrcBucket, opts.SrcPrefix, opts.SrcManifest = bucket, prefix, ""
+	ctx = WithRunID(ctx, opts.RunID)
+	report := &BenchReport{RunID: opts.RunID, Archive: fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey), Objects: b.Objects}
+
+	Infof(ctx, "bench: writing %d objects to s3://%s/%s/", b.Objects, bucket, prefix)
+	start := time.Now()
+	objectList, err := putBenchObjects(ctx, svc, bucket, prefix, benchSizes(b), opts.Threads)
+	// the archive is only deleted once this run wrote it
+	archived := false
+	if !opts.KeepScratch {
+		defer func() { deleteBench(ctx, svc, &opts, bucket, prefix, archived) }()
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.GenerateSeconds = time.Since(start).Seconds()
+	for _, o := range objectList {
+		report.Bytes += *o.Size
+	}
+
+	// the requests of the archive are timed, not those of the objects
+	latency := &latencyRecorder{samples: map[string][]time.Duration{}}
+	timed := s3.New(svc.Options(), func(o *s3.Options) {
+		latency.next = o.HTTPClient
+		o.HTTPClient = latency
+	})
+	var summary *RunSummary
+	optFns = append(optFns, WithSourceClient(timed), WithSummary(func(s *RunSummary) { summary = s }))
+
+	sampler := sampleMemory()
+	start = time.Now()
+	err = NewArchiveClient(timed).CreateFromList(ctx, objectList, &opts, optFns...)
+	elapsed := time.Since(start)
+	report.PeakHeapBytes = sampler.stop()
+	if err != nil {
+		return nil, err
+	}
+	archived = true
+	report.DurationSeconds = elapsed.Seconds()
+	report.MiBPerSecond = float64(report.Bytes) / (1 << 20) / elapsed.Seconds()
+	report.ObjectsPerSecond = float64(report.Objects) / elapsed.Seconds()
+	if summary != nil {
+		report.ArchiveSize, report.Retries = summary.Size, summary.Retries
+	}
+	report.Requests = latency.report()
+	return report, nil
+}
+
+// benchLocation returns the bucket and the prefix of the objects of a
+// benchmark.
+func benchLocation(opts *S3TarS3Options) (string, string) {
+	bucket, prefix := opts.DstBucket, opts.DstKey+".bench"
+	if opts.Scratch != "" {
+		bucket, prefix = ExtractBucketAndPath(opts.Scratch)
+		prefix = filepath.Join(prefix, opts.DstKey+".bench")
+	}
+	return bucket, filepath.Join(prefix, opts.RunID)
+}
+
+func putBenchObjects(ctx context.Context, svc *s3.Client, bucket, prefix string, sizes []int64, threads int) ([]*S3Obj, error) {
+	objectList := make([]*S3Obj, len(sizes))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for i, size := range sizes {
+		i, size := i, size
+		key := fmt.Sprintf("%s/%08d", prefix, i)
+		g.Go(func() error {
+			output, err := svc.PutObject(gctx, &s3.PutObjectInput{
+				Bucket:        &bucket,
+				Key:           &key,
+				Body:          &patternReader{size: size, seed: byte(i)},
+				ContentLength: aws.Int64(size),
+			})
+			if err != nil {
+				return &ObjectError{Bucket: bucket, Key: key, Err: classifyError(err)}
+			}
+			now := time.Now()
+			objectList[i] = &S3Obj{Bucket: bucket, Object: types.Object{Key: aws.String(key), Size: aws.Int64(size), ETag: output.ETag, LastModified: &now}}
+			return nil
+		})
+	}
+	return objectList, g.Wait()
+}
+
+// benchArchiveKeys are the keys a benchmark writes its archive to.
+func benchArchiveKeys(opts *S3TarS3Options) []string {
+	return []string{opts.DstKey, opts.DstKey + ".toc.csv"}
+}
+
+// checkBenchDestination fails with ErrDestinationExists when the archive of a
+// benchmark would replace an object, as it's deleted at the end of the run.
+func checkBenchDestination(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	for _, key := range benchArchiveKeys(opts) {
+		_, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &opts.DstBucket, Key: aws.String(key)})
+		if err == nil {
+			return &ObjectError{Bucket: opts.DstBucket, Key: key, Err: fmt.Errorf("%w: bench needs a new archive key", ErrDestinationExists)}
+		}
+		if err = classifyError(err); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBench deletes the objects of a benchmark, and its archive when
+// archived is set.
+func deleteBench(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, bucket, prefix string, archived bool) {
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, cleanUpTimeout)
+	defer cancel()
+	Infof(ctx, "bench: deleting s3://%s/%s/", bucket, prefix)
+	objectList, _, err := ListAllObjects(ctx, svc, bucket, prefix+"/")
+	if err == nil && len(objectList) > 0 {
+		err = deleteObjectList(ctx, svc, opts, objectList)
+	}
+	if err != nil {
+		Warnf(ctx, "unable to delete the objects at s3://%s/%s/: %s", bucket, prefix, err.Error())
+	}
+	if !archived {
+		return
+	}
+	Infof(ctx, "bench: deleting the archive s3://%s/%s", opts.DstBucket, opts.DstKey)
+	for _, key := range benchArchiveKeys(opts) {
+		if _, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &opts.DstBucket, Key: aws.String(key)}); err != nil {
+			Warnf(ctx, "unable to delete s3://%s/%s: %s", opts.DstBucket, key, err.Error())
+		}
+	}
+}
+
+// patternReader is the content of a benchmark object, size bytes of a pattern
+// that depends on seed, generated as it's read.
+type patternReader struct {
+	size int64
+	off  int64
+	seed byte
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.off {
+		p = p[:r.size-r.off]
+	}
+	for i := range p {
+		p[i] = byte(r.off+int64(i)) ^ r.seed
+	}
+	r.off += int64(len(p))
+	return len(p), nil
+}
+
+// Seek lets the SDK rewind the body to sign it and to retry the request.
+func (r *patternReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// latencyRecorder is the HTTP client of the requests timed by Bench.
+type latencyRecorder struct {
+	next    s3.HTTPClient
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+}
+
+func (l *latencyRecorder) Do(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := l.next.Do(r)
+	l.mu.Lock()
+	l.samples[r.Method] = append(l.samples[r.Method], time.Since(start))
+	l.mu.Unlock()
+	return resp, err
+}
+
+func (l *latencyRecorder) report() map[string]*LatencyReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reports := map[string]*LatencyReport{}
+	for method, samples := range l.samples {
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		ms := func(q float64) float64 {
+			return float64(samples[int(q*float64(len(samples)-1))]) / float64(time.Millisecond)
+		}
+		reports[method] = &LatencyReport{Count: len(samples), P50: ms(0.5), P90: ms(0.9), P99: ms(0.99), Max: ms(1)}
+	}
+	return reports
+}
+
+// memorySampler records the peak heap in use while a benchmark runs.
+type memorySampler struct {
+	done chan struct{}
+	peak chan uint64
+}
+
+func sampleMemory() *memorySampler {
+	s := &memorySampler{done: make(chan struct{}), peak: make(chan uint64)}
+	go func() {
+		var peak uint64
+		var m runtime.MemStats
+		ticker := time.NewTicker(benchMemoryInterval)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > peak {
+				peak = m.HeapInuse
+			}
+			select {
+			case <-ticker.C:
+			case <-s.done:
+				s.peak <- peak
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// stop returns the peak heap in use since the sampler started.
+func (s *memorySampler) stop() uint64 {
+	close(s.done)
+	return <-s.peak
+}
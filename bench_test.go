@@ -0,0 +1,121 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBenchSizes(t *testing.T) {
+	sizes, err := ParseBenchSizes("4KiB:80, 1MiB:15,512,1MB:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BenchSize{{Size: 4096, Weight: 80}, {Size: 1 << 20, Weight: 15}, {Size: 512, Weight: 1}, {Size: 1000000, Weight: 2}}
+	if len(sizes) != len(want) {
+		t.Fatalf("ParseBenchSizes() = %v, want %v", sizes, want)
+	}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Errorf("ParseBenchSizes()[%d] = %v, want %v", i, sizes[i], want[i])
+		}
+	}
+	for _, s := range []string{"", "1XB", "0", "-1", "1MiB:0", "1MiB:x"} {
+		if _, err := ParseBenchSizes(s); err == nil {
+			t.Errorf("ParseBenchSizes(%q) error = nil", s)
+		}
+	}
+	if err := validateBench(&BenchOptions{Objects: 1, Sizes: []BenchSize{{Size: 6 << 30, Weight: 1}}}); err == nil {
+		t.Errorf("validateBench() of a 6GiB object error = nil")
+	}
+}
+
+func TestBenchSizes(t *testing.T) {
+	b := BenchOptions{Objects: 1000, Sizes: []BenchSize{{Size: 1, Weight: 9}, {Size: 2, Weight: 1}}, Seed: 1}
+	sizes := benchSizes(b)
+	small := 0
+	for i, size := range sizes {
+		if size == 1 {
+			small++
+		} else if size != 2 {
+			t.Fatalf("sizes[%d] = %d, want 1 or 2", i, size)
+		}
+	}
+	if small < 850 || small > 950 {
+		t.Errorf("%d of 1000 sizes drawn with weight 9 of 10", small)
+	}
+	again := benchSizes(b)
+	for i := range sizes {
+		if sizes[i] != again[i] {
+			t.Fatalf("benchSizes() isn't reproducible with the same seed")
+		}
+	}
+}
+
+func TestPatternReader(t *testing.T) {
+	r := &patternReader{size: 1000, seed: 7}
+	data, err := io.ReadAll(r)
+	if err != nil || len(data) != 1000 {
+		t.Fatalf("ReadAll() = %d bytes, %v", len(data), err)
+	}
+	if n, err := r.Seek(0, io.SeekStart); n != 0 || err != nil {
+		t.Fatalf("Seek() = %d, %v", n, err)
+	}
+	again, _ := io.ReadAll(r)
+	if string(again) != string(data) {
+		t.Errorf("the body read again after Seek differs")
+	}
+	if n, _ := r.Seek(0, io.SeekEnd); n != 1000 {
+		t.Errorf("Seek(0, io.SeekEnd) = %d, want 1000", n)
+	}
+}
+
+func TestLatencyReport(t *testing.T) {
+	l := &latencyRecorder{samples: map[string][]time.Duration{}}
+	for i := 100; i > 0; i-- {
+		l.samples["GET"] = append(l.samples["GET"], time.Duration(i)*time.Millisecond)
+	}
+	get := l.report()["GET"]
+	if get.Count != 100 || get.P50 != 50 || get.P99 != 99 || get.Max != 100 {
+		t.Errorf("report() = %+v", get)
+	}
+}
+
+func TestBenchDestination(t *testing.T) {
+	tests := map[string]struct {
+		existing string
+		wantErr  error
+	}{
+		"new archive":      {},
+		"existing archive": {existing: "dst/a.tar", wantErr: ErrDestinationExists},
+		"existing toc":     {existing: "dst/a.tar.toc.csv", wantErr: ErrDestinationExists},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := SetupLogger(context.Background())
+			store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+			if tt.existing != "" {
+				store.objects[tt.existing] = []byte("keep")
+			}
+			b := BenchOptions{Objects: 3, Sizes: []BenchSize{{Size: 1024, Weight: 1}}}
+			opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 2}
+			if _, err := Bench(ctx, store.client(), b, opts); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Bench() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.existing != "" && string(store.objects[tt.existing]) != "keep" {
+				t.Errorf("%s was replaced or deleted", tt.existing)
+			}
+			for key := range store.objects {
+				if key != tt.existing && strings.HasPrefix(key, "dst/a.tar") && !strings.Contains(key, ".bench/") {
+					t.Errorf("%s is left after the run", key)
+				}
+			}
+		})
+	}
+}
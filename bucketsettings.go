@@ -0,0 +1,103 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// checkDestinationBucket reads the settings of the destination bucket that
+// change how the archive is written before its multipart upload is created,
+// and adjusts the run to them or fails with what to change:
+//
+//   - with ACLs disabled by the BucketOwnerEnforced Object Ownership, the
+//     archive is written without the canned ACL bucket-owner-full-control;
+//   - in a Requester Pays bucket the requests of the run say the requester
+//     pays, as the bucket requires from other accounts;
+//   - with Object Lock, whose writes need a checksum, a provider without
+//     additional checksums is rejected, and a default retention that would
+//     keep the intermediate objects of the run is warned about.
+//
+// The default encryption of the bucket, used when the run sets none, is
+// logged. A setting that can't be read, without the permission to or on a
+// service without it, is left as it is. It returns the client the archive is
+// written with.
+func checkDestinationBucket(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) (*s3.Client, error) {
+	if opts.DstPath != "" {
+		return svc, nil
+	}
+	bucket := opts.DstBucket
+
+	ownership, err := svc.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{Bucket: &bucket})
+	if err != nil {
+		Debugf(ctx, "unable to read the Object Ownership of s3://%s: %s", bucket, err.Error())
+	} else if ownership.OwnershipControls != nil && len(ownership.OwnershipControls.Rules) > 0 &&
+		ownership.OwnershipControls.Rules[0].ObjectOwnership == types.ObjectOwnershipBucketOwnerEnforced {
+		Infof(ctx, "s3://%s has ACLs disabled, writing the archive without an ACL", bucket)
+		opts.provider.NoACLs = true
+	}
+
+	if opts.KMSKeyID == "" {
+		encryption, err := svc.GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{Bucket: &bucket})
+		if err != nil {
+			Debugf(ctx, "unable to read the default encryption of s3://%s: %s", bucket, err.Error())
+		} else if sse := defaultEncryption(encryption); sse != nil && sse.SSEAlgorithm != types.ServerSideEncryptionAes256 {
+			key := aws.ToString(sse.KMSMasterKeyID)
+			if key == "" {
+				key = "aws/s3"
+			}
+			Infof(ctx, "the archive gets the default encryption of s3://%s, %s with the key %s, the run needs kms:GenerateDataKey and kms:Decrypt on it", bucket, sse.SSEAlgorithm, key)
+		}
+	}
+
+	payment, err := svc.GetBucketRequestPayment(ctx, &s3.GetBucketRequestPaymentInput{Bucket: &bucket})
+	if err != nil {
+		Debugf(ctx, "unable to read the request payment of s3://%s: %s", bucket, err.Error())
+	} else if payment.Payer == types.PayerRequester {
+		Infof(ctx, "s3://%s is a Requester Pays bucket, the requests of the run are charged to the requester", bucket)
+		svc = s3.New(svc.Options(), func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("x-amz-request-payer", string(types.RequestPayerRequester)))
+		})
+	}
+
+	lock, err := svc.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{Bucket: &bucket})
+	if err != nil {
+		Debugf(ctx, "unable to read the Object Lock configuration of s3://%s: %s", bucket, err.Error())
+		return svc, nil
+	}
+	if lock.ObjectLockConfiguration == nil || lock.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return svc, nil
+	}
+	if opts.provider.NoChecksums {
+		return nil, fmt.Errorf("%w: s3://%s has Object Lock, whose writes need a checksum, and the provider %s writes without, use a bucket without Object Lock", ErrInvalidArgument, bucket, opts.provider.Name)
+	}
+	if rule := lock.ObjectLockConfiguration.Rule; rule != nil && rule.DefaultRetention != nil {
+		scratch, _ := scratchLocation(opts)
+		if scratch == bucket && !opts.ConcatInMemory && !opts.KeepScratch {
+			Warnf(ctx, "s3://%s has a default retention (%s), the intermediate objects of the run are kept as noncurrent versions until it expires, write them to another bucket with --scratch or build the archive with --concat-in-memory",
+				bucket, describeRetention(rule.DefaultRetention))
+		}
+	}
+	return svc, nil
+}
+
+// defaultEncryption returns the default encryption of a bucket, nil when it
+// has none.
+func defaultEncryption(out *s3.GetBucketEncryptionOutput) *types.ServerSideEncryptionByDefault {
+	if out.ServerSideEncryptionConfiguration == nil {
+		return nil
+	}
+	for _, rule := range out.ServerSideEncryptionConfiguration.Rules {
+		if rule.ApplyServerSideEncryptionByDefault != nil {
+			return rule.ApplyServerSideEncryptionByDefault
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestCheckDestinationBucket(t *testing.T) {
+	const denied = `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`
+	tests := map[string]struct {
+		responses     map[string]probeResponse
+		provider      Provider
+		wantNoACLs    bool
+		wantRequester bool
+		wantErr       error
+	}{
+		"defaults": {},
+		"acls disabled": {
+			responses:  map[string]probeResponse{"GetBucketOwnershipControls": {200, `<OwnershipControls><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>`}},
+			wantNoACLs: true,
+		},
+		"object writer": {
+			responses: map[string]probeResponse{"GetBucketOwnershipControls": {200, `<OwnershipControls><Rule><ObjectOwnership>ObjectWriter</ObjectOwnership></Rule></OwnershipControls>`}},
+		},
+		"requester pays": {
+			responses:     map[string]probeResponse{"GetBucketRequestPayment": {200, `<RequestPaymentConfiguration><Payer>Requester</Payer></RequestPaymentConfiguration>`}},
+			wantRequester: true,
+		},
+		"object lock without checksums": {
+			responses: map[string]probeResponse{"GetObjectLockConfiguration": {200, `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>`}},
+			provider:  Provider{Name: "ceph", NoChecksums: true},
+			wantErr:   ErrInvalidArgument,
+		},
+		"object lock": {
+			responses: map[string]probeResponse{"GetObjectLockConfiguration": {200, `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>1</Days></DefaultRetention></Rule></ObjectLockConfiguration>`}},
+		},
+		"denied": {
+			responses: map[string]probeResponse{
+				"GetBucketOwnershipControls": {403, denied},
+				"GetBucketEncryption":        {403, denied},
+				"GetBucketRequestPayment":    {403, denied},
+				"GetObjectLockConfiguration": {403, denied},
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requester string
+			do := doFunc(func(req *http.Request) (*http.Response, error) {
+				op := fakeOperation(req)
+				status, body := 200, ""
+				switch op {
+				case "GetBucketOwnershipControls":
+					status, body = 404, `<Error><Code>OwnershipControlsNotFoundError</Code></Error>`
+				case "GetBucketEncryption":
+					body = `<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`
+				case "GetBucketRequestPayment":
+					body = `<RequestPaymentConfiguration><Payer>BucketOwner</Payer></RequestPaymentConfiguration>`
+				case "GetObjectLockConfiguration":
+					status, body = 404, `<Error><Code>ObjectLockConfigurationNotFoundError</Code></Error>`
+				case "HeadBucket":
+					requester = req.Header.Get("X-Amz-Request-Payer")
+				}
+				if r, ok := tt.responses[op]; ok {
+					status, body = r.status, r.body
+				}
+				return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body)), ContentLength: int64(len(body)), Request: req}, nil
+			})
+			client := s3.New(s3.Options{
+				Region:       "us-west-2",
+				BaseEndpoint: aws.String("http://s3.local"),
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+				HTTPClient:   do,
+			})
+			opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", RunID: "run", provider: tt.provider}
+			svc, err := checkDestinationBucket(context.Background(), client, opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("checkDestinationBucket() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.provider.NoACLs != tt.wantNoACLs {
+				t.Errorf("NoACLs = %v, want %v", opts.provider.NoACLs, tt.wantNoACLs)
+			}
+			if _, err := svc.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String("dst")}); err != nil {
+				t.Fatal(err)
+			}
+			if (requester == "requester") != tt.wantRequester {
+				t.Errorf("x-amz-request-payer = %q, want requester %v", requester, tt.wantRequester)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// budgetChunk is the most bytes read at once from a body whose bandwidth is
+// limited, so the readers sharing a rate take turns.
+const budgetChunk = 64 << 10
+
+// BudgetOptions are the limits of a Budget. Zero values are unlimited.
+type BudgetOptions struct {
+	// Downloads is the most GET requests at once, a request being counted
+	// until its body is read or closed.
+	Downloads int
+	// Uploads is the most requests with a body at once, PutObject and
+	// UploadPart. The copies made server-side aren't counted.
+	Uploads int
+	// DownloadRate and UploadRate are the bytes per second read from the
+	// responses and sent with the requests.
+	DownloadRate int64
+	UploadRate   int64
+}
+
+// Budget limits the transfers of every client it's applied to together, so
+// archives created at the same time, e.g. the jobs of a job file, share one
+// concurrency and bandwidth budget instead of each having its own.
+type Budget struct {
+	downloads    chan struct{}
+	uploads      chan struct{}
+	downloadRate *rateLimiter
+	uploadRate   *rateLimiter
+}
+
+// NewBudget returns the Budget of o.
+func NewBudget(o BudgetOptions) *Budget {
+	b := &Budget{}
+	if o.Downloads > 0 {
+		b.downloads = make(chan struct{}, o.Downloads)
+	}
+	if o.Uploads > 0 {
+		b.uploads = make(chan struct{}, o.Uploads)
+	}
+	if o.DownloadRate > 0 {
+		b.downloadRate = &rateLimiter{rate: float64(o.DownloadRate)}
+	}
+	if o.UploadRate > 0 {
+		b.uploadRate = &rateLimiter{rate: float64(o.UploadRate)}
+	}
+	return b
+}
+
+// ClientOptions applies the budget to an Amazon S3 client, e.g.
+// s3.NewFromConfig(cfg, budget.ClientOptions).
+func (b *Budget) ClientOptions(o *s3.Options) {
+	o.HTTPClient = &budgetClient{next: o.HTTPClient, budget: b}
+}
+
+// budgetClient is the HTTP client of the requests limited by a Budget.
+type budgetClient struct {
+	next   s3.HTTPClient
+	budget *Budget
+}
+
+func (c *budgetClient) Do(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	if r.Method == http.MethodGet {
+		release, err := acquire(ctx, c.budget.downloads)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.next.Do(r)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		resp.Body = &budgetBody{ReadCloser: resp.Body, ctx: ctx, rate: c.budget.downloadRate, release: release}
+		return resp, nil
+	}
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return c.next.Do(r)
+	}
+	release, err := acquire(ctx, c.budget.uploads)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if rate := c.budget.uploadRate; rate != nil {
+		r = r.Clone(ctx)
+		r.Body = &budgetBody{ReadCloser: r.Body, ctx: ctx, rate: rate, release: func() {}}
+		if getBody := r.GetBody; getBody != nil {
+			r.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return &budgetBody{ReadCloser: body, ctx: ctx, rate: rate, release: func() {}}, nil
+			}
+		}
+	}
+	return c.next.Do(r)
+}
+
+// acquire takes one of slots, nil when they're unlimited, and returns the
+// func that gives it back.
+func acquire(ctx context.Context, slots chan struct{}) (func(), error) {
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-slots }) }, nil
+}
+
+// budgetBody reads a body at the rate of its budget and releases its slot once
+// it's read or closed.
+type budgetBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	rate    *rateLimiter
+	release func()
+}
+
+func (b *budgetBody) Read(p []byte) (int, error) {
+	if b.rate != nil && len(p) > budgetChunk {
+		p = p[:budgetChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.rate != nil && n > 0 {
+		if werr := b.rate.wait(b.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *budgetBody) Close() error {
+	b.release()
+	return b.ReadCloser.Close()
+}
+
+// rateLimiter spreads the bytes of every caller over time at rate bytes per
+// second.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	// next is when the bytes transferred so far are paid for
+	next time.Time
+}
+
+// wait blocks until the n bytes just transferred are paid for.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
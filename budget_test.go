@@ -0,0 +1,110 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTransfers answers every request with a body of size bytes and records
+// the most transfers in progress at once, a GET until its body is read.
+type fakeTransfers struct {
+	size     int
+	inFlight int32
+	peak     int32
+}
+
+func (f *fakeTransfers) Do(r *http.Request) (*http.Response, error) {
+	n := atomic.AddInt32(&f.inFlight, 1)
+	for {
+		peak := atomic.LoadInt32(&f.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&f.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	if r.Method != http.MethodGet {
+		io.Copy(io.Discard, r.Body)
+		atomic.AddInt32(&f.inFlight, -1)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: &closeFunc{Reader: bytes.NewReader(make([]byte, f.size)), close: func() { atomic.AddInt32(&f.inFlight, -1) }}}, nil
+}
+
+// closeFunc calls close once it's read or closed.
+type closeFunc struct {
+	io.Reader
+	once  sync.Once
+	close func()
+}
+
+func (c *closeFunc) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	if err != nil {
+		c.once.Do(c.close)
+	}
+	return n, err
+}
+
+func (c *closeFunc) Close() error {
+	c.once.Do(c.close)
+	return nil
+}
+
+func TestBudget(t *testing.T) {
+	tests := map[string]struct {
+		opts     BudgetOptions
+		method   string
+		size     int
+		wantPeak int32
+		minTime  time.Duration
+	}{
+		"downloads":     {opts: BudgetOptions{Downloads: 3}, method: http.MethodGet, size: 10, wantPeak: 3},
+		"uploads":       {opts: BudgetOptions{Uploads: 2}, method: http.MethodPut, size: 10, wantPeak: 2},
+		"download rate": {opts: BudgetOptions{DownloadRate: 4 << 20}, method: http.MethodGet, size: 100 << 10, minTime: 400 * time.Millisecond},
+		"upload rate":   {opts: BudgetOptions{UploadRate: 4 << 20}, method: http.MethodPut, size: 100 << 10, minTime: 400 * time.Millisecond},
+		"unlimited":     {method: http.MethodGet, size: 10},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			next := &fakeTransfers{size: tt.size}
+			client := &budgetClient{next: next, budget: NewBudget(tt.opts)}
+			start := time.Now()
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					req, _ := http.NewRequestWithContext(context.Background(), tt.method, "https://bucket.s3.amazonaws.com/key", nil)
+					if tt.method != http.MethodGet {
+						req.Body, req.ContentLength = io.NopCloser(bytes.NewReader(make([]byte, tt.size))), int64(tt.size)
+					}
+					resp, err := client.Do(req)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					// the slot of a download is released once its body is read
+					io.Copy(io.Discard, resp.Body)
+					time.Sleep(time.Millisecond)
+					resp.Body.Close()
+				}()
+			}
+			wg.Wait()
+			if tt.wantPeak > 0 && next.peak > tt.wantPeak {
+				t.Errorf("%d transfers at once, want at most %d", next.peak, tt.wantPeak)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("2000KiB transferred in %s, want at least %s", elapsed, tt.minTime)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// The user metadata of the archives of a chain: the position of the archive,
+// as 2/3, and the url of the manifest of the chain.
+const (
+	chainVolumeKey   = "s3tar-chain-volume"
+	chainManifestKey = "s3tar-chain-manifest"
+)
+
+// ChainManifest lists the archives of a chain, written next to them to
+// <archive>.chain.json when the objects don't fit in one archive.
+type ChainManifest struct {
+	// Archive is the url of the archive that was requested.
+	Archive string `json:"archive"`
+	// Volumes are the archives of the chain, in the order of the members.
+	Volumes []ChainVolume `json:"volumes"`
+}
+
+// ChainVolume is an archive of a chain, a complete archive with its own TOC.
+type ChainVolume struct {
+	Archive string `json:"archive"`
+	Members int    `json:"members"`
+	Size    int64  `json:"size"`
+	// First and Last are the keys of the first and the last object of the
+	// archive.
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// chainLink is the position of an archive in its chain, recorded in its
+// metadata.
+type chainLink struct {
+	volume   string
+	manifest string
+}
+
+// fitsOneArchive reports whether the archive of objectList, of totalSize
+// bytes of data, is under the limits of the provider: the size of an object
+// and, built in memory, the number of parts of a multipart upload. The size
+// counts the headers, the padding and an estimate of the TOC.
+func fitsOneArchive(objectList []*S3Obj, totalSize int64, opts *S3TarS3Options) bool {
+	if totalSize > opts.provider.maxObjectSize() {
+		return false
+	}
+	size := estimateFinalSize(objectList)
+	for _, o := range objectList {
+		// a line of the toc.csv, with the offset, size and ETag
+		size += int64(len(*o.Key)) + 80
+	}
+	if size > opts.provider.maxObjectSize() {
+		return false
+	}
+	if useInMemory(opts, totalSize) && totalSize >= mpuThreshold(opts) {
+		// the largest parts of buildInMemoryConcat must be enough, see
+		// findMinimumPartSize
+		return totalSize/opts.provider.maxPartSize() < int64(opts.provider.maxParts())
+	}
+	return true
+}
+
+// chainVolumes splits objectList in order into the objects of the archives of
+// a chain, each as large as the limits of one archive allow.
+func chainVolumes(objectList []*S3Obj, opts *S3TarS3Options) [][]*S3Obj {
+	var volumes [][]*S3Obj
+	for len(objectList) > 0 {
+		n := sort.Search(len(objectList), func(i int) bool {
+			size, _ := archiveSizes(objectList[:i+1], opts)
+			return !fitsOneArchive(objectList[:i+1], size, opts)
+		})
+		if n == 0 {
+			// an object too large for any archive fails when it's archived
+			n = 1
+		}
+		volumes = append(volumes, objectList[:n])
+		objectList = objectList[n:]
+	}
+	return volumes
+}
+
+// chainKey is the key of the archive number n of the chain of key, with the
+// number before the extension: archive.0002.tar.
+func chainKey(key string, n int) string {
+	ext := path.Ext(key)
+	if strings.Contains(ext, "/") {
+		ext = ""
+	}
+	return fmt.Sprintf("%s.%04d%s", strings.TrimSuffix(key, ext), n, ext)
+}
+
+// createChain archives objectList, which doesn't fit in one archive, into a
+// chain of archives named after the destination with chainKey, and writes
+// their ChainManifest to <destination>.chain.json. Every archive is complete,
+// with its own TOC, and records its position in the chain and the url of the
+// manifest in its metadata.
+func createChain(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options, start time.Time, retries int64) error {
+	volumes := chainVolumes(objectList, opts)
+	location := fmt.Sprintf("s3://%s/%s.chain.json", opts.DstBucket, opts.DstKey)
+	Warnf(ctx, "the objects don't fit in one archive, writing a chain of %d archives listed in %s", len(volumes), location)
+
+	manifest := &ChainManifest{Archive: archiveURL(opts)}
+	for i, volume := range volumes {
+		volumeOpts := opts.Copy()
+		volumeOpts.DstKey = chainKey(opts.DstKey, i+1)
+		volumeOpts.chain = &chainLink{volume: fmt.Sprintf("%d/%d", i+1, len(volumes)), manifest: location}
+		Infof(ctx, "chain: archive %d of %d, %d objects, to %s", i+1, len(volumes), len(volume), archiveURL(&volumeOpts))
+		skippedBefore := len(skippedObjects(ctx))
+		volumeStart := time.Now()
+		// the parts of every archive are reported with its own summary
+		volumeCtx := withPartTracker(ctx)
+		archive, err := buildArchive(volumeCtx, svc, volume, &volumeOpts)
+		if !opts.ConcatInMemory {
+			cleanUp(ctx, svc, &volumeOpts)
+		}
+		if err != nil {
+			return fmt.Errorf("archive %d of %d of the chain: %w", i+1, len(volumes), err)
+		}
+		if opts.Provenance {
+			if err := writeProvenance(ctx, svc, &volumeOpts, volumeStart); err != nil {
+				return err
+			}
+		}
+		skipped := skippedObjects(ctx)[skippedBefore:]
+		manifest.Volumes = append(manifest.Volumes, ChainVolume{
+			Archive: archiveURL(&volumeOpts),
+			Members: len(volume) - len(skipped),
+			Size:    aws.ToInt64(archive.Size),
+			First:   *volume[0].Key,
+			Last:    *volume[len(volume)-1].Key,
+		})
+		if opts.SummaryFn != nil {
+			summary := newRunSummary(ctx, svc, archive, len(volume)-len(skipped), time.Since(volumeStart), clientRetries(svc)-retries)
+			summary.Parts = partReports(volumeCtx)
+			if i == 0 {
+				// the deleted keys of the listing aren't in any archive, they
+				// are counted once
+				summary.IgnoredDeleteMarkers = opts.ignoredDeleteMarkers
+			}
+			for _, o := range skipped {
+				summary.Skipped = append(summary.Skipped, objectURL(o.Bucket, o.Key))
+			}
+			if len(skipped) > 0 {
+				summary.ErrorManifest = errorManifestLocation(opts)
+			}
+			opts.SummaryFn(summary)
+		}
+		retries = clientRetries(svc)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := saveFile(ctx, svc, location, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing the manifest of the chain %s: %w", location, err)
+	}
+	Infof(ctx, "chain of %d archives written in %s, listed in %s", len(volumes), time.Since(start), location)
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestChainKey(t *testing.T) {
+	tests := map[string]struct {
+		key  string
+		want string
+	}{
+		"extension":    {key: "prefix/archive.tar", want: "prefix/archive.0002.tar"},
+		"no extension": {key: "prefix/archive", want: "prefix/archive.0002"},
+		"dotted dir":   {key: "v1.2/archive", want: "v1.2/archive.0002"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := chainKey(tt.key, 2); got != tt.want {
+				t.Errorf("chainKey(%q, 2) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainVolumes(t *testing.T) {
+	objects := func(n int, size int64) []*S3Obj {
+		var list []*S3Obj
+		for i := 0; i < n; i++ {
+			list = append(list, NewS3ObjOptions(WithBucketAndKey("b", fmt.Sprintf("k%03d", i)), WithSize(size)))
+		}
+		return list
+	}
+	tests := map[string]struct {
+		objectList []*S3Obj
+		opts       *S3TarS3Options
+		want       []int
+	}{
+		"fits": {
+			objectList: objects(10, 10*1024*1024+100),
+			opts:       &S3TarS3Options{provider: Provider{MaxObjectSize: 1 << 30}},
+			want:       []int{10},
+		},
+		"object size": {
+			objectList: objects(10, 10*1024*1024+100),
+			opts:       &S3TarS3Options{provider: Provider{MaxObjectSize: 35 * 1024 * 1024}},
+			want:       []int{3, 3, 3, 1},
+		},
+		"parts in memory": {
+			objectList: objects(10, 10*1024*1024+100),
+			opts:       &S3TarS3Options{ConcatInMemory: true, provider: Provider{MaxParts: 4, MaxPartSize: 25 * 1024 * 1024}},
+			want:       []int{9, 1},
+		},
+	}
+	ctx := SetupLogger(context.Background())
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			volumes := chainVolumes(tt.objectList, tt.opts)
+			var got []int
+			for _, v := range volumes {
+				got = append(got, len(v))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("chainVolumes() = %v objects per archive, want %v", got, tt.want)
+			}
+			// every archive has the offsets and the end of its own members
+			for i, volume := range volumes {
+				headers := processHeaders(ctx, volume, false)
+				tocObj, _, err := buildToc(ctx, volume)
+				if err != nil {
+					t.Fatal(err)
+				}
+				toc, err := parseTocCSV(bytes.NewReader(tocObj.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				tocSize := int64(len(tocObj.Data))
+				membersStart := tocSize + findPadding(tocSize) + paxTarHeaderSize
+				offset := membersStart
+				for j, m := range toc {
+					offset += *headers[j].Size
+					if m.Start != offset {
+						t.Errorf("archive %d: %s starts at %d in the TOC, want %d", i+1, m.Filename, m.Start, offset)
+					}
+					offset += m.Size
+				}
+				end := offset - membersStart + *headers[len(headers)-1].Size
+				if end%blockSize != 0 {
+					t.Errorf("archive %d: the members and the end of archive are %d bytes, want whole blocks", i+1, end)
+				}
+			}
+		})
+	}
+}
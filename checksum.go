@@ -0,0 +1,92 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/logging"
+	"golang.org/x/sync/errgroup"
+)
+
+// formatChecksum returns the additional checksum Amazon S3 stores for an
+// object as algorithm:value, e.g. sha256:n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg=,
+// the strongest one when there are several, or "" when it has none. The value
+// of an object uploaded in parts is a checksum of the checksums of its parts,
+// and ends with -<number of parts>.
+func formatChecksum(crc32, crc32c, sha1, sha256 *string) string {
+	for _, c := range []struct {
+		algorithm string
+		value     *string
+	}{{"sha256", sha256}, {"sha1", sha1}, {"crc32c", crc32c}, {"crc32", crc32}} {
+		if c.value != nil && *c.value != "" {
+			return c.algorithm + ":" + *c.value
+		}
+	}
+	return ""
+}
+
+// responseChecksum is the checksum of a GetObject output.
+func responseChecksum(output *s3.GetObjectOutput) string {
+	if output == nil {
+		return ""
+	}
+	return formatChecksum(output.ChecksumCRC32, output.ChecksumCRC32C, output.ChecksumSHA1, output.ChecksumSHA256)
+}
+
+// checksumValidated reports whether the SDK validates the body of output
+// against the checksum stored with the object as it's read, which it does for
+// the checksum of a whole object but not for one of the checksums of its
+// parts. The MD5 of the body doesn't have to be computed then.
+func checksumValidated(output *s3.GetObjectOutput) bool {
+	checksum := responseChecksum(output)
+	return checksum != "" && !strings.Contains(checksum, "-")
+}
+
+// withChecksumValidation asks for the checksum of the object with GetObject,
+// which the SDK validates the body against. Objects without one are read as
+// before, without the warning the SDK logs for them.
+func withChecksumValidation(input *s3.GetObjectInput) func(*s3.Options) {
+	input.ChecksumMode = types.ChecksumModeEnabled
+	return func(o *s3.Options) {
+		o.Logger = logging.Nop{}
+	}
+}
+
+// fetchChecksums sets the checksum of every object of objectList with
+// GetObjectAttributes, for the TOC of archives built server-side, whose
+// objects are never read. Objects without one keep an empty checksum.
+func fetchChecksums(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for _, o := range objectList {
+		o := o
+		if len(o.Data) > 0 || o.NoHeaderRequired || isObjectLambda(o.Bucket) {
+			continue
+		}
+		g.Go(func() error {
+			attrs, err := readClient(svc, o).GetObjectAttributes(gctx, &s3.GetObjectAttributesInput{
+				Bucket:           &o.Bucket,
+				Key:              o.Key,
+				VersionId:        versionID(o),
+				ObjectAttributes: []types.ObjectAttributes{types.ObjectAttributesChecksum, types.ObjectAttributesObjectParts},
+			})
+			if err != nil {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("reading its checksum: %w", classifyError(err))}
+			}
+			if c := attrs.Checksum; c != nil {
+				o.Checksum = formatChecksum(c.ChecksumCRC32, c.ChecksumCRC32C, c.ChecksumSHA1, c.ChecksumSHA256)
+				if o.Checksum != "" && attrs.ObjectParts != nil && attrs.ObjectParts.TotalPartsCount != nil && *attrs.ObjectParts.TotalPartsCount > 0 {
+					o.Checksum = fmt.Sprintf("%s-%d", o.Checksum, *attrs.ObjectParts.TotalPartsCount)
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
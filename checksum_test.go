@@ -0,0 +1,60 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestFormatChecksum(t *testing.T) {
+	tests := []struct {
+		name                        string
+		crc32, crc32c, sha1, sha256 *string
+		want                        string
+	}{
+		{name: "none"},
+		{name: "empty", sha256: aws.String("")},
+		{name: "crc32", crc32: aws.String("NhCmhg=="), want: "crc32:NhCmhg=="},
+		{name: "strongest", crc32c: aws.String("yZRlqg=="), sha256: aws.String("LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="), want: "sha256:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="},
+	}
+	for _, tt := range tests {
+		if got := formatChecksum(tt.crc32, tt.crc32c, tt.sha1, tt.sha256); got != tt.want {
+			t.Errorf("%s: formatChecksum() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChecksumValidated(t *testing.T) {
+	tests := map[string]struct {
+		output *s3.GetObjectOutput
+		want   bool
+	}{
+		"nil":       {},
+		"none":      {output: &s3.GetObjectOutput{}},
+		"object":    {output: &s3.GetObjectOutput{ChecksumCRC32C: aws.String("yZRlqg==")}, want: true},
+		"composite": {output: &s3.GetObjectOutput{ChecksumSHA256: aws.String("LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=-3")}},
+	}
+	for name, tt := range tests {
+		if got := checksumValidated(tt.output); got != tt.want {
+			t.Errorf("%s: checksumValidated() = %v, want %v", name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTocChecksums(t *testing.T) {
+	toc, err := parseTocCSV(strings.NewReader("a.txt,1536,5,\"\"\"abc\"\"\",sha256:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=\nb.txt,2560,5,\"\"\"def\"\"\"\n"))
+	if err != nil {
+		t.Fatalf("parseTocCSV() error = %v", err)
+	}
+	if len(toc) != 2 || toc[0].Checksum != "sha256:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=" || toc[1].Checksum != "" {
+		t.Errorf("parseTocCSV() = %+v, want the checksum of a.txt only", toc)
+	}
+	if _, err := parseTocCSV(strings.NewReader("a.txt,1536,5,\"abc\",sha256:x,s3://b/a.txt,extra\n")); err == nil {
+		t.Errorf("parseTocCSV() with 7 fields succeeded, want an error")
+	}
+}
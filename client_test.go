@@ -0,0 +1,50 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestCheckClient(t *testing.T) {
+	client := s3.New(s3.Options{Region: "us-west-2"})
+	endpointClient := s3.New(s3.Options{Region: "us-west-2", BaseEndpoint: aws.String("http://localhost:9000")})
+	tests := []struct {
+		name         string
+		client       *s3.Client
+		opts         S3TarS3Options
+		wantRegion   string
+		wantEndpoint string
+		wantErr      bool
+	}{
+		{name: "region of the client", client: client, wantRegion: "us-west-2"},
+		{name: "same region", client: client, opts: S3TarS3Options{Region: "us-west-2"}, wantRegion: "us-west-2"},
+		{name: "other region", client: client, opts: S3TarS3Options{Region: "eu-west-1"}, wantErr: true},
+		{name: "no region", client: s3.New(s3.Options{}), wantErr: true},
+		{name: "region of the options", client: s3.New(s3.Options{}), opts: S3TarS3Options{Region: "eu-west-1"}, wantRegion: "eu-west-1"},
+		{name: "endpoint of the client", client: endpointClient, wantRegion: "us-west-2", wantEndpoint: "http://localhost:9000"},
+		{name: "other endpoint", client: endpointClient, opts: S3TarS3Options{EndpointUrl: "http://localhost:9001"}, wantErr: true},
+		{name: "no client", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := checkClient(tt.client, &tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkClient() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("%s: checkClient() error = %v, want ErrInvalidArgument", tt.name, err)
+			}
+			continue
+		}
+		if tt.opts.Region != tt.wantRegion || tt.opts.EndpointUrl != tt.wantEndpoint {
+			t.Errorf("%s: checkClient() set region %q endpoint %q, want %q %q", tt.name, tt.opts.Region, tt.opts.EndpointUrl, tt.wantRegion, tt.wantEndpoint)
+		}
+	}
+}
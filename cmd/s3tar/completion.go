@@ -0,0 +1,163 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// completeCommand is the hidden first argument the shell scripts use to ask
+	// for completions: s3tar __complete <current word> <previous words...>
+	completeCommand   = "__complete"
+	completionTimeout = 3 * time.Second
+)
+
+const bashCompletion = `# s3tar bash completion, load with: source <(s3tar --completion bash)
+_s3tar_complete() {
+  local line="${COMP_LINE:0:COMP_POINT}"
+  local cur="${line##*[[:space:]]}"
+  local -a words
+  read -ra words <<< "${line%"$cur"}"
+  local IFS=$'\n'
+  COMPREPLY=($("${words[0]}" __complete "$cur" "${words[@]:1}" 2>/dev/null))
+  # bash splits words on ':', only complete what comes after the last one
+  local colon_prefix="${cur%"${cur##*:}"}"
+  if [[ -n "$colon_prefix" ]]; then
+    COMPREPLY=("${COMPREPLY[@]#"$colon_prefix"}")
+  fi
+}
+complete -o nospace -o default -F _s3tar_complete s3tar
+`
+
+const zshCompletion = `#compdef s3tar
+# s3tar zsh completion, load with: source <(s3tar --completion zsh)
+_s3tar() {
+  local -a completions
+  completions=("${(@f)$(${words[1]} __complete "${words[CURRENT]}" "${(@)words[2,CURRENT-1]}" 2>/dev/null)}")
+  compadd -S '' -- "${completions[@]}"
+}
+compdef _s3tar s3tar
+`
+
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	default:
+		return "", fmt.Errorf("completion is supported for bash and zsh")
+	}
+}
+
+// complete prints the candidates for cur, one per line. Flags are completed from
+// the app definition, s3:// urls with ListBuckets and ListObjectsV2 using the
+// --region, --profile and --endpointUrl already present in words.
+func complete(ctx context.Context, app *cli.App, cur string, words []string, w io.Writer) error {
+	if strings.HasPrefix(cur, "-") {
+		for _, flag := range app.Flags {
+			for _, name := range flag.Names() {
+				opt := "--" + name
+				if len(name) == 1 {
+					opt = "-" + name
+				}
+				if strings.HasPrefix(opt, cur) {
+					fmt.Fprintln(w, opt)
+				}
+			}
+		}
+		return nil
+	}
+	if !strings.HasPrefix(cur, "s3://") {
+		if strings.HasPrefix("s3://", cur) {
+			fmt.Fprintln(w, "s3://")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	svc := completionClient(ctx, words)
+
+	bucket, prefix := s3tar.ExtractBucketAndPath(cur)
+	if !strings.Contains(strings.TrimPrefix(cur, "s3://"), "/") {
+		output, err := svc.ListBuckets(ctx, &s3.ListBucketsInput{})
+		if err != nil {
+			return err
+		}
+		for _, b := range output.Buckets {
+			if strings.HasPrefix(*b.Name, bucket) {
+				fmt.Fprintf(w, "s3://%s/\n", *b.Name)
+			}
+		}
+		return nil
+	}
+
+	output, err := svc.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(1000),
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range output.CommonPrefixes {
+		fmt.Fprintf(w, "s3://%s/%s\n", bucket, *p.Prefix)
+	}
+	for _, o := range output.Contents {
+		fmt.Fprintf(w, "s3://%s/%s\n", bucket, *o.Key)
+	}
+	return nil
+}
+
+func completionClient(ctx context.Context, words []string) *s3.Client {
+	region := flagValue(words, "region")
+	endpointUrl := flagValue(words, "endpointUrl")
+	optFns := []func(*config.LoadOptions) error{
+		config.WithRetryMaxAttempts(1),
+	}
+	if region != "" {
+		optFns = append(optFns, config.WithRegion(region))
+	}
+	if endpointUrl != "" {
+		optFns = append(optFns, config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{
+					URL:               endpointUrl,
+					HostnameImmutable: true,
+					SigningRegion:     region,
+					Source:            aws.EndpointSourceCustom,
+				}, nil
+			})))
+	}
+	if profile := firstNonEmpty(flagValue(words, "src-profile"), flagValue(words, "profile")); profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(profile))
+	}
+	return s3Client(ctx, flagValue(words, "provider"), nil, nil, nil, optFns...)
+}
+
+// flagValue finds the value of --name value or --name=value in words.
+func flagValue(words []string, name string) string {
+	for i, word := range words {
+		if word == "--"+name && i+1 < len(words) {
+			return words[i+1]
+		}
+		if strings.HasPrefix(word, "--"+name+"=") {
+			return strings.TrimPrefix(word, "--"+name+"=")
+		}
+	}
+	return ""
+}
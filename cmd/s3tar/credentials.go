@@ -0,0 +1,83 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+)
+
+// roleOptions are the flags that assume a role for the clients, with the
+// credentials of the profile or with a web identity token.
+type roleOptions struct {
+	arn                  string
+	externalID           string
+	sessionName          string
+	sessionTags          []string
+	webIdentityTokenFile string
+}
+
+func (r *roleOptions) validate() error {
+	if r.arn == "" {
+		if r.externalID != "" || r.sessionName != "" || len(r.sessionTags) > 0 || r.webIdentityTokenFile != "" {
+			return fmt.Errorf("%w: --external-id, --role-session-name, --session-tag and --web-identity-token-file need --role-arn", s3tar.ErrInvalidArgument)
+		}
+		return nil
+	}
+	if r.webIdentityTokenFile != "" && (r.externalID != "" || len(r.sessionTags) > 0) {
+		return fmt.Errorf("%w: --external-id and --session-tag can't be used with --web-identity-token-file, the tags of a web identity are in its token", s3tar.ErrInvalidArgument)
+	}
+	_, err := parseSessionTags(r.sessionTags)
+	return err
+}
+
+// parseSessionTags parses the key=value session tags of --session-tag.
+func parseSessionTags(values []string) ([]ststypes.Tag, error) {
+	var tags []ststypes.Tag
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: session tag %q isn't key=value", s3tar.ErrInvalidArgument, v)
+		}
+		tags = append(tags, ststypes.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return tags, nil
+}
+
+// assume replaces the credentials of cfg with those of the role, obtained
+// from STS with the credentials of cfg or with the web identity token, and
+// refreshed before they expire.
+func (r *roleOptions) assume(cfg *aws.Config) {
+	if r == nil || r.arn == "" {
+		return
+	}
+	sessionName := r.sessionName
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("s3tar-%d", time.Now().UnixNano())
+	}
+	client := sts.NewFromConfig(*cfg)
+	var provider aws.CredentialsProvider
+	if r.webIdentityTokenFile != "" {
+		provider = stscreds.NewWebIdentityRoleProvider(client, r.arn, stscreds.IdentityTokenFile(r.webIdentityTokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+			o.RoleSessionName = sessionName
+		})
+	} else {
+		tags, _ := parseSessionTags(r.sessionTags)
+		provider = stscreds.NewAssumeRoleProvider(client, r.arn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			o.Tags = tags
+			if r.externalID != "" {
+				o.ExternalID = aws.String(r.externalID)
+			}
+		})
+	}
+	cfg.Credentials = aws.NewCredentialsCache(provider)
+}
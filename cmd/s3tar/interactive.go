@@ -0,0 +1,241 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+)
+
+const interactiveHelp = `commands:
+  ls                    list buckets, or prefixes and objects in the current prefix
+  cd <name>|..|/        move into a bucket or prefix, up one level, or back to the bucket list
+  stats                 count objects and bytes under the current prefix with the filters applied
+  filter min <bytes>    only include objects of at least <bytes>
+  filter max <bytes>    only include objects of at most <bytes>
+  filter match <text>   only include keys containing <text>
+  filter clear          remove all filters
+  memory                toggle --concat-in-memory
+  plan                  print the archive size and multipart plan for the current selection
+  create s3://b/k.tar   create the archive from the current selection
+  help                  print this help
+  quit                  exit
+`
+
+// interactiveSession is a line oriented browser over buckets and prefixes used
+// to pick the source of an archive before creating it.
+type interactiveSession struct {
+	ctx      context.Context
+	svc      *s3.Client
+	out      io.Writer
+	bucket   string
+	prefix   string
+	minSize  int64
+	maxSize  int64
+	match    string
+	inMemory bool
+	// create launches the archive with the options given on the command line
+	create func(objectList []*s3tar.S3Obj, dst string, inMemory bool) error
+	// estimate returns the plan for objectList with the options given on the command line
+	estimate func(objectList []*s3tar.S3Obj, inMemory bool) (*s3tar.Estimate, error)
+}
+
+func (s *interactiveSession) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(s.out, interactiveHelp)
+	for {
+		fmt.Fprintf(s.out, "%s> ", s.location())
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "exit" {
+			return nil
+		}
+		if err := s.exec(fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(s.out, "error: %s\n", err.Error())
+		}
+	}
+}
+
+func (s *interactiveSession) location() string {
+	if s.bucket == "" {
+		return "s3://"
+	}
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
+
+func (s *interactiveSession) exec(cmd string, args []string) error {
+	switch cmd {
+	case "help":
+		fmt.Fprint(s.out, interactiveHelp)
+	case "ls":
+		return s.ls()
+	case "cd":
+		if len(args) != 1 {
+			return fmt.Errorf("cd needs a bucket or prefix")
+		}
+		s.cd(args[0])
+	case "stats":
+		objectList, err := s.selection()
+		if err != nil {
+			return err
+		}
+		var size int64
+		for _, o := range objectList {
+			size += *o.Size
+		}
+		fmt.Fprintf(s.out, "%d objects, %d bytes\n", len(objectList), size)
+	case "filter":
+		return s.filter(args)
+	case "memory":
+		s.inMemory = !s.inMemory
+		fmt.Fprintf(s.out, "concat-in-memory: %t\n", s.inMemory)
+	case "plan":
+		objectList, err := s.selection()
+		if err != nil {
+			return err
+		}
+		e, err := s.estimate(objectList, s.inMemory)
+		if err != nil {
+			return err
+		}
+		printEstimate(e)
+	case "create":
+		if len(args) != 1 || !strings.HasPrefix(args[0], "s3://") {
+			return fmt.Errorf("create needs the destination s3://bucket/key.tar")
+		}
+		objectList, err := s.selection()
+		if err != nil {
+			return err
+		}
+		if err := s.create(objectList, args[0], s.inMemory); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.out, "created %s with %d objects\n", args[0], len(objectList))
+	default:
+		return fmt.Errorf("unknown command %q, type help", cmd)
+	}
+	return nil
+}
+
+func (s *interactiveSession) cd(name string) {
+	switch {
+	case name == "/":
+		s.bucket, s.prefix = "", ""
+	case name == "..":
+		if s.prefix == "" {
+			s.bucket = ""
+			return
+		}
+		parent := path.Dir(strings.TrimSuffix(s.prefix, "/"))
+		if parent == "." {
+			s.prefix = ""
+		} else {
+			s.prefix = parent + "/"
+		}
+	case strings.HasPrefix(name, "s3://"):
+		s.bucket, s.prefix = s3tar.ExtractBucketAndPath(name)
+	case s.bucket == "":
+		s.bucket = strings.TrimSuffix(name, "/")
+	default:
+		s.prefix = s.prefix + strings.TrimSuffix(name, "/") + "/"
+	}
+}
+
+func (s *interactiveSession) ls() error {
+	w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	if s.bucket == "" {
+		output, err := s.svc.ListBuckets(s.ctx, &s3.ListBucketsInput{})
+		if err != nil {
+			return err
+		}
+		for _, b := range output.Buckets {
+			fmt.Fprintf(w, "%s/\n", *b.Name)
+		}
+		return nil
+	}
+	p := s3.NewListObjectsV2Paginator(s.svc, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(s.prefix),
+		Delimiter: aws.String("/"),
+	})
+	for p.HasMorePages() {
+		output, err := p.NextPage(s.ctx)
+		if err != nil {
+			return err
+		}
+		for _, cp := range output.CommonPrefixes {
+			fmt.Fprintf(w, "%s\t\n", strings.TrimPrefix(*cp.Prefix, s.prefix))
+		}
+		for _, o := range output.Contents {
+			fmt.Fprintf(w, "%s\t%d\n", strings.TrimPrefix(*o.Key, s.prefix), *o.Size)
+		}
+	}
+	return nil
+}
+
+func (s *interactiveSession) filter(args []string) error {
+	if len(args) == 1 && args[0] == "clear" {
+		s.minSize, s.maxSize, s.match = 0, 0, ""
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("filter needs min, max, match or clear")
+	}
+	switch args[0] {
+	case "min", "max":
+		v, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		if args[0] == "min" {
+			s.minSize = v
+		} else {
+			s.maxSize = v
+		}
+	case "match":
+		s.match = args[1]
+	default:
+		return fmt.Errorf("unknown filter %q", args[0])
+	}
+	fmt.Fprintf(s.out, "filters: min=%d max=%d match=%q\n", s.minSize, s.maxSize, s.match)
+	return nil
+}
+
+// selection lists every object under the current prefix that passes the filters.
+func (s *interactiveSession) selection() ([]*s3tar.S3Obj, error) {
+	if s.bucket == "" {
+		return nil, fmt.Errorf("select a bucket first")
+	}
+	filterFn := func(o types.Object) bool {
+		if *o.Size < s.minSize || (s.maxSize > 0 && *o.Size > s.maxSize) {
+			return false
+		}
+		return s.match == "" || strings.Contains(*o.Key, s.match)
+	}
+	objectList, _, err := listAllObjects(s.ctx, s.svc, s.bucket, s.prefix, filterFn)
+	if err != nil {
+		return nil, err
+	}
+	if len(objectList) == 0 {
+		return nil, fmt.Errorf("no objects selected")
+	}
+	return objectList, nil
+}
@@ -0,0 +1,169 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+	"sigs.k8s.io/yaml"
+)
+
+// jobSpec is the file passed with --jobs. Options not set on a job are taken
+// from the command line.
+type jobSpec struct {
+	Jobs []*job `json:"jobs"`
+}
+
+type job struct {
+	Name           string `json:"name"`
+	Source         string `json:"source"`
+	Manifest       string `json:"manifest"`
+	Destination    string `json:"destination"`
+	ConcatInMemory *bool  `json:"concat_in_memory"`
+	StorageClass   string `json:"storage_class"`
+	Format         string `json:"format"`
+	OnError        string `json:"on_error"`
+	Goroutines     int    `json:"goroutines"`
+}
+
+type jobResult struct {
+	Name        string            `json:"name"`
+	Destination string            `json:"destination"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Duration    string            `json:"duration"`
+	Summary     *s3tar.RunSummary `json:"summary,omitempty"`
+}
+
+type jobReport struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Jobs      []*jobResult `json:"jobs"`
+}
+
+// parseJobSpec parses a job file in YAML or JSON, which is YAML too. The
+// YAML is converted to JSON first, so the fields are named after their json
+// tags either way.
+func parseJobSpec(data []byte) (*jobSpec, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: job file: %w", s3tar.ErrInvalidArgument, err)
+	}
+	spec := &jobSpec{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(spec); err != nil {
+		return nil, fmt.Errorf("%w: job file: %w", s3tar.ErrInvalidArgument, err)
+	}
+	if len(spec.Jobs) == 0 {
+		return nil, fmt.Errorf("%w: job file has no jobs", s3tar.ErrInvalidArgument)
+	}
+	for i, j := range spec.Jobs {
+		if !strings.HasPrefix(j.Destination, "s3://") {
+			return nil, fmt.Errorf("%w: job %d: destination must be an s3:// url", s3tar.ErrInvalidArgument, i+1)
+		}
+		if j.Source == "" && j.Manifest == "" {
+			return nil, fmt.Errorf("%w: job %d: source or manifest is required", s3tar.ErrInvalidArgument, i+1)
+		}
+		if j.Name == "" {
+			j.Name = j.Destination
+		}
+		if j.Goroutines < 0 {
+			return nil, fmt.Errorf("%w: job %d: goroutines can't be negative", s3tar.ErrInvalidArgument, i+1)
+		}
+	}
+	return spec, nil
+}
+
+func loadJobSpec(ctx context.Context, svc *s3.Client, location string) (*jobSpec, error) {
+	r, err := s3tar.LoadFile(ctx, svc, location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseJobSpec(data)
+}
+
+// runJobs creates the archives, parallel at a time. One at a time, every job
+// gets the whole --goroutines budget, or its own goroutines, instead of
+// competing for the network; jobs run in parallel share the limits of the
+// budget of the clients. A failed job does not stop the others; the error of
+// the first failure in the file is returned once all of them ran.
+func runJobs(ctx context.Context, jobs []*job, parallel int, create func(*job) (*s3tar.RunSummary, error)) (*jobReport, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	report := &jobReport{Jobs: make([]*jobResult, len(jobs))}
+	errs := make([]error, len(jobs))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		i, j := i, j
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s3tar.Infof(ctx, "job %d of %d: %s", i+1, len(jobs), j.Name)
+			start := time.Now()
+			summary, err := create(j)
+			result := &jobResult{
+				Name:        j.Name,
+				Destination: j.Destination,
+				Status:      "succeeded",
+				Duration:    time.Since(start).String(),
+				Summary:     summary,
+			}
+			if err != nil {
+				s3tar.Errorf(ctx, "job %s failed: %s", j.Name, err.Error())
+				result.Status, result.Error = "failed", err.Error()
+			}
+			report.Jobs[i], errs[i] = result, err
+		}()
+	}
+	wg.Wait()
+	var firstErr error
+	for _, err := range errs {
+		if err == nil {
+			report.Succeeded++
+			continue
+		}
+		report.Failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return report, fmt.Errorf("%d of %d jobs failed: %w", report.Failed, len(jobs), firstErr)
+	}
+	return report, nil
+}
+
+// writeJobReport prints the report and also writes it to location when set.
+func writeJobReport(ctx context.Context, svc *s3.Client, location string, report *jobReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	os.Stdout.Write(data)
+	if location == "" {
+		return nil
+	}
+	return s3tar.SaveFile(ctx, svc, location, data)
+}
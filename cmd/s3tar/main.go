@@ -7,16 +7,25 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	s3tar "github.com/awslabs/amazon-s3-tar-tool"
@@ -36,20 +45,109 @@ const (
 	maxSize = 1024 * 1024 * 1024 * 1024 * 5
 )
 
+// exit codes returned when a run fails, so scripts can branch on the cause.
+// Codes below 20 are used for invalid command line flags.
+const (
+	exitFailure           = 1
+	exitAccessDenied      = 20
+	exitNotFound          = 21
+	exitObjectTooLarge    = 22
+	exitArchiveTooLarge   = 23
+	exitTooManyParts      = 24
+	exitSourceChanged     = 25
+	exitInvalidArgument   = 26
+	exitInvalidArchive    = 27
+	exitChecksumMismatch  = 28
+	exitDestinationExists = 29
+	exitSourceLocked      = 30
+	exitObjectArchived    = 31
+	exitRestorePending    = 32
+	exitInvalidSignature  = 33
+	exitUnsafeName        = 34
+	exitUnsupportedFormat = 35
+	exitResumable         = 36
+)
+
 func main() {
 	err := run(os.Args)
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Print(err.Error())
+		os.Exit(exitCode(err))
+	}
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, s3tar.ErrAccessDenied):
+		return exitAccessDenied
+	case errors.Is(err, s3tar.ErrNotFound), errors.Is(err, s3tar.ErrUnableToAccess):
+		return exitNotFound
+	case errors.Is(err, s3tar.ErrObjectTooLarge):
+		return exitObjectTooLarge
+	case errors.Is(err, s3tar.ErrArchiveTooLarge):
+		return exitArchiveTooLarge
+	case errors.Is(err, s3tar.ErrTooManyParts):
+		return exitTooManyParts
+	case errors.Is(err, s3tar.ErrSourceChanged):
+		return exitSourceChanged
+	case errors.Is(err, s3tar.ErrInvalidArgument):
+		return exitInvalidArgument
+	case errors.Is(err, s3tar.ErrInvalidArchive):
+		return exitInvalidArchive
+	case errors.Is(err, s3tar.ErrChecksumMismatch):
+		return exitChecksumMismatch
+	case errors.Is(err, s3tar.ErrDestinationExists):
+		return exitDestinationExists
+	case errors.Is(err, s3tar.ErrSourceLocked):
+		return exitSourceLocked
+	case errors.Is(err, s3tar.ErrObjectArchived):
+		return exitObjectArchived
+	case errors.Is(err, s3tar.ErrRestorePending):
+		return exitRestorePending
+	case errors.Is(err, s3tar.ErrInvalidSignature):
+		return exitInvalidSignature
+	case errors.Is(err, s3tar.ErrUnsafeName):
+		return exitUnsafeName
+	case errors.Is(err, s3tar.ErrUnsupportedFormat):
+		return exitUnsupportedFormat
+	case errors.Is(err, s3tar.ErrResumable):
+		return exitResumable
+	default:
+		return exitFailure
 	}
 }
 
 func run(args []string) error {
-	ctx := s3tar.SetupLogger(context.Background())
+	// an interrupted run returns through the clean up of its intermediate
+	// objects, an archive built in memory first finishes the parts started
+	ctx, cancel := context.WithCancel(s3tar.SetupLogger(context.Background()))
+	defer cancel()
+	drain, stop := drainOnSignal(ctx, cancel)
+	ctx = s3tar.WithDrain(ctx, drain)
 	var create bool
 	var extract bool
 	var list bool
 	var generateToc bool
 	var generateManifest bool
+	var estimate bool
+	var bench bool
+	var probe bool
+	var benchObjects int
+	var benchSizes string
+	var interactive bool
+	var validate bool
+	var salvage bool
+	var recoveryReport string
+	var recompress bool
+	var convert bool
+	var convertTo string
+	var zipStore bool
+	var compressionLevel int
+	var windowsNames bool
+	var onConflict string
+	var nameMap string
+	var serve string
+	var mountDir string
 	var region string
 	var endpointUrl string
 	var archiveFile string // file flag
@@ -59,18 +157,114 @@ func run(args []string) error {
 	var manifestPath string
 	var tarFormat string
 	var extended bool
+	var tocFormat string
+	var patterns cli.StringSlice
+	var excludes cli.StringSlice
+	var searchMinSize string
+	var searchMaxSize string
+	var newerThan string
+	var olderThan string
+	var indexFormat string
 	var externalToc string
 	var storageClass string
 	var sizeLimit int64
 	var maxAttempts int
+	var httpOpts s3tar.HTTPOptions
 	var concatInMemory bool
 	var urlDecode bool
 	var userPartMaxSize int64
+	var userPartMinSize int64
+	var mpuThresholdSize string
+	var mpuThreshold int64
+	var groupingName string
+	var grouping s3tar.Grouping
 	var awsProfile string
 	var tagSetInput string
 	var kmsKeyID string
 	var sseAlgo string
 	var preservePosixMetadata bool
+	var jsonSummary bool
+	var srcProfile string
+	var srcRegion string
+	var srcs cli.StringSlice
+	var dstProfile string
+	var dstRegion string
+	var completionShell string
+	var summaryLocation string
+	var onError string
+	var onChange string
+	var onArchived string
+	var noClobber bool
+	var lock string
+	var idempotent bool
+	var overwrite string
+	var serverSideOnly bool
+	var hybridThreshold int64
+	var partRetries int
+	var objectTimeout time.Duration
+	var partTimeout time.Duration
+	var rangeSize int64
+	var rangeBigMembers bool
+	var rangeConcurrency int
+	var maxBufferedParts int
+	var preflight string
+	var tocChecksums bool
+	var tocExtended bool
+	var signToc string
+	var dynamodbTable string
+	var dynamodbKey string
+	var verifySignature string
+	var provenance bool
+	var auditLog string
+	var logFile string
+	var logMaxSize string
+	var logMaxFiles int
+	var logLevelName string
+	var logOutput *s3tar.RotatingFile
+	var prefetch int
+	var tinyPrefetch int
+	var partPadding string
+	var partOrder string
+	var provider string
+	var accelerate bool
+	var deleteMarkers string
+	var role roleOptions
+	var sessionTags cli.StringSlice
+	var scratch string
+	var keepScratch bool
+	var runID string
+	var errorManifest string
+	var retryErrors string
+	var jobFile string
+	var parallelJobs int
+	var maxDownloads int
+	var maxUploads int
+	var downloadRate string
+	var uploadRate string
+	var flat bool
+	var transforms cli.StringSlice
+	var nameTemplate string
+	var paxRecordValues cli.StringSlice
+	var skipArchived cli.StringSlice
+	var restoreState string
+	var restoreDays int
+	var jobState string
+	var resumeState string
+	var spotInterruption bool
+	var showTransformedNames bool
+	var stripComponents int
+	var addPrefix string
+	var flatten bool
+	var flattenCollisions string
+	var nameCollisionPolicy string
+	var nameCase string
+	var normalizeNames string
+	var recordOrigin bool
+	var memberMode string
+	var memberOwner string
+	var memberGroup string
+	var mtime string
+	var clampMtime bool
 
 	var tagSet types.Tagging
 	var err error
@@ -81,7 +275,8 @@ func run(args []string) error {
 		Usage:   "show version:",
 	}
 	app := &cli.App{
-		UseShortOptionHandling: true,
+		UseShortOptionHandling:    true,
+		DisableSliceFlagSeparator: true,
 		Authors: []*cli.Author{
 			&cli.Author{
 				Name:  "Yanko Bolanos",
@@ -126,6 +321,96 @@ func run(args []string) error {
 				Usage:       "lists objects in an S3 Path and generates a for creating an archive later",
 				Destination: &generateManifest,
 			},
+			&cli.BoolFlag{
+				Name:        "estimate",
+				Value:       false,
+				Usage:       "print the exact archive size and multipart plan for a source without creating it",
+				Destination: &estimate,
+			},
+			&cli.BoolFlag{
+				Name:        "bench",
+				Usage:       "with -c, archive synthetic objects written for the run instead of a source, and report the throughput, the request latency and the memory of the run",
+				Destination: &bench,
+			},
+			&cli.BoolFlag{
+				Name:        "probe",
+				Usage:       "with -c, check that the source can be listed and read, the archive and its intermediate objects written with the encryption, tags and ACL of the run, and read the Object Ownership and Object Lock settings of the destination, instead of creating the archive",
+				Destination: &probe,
+			},
+			&cli.IntFlag{
+				Name:        "bench-objects",
+				Value:       1000,
+				Usage:       "number of objects written by --bench",
+				Destination: &benchObjects,
+			},
+			&cli.StringFlag{
+				Name:        "bench-sizes",
+				Value:       "1MiB",
+				Usage:       "size distribution of the objects of --bench, sizes with an optional weight, e.g. 4KiB:80,1MiB:15,64MiB:5",
+				Destination: &benchSizes,
+			},
+			&cli.BoolFlag{
+				Name:        "interactive",
+				Value:       false,
+				Usage:       "browse buckets and prefixes, preview the plan and create an archive interactively",
+				Destination: &interactive,
+			},
+			&cli.BoolFlag{
+				Name:        "validate",
+				Value:       false,
+				Usage:       "check the headers, sizes, end-of-archive marker and TOC of the archive without downloading the members",
+				Destination: &validate,
+			},
+			&cli.BoolFlag{
+				Name:        "salvage",
+				Value:       false,
+				Usage:       "extract the members of a corrupt archive found before the corruption to -C and list the ones found after it",
+				Destination: &salvage,
+			},
+			&cli.StringFlag{
+				Name:        "recovery-report",
+				Usage:       "where --salvage writes its JSON report, local file or s3://bucket/key. Defaults to the archive key with .recovery.json appended",
+				Destination: &recoveryReport,
+			},
+			&cli.BoolFlag{
+				Name:        "recompress",
+				Value:       false,
+				Usage:       "stream the uncompressed archive given with -f through zstd to the seekable archive s3://bucket/key given with -C, by default the archive key with .zst appended",
+				Destination: &recompress,
+			},
+			&cli.BoolFlag{
+				Name:        "convert",
+				Value:       false,
+				Usage:       "stream the archive given with -f, uncompressed or compressed with gzip or zstd, into a new archive in the format of --to at the s3://bucket/key given with -C, by default the archive key with the extension of the format",
+				Destination: &convert,
+			},
+			&cli.StringFlag{
+				Name:        "to",
+				Value:       "zip",
+				Usage:       "with --convert, the format of the new archive: zip",
+				Destination: &convertTo,
+			},
+			&cli.BoolFlag{
+				Name:        "zip-store",
+				Value:       false,
+				Usage:       "with --convert --to zip, store the members uncompressed instead of compressing them with deflate, for members that are compressed already",
+				Destination: &zipStore,
+			},
+			&cli.IntFlag{
+				Name:        "zstd-level",
+				Usage:       "with --recompress, the zstd level from 1, fastest, to 22, smallest. Defaults to 3",
+				Destination: &compressionLevel,
+			},
+			&cli.StringFlag{
+				Name:        "serve",
+				Usage:       "serve GET /<archive>/<member> on this address, e.g. :8080, for the archives under the s3://bucket/prefix given with -f",
+				Destination: &serve,
+			},
+			&cli.StringFlag{
+				Name:        "mount",
+				Usage:       "mount the archive given with -f read-only on this directory, Linux only",
+				Destination: &mountDir,
+			},
 			&cli.BoolFlag{
 				Name:    "verbose",
 				Value:   false,
@@ -189,6 +474,47 @@ func run(args []string) error {
 				Usage:       "--extended prints out manifest with: name,byte location,content-length,Etag",
 				Destination: &extended,
 			},
+			&cli.StringFlag{
+				Name:        "toc-format",
+				Value:       "csv",
+				Usage:       "the output of -t: csv, or ndjson for a JSON object per member with the archive and all the fields of the TOC",
+				Destination: &tocFormat,
+			},
+			&cli.StringSliceFlag{
+				Name:        "pattern",
+				Usage:       "with -t, only list the members whose name or base name matches this shell pattern, with their offsets, with -x only extract them. Can be repeated",
+				Destination: &patterns,
+			},
+			&cli.StringSliceFlag{
+				Name:        "exclude",
+				Usage:       "with -t or -x, leave out the members whose name or base name matches this shell pattern. Can be repeated",
+				Destination: &excludes,
+			},
+			&cli.StringFlag{
+				Name:        "min-size",
+				Usage:       "with -t, only list the members of at least this size, e.g. 1MB or 512KiB, with their offsets, with -x only extract them",
+				Destination: &searchMinSize,
+			},
+			&cli.StringFlag{
+				Name:        "max-size",
+				Usage:       "with -t, only list the members of at most this size, with their offsets, with -x only extract them",
+				Destination: &searchMaxSize,
+			},
+			&cli.StringFlag{
+				Name:        "newer-than",
+				Usage:       "with -x, only extract the members modified after this time: an age such as 36h or 7d, RFC 3339, YYYY-MM-DD or @seconds. Reads the header of every member",
+				Destination: &newerThan,
+			},
+			&cli.StringFlag{
+				Name:        "older-than",
+				Usage:       "with -x, only extract the members modified before this time, as --newer-than",
+				Destination: &olderThan,
+			},
+			&cli.StringFlag{
+				Name:        "index-format",
+				Usage:       "with -t, write an index of the members for third-party random access tools to -C instead of listing them, tarindexer or sqlite. A sqlite index also holds the archives given after the flags",
+				Destination: &indexFormat,
+			},
 			&cli.StringFlag{
 				Name:        "external-toc",
 				Value:       "",
@@ -213,6 +539,31 @@ func run(args []string) error {
 				Usage:       "number of maxAttempts for AWS Go SDK. 0 is unlimited",
 				Destination: &maxAttempts,
 			},
+			&cli.IntFlag{
+				Name:        "max-idle-conns-per-host",
+				Usage:       "idle connections kept open to Amazon S3, by default --goroutines",
+				Destination: &httpOpts.MaxIdleConnsPerHost,
+			},
+			&cli.DurationFlag{
+				Name:        "dial-timeout",
+				Usage:       "timeout to open a connection, e.g. 5s (default 30s)",
+				Destination: &httpOpts.DialTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "tls-handshake-timeout",
+				Usage:       "timeout of the TLS handshake (default 10s)",
+				Destination: &httpOpts.TLSHandshakeTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "response-header-timeout",
+				Usage:       "timeout waiting for the response headers once a request is sent, none by default",
+				Destination: &httpOpts.ResponseHeaderTimeout,
+			},
+			&cli.BoolFlag{
+				Name:        "disable-http2",
+				Usage:       "only use HTTP/1.1",
+				Destination: &httpOpts.DisableHTTP2,
+			},
 			&cli.BoolFlag{
 				Name:        "concat-in-memory",
 				Value:       false,
@@ -228,15 +579,82 @@ func run(args []string) error {
 			&cli.Int64Flag{
 				Name:        "max-part-size",
 				Value:       0,
-				Usage:       "constrain the max part size of MPU, in MB",
+				Usage:       "the largest part size of MPU, in MB, the archive fails when it needs bigger parts",
 				Destination: &userPartMaxSize,
 			},
+			&cli.Int64Flag{
+				Name:        "min-part-size",
+				Value:       0,
+				Usage:       "the smallest part size of MPU, in MB, grown by 5MB until the archive fits in the part limit",
+				Destination: &userPartMinSize,
+			},
+			&cli.StringFlag{
+				Name:        "mpu-threshold",
+				Usage:       "archives under this size, e.g. 64MB, are uploaded with a single PutObject and larger ones with a multipart upload (default 5MB)",
+				Destination: &mpuThresholdSize,
+			},
+			&cli.StringFlag{
+				Name:        "grouping",
+				Value:       "in-order",
+				Usage:       "how the objects are split into parts with --concat-in-memory: in-order, balanced (even parts, out of the listing order) or max-objects=N",
+				Destination: &groupingName,
+			},
 			&cli.StringFlag{
 				Name:        "profile",
 				Value:       "",
 				Usage:       "",
 				Destination: &awsProfile,
 			},
+			&cli.StringFlag{
+				Name:        "role-arn",
+				Usage:       "assume this role with the credentials of the profiles, or with --web-identity-token-file",
+				Destination: &role.arn,
+			},
+			&cli.StringFlag{
+				Name:        "external-id",
+				Usage:       "external ID required by the trust policy of --role-arn",
+				Destination: &role.externalID,
+			},
+			&cli.StringFlag{
+				Name:        "role-session-name",
+				Usage:       "session name of --role-arn, defaults to s3tar-<timestamp>",
+				Destination: &role.sessionName,
+			},
+			&cli.StringSliceFlag{
+				Name:        "session-tag",
+				Usage:       "key=value session tag of --role-arn, can be repeated",
+				Destination: &sessionTags,
+			},
+			&cli.StringFlag{
+				Name:        "web-identity-token-file",
+				Usage:       "assume --role-arn with the web identity token in this file, e.g. of a CI job or a Kubernetes service account",
+				Destination: &role.webIdentityTokenFile,
+			},
+			&cli.StringFlag{
+				Name:        "src-profile",
+				Usage:       "awscli profile used to read the source, defaults to --profile, with -c it needs --concat-in-memory, with -x a file:/// destination",
+				Destination: &srcProfile,
+			},
+			&cli.StringFlag{
+				Name:        "src-region",
+				Usage:       "region of the source, defaults to --region",
+				Destination: &srcRegion,
+			},
+			&cli.StringSliceFlag{
+				Name:        "src",
+				Usage:       "s3://bucket/prefix merged with the other --src into the archive, under the name of the bucket or ?name=<dir>, and &region=<region> for a bucket in another region, can be repeated",
+				Destination: &srcs,
+			},
+			&cli.StringFlag{
+				Name:        "dst-profile",
+				Usage:       "awscli profile used to write the destination, defaults to --profile",
+				Destination: &dstProfile,
+			},
+			&cli.StringFlag{
+				Name:        "dst-region",
+				Usage:       "region of the destination, defaults to --region",
+				Destination: &dstRegion,
+			},
 			&cli.StringFlag{
 				Name:        "tagging",
 				Usage:       "pass a tag value following awscli syntax: --tagging='{\"TagSet\": [{ \"Key\": \"transition-to\", \"Value\": \"GDA\" }]}'",
@@ -257,57 +675,779 @@ func run(args []string) error {
 				Usage:       "Preserve POSIX permisions, uid and gid if present in S3 object metadata. See https://docs.aws.amazon.com/fsx/latest/LustreGuide/posix-metadata-support.html",
 				Destination: &preservePosixMetadata,
 			},
+			&cli.StringFlag{
+				Name:        "on-conflict",
+				Value:       "overwrite",
+				Usage:       "with -x or --salvage, what to do with a member whose key or file already exists at the destination: overwrite, skip, rename to name-1.ext or error before anything is extracted",
+				Destination: &onConflict,
+			},
+			&cli.BoolFlag{
+				Name:        "windows-names",
+				Usage:       "with -x or --salvage to a file:/// directory, make the member names valid on Windows, as on Windows itself: invalid characters, reserved names like CON and NUL, paths over 259 characters and names differing only in case are renamed",
+				Destination: &windowsNames,
+			},
+			&cli.StringFlag{
+				Name:        "name-map",
+				Usage:       "with -x or --salvage to a file:/// directory, write the members renamed for Windows or by --on-conflict rename as csv of member,path,reason to this local file or s3://bucket/key instead of logging them",
+				Destination: &nameMap,
+			},
+			&cli.StringFlag{
+				Name:        "completion",
+				Usage:       "print the shell completion script for bash or zsh",
+				Destination: &completionShell,
+			},
+			&cli.BoolFlag{
+				Name:        "json-summary",
+				Usage:       "print a JSON summary of every archive created",
+				Destination: &jsonSummary,
+			},
+			&cli.StringFlag{
+				Name:        "summary-location",
+				Usage:       "write the JSON summary to a local file or s3://bucket/key",
+				Destination: &summaryLocation,
+			},
+			&cli.StringFlag{
+				Name:        "on-error",
+				Value:       "fail",
+				Usage:       "what to do when a source object can't be downloaded with --concat-in-memory: fail, skip or retry-then-skip",
+				Destination: &onError,
+			},
+			&cli.StringFlag{
+				Name:        "on-change",
+				Value:       "fail",
+				Usage:       "what to do when a source object changed since it was listed: fail, skip or refetch. skip and refetch need --concat-in-memory",
+				Destination: &onChange,
+			},
+			&cli.StringFlag{
+				Name:        "on-archived",
+				Value:       "fail",
+				Usage:       "what to do with Glacier or Intelligent-Tiering archive objects that aren't restored: fail or skip. They are found before anything is written",
+				Destination: &onArchived,
+			},
+			&cli.BoolFlag{
+				Name:        "no-clobber",
+				Usage:       "fail instead of overwriting an archive that already exists at the destination",
+				Destination: &noClobber,
+			},
+			&cli.StringFlag{
+				Name:        "lock",
+				Usage:       "mark the source while the archive is created: tag adds the s3tar-lock tag to every object, object writes a .s3tar.lock object under the source prefix",
+				Destination: &lock,
+			},
+			&cli.BoolFlag{
+				Name:        "idempotent",
+				Usage:       "record a hash of the objects and options in the archive metadata and skip the run when the archive already has the same hash",
+				Destination: &idempotent,
+			},
+			&cli.StringFlag{
+				Name:        "overwrite",
+				Value:       "always",
+				Usage:       "what to do when the archive already exists: always replace it, never (as --no-clobber) or if-different (as --idempotent)",
+				Destination: &overwrite,
+			},
+			&cli.BoolFlag{
+				Name:        "server-side-only",
+				Usage:       "never download object data, archives under 5MiB are also assembled with UploadPartCopy",
+				Destination: &serverSideOnly,
+			},
+			&cli.Int64Flag{
+				Name:        "hybrid-threshold",
+				Usage:       "download the objects under this size, in MB, and coalesce them into parts, the larger objects are copied server-side",
+				Destination: &hybridThreshold,
+			},
+			&cli.IntFlag{
+				Name:        "part-retries",
+				Value:       2,
+				Usage:       "number of times a part that failed with a transient error is downloaded and uploaded again, with --concat-in-memory",
+				Destination: &partRetries,
+			},
+			&cli.DurationFlag{
+				Name:        "object-timeout",
+				Usage:       "timeout of the download of each object, e.g. 5m, a download over it fails like any other (default none)",
+				Destination: &objectTimeout,
+			},
+			&cli.DurationFlag{
+				Name:        "part-timeout",
+				Usage:       "timeout of the upload or the copy of each part, e.g. 10m, an upload over it fails like any other (default none)",
+				Destination: &partTimeout,
+			},
+			&cli.Int64Flag{
+				Name:        "range-size",
+				Usage:       "download the objects over this size, in MB, with concurrent ranged GETs of this size, with --concat-in-memory",
+				Destination: &rangeSize,
+			},
+			&cli.IntFlag{
+				Name:        "range-concurrency",
+				Value:       4,
+				Usage:       "number of ranges of an object downloaded at once with --range-size",
+				Destination: &rangeConcurrency,
+			},
+			&cli.BoolFlag{
+				Name:        "range-big-members",
+				Usage:       "download the objects of at least half a part in --range-concurrency ranges, at least 8MiB each, with --concat-in-memory",
+				Destination: &rangeBigMembers,
+			},
+			&cli.IntFlag{
+				Name:        "max-buffered-parts",
+				Usage:       "number of parts held in memory at once with --concat-in-memory, from the start of their download to the end of their upload (default: as many as fit in 2GiB, up to --goroutines)",
+				Destination: &maxBufferedParts,
+			},
+			&cli.StringFlag{
+				Name:        "preflight",
+				Value:       "none",
+				Usage:       "head: request the HEAD of every object before anything is written, to fail early on objects that are denied, missing, archived or changed",
+				Destination: &preflight,
+			},
+			&cli.BoolFlag{
+				Name:        "toc-checksums",
+				Usage:       "add the SHA-256, SHA-1 or CRC checksum Amazon S3 stores for each object as a fifth column of the toc.csv",
+				Destination: &tocChecksums,
+			},
+			&cli.BoolFlag{
+				Name:        "toc-extended",
+				Usage:       "add the checksum, origin, version id, storage class, content type and owner of each object as columns 5 to 10 of the toc.csv",
+				Destination: &tocExtended,
+			},
+			&cli.StringFlag{
+				Name:        "sign-toc",
+				Usage:       "sign the TOC, the ETag and the size of the archive with this asymmetric AWS KMS key and write the signature to <archive>.toc.sig",
+				Destination: &signToc,
+			},
+			&cli.StringFlag{
+				Name:        "dynamodb-table",
+				Usage:       "write an item per member of the archive, with its archive, name, offset, size, ETag and checksum, to this Amazon DynamoDB table once it's created, or with -t of the archives listed",
+				Destination: &dynamodbTable,
+			},
+			&cli.StringFlag{
+				Name:        "dynamodb-key",
+				Value:       "member,archive",
+				Usage:       "the key schema of --dynamodb-table: the partition key and an optional sort key, each member or archive, or attribute=member and attribute=archive to name them",
+				Destination: &dynamodbKey,
+			},
+			&cli.BoolFlag{
+				Name:        "provenance",
+				Usage:       "write an in-toto provenance of the archive, with the sources, options, identity, times and checksums of the run, to <archive>.provenance.json",
+				Destination: &provenance,
+			},
+			&cli.StringFlag{
+				Name:        "audit-log",
+				Usage:       "record every Amazon S3 request, with its operation, bucket, key, bytes, duration, request id and outcome, as JSON lines to a local file or s3://bucket/key",
+				Destination: &auditLog,
+			},
+			&cli.StringFlag{
+				Name:        "log-file",
+				Usage:       "write the log to this local file, with timestamps and severities, instead of stdout",
+				Destination: &logFile,
+			},
+			&cli.StringFlag{
+				Name:        "log-max-size",
+				Value:       "100MB",
+				Usage:       "rotate the --log-file when it reaches this size, to <file>.1, <file>.2 and so on, 0 never rotates it",
+				Destination: &logMaxSize,
+			},
+			&cli.IntFlag{
+				Name:        "log-max-files",
+				Value:       5,
+				Usage:       "number of rotated log files kept, the oldest is removed",
+				Destination: &logMaxFiles,
+			},
+			&cli.StringFlag{
+				Name:        "log-level",
+				Usage:       "log debug, info, warn or error messages and the ones more severe, instead of the level of -v",
+				Destination: &logLevelName,
+			},
+			&cli.StringFlag{
+				Name:        "verify-signature",
+				Usage:       "with --validate, also check that the signature written by --sign-toc is by this AWS KMS key and signs the archive as it is",
+				Destination: &verifySignature,
+			},
+			&cli.IntFlag{
+				Name:        "prefetch",
+				Value:       4,
+				Usage:       "number of objects of a part downloaded ahead of the one written to it with --concat-in-memory, objects over 16MiB are not, 0 downloads them one at a time",
+				Destination: &prefetch,
+			},
+			&cli.IntFlag{
+				Name:        "tiny-prefetch",
+				Value:       32,
+				Usage:       "number of objects of at most 64KiB of a part downloaded ahead of the one written to it with --concat-in-memory, in place of --prefetch for them, 0 counts them in --prefetch",
+				Destination: &tinyPrefetch,
+			},
+			&cli.StringFlag{
+				Name:        "part-padding",
+				Value:       "zero-blocks",
+				Usage:       "how the parts built with --concat-in-memory end: zero-blocks ends them with a member and fills a part left under 5MiB by skipped objects with a pad member, pad-file also fills one left short by re-fetched objects, exact-fit cuts the members into parts of exactly the part size",
+				Destination: &partPadding,
+			},
+			&cli.StringFlag{
+				Name:        "part-order",
+				Value:       "listing",
+				Usage:       "order the parts are built or copied in: listing, largest-first, so a large part doesn't run alone at the end, or smallest-first",
+				Destination: &partOrder,
+			},
+			&cli.StringFlag{
+				Name:        "provider",
+				Usage:       "profile of the S3-compatible service of --endpointUrl, one of aws, ceph, minio or wasabi: its bucket addressing, additional checksums and multipart limits. The destination bucket is probed before the archive is written",
+				Destination: &provider,
+			},
+			&cli.BoolFlag{
+				Name:        "accelerate",
+				Usage:       "upload the archive built with --concat-in-memory through the transfer acceleration endpoint of the destination bucket, which must have it enabled",
+				Destination: &accelerate,
+			},
+			&cli.StringFlag{
+				Name:        "delete-markers",
+				Usage:       "what to do with the keys of a versioned source whose latest version is a delete marker, which aren't looked for by default: ignore leaves them out with a warning each, report skips them as objects that can't be archived, include archives their newest version",
+				Destination: &deleteMarkers,
+			},
+			&cli.StringFlag{
+				Name:        "scratch",
+				Usage:       "s3:// prefix the intermediate objects are written to, by default next to the archive",
+				Destination: &scratch,
+			},
+			&cli.BoolFlag{
+				Name:        "keep-scratch",
+				Usage:       "don't delete the intermediate objects, to debug a run",
+				Destination: &keepScratch,
+			},
+			&cli.StringFlag{
+				Name:        "run-id",
+				Usage:       "id of the run in the log lines, the intermediate keys and the metadata of the archive, generated by default",
+				Destination: &runID,
+			},
+			&cli.StringFlag{
+				Name:        "error-manifest",
+				Usage:       "where to write the csv of skipped objects, local file or s3://bucket/key. Defaults to the archive key with .errors.csv appended",
+				Destination: &errorManifest,
+			},
+			&cli.StringFlag{
+				Name:        "retry-errors",
+				Usage:       "create a supplemental archive with the objects of an error manifest and a merged TOC of both archives",
+				Destination: &retryErrors,
+			},
+			&cli.StringSliceFlag{
+				Name:        "skip-archived",
+				Usage:       "leave out objects whose key and ETag are in the TOC of an existing archive, or a csv TOC. Can be repeated",
+				Destination: &skipArchived,
+			},
+			&cli.StringFlag{
+				Name:        "restore-and-wait",
+				Usage:       "start a Bulk restore of the Glacier objects of the source and keep the pending set in this file, local or s3://. Later runs with the same flags create the archive once every restore is complete",
+				Destination: &restoreState,
+			},
+			&cli.StringFlag{
+				Name:        "state",
+				Usage:       "with --concat-in-memory, persist the state of the job under this s3://bucket/prefix/ so --resume continues it on any machine after a failure",
+				Destination: &jobState,
+			},
+			&cli.StringFlag{
+				Name:        "resume",
+				Usage:       "continue the job whose state is under this s3://bucket/prefix/, given with --state when it was started",
+				Destination: &resumeState,
+			},
+			&cli.BoolFlag{
+				Name:        "spot-interruption",
+				Usage:       "on an EC2 Spot instance, watch for its interruption notice and stop as on SIGTERM, so --resume continues the job on another instance",
+				Destination: &spotInterruption,
+			},
+			&cli.IntFlag{
+				Name:        "restore-days",
+				Value:       7,
+				Usage:       "days the restored copies are kept, with --restore-and-wait",
+				Destination: &restoreDays,
+			},
+			&cli.StringFlag{
+				Name:        "jobs",
+				Usage:       "JSON or YAML file, local or s3://, with the source and destination of many archives to create one after the other, or --parallel-jobs at once",
+				Destination: &jobFile,
+			},
+			&cli.IntFlag{
+				Name:        "parallel-jobs",
+				Value:       1,
+				Usage:       "jobs of --jobs run at once, sharing --max-downloads, --max-uploads, --download-rate and --upload-rate",
+				Destination: &parallelJobs,
+			},
+			&cli.IntFlag{
+				Name:        "max-downloads",
+				Usage:       "most GET requests at once across every archive of the run, unlimited by default",
+				Destination: &maxDownloads,
+			},
+			&cli.IntFlag{
+				Name:        "max-uploads",
+				Usage:       "most PutObject and UploadPart requests at once across every archive of the run, unlimited by default",
+				Destination: &maxUploads,
+			},
+			&cli.StringFlag{
+				Name:        "download-rate",
+				Usage:       "most bytes per second downloaded across every archive of the run, e.g. 100MB",
+				Destination: &downloadRate,
+			},
+			&cli.StringFlag{
+				Name:        "upload-rate",
+				Usage:       "most bytes per second uploaded across every archive of the run, e.g. 100MB",
+				Destination: &uploadRate,
+			},
+			&cli.BoolFlag{
+				Name:        "flat",
+				Usage:       "only archive the objects at the level of the source prefix instead of everything under it",
+				Destination: &flat,
+			},
+			&cli.StringSliceFlag{
+				Name:        "transform",
+				Usage:       "rewrite member names with a sed expression s/regexp/replacement/flags, can be repeated and is applied in order",
+				Destination: &transforms,
+			},
+			&cli.StringFlag{
+				Name:        "name-template",
+				Usage:       "name members with a Go template such as '{{.Dir}}/{{.Base | lower}}', applied after --transform",
+				Destination: &nameTemplate,
+			},
+			&cli.IntFlag{
+				Name:        "strip-components",
+				Usage:       "remove the first N components of the keys from the member names, applied before --transform",
+				Destination: &stripComponents,
+			},
+			&cli.BoolFlag{
+				Name:        "flatten",
+				Usage:       "store only the base name of every key",
+				Destination: &flatten,
+			},
+			&cli.StringFlag{
+				Name:        "flatten-collisions",
+				Value:       "error",
+				Usage:       "with --flatten, what to do when two keys have the same base name: error, suffix or hash",
+				Destination: &flattenCollisions,
+			},
+			&cli.StringFlag{
+				Name:        "name-collisions",
+				Value:       "error",
+				Usage:       "with --name-case or --normalize-names, what to do when two keys get the same name: error, suffix or hash",
+				Destination: &nameCollisionPolicy,
+			},
+			&cli.StringFlag{
+				Name:        "mode",
+				Usage:       "octal mode of every member instead of 0600, preserved POSIX metadata takes precedence",
+				Destination: &memberMode,
+			},
+			&cli.StringFlag{
+				Name:        "owner",
+				Usage:       "owner of every member as NAME, ID or NAME:ID, preserved POSIX metadata takes precedence",
+				Destination: &memberOwner,
+			},
+			&cli.StringFlag{
+				Name:        "group",
+				Usage:       "group of every member as NAME, ID or NAME:ID, preserved POSIX metadata takes precedence",
+				Destination: &memberGroup,
+			},
+			&cli.StringFlag{
+				Name:        "mtime",
+				Usage:       "set the time of every member: RFC 3339, YYYY-MM-DD or @seconds. Defaults to $SOURCE_DATE_EPOCH with --clamp-mtime",
+				Destination: &mtime,
+			},
+			&cli.BoolFlag{
+				Name:        "clamp-mtime",
+				Usage:       "only set the time of the members newer than --mtime",
+				Destination: &clampMtime,
+			},
+			&cli.StringSliceFlag{
+				Name:        "pax-record",
+				Usage:       "add the PAX record key=value to every member, the value is a Go template such as {{.Key}} or {{.Dir}}, can be repeated",
+				Destination: &paxRecordValues,
+			},
+			&cli.BoolFlag{
+				Name:        "record-origin",
+				Usage:       "store the s3://bucket/key of every object, and its versionId with --concat-in-memory, as PAX records of its member",
+				Destination: &recordOrigin,
+			},
+			&cli.StringFlag{
+				Name:        "add-prefix",
+				Usage:       "place every member under this directory inside the archive, applied after --transform",
+				Destination: &addPrefix,
+			},
+			&cli.StringFlag{
+				Name:        "name-case",
+				Usage:       "convert member names to lower or upper case",
+				Destination: &nameCase,
+			},
+			&cli.StringFlag{
+				Name:        "normalize-names",
+				Usage:       "convert member names to the Unicode normalization form NFC or NFD (macOS)",
+				Destination: &normalizeNames,
+			},
+			&cli.BoolFlag{
+				Name:        "show-transformed-names",
+				Usage:       "print the source keys and the member names they get with --strip-components, --transform and the other name options without creating the archive",
+				Destination: &showTransformedNames,
+			},
 		},
 		Action: func(cCtx *cli.Context) error {
 			logLevel := parseLogLevel(cCtx.Count("verbose"))
-			if region == "" && !generateToc {
-				exitError(1, "region is missing\n")
-			}
-			if archiveFile == "" {
-				exitError(2, "-f is a required flag\n")
-			}
-			if sizeLimit > maxSize {
-				sizeLimit = maxSize
+			if logLevelName != "" {
+				level, err := s3tar.ParseLogLevel(logLevelName)
+				if err != nil {
+					return err
+				}
+				logLevel = level
 			}
-
-			if tagSetInput != "" {
-				tagSet, err = parseTagValues(tagSetInput)
+			if logFile != "" {
+				maxSize, err := s3tar.ParseSize(logMaxSize)
 				if err != nil {
-					exitError(10, "invalid format for tags")
+					return err
+				}
+				if logOutput, err = s3tar.OpenRotatingFile(logFile, maxSize, logMaxFiles); err != nil {
+					return err
 				}
+				ctx = s3tar.SetLogOutput(ctx, logOutput)
+			}
+			if mpuThresholdSize != "" {
+				var err error
+				if mpuThreshold, err = s3tar.ParseSize(mpuThresholdSize); err != nil || mpuThreshold <= 0 {
+					return fmt.Errorf("%w: --mpu-threshold %q", s3tar.ErrInvalidArgument, mpuThresholdSize)
+				}
+			}
+			if grouping, err = s3tar.ParseGrouping(groupingName); err != nil {
+				return err
+			}
+			if spotInterruption {
+				go func() {
+					select {
+					case <-s3tar.WatchSpotInterruption(ctx, imds.New(imds.Options{})):
+						stop("stopping before the spot instance is interrupted, an archive built in memory finishes the parts being uploaded first")
+					case <-ctx.Done():
+					}
+				}()
+			}
+			if completionShell != "" {
+				script, err := completionScript(completionShell)
+				if err != nil {
+					return err
+				}
+				fmt.Print(script)
+				return nil
+			}
+			// --src-region only sets the region of the source
+			if region == "" && dstRegion == "" && !generateToc {
+				exitError(1, "region is missing, set --region or --dst-region\n")
+			}
+			if create && srcProfile != "" && !concatInMemory {
+				return fmt.Errorf("%w: --src-profile needs --concat-in-memory, the server-side copies are made with the credentials of the destination", s3tar.ErrInvalidArgument)
+			}
+			if extract && srcProfile != "" && destination != "" && !s3tar.IsLocalURL(destination) {
+				return fmt.Errorf("%w: --src-profile can't extract to Amazon S3, the members are copied server-side with the credentials of the destination, extract to a file:/// directory instead", s3tar.ErrInvalidArgument)
+			}
+			// --on-archived is about the Glacier and Intelligent-Tiering archive
+			// storage classes, --skip-archived about existing tar archives
+			if cCtx.IsSet("on-archived") && len(skipArchived.Value()) > 0 {
+				return fmt.Errorf("%w: --on-archived and --skip-archived can't be used together, --on-archived is for objects in the archive storage classes and --skip-archived for objects in existing archives", s3tar.ErrInvalidArgument)
+			}
+			if archiveFile == "" && !estimate && !interactive && jobFile == "" && !showTransformedNames && resumeState == "" {
+				exitError(2, "-f is a required flag\n")
+			}
+			if sizeLimit > maxSize {
+				sizeLimit = maxSize
 			}
 
-			var loadOption config.LoadOptionsFunc
-			if endpointUrl != "" {
-				loadOption = config.WithEndpointResolverWithOptions(
-					aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-						return aws.Endpoint{
-							URL:               endpointUrl,
-							HostnameImmutable: true,
-							SigningRegion:     region,
-							Source:            aws.EndpointSourceCustom,
-						}, nil
-					}))
-			} else {
-				loadOption = config.WithRegion(region)
+			var nameTransforms []s3tar.NameTransform
+			if stripComponents > 0 {
+				nameTransforms = append(nameTransforms, s3tar.StripComponents(stripComponents))
+			}
+			for _, expr := range transforms.Value() {
+				t, err := s3tar.SedTransform(expr)
+				if err != nil {
+					return err
+				}
+				nameTransforms = append(nameTransforms, t)
+			}
+			if nameTemplate != "" {
+				t, err := s3tar.NameTemplate(nameTemplate)
+				if err != nil {
+					return err
+				}
+				nameTransforms = append(nameTransforms, t)
+			}
+			var nameCollisions string
+			if flatten {
+				nameTransforms = append(nameTransforms, s3tar.Flatten())
+				nameCollisions = flattenCollisions
+			}
+			if nameCase != "" {
+				t, err := s3tar.NameCase(nameCase)
+				if err != nil {
+					return err
+				}
+				nameTransforms = append(nameTransforms, t)
+			}
+			if normalizeNames != "" {
+				t, err := s3tar.NormalizeNames(normalizeNames)
+				if err != nil {
+					return err
+				}
+				nameTransforms = append(nameTransforms, t)
+			}
+			if nameCase != "" || normalizeNames != "" {
+				// the names made equal by --flatten and by these are resolved together
+				if flatten && cCtx.IsSet("flatten-collisions") && cCtx.IsSet("name-collisions") && flattenCollisions != nameCollisionPolicy {
+					return fmt.Errorf("%w: --flatten-collisions and --name-collisions can't differ, the names are resolved once", s3tar.ErrInvalidArgument)
+				}
+				if !flatten || cCtx.IsSet("name-collisions") {
+					nameCollisions = nameCollisionPolicy
+				}
+			}
+			if addPrefix != "" {
+				nameTransforms = append(nameTransforms, s3tar.AddPrefix(addPrefix))
 			}
 
-			retryOption := config.WithRetryer(func() aws.Retryer {
-				return retry.AddWithMaxAttempts(retry.NewStandard(), maxAttempts)
-			})
+			var headerTransforms []s3tar.HeaderTransform
+			if memberMode != "" || memberOwner != "" || memberGroup != "" {
+				t, err := s3tar.Ownership(memberMode, memberOwner, memberGroup)
+				if err != nil {
+					return err
+				}
+				headerTransforms = append(headerTransforms, t)
+			}
+			if mtime == "" && clampMtime && os.Getenv("SOURCE_DATE_EPOCH") != "" {
+				mtime = "@" + os.Getenv("SOURCE_DATE_EPOCH")
+			}
+			if mtime != "" {
+				t, err := s3tar.ParseMtime(mtime)
+				if err != nil {
+					return err
+				}
+				headerTransforms = append(headerTransforms, s3tar.Mtime(t, clampMtime))
+			} else if clampMtime {
+				return fmt.Errorf("%w: --clamp-mtime needs --mtime or SOURCE_DATE_EPOCH", s3tar.ErrInvalidArgument)
+			}
 
-			optFns := []func(*config.LoadOptions) error{
-				loadOption,
-				retryOption,
+			var paxRecords []s3tar.PAXRecord
+			for _, v := range paxRecordValues.Value() {
+				r, err := s3tar.ParsePAXRecord(v)
+				if err != nil {
+					return err
+				}
+				paxRecords = append(paxRecords, r)
 			}
-			if awsProfile != "" {
-				optFns = append(optFns, config.WithSharedConfigProfile(awsProfile))
+
+			if tagSetInput != "" {
+				tagSet, err = parseTagValues(tagSetInput)
+				if err != nil {
+					exitError(10, "invalid format for tags")
+				}
+			}
+
+			var tocTable s3tar.TocTable
+			if dynamodbTable != "" {
+				tocTable.Name = dynamodbTable
+				if tocTable.PartitionKey, tocTable.SortKey, err = s3tar.ParseTocTableKey(dynamodbKey); err != nil {
+					return err
+				}
 			}
 
-			svc := s3Client(ctx, optFns...)
+			providerProfile, err := s3tar.ProviderProfile(provider)
+			if err != nil {
+				return err
+			}
+			switch s3tar.DeleteMarkerPolicy(deleteMarkers) {
+			case s3tar.DeleteMarkersOff, s3tar.DeleteMarkersIgnore, s3tar.DeleteMarkersReport, s3tar.DeleteMarkersInclude:
+			default:
+				return fmt.Errorf("%w: unknown --delete-markers %q, one of ignore, report or include", s3tar.ErrInvalidArgument, deleteMarkers)
+			}
+			role.sessionTags = sessionTags.Value()
+			if err := role.validate(); err != nil {
+				return err
+			}
+			loadOptions := func(region, profile string) []func(*config.LoadOptions) error {
+				var loadOption config.LoadOptionsFunc
+				if endpointUrl != "" {
+					loadOption = config.WithEndpointResolverWithOptions(
+						aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+							if service != s3.ServiceID {
+								// STS, for --role-arn, keeps its own endpoint
+								return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+							}
+							return aws.Endpoint{
+								URL: endpointUrl,
+								// without --provider the bucket is always in the path
+								HostnameImmutable: provider == "" || providerProfile.PathStyle,
+								SigningRegion:     region,
+								Source:            aws.EndpointSourceCustom,
+							}, nil
+						}))
+				} else {
+					loadOption = config.WithRegion(region)
+				}
+
+				retryOption := config.WithRetryer(func() aws.Retryer {
+					return s3tar.NewRetryCounter(retry.AddWithMaxAttempts(retry.NewStandard(), maxAttempts))
+				})
+
+				if httpOpts.MaxIdleConnsPerHost == 0 {
+					// one connection per goroutine, the SDK keeps 10
+					httpOpts.MaxIdleConnsPerHost = threads
+					if concatInMemory {
+						// and one per object downloaded ahead, so the GETs
+						// of tiny objects reuse their connections
+						httpOpts.MaxIdleConnsPerHost = threads * (1 + prefetch + tinyPrefetch)
+					}
+				}
+				optFns := []func(*config.LoadOptions) error{
+					loadOption,
+					retryOption,
+					config.WithHTTPClient(s3tar.NewHTTPClient(httpOpts)),
+				}
+				if profile != "" {
+					optFns = append(optFns, config.WithSharedConfigProfile(profile))
+				}
+				return optFns
+			}
+
+			var audit *s3tar.AuditLog
+			if auditLog != "" {
+				if audit, err = s3tar.NewAuditLog(auditLog); err != nil {
+					return err
+				}
+			}
+			budget, err := newBudget(maxDownloads, maxUploads, downloadRate, uploadRate)
+			if err != nil {
+				return err
+			}
+
+			// svc writes to the destination, srcSvc reads the source
+			svc := s3Client(ctx, provider, &role, audit, budget, loadOptions(firstNonEmpty(dstRegion, region), firstNonEmpty(dstProfile, awsProfile))...)
+			srcSvc := svc
+			if srcRegion != "" || srcProfile != "" {
+				srcSvc = s3Client(ctx, provider, &role, audit, budget, loadOptions(firstNonEmpty(srcRegion, region), firstNonEmpty(srcProfile, awsProfile))...)
+			}
+			if audit != nil {
+				defer func() {
+					// written after an interrupt too
+					if err := audit.Close(context.Background(), svc); err != nil {
+						log.Print(err.Error())
+					}
+				}()
+			}
+			// sourcesOf returns the --src merged into the archive, read with a
+			// client of their region when it isn't the one of srcSvc
+			sourcesOf := func(src string) ([]s3tar.Source, error) {
+				if src != "" {
+					return nil, fmt.Errorf("%w: give the source as an argument or with --src, not both", s3tar.ErrInvalidArgument)
+				}
+				clients := map[string]*s3.Client{}
+				var sources []s3tar.Source
+				for _, spec := range srcs.Value() {
+					s, err := s3tar.ParseSource(spec)
+					if err != nil {
+						return nil, err
+					}
+					if s.Region != "" && s.Region != firstNonEmpty(srcRegion, region) {
+						if clients[s.Region] == nil {
+							clients[s.Region] = s3Client(ctx, provider, &role, audit, budget, loadOptions(s.Region, firstNonEmpty(srcProfile, awsProfile))...)
+						}
+						s.Client = clients[s.Region]
+					}
+					sources = append(sources, s)
+				}
+				return sources, nil
+			}
+
+			if jobFile != "" {
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				spec, err := loadJobSpec(ctx, svc, jobFile)
+				if err != nil {
+					return err
+				}
+				archiveClient := newArchiveClient(svc)
+				report, err := runJobs(ctx, spec.Jobs, parallelJobs, func(j *job) (*s3tar.RunSummary, error) {
+					s3opts := &s3tar.S3TarS3Options{
+						SrcManifest:           j.Manifest,
+						SkipManifestHeader:    skipManifestHeader,
+						Threads:               threads,
+						Region:                firstNonEmpty(dstRegion, region),
+						EndpointUrl:           endpointUrl,
+						ConcatInMemory:        concatInMemory,
+						UrlDecode:             urlDecode,
+						UserMaxPartSize:       userPartMaxSize,
+						UserMinPartSize:       userPartMinSize,
+						MPUThreshold:          mpuThreshold,
+						ObjectTags:            tagSet,
+						PreservePOSIXMetadata: preservePosixMetadata,
+						RecordOrigin:          recordOrigin,
+						OnError:               s3tar.ErrorPolicy(firstNonEmpty(j.OnError, onError)),
+						OnChange:              s3tar.ChangePolicy(onChange),
+						OnArchived:            s3tar.ArchivedPolicy(onArchived),
+						NoClobber:             noClobber,
+						Lock:                  s3tar.LockMode(lock),
+						Idempotent:            idempotent,
+						Overwrite:             s3tar.OverwritePolicy(overwrite),
+						ServerSideOnly:        serverSideOnly,
+						HybridThreshold:       hybridThreshold * 1024 * 1024,
+						PartRetries:           partRetries,
+						ObjectTimeout:         objectTimeout,
+						RangeSize:             rangeSize * 1024 * 1024,
+						RangeConcurrency:      rangeConcurrency,
+						RangeBigMembers:       rangeBigMembers,
+						MaxBufferedParts:      maxBufferedParts,
+						Preflight:             s3tar.PreflightMode(preflight),
+						TocChecksums:          tocChecksums,
+						TocExtended:           tocExtended,
+						SignTocKeyID:          signToc,
+						TocTable:              tocTable,
+						Provenance:            provenance,
+						ToolVersion:           VersionMsg,
+						Prefetch:              prefetch,
+						TinyPrefetch:          tinyPrefetch,
+						PartPadding:           s3tar.PartPadding(partPadding),
+						PartOrder:             s3tar.PartOrder(partOrder),
+						Provider:              provider,
+						Accelerate:            accelerate,
+						DeleteMarkers:         s3tar.DeleteMarkerPolicy(deleteMarkers),
+						PartTimeout:           partTimeout,
+						Scratch:               scratch,
+						KeepScratch:           keepScratch,
+						RunID:                 runID,
+					}
+					if j.ConcatInMemory != nil {
+						s3opts.ConcatInMemory = *j.ConcatInMemory
+					}
+					if j.Goroutines > 0 {
+						s3opts.Threads = j.Goroutines
+					}
+					setDestination(s3opts, j.Destination)
+					s3opts.SrcBucket, s3opts.SrcPrefix = s3tar.ExtractBucketAndPath(j.Source)
+
+					var objectList []*s3tar.S3Obj
+					var err error
+					if s3opts.SrcManifest != "" {
+						objectList, _, err = loadCSV(ctx, srcSvc, s3opts.SrcManifest, s3opts.SkipManifestHeader, s3opts.UrlDecode)
+					} else {
+						objectList, _, err = listSource(ctx, srcSvc, s3opts.SrcBucket, s3opts.SrcPrefix, flat, s3opts.DeleteMarkers)
+					}
+					if err != nil {
+						return nil, err
+					}
+					var summary *s3tar.RunSummary
+					err = archiveClient.CreateFromList(ctx, objectList, s3opts,
+						s3tar.WithStorageClass(firstNonEmpty(j.StorageClass, storageClass)),
+						s3tar.WithTarFormat(firstNonEmpty(j.Format, tarFormat)),
+						s3tar.WithKMS(kmsKeyID, sseAlgo),
+						s3tar.WithNameTransforms(nameTransforms...),
+						s3tar.WithNameCollisions(nameCollisions),
+						s3tar.WithHeaderTransforms(headerTransforms...),
+						s3tar.WithGrouping(grouping),
+						s3tar.WithPAXRecords(paxRecords...),
+						s3tar.WithSourceClient(srcSvc),
+						s3tar.WithSummary(func(s *s3tar.RunSummary) {
+							summary = s
+						}))
+					return summary, err
+				})
+				if werr := writeJobReport(ctx, svc, summaryLocation, report); werr != nil {
+					s3tar.Errorf(ctx, "unable to write job report: %s", werr.Error())
+				}
+				return err
+			}
 
 			if create {
-				src := cCtx.Args().First() // TODO implement dir list
+				src := cCtx.Args().First()
 
 				if userPartMaxSize > 0 && (userPartMaxSize < 5 || userPartMaxSize > 5000) {
 					exitError(6, "max-part-size should be >= 5 and < 5000")
@@ -318,62 +1458,248 @@ func run(args []string) error {
 					SkipManifestHeader:    skipManifestHeader,
 					Threads:               threads,
 					DeleteSource:          false,
-					Region:                region,
+					Region:                firstNonEmpty(dstRegion, region),
 					EndpointUrl:           endpointUrl,
 					ConcatInMemory:        concatInMemory,
 					UrlDecode:             urlDecode,
 					UserMaxPartSize:       userPartMaxSize,
+					UserMinPartSize:       userPartMinSize,
+					MPUThreshold:          mpuThreshold,
 					ObjectTags:            tagSet,
 					PreservePOSIXMetadata: preservePosixMetadata,
+					RecordOrigin:          recordOrigin,
+					OnError:               s3tar.ErrorPolicy(onError),
+					OnChange:              s3tar.ChangePolicy(onChange),
+					OnArchived:            s3tar.ArchivedPolicy(onArchived),
+					NoClobber:             noClobber,
+					Lock:                  s3tar.LockMode(lock),
+					Idempotent:            idempotent,
+					Overwrite:             s3tar.OverwritePolicy(overwrite),
+					ServerSideOnly:        serverSideOnly,
+					HybridThreshold:       hybridThreshold * 1024 * 1024,
+					PartRetries:           partRetries,
+					ObjectTimeout:         objectTimeout,
+					RangeSize:             rangeSize * 1024 * 1024,
+					RangeConcurrency:      rangeConcurrency,
+					RangeBigMembers:       rangeBigMembers,
+					MaxBufferedParts:      maxBufferedParts,
+					Preflight:             s3tar.PreflightMode(preflight),
+					TocChecksums:          tocChecksums,
+					TocExtended:           tocExtended,
+					SignTocKeyID:          signToc,
+					TocTable:              tocTable,
+					Provenance:            provenance,
+					ToolVersion:           VersionMsg,
+					Prefetch:              prefetch,
+					TinyPrefetch:          tinyPrefetch,
+					PartPadding:           s3tar.PartPadding(partPadding),
+					PartOrder:             s3tar.PartOrder(partOrder),
+					Provider:              provider,
+					Accelerate:            accelerate,
+					DeleteMarkers:         s3tar.DeleteMarkerPolicy(deleteMarkers),
+					PartTimeout:           partTimeout,
+					Scratch:               scratch,
+					KeepScratch:           keepScratch,
+					RunID:                 runID,
+					ErrorManifest:         errorManifest,
+					State:                 jobState,
 				}
-				s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(archiveFile)
-				s3opts.DstPrefix = filepath.Dir(s3opts.DstKey)
-				s3opts.SrcBucket, s3opts.SrcPrefix = s3tar.ExtractBucketAndPath(src)
-				if s3opts.SrcBucket == "" && manifestPath == "" {
+				setDestination(s3opts, archiveFile)
+				if len(srcs.Value()) > 0 {
+					sources, err := sourcesOf(src)
+					if err != nil {
+						return err
+					}
+					s3opts.Sources = sources
+				} else if s3tar.IsLocalURL(src) {
+					s3opts.SrcPath = s3tar.LocalPath(src)
+				} else {
+					s3opts.SrcBucket, s3opts.SrcPrefix = s3tar.ExtractBucketAndPath(src)
+				}
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+
+				if bench {
+					sizes, err := s3tar.ParseBenchSizes(benchSizes)
+					if err != nil {
+						return err
+					}
+					report, err := s3tar.Bench(ctx, svc, s3tar.BenchOptions{Objects: benchObjects, Sizes: sizes}, s3opts,
+						s3tar.WithStorageClass(storageClass),
+						s3tar.WithTarFormat(tarFormat),
+						s3tar.WithKMS(kmsKeyID, sseAlgo),
+						s3tar.WithHeaderTransforms(headerTransforms...),
+						s3tar.WithGrouping(grouping),
+						s3tar.WithPAXRecords(paxRecords...))
+					if err != nil {
+						return err
+					}
+					if jsonSummary {
+						return json.NewEncoder(os.Stdout).Encode(report)
+					}
+					printBench(report)
+					return nil
+				}
+				if s3opts.SrcBucket == "" && s3opts.SrcPath == "" && len(s3opts.Sources) == 0 && manifestPath == "" && retryErrors == "" {
 					exitError(4, "source directory or manifest file is required.\n")
 				}
+				if probe {
+					report, err := s3tar.Probe(ctx, svc, s3opts,
+						s3tar.WithStorageClass(storageClass),
+						s3tar.WithKMS(kmsKeyID, sseAlgo),
+						s3tar.WithSourceClient(srcSvc))
+					if report != nil {
+						if jsonSummary {
+							json.NewEncoder(os.Stdout).Encode(report)
+						} else {
+							printProbe(report)
+						}
+					}
+					return err
+				}
 
-				ctx = s3tar.SetLogLevel(ctx, logLevel)
 				archiveClient := newArchiveClient(svc)
 
+				// with --retry-errors the archives created are merged with the
+				// original archive into a single TOC once they are all done
+				var originalArchive string
+				var created []string
+				defer func() {
+					if originalArchive == "" || len(created) == 0 {
+						return
+					}
+					location := archiveFile + ".merged-toc.csv"
+					if err := s3tar.MergeToc(ctx, svc, location, append([]string{originalArchive}, created...)...); err != nil {
+						s3tar.Errorf(ctx, "unable to write merged toc: %s", err.Error())
+						return
+					}
+					s3tar.Infof(ctx, "merged toc: %s", location)
+				}()
+
+				var summaries []*s3tar.RunSummary
+				collectSummary := s3tar.WithSummary(func(s *s3tar.RunSummary) {
+					summaries = append(summaries, s)
+				})
+				defer func() {
+					if len(summaries) > 0 {
+						reportSummaries(ctx, svc, jsonSummary, summaryLocation, summaries)
+					}
+				}()
+
 				var objectList []*s3tar.S3Obj
 				var estimatedSize int64
 				var err error
-				if s3opts.SrcManifest != "" {
-					objectList, estimatedSize, err = loadCSV(ctx, svc, s3opts.SrcManifest, s3opts.SkipManifestHeader, s3opts.UrlDecode)
+				if retryErrors != "" {
+					objectList, estimatedSize, originalArchive, err = s3tar.LoadErrorManifest(ctx, svc, retryErrors)
+				} else if s3opts.SrcManifest != "" {
+					objectList, estimatedSize, err = loadCSV(ctx, srcSvc, s3opts.SrcManifest, s3opts.SkipManifestHeader, s3opts.UrlDecode)
+				} else if len(s3opts.Sources) > 0 {
+					objectList, estimatedSize, err = s3tar.ListSources(ctx, srcSvc, s3opts.Sources, flat, s3opts.DeleteMarkers)
+				} else if s3opts.SrcPath != "" {
+					objectList, estimatedSize, err = s3tar.ListLocalFiles(ctx, s3opts.SrcPath, flat)
 				} else {
-					objectList, estimatedSize, err = listAllObjects(ctx, svc, s3opts.SrcBucket, s3opts.SrcPrefix)
+					objectList, estimatedSize, err = listSource(ctx, srcSvc, s3opts.SrcBucket, s3opts.SrcPrefix, flat, s3opts.DeleteMarkers)
 				}
 				if err != nil {
 					return err
 				}
+				if locations := skipArchived.Value(); len(locations) > 0 {
+					archived, err := s3tar.LoadArchived(ctx, svc, locations...)
+					if err != nil {
+						return err
+					}
+					listed := len(objectList)
+					objectList, estimatedSize = s3tar.SkipArchived(objectList, archived)
+					s3tar.Infof(ctx, "%d of %d objects are already archived", listed-len(objectList), listed)
+					if len(objectList) == 0 {
+						fmt.Println("every object is already archived, nothing to do")
+						return nil
+					}
+				}
+
+				if showTransformedNames {
+					if err := s3tar.ApplyNameTransforms(objectList, nameTransforms...); err != nil {
+						return err
+					}
+					if err := s3tar.ResolveNameCollisions(objectList, s3tar.CollisionPolicy(nameCollisions)); err != nil {
+						return err
+					}
+					for _, o := range objectList {
+						fmt.Printf("%s -> %s\n", *o.Key, o.MemberName())
+					}
+					return nil
+				}
+
+				if restoreState != "" {
+					// exits with exitRestorePending until every restore is complete
+					err := s3tar.RestoreAndWait(ctx, svc, srcSvc, restoreState, objectList, s3tar.RestoreOptions{Days: int32(restoreDays), Threads: threads})
+					if err != nil {
+						return err
+					}
+				}
 
 				s3tar.Infof(ctx, "estimated tar size: %d", estimatedSize)
 				if estimatedSize > sizeLimit {
+					if jobState != "" {
+						return fmt.Errorf("%w: --state can't be used with an archive split by --size-limit", s3tar.ErrInvalidArgument)
+					}
 					archiveList := s3tar.BreakUpList(objectList, sizeLimit)
 					s3tar.Infof(ctx, "breaking up tar into %d parts", len(archiveList))
 					padWidth := getPadWidth(len(archiveList))
 					for i, archive := range archiveList {
 						fn := fmt.Sprintf("%s.%0*d.tar", archiveFile[:len(archiveFile)-4], padWidth, i)
 						s3tar.Infof(ctx, "creating %s", fn)
-						s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(fn)
-						s3opts.DstPrefix = filepath.Dir(s3opts.DstKey)
+						setDestination(s3opts, fn)
 						err := archiveClient.CreateFromList(ctx, archive, s3opts,
 							s3tar.WithStorageClass(storageClass),
 							s3tar.WithTarFormat(tarFormat),
-							s3tar.WithKMS(kmsKeyID, sseAlgo))
+							s3tar.WithKMS(kmsKeyID, sseAlgo),
+							s3tar.WithNameTransforms(nameTransforms...),
+							s3tar.WithNameCollisions(nameCollisions),
+							s3tar.WithHeaderTransforms(headerTransforms...),
+							s3tar.WithGrouping(grouping),
+							s3tar.WithPAXRecords(paxRecords...),
+							s3tar.WithSourceClient(srcSvc),
+							collectSummary)
 						if err != nil {
 							return err
 						}
+						created = append(created, fn)
 					}
 					return nil
 				} else {
-					return archiveClient.CreateFromList(ctx, objectList, s3opts,
+					err := archiveClient.CreateFromList(ctx, objectList, s3opts,
 						s3tar.WithStorageClass(storageClass),
 						s3tar.WithTarFormat(tarFormat),
-						s3tar.WithKMS(kmsKeyID, sseAlgo))
+						s3tar.WithKMS(kmsKeyID, sseAlgo),
+						s3tar.WithNameTransforms(nameTransforms...),
+						s3tar.WithNameCollisions(nameCollisions),
+						s3tar.WithHeaderTransforms(headerTransforms...),
+						s3tar.WithGrouping(grouping),
+						s3tar.WithPAXRecords(paxRecords...),
+						s3tar.WithSourceClient(srcSvc),
+						collectSummary)
+					if err != nil {
+						return err
+					}
+					created = append(created, archiveFile)
+					return nil
 				}
 
+			} else if resumeState != "" {
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				var summaries []*s3tar.RunSummary
+				err := s3tar.Resume(ctx, svc, resumeState,
+					s3tar.WithSourceClient(srcSvc),
+					s3tar.WithSummary(func(s *s3tar.RunSummary) {
+						summaries = append(summaries, s)
+					}),
+					func(o *s3tar.S3TarS3Options) {
+						o.Threads = threads
+					})
+				if len(summaries) > 0 {
+					reportSummaries(ctx, svc, jsonSummary, summaryLocation, summaries)
+				}
+				return err
 			} else if extract {
 
 				if archiveFile == "" {
@@ -390,33 +1716,121 @@ func run(args []string) error {
 				s3opts := &s3tar.S3TarS3Options{
 					Threads:               threads,
 					DeleteSource:          false,
-					Region:                region,
+					Region:                firstNonEmpty(dstRegion, region),
 					EndpointUrl:           endpointUrl,
 					ExternalToc:           externalToc,
 					PreservePOSIXMetadata: preservePosixMetadata,
+					PartTimeout:           partTimeout,
+					WindowsNames:          windowsNames,
+					OnConflict:            s3tar.ConflictPolicy(onConflict),
+					NameMap:               nameMap,
 				}
 				s3opts.SrcBucket, s3opts.SrcKey = s3tar.ExtractBucketAndPath(archiveFile)
 				s3opts.SrcPrefix = filepath.Dir(s3opts.SrcKey)
-				s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(destination)
-				s3opts.DstPrefix = filepath.Dir(s3opts.DstKey)
+				setDestination(s3opts, destination)
 				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				optFns := []func(*s3tar.S3TarS3Options){s3tar.WithExtractPrefix(prefix), s3tar.WithSourceClient(srcSvc)}
+				// --pattern, the size and time bounds scope the members
+				// extracted under the prefix
+				if len(patterns.Value()) > 0 || len(excludes.Value()) > 0 || searchMinSize != "" || searchMaxSize != "" || newerThan != "" || olderThan != "" {
+					filter, err := extractFilter(patterns.Value(), excludes.Value(), searchMinSize, searchMaxSize, newerThan, olderThan, time.Now())
+					if err != nil {
+						return err
+					}
+					optFns = append(optFns, s3tar.WithExtractFilter(filter))
+				}
 				archiveClient := newArchiveClient(svc)
-				return archiveClient.Extract(ctx, s3opts, s3tar.WithExtractPrefix(prefix))
+				return archiveClient.Extract(ctx, s3opts, optFns...)
 			} else if list {
+				if tocFormat != "csv" && tocFormat != "ndjson" {
+					return fmt.Errorf("%w: unknown --toc-format %q, csv or ndjson", s3tar.ErrInvalidArgument, tocFormat)
+				}
 				s3opts := &s3tar.S3TarS3Options{
 					Threads:      threads,
 					DeleteSource: false,
-					Region:       region,
+					Region:       firstNonEmpty(dstRegion, region),
 					EndpointUrl:  endpointUrl,
 					ExternalToc:  externalToc,
 				}
+				// --pattern, --exclude and the sizes search the toc, the
+				// members found are printed with their offsets
+				var err error
+				query := s3tar.TocQuery{Patterns: patterns.Value(), Exclude: excludes.Value()}
+				if searchMinSize != "" {
+					if query.MinSize, err = s3tar.ParseSize(searchMinSize); err != nil {
+						return err
+					}
+				}
+				if searchMaxSize != "" {
+					if query.MaxSize, err = s3tar.ParseSize(searchMaxSize); err != nil {
+						return err
+					}
+				}
 				archiveClient := newArchiveClient(svc)
-				toc, err := archiveClient.List(ctx, archiveFile, s3opts)
+				search := len(query.Patterns) > 0 || len(query.Exclude) > 0 || searchMinSize != "" || searchMaxSize != ""
+				listToc := func(archive string) (s3tar.TOC, error) {
+					toc, err := archiveClient.List(ctx, archive, s3opts, s3tar.WithSourceClient(srcSvc))
+					if err != nil || !search {
+						return toc, err
+					}
+					return s3tar.SearchToc(toc, query)
+				}
+				if indexFormat != "" || tocTable.Name != "" {
+					if indexFormat != "" && destination == "" {
+						exitError(5, "destination is missing")
+					}
+					// the archives after the flags are indexed with -f in
+					// the same SQLite index or DynamoDB table
+					archives := append([]string{archiveFile}, cCtx.Args().Slice()...)
+					var indexed []s3tar.IndexedArchive
+					members := 0
+					for _, archive := range archives {
+						toc, err := listToc(archive)
+						if err != nil {
+							return err
+						}
+						indexed = append(indexed, s3tar.IndexedArchive{Archive: archive, Toc: toc})
+						members += len(toc)
+					}
+					if search && members == 0 {
+						return fmt.Errorf("%w: no member of %s matches", s3tar.ErrNotFound, strings.Join(archives, ", "))
+					}
+					if indexFormat != "" {
+						if err := s3tar.WriteArchivesIndex(ctx, srcSvc, destination, indexed, s3tar.IndexFormat(indexFormat)); err != nil {
+							return err
+						}
+					}
+					if tocTable.Name != "" {
+						report, err := s3tar.ExportToc(ctx, srcSvc, tocTable, indexed, threads)
+						if err != nil {
+							return err
+						}
+						fmt.Printf("%d members of %d archives written to %s, %d skipped\n", report.Items, report.Archives, report.Table, report.Skipped)
+					}
+					return nil
+				}
+				toc, err := listToc(archiveFile)
 				if err != nil {
-					log.Fatal(err.Error())
+					return err
+				}
+				if search && len(toc) == 0 {
+					return fmt.Errorf("%w: no member of %s matches", s3tar.ErrNotFound, archiveFile)
+				}
+				if tocFormat == "ndjson" {
+					// a line per member, with the fields the TOC has
+					enc := json.NewEncoder(os.Stdout)
+					for _, f := range toc {
+						if err := enc.Encode(struct {
+							Archive string `json:"archive"`
+							*s3tar.FileMetadata
+						}{archiveFile, f}); err != nil {
+							return err
+						}
+					}
+					return nil
 				}
 				for _, f := range toc {
-					if extended {
+					if extended || search {
 						fmt.Printf("%s,%d,%d,%s\n", f.Filename, f.Start, f.Size, f.Etag)
 					} else {
 						fmt.Printf("%s\n", f.Filename)
@@ -428,19 +1842,129 @@ func run(args []string) error {
 				s3opts := &s3tar.S3TarS3Options{
 					Threads:      threads,
 					DeleteSource: false,
-					Region:       region,
+					Region:       firstNonEmpty(dstRegion, region),
 					EndpointUrl:  endpointUrl,
 					SrcBucket:    bucket,
 					SrcKey:       key,
 				}
-				err := s3tar.GenerateToc(ctx, svc, archiveFile, destination, s3opts)
+				err := s3tar.GenerateToc(ctx, srcSvc, archiveFile, destination, s3opts)
 				if err != nil {
-					log.Fatal(err.Error())
+					return err
+				}
+			} else if validate {
+				if archiveFile == "" {
+					exitError(5, "file is missing")
+				}
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				bucket, key := s3tar.ExtractBucketAndPath(archiveFile)
+				report, err := s3tar.ValidateArchive(ctx, srcSvc, bucket, key)
+				if err != nil {
+					if report != nil {
+						fmt.Printf("%s is corrupt at offset %d: %s\n", archiveFile, report.CorruptOffset, report.Problem)
+					}
+					return err
+				}
+				if report.Format != "" {
+					fmt.Printf("%s is a valid %s tar archive: %d members", archiveFile, report.Format, report.Members)
+				} else {
+					fmt.Printf("%s is valid: %d members", archiveFile, report.Members)
 				}
+				if report.TocMembers > 0 {
+					fmt.Printf(", %d listed in toc.csv", report.TocMembers)
+				}
+				fmt.Printf("\n")
+				if verifySignature != "" {
+					signed, err := s3tar.VerifyTocSignature(ctx, srcSvc, bucket, key, verifySignature)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("the TOC of %s is signed by %s on %s\n", archiveFile, verifySignature, signed.SignedAt.Format(time.RFC3339))
+				}
+			} else if salvage {
+				if archiveFile == "" {
+					exitError(5, "file is missing")
+				}
+				if destination == "" {
+					exitError(5, "destination is missing")
+				}
+				if destination[len(destination)-1] != '/' {
+					destination = destination + "/"
+				}
+				s3opts := &s3tar.S3TarS3Options{
+					Threads:               threads,
+					Region:                firstNonEmpty(dstRegion, region),
+					EndpointUrl:           endpointUrl,
+					PreservePOSIXMetadata: preservePosixMetadata,
+					WindowsNames:          windowsNames,
+					OnConflict:            s3tar.ConflictPolicy(onConflict),
+					NameMap:               nameMap,
+				}
+				s3opts.SrcBucket, s3opts.SrcKey = s3tar.ExtractBucketAndPath(archiveFile)
+				setDestination(s3opts, destination)
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				report, err := s3tar.Salvage(ctx, svc, s3opts, recoveryReport, s3tar.WithSourceClient(srcSvc))
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%d members extracted, %d recoverable members after the corruption\n", len(report.Extracted), len(report.Recoverable))
+			} else if recompress {
+				if archiveFile == "" {
+					exitError(5, "file is missing")
+				}
+				if destination == "" {
+					destination = archiveFile + ".zst"
+				}
+				s3opts := &s3tar.S3TarS3Options{
+					Threads:          threads,
+					Region:           firstNonEmpty(dstRegion, region),
+					EndpointUrl:      endpointUrl,
+					ObjectTags:       tagSet,
+					Provider:         provider,
+					CompressionLevel: compressionLevel,
+				}
+				s3opts.SrcBucket, s3opts.SrcKey = s3tar.ExtractBucketAndPath(archiveFile)
+				s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(destination)
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				report, err := s3tar.Recompress(ctx, svc, s3opts,
+					s3tar.WithSourceClient(srcSvc),
+					s3tar.WithStorageClass(storageClass),
+					s3tar.WithKMS(kmsKeyID, sseAlgo),
+				)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s recompressed to %s: %d bytes to %d, %d frames\n", report.Archive, report.Destination, report.Size, report.CompressedSize, report.Frames)
+			} else if convert {
+				if archiveFile == "" {
+					exitError(5, "file is missing")
+				}
+				if destination == "" {
+					destination = convertedName(archiveFile, convertTo)
+				}
+				s3opts := &s3tar.S3TarS3Options{
+					Threads:     threads,
+					Region:      firstNonEmpty(dstRegion, region),
+					EndpointUrl: endpointUrl,
+					ObjectTags:  tagSet,
+					Provider:    provider,
+					ZipStore:    zipStore,
+				}
+				s3opts.SrcBucket, s3opts.SrcKey = s3tar.ExtractBucketAndPath(archiveFile)
+				s3opts.DstBucket, s3opts.DstKey = s3tar.ExtractBucketAndPath(destination)
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				report, err := s3tar.Convert(ctx, svc, s3opts, convertTo,
+					s3tar.WithSourceClient(srcSvc),
+					s3tar.WithStorageClass(storageClass),
+					s3tar.WithKMS(kmsKeyID, sseAlgo),
+				)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s converted to %s: %d members, %d bytes\n", report.Archive, report.Destination, report.Members, report.Size)
 			} else if generateManifest {
 				bucket, prefix := s3tar.ExtractBucketAndPath(archiveFile)
 
-				objectList, _, err := s3tar.ListAllObjects(ctx, svc, bucket, prefix)
+				objectList, _, err := s3tar.ListAllObjects(ctx, srcSvc, bucket, prefix)
 				if err != nil {
 					log.Fatal(err.Error())
 				}
@@ -461,6 +1985,160 @@ func run(args []string) error {
 						return err
 					}
 				}
+			} else if estimate {
+				src := cCtx.Args().First()
+				s3opts := &s3tar.S3TarS3Options{
+					SrcManifest:           manifestPath,
+					SkipManifestHeader:    skipManifestHeader,
+					ConcatInMemory:        concatInMemory,
+					UrlDecode:             urlDecode,
+					UserMaxPartSize:       userPartMaxSize,
+					UserMinPartSize:       userPartMinSize,
+					MPUThreshold:          mpuThreshold,
+					PreservePOSIXMetadata: preservePosixMetadata,
+					RecordOrigin:          recordOrigin,
+				}
+				if len(srcs.Value()) > 0 {
+					sources, err := sourcesOf(src)
+					if err != nil {
+						return err
+					}
+					s3opts.Sources = sources
+				} else if s3tar.IsLocalURL(src) {
+					s3opts.SrcPath = s3tar.LocalPath(src)
+				} else {
+					s3opts.SrcBucket, s3opts.SrcPrefix = s3tar.ExtractBucketAndPath(src)
+				}
+				if s3opts.SrcBucket == "" && s3opts.SrcPath == "" && len(s3opts.Sources) == 0 && manifestPath == "" {
+					exitError(4, "source directory or manifest file is required.\n")
+				}
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+
+				var objectList []*s3tar.S3Obj
+				var err error
+				if s3opts.SrcManifest != "" {
+					objectList, _, err = loadCSV(ctx, srcSvc, s3opts.SrcManifest, s3opts.SkipManifestHeader, s3opts.UrlDecode)
+				} else if len(s3opts.Sources) > 0 {
+					objectList, _, err = s3tar.ListSources(ctx, srcSvc, s3opts.Sources, flat, s3opts.DeleteMarkers)
+				} else if s3opts.SrcPath != "" {
+					objectList, _, err = s3tar.ListLocalFiles(ctx, s3opts.SrcPath, flat)
+				} else {
+					objectList, _, err = listSource(ctx, srcSvc, s3opts.SrcBucket, s3opts.SrcPrefix, flat, s3opts.DeleteMarkers)
+				}
+				if err != nil {
+					return err
+				}
+				e, err := s3tar.EstimateArchive(ctx, objectList, s3opts,
+					s3tar.WithTarFormat(tarFormat),
+					s3tar.WithKMS(kmsKeyID, sseAlgo),
+					s3tar.WithNameTransforms(nameTransforms...),
+					s3tar.WithNameCollisions(nameCollisions),
+					s3tar.WithHeaderTransforms(headerTransforms...),
+					s3tar.WithGrouping(grouping),
+					s3tar.WithPAXRecords(paxRecords...))
+				if err != nil {
+					return err
+				}
+				printEstimate(e)
+			} else if interactive {
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				newOptions := func(inMemory bool) *s3tar.S3TarS3Options {
+					return &s3tar.S3TarS3Options{
+						Threads:               threads,
+						Region:                firstNonEmpty(dstRegion, region),
+						EndpointUrl:           endpointUrl,
+						ConcatInMemory:        inMemory,
+						UserMaxPartSize:       userPartMaxSize,
+						UserMinPartSize:       userPartMinSize,
+						MPUThreshold:          mpuThreshold,
+						ObjectTags:            tagSet,
+						PreservePOSIXMetadata: preservePosixMetadata,
+						RecordOrigin:          recordOrigin,
+						OnError:               s3tar.ErrorPolicy(onError),
+						OnChange:              s3tar.ChangePolicy(onChange),
+						OnArchived:            s3tar.ArchivedPolicy(onArchived),
+						NoClobber:             noClobber,
+						Lock:                  s3tar.LockMode(lock),
+						Idempotent:            idempotent,
+						Overwrite:             s3tar.OverwritePolicy(overwrite),
+						ServerSideOnly:        serverSideOnly,
+						HybridThreshold:       hybridThreshold * 1024 * 1024,
+						PartRetries:           partRetries,
+						ObjectTimeout:         objectTimeout,
+						RangeSize:             rangeSize * 1024 * 1024,
+						RangeConcurrency:      rangeConcurrency,
+						RangeBigMembers:       rangeBigMembers,
+						MaxBufferedParts:      maxBufferedParts,
+						Preflight:             s3tar.PreflightMode(preflight),
+						TocChecksums:          tocChecksums,
+						TocExtended:           tocExtended,
+						SignTocKeyID:          signToc,
+						TocTable:              tocTable,
+						Provenance:            provenance,
+						ToolVersion:           VersionMsg,
+						Prefetch:              prefetch,
+						TinyPrefetch:          tinyPrefetch,
+						PartPadding:           s3tar.PartPadding(partPadding),
+						PartOrder:             s3tar.PartOrder(partOrder),
+						Provider:              provider,
+						Accelerate:            accelerate,
+						DeleteMarkers:         s3tar.DeleteMarkerPolicy(deleteMarkers),
+						PartTimeout:           partTimeout,
+						Scratch:               scratch,
+						KeepScratch:           keepScratch,
+						RunID:                 runID,
+					}
+				}
+				session := &interactiveSession{
+					ctx:      ctx,
+					svc:      srcSvc,
+					out:      os.Stdout,
+					inMemory: concatInMemory,
+					estimate: func(objectList []*s3tar.S3Obj, inMemory bool) (*s3tar.Estimate, error) {
+						return s3tar.EstimateArchive(ctx, objectList, newOptions(inMemory),
+							s3tar.WithTarFormat(tarFormat),
+							s3tar.WithKMS(kmsKeyID, sseAlgo),
+							s3tar.WithNameTransforms(nameTransforms...),
+							s3tar.WithNameCollisions(nameCollisions),
+							s3tar.WithHeaderTransforms(headerTransforms...),
+							s3tar.WithGrouping(grouping),
+							s3tar.WithPAXRecords(paxRecords...))
+					},
+					create: func(objectList []*s3tar.S3Obj, dst string, inMemory bool) error {
+						s3opts := newOptions(inMemory)
+						setDestination(s3opts, dst)
+						s3opts.SrcBucket = objectList[0].Bucket
+						return newArchiveClient(svc).CreateFromList(ctx, objectList, s3opts,
+							s3tar.WithStorageClass(storageClass),
+							s3tar.WithTarFormat(tarFormat),
+							s3tar.WithKMS(kmsKeyID, sseAlgo),
+							s3tar.WithNameTransforms(nameTransforms...),
+							s3tar.WithNameCollisions(nameCollisions),
+							s3tar.WithHeaderTransforms(headerTransforms...),
+							s3tar.WithGrouping(grouping),
+							s3tar.WithPAXRecords(paxRecords...),
+							s3tar.WithSourceClient(srcSvc))
+					},
+				}
+				return session.run(os.Stdin)
+			} else if serve != "" {
+				if archiveFile == "" {
+					exitError(5, "file is missing")
+				}
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				bucket, prefix := s3tar.ExtractBucketAndPath(archiveFile)
+				return s3tar.ServeArchives(ctx, srcSvc, serve, bucket, prefix)
+			} else if mountDir != "" {
+				if archiveFile == "" {
+					exitError(5, "file is missing")
+				}
+				ctx = s3tar.SetLogLevel(ctx, logLevel)
+				bucket, key := s3tar.ExtractBucketAndPath(archiveFile)
+				archive, err := s3tar.OpenArchive(ctx, srcSvc, bucket, key)
+				if err != nil {
+					return err
+				}
+				return mountArchive(ctx, archive, mountDir, archiveFile)
 			} else {
 				exitError(3, "operation not implemented, provide create or extract flag\n")
 			}
@@ -468,10 +2146,22 @@ func run(args []string) error {
 		},
 	}
 
-	return app.Run(args)
+	if len(args) > 2 && args[1] == completeCommand {
+		return complete(ctx, app, args[2], args[3:], os.Stdout)
+	}
+
+	err = app.Run(args)
+	if logOutput != nil {
+		// the error the run ends with is in the log too
+		if err != nil {
+			s3tar.Errorf(ctx, "%s", err.Error())
+		}
+		logOutput.Close()
+	}
+	return err
 }
 
-func s3Client(ctx context.Context, opts ...func(*config.LoadOptions) error) *s3.Client {
+func s3Client(ctx context.Context, provider string, role *roleOptions, audit *s3tar.AuditLog, budget *s3tar.Budget, opts ...func(*config.LoadOptions) error) *s3.Client {
 
 	uaVersion := Version
 	if uaVersion == "0.0.0" { // Version is set at compile time
@@ -479,6 +2169,17 @@ func s3Client(ctx context.Context, opts ...func(*config.LoadOptions) error) *s3.
 	}
 	ua := func(options *s3.Options) {
 		options.APIOptions = append(options.APIOptions, middleware.AddUserAgentKeyValue("s3tar", Version))
+		// access point ARNs, e.g. of Object Lambda sources, carry their own region
+		options.UseARNRegion = true
+		if p, err := s3tar.ProviderProfile(provider); provider != "" && err == nil {
+			p.ClientOptions(options)
+		}
+		if audit != nil {
+			audit.ClientOptions(options)
+		}
+		if budget != nil {
+			budget.ClientOptions(options)
+		}
 	}
 
 	cfg, err := config.LoadDefaultConfig(ctx, opts...)
@@ -486,6 +2187,14 @@ func s3Client(ctx context.Context, opts ...func(*config.LoadOptions) error) *s3.
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	if role != nil && role.arn != "" {
+		// assume the role before anything is read or written, so a role that
+		// can't be assumed fails the run right away
+		role.assume(&cfg)
+		if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+			log.Fatalf("assuming %s: %s", role.arn, err.Error())
+		}
+	}
 	return s3.NewFromConfig(cfg, ua)
 
 }
@@ -515,6 +2224,243 @@ func exitError(code int, format string, v ...any) {
 	os.Exit(code)
 }
 
+func reportSummaries(ctx context.Context, svc *s3.Client, print bool, location string, summaries []*s3tar.RunSummary) {
+	if print {
+		enc := json.NewEncoder(os.Stdout)
+		for _, s := range summaries {
+			if err := enc.Encode(s); err != nil {
+				log.Print(err.Error())
+			}
+		}
+	}
+	if location != "" {
+		if err := s3tar.WriteSummary(ctx, svc, location, summaries...); err != nil {
+			log.Printf("unable to write summary to %s: %s", location, err.Error())
+		}
+	}
+}
+
+func printEstimate(e *s3tar.Estimate) {
+	mode := "server-side concat"
+	if e.InMemory {
+		mode = "concat-in-memory"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "objects:\t%d\n", e.Objects)
+	fmt.Fprintf(w, "mode:\t%s\n", mode)
+	fmt.Fprintf(w, "data:\t%d\n", e.DataSize)
+	fmt.Fprintf(w, "headers:\t%d\n", e.HeaderSize)
+	fmt.Fprintf(w, "padding:\t%d\n", e.PaddingSize)
+	fmt.Fprintf(w, "toc:\t%d\n", e.TocSize)
+	fmt.Fprintf(w, "eof:\t%d\n", e.EOFSize)
+	fmt.Fprintf(w, "total size:\t%d\n", e.TotalSize)
+	fmt.Fprintf(w, "part size:\t%d\n", e.PartSize)
+	fmt.Fprintf(w, "parts:\t%d\n", e.Parts)
+	if e.PlanDigest != "" {
+		fmt.Fprintf(w, "plan digest:\t%s\n", e.PlanDigest)
+	}
+	r := e.Requests
+	fmt.Fprintf(w, "list requests:\t%d\n", r.List)
+	fmt.Fprintf(w, "get requests:\t%d\n", r.Get)
+	fmt.Fprintf(w, "head requests:\t%d\n", r.Head)
+	fmt.Fprintf(w, "put requests:\t%d\n", r.Put)
+	fmt.Fprintf(w, "create/complete mpu:\t%d/%d\n", r.CreateMultipartUpload, r.CompleteMultipartUpload)
+	fmt.Fprintf(w, "upload part:\t%d\n", r.UploadPart)
+	fmt.Fprintf(w, "upload part copy:\t%d\n", r.UploadPartCopy)
+	fmt.Fprintf(w, "kms requests:\t%d\n", r.KMS)
+	fmt.Fprintf(w, "source bytes:\t%d\n", r.TransferBytes)
+	fmt.Fprintf(w, "request cost:\t$%.4f\n", r.RequestCost(s3tar.DefaultPricing))
+	fmt.Fprintf(w, "inter-region transfer cost:\t$%.4f (only if the source is in another region)\n", r.TransferCost(s3tar.DefaultPricing))
+	w.Flush()
+}
+
+func printBench(r *s3tar.BenchReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "run id:\t%s\n", r.RunID)
+	fmt.Fprintf(w, "objects:\t%d\n", r.Objects)
+	fmt.Fprintf(w, "data:\t%d\n", r.Bytes)
+	fmt.Fprintf(w, "archive size:\t%d\n", r.ArchiveSize)
+	fmt.Fprintf(w, "generated in:\t%.1fs\n", r.GenerateSeconds)
+	fmt.Fprintf(w, "archived in:\t%.1fs\n", r.DurationSeconds)
+	fmt.Fprintf(w, "throughput:\t%.1f MiB/s, %.0f objects/s\n", r.MiBPerSecond, r.ObjectsPerSecond)
+	fmt.Fprintf(w, "retries:\t%d\n", r.Retries)
+	fmt.Fprintf(w, "peak heap:\t%d\n", r.PeakHeapBytes)
+	methods := make([]string, 0, len(r.Requests))
+	for method := range r.Requests {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		l := r.Requests[method]
+		fmt.Fprintf(w, "%s requests:\t%d, p50 %.1fms p90 %.1fms p99 %.1fms max %.1fms\n", method, l.Count, l.P50, l.P90, l.P99, l.Max)
+	}
+	w.Flush()
+}
+
+func printProbe(r *s3tar.ProbeReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range r.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Status, c.Check, c.Target, c.Detail)
+	}
+	w.Flush()
+	fmt.Printf("%d problems, %d warnings\n", r.Problems, r.Warnings)
+}
+
+// listSource lists the objects of the source prefix, everything under it or
+// with flat only the objects at its level. It warns before anything is created
+// when the prefix matches nothing, or when a prefix without a trailing slash
+// also matches keys next to it, like logs matching logs-old/.
+func listSource(ctx context.Context, svc *s3.Client, bucket, prefix string, flat bool, deleteMarkers s3tar.DeleteMarkerPolicy) ([]*s3tar.S3Obj, int64, error) {
+	var filterFns []func(types.Object) bool
+	if flat {
+		filterFns = append(filterFns, s3tar.FlatFilter(prefix))
+	}
+	list := listAllObjects
+	if deleteMarkers != s3tar.DeleteMarkersOff {
+		list = func(ctx context.Context, client *s3.Client, bucket, prefix string, filterFns ...func(types.Object) bool) ([]*s3tar.S3Obj, int64, error) {
+			return s3tar.ListAllObjectVersions(ctx, client, bucket, prefix, deleteMarkers, filterFns...)
+		}
+	}
+	objectList, estimatedSize, err := list(ctx, svc, bucket, prefix, filterFns...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(objectList) == 0 {
+		s3tar.Warnf(ctx, "s3://%s/%s matched zero objects", bucket, prefix)
+		return objectList, estimatedSize, nil
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		outside := 0
+		for _, o := range objectList {
+			if !strings.HasPrefix(*o.Key, prefix+"/") {
+				outside++
+			}
+		}
+		if outside > 0 {
+			s3tar.Warnf(ctx, "prefix %q has no trailing slash and matches %d objects outside %s/", prefix, outside, prefix)
+		}
+	}
+	return objectList, estimatedSize, nil
+}
+
+// extractFilter returns the filter of -x from the values of --pattern,
+// --exclude, --min-size, --max-size, --newer-than and --older-than.
+func extractFilter(patterns, excludes []string, minSize, maxSize, newerThan, olderThan string, now time.Time) (s3tar.ExtractFilter, error) {
+	filter := s3tar.ExtractFilter{TocQuery: s3tar.TocQuery{Patterns: patterns, Exclude: excludes}}
+	var err error
+	if minSize != "" {
+		if filter.MinSize, err = s3tar.ParseSize(minSize); err != nil {
+			return filter, err
+		}
+	}
+	if maxSize != "" {
+		if filter.MaxSize, err = s3tar.ParseSize(maxSize); err != nil {
+			return filter, err
+		}
+	}
+	if newerThan != "" {
+		if filter.NewerThan, err = s3tar.ParseTimeBound(newerThan, now); err != nil {
+			return filter, err
+		}
+	}
+	if olderThan != "" {
+		if filter.OlderThan, err = s3tar.ParseTimeBound(olderThan, now); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// setDestination points opts to dst, an s3:// url or a local file given as
+// file:///path.
+func setDestination(opts *s3tar.S3TarS3Options, dst string) {
+	if s3tar.IsLocalURL(dst) {
+		opts.DstBucket, opts.DstKey, opts.DstPrefix = "", "", ""
+		opts.DstPath = s3tar.LocalPath(dst)
+		return
+	}
+	opts.DstBucket, opts.DstKey = s3tar.ExtractBucketAndPath(dst)
+	opts.DstPrefix = filepath.Dir(opts.DstKey)
+}
+
+// convertedName is the default url of the archive at src converted to
+// format: its name with the extension of the format instead of its own.
+func convertedName(src, format string) string {
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tgz", ".tar"} {
+		if strings.HasSuffix(src, ext) {
+			return strings.TrimSuffix(src, ext) + "." + format
+		}
+	}
+	return src + "." + format
+}
+
+// newBudget returns the budget of the transfers of the run, nil without
+// limits.
+func newBudget(downloads, uploads int, downloadRate, uploadRate string) (*s3tar.Budget, error) {
+	opts := s3tar.BudgetOptions{Downloads: downloads, Uploads: uploads}
+	for _, r := range []struct {
+		flag  string
+		value string
+		rate  *int64
+	}{{"--download-rate", downloadRate, &opts.DownloadRate}, {"--upload-rate", uploadRate, &opts.UploadRate}} {
+		if r.value == "" {
+			continue
+		}
+		rate, err := s3tar.ParseSize(r.value)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%w: %s %q", s3tar.ErrInvalidArgument, r.flag, r.value)
+		}
+		*r.rate = rate
+	}
+	if opts.Downloads < 0 || opts.Uploads < 0 {
+		return nil, fmt.Errorf("%w: --max-downloads and --max-uploads can't be negative", s3tar.ErrInvalidArgument)
+	}
+	if opts == (s3tar.BudgetOptions{}) {
+		return nil, nil
+	}
+	return s3tar.NewBudget(opts), nil
+}
+
+// drainOnSignal returns the channel closed on the first SIGINT or SIGTERM,
+// the second one cancels the run, and the func that closes it with a reason
+// logged.
+func drainOnSignal(ctx context.Context, cancel context.CancelFunc) (<-chan struct{}, func(reason string)) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	drain := make(chan struct{})
+	var once sync.Once
+	stop := func(reason string) {
+		once.Do(func() {
+			s3tar.Warnf(ctx, "%s", reason)
+			close(drain)
+		})
+	}
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		stop("stopping, an archive built in memory finishes the parts being uploaded first. Interrupt again to stop at once")
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return drain, stop
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 func getPadWidth(length int) int {
 	padWidth := len(strconv.Itoa(length))
 	if padWidth == 1 {
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+	"github.com/urfave/cli/v2"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -159,7 +165,70 @@ func Test_cli(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:               "create-src-profile-server-side",
+			archiveInitializer: newMockArchive,
+			listObjFun:         mockListAllObjects,
+			listObjManifest:    mockLoadCSV,
+			args:               args{[]string{firstArgs, "--region", testRegion, "--src-profile", "source", "-cf", dstPath, srcPath}},
+			wantErr:            true,
+		},
+		{
+			name:               "extract-src-profile-s3-destination",
+			archiveInitializer: newMockArchive,
+			listObjFun:         mockListAllObjects,
+			listObjManifest:    mockLoadCSV,
+			args:               args{[]string{firstArgs, "--region", testRegion, "--src-profile", "source", "-xf", dstPath, "-C", srcPath}},
+			wantErr:            true,
+		},
+		{
+			name:               "extract-src-profile-local-destination",
+			archiveInitializer: newMockArchive,
+			listObjFun:         mockListAllObjects,
+			listObjManifest:    mockLoadCSV,
+			args:               args{[]string{firstArgs, "--region", testRegion, "--src-profile", "source", "-xf", dstPath, "-C", "file:///tmp/extract/"}},
+		},
+		{
+			name:               "create-name-collisions",
+			archiveInitializer: newMockArchive,
+			listObjFun:         mockListAllObjects,
+			listObjManifest:    mockLoadCSV,
+			args:               args{[]string{firstArgs, "--region", testRegion, "--name-case", "lower", "--name-collisions", "suffix", "-cf", dstPath, srcPath}},
+		},
+		{
+			name:               "create-flatten-and-name-collisions",
+			archiveInitializer: newMockArchive,
+			listObjFun:         mockListAllObjects,
+			listObjManifest:    mockLoadCSV,
+			args:               args{[]string{firstArgs, "--region", testRegion, "--flatten", "--flatten-collisions", "suffix", "--name-case", "lower", "--name-collisions", "hash", "-cf", dstPath, srcPath}},
+			wantErr:            true,
+		},
+		{
+			name:               "create-on-archived-and-skip-archived",
+			archiveInitializer: newMockArchive,
+			listObjFun:         mockListAllObjects,
+			listObjManifest:    mockLoadCSV,
+			args:               args{[]string{firstArgs, "--region", testRegion, "--on-archived", "skip", "--skip-archived", "s3://dst-bucket/old.tar", "-cf", dstPath, srcPath}},
+			wantErr:            true,
+		},
+		{
+			name:               "list-ndjson",
+			archiveInitializer: newMockArchive,
+			args:               args{[]string{firstArgs, "--region", testRegion, "-tf", dstPath, "--toc-format", "ndjson"}},
+		},
+		{
+			name:               "list-unknown-toc-format",
+			archiveInitializer: newMockArchive,
+			args:               args{[]string{firstArgs, "--region", testRegion, "-tf", dstPath, "--toc-format", "xml"}},
+			wantErr:            true,
+		},
+	}
+	// the profile given to --src-profile
+	config := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(config, []byte("[profile source]\nregion = us-west-2\n"), 0600); err != nil {
+		t.Fatal(err)
 	}
+	t.Setenv("AWS_CONFIG_FILE", config)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			newArchiveClient = tt.archiveInitializer
@@ -184,3 +253,211 @@ type TestFile struct {
 	Key    string
 	Size   int
 }
+
+func Test_complete(t *testing.T) {
+	tests := []struct {
+		name string
+		cur  string
+		want string
+	}{
+		{name: "long-flag", cur: "--src-r", want: "--src-region\n"},
+		{name: "short-flag", cur: "-C", want: "-C\n"},
+		{name: "scheme", cur: "s3", want: "s3://\n"},
+		{name: "local-path", cur: "/tmp", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := &cli.App{Flags: []cli.Flag{
+				&cli.StringFlag{Name: "src-region"},
+				&cli.StringFlag{Name: "location", Aliases: []string{"C"}},
+			}}
+			if err := complete(context.Background(), app, tt.cur, nil, &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("complete() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_interactiveSession_cd(t *testing.T) {
+	s := &interactiveSession{out: &bytes.Buffer{}}
+	steps := []struct {
+		arg  string
+		want string
+	}{
+		{arg: "bucket", want: "s3://bucket/"},
+		{arg: "logs/", want: "s3://bucket/logs/"},
+		{arg: "2024", want: "s3://bucket/logs/2024/"},
+		{arg: "..", want: "s3://bucket/logs/"},
+		{arg: "..", want: "s3://bucket/"},
+		{arg: "..", want: "s3://"},
+		{arg: "s3://other/data/", want: "s3://other/data/"},
+		{arg: "/", want: "s3://"},
+	}
+	for _, step := range steps {
+		s.cd(step.arg)
+		if got := s.location(); got != step.want {
+			t.Errorf("cd %s = %s, want %s", step.arg, got, step.want)
+		}
+	}
+}
+
+func Test_exitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: fmt.Errorf("%w: s3://b/k", s3tar.ErrAccessDenied), want: exitAccessDenied},
+		{err: fmt.Errorf("wrapped: %w", s3tar.ErrTooManyParts), want: exitTooManyParts},
+		{err: &s3tar.ObjectError{Bucket: "b", Key: "k", Err: s3tar.ErrSourceChanged}, want: exitSourceChanged},
+		{err: s3tar.ErrUnableToAccess, want: exitNotFound},
+		{err: &s3tar.ObjectError{Bucket: "b", Key: "k", Err: s3tar.ErrChecksumMismatch}, want: exitChecksumMismatch},
+		{err: &s3tar.ObjectError{Bucket: "b", Key: "k", Err: s3tar.ErrDestinationExists}, want: exitDestinationExists},
+		{err: &s3tar.ObjectError{Bucket: "b", Key: "k", Err: s3tar.ErrSourceLocked}, want: exitSourceLocked},
+		{err: &s3tar.ObjectError{Bucket: "b", Key: "k", Err: s3tar.ErrObjectArchived}, want: exitObjectArchived},
+		{err: fmt.Errorf("%w: 3 objects", s3tar.ErrRestorePending), want: exitRestorePending},
+		{err: fmt.Errorf("%w: the TOC changed", s3tar.ErrInvalidSignature), want: exitInvalidSignature},
+		{err: fmt.Errorf("%w: \"../etc/passwd\" climbs out of the destination", s3tar.ErrUnsafeName), want: exitUnsafeName},
+		{err: fmt.Errorf("%w: s3://bucket/a.zip is a zip archive", s3tar.ErrUnsupportedFormat), want: exitUnsupportedFormat},
+		{err: fmt.Errorf("%w: resume it with --resume s3://bucket/a.tar.state/", s3tar.ErrResumable), want: exitResumable},
+		{err: fmt.Errorf("other"), want: exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func Test_convertedName(t *testing.T) {
+	tests := map[string]struct {
+		src  string
+		want string
+	}{
+		"tar":        {src: "s3://bucket/a.tar", want: "s3://bucket/a.zip"},
+		"gzip":       {src: "s3://bucket/a.tar.gz", want: "s3://bucket/a.zip"},
+		"tgz":        {src: "s3://bucket/a.tgz", want: "s3://bucket/a.zip"},
+		"zstd":       {src: "s3://bucket/dir/a.tar.zst", want: "s3://bucket/dir/a.zip"},
+		"no tar ext": {src: "s3://bucket/backup", want: "s3://bucket/backup.zip"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := convertedName(tt.src, "zip"); got != tt.want {
+				t.Errorf("convertedName(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_roleOptions_validate(t *testing.T) {
+	tests := map[string]struct {
+		role    roleOptions
+		wantErr bool
+	}{
+		"none":                      {role: roleOptions{}},
+		"assume role":               {role: roleOptions{arn: "arn:aws:iam::123456789012:role/r", externalID: "id", sessionTags: []string{"team=data", "job="}}},
+		"web identity":              {role: roleOptions{arn: "arn:aws:iam::123456789012:role/r", webIdentityTokenFile: "/var/run/token"}},
+		"external id without arn":   {role: roleOptions{externalID: "id"}, wantErr: true},
+		"token file without arn":    {role: roleOptions{webIdentityTokenFile: "/var/run/token"}, wantErr: true},
+		"tags with web identity":    {role: roleOptions{arn: "arn:aws:iam::123456789012:role/r", webIdentityTokenFile: "/var/run/token", sessionTags: []string{"team=data"}}, wantErr: true},
+		"tag without value":         {role: roleOptions{arn: "arn:aws:iam::123456789012:role/r", sessionTags: []string{"team"}}, wantErr: true},
+		"external id with identity": {role: roleOptions{arn: "arn:aws:iam::123456789012:role/r", webIdentityTokenFile: "/var/run/token", externalID: "id"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.role.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, s3tar.ErrInvalidArgument) {
+				t.Errorf("validate() error = %v, want ErrInvalidArgument", err)
+			}
+		})
+	}
+}
+
+func Test_runJobs(t *testing.T) {
+	spec, err := parseJobSpec([]byte(`{"jobs": [
+		{"source": "s3://bucket/a/", "destination": "s3://bucket/a.tar"},
+		{"name": "b", "manifest": "b.csv", "destination": "s3://bucket/b.tar", "concat_in_memory": true, "goroutines": 20}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Jobs[0].Name != "s3://bucket/a.tar" || !*spec.Jobs[1].ConcatInMemory || spec.Jobs[0].Goroutines != 0 || spec.Jobs[1].Goroutines != 20 {
+		t.Fatalf("parseJobSpec() = %+v %+v", spec.Jobs[0], spec.Jobs[1])
+	}
+	report, err := runJobs(context.Background(), spec.Jobs, 2, func(j *job) (*s3tar.RunSummary, error) {
+		if j.Name == "b" {
+			return nil, s3tar.ErrAccessDenied
+		}
+		return &s3tar.RunSummary{Key: "a.tar"}, nil
+	})
+	if exitCode(err) != exitAccessDenied {
+		t.Errorf("runJobs() error = %v, want access denied", err)
+	}
+	if report.Succeeded != 1 || report.Failed != 1 || report.Jobs[0].Status != "succeeded" || report.Jobs[1].Status != "failed" {
+		t.Errorf("runJobs() report = %+v", report)
+	}
+
+	if _, err := parseJobSpec([]byte(`{"jobs": [{"source": "s3://bucket/a/"}]}`)); exitCode(err) != exitInvalidArgument {
+		t.Errorf("parseJobSpec() without destination error = %v", err)
+	}
+
+	yamlSpec, err := parseJobSpec([]byte(`jobs:
+  - source: s3://bucket/a/
+    destination: s3://bucket/a.tar
+  - name: b
+    manifest: b.csv
+    destination: s3://bucket/b.tar
+    concat_in_memory: true
+    goroutines: 20
+`))
+	if err != nil {
+		t.Fatalf("parseJobSpec(yaml) error = %v", err)
+	}
+	if !reflect.DeepEqual(yamlSpec, spec) {
+		t.Errorf("parseJobSpec(yaml) = %+v, want %+v", yamlSpec.Jobs[1], spec.Jobs[1])
+	}
+	if _, err := parseJobSpec([]byte("jobs:\n  - source: s3://bucket/a/\n    destination: s3://bucket/a.tar\n    unknown: 1\n")); exitCode(err) != exitInvalidArgument {
+		t.Errorf("parseJobSpec(yaml) with an unknown field error = %v", err)
+	}
+
+	location := filepath.Join(t.TempDir(), "report.json")
+	if err := writeJobReport(context.Background(), nil, location, report); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(location); err != nil || !strings.Contains(string(data), `"failed": 1`) {
+		t.Errorf("writeJobReport() wrote %q, %v", data, err)
+	}
+}
+
+func Test_newBudget(t *testing.T) {
+	tests := map[string]struct {
+		downloads, uploads int
+		downloadRate       string
+		uploadRate         string
+		wantNil            bool
+		wantErr            error
+	}{
+		"no limits":    {wantNil: true},
+		"limits":       {downloads: 64, uploads: 16, downloadRate: "1GB", uploadRate: "200MiB"},
+		"invalid rate": {uploadRate: "fast", wantErr: s3tar.ErrInvalidArgument},
+		"zero rate":    {downloadRate: "0", wantErr: s3tar.ErrInvalidArgument},
+		"negative max": {downloads: -1, wantErr: s3tar.ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			budget, err := newBudget(tt.downloads, tt.uploads, tt.downloadRate, tt.uploadRate)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("newBudget() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (budget == nil) != tt.wantNil {
+				t.Errorf("newBudget() = %v, want nil %t", budget, tt.wantNil)
+			}
+		})
+	}
+}
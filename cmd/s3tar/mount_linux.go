@@ -0,0 +1,130 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"syscall"
+	"time"
+
+	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// mountArchive mounts the members of archive read-only on dir until ctx is
+// done or dir is unmounted.
+func mountArchive(ctx context.Context, archive *s3tar.ArchiveFS, dir, name string) error {
+	root := &mountDir{}
+	root.archive, root.modTime = archive, archiveModTime(archive)
+	server, err := fusefs.Mount(dir, root, &fusefs.Options{
+		MountOptions: fuse.MountOptions{FsName: name, Name: "s3tar", Options: []string{"ro"}},
+	})
+	if err != nil {
+		return fmt.Errorf("mounting %s: %w", dir, err)
+	}
+	s3tar.Infof(ctx, "mounted %s on %s, unmount it or press Ctrl-C to stop", name, dir)
+	go func() {
+		<-ctx.Done()
+		if err := server.Unmount(); err != nil {
+			s3tar.Warnf(ctx, "unmounting %s: %s", dir, err)
+		}
+	}()
+	server.Wait()
+	return nil
+}
+
+// mountDir is a directory of a mounted archive. The root adds every member
+// and directory of the archive when it's mounted.
+type mountDir struct {
+	fusefs.Inode
+	archive *s3tar.ArchiveFS
+	modTime time.Time
+}
+
+var _ = (fusefs.NodeOnAdder)((*mountDir)(nil))
+var _ = (fusefs.NodeGetattrer)((*mountDir)(nil))
+
+func (d *mountDir) OnAdd(ctx context.Context) {
+	if !d.IsRoot() {
+		return
+	}
+	inodes := map[string]*fusefs.Inode{".": &d.Inode}
+	fs.WalkDir(d.archive, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		parent := inodes[path.Dir(name)]
+		var child *fusefs.Inode
+		if entry.IsDir() {
+			child = parent.NewPersistentInode(ctx, &mountDir{archive: d.archive, modTime: d.modTime}, fusefs.StableAttr{Mode: syscall.S_IFDIR})
+			inodes[name] = child
+		} else {
+			member, err := d.archive.Member(name)
+			if err != nil {
+				return err
+			}
+			child = parent.NewPersistentInode(ctx, &mountFile{member: member, modTime: d.modTime}, fusefs.StableAttr{Mode: syscall.S_IFREG})
+		}
+		parent.AddChild(entry.Name(), child, true)
+		return nil
+	})
+}
+
+func (d *mountDir) Getattr(ctx context.Context, fh fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = syscall.S_IFDIR | 0555
+	out.SetTimes(nil, &d.modTime, &d.modTime)
+	return 0
+}
+
+// mountFile is a member of a mounted archive, read at its offset with range
+// requests.
+type mountFile struct {
+	fusefs.Inode
+	member  *io.SectionReader
+	modTime time.Time
+}
+
+var _ = (fusefs.NodeOpener)((*mountFile)(nil))
+var _ = (fusefs.NodeReader)((*mountFile)(nil))
+var _ = (fusefs.NodeGetattrer)((*mountFile)(nil))
+
+func (f *mountFile) Getattr(ctx context.Context, fh fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = syscall.S_IFREG | 0444
+	out.Size = uint64(f.member.Size())
+	out.SetTimes(nil, &f.modTime, &f.modTime)
+	return 0
+}
+
+func (f *mountFile) Open(ctx context.Context, flags uint32) (fusefs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	// members never change, the kernel can keep what it read
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *mountFile) Read(ctx context.Context, fh fusefs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := f.member.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// archiveModTime is the last modified time of the archive, which every
+// member of an ArchiveFS has.
+func archiveModTime(archive *s3tar.ArchiveFS) time.Time {
+	info, err := archive.Stat(".")
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,155 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+type doFunc func(*http.Request) (*http.Response, error)
+
+func (f doFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+// objectClient is an Amazon S3 client that serves the HEAD and ranged GET
+// requests of objects, keyed by bucket/key.
+func objectClient(objects map[string][]byte) *s3.Client {
+	do := doFunc(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{"Etag": {`"etag"`}, "Last-Modified": {time.Unix(1700000000, 0).UTC().Format(http.TimeFormat)}}
+		status, body := http.StatusOK, objects[strings.TrimPrefix(req.URL.Path, "/")]
+		if body == nil {
+			status, body = http.StatusNotFound, []byte(`<Error><Code>NoSuchKey</Code></Error>`)
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil && status == http.StatusOK {
+			if end >= len(body) {
+				end = len(body) - 1
+			}
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+			status, body = http.StatusPartialContent, body[start:end+1]
+		}
+		header.Set("Content-Length", fmt.Sprint(len(body)))
+		if req.Method == http.MethodHead {
+			body = nil
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(bytes.NewReader(body)), ContentLength: int64(len(body)), Request: req}, nil
+	})
+	return s3.New(s3.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String("http://s3.local"),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   do,
+		Retryer:      aws.NopRetryer{},
+	})
+}
+
+func Test_mountDir(t *testing.T) {
+	members := [][2]string{{"c.txt", "world"}, {"dir/a.txt", "hello"}, {"dir/sub/b.txt", strings.Repeat("b", 1000)}}
+	var archive, toc bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, m := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: m[0], Mode: 0640, Size: int64(len(m[1])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&toc, "%s,%d,%d,etag\n", m[0], archive.Len(), len(m[1]))
+		tw.Write([]byte(m[1]))
+		tw.Flush()
+	}
+	tw.Close()
+	client := objectClient(map[string][]byte{"bucket/a.tar": archive.Bytes(), "bucket/a.tar.toc.csv": toc.Bytes()})
+	ctx := context.Background()
+	fsys, err := s3tar.OpenArchive(ctx, client, "bucket", "a.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tree is built when the root is added, without mounting it
+	root := &mountDir{}
+	root.archive, root.modTime = fsys, archiveModTime(fsys)
+	fusefs.NewNodeFS(root, &fusefs.Options{})
+
+	dirs := map[string][]string{
+		"":        {"c.txt", "dir"},
+		"dir":     {"a.txt", "sub"},
+		"dir/sub": {"b.txt"},
+	}
+	for dir, want := range dirs {
+		node := mountNode(root, dir)
+		if node == nil {
+			t.Fatalf("%s isn't a directory of the mount", dir)
+		}
+		var got []string
+		for name := range node.Children() {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s lists %v, want %v", dir, got, want)
+		}
+		var out fuse.AttrOut
+		if errno := node.Operations().(*mountDir).Getattr(ctx, nil, &out); errno != 0 || out.Mode != syscall.S_IFDIR|0555 {
+			t.Errorf("%s mode = %o, %v, want a read-only directory", dir, out.Mode, errno)
+		}
+	}
+
+	for _, m := range members {
+		node := mountNode(root, m[0])
+		if node == nil {
+			t.Fatalf("%s isn't in the mount", m[0])
+		}
+		f, ok := node.Operations().(*mountFile)
+		if !ok {
+			t.Fatalf("%s isn't a file of the mount", m[0])
+		}
+		var out fuse.AttrOut
+		if errno := f.Getattr(ctx, nil, &out); errno != 0 || out.Size != uint64(len(m[1])) || out.Mode != syscall.S_IFREG|0444 {
+			t.Errorf("%s size = %d, mode %o, want %d, 0444", m[0], out.Size, out.Mode, len(m[1]))
+		}
+		if out.Mtime != 1700000000 {
+			t.Errorf("%s mtime = %d, want the last modified time of the archive", m[0], out.Mtime)
+		}
+		// read past the start of the member, and over its end
+		dest := make([]byte, len(m[1]))
+		res, errno := f.Read(ctx, nil, dest, 2)
+		if errno != 0 {
+			t.Fatalf("%s read: %v", m[0], errno)
+		}
+		if got, _ := res.Bytes(nil); string(got) != m[1][2:] {
+			t.Errorf("%s read at 2 = %q, want %q", m[0], got, m[1][2:])
+		}
+		if _, _, errno := f.Open(ctx, syscall.O_WRONLY); errno != syscall.EROFS {
+			t.Errorf("%s opened for writing = %v, want EROFS", m[0], errno)
+		}
+	}
+}
+
+// mountNode returns the inode of name under root, nil when it isn't there.
+func mountNode(root *mountDir, name string) *fusefs.Inode {
+	node := &root.Inode
+	for _, name := range strings.Split(name, "/") {
+		if node == nil || name == "" {
+			continue
+		}
+		node = node.GetChild(name)
+	}
+	return node
+}
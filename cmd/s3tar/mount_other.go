@@ -0,0 +1,18 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	s3tar "github.com/awslabs/amazon-s3-tar-tool"
+)
+
+// mountArchive isn't available on this platform.
+func mountArchive(ctx context.Context, archive *s3tar.ArchiveFS, dir, name string) error {
+	return fmt.Errorf("%w: --mount is only available on Linux", s3tar.ErrInvalidArgument)
+}
@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"io"
-	"net/url"
 	"path/filepath"
 	"time"
 
@@ -24,6 +23,7 @@ type RecursiveConcat struct {
 	Bucket      string
 	DstPrefix   string
 	DstKey      string
+	PartsPrefix string
 	block       S3Obj
 }
 
@@ -34,6 +34,9 @@ type RecursiveConcatOptions struct {
 	Bucket      string
 	DstPrefix   string
 	DstKey      string
+	// PartsPrefix is where the intermediate objects are written, by
+	// default <DstKey>.parts under DstPrefix.
+	PartsPrefix string
 }
 
 // type RecursiveConcatOption func(r *RecursiveConcat)
@@ -41,6 +44,9 @@ type RecursiveConcatOptions struct {
 func (r *RecursiveConcat) CreateFirstBlock(ctx context.Context) {
 	//randomize?
 	key := filepath.Join(r.DstPrefix, r.DstKey+".parts", "min-size-block")
+	if r.PartsPrefix != "" {
+		key = filepath.Join(r.PartsPrefix, "min-size-block")
+	}
 	now := time.Now()
 	output, err := putObject(ctx, r.Client, r.Bucket, key, pad)
 	if err != nil {
@@ -75,13 +81,16 @@ func NewRecursiveConcat(ctx context.Context, options RecursiveConcatOptions, opt
 		Bucket:      options.Bucket,
 		DstPrefix:   options.DstPrefix,
 		DstKey:      options.DstKey,
+		PartsPrefix: options.PartsPrefix,
 	}
 	rc.CreateFirstBlock(ctx)
 
 	return rc, nil
 }
 
-func (r *RecursiveConcat) uploadPart(object *S3Obj, uploadId string, bucket, key string, partNum int32) (types.CompletedPart, error) {
+func (r *RecursiveConcat) uploadPart(ctx context.Context, object *S3Obj, uploadId string, bucket, key string, partNum int32) (types.CompletedPart, error) {
+	ctx, cancel := partContext(ctx)
+	defer cancel()
 
 	input := &s3.UploadPartInput{
 		Bucket:     &bucket,
@@ -91,7 +100,7 @@ func (r *RecursiveConcat) uploadPart(object *S3Obj, uploadId string, bucket, key
 		Body:       io.ReadSeeker(bytes.NewReader(object.Data)),
 	}
 
-	res, err := r.Client.UploadPart(context.TODO(), input)
+	res, err := r.Client.UploadPart(ctx, input)
 	if err != nil {
 		return types.CompletedPart{}, err
 	}
@@ -100,22 +109,25 @@ func (r *RecursiveConcat) uploadPart(object *S3Obj, uploadId string, bucket, key
 		PartNumber: input.PartNumber}, nil
 }
 
-func (r *RecursiveConcat) uploadPartCopy(object *S3Obj, uploadId string, bucket, key string, partNum int32, start, end int64) (types.CompletedPart, error) {
+func (r *RecursiveConcat) uploadPartCopy(ctx context.Context, object *S3Obj, uploadId string, bucket, key string, partNum int32, start, end int64) (types.CompletedPart, error) {
+	ctx, cancel := partContext(ctx)
+	defer cancel()
 
 	copySourceRange := fmt.Sprintf("bytes=%d-%d", start, end-1)
 
 	input := s3.UploadPartCopyInput{
-		Bucket:          &bucket,
-		Key:             &key,
-		PartNumber:      aws.Int32(partNum),
-		UploadId:        &uploadId,
-		CopySource:      aws.String(object.Bucket + "/" + url.QueryEscape(*object.Key)),
-		CopySourceRange: aws.String(copySourceRange),
+		Bucket:            &bucket,
+		Key:               &key,
+		PartNumber:        aws.Int32(partNum),
+		UploadId:          &uploadId,
+		CopySource:        copySource(object),
+		CopySourceRange:   aws.String(copySourceRange),
+		CopySourceIfMatch: ifMatch(object),
 	}
 
-	res, err := r.Client.UploadPartCopy(context.TODO(), &input)
+	res, err := r.Client.UploadPartCopy(ctx, &input)
 	if err != nil {
-		return types.CompletedPart{}, err
+		return types.CompletedPart{}, &ObjectError{Bucket: object.Bucket, Key: *object.Key, Err: classifyError(err)}
 	}
 
 	return types.CompletedPart{
@@ -132,9 +144,10 @@ func (r *RecursiveConcat) mergePair(ctx context.Context, objectList []*S3Obj, tr
 	}
 
 	output, err := r.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		ACL:    types.ObjectCannedACLBucketOwnerFullControl,
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		ACL:      providerOf(ctx).acl(),
+		Metadata: runMetadata(ctx),
 	})
 	if err != nil {
 		return complete, err
@@ -143,27 +156,31 @@ func (r *RecursiveConcat) mergePair(ctx context.Context, objectList []*S3Obj, tr
 	uploadId := *output.UploadId
 	parts := []types.CompletedPart{}
 	var accumSize int64 = 0
-	for i, o := range objectList {
-		part := types.CompletedPart{}
-		var err error
+	partNum := int32(1)
+	for _, o := range objectList {
 		if len(o.Data) > 0 {
 			// Debugf(ctx,"uploadPart key:%d", len(o.Data))
-			part, err = r.uploadPart(o, uploadId, bucket, key, int32(i+1))
+			part, err := r.uploadPart(ctx, o, uploadId, bucket, key, partNum)
+			if err != nil {
+				return complete, fmt.Errorf("uploading part %d of %d bytes to s3://%s/%s, upload %s: %w", partNum, len(o.Data), bucket, key, uploadId, err)
+			}
 			accumSize += int64(len(o.Data))
-		} else if *o.Size > 0 {
-			Debugf(ctx, "uploadPartCopy bucket:%s key:%s %d", o.Bucket, *o.Key, len(o.Data))
-			part, err = r.uploadPartCopy(o, uploadId, bucket, key, int32(i+1), trim, *o.Size)
-			accumSize += int64(*o.Size) - trim
-		}
-		if err != nil {
-			fmt.Printf("error 1\n")
-			fmt.Printf("UploadPart[Copy] failed\n")
-			fmt.Printf("len(o.Data): %d\n", len(o.Data))
-			fmt.Printf("uploadId: %s, bucket: %s, key: %s, start: %d, end: %d\n", uploadId, bucket, key, trim, o.Size)
-			return complete, err
+			trackUploaded(ctx, int64(len(o.Data)))
+			parts = append(parts, part)
+			partNum++
+			continue
 		}
-		if *o.Size > 0 {
+		// objects over the part size limit are copied in several ranges
+		for _, rng := range copyRanges(trim, *o.Size) {
+			Debugf(ctx, "uploadPartCopy bucket:%s key:%s bytes=%d-%d", o.Bucket, *o.Key, rng[0], rng[1]-1)
+			part, err := r.uploadPartCopy(ctx, o, uploadId, bucket, key, partNum, rng[0], rng[1])
+			if err != nil {
+				return complete, fmt.Errorf("copying bytes %d-%d of s3://%s/%s to part %d of s3://%s/%s, upload %s: %w", rng[0], rng[1]-1, o.Bucket, *o.Key, partNum, bucket, key, uploadId, err)
+			}
+			accumSize += rng[1] - rng[0]
+			trackCopied(ctx, rng[1]-rng[0])
 			parts = append(parts, part)
+			partNum++
 		}
 	}
 
@@ -193,6 +210,41 @@ func (r *RecursiveConcat) mergePair(ctx context.Context, objectList []*S3Obj, tr
 	return complete, nil
 }
 
+// copyRanges splits the bytes [start, end) of an object into the ranges
+// copied by UploadPartCopy, as few as the part size limit allows and of about
+// the same size, so none is under the minimum part size but the last. Objects
+// up to the limit are a single range, empty ones none.
+func copyRanges(start, end int64) [][2]int64 {
+	size := end - start
+	if size <= 0 {
+		return nil
+	}
+	n := (size + partSizeMax - 1) / partSizeMax
+	rangeSize := (size + n - 1) / n
+	ranges := make([][2]int64, 0, n)
+	for ; start < end; start += rangeSize {
+		rangeEnd := start + rangeSize
+		if rangeEnd > end {
+			rangeEnd = end
+		}
+		ranges = append(ranges, [2]int64{start, rangeEnd})
+	}
+	return ranges
+}
+
+// copyParts is the number of parts a multipart upload of objectList takes.
+func copyParts(objectList []*S3Obj) int {
+	n := 0
+	for _, o := range objectList {
+		if len(o.Data) > 0 {
+			n++
+		} else {
+			n += len(copyRanges(0, *o.Size))
+		}
+	}
+	return n
+}
+
 func calculateFinalSize(objectList []*S3Obj) int64 {
 	var accum int64 = 0
 	for _, v := range objectList {
@@ -0,0 +1,52 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestCopyRanges(t *testing.T) {
+	tests := map[string]struct {
+		start, end int64
+		want       [][2]int64
+	}{
+		"empty":         {start: 0, end: 0, want: nil},
+		"small":         {start: 0, end: 100, want: [][2]int64{{0, 100}}},
+		"trimmed":       {start: 512, end: 100, want: nil},
+		"at the limit":  {start: 0, end: partSizeMax, want: [][2]int64{{0, partSizeMax}}},
+		"over by one":   {start: 0, end: partSizeMax + 1, want: [][2]int64{{0, partSizeMax/2 + 1}, {partSizeMax/2 + 1, partSizeMax + 1}}},
+		"three ranges":  {start: 0, end: 3 * partSizeMax, want: [][2]int64{{0, partSizeMax}, {partSizeMax, 2 * partSizeMax}, {2 * partSizeMax, 3 * partSizeMax}}},
+		"trimmed start": {start: 512, end: 2*partSizeMax + 512, want: [][2]int64{{512, partSizeMax + 512}, {partSizeMax + 512, 2*partSizeMax + 512}}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := copyRanges(tt.start, tt.end)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("copyRanges(%d, %d) = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+			for i, r := range got {
+				if r[1]-r[0] > partSizeMax || (i < len(got)-1 && r[1]-r[0] < fileSizeMin) {
+					t.Errorf("copyRanges(%d, %d) range %v is out of the part size limits", tt.start, tt.end, r)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyParts(t *testing.T) {
+	objectList := []*S3Obj{
+		{Data: []byte("header"), Object: types.Object{Size: aws.Int64(6)}},
+		{Object: types.Object{Size: aws.Int64(10 * partSizeMax / 3)}},
+		{Object: types.Object{Size: aws.Int64(100)}},
+		{Object: types.Object{Size: aws.Int64(0)}},
+	}
+	if got := copyParts(objectList); got != 6 {
+		t.Errorf("copyParts() = %d, want 6", got)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ConflictPolicy decides what happens when a member is extracted to a key or
+// a file that already exists.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces the existing key or file.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip keeps the existing key or file and doesn't extract the
+	// member.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictRename extracts the member under its name with -1, -2...
+	// before the extension, the first that doesn't exist.
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictError fails the run before any member is extracted.
+	ConflictError ConflictPolicy = "error"
+)
+
+func validateConflictPolicy(opts *S3TarS3Options) error {
+	switch opts.OnConflict {
+	case "":
+		opts.OnConflict = ConflictOverwrite
+	case ConflictOverwrite, ConflictSkip, ConflictRename, ConflictError:
+	default:
+		return fmt.Errorf("%w: unknown conflict policy %q", ErrInvalidArgument, opts.OnConflict)
+	}
+	return nil
+}
+
+// resolveConflicts applies policy to the members extracted to paths under
+// dest, the slash separated paths under the destination, and returns where
+// they are extracted: the same path, another one with ConflictRename, or ""
+// with ConflictSkip. exists reports whether a path is already taken. A
+// renamed path is never one of paths either.
+func resolveConflicts(paths []string, dest string, policy ConflictPolicy, exists func(p string) (bool, error)) ([]string, error) {
+	c := newConflictResolver(dest, policy, exists)
+	for _, p := range paths {
+		c.taken[p] = true
+	}
+	resolved := make([]string, len(paths))
+	for i, p := range paths {
+		var err error
+		if resolved[i], err = c.resolve(p); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// conflictResolver applies a ConflictPolicy to the members extracted under a
+// destination one at a time, see resolveConflicts.
+type conflictResolver struct {
+	dest   string
+	policy ConflictPolicy
+	exists func(p string) (bool, error)
+	// taken are the paths extracted to by the run
+	taken map[string]bool
+}
+
+func newConflictResolver(dest string, policy ConflictPolicy, exists func(p string) (bool, error)) *conflictResolver {
+	return &conflictResolver{dest: dest, policy: policy, exists: exists, taken: map[string]bool{}}
+}
+
+// resolve returns where the member at p is extracted, "" when it's skipped.
+func (c *conflictResolver) resolve(p string) (string, error) {
+	if c.policy == "" || c.policy == ConflictOverwrite {
+		return p, nil
+	}
+	found, err := c.exists(p)
+	if err != nil || !found {
+		c.taken[p] = true
+		return p, err
+	}
+	switch c.policy {
+	case ConflictError:
+		return "", fmt.Errorf("%w: %s already exists under %s", ErrDestinationExists, p, c.dest)
+	case ConflictSkip:
+		return "", nil
+	}
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, n, ext)
+		if c.taken[candidate] {
+			continue
+		}
+		found, err := c.exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			c.taken[candidate] = true
+			return candidate, nil
+		}
+	}
+}
+
+// extractKeys returns the keys under opts.DstPrefix the members named names
+// are extracted to, "" for the ones skipped with opts.OnConflict. Every name
+// is checked with memberPath first, and the keys under the prefix are listed
+// once to find the conflicts.
+func extractKeys(ctx context.Context, svc *s3.Client, names []string, opts *S3TarS3Options) ([]string, error) {
+	rel := make([]string, len(names))
+	for i, name := range names {
+		p, err := memberPath(name, false)
+		if err != nil {
+			return nil, err
+		}
+		rel[i] = p
+	}
+	var existing map[string]bool
+	if opts.OnConflict != "" && opts.OnConflict != ConflictOverwrite {
+		var err error
+		if existing, err = listKeys(ctx, svc, opts.DstBucket, opts.DstPrefix); err != nil {
+			return nil, err
+		}
+	}
+	dest := objectURL(opts.DstBucket, opts.DstPrefix)
+	resolved, err := resolveConflicts(rel, dest, opts.OnConflict, func(p string) (bool, error) {
+		return existing[path.Join(opts.DstPrefix, p)], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(resolved))
+	for i, p := range resolved {
+		existingURL := objectURL(opts.DstBucket, path.Join(opts.DstPrefix, rel[i]))
+		switch {
+		case p == "":
+			Infof(ctx, "skipping %s, %s exists", names[i], existingURL)
+			continue
+		case p != rel[i]:
+			Infof(ctx, "%s exists, extracting %s to %s", existingURL, names[i], objectURL(opts.DstBucket, path.Join(opts.DstPrefix, p)))
+		}
+		keys[i] = path.Join(opts.DstPrefix, p)
+	}
+	return keys, nil
+}
+
+// listKeys returns the keys under prefix in bucket.
+func listKeys(ctx context.Context, svc *s3.Client, bucket, prefix string) (map[string]bool, error) {
+	if prefix == "." {
+		prefix = ""
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	keys := map[string]bool{}
+	p := s3.NewListObjectsV2Paginator(svc, &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, classifyError(err)
+		}
+		for _, o := range page.Contents {
+			keys[*o.Key] = true
+		}
+	}
+	return keys, nil
+}
+
+// localExists reports whether a file is already at the slash separated path
+// p under dir. An existing directory isn't a conflict, the members under it
+// are extracted into it.
+func localExists(dir string) func(p string) (bool, error) {
+	return func(p string) (bool, error) {
+		fi, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(p)))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return !fi.IsDir(), nil
+	}
+}
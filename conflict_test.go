@@ -0,0 +1,49 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	existing := map[string]bool{"a.txt": true, "a-1.txt": true, "dir/b": true}
+	paths := []string{"a.txt", "dir/b", "c.txt", "a-2.txt"}
+	tests := map[string]struct {
+		policy  ConflictPolicy
+		want    []string
+		wantErr error
+	}{
+		"default":   {policy: "", want: paths},
+		"overwrite": {policy: ConflictOverwrite, want: paths},
+		"skip":      {policy: ConflictSkip, want: []string{"", "", "c.txt", "a-2.txt"}},
+		// a-1.txt exists and a-2.txt is extracted by the run
+		"rename": {policy: ConflictRename, want: []string{"a-3.txt", "dir/b-1", "c.txt", "a-2.txt"}},
+		"error":  {policy: ConflictError, wantErr: ErrDestinationExists},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := resolveConflicts(paths, "s3://bucket/prefix", tt.policy, func(p string) (bool, error) {
+				return existing[p], nil
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("resolveConflicts() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("resolveConflicts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if err := validateConflictPolicy(&S3TarS3Options{OnConflict: "replace"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("validateConflictPolicy(replace) error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ConvertZip is the format Convert writes a zip file in.
+const ConvertZip = "zip"
+
+// ConvertReport is the result of Convert.
+type ConvertReport struct {
+	Archive     string `json:"archive"`
+	Destination string `json:"destination"`
+	Format      string `json:"format"`
+	Members     int    `json:"members"`
+	// Skipped are the hard links and special files left out.
+	Skipped int   `json:"skipped"`
+	Size    int64 `json:"size"`
+}
+
+// Convert reads the archive s3://SrcBucket/SrcKey of opts once, uncompressed
+// or compressed with gzip or zstd, and streams its members into a new
+// archive in the format to at s3://DstBucket/DstKey, written with a
+// multipart upload as it's read. The only format is ConvertZip: a zip file,
+// with ZIP64 records for the members and archives over 4GiB, whose members
+// are compressed with deflate, or stored with opts.ZipStore. Directories,
+// regular files and symbolic links are converted with their modes and
+// modification times, the toc.csv member of s3tar, pad members, hard links
+// and special files are left out. A member name that climbs out of the
+// archive fails the run, as it would extract outside the destination.
+func Convert(ctx context.Context, svc *s3.Client, options *S3TarS3Options, to string, optFns ...func(*S3TarS3Options)) (*ConvertReport, error) {
+	opts := options.Copy()
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if to != ConvertZip {
+		return nil, fmt.Errorf("%w: unknown format %q to convert to, want %s", ErrInvalidArgument, to, ConvertZip)
+	}
+	if err := checkRewriteArgs(&opts); err != nil {
+		return nil, err
+	}
+	src := sourceClient(svc, &opts)
+	archiveURL, dstURL := objectURL(opts.SrcBucket, opts.SrcKey), objectURL(opts.DstBucket, opts.DstKey)
+	archive, err := headArchive(ctx, src, opts.SrcBucket, opts.SrcKey)
+	if err != nil {
+		return nil, err
+	}
+	format, err := readFormat(archive)
+	if err != nil {
+		return nil, err
+	}
+	if !isTarFormat(format) && format != compressionGzip && format != compressionZstd {
+		return nil, unsupportedFormat(archiveURL, format, "only tar archives, uncompressed or compressed with gzip or zstd, can be converted")
+	}
+
+	if err := validateProvider(&opts); err != nil {
+		return nil, err
+	}
+	svc, err = checkDestinationBucket(ctx, svc, &opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withProvider(ctx, &opts)
+	ctx, stopProgress := startProgress(ctx, opts.ProgressFn)
+	defer stopProgress()
+
+	output, err := src.GetObject(ctx, &s3.GetObjectInput{Bucket: &opts.SrcBucket, Key: &opts.SrcKey})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer output.Body.Close()
+	var data io.Reader = output.Body
+	if !isTarFormat(format) {
+		d, err := decompressor(output.Body, format)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidArchive, archiveURL, err)
+		}
+		defer d.Close()
+		data = d
+	}
+	size, ok := uncompressedSize(ctx, src, archive, format)
+	if !ok {
+		Infof(ctx, "the size of the tar in %s isn't known, it's converted in parts of %s", archiveURL, formatBytes(opts.provider.maxPartSize()))
+		size = -1
+	}
+	upload, err := newStreamUpload(ctx, svc, &opts, size)
+	if err != nil {
+		return nil, err
+	}
+	Infof(ctx, "converting %s to the %s file %s", archiveURL, to, dstURL)
+	report := &ConvertReport{Archive: archiveURL, Destination: dstURL, Format: to}
+	if err := writeZip(ctx, tar.NewReader(data), uploadWriter{ctx, upload}, archiveURL, opts.ZipStore, report); err != nil {
+		upload.abort(ctx)
+		return nil, err
+	}
+	if err := upload.complete(ctx); err != nil {
+		return nil, err
+	}
+	report.Size = upload.size
+	Infof(ctx, "%s converted to %s, %d members, %d bytes", archiveURL, dstURL, report.Members, report.Size)
+	return report, nil
+}
+
+// uncompressedSize returns the size of the tar in archive, of format, which
+// a zip of stored members can grow to: the size of an uncompressed archive,
+// the end of the last member of the TOC next to it, the total of the seek
+// table or the content size of the first frame of zstd, or the ISIZE of the
+// trailer of gzip, the size modulo 4GiB, for archives under 4GiB. It's never
+// under the size of the archive, and false when it isn't known.
+func uncompressedSize(ctx context.Context, client *s3.Client, archive *archiveReader, format string) (int64, bool) {
+	if isTarFormat(format) {
+		return archive.size, true
+	}
+	atLeast := func(size int64) (int64, bool) {
+		if size < archive.size {
+			size = archive.size
+		}
+		return size, true
+	}
+	if r, err := loadFile(ctx, client, externalTocLocation(archive.bucket, archive.key)); err == nil {
+		toc, err := parseTocCSV(r)
+		r.Close()
+		if err == nil && len(toc) > 0 {
+			var end int64
+			for _, m := range toc {
+				if m.Start+m.Size > end {
+					end = m.Start + m.Size
+				}
+			}
+			return atLeast(end)
+		}
+	}
+	switch format {
+	case compressionZstd:
+		if table, err := readSeekTable(archive.readAt, archive.size); err == nil && table != nil {
+			return atLeast(table.size())
+		}
+		head, err := archive.readAt(0, blockSize)
+		if err != nil {
+			return 0, false
+		}
+		var h zstd.Header
+		if err := h.Decode(head); err == nil && h.HasFCS {
+			return atLeast(int64(h.FrameContentSize))
+		}
+	case compressionGzip:
+		if archive.size < 1<<32 {
+			trailer, err := archive.readAt(archive.size-4, 4)
+			if err == nil && len(trailer) == 4 {
+				return atLeast(int64(binary.LittleEndian.Uint32(trailer)))
+			}
+		}
+	}
+	return 0, false
+}
+
+// writeZip writes the members read from tr, of the archive at archiveURL, as
+// a zip file to w and counts them in report.
+func writeZip(ctx context.Context, tr *tar.Reader, w io.Writer, archiveURL string, store bool, report *ConvertReport) error {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.DefaultCompression)
+	})
+	method := zip.Deflate
+	if store {
+		method = zip.Store
+	}
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidArchive, archiveURL, err)
+		}
+		if (i == 0 && hdr.Name == "toc.csv") || isPadMember(hdr.Name) {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink:
+		default:
+			Warnf(ctx, "skipping %s, a %s can't be converted", hdr.Name, typeName(hdr.Typeflag))
+			report.Skipped++
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir && path.Clean("/"+hdr.Name) == "/" {
+			// the ./ of archives created from a directory
+			continue
+		}
+		name, err := memberPath(hdr.Name, false)
+		if err != nil {
+			return err
+		}
+		fh := &zip.FileHeader{Name: name, Modified: hdr.ModTime, Method: method}
+		fh.SetMode(hdr.FileInfo().Mode())
+		var body io.Reader = tr
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fh.Name, fh.Method = name+"/", zip.Store
+		case tar.TypeSymlink:
+			// the target of a symbolic link is the data of its entry
+			fh.Method, body = zip.Store, strings.NewReader(hdr.Linkname)
+			fh.SetMode(os.ModeSymlink | 0777)
+		}
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(fw, body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, classifyError(err))
+		}
+		trackCopied(ctx, n)
+		report.Members++
+		Debugf(ctx, "a %s", fh.Name)
+	}
+	return zw.Close()
+}
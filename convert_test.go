@@ -0,0 +1,153 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestConvert(t *testing.T) {
+	members := [][2]string{
+		{"toc.csv", "not,a,toc\n"},
+		{"./", ""},
+		{"etc/", ""},
+		{"etc/app.conf", "a=1"},
+		{"etc/link", "->app.conf"},
+		{"/data/big.bin", strings.Repeat("x", 100000)},
+	}
+	want := []string{"data/big.bin 600 100000", "etc/ 755", "etc/app.conf 600 a=1", "etc/link symlink app.conf"}
+	tests := map[string]struct {
+		compression string
+		members     [][2]string
+		store       bool
+		want        []string
+		wantErr     error
+	}{
+		"tar":          {want: want},
+		"gzip":         {compression: compressionGzip, want: want},
+		"zstd stored":  {compression: compressionZstd, store: true, want: want},
+		"traversal":    {members: [][2]string{{"a.txt", "a"}, {"../../evil.txt", "b"}}, wantErr: ErrUnsafeName},
+		"unknown data": {compression: "zip", wantErr: ErrUnsupportedFormat},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := members
+			if tt.members != nil {
+				m = tt.members
+			}
+			data := []byte("PK\x03\x04 a zip file")
+			if tt.compression != "zip" {
+				data = compressedArchive(t, tt.compression, m)
+			}
+			store := &fakeStore{objects: map[string][]byte{"src/a.tar": data}, parts: map[string]map[int][]byte{}}
+			opts := &S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", DstKey: "a.zip", ZipStore: tt.store}
+			report, err := Convert(context.Background(), store.client(), opts, ConvertZip)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Convert() error = %v, want %v", err, tt.wantErr)
+				}
+				if _, ok := store.objects["dst/a.zip"]; ok {
+					t.Error("the zip file was written")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(store.objects["dst/a.zip"]), int64(len(store.objects["dst/a.zip"])))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(rc)
+				rc.Close()
+				entry := fmt.Sprintf("%s %o", f.Name, f.Mode().Perm())
+				switch {
+				case f.Mode()&os.ModeSymlink != 0:
+					entry = f.Name + " symlink " + string(b)
+				case len(b) > 10:
+					entry += fmt.Sprintf(" %d", len(b))
+				case !f.Mode().IsDir():
+					entry += " " + string(b)
+				}
+				if tt.store && f.Method != zip.Store || !tt.store && !f.Mode().IsDir() && f.Mode()&os.ModeSymlink == 0 && f.Method != zip.Deflate {
+					t.Errorf("%s is written with method %d", f.Name, f.Method)
+				}
+				got = append(got, entry)
+			}
+			sort.Strings(got)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("zip file has %q, want %q", got, tt.want)
+			}
+			if report.Members != len(tt.want) || report.Size != int64(len(store.objects["dst/a.zip"])) {
+				t.Errorf("report = %+v, want %d members", report, len(tt.want))
+			}
+		})
+	}
+	store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	opts := &S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", DstKey: "a.7z"}
+	if _, err := Convert(context.Background(), store.client(), opts, "7z"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("Convert(7z) error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestUncompressedSize(t *testing.T) {
+	members := [][2]string{{"a.txt", "a"}, {"big.bin", strings.Repeat("x", 100000)}}
+	tarData := compressedArchive(t, "", members)
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := encoder.EncodeAll(tarData, nil)
+	encoder.Close()
+	tests := map[string]struct {
+		data []byte
+		toc  string
+		want int64
+		ok   bool
+	}{
+		"tar":               {data: tarData, want: int64(len(tarData)), ok: true},
+		"gzip":              {data: compressedArchive(t, compressionGzip, members), want: int64(len(tarData)), ok: true},
+		"zstd content size": {data: frame, want: int64(len(tarData)), ok: true},
+		"zstd unknown size": {data: compressedArchive(t, compressionZstd, [][2]string{{"big.bin", strings.Repeat("x", 4<<20)}})},
+		"toc next to it":    {data: compressedArchive(t, compressionZstd, members), toc: "a.txt,512,1,etag\nbig.bin,1536,100000,etag\n", want: 101536, ok: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &fakeStore{objects: map[string][]byte{"src/a.tar": tt.data}}
+			if tt.toc != "" {
+				store.objects["src/a.tar.toc.csv"] = []byte(tt.toc)
+			}
+			archive, err := headArchive(ctx, store.client(), "src", "a.tar")
+			if err != nil {
+				t.Fatal(err)
+			}
+			format, err := readFormat(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size, ok := uncompressedSize(ctx, store.client(), archive, format)
+			if ok != tt.ok || (ok && size != tt.want) {
+				t.Errorf("uncompressedSize() = %d, %v, want %d, %v", size, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
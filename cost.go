@@ -0,0 +1,141 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+// Requests is the number of API calls an archive is expected to make.
+type Requests struct {
+	List                    int64
+	Get                     int64
+	Head                    int64
+	Put                     int64
+	CreateMultipartUpload   int64
+	UploadPart              int64
+	UploadPartCopy          int64
+	CompleteMultipartUpload int64
+	KMS                     int64 // GenerateDataKey and Decrypt calls of SSE-KMS writes
+	TransferBytes           int64 // bytes read from the source, billed as transfer when crossing regions
+}
+
+// Pricing holds the request and transfer prices used to approximate the cost
+// of a run, in USD.
+type Pricing struct {
+	PutPer1000    float64 // PUT, COPY, POST and LIST requests
+	GetPer1000    float64 // GET and HEAD requests
+	KMSPer10000   float64
+	TransferPerGB float64 // inter-region data transfer
+}
+
+// DefaultPricing is the us-west-2 pricing for S3 Standard and AWS KMS.
+var DefaultPricing = Pricing{
+	PutPer1000:    0.005,
+	GetPer1000:    0.0004,
+	KMSPer10000:   0.03,
+	TransferPerGB: 0.02,
+}
+
+// RequestCost returns the approximate cost of the API calls in r.
+func (r Requests) RequestCost(p Pricing) float64 {
+	put := r.List + r.Put + r.CreateMultipartUpload + r.UploadPart + r.UploadPartCopy + r.CompleteMultipartUpload
+	get := r.Get + r.Head
+	return float64(put)/1000*p.PutPer1000 + float64(get)/1000*p.GetPer1000 + float64(r.KMS)/10000*p.KMSPer10000
+}
+
+// TransferCost returns the approximate cost of reading the source from another region.
+func (r Requests) TransferCost(p Pricing) float64 {
+	return float64(r.TransferBytes) / (1024 * 1024 * 1024) * p.TransferPerGB
+}
+
+// estimateRequests projects the API calls made by the path the archive takes.
+// The counts follow the request pattern of each path and are approximate.
+func estimateRequests(e *Estimate, opts *S3TarS3Options, smallFiles bool) {
+	n := int64(e.Objects)
+	r := &e.Requests
+	r.TransferBytes = e.DataSize
+	if opts.SrcPath != "" {
+		// local files are read from disk, only the archive is uploaded
+		r.TransferBytes = 0
+	} else if opts.SrcManifest == "" {
+		r.List = (n + 999) / 1000
+	} else {
+		r.Get = 1
+	}
+
+	if opts.Preflight == PreflightHead && opts.SrcPath == "" {
+		r.Head = n
+	}
+
+	if e.InMemory {
+		// one GET per object, or per range of the objects downloaded in ranges
+		if opts.SrcPath == "" {
+			r.Get += n + e.RangedGets
+		}
+		if e.Parts <= 1 && e.DataSize < mpuThreshold(opts) {
+			r.Put = 1
+		} else {
+			r.CreateMultipartUpload = 1
+			r.UploadPart = int64(e.Parts)
+			r.CompleteMultipartUpload = 1
+		}
+		// the toc written next to the archive
+		r.Put++
+		if opts.KMSKeyID != "" {
+			// GenerateDataKey for the object, or for the upload and each
+			// part, and a Decrypt of each part when the upload is completed.
+			// The toc is written without the key.
+			if r.CreateMultipartUpload == 0 {
+				r.KMS = 1
+			} else {
+				r.KMS = 1 + 2*r.UploadPart
+			}
+		}
+		return
+	}
+
+	// the server-side copies don't take the KMS key, they make no KMS requests
+	if opts.TocExtended {
+		// one HeadObject per object
+		r.Head += n
+	} else if opts.TocChecksums {
+		// one GetObjectAttributes per object, priced as a GET
+		r.Get += n
+	}
+
+	if opts.PreservePOSIXMetadata {
+		r.Head += n
+	}
+	// min-size-block used by RecursiveConcat, and the clean up listing
+	r.Put = 1
+	r.List += 1
+
+	if smallFiles {
+		// every header and object is merged into its group one pair at a time,
+		// then the groups are concatenated into a single object.
+		var groups int64 = 1
+		// an archive too large for any part size fails before it's estimated
+		if partSize, err := findMinimumPartSize(e.TotalSize, 0, 0, opts.provider); err == nil && e.TotalSize/partSize > 1 {
+			groups = e.TotalSize / partSize
+		}
+		merges := 2*(n+1) + 1 + groups
+		r.CreateMultipartUpload = merges + 1
+		r.CompleteMultipartUpload = merges + 1
+		r.UploadPart = n + 3
+		r.UploadPartCopy = merges + n + 1 + groups
+		// objects under the hybrid threshold are downloaded instead of copied,
+		// the merges saved by coalescing them aren't counted
+		r.Get += int64(e.Downloaded)
+		r.UploadPartCopy -= int64(e.Downloaded)
+	} else {
+		// every object is paired with the next header, then all pairs are
+		// concatenated into a single object.
+		r.CreateMultipartUpload = n + 2
+		r.CompleteMultipartUpload = n + 2
+		r.UploadPart = n + 2
+		r.UploadPartCopy = n + n + 1
+	}
+
+	// redistribute into the final object
+	r.CreateMultipartUpload++
+	r.CompleteMultipartUpload++
+	r.UploadPartCopy += int64(e.Parts)
+}
@@ -0,0 +1,366 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compressions of the archives extracted by streaming them through a
+// decompressor, found from their first bytes with sniffFormat.
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// streamPartSize is the size of the parts of a member extracted from a
+// compressed archive to Amazon S3, larger members are uploaded in parts.
+const streamPartSize = 16 * 1024 * 1024
+
+// decompressor returns the data of r decompressed with compression.
+func decompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case compressionGzip:
+		// gzip.Reader reads the concatenated members written by pigz too
+		return gzip.NewReader(r)
+	case compressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: unknown compression %q", ErrInvalidArgument, compression)
+}
+
+// extractCompressed extracts the members under prefix of the archive of
+// opts, compressed with compression. A compressed archive has no offsets to
+// read its members at, it's read once from start to end through the
+// decompressor and every member that matches is written as it's reached.
+// The member names are checked, renamed and their conflicts resolved as
+// with an uncompressed archive, a name that climbs out of the destination
+// fails the run when it's reached.
+func extractCompressed(ctx context.Context, svc, src *s3.Client, prefix, compression string, opts *S3TarS3Options) error {
+	archiveURL := objectURL(opts.SrcBucket, opts.SrcKey)
+	filter := opts.extractFilter
+	if filter != nil {
+		if err := filter.validate(); err != nil {
+			return err
+		}
+	}
+
+	var dst memberWriter
+	if opts.DstPath != "" {
+		w, err := newLocalMemberWriter(svc, opts)
+		if err != nil {
+			return err
+		}
+		dst = w
+	} else {
+		w, err := newS3MemberWriter(ctx, svc, opts)
+		if err != nil {
+			return err
+		}
+		dst = w
+	}
+
+	output, err := src.GetObject(ctx, &s3.GetObjectInput{Bucket: &opts.SrcBucket, Key: &opts.SrcKey})
+	if err != nil {
+		return classifyError(err)
+	}
+	defer output.Body.Close()
+	data, err := decompressor(output.Body, compression)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidArchive, archiveURL, err)
+	}
+	defer data.Close()
+	Infof(ctx, "%s is compressed with %s, extracting it as it's read", archiveURL, compression)
+
+	tr := tar.NewReader(data)
+	extracted := 0
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidArchive, archiveURL, err)
+		}
+		if (i == 0 && hdr.Name == "toc.csv") || isPadMember(hdr.Name) || !strings.HasPrefix(hdr.Name, prefix) {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+		case tar.TypeDir:
+			if filter == nil {
+				if err := dst.dir(ctx, hdr); err != nil {
+					return err
+				}
+			}
+			continue
+		default:
+			Warnf(ctx, "skipping %s, a %s isn't extracted", hdr.Name, typeName(hdr.Typeflag))
+			continue
+		}
+		if filter != nil && (!filter.match(hdr.Name, hdr.Size) || !filter.matchTime(hdr.ModTime)) {
+			continue
+		}
+		trackParts(ctx, 1)
+		ok, err := dst.file(ctx, hdr, tr)
+		if err != nil {
+			return &ObjectError{Bucket: opts.SrcBucket, Key: opts.SrcKey, Err: fmt.Errorf("%s: %w", hdr.Name, err)}
+		}
+		trackPartDone(ctx)
+		if ok {
+			trackCopied(ctx, hdr.Size)
+			extracted++
+		}
+	}
+	Infof(ctx, "%d members extracted from %s", extracted, archiveURL)
+	return dst.close(ctx)
+}
+
+// typeName names the tar entry types that aren't extracted.
+func typeName(flag byte) string {
+	switch flag {
+	case tar.TypeSymlink:
+		return "symbolic link"
+	case tar.TypeLink:
+		return "hard link"
+	case tar.TypeChar, tar.TypeBlock:
+		return "device"
+	case tar.TypeFifo:
+		return "named pipe"
+	}
+	return fmt.Sprintf("entry of type %q", flag)
+}
+
+// memberWriter writes the members of a compressed archive, in the order they
+// are read, to the destination of an extraction.
+type memberWriter interface {
+	// file writes the member of hdr with the data read from r, and reports
+	// whether it was written or skipped with ConflictSkip.
+	file(ctx context.Context, hdr *tar.Header, r io.Reader) (bool, error)
+	// dir creates the directory member of hdr, where there are directories.
+	dir(ctx context.Context, hdr *tar.Header) error
+	close(ctx context.Context) error
+}
+
+// localMemberWriter writes members under the local directory opts.DstPath.
+type localMemberWriter struct {
+	svc      *s3.Client
+	opts     *S3TarS3Options
+	root     string
+	windows  bool
+	namer    *localNamer
+	resolver *conflictResolver
+	renamed  []RenamedMember
+}
+
+func newLocalMemberWriter(svc *s3.Client, opts *S3TarS3Options) (*localMemberWriter, error) {
+	dir, err := filepath.Abs(opts.DstPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	windows := windowsNames(opts)
+	return &localMemberWriter{
+		svc:      svc,
+		opts:     opts,
+		root:     dir,
+		windows:  windows,
+		namer:    newLocalNamer(dir, windows),
+		resolver: newConflictResolver(objectURL("", localKey(dir)), opts.OnConflict, localExists(dir)),
+		renamed:  []RenamedMember{},
+	}, nil
+}
+
+func (w *localMemberWriter) file(ctx context.Context, hdr *tar.Header, r io.Reader) (bool, error) {
+	p, err := memberPath(hdr.Name, w.windows)
+	if err != nil {
+		return false, err
+	}
+	named, reason := w.namer.name(p)
+	resolved, err := w.resolver.resolve(named)
+	if err != nil {
+		return false, err
+	}
+	if resolved == "" {
+		Infof(ctx, "skipping %s, %s exists", hdr.Name, filepath.Join(w.root, filepath.FromSlash(named)))
+		return false, nil
+	}
+	if resolved != named {
+		reason = "already exists"
+	}
+	if resolved != p {
+		w.renamed = append(w.renamed, RenamedMember{Member: hdr.Name, Path: resolved, Reason: reason})
+	}
+	target := filepath.Join(w.root, filepath.FromSlash(resolved))
+	var posix *tar.Header
+	if w.opts.PreservePOSIXMetadata {
+		posix = hdr
+	}
+	if err := writeLocalFile(target, r, posix); err != nil {
+		return false, err
+	}
+	Infof(ctx, "x %s", objectURL("", localKey(target)))
+	return true, nil
+}
+
+func (w *localMemberWriter) dir(ctx context.Context, hdr *tar.Header) error {
+	p, err := memberPath(hdr.Name, w.windows)
+	if err != nil {
+		return err
+	}
+	named, _ := w.namer.name(p)
+	return os.MkdirAll(filepath.Join(w.root, filepath.FromSlash(named)), 0755)
+}
+
+func (w *localMemberWriter) close(ctx context.Context) error {
+	return writeNameMap(ctx, w.svc, w.opts.NameMap, w.renamed)
+}
+
+// s3MemberWriter writes members under opts.DstPrefix in opts.DstBucket.
+type s3MemberWriter struct {
+	svc      *s3.Client
+	opts     *S3TarS3Options
+	resolver *conflictResolver
+}
+
+func newS3MemberWriter(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) (*s3MemberWriter, error) {
+	var existing map[string]bool
+	if opts.OnConflict != "" && opts.OnConflict != ConflictOverwrite {
+		var err error
+		if existing, err = listKeys(ctx, svc, opts.DstBucket, opts.DstPrefix); err != nil {
+			return nil, err
+		}
+	}
+	exists := func(p string) (bool, error) {
+		return existing[path.Join(opts.DstPrefix, p)], nil
+	}
+	return &s3MemberWriter{
+		svc:      svc,
+		opts:     opts,
+		resolver: newConflictResolver(objectURL(opts.DstBucket, opts.DstPrefix), opts.OnConflict, exists),
+	}, nil
+}
+
+func (w *s3MemberWriter) file(ctx context.Context, hdr *tar.Header, r io.Reader) (bool, error) {
+	p, err := memberPath(hdr.Name, false)
+	if err != nil {
+		return false, err
+	}
+	resolved, err := w.resolver.resolve(p)
+	if err != nil {
+		return false, err
+	}
+	if resolved == "" {
+		Infof(ctx, "skipping %s, %s exists", hdr.Name, objectURL(w.opts.DstBucket, path.Join(w.opts.DstPrefix, p)))
+		return false, nil
+	}
+	key := path.Join(w.opts.DstPrefix, resolved)
+	var metadata map[string]string
+	if w.opts.PreservePOSIXMetadata {
+		metadata = posixMetadata(hdr)
+	}
+	if err := putStream(ctx, w.svc, w.opts.DstBucket, key, r, hdr.Size, metadata); err != nil {
+		return false, err
+	}
+	Infof(ctx, "x %s", objectURL(w.opts.DstBucket, key))
+	return true, nil
+}
+
+// dir leaves directories out, Amazon S3 has none.
+func (w *s3MemberWriter) dir(ctx context.Context, hdr *tar.Header) error {
+	return nil
+}
+
+func (w *s3MemberWriter) close(ctx context.Context) error {
+	return nil
+}
+
+// putStream writes the size bytes read from r to s3://bucket/key, with a
+// single PutObject up to streamPartSize and with a multipart upload of parts
+// read one after the other above it.
+func putStream(ctx context.Context, svc *s3.Client, bucket, key string, r io.Reader, size int64, metadata map[string]string) error {
+	partSize := int64(streamPartSize)
+	if n := (size + maxPartNumLimit - 1) / maxPartNumLimit; n > partSize {
+		partSize = n
+	}
+	if size <= partSize {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		_, err := svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:   &bucket,
+			Key:      &key,
+			Body:     bytes.NewReader(data),
+			ACL:      types.ObjectCannedACLBucketOwnerFullControl,
+			Metadata: metadata,
+		})
+		if err != nil {
+			return classifyError(err)
+		}
+		trackUploaded(ctx, size)
+		return nil
+	}
+
+	output, err := svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		ACL:      types.ObjectCannedACLBucketOwnerFullControl,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return classifyError(err)
+	}
+	uploadId := *output.UploadId
+	var parts []types.CompletedPart
+	buf := make([]byte, partSize)
+	for partNum, remaining := int32(1), size; remaining > 0; partNum++ {
+		n := partSize
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			abortUpload(ctx, svc, bucket, key, uploadId)
+			return err
+		}
+		num := partNum
+		res, err := uploadPart(ctx, svc, uploadId, bucket, key, buf[:n], &num, "")
+		if err != nil {
+			abortUpload(ctx, svc, bucket, key, uploadId)
+			return classifyError(err)
+		}
+		trackUploaded(ctx, n)
+		parts = append(parts, types.CompletedPart{ETag: res.ETag, PartNumber: aws.Int32(num)})
+		remaining -= n
+	}
+	_, err = svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		UploadId:        &uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abortUpload(ctx, svc, bucket, key, uploadId)
+		return classifyError(err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedArchive returns a tar of members, name and data, compressed with
+// compression, empty for none.
+func compressedArchive(t *testing.T, compression string, members [][2]string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case compressionGzip:
+		w = gzip.NewWriter(&buf)
+	case compressionZstd:
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		w = nopWriteCloser{&buf}
+	}
+	tw := tar.NewWriter(w)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m[0], Mode: 0600, Size: int64(len(m[1])), ModTime: time.Unix(1700000000, 0), Typeflag: tar.TypeReg}
+		switch {
+		case strings.HasSuffix(m[0], "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		case strings.HasPrefix(m[1], "->"):
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, m[1][2:], 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(m[1]))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// fakeArchiveBucket answers the requests of an extraction of the archive
+// s3://src/a.tar with data, and records the objects written to the dst
+// bucket.
+func fakeArchiveBucket(data []byte, written map[string]string, mu *sync.Mutex) *s3.Client {
+	do := doFunc(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{"Etag": {`"etag"`}}
+		status, body := http.StatusOK, []byte(nil)
+		switch op := fakeOperation(req); {
+		case op == "ListObjectsV2":
+			body = []byte(`<ListBucketResult><Name>dst</Name><KeyCount>0</KeyCount></ListBucketResult>`)
+		case op == "PutObject":
+			b, _ := io.ReadAll(req.Body)
+			mu.Lock()
+			written[strings.TrimPrefix(req.URL.Path, "/dst/")] = string(b)
+			mu.Unlock()
+		case req.Method == http.MethodHead:
+			header.Set("Content-Length", fmt.Sprint(len(data)))
+			header.Set("Last-Modified", time.Unix(1700000000, 0).UTC().Format(http.TimeFormat))
+		case op == "GetObject":
+			body = data
+			var start, end int64
+			if _, err := fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+				if end >= int64(len(data)) {
+					end = int64(len(data)) - 1
+				}
+				status, body = http.StatusPartialContent, data[start:end+1]
+				header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			}
+		}
+		header.Set("Content-Length", fmt.Sprint(len(body)))
+		if req.Method == http.MethodHead {
+			header.Set("Content-Length", fmt.Sprint(len(data)))
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(bytes.NewReader(body)), ContentLength: int64(len(body)), Request: req}, nil
+	})
+	return s3.New(s3.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String("http://s3.local"),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   do,
+	})
+}
+
+func TestExtractCompressed(t *testing.T) {
+	members := [][2]string{
+		{"toc.csv", "not,a,toc\n"},
+		{"etc/", ""},
+		{"etc/app.conf", "a=1"},
+		{"etc/app.conf.bak", "a=0"},
+		{"etc/link", "->/etc/passwd"},
+		{"data/big.bin", strings.Repeat("x", 100)},
+	}
+	tests := map[string]struct {
+		compression string
+		local       bool
+		prefix      string
+		filter      *ExtractFilter
+		members     [][2]string
+		want        map[string]string
+		wantErr     error
+	}{
+		"gzip to a directory": {
+			compression: compressionGzip, local: true,
+			want: map[string]string{"etc/app.conf": "a=1", "etc/app.conf.bak": "a=0", "data/big.bin": strings.Repeat("x", 100)},
+		},
+		"zstd to a directory": {
+			compression: compressionZstd, local: true, prefix: "etc/",
+			want: map[string]string{"etc/app.conf": "a=1", "etc/app.conf.bak": "a=0"},
+		},
+		"gzip to amazon s3": {
+			compression: compressionGzip,
+			filter:      &ExtractFilter{TocQuery: TocQuery{Exclude: []string{"*.bak"}, MaxSize: 50}},
+			want:        map[string]string{"out/etc/app.conf": "a=1"},
+		},
+		"zstd with a filter": {
+			compression: compressionZstd, local: true,
+			filter: &ExtractFilter{TocQuery: TocQuery{Patterns: []string{"*.conf*"}, Exclude: []string{"*.bak"}}},
+			want:   map[string]string{"etc/app.conf": "a=1"},
+		},
+		"traversal": {
+			compression: compressionGzip, local: true,
+			members: [][2]string{{"a.txt", "a"}, {"../../evil.txt", "b"}},
+			want:    map[string]string{"a.txt": "a"},
+			wantErr: ErrUnsafeName,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := members
+			if tt.members != nil {
+				m = tt.members
+			}
+			written := map[string]string{}
+			var mu sync.Mutex
+			client := fakeArchiveBucket(compressedArchive(t, tt.compression, m), written, &mu)
+			opts := &S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", DstPrefix: "out", Threads: 2, extractFilter: tt.filter}
+			dir := t.TempDir()
+			if tt.local {
+				opts.DstBucket, opts.DstPrefix, opts.DstPath = "", "", dir
+			}
+			err := Extract(context.Background(), client, tt.prefix, opts)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Extract() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.local {
+				filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+					if err == nil && !d.IsDir() {
+						data, _ := os.ReadFile(p)
+						rel, _ := filepath.Rel(dir, p)
+						written[filepath.ToSlash(rel)] = string(data)
+					}
+					return err
+				})
+			}
+			var got, want []string
+			for k, v := range written {
+				got = append(got, k+"="+v)
+			}
+			for k, v := range tt.want {
+				want = append(want, k+"="+v)
+			}
+			sort.Strings(got)
+			sort.Strings(want)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("extracted %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+// The fields of a member a key attribute of a TocTable can hold.
+const (
+	TocKeyMember  = "member"
+	TocKeyArchive = "archive"
+)
+
+const (
+	// dynamoBatchSize is the most items of a BatchWriteItem request.
+	dynamoBatchSize = 25
+	// dynamoPartitionKeySize and dynamoSortKeySize are the largest values
+	// of the keys of an item.
+	dynamoPartitionKeySize = 2048
+	dynamoSortKeySize      = 1024
+	// dynamoMaxAttempts is how many times the items DynamoDB leaves
+	// unprocessed are written before the export fails.
+	dynamoMaxAttempts = 8
+)
+
+// dynamoRetryBackoff is the wait before the unprocessed items of a batch are
+// written again, doubled before every other attempt.
+var dynamoRetryBackoff = 100 * time.Millisecond
+
+// TocKeyAttribute is a key attribute of a TocTable: its name in the table
+// and the field of the member it holds, TocKeyMember or TocKeyArchive.
+type TocKeyAttribute struct {
+	Name  string
+	Field string
+}
+
+// TocTable is the Amazon DynamoDB table ExportToc writes an item per member
+// to. PartitionKey and SortKey are its key schema, SortKey has no Name for a
+// table without a sort key; both are strings.
+type TocTable struct {
+	Name         string
+	PartitionKey TocKeyAttribute
+	SortKey      TocKeyAttribute
+}
+
+// ParseTocTableKey parses the key schema of a TocTable: the partition key
+// and, after a comma, the sort key, each the field it holds, member or
+// archive, named after it or given as attribute=field. The default,
+// "member,archive", finds which archives hold a member with a query on its
+// name.
+func ParseTocTableKey(s string) (partitionKey, sortKey TocKeyAttribute, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) > 2 {
+		return partitionKey, sortKey, fmt.Errorf("%w: the key %q has %d attributes, want a partition key and an optional sort key", ErrInvalidArgument, s, len(parts))
+	}
+	var keys [2]TocKeyAttribute
+	for i, part := range parts {
+		name, field, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			field = name
+		}
+		if field != TocKeyMember && field != TocKeyArchive {
+			return partitionKey, sortKey, fmt.Errorf("%w: the key attribute %q holds %q, want %s or %s", ErrInvalidArgument, name, field, TocKeyMember, TocKeyArchive)
+		}
+		if name == "" {
+			return partitionKey, sortKey, fmt.Errorf("%w: a key attribute of %q has no name", ErrInvalidArgument, s)
+		}
+		keys[i] = TocKeyAttribute{Name: name, Field: field}
+	}
+	if keys[0].Field == keys[1].Field || keys[0].Name == keys[1].Name {
+		return partitionKey, sortKey, fmt.Errorf("%w: the partition and sort keys of %q are the same", ErrInvalidArgument, s)
+	}
+	return keys[0], keys[1], nil
+}
+
+// TocExportReport is the result of ExportToc.
+type TocExportReport struct {
+	Table    string `json:"table"`
+	Archives int    `json:"archives"`
+	Items    int64  `json:"items"`
+	// Skipped are the members whose key is too large for the table.
+	Skipped int64 `json:"skipped"`
+}
+
+// dynamoAPI is the part of the Amazon DynamoDB client used to export TOCs.
+type dynamoAPI interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// newDynamoClient returns an Amazon DynamoDB client in the region and with
+// the credentials of svc.
+func newDynamoClient(svc *s3.Client) *dynamodb.Client {
+	o := svc.Options()
+	return dynamodb.New(dynamodb.Options{Region: o.Region, Credentials: o.Credentials, HTTPClient: o.HTTPClient, Logger: o.Logger})
+}
+
+// ExportToc writes an item per member of archives to table, with the url of
+// the archive, the name, offset and size of the member and its ETag and
+// checksum when the TOC has them, so the archives holding an object are
+// found with a query instead of reading their TOCs. Of the members with the
+// same key, the last one of an archive or, for a table keyed on the name
+// only, the one of the last archive, is written. The items are written in
+// batches by threads workers.
+func ExportToc(ctx context.Context, svc *s3.Client, table TocTable, archives []IndexedArchive, threads int) (*TocExportReport, error) {
+	return exportToc(ctx, newDynamoClient(svc), table, archives, threads)
+}
+
+func exportToc(ctx context.Context, client dynamoAPI, table TocTable, archives []IndexedArchive, threads int) (*TocExportReport, error) {
+	if err := checkTocTable(ctx, client, table); err != nil {
+		return nil, err
+	}
+	if threads <= 0 {
+		threads = defaultThreads
+	}
+	report := &TocExportReport{Table: table.Name, Archives: len(archives)}
+	// a BatchWriteItem request can't have two items with the same key and
+	// the batches are written in parallel, the last member with a key is
+	// the only one written
+	var requests []ddbtypes.WriteRequest
+	keys := map[string]int{}
+	for _, a := range archives {
+		for _, m := range a.Toc {
+			item, key, err := tocItem(table, a.Archive, m)
+			if err != nil {
+				Warnf(ctx, "skipping %s of %s: %s", m.Filename, a.Archive, err.Error())
+				report.Skipped++
+				continue
+			}
+			request := ddbtypes.WriteRequest{PutRequest: &ddbtypes.PutRequest{Item: item}}
+			if i, ok := keys[key]; ok {
+				requests[i] = request
+				continue
+			}
+			keys[key] = len(requests)
+			requests = append(requests, request)
+		}
+	}
+	var items atomic.Int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for start := 0; start < len(requests); start += dynamoBatchSize {
+		batch := requests[start:]
+		if len(batch) > dynamoBatchSize {
+			batch = batch[:dynamoBatchSize]
+		}
+		g.Go(func() error {
+			if err := writeDynamoBatch(gctx, client, table.Name, batch); err != nil {
+				return err
+			}
+			items.Add(int64(len(batch)))
+			return nil
+		})
+	}
+	err := g.Wait()
+	report.Items = items.Load()
+	if err != nil {
+		return report, fmt.Errorf("writing to the table %s: %w", table.Name, err)
+	}
+	Infof(ctx, "%d members of %d archives written to the table %s", report.Items, report.Archives, table.Name)
+	return report, nil
+}
+
+// exportArchiveToc exports the TOC of the archive s3://DstBucket/DstKey of
+// opts, once it's created, to opts.TocTable.
+func exportArchiveToc(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	toc, err := readToc(ctx, svc, newArchiveReader(ctx, svc, opts.DstBucket, opts.DstKey, -1))
+	if err != nil {
+		return fmt.Errorf("reading the TOC to export: %w", err)
+	}
+	archive := objectURL(opts.DstBucket, opts.DstKey)
+	_, err = ExportToc(ctx, svc, opts.TocTable, []IndexedArchive{{Archive: archive, Toc: toc}}, opts.Threads)
+	return err
+}
+
+// checkTocTable checks that the key schema of table is the one of the table
+// in DynamoDB.
+func checkTocTable(ctx context.Context, client dynamoAPI, table TocTable) error {
+	if table.Name == "" || table.PartitionKey.Name == "" {
+		return fmt.Errorf("%w: the table and its partition key are required", ErrInvalidArgument)
+	}
+	out, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table.Name)})
+	if err != nil {
+		return fmt.Errorf("describing the table %s: %w", table.Name, err)
+	}
+	want := map[ddbtypes.KeyType]string{ddbtypes.KeyTypeHash: table.PartitionKey.Name}
+	if table.SortKey.Name != "" {
+		want[ddbtypes.KeyTypeRange] = table.SortKey.Name
+	}
+	got := map[ddbtypes.KeyType]string{}
+	for _, k := range out.Table.KeySchema {
+		got[k.KeyType] = aws.ToString(k.AttributeName)
+	}
+	if len(got) != len(want) || got[ddbtypes.KeyTypeHash] != want[ddbtypes.KeyTypeHash] || got[ddbtypes.KeyTypeRange] != want[ddbtypes.KeyTypeRange] {
+		return fmt.Errorf("%w: the key of the table %s is %s, not %s", ErrInvalidArgument, table.Name, keySchema(got), keySchema(want))
+	}
+	for _, a := range out.Table.AttributeDefinitions {
+		name := aws.ToString(a.AttributeName)
+		if (name == table.PartitionKey.Name || name == table.SortKey.Name) && a.AttributeType != ddbtypes.ScalarAttributeTypeS {
+			return fmt.Errorf("%w: the key attribute %s of the table %s is of type %s, want S", ErrInvalidArgument, name, table.Name, a.AttributeType)
+		}
+	}
+	return nil
+}
+
+func keySchema(keys map[ddbtypes.KeyType]string) string {
+	if sortKey, ok := keys[ddbtypes.KeyTypeRange]; ok {
+		return keys[ddbtypes.KeyTypeHash] + "," + sortKey
+	}
+	return keys[ddbtypes.KeyTypeHash]
+}
+
+// tocItem returns the item of the member m of archive in table, and its key.
+func tocItem(table TocTable, archive string, m *FileMetadata) (map[string]ddbtypes.AttributeValue, string, error) {
+	item := map[string]ddbtypes.AttributeValue{
+		TocKeyArchive: &ddbtypes.AttributeValueMemberS{Value: archive},
+		TocKeyMember:  &ddbtypes.AttributeValueMemberS{Value: m.Filename},
+		"offset":      &ddbtypes.AttributeValueMemberN{Value: strconv.FormatInt(m.Start, 10)},
+		"size":        &ddbtypes.AttributeValueMemberN{Value: strconv.FormatInt(m.Size, 10)},
+	}
+	if m.Etag != "" {
+		item["etag"] = &ddbtypes.AttributeValueMemberS{Value: m.Etag}
+	}
+	if m.Checksum != "" {
+		item["checksum"] = &ddbtypes.AttributeValueMemberS{Value: m.Checksum}
+	}
+	var key []string
+	for i, k := range []TocKeyAttribute{table.PartitionKey, table.SortKey} {
+		if k.Name == "" {
+			continue
+		}
+		value := m.Filename
+		if k.Field == TocKeyArchive {
+			value = archive
+		}
+		if limit := []int{dynamoPartitionKeySize, dynamoSortKeySize}[i]; len(value) > limit || value == "" {
+			return nil, "", fmt.Errorf("the key %s has %d bytes, want 1 to %d", k.Name, len(value), limit)
+		}
+		item[k.Name] = &ddbtypes.AttributeValueMemberS{Value: value}
+		key = append(key, value)
+	}
+	return item, strings.Join(key, "\x00"), nil
+}
+
+// writeDynamoBatch writes requests to table, and writes again those DynamoDB
+// leaves unprocessed, when the table is throttled, with a backoff.
+func writeDynamoBatch(ctx context.Context, client dynamoAPI, table string, requests []ddbtypes.WriteRequest) error {
+	backoff := dynamoRetryBackoff
+	for attempt := 1; ; attempt++ {
+		out, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: map[string][]ddbtypes.WriteRequest{table: requests}})
+		if err != nil {
+			return err
+		}
+		requests = out.UnprocessedItems[table]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == dynamoMaxAttempts {
+			return fmt.Errorf("%d items still unprocessed after %d attempts", len(requests), attempt)
+		}
+		Debugf(ctx, "%d items unprocessed, writing them again in %s", len(requests), backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
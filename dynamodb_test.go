@@ -0,0 +1,176 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDynamo is a table with the key schema keys, partition key first, that
+// leaves the last item of a batch unprocessed the first unprocessed times.
+type fakeDynamo struct {
+	mu          sync.Mutex
+	keys        []string
+	items       map[string]map[string]ddbtypes.AttributeValue
+	unprocessed int
+}
+
+func (f *fakeDynamo) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	table := &ddbtypes.TableDescription{TableName: params.TableName}
+	for i, k := range f.keys {
+		keyType := ddbtypes.KeyTypeHash
+		if i == 1 {
+			keyType = ddbtypes.KeyTypeRange
+		}
+		table.KeySchema = append(table.KeySchema, ddbtypes.KeySchemaElement{AttributeName: aws.String(k), KeyType: keyType})
+		table.AttributeDefinitions = append(table.AttributeDefinitions, ddbtypes.AttributeDefinition{AttributeName: aws.String(k), AttributeType: ddbtypes.ScalarAttributeTypeS})
+	}
+	return &dynamodb.DescribeTableOutput{Table: table}, nil
+}
+
+func (f *fakeDynamo) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]ddbtypes.WriteRequest{}}
+	for table, requests := range params.RequestItems {
+		if len(requests) > dynamoBatchSize {
+			return nil, fmt.Errorf("%d items in a batch", len(requests))
+		}
+		if f.unprocessed > 0 {
+			f.unprocessed--
+			out.UnprocessedItems[table] = requests[len(requests)-1:]
+			requests = requests[:len(requests)-1]
+		}
+		seen := map[string]bool{}
+		for _, r := range requests {
+			var key []string
+			for _, k := range f.keys {
+				key = append(key, r.PutRequest.Item[k].(*ddbtypes.AttributeValueMemberS).Value)
+			}
+			if seen[strings.Join(key, "|")] {
+				return nil, fmt.Errorf("the batch has %v twice", key)
+			}
+			seen[strings.Join(key, "|")] = true
+			f.items[strings.Join(key, "|")] = r.PutRequest.Item
+		}
+	}
+	return out, nil
+}
+
+func TestParseTocTableKey(t *testing.T) {
+	tests := map[string]struct {
+		key     string
+		want    [2]TocKeyAttribute
+		wantErr error
+	}{
+		"default":       {key: "member,archive", want: [2]TocKeyAttribute{{"member", TocKeyMember}, {"archive", TocKeyArchive}}},
+		"partition key": {key: "archive", want: [2]TocKeyAttribute{{"archive", TocKeyArchive}}},
+		"named":         {key: "pk=member, sk=archive", want: [2]TocKeyAttribute{{"pk", TocKeyMember}, {"sk", TocKeyArchive}}},
+		"unknown field": {key: "pk=etag", wantErr: ErrInvalidArgument},
+		"same field":    {key: "member,key=member", wantErr: ErrInvalidArgument},
+		"no name":       {key: "=member", wantErr: ErrInvalidArgument},
+		"three":         {key: "member,archive,size", wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			partitionKey, sortKey, err := ParseTocTableKey(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseTocTableKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := [2]TocKeyAttribute{partitionKey, sortKey}; err == nil && got != tt.want {
+				t.Errorf("ParseTocTableKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportToc(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	dynamoRetryBackoff = 0
+	archives := []IndexedArchive{
+		{Archive: "s3://b/a.tar", Toc: TOC{{Filename: "toc.csv", Start: 512, Size: 100}, {Filename: "x.txt", Start: 1536, Size: 5, Etag: "e1", Checksum: "c1"}, {Filename: "x.txt", Start: 2560, Size: 6}}},
+		{Archive: "s3://b/b.tar", Toc: append(manyMembers(60), &FileMetadata{Filename: "x.txt", Start: 512, Size: 7}, &FileMetadata{Filename: strings.Repeat("a", 3000), Start: 1024})},
+	}
+	tests := map[string]struct {
+		key         string
+		tableKeys   []string
+		unprocessed int
+		want        map[string]string
+		items       int64
+		skipped     int64
+		fails       bool
+		wantErr     error
+	}{
+		"member and archive": {
+			key:       "member,archive",
+			tableKeys: []string{"member", "archive"},
+			want:      map[string]string{"x.txt|s3://b/a.tar": "2560 6", "x.txt|s3://b/b.tar": "512 7", "dir/file-7.txt|s3://b/b.tar": "7680 7"},
+			items:     63,
+			skipped:   1,
+		},
+		"member": {
+			key:         "pk=member",
+			tableKeys:   []string{"pk"},
+			unprocessed: 2,
+			want:        map[string]string{"x.txt": "512 7 s3://b/b.tar"},
+			items:       62,
+			skipped:     1,
+		},
+		"archive and member": {
+			key:       "archive,member",
+			tableKeys: []string{"archive", "member"},
+			want:      map[string]string{"s3://b/a.tar|toc.csv": "512 100"},
+			items:     63,
+			skipped:   1,
+		},
+		"unprocessed":  {key: "member,archive", tableKeys: []string{"member", "archive"}, unprocessed: 100, fails: true},
+		"other schema": {key: "member,archive", tableKeys: []string{"pk", "sk"}, wantErr: ErrInvalidArgument},
+		"no sort key":  {key: "member,archive", tableKeys: []string{"member"}, wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := &fakeDynamo{keys: tt.tableKeys, items: map[string]map[string]ddbtypes.AttributeValue{}, unprocessed: tt.unprocessed}
+			table := TocTable{Name: "toc"}
+			var err error
+			if table.PartitionKey, table.SortKey, err = ParseTocTableKey(tt.key); err != nil {
+				t.Fatal(err)
+			}
+			report, err := exportToc(ctx, client, table, archives, 4)
+			if tt.fails || tt.wantErr != nil {
+				if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("exportToc() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Items != tt.items || report.Skipped != tt.skipped || int64(len(client.items)) != tt.items {
+				t.Errorf("report = %+v with %d items in the table, want %d items and %d skipped", report, len(client.items), tt.items, tt.skipped)
+			}
+			for key, want := range tt.want {
+				item, ok := client.items[key]
+				if !ok {
+					t.Fatalf("no item %s in the table", key)
+				}
+				got := item["offset"].(*ddbtypes.AttributeValueMemberN).Value + " " + item["size"].(*ddbtypes.AttributeValueMemberN).Value
+				if strings.Count(want, " ") == 2 {
+					got += " " + item["archive"].(*ddbtypes.AttributeValueMemberS).Value
+				}
+				if got != want {
+					t.Errorf("item %s = %s, want %s", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/smithy-go"
+)
+
+// Errors returned by the archive operations. They are wrapped with more
+// context, use errors.Is to check for them.
+var (
+	ErrInvalidArgument   = errors.New("invalid argument")
+	ErrAccessDenied      = errors.New("access denied")
+	ErrNotFound          = errors.New("not found")
+	ErrObjectTooLarge    = errors.New("object too large")
+	ErrArchiveTooLarge   = errors.New("archive too large")
+	ErrTooManyParts      = errors.New("too many parts")
+	ErrSourceChanged     = errors.New("source object changed")
+	ErrInvalidArchive    = errors.New("invalid archive")
+	ErrChecksumMismatch  = errors.New("checksum mismatch")
+	ErrDestinationExists = errors.New("destination already exists")
+	ErrSourceLocked      = errors.New("source locked")
+	ErrObjectArchived    = errors.New("object archived")
+	ErrRestorePending    = errors.New("restore pending")
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrUnsafeName        = errors.New("unsafe member name")
+	ErrUnsupportedFormat = errors.New("unsupported archive format")
+	// ErrResumable is returned by a run stopped with WithDrain that can be
+	// continued with Resume.
+	ErrResumable = errors.New("stopped, resumable")
+)
+
+// ObjectError is returned when an operation on a single object fails.
+type ObjectError struct {
+	Bucket string
+	Key    string
+	Err    error
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("%s: %s", objectURL(e.Bucket, e.Key), e.Err.Error())
+}
+
+func (e *ObjectError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError wraps Amazon S3 API errors with ErrAccessDenied or ErrNotFound
+// so callers can branch on the cause without inspecting error codes.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrSourceChanged) || errors.Is(err, ErrObjectArchived) {
+		return err
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "AccessDenied", "Forbidden", "AllAccessDisabled":
+			return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+		case "NoSuchKey", "NoSuchBucket", "NotFound":
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		case "PreconditionFailed":
+			return fmt.Errorf("%w: %w", ErrSourceChanged, err)
+		case "InvalidObjectState":
+			return fmt.Errorf("%w: %w", ErrObjectArchived, err)
+		}
+	}
+	return err
+}
+
+// destinationError wraps the error of a conditional write of the archive with
+// ErrDestinationExists when it failed because the key already exists.
+func destinationError(bucket, key string, err error) error {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return &ObjectError{Bucket: bucket, Key: key, Err: fmt.Errorf("%w: %w", ErrDestinationExists, err)}
+		}
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Estimate is the layout of an archive computed from the object listing alone,
+// without reading or writing any data.
+type Estimate struct {
+	Objects     int
+	DataSize    int64 // sum of the object sizes
+	HeaderSize  int64 // tar headers, including PAX/GNU extension blocks
+	PaddingSize int64 // padding to align every member to 512 bytes
+	TocSize     int64 // toc.csv member: header, data and padding
+	EOFSize     int64 // end of archive marker
+	TotalSize   int64 // final size of the archive
+	InMemory    bool  // archive is built with the concat-in-memory path
+	Downloaded  int   // objects under the hybrid threshold, downloaded instead of copied
+	RangedGets  int64 // GETs beyond the first of the objects downloaded in ranges
+	PartSize    int64 // multipart part size of the final object
+	Parts       int   // number of multipart parts of the final object
+	Requests    Requests
+	PlanDigest  string // digest of the objects of every part built in memory, as logged by the run
+}
+
+// EstimateArchive computes the exact size of the archive createFromList would
+// produce for objectList, and the multipart plan used to write it.
+// POSIX metadata fetched with HEAD requests is not taken into account.
+func EstimateArchive(ctx context.Context, objectList []*S3Obj, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) (*Estimate, error) {
+	opts := options.Copy()
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	// the deleted keys aren't archived
+	objectList, _ = splitDeleteMarkers(objectList)
+	if len(objectList) == 0 {
+		return nil, fmt.Errorf("no objects to estimate")
+	}
+	if err := ApplyNameTransforms(objectList, opts.nameTransforms...); err != nil {
+		return nil, err
+	}
+	if err := ResolveNameCollisions(objectList, opts.nameCollisions); err != nil {
+		return nil, err
+	}
+	setHeaderTransforms(objectList, opts.headerTransforms)
+	if opts.RecordOrigin {
+		recordOrigin(objectList)
+	}
+	if err := setPAXRecords(objectList, opts.paxRecords); err != nil {
+		return nil, err
+	}
+	if err := checkLocalSource(objectList, &opts); err != nil {
+		return nil, err
+	}
+	if err := checkPresignedSource(objectList, &opts); err != nil {
+		return nil, err
+	}
+	if err := validateProvider(&opts); err != nil {
+		return nil, err
+	}
+
+	if opts.tarFormat == tar.FormatUnknown {
+		opts.tarFormat = tar.FormatPAX
+	}
+	setTarFormat(objectList, opts.tarFormat)
+
+	e := &Estimate{Objects: len(objectList)}
+	smallFiles := false
+	for _, o := range objectList {
+		e.DataSize += *o.Size
+		if *o.Size < int64(beginningPad) || *o.Size < opts.HybridThreshold {
+			smallFiles = true
+		}
+	}
+
+	if useInMemory(&opts, e.DataSize) {
+		if err := estimateInMemory(e, objectList, &opts); err != nil {
+			return nil, err
+		}
+	} else {
+		estimateConcat(ctx, e, objectList, smallFiles)
+		for _, o := range objectList {
+			if isHybridSmall(o, &opts) {
+				e.Downloaded++
+			}
+		}
+	}
+
+	e.TotalSize = e.TocSize + e.HeaderSize + e.DataSize + e.PaddingSize + e.EOFSize
+	estimateRequests(e, &opts, smallFiles)
+	Debugf(ctx, "estimate: %+v", *e)
+	return e, nil
+}
+
+// estimateInMemory mirrors buildInMemoryConcat: every member is written by
+// archive/tar and there is no TOC.
+func estimateInMemory(e *Estimate, objectList []*S3Obj, opts *S3TarS3Options) error {
+	e.InMemory = true
+	for _, o := range objectList {
+		e.HeaderSize += tarHeaderSize(inMemoryHeader(o))
+		e.PaddingSize += findPadding(*o.Size)
+	}
+	e.EOFSize = blockSize * 2
+
+	if e.DataSize < mpuThreshold(opts) {
+		e.Parts = 1
+		e.PartSize = e.HeaderSize + e.DataSize + e.PaddingSize + e.EOFSize
+	} else {
+		var err error
+		if e.PartSize, err = findMinimumPartSize(e.DataSize, opts.UserMinPartSize, opts.UserMaxPartSize, opts.provider); err != nil {
+			return err
+		}
+		groups := groupingOf(opts).Groups(objectList, e.PartSize)
+		e.Parts, e.PlanDigest = len(groups), planDigest(e.PartSize, groups)
+	}
+	ctx := withPartSize(withRanges(context.Background(), opts), e.PartSize)
+	for _, o := range objectList {
+		if r, ok := rangesOf(ctx, o); ok {
+			e.RangedGets += rangeCount(*o.Size, r.size) - 1
+		}
+	}
+	return nil
+}
+
+// estimateConcat mirrors the server-side concatenation paths: a toc.csv member
+// is prepended and the end of archive marker is built by generateLastBlock.
+func estimateConcat(ctx context.Context, e *Estimate, objectList []*S3Obj, smallFiles bool) {
+	headers := make([]*S3Obj, len(objectList))
+	var prevPad int64
+	for i, o := range objectList {
+		size := tarHeaderSize(objectHeader(o))
+		e.HeaderSize += size
+		headers[i] = &S3Obj{Object: types.Object{Size: aws.Int64(size + prevPad)}}
+		e.PaddingSize += prevPad
+		prevPad = findPadding(*o.Size)
+	}
+
+	toc, err := _buildToc(ctx, headers, objectList)
+	if err == nil {
+		tocObj := NewS3Obj()
+		tocObj.Key = aws.String("toc.csv")
+		tocObj.Size = aws.Int64(int64(toc.Len()))
+		tocPad := findPadding(int64(toc.Len()))
+		if !smallFiles && tocPad == 0 {
+			// buildFirstPart always closes the TOC with at least one block
+			tocPad = blockSize
+		}
+		e.TocSize = tarHeaderSize(objectHeader(tocObj)) + int64(toc.Len()) + tocPad
+	}
+
+	lastBlock := prevPad
+	if lastBlock == 0 {
+		lastBlock = blockSize
+	}
+	e.PaddingSize += prevPad
+	e.EOFSize = lastBlock - prevPad + blockSize*2
+
+	finalSize := e.TocSize + e.HeaderSize + e.DataSize + e.PaddingSize + e.EOFSize
+	_, e.PartSize = redistributePartSize(finalSize)
+	e.Parts = int((finalSize + e.PartSize - 1) / e.PartSize)
+}
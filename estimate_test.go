@@ -0,0 +1,231 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func testObjects(sizes ...int) []*S3Obj {
+	var objectList []*S3Obj
+	for i, size := range sizes {
+		o := NewS3ObjOptions(WithBucketAndKey("bucket", fmt.Sprintf("prefix/%04d", i)))
+		o.AddData(make([]byte, size))
+		objectList = append(objectList, o)
+	}
+	return objectList
+}
+
+func TestEstimateArchive_InMemory(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	tests := []struct {
+		name  string
+		sizes []int
+	}{
+		{name: "aligned", sizes: []int{512, 1024}},
+		{name: "unaligned", sizes: []int{1, 700, 513}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objectList := testObjects(tt.sizes...)
+			opts := &S3TarS3Options{ConcatInMemory: true}
+			e, err := EstimateArchive(ctx, objectList, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _, err := tarGroup(ctx, nil, objectList, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.TotalSize != int64(len(data)) {
+				t.Errorf("EstimateArchive() TotalSize = %d, want %d", e.TotalSize, len(data))
+			}
+			if e.Parts != 1 {
+				t.Errorf("EstimateArchive() Parts = %d, want 1", e.Parts)
+			}
+		})
+	}
+}
+
+func TestEstimateArchive_RecordOrigin(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	objectList := testObjects(10, 20)
+	opts := &S3TarS3Options{ConcatInMemory: true, RecordOrigin: true}
+	e, err := EstimateArchive(ctx, objectList, opts, WithNameTransforms(AddPrefix("backup")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := tarGroup(ctx, nil, objectList, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.TotalSize != int64(len(data)) {
+		t.Errorf("EstimateArchive() TotalSize = %d, want %d", e.TotalSize, len(data))
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for _, o := range objectList {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != "backup/"+*o.Key {
+			t.Errorf("Name = %s, want backup/%s", hdr.Name, *o.Key)
+		}
+		if got := hdr.PAXRecords[paxRecordOrigin]; got != "s3://bucket/"+*o.Key {
+			t.Errorf("PAXRecords[%s] = %s, want s3://bucket/%s", paxRecordOrigin, got, *o.Key)
+		}
+	}
+}
+
+func TestEstimateArchive_Concat(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	objectList := testObjects(fileSizeMin, 700, 513)
+	e, err := EstimateArchive(ctx, objectList, &S3TarS3Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.InMemory {
+		t.Errorf("EstimateArchive() InMemory = true, want false")
+	}
+	if e.TotalSize%blockSize != 0 {
+		t.Errorf("EstimateArchive() TotalSize = %d, not a multiple of %d", e.TotalSize, blockSize)
+	}
+	if e.TocSize == 0 {
+		t.Errorf("EstimateArchive() TocSize = 0, want the toc.csv member")
+	}
+	if e.PartSize*int64(e.Parts) < e.TotalSize {
+		t.Errorf("EstimateArchive() %d parts of %d do not cover %d bytes", e.Parts, e.PartSize, e.TotalSize)
+	}
+}
+
+func TestEstimateArchive_ServerSideOnly(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	e, err := EstimateArchive(ctx, testObjects(700, 513), &S3TarS3Options{ServerSideOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.InMemory {
+		t.Errorf("EstimateArchive() InMemory = true, want false")
+	}
+	if e.Parts != 1 || e.PartSize != e.TotalSize {
+		t.Errorf("EstimateArchive() = %d parts of %d, want a single part of %d", e.Parts, e.PartSize, e.TotalSize)
+	}
+	if e.Requests.Get != 0 {
+		t.Errorf("EstimateArchive() Get = %d, want no downloads", e.Requests.Get)
+	}
+}
+
+func TestEstimateArchive_Hybrid(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	objectList := testObjects(fileSizeMin, 700, 513)
+	e, err := EstimateArchive(ctx, objectList, &S3TarS3Options{HybridThreshold: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.InMemory || e.Downloaded != 2 {
+		t.Errorf("EstimateArchive() InMemory = %t, Downloaded = %d, want false, 2", e.InMemory, e.Downloaded)
+	}
+	if e.Requests.Get != 2 {
+		t.Errorf("EstimateArchive() Get = %d, want 2", e.Requests.Get)
+	}
+}
+
+func TestMPUThreshold(t *testing.T) {
+	tests := map[string]struct {
+		size      int
+		opts      S3TarS3Options
+		multipart bool
+	}{
+		"small archive":       {size: 700},
+		"multipart under 5MB": {size: 700, opts: S3TarS3Options{MPUThreshold: 1}, multipart: true},
+		"multipart over 5MB":  {size: 6 << 20, opts: S3TarS3Options{ConcatInMemory: true}, multipart: true},
+		"put over 5MB":        {size: 6 << 20, opts: S3TarS3Options{MPUThreshold: 16 << 20}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := SetupLogger(context.Background())
+			store := &fakeStore{objects: map[string][]byte{"src/a.bin": make([]byte, tt.size)}, parts: map[string]map[int][]byte{}}
+			objectList := []*S3Obj{NewS3ObjOptions(WithBucketAndKey("src", "a.bin"), WithSize(int64(tt.size)))}
+			opts := tt.opts
+			opts.DstBucket, opts.DstKey, opts.Threads = "dst", "a.tar", 1
+			e, err := EstimateArchive(ctx, objectList, &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := createFromList(ctx, store.client(), objectList, &opts); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := store.parts["dst/a.tar"]; ok != tt.multipart {
+				t.Errorf("multipart upload = %t, want %t", ok, tt.multipart)
+			}
+			if multipart := e.Requests.CreateMultipartUpload == 1; multipart != tt.multipart {
+				t.Errorf("EstimateArchive() multipart upload = %t, want %t", multipart, tt.multipart)
+			}
+			if _, ok := store.objects["dst/a.tar"]; !ok {
+				t.Error("the archive wasn't created")
+			}
+		})
+	}
+}
+
+func TestEstimateArchive_Requests(t *testing.T) {
+	tests := map[string]struct {
+		objectList []*S3Obj
+		opts       S3TarS3Options
+		want       Requests
+	}{
+		"in memory put": {
+			objectList: testObjects(700, 513),
+			opts:       S3TarS3Options{ConcatInMemory: true},
+			want:       Requests{List: 1, Get: 2, Put: 2, TransferBytes: 1213},
+		},
+		"in memory put with kms": {
+			objectList: testObjects(700, 513),
+			opts:       S3TarS3Options{ConcatInMemory: true, KMSKeyID: "key"},
+			want:       Requests{List: 1, Get: 2, Put: 2, KMS: 1, TransferBytes: 1213},
+		},
+		"in memory multipart with kms": {
+			objectList: testObjects(fileSizeMin, fileSizeMin, 513),
+			opts:       S3TarS3Options{ConcatInMemory: true, KMSKeyID: "key"},
+			want:       Requests{List: 1, Get: 3, Put: 1, CreateMultipartUpload: 1, UploadPart: 2, CompleteMultipartUpload: 1, KMS: 5, TransferBytes: 2*fileSizeMin + 513},
+		},
+		"server side with kms": {
+			objectList: testObjects(fileSizeMin, 700, 513),
+			opts:       S3TarS3Options{KMSKeyID: "key"},
+			want:       Requests{List: 2, Put: 1, CreateMultipartUpload: 12, UploadPart: 6, UploadPartCopy: 16, CompleteMultipartUpload: 12, TransferBytes: fileSizeMin + 1213},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := SetupLogger(context.Background())
+			opts := tt.opts
+			e, err := EstimateArchive(ctx, tt.objectList, &opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Requests != tt.want {
+				t.Errorf("EstimateArchive() Requests = %+v, want %+v", e.Requests, tt.want)
+			}
+			kms := tt.want.RequestCost(DefaultPricing) - float64(tt.want.KMS)/10000*DefaultPricing.KMSPer10000
+			if got := e.Requests.RequestCost(DefaultPricing); got <= 0 || (tt.want.KMS > 0 && got <= kms) {
+				t.Errorf("RequestCost() = %f, want more than %f", got, kms)
+			}
+		})
+	}
+}
+
+func TestRequests_RequestCost(t *testing.T) {
+	r := Requests{Put: 1000, Get: 1000, KMS: 10000, TransferBytes: 1024 * 1024 * 1024}
+	p := Pricing{PutPer1000: 0.005, GetPer1000: 0.0004, KMSPer10000: 0.03, TransferPerGB: 0.02}
+	if got := r.RequestCost(p); got < 0.0353 || got > 0.0355 {
+		t.Errorf("RequestCost() = %f, want 0.0354", got)
+	}
+	if got := r.TransferCost(p); got != 0.02 {
+		t.Errorf("TransferCost() = %f, want 0.02", got)
+	}
+}
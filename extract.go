@@ -7,12 +7,10 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
-	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -29,34 +27,88 @@ const (
 )
 
 // Extract will unpack the tar file from source to target without downloading the archive locally.
-// The archive has to be created with the manifest option.
+// The archive has to be created with the manifest option. An archive
+// compressed with gzip or zstd has no TOC to read, it's streamed through the
+// decompressor instead, and one in another format fails with an error
+// wrapping ErrUnsupportedFormat.
 func Extract(ctx context.Context, svc *s3.Client, prefix string, opts *S3TarS3Options) error {
 
-	if err := checkIfObjectExists(ctx, svc, opts.SrcBucket, opts.SrcKey); err != nil {
+	src := sourceClient(svc, opts)
+	if err := checkIfObjectExists(ctx, src, opts.SrcBucket, opts.SrcKey); err != nil {
 		return err
 	}
 
-	toc, err := extractCSVToc(ctx, svc, opts.SrcBucket, opts.SrcKey, opts.ExternalToc)
+	toc, err := extractCSVToc(ctx, src, opts.SrcBucket, opts.SrcKey, opts.ExternalToc)
+	compression := ""
+	var archive *archiveReader
 	if err != nil {
+		if compression, err = tocFormat(ctx, src, opts, err); err != nil {
+			return err
+		}
+		if compression == compressionZstd {
+			if archive, toc, err = seekableToc(ctx, src, opts.SrcBucket, opts.SrcKey); err != nil {
+				return err
+			}
+			if archive != nil {
+				compression = ""
+			}
+		}
+	}
+
+	if err := validateTimeouts(opts); err != nil {
 		return err
 	}
+	ctx = withTimeouts(ctx, opts)
+	ctx, stopProgress := startProgress(ctx, opts.ProgressFn)
+	defer stopProgress()
+	if compression != "" {
+		return extractCompressed(ctx, svc, src, prefix, compression, opts)
+	}
 
+	if archive == nil {
+		archive = newArchiveReader(ctx, src, opts.SrcBucket, opts.SrcKey, -1)
+	}
+	var members TOC
+	for _, f := range toc {
+		if strings.HasPrefix(f.Filename, prefix) && !isPadMember(f.Filename) {
+			members = append(members, f)
+		}
+	}
+	if opts.extractFilter != nil {
+		all := len(members)
+		if members, err = filterMembers(ctx, archive, members, opts.extractFilter, opts.Threads); err != nil {
+			return err
+		}
+		Infof(ctx, "%d of %d members match the filter", len(members), all)
+	}
+	if opts.DstPath != "" {
+		return extractLocal(ctx, svc, archive, members, opts)
+	}
+	// a hostile name would be written outside the prefix, none is extracted
+	names := make([]string, len(members))
+	for i, f := range members {
+		names[i] = f.Filename
+	}
+	keys, err := extractKeys(ctx, svc, names, opts)
+	if err != nil {
+		return err
+	}
 	extract := func() error {
-		g, _ := errgroup.WithContext(ctx)
+		g, gctx := errgroup.WithContext(ctx)
 		g.SetLimit(opts.Threads)
 
-		for _, f := range toc {
-			f := f
-			if strings.HasPrefix(f.Filename, prefix) {
-				g.Go(func() error {
-					dstKey := filepath.Join(opts.DstPrefix, f.Filename)
-					err = extractRange(ctx, svc, opts.SrcBucket, opts.SrcKey, opts.DstBucket, dstKey, f.Start, f.Size, opts)
-					if err != nil {
-						Fatalf(ctx, err.Error())
-					}
-					return nil
-				})
+		for i, f := range members {
+			f, dstKey := f, keys[i]
+			if dstKey == "" {
+				continue
 			}
+			trackParts(ctx, 1)
+			g.Go(func() error {
+				if err := extractRange(gctx, svc, archive, opts.DstBucket, dstKey, f.Start, f.Size, opts); err != nil {
+					return &ObjectError{Bucket: opts.DstBucket, Key: dstKey, Err: err}
+				}
+				return nil
+			})
 		}
 
 		return g.Wait()
@@ -72,51 +124,87 @@ func checkIfObjectExists(ctx context.Context, svc *s3.Client, bucket, key string
 	if err != nil {
 		Errorf(ctx, "%s", err.Error())
 		Errorf(ctx, "does s3://%s/%s exist?", bucket, key)
-		return ErrUnableToAccess
+		return fmt.Errorf("%w: %w", ErrUnableToAccess, classifyError(err))
 	}
 	return nil
 }
 
 // List will print out the contents in a tar, we do this by just printing from the TOC.
 func List(ctx context.Context, svc *s3.Client, bucket, key string, opts *S3TarS3Options) (TOC, error) {
+	svc = sourceClient(svc, opts)
 	if err := checkIfObjectExists(ctx, svc, bucket, key); err != nil {
 		return nil, err
 	}
 	toc, err := extractCSVToc(ctx, svc, bucket, key, opts.ExternalToc)
 	if err != nil {
-		return TOC{}, err
+		compression, err := tocFormat(ctx, svc, &S3TarS3Options{SrcBucket: bucket, SrcKey: key, ExternalToc: opts.ExternalToc}, err)
+		if err != nil {
+			return TOC{}, err
+		}
+		if compression == compressionZstd {
+			archive, toc, err := seekableToc(ctx, svc, bucket, key)
+			if err != nil || archive != nil {
+				return toc, err
+			}
+		}
+		return TOC{}, unsupportedFormat(objectURL(bucket, key), compression, "it has no TOC to list, extract it with -x")
 	}
 	return toc, nil
 }
 
-func extractRange(ctx context.Context, svc *s3.Client, bucket, key, dstBucket, dstKey string, start, size int64, opts *S3TarS3Options) error {
+// tocFormat looks at the first bytes of the archive of opts whose TOC
+// couldn't be read with tocErr. It returns the compression of an archive
+// extracted by streaming it through the decompressor, an error wrapping
+// ErrUnsupportedFormat that names the format of an archive s3tar can't read,
+// or tocErr for a tar archive, one in an unknown format or one read with an
+// external TOC.
+func tocFormat(ctx context.Context, src *s3.Client, opts *S3TarS3Options, tocErr error) (string, error) {
+	if opts.ExternalToc != "" {
+		return "", tocErr
+	}
+	format, err := readFormat(newArchiveReader(ctx, src, opts.SrcBucket, opts.SrcKey, -1))
+	switch {
+	case err != nil, format == "", isTarFormat(format):
+		return "", tocErr
+	case format == compressionGzip, format == compressionZstd:
+		return format, nil
+	}
+	return "", unsupportedFormat(objectURL(opts.SrcBucket, opts.SrcKey), format, "s3tar reads tar archives, uncompressed or compressed with gzip or zstd")
+}
+
+// seekableToc returns a reader of the seekable archive s3://bucket/key and
+// its TOC, nil for both when it isn't seekable or its TOC can't be read, it's
+// then read from start to end instead.
+func seekableToc(ctx context.Context, svc *s3.Client, bucket, key string) (*archiveReader, TOC, error) {
+	archive, err := openSeekable(ctx, svc, bucket, key)
+	if err != nil || archive == nil {
+		return nil, nil, err
+	}
+	toc, err := readToc(ctx, svc, archive)
+	if err != nil {
+		Debugf(ctx, "unable to read the TOC of the seekable archive %s: %s", objectURL(bucket, key), err.Error())
+		return nil, nil, nil
+	}
+	return archive, toc, nil
+}
+
+// extractRange copies the member of archive at start with size bytes to
+// s3://dstBucket/dstKey server-side, or uploads it decompressed from a
+// seekable archive.
+func extractRange(ctx context.Context, svc *s3.Client, archive *archiveReader, dstBucket, dstKey string, start, size int64, opts *S3TarS3Options) error {
+	if archive.frames != nil {
+		return extractFrames(ctx, svc, archive, dstBucket, dstKey, start, size, opts)
+	}
+	bucket, key := archive.bucket, archive.key
 	var Metadata map[string]string
 	if opts.PreservePOSIXMetadata {
-		hdr, headerSize, err := extractTarHeaderEnding(ctx, svc, bucket, key, start)
+		hdr, headerSize, err := archive.headerEnding(start)
 		if err != nil {
 			Warnf(ctx, "unable to extract tar header for %s, cannot set permissions", dstKey)
 			hdr = nil
 		}
 		if hdr != nil {
-			var mtime string = strconv.FormatInt(hdr.ModTime.UnixMilli(), 10)
-			var hasATime = hdr.Format == tar.FormatGNU || hdr.Format == tar.FormatPAX
-			var atime string
-			var ctime string
-			if hasATime {
-				atime = strconv.FormatInt(hdr.AccessTime.UnixMilli(), 10)
-				ctime = strconv.FormatInt(hdr.ChangeTime.UnixMilli(), 10)
-			} else {
-				atime = mtime
-				ctime = mtime
-			}
-			Metadata = map[string]string{
-				"file-permissions": fmt.Sprintf("%#o", hdr.Mode),
-				"file-owner":       strconv.Itoa(hdr.Uid),
-				"file-group":       strconv.Itoa(hdr.Gid),
-				"file-atime":       atime,
-				"file-mtime":       mtime,
-				"file-ctime":       ctime,
-			}
+			Metadata = posixMetadata(hdr)
 			Debugf(ctx, "got posix metadata permissions: %s uid: %s gid: %s name: %s from header size %d, ending %d, format %s",
 				Metadata["file-permissions"], Metadata["file-owner"], Metadata["file-group"], hdr.Name,
 				headerSize, start, hdr.Format,
@@ -162,10 +250,36 @@ func extractRange(ctx context.Context, svc *s3.Client, bucket, key, dstBucket, d
 	if err != nil {
 		return err
 	}
+	trackCopied(ctx, size)
+	trackPartDone(ctx)
 	Infof(ctx, "x s3://%s/%s", *completeOutput.Bucket, *completeOutput.Key)
 	return nil
 }
 
+// posixMetadata returns the user metadata of an object extracted from the
+// member of hdr that keeps its POSIX permissions, owner, group and times.
+func posixMetadata(hdr *tar.Header) map[string]string {
+	var mtime string = strconv.FormatInt(hdr.ModTime.UnixMilli(), 10)
+	var hasATime = hdr.Format == tar.FormatGNU || hdr.Format == tar.FormatPAX
+	var atime string
+	var ctime string
+	if hasATime {
+		atime = strconv.FormatInt(hdr.AccessTime.UnixMilli(), 10)
+		ctime = strconv.FormatInt(hdr.ChangeTime.UnixMilli(), 10)
+	} else {
+		atime = mtime
+		ctime = mtime
+	}
+	return map[string]string{
+		"file-permissions": fmt.Sprintf("%#o", hdr.Mode),
+		"file-owner":       strconv.Itoa(hdr.Uid),
+		"file-group":       strconv.Itoa(hdr.Gid),
+		"file-atime":       atime,
+		"file-mtime":       mtime,
+		"file-ctime":       ctime,
+	}
+}
+
 func extractEmptyRange(ctx context.Context, svc *s3.Client, dstBucket string, dstKey string, uploadId string) ([]types.CompletedPart, error) {
 	input := s3.UploadPartInput{
 		Bucket:     &dstBucket,
@@ -175,6 +289,8 @@ func extractEmptyRange(ctx context.Context, svc *s3.Client, dstBucket string, ds
 		Body:       new(bytes.Buffer),
 	}
 
+	ctx, cancel := partContext(ctx)
+	defer cancel()
 	res, err := svc.UploadPart(ctx, &input)
 	if err != nil {
 		return nil, err
@@ -198,6 +314,8 @@ func extractCopyRange(ctx context.Context, svc *s3.Client, bucket string, key st
 		CopySourceRange: aws.String(copySourceRange),
 	}
 
+	ctx, cancel := partContext(ctx)
+	defer cancel()
 	res, err := svc.UploadPartCopy(ctx, &input)
 
 	if err != nil {
@@ -214,67 +332,31 @@ func extractCopyRange(ctx context.Context, svc *s3.Client, bucket string, key st
 
 type TOC []*FileMetadata
 type FileMetadata struct {
-	Filename string
-	Start    int64
-	Size     int64
-	Etag     string
+	Filename string `json:"name"`
+	Start    int64  `json:"offset"`
+	Size     int64  `json:"size"`
+	Etag     string `json:"etag,omitempty"`
+	// Checksum is the additional checksum of the object, when the TOC has
+	// them.
+	Checksum string `json:"checksum,omitempty"`
+	// Origin is the s3:// url of the object in the TOC of an archive merged
+	// from several sources.
+	Origin string `json:"origin,omitempty"`
+	// VersionId, StorageClass, ContentType and Owner, the canonical ID of
+	// the owner of the object, are in the TOC written with TocExtended.
+	VersionId    string `json:"version_id,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Owner        string `json:"owner,omitempty"`
+	// FrameOffset is where the zstd frame holding the start of the member is
+	// in an archive written by Recompress, in the TOC next to it.
+	FrameOffset *int64 `json:"frame_offset,omitempty"`
 }
 
+// extractTarHeader parses the first header of an archive and returns it with
+// the offset of the data of its member.
 func extractTarHeader(ctx context.Context, svc *s3.Client, bucket, key string) (*tar.Header, int64, error) {
-
-	headerSize := gnuTarHeaderSize
-	ctr := 0
-
-retry:
-
-	if ctr >= 2 {
-		return nil, 0, fmt.Errorf("unable to parse CSV TOC from TAR")
-	}
-	ctr += 1
-
-	output, err := getObjectRange(ctx, svc, bucket, key, 0, headerSize-1)
-	if err != nil {
-		return nil, 0, err
-	}
-	tr := tar.NewReader(output)
-	hdr, err := tr.Next()
-	if err != nil {
-		headerSize = paxTarHeaderSize
-		goto retry
-	}
-	return hdr, headerSize, err
-}
-
-func extractTarHeaderEnding(ctx context.Context, svc *s3.Client, bucket, key string, end int64) (*tar.Header, int64, error) {
-
-	headerSize := paxTarHeaderSize
-	ctr := 0
-
-	// the last 512 bytes of a PAX (POSIX.1-2001) header resemble a USTAR (POSIX.1-1988) header
-	// so we should check for the longer header first.
-	if end < paxTarHeaderSize {
-		// however, if there is not enough room for a PAX header, only look for GNU header
-		headerSize = gnuTarHeaderSize
-		ctr = 1
-	}
-
-retry:
-	if ctr >= 2 {
-		Fatalf(ctx, "unable to parse header ending %d from TAR", end)
-	}
-	ctr += 1
-
-	output, err := getObjectRange(ctx, svc, bucket, key, end-headerSize, end-1)
-	if err != nil {
-		return nil, 0, err
-	}
-	tr := tar.NewReader(output)
-	hdr, err := tr.Next()
-	if err != nil {
-		headerSize = gnuTarHeaderSize
-		goto retry
-	}
-	return hdr, headerSize, err
+	return newArchiveReader(ctx, svc, bucket, key, -1).header(0)
 }
 
 func extractCSVToc(ctx context.Context, svc *s3.Client, bucket, key, externalToc string) (TOC, error) {
@@ -283,17 +365,9 @@ func extractCSVToc(ctx context.Context, svc *s3.Client, bucket, key, externalToc
 	var output io.ReadCloser
 	// for regular s3tar files that have a toc in them, else files with external TOCs
 	if externalToc == "" {
-		hdr, offset, err := extractTarHeader(ctx, svc, bucket, key)
-		if err != nil {
-			return m, err
-		}
-		// extract the csv now that we know the length of the CSV
-		output, err = getObjectRange(ctx, svc, bucket, key, offset, offset+hdr.Size-1)
-		if err != nil {
-			return m, err
-		}
+		return readToc(ctx, svc, newArchiveReader(ctx, svc, bucket, key, -1))
 	} else {
-		fmt.Printf("using external-toc: %s\n", externalToc)
+		Infof(ctx, "using external-toc: %s", externalToc)
 		var err error
 		output, err = loadFile(ctx, svc, externalToc)
 		if err != nil {
@@ -301,32 +375,41 @@ func extractCSVToc(ctx context.Context, svc *s3.Client, bucket, key, externalToc
 		}
 	}
 	defer output.Close()
-	r := csv.NewReader(output)
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			break
-		}
-		if len(record) != 4 {
-			Fatalf(ctx, "unable to parse csv TOC. Was this archive created with s3tar?")
-		}
-		start, err := StringToInt64(record[1])
-		if err != nil {
-			Fatalf(ctx, "Unable to parse int")
-		}
-		size, err := StringToInt64(record[2])
+	return parseTocCSV(output)
+}
+
+// readToc reads the toc.csv at the start of archive, or the TOC next to it
+// for the archives built in memory without one.
+func readToc(ctx context.Context, svc *s3.Client, archive *archiveReader) (TOC, error) {
+	data, _, err := readTocBytes(ctx, svc, archive)
+	if err != nil {
+		return nil, err
+	}
+	return parseTocCSV(bytes.NewReader(data))
+}
+
+// readTocBytes returns the TOC of archive as it's stored, the toc.csv member
+// or the TOC next to it, and where it was read: toc.csv or the url of the
+// TOC.
+func readTocBytes(ctx context.Context, svc *s3.Client, archive *archiveReader) ([]byte, string, error) {
+	bucket, key := archive.bucket, archive.key
+	hdr, offset, err := archive.header(0)
+	if err != nil {
+		return nil, "", err
+	}
+	if hdr.Name != "toc.csv" {
+		// archives built in memory have their toc next to them
+		location := externalTocLocation(bucket, key)
+		Debugf(ctx, "%s has no toc.csv, using %s", key, location)
+		r, err := loadFile(ctx, svc, location)
 		if err != nil {
-			Fatalf(ctx, "Unable to parse int")
+			return nil, "", fmt.Errorf("%w: s3://%s/%s has no toc.csv and %s can't be read, pass --external-toc or generate one with --generate-toc: %w", ErrInvalidArchive, bucket, key, location, classifyError(err))
 		}
-		m = append(m, &FileMetadata{
-			Filename: record[0],
-			Start:    start,
-			Size:     size,
-			Etag:     record[3],
-		})
-	}
-	return m, nil
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return data, location, err
+	}
+	// the csv follows the header, usually in the same range request
+	data, err := archive.member(offset, hdr.Size)
+	return data, hdr.Name, err
 }
@@ -0,0 +1,24 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExtract_memberError(t *testing.T) {
+	data, toc := tarWithToc(t, [][2]string{{"a.txt", "hello"}, {"b.txt", "world"}})
+	store := &fakeStore{objects: map[string][]byte{"src/a.tar": data, "src/a.tar.toc.csv": toc}, parts: map[string]map[int][]byte{}, failing: map[string]bool{"dst/x/b.txt": true}}
+	opts := &S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", DstPrefix: "x", Threads: 1}
+	err := Extract(SetupLogger(context.Background()), store.client(), "", opts)
+	var objErr *ObjectError
+	if !errors.As(err, &objErr) || objErr.Key != "x/b.txt" {
+		t.Fatalf("Extract() = %v, want the error of the member it couldn't extract", err)
+	}
+	if got := string(store.objects["dst/x/a.txt"]); got != "hello" {
+		t.Errorf("x/a.txt = %q, want it extracted", got)
+	}
+}
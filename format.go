@@ -0,0 +1,105 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+)
+
+// The formats sniffFormat finds from the first bytes of an archive, besides
+// compressionGzip and compressionZstd. The tar variants are told apart by the
+// magic of their first header, the others by their file signature.
+const (
+	formatPax   = "pax"
+	formatUstar = "ustar"
+	formatGNU   = "gnu"
+	formatV7    = "v7"
+	formatBzip2 = "bzip2"
+	formatXz    = "xz"
+	formatLz4   = "lz4"
+	formatZip   = "zip"
+	format7z    = "7z"
+	formatRar   = "rar"
+)
+
+var formatMagics = []struct {
+	format string
+	magic  []byte
+}{
+	{compressionGzip, []byte{0x1f, 0x8b}},
+	{compressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{formatBzip2, []byte("BZh")},
+	{formatXz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{formatLz4, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{formatZip, []byte("PK\x03\x04")},
+	// an empty zip file is only its end of central directory
+	{formatZip, []byte("PK\x05\x06")},
+	{format7z, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+	{formatRar, []byte("Rar!\x1a\x07")},
+}
+
+// sniffFormat returns the format of an archive that starts with head, empty
+// when it's none sniffFormat knows. A first block with a valid header
+// checksum is a tar archive whatever its first bytes, a member can be named
+// like a file signature.
+func sniffFormat(head []byte) string {
+	if _, _, err := parseHeaderBlock(head); err == nil {
+		switch magic := head[257:265]; {
+		case bytes.Equal(magic, []byte("ustar  \x00")):
+			return formatGNU
+		case bytes.HasPrefix(magic, []byte("ustar\x00")):
+			if head[156] == tar.TypeXHeader || head[156] == tar.TypeXGlobalHeader {
+				return formatPax
+			}
+			return formatUstar
+		}
+		return formatV7
+	}
+	for _, m := range formatMagics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+	return ""
+}
+
+// isTarFormat reports whether format is one of the tar variants, which are
+// read at their offsets.
+func isTarFormat(format string) bool {
+	switch format {
+	case formatPax, formatUstar, formatGNU, formatV7:
+		return true
+	}
+	return false
+}
+
+// readFormat returns the format of archive from its first block.
+func readFormat(archive *archiveReader) (string, error) {
+	head, err := archive.readAt(0, blockSize)
+	if err != nil {
+		return "", err
+	}
+	return sniffFormat(head), nil
+}
+
+// describeFormat names format in an error or a log line.
+func describeFormat(format string) string {
+	switch format {
+	case compressionGzip, compressionZstd, formatBzip2, formatXz, formatLz4:
+		return "compressed with " + format
+	case formatZip, format7z, formatRar:
+		return "a " + format + " archive"
+	case "":
+		return "in an unknown format"
+	}
+	return "a " + format + " tar archive"
+}
+
+// unsupportedFormat returns the error of a run that can't read the archive at
+// url, found to be in format, with hint on what to do instead.
+func unsupportedFormat(url, format, hint string) error {
+	return fmt.Errorf("%w: %s is %s, %s", ErrUnsupportedFormat, url, describeFormat(format), hint)
+}
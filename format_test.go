@@ -0,0 +1,172 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tarHeader returns the first block of a tar with a member named name
+// written in format, v7 for tar.FormatUnknown.
+func tarHeader(t *testing.T, name string, format tar.Format) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	hdr := &tar.Header{Name: name, Mode: 0600, Typeflag: tar.TypeReg, Format: format}
+	if format == tar.FormatPAX {
+		hdr.PAXRecords = map[string]string{"comment": "pax"}
+	}
+	if format == tar.FormatUnknown {
+		hdr.Format = tar.FormatUSTAR
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	block := buf.Bytes()[:blockSize]
+	if format == tar.FormatUnknown {
+		// v7 headers have no magic, the checksum is computed again without it
+		copy(block[257:265], make([]byte, 8))
+		copy(block[148:156], "        ")
+		var sum int64
+		for _, c := range block {
+			sum += int64(c)
+		}
+		copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	}
+	return block
+}
+
+func TestSniffFormat(t *testing.T) {
+	tests := map[string]struct {
+		head []byte
+		want string
+	}{
+		"gzip":  {head: compressedArchive(t, compressionGzip, [][2]string{{"a.txt", "a"}}), want: compressionGzip},
+		"zstd":  {head: compressedArchive(t, compressionZstd, [][2]string{{"a.txt", "a"}}), want: compressionZstd},
+		"pax":   {head: tarHeader(t, "a.txt", tar.FormatPAX), want: formatPax},
+		"ustar": {head: tarHeader(t, "a.txt", tar.FormatUSTAR), want: formatUstar},
+		"gnu":   {head: tarHeader(t, "a.txt", tar.FormatGNU), want: formatGNU},
+		"v7":    {head: tarHeader(t, "a.txt", tar.FormatUnknown), want: formatV7},
+		// a member named like a file signature
+		"tar named like bzip2": {head: tarHeader(t, "BZh91AY", tar.FormatUSTAR), want: formatUstar},
+		"bzip2":                {head: []byte("BZh91AY&SY"), want: formatBzip2},
+		"xz":                   {head: []byte("\xfd7zXZ\x00\x00\x04"), want: formatXz},
+		"lz4":                  {head: []byte("\x04\x22\x4d\x18\x64\x40"), want: formatLz4},
+		"zip":                  {head: []byte("PK\x03\x04\x14\x00"), want: formatZip},
+		"empty zip":            {head: append([]byte("PK\x05\x06"), make([]byte, 18)...), want: formatZip},
+		"7z":                   {head: []byte("7z\xbc\xaf\x27\x1c\x00\x04"), want: format7z},
+		"rar":                  {head: []byte("Rar!\x1a\x07\x01\x00"), want: formatRar},
+		"text":                 {head: []byte("name,offset,size\n"), want: ""},
+		"zeros":                {head: make([]byte, blockSize), want: ""},
+		"empty":                {head: nil, want: ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := sniffFormat(tt.head); got != tt.want {
+				t.Errorf("sniffFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	tests := map[string]struct {
+		data []byte
+		run  func(ctx context.Context, client *s3.Client, opts *S3TarS3Options) error
+	}{
+		"list": {
+			data: compressedArchive(t, compressionGzip, [][2]string{{"a.txt", "a"}}),
+			run: func(ctx context.Context, client *s3.Client, opts *S3TarS3Options) error {
+				_, err := List(ctx, client, "src", "a.tar", opts)
+				return err
+			},
+		},
+		"extract": {
+			data: []byte("PK\x03\x04\x14\x00"),
+			run: func(ctx context.Context, client *s3.Client, opts *S3TarS3Options) error {
+				return Extract(ctx, client, "", opts)
+			},
+		},
+		"validate": {
+			data: compressedArchive(t, compressionZstd, [][2]string{{"a.txt", "a"}}),
+			run: func(ctx context.Context, client *s3.Client, opts *S3TarS3Options) error {
+				_, err := ValidateArchive(ctx, client, "src", "a.tar")
+				return err
+			},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			opts := &S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", Threads: 2}
+			client := fakeArchiveBucket(tt.data, map[string]string{}, &mu)
+			if err := tt.run(context.Background(), client, opts); !errors.Is(err, ErrUnsupportedFormat) {
+				t.Errorf("error = %v, want %v", err, ErrUnsupportedFormat)
+			}
+		})
+	}
+}
+
+func TestConcurrentFormats(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	objectList := func() []*S3Obj {
+		var l []*S3Obj
+		for i := 0; i < 20; i++ {
+			l = append(l, NewS3ObjOptions(WithBucketAndKey("src", fmt.Sprintf("%03d.txt", i)), WithSize(int64(100+i))))
+		}
+		return l
+	}
+	for _, o := range objectList() {
+		store.objects["src/"+*o.Key] = bytes.Repeat([]byte("a"), int(*o.Size))
+	}
+	formats := map[string]tar.Format{"pax.tar": tar.FormatPAX, "gnu.tar": tar.FormatGNU}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(formats))
+	for key, format := range formats {
+		key, format := key, format
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := &S3TarS3Options{DstBucket: "dst", DstKey: key, ConcatInMemory: true, Threads: 4, tarFormat: format}
+			errs <- createFromList(ctx, store.client(), objectList(), opts)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, format := range formats {
+		tr := tar.NewReader(bytes.NewReader(store.objects["dst/"+key]))
+		members := 0
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", key, err)
+			}
+			if gnu := hdr.Format == tar.FormatGNU; gnu != (format == tar.FormatGNU) {
+				t.Errorf("%s: %s is written as %s, want %s", key, hdr.Name, hdr.Format, format)
+			}
+			members++
+		}
+		if members != 20 {
+			t.Errorf("%s has %d members, want 20", key, members)
+		}
+	}
+}
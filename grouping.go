@@ -0,0 +1,181 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Grouping splits the objects of an archive built in memory into the groups
+// of its parts, every group being written to the archive in turn. A group
+// other than the last one must have at least 5MiB of data. The groups must
+// depend on the objects and the part size alone, so the same listing and
+// options always give the same part boundaries, see planDigest.
+type Grouping interface {
+	Groups(objectList []*S3Obj, partSize int64) [][]*S3Obj
+}
+
+// GroupingFunc is a Grouping made of a func.
+type GroupingFunc func(objectList []*S3Obj, partSize int64) [][]*S3Obj
+
+func (f GroupingFunc) Groups(objectList []*S3Obj, partSize int64) [][]*S3Obj {
+	return f(objectList, partSize)
+}
+
+var (
+	// GroupInOrder keeps the order of the listing and ends a part once it's
+	// over the part size, the default.
+	GroupInOrder Grouping = GroupingFunc(func(objectList []*S3Obj, partSize int64) [][]*S3Obj {
+		return splitSliceBySizeLimit(partSize, objectList)
+	})
+	// GroupBalanced packs the objects into as many parts as GroupInOrder
+	// makes, of sizes as even as they can be, the largest objects first. The
+	// objects keep their order within a part, and the parts the order of their
+	// first object, but the members of the archive aren't in the order of the
+	// listing anymore.
+	GroupBalanced Grouping = GroupingFunc(balancedGroups)
+)
+
+// GroupMaxObjects is GroupInOrder, but a part of at least 5MiB also ends once
+// it has n objects, so parts of many small objects are smaller and more of
+// them are built at once.
+func GroupMaxObjects(n int) Grouping {
+	return GroupingFunc(func(objectList []*S3Obj, partSize int64) [][]*S3Obj {
+		var groups [][]*S3Obj
+		var currentGroup []*S3Obj
+		var currentSize int64
+		for _, o := range objectList {
+			currentGroup = append(currentGroup, o)
+			currentSize += *o.Size
+			if currentSize > fileSizeMin && (currentSize > partSize || len(currentGroup) >= n) {
+				groups = append(groups, currentGroup)
+				currentGroup = nil
+				currentSize = 0
+			}
+		}
+		if len(currentGroup) > 0 {
+			groups = append(groups, currentGroup)
+		}
+		return groups
+	})
+}
+
+// WithGrouping sets how the objects of an archive built in memory are split
+// into parts, by default GroupInOrder.
+func WithGrouping(grouping Grouping) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.grouping = grouping
+	}
+}
+
+// ParseGrouping returns the Grouping named in-order, balanced or
+// max-objects=N.
+func ParseGrouping(s string) (Grouping, error) {
+	switch name, value, _ := strings.Cut(s, "="); name {
+	case "", "in-order":
+		return GroupInOrder, nil
+	case "balanced":
+		return GroupBalanced, nil
+	case "max-objects":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%w: max-objects=N needs a number of objects of at least 1, got %q", ErrInvalidArgument, value)
+		}
+		return GroupMaxObjects(n), nil
+	}
+	return nil, fmt.Errorf("%w: unknown grouping %q, valid values are in-order, balanced and max-objects=N", ErrInvalidArgument, s)
+}
+
+// groupingOf returns the Grouping of opts.
+func groupingOf(opts *S3TarS3Options) Grouping {
+	if opts.grouping == nil {
+		return GroupInOrder
+	}
+	return opts.grouping
+}
+
+// balancedGroups packs the objects into the parts of splitSliceBySizeLimit,
+// each object going to the smallest part so far, largest objects first. The
+// parts under 5MiB are merged until at most one is left, which goes last.
+func balancedGroups(objectList []*S3Obj, partSize int64) [][]*S3Obj {
+	inOrder := splitSliceBySizeLimit(partSize, objectList)
+	if len(inOrder) <= 1 {
+		return inOrder
+	}
+	bySize := make([]int, len(objectList))
+	for i := range bySize {
+		bySize[i] = i
+	}
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return *objectList[bySize[i]].Size > *objectList[bySize[j]].Size
+	})
+	type bin struct {
+		objects []int
+		size    int64
+	}
+	bins := make([]bin, len(inOrder))
+	for _, i := range bySize {
+		smallest := 0
+		for b := range bins {
+			if bins[b].size < bins[smallest].size {
+				smallest = b
+			}
+		}
+		bins[smallest].objects = append(bins[smallest].objects, i)
+		bins[smallest].size += *objectList[i].Size
+	}
+	for {
+		var small []int
+		for b := range bins {
+			if bins[b].size < fileSizeMin {
+				small = append(small, b)
+			}
+		}
+		if len(small) <= 1 {
+			break
+		}
+		a, b := small[0], small[1]
+		bins[a].objects, bins[a].size = append(bins[a].objects, bins[b].objects...), bins[a].size+bins[b].size
+		bins = append(bins[:b], bins[b+1:]...)
+	}
+	for _, b := range bins {
+		sort.Ints(b.objects)
+	}
+	sort.SliceStable(bins, func(i, j int) bool {
+		if small := bins[i].size < fileSizeMin; small != (bins[j].size < fileSizeMin) {
+			return !small
+		}
+		return bins[i].objects[0] < bins[j].objects[0]
+	})
+	groups := make([][]*S3Obj, 0, len(bins))
+	for _, b := range bins {
+		group := make([]*S3Obj, 0, len(b.objects))
+		for _, i := range b.objects {
+			group = append(group, objectList[i])
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// planDigest is the SHA-256 of the assignment of the objects to the parts of
+// partSize: the bucket, key, version and size of the objects of every group,
+// in order. Runs with the same digest have the same part boundaries, and the
+// same part checksums when the objects and their headers are the same.
+func planDigest(partSize int64, groups [][]*S3Obj) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", partSize)
+	for _, group := range groups {
+		fmt.Fprintf(h, "%d\n", len(group))
+		for _, o := range group {
+			fmt.Fprintf(h, "%q %q %q %d\n", o.Bucket, *o.Key, o.VersionId, *o.Size)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,140 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestGrouping(t *testing.T) {
+	const mb = 1 << 20
+	sizes := func(s ...int64) []*S3Obj {
+		var objectList []*S3Obj
+		for i, size := range s {
+			objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src", fmt.Sprintf("%03d", i)), WithSize(size)))
+		}
+		return objectList
+	}
+	var many []int64
+	for i := 0; i < 100; i++ {
+		many = append(many, mb/2)
+	}
+	tests := map[string]struct {
+		grouping   Grouping
+		objects    []*S3Obj
+		partSize   int64
+		wantParts  int
+		maxObjects int
+		// wantSpread is the most the sizes of the parts but the last one
+		// differ by
+		wantSpread int64
+	}{
+		"in order":             {grouping: GroupInOrder, objects: sizes(20*mb, mb, mb, 20*mb, mb, mb, 20*mb), partSize: 10 * mb, wantParts: 3},
+		"balanced":             {grouping: GroupBalanced, objects: sizes(20*mb, mb, mb, 20*mb, mb, mb, 20*mb), partSize: 10 * mb, wantParts: 3, wantSpread: 2 * mb},
+		"balanced uneven":      {grouping: GroupBalanced, objects: sizes(30*mb, 2*mb, 2*mb, 2*mb, 12*mb, 6*mb, 8*mb, 8*mb), partSize: 15 * mb, wantParts: 3, wantSpread: 10 * mb},
+		"balanced empty":       {grouping: GroupBalanced, objects: sizes(6*mb, 0, 6*mb, 0), partSize: 5 * mb, wantParts: 3},
+		"balanced single part": {grouping: GroupBalanced, objects: sizes(mb, mb), partSize: 5 * mb, wantParts: 1},
+		"max objects":          {grouping: GroupMaxObjects(12), objects: sizes(many...), partSize: 40 * mb, wantParts: 9, maxObjects: 12},
+		"max objects over 5MB": {grouping: GroupMaxObjects(2), objects: sizes(many...), partSize: 40 * mb, wantParts: 10},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			groups := tt.grouping.Groups(tt.objects, tt.partSize)
+			if len(groups) != tt.wantParts {
+				t.Fatalf("Groups() = %d parts, want %d", len(groups), tt.wantParts)
+			}
+			seen := map[*S3Obj]bool{}
+			var smallest, largest int64 = -1, 0
+			for i, group := range groups {
+				for _, o := range group {
+					if seen[o] {
+						t.Fatalf("%s is in more than one part", *o.Key)
+					}
+					seen[o] = true
+				}
+				size := objectsSize(group)
+				if i == len(groups)-1 {
+					continue
+				}
+				if size < fileSizeMin {
+					t.Errorf("part %d has %d bytes, under the 5MiB of a part", i+1, size)
+				}
+				if tt.maxObjects > 0 && len(group) > tt.maxObjects {
+					t.Errorf("part %d has %d objects, want at most %d", i+1, len(group), tt.maxObjects)
+				}
+				if smallest < 0 || size < smallest {
+					smallest = size
+				}
+				if size > largest {
+					largest = size
+				}
+			}
+			if len(seen) != len(tt.objects) {
+				t.Errorf("Groups() has %d objects, want %d", len(seen), len(tt.objects))
+			}
+			if tt.wantSpread > 0 && largest-smallest > tt.wantSpread {
+				t.Errorf("the parts are between %d and %d bytes, want at most %d apart", smallest, largest, tt.wantSpread)
+			}
+		})
+	}
+}
+
+func TestParseGrouping(t *testing.T) {
+	tests := map[string]struct {
+		value   string
+		wantErr error
+	}{
+		"default":       {value: ""},
+		"in order":      {value: "in-order"},
+		"balanced":      {value: "balanced"},
+		"max objects":   {value: "max-objects=1000"},
+		"no count":      {value: "max-objects", wantErr: ErrInvalidArgument},
+		"zero objects":  {value: "max-objects=0", wantErr: ErrInvalidArgument},
+		"unknown value": {value: "random", wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseGrouping(tt.value); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ParseGrouping(%q) error = %v, want %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlanDigest(t *testing.T) {
+	const mb = 1 << 20
+	listing := func() []*S3Obj {
+		var objectList []*S3Obj
+		for i, size := range []int64{20 * mb, mb, 3 * mb, 12 * mb, mb, 7 * mb} {
+			objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src", fmt.Sprintf("%03d", i)), WithSize(size)))
+		}
+		return objectList
+	}
+	want := planDigest(10*mb, GroupBalanced.Groups(listing(), 10*mb))
+	tests := map[string]struct {
+		grouping Grouping
+		change   func(objectList []*S3Obj)
+		partSize int64
+		same     bool
+	}{
+		"same listing":   {grouping: GroupBalanced, partSize: 10 * mb, same: true},
+		"in order":       {grouping: GroupInOrder, partSize: 10 * mb},
+		"part size":      {grouping: GroupBalanced, partSize: 15 * mb},
+		"object size":    {grouping: GroupBalanced, partSize: 10 * mb, change: func(l []*S3Obj) { *l[1].Size++ }},
+		"object version": {grouping: GroupBalanced, partSize: 10 * mb, change: func(l []*S3Obj) { l[1].VersionId = "v2" }},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			objectList := listing()
+			if tt.change != nil {
+				tt.change(objectList)
+			}
+			if got := planDigest(tt.partSize, tt.grouping.Groups(objectList, tt.partSize)); (got == want) != tt.same {
+				t.Errorf("planDigest() = %s, want the same as %s: %t", got, want, tt.same)
+			}
+		})
+	}
+}
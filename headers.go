@@ -14,7 +14,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -55,19 +55,12 @@ import (
 //	fmt.Println(result)
 func buildHeader(o, prev *S3Obj, addZeros bool, head *s3.HeadObjectOutput) S3Obj {
 
-	name := *o.Key
 	var buff bytes.Buffer
 	tw := tar.NewWriter(&buff)
-	hdr := &tar.Header{
-		Name:       name,
-		Mode:       0600,
-		Size:       *o.Size,
-		ModTime:    *o.LastModified,
-		ChangeTime: *o.LastModified,
-		AccessTime: time.Now(),
-		Format:     tarFormat,
+	hdr := objectHeader(o)
+	if head != nil {
+		preserveMetadata(o, hdr, head.Metadata)
 	}
-	setHeaderPermissionsS3Head(hdr, head)
 
 	if addZeros {
 		buff.Write(pad)
@@ -86,7 +79,6 @@ func buildHeader(o, prev *S3Obj, addZeros bool, head *s3.HeadObjectOutput) S3Obj
 		// didn't write the whole file. This part is already on Amazon S3
 	}
 	data := buff.Bytes()
-	atomic.AddInt64(&accum, int64(len(data)+int(*o.Size)))
 	ETag := fmt.Sprintf("%x", md5.Sum(data))
 	return S3Obj{
 		Object: types.Object{
@@ -98,10 +90,269 @@ func buildHeader(o, prev *S3Obj, addZeros bool, head *s3.HeadObjectOutput) S3Obj
 	}
 }
 
-func setHeaderPermissionsS3Head(hdr *tar.Header, head *s3.HeadObjectOutput) {
-	if head != nil {
-		setHeaderPermissions(hdr, head.Metadata)
+// objectHeader returns the tar header written in front of o when the archive
+// is assembled on Amazon S3.
+func objectHeader(o *S3Obj) *tar.Header {
+	hdr := &tar.Header{
+		Name:       o.MemberName(),
+		Mode:       0600,
+		Size:       *o.Size,
+		ModTime:    *o.LastModified,
+		ChangeTime: *o.LastModified,
+		AccessTime: time.Now(),
+		Format:     o.format(),
+		PAXRecords: paxRecords(o),
+	}
+	applyHeaderTransforms(o, hdr)
+	return hdr
+}
+
+// HeaderTransform changes the header written in front of o. Preserved POSIX
+// metadata is applied after the transforms, but for the times the transforms
+// set, see preserveMetadata.
+type HeaderTransform func(o *S3Obj, hdr *tar.Header)
+
+// WithHeaderTransforms appends transforms applied in order to every member header.
+func WithHeaderTransforms(transforms ...HeaderTransform) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.headerTransforms = append(opts.headerTransforms, transforms...)
+	}
+}
+
+func setHeaderTransforms(objectList []*S3Obj, transforms []HeaderTransform) {
+	for _, o := range objectList {
+		o.headerTransforms = transforms
+	}
+}
+
+// setTarFormat sets the format of the member headers of objectList. The
+// format is carried by the objects rather than shared, so archives of
+// different formats can be built at once.
+func setTarFormat(objectList []*S3Obj, format tar.Format) {
+	for _, o := range objectList {
+		o.tarFormat = format
+	}
+}
+
+// format is the format of the header of o, PAX when it isn't set.
+func (o *S3Obj) format() tar.Format {
+	if o.tarFormat == tar.FormatUnknown {
+		return tar.FormatPAX
+	}
+	return o.tarFormat
+}
+
+func applyHeaderTransforms(o *S3Obj, hdr *tar.Header) {
+	for _, t := range o.headerTransforms {
+		t(o, hdr)
+	}
+}
+
+// Ownership sets the mode, owner and group of every member. mode is octal,
+// owner and group are NAME, ID or NAME:ID as in GNU tar. Empty values keep the
+// defaults, mode 0600 owned by 0:0.
+func Ownership(mode, owner, group string) (HeaderTransform, error) {
+	var modeInt int64
+	if mode != "" {
+		var err error
+		modeInt, err = strconv.ParseInt(mode, 8, 64)
+		if err != nil || modeInt < 0 || modeInt > 07777 {
+			return nil, fmt.Errorf("%w: mode %q must be octal, e.g. 0644", ErrInvalidArgument, mode)
+		}
+	}
+	uname, uid, err := parseOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+	gname, gid, err := parseOwner(group)
+	if err != nil {
+		return nil, err
+	}
+	return func(o *S3Obj, hdr *tar.Header) {
+		if mode != "" {
+			hdr.Mode = modeInt
+		}
+		if owner != "" {
+			hdr.Uname, hdr.Uid = uname, uid
+		}
+		if group != "" {
+			hdr.Gname, hdr.Gid = gname, gid
+		}
+	}, nil
+}
+
+// Mtime sets the modification, access and change time of every member to t,
+// or with clamp only of the members newer than t, so archives of the same
+// objects are identical.
+func Mtime(t time.Time, clamp bool) HeaderTransform {
+	t = t.Truncate(time.Second)
+	return func(o *S3Obj, hdr *tar.Header) {
+		for _, field := range []*time.Time{&hdr.ModTime, &hdr.AccessTime, &hdr.ChangeTime} {
+			if !clamp || field.After(t) {
+				*field = t
+			}
+		}
+	}
+}
+
+// ParseMtime parses the date of --mtime: RFC 3339, YYYY-MM-DD or @ followed
+// by seconds since the epoch.
+func ParseMtime(s string) (time.Time, error) {
+	if strings.HasPrefix(s, "@") {
+		sec, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: mtime %q: %w", ErrInvalidArgument, s, err)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
 	}
+	return time.Time{}, fmt.Errorf("%w: mtime %q must be RFC 3339, YYYY-MM-DD or @seconds", ErrInvalidArgument, s)
+}
+
+// parseOwner splits NAME, ID or NAME:ID.
+func parseOwner(s string) (string, int, error) {
+	if s == "" {
+		return "", 0, nil
+	}
+	name, idStr, found := strings.Cut(s, ":")
+	if !found {
+		if id, err := strconv.Atoi(s); err == nil {
+			return "", id, checkOwnerId(s, id)
+		}
+		return s, 0, nil
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %q must be NAME, ID or NAME:ID", ErrInvalidArgument, s)
+	}
+	return name, id, checkOwnerId(s, id)
+}
+
+func checkOwnerId(s string, id int) error {
+	if id < 0 || id > 1<<21-1 {
+		return fmt.Errorf("%w: id of %q must be between 0 and %d", ErrInvalidArgument, s, 1<<21-1)
+	}
+	return nil
+}
+
+const (
+	paxRecordOrigin    = "S3TAR.origin"
+	paxRecordVersionId = "S3TAR.versionId"
+)
+
+// recordOrigin adds the s3:// url of every object to its member header.
+func recordOrigin(objectList []*S3Obj) {
+	for _, o := range objectList {
+		if o.PAXRecords == nil {
+			o.PAXRecords = map[string]string{}
+		}
+		o.PAXRecords[paxRecordOrigin] = objectURL(o.Bucket, *o.Key)
+	}
+}
+
+// PAXRecord is a record added to every member header, its value is a template
+// executed with the ObjectTemplateData of the object.
+type PAXRecord struct {
+	Key   string
+	value *template.Template
+}
+
+// ParsePAXRecord parses key=value. Keys without a "." are reserved for the
+// standard records and the ones starting with S3TAR. for the records of the tool.
+func ParsePAXRecord(s string) (PAXRecord, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return PAXRecord{}, fmt.Errorf("%w: pax record %q must be key=value", ErrInvalidArgument, s)
+	}
+	if !strings.Contains(key, ".") || strings.HasPrefix(key, "S3TAR.") {
+		return PAXRecord{}, fmt.Errorf("%w: pax record key %q must be VENDOR.keyword", ErrInvalidArgument, key)
+	}
+	if strings.ContainsAny(key, " \x00\n") {
+		return PAXRecord{}, fmt.Errorf("%w: pax record key %q can't contain spaces, NUL or newlines", ErrInvalidArgument, key)
+	}
+	t, err := parseObjectTemplate(key, value)
+	if err != nil {
+		return PAXRecord{}, fmt.Errorf("%w: pax record %q: %w", ErrInvalidArgument, s, err)
+	}
+	return PAXRecord{Key: key, value: t}, nil
+}
+
+// WithPAXRecords appends records added to every member header.
+func WithPAXRecords(records ...PAXRecord) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.paxRecords = append(opts.paxRecords, records...)
+	}
+}
+
+// setPAXRecords executes the records for every object.
+func setPAXRecords(objectList []*S3Obj, records []PAXRecord) error {
+	for _, o := range objectList {
+		for _, r := range records {
+			value, err := executeObjectTemplate(r.value, o, o.MemberName())
+			if err != nil {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("pax record %s: %w", r.Key, err)}
+			}
+			if strings.ContainsRune(value, 0) {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: pax record %s contains NUL", ErrInvalidArgument, r.Key)}
+			}
+			if o.PAXRecords == nil {
+				o.PAXRecords = map[string]string{}
+			}
+			o.PAXRecords[r.Key] = value
+		}
+	}
+	return nil
+}
+
+// setOriginVersion adds the version read to a header with an origin record.
+// Only headers written after the object is downloaded can have it, archives
+// assembled on Amazon S3 lay out the headers before reading any object.
+func setOriginVersion(hdr *tar.Header, versionId *string) {
+	if _, ok := hdr.PAXRecords[paxRecordOrigin]; ok && versionId != nil && *versionId != "" && *versionId != "null" {
+		hdr.PAXRecords[paxRecordVersionId] = *versionId
+	}
+}
+
+// paxRecords returns a copy of the records of o, nil when it has none.
+func paxRecords(o *S3Obj) map[string]string {
+	if len(o.PAXRecords) == 0 {
+		return nil
+	}
+	records := make(map[string]string, len(o.PAXRecords))
+	for k, v := range o.PAXRecords {
+		records[k] = v
+	}
+	return records
+}
+
+// tarHeaderSize returns the number of bytes hdr takes once encoded, including
+// any PAX or GNU extension blocks.
+func tarHeaderSize(hdr *tar.Header) int64 {
+	var buff bytes.Buffer
+	tw := tar.NewWriter(&buff)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return paxTarHeaderSize
+	}
+	return int64(buff.Len())
+}
+
+// preserveMetadata sets the preserved POSIX metadata of the object o on hdr,
+// which has the transforms of o applied. The mode and owner of the metadata
+// take precedence over the transforms, the times don't: the transforms are
+// applied again to the preserved times, so --mtime and --clamp-mtime hold.
+func preserveMetadata(o *S3Obj, hdr *tar.Header, s3metadata map[string]string) {
+	setHeaderPermissions(hdr, s3metadata)
+	if len(o.headerTransforms) == 0 {
+		return
+	}
+	timed := *hdr
+	timed.PAXRecords = nil
+	applyHeaderTransforms(o, &timed)
+	hdr.ModTime, hdr.AccessTime, hdr.ChangeTime = timed.ModTime, timed.AccessTime, timed.ChangeTime
 }
 
 // setHeaderPermissions sets the permissions, owner, and group of a tar.Header based on the metadata from s3.HeadObjectOutput.
@@ -126,6 +377,8 @@ func setHeaderPermissions(hdr *tar.Header, s3metadata map[string]string) {
 				log.Fatal(err)
 			}
 			hdr.Uid = int(ownerInt)
+			// the name would take precedence over the id on extraction
+			hdr.Uname = ""
 		}
 		if groupStr, ok := s3metadata["file-group"]; ok {
 			groupInt, err := strconv.ParseInt(groupStr, 10, 32)
@@ -133,6 +386,7 @@ func setHeaderPermissions(hdr *tar.Header, s3metadata map[string]string) {
 				log.Fatal(err)
 			}
 			hdr.Gid = int(groupInt)
+			hdr.Gname = ""
 		}
 		if atimeStr, ok := s3metadata["file-atime"]; ok {
 			hdr.AccessTime = s3metadataToTime(atimeStr)
@@ -166,8 +420,11 @@ func s3metadataToTime(timeStr string) time.Time {
 	return timeValue
 }
 
-func buildHeaders(objectList []*S3Obj, frontPad bool) []*S3Obj {
+// buildHeaders returns the headers of objectList and the size of the archive
+// they make with the objects, the 5MB front pad included.
+func buildHeaders(objectList []*S3Obj, frontPad bool) ([]*S3Obj, int64) {
 	headers := []*S3Obj{}
+	var size int64
 	for i := 0; i < len(objectList); i++ {
 		o := objectList[i]
 		name := *o.Key
@@ -189,19 +446,19 @@ func buildHeaders(objectList []*S3Obj, frontPad bool) []*S3Obj {
 		newObject.PartNum = i
 		newObject.Key = aws.String(filename + ".hdr")
 		headers = append(headers, &newObject)
+		size += *newObject.Size + *o.Size
 	}
-	return headers
+	return headers, size
 }
 
 func processHeaders(ctx context.Context, objectList []*S3Obj, frontPad bool) []*S3Obj {
-	headers := buildHeaders(objectList, frontPad)
+	headers, size := buildHeaders(objectList, frontPad)
 	sort.Sort(byPartNum(headers))
 
 	///////////////////////
 	// Create last header
 	// remove 5MB
-	atomic.AddInt64(&accum, -int64(beginningPad))
-	lastblockSize := findPadding(accum)
+	lastblockSize := findPadding(size - int64(beginningPad))
 	if lastblockSize == 0 {
 		lastblockSize = blockSize
 	}
@@ -0,0 +1,168 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestOwnership(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	ownership, err := Ownership("0644", "alice:1001", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectList := testObjects(10)
+	opts := &S3TarS3Options{ConcatInMemory: true}
+	if _, err := EstimateArchive(ctx, objectList, opts, WithHeaderTransforms(ownership)); err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := tarGroup(ctx, nil, objectList, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := tar.NewReader(bytes.NewReader(data)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Mode != 0644 || hdr.Uname != "alice" || hdr.Uid != 1001 || hdr.Gname != "" || hdr.Gid != 100 {
+		t.Errorf("header mode=%o owner=%s:%d group=%s:%d", hdr.Mode, hdr.Uname, hdr.Uid, hdr.Gname, hdr.Gid)
+	}
+
+	for _, args := range [][3]string{{"999", "", ""}, {"0644", "alice:bob", ""}, {"", "", "-1"}} {
+		if _, err := Ownership(args[0], args[1], args[2]); err == nil {
+			t.Errorf("Ownership(%q, %q, %q) expected an error", args[0], args[1], args[2])
+		}
+	}
+}
+
+func TestMtime(t *testing.T) {
+	epoch, err := ParseMtime("@1700000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, newer := epoch.Add(-time.Hour), epoch.Add(time.Hour)
+	tests := []struct {
+		clamp bool
+		in    time.Time
+		want  time.Time
+	}{
+		{clamp: false, in: older, want: epoch},
+		{clamp: false, in: newer, want: epoch},
+		{clamp: true, in: older, want: older},
+		{clamp: true, in: newer, want: epoch},
+	}
+	for _, tt := range tests {
+		hdr := &tar.Header{ModTime: tt.in, AccessTime: tt.in, ChangeTime: tt.in}
+		Mtime(epoch, tt.clamp)(nil, hdr)
+		if !hdr.ModTime.Equal(tt.want) || !hdr.AccessTime.Equal(tt.want) || !hdr.ChangeTime.Equal(tt.want) {
+			t.Errorf("Mtime(clamp=%t)(%s) = %s, want %s", tt.clamp, tt.in, hdr.ModTime, tt.want)
+		}
+	}
+	for _, s := range []string{"2024-01-02", "2024-01-02T03:04:05Z"} {
+		if _, err := ParseMtime(s); err != nil {
+			t.Errorf("ParseMtime(%s) error = %v", s, err)
+		}
+	}
+	if _, err := ParseMtime("yesterday"); err == nil {
+		t.Errorf("ParseMtime(yesterday) expected an error")
+	}
+}
+
+func TestParsePAXRecord(t *testing.T) {
+	for _, s := range []string{"classification", "=x", "path=x", "S3TAR.origin=x", "ACME.id={{.Nope"} {
+		if _, err := ParsePAXRecord(s); err == nil {
+			t.Errorf("ParsePAXRecord(%s) expected an error", s)
+		}
+	}
+	ctx := SetupLogger(context.Background())
+	objectList := testObjects(10, 20)
+	opts := &S3TarS3Options{ConcatInMemory: true}
+	var records []PAXRecord
+	for _, s := range []string{"ACME.classification=internal", "ACME.dataset={{.Bucket}}:{{.Base | upper}}"} {
+		r, err := ParsePAXRecord(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	e, err := EstimateArchive(ctx, objectList, opts, WithPAXRecords(records...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := tarGroup(ctx, nil, objectList, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.TotalSize != int64(len(data)) {
+		t.Errorf("EstimateArchive() TotalSize = %d, want %d", e.TotalSize, len(data))
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for _, o := range objectList {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hdr.PAXRecords["ACME.classification"]; got != "internal" {
+			t.Errorf("PAXRecords[ACME.classification] = %s, want internal", got)
+		}
+		if got, want := hdr.PAXRecords["ACME.dataset"], "bucket:"+strings.ToUpper(path.Base(*o.Key)); got != want {
+			t.Errorf("PAXRecords[ACME.dataset] = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestMtime_PreservedMetadata(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	older := time.Unix(1600000000, 0)
+	metadata := map[string]string{"file-permissions": "0755", "file-mtime": "1600000000000", "file-atime": "1800000000000", "file-ctime": "1600000000000"}
+	tests := map[string]struct {
+		clamp bool
+		want  [3]time.Time // mtime, atime, ctime
+	}{
+		"mtime":       {want: [3]time.Time{epoch, epoch, epoch}},
+		"clamp-mtime": {clamp: true, want: [3]time.Time{older, epoch, older}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := SetupLogger(context.Background())
+			store := &fakeStore{objects: map[string][]byte{"src/a.txt": []byte("hello")}, metadata: map[string]map[string]string{"src/a.txt": metadata}}
+			o := NewS3ObjOptions(WithBucketAndKey("src", "a.txt"), WithSize(5))
+			setHeaderTransforms([]*S3Obj{o}, []HeaderTransform{Mtime(epoch, tt.clamp)})
+
+			// the headers of the server-side concatenation and of the archives
+			// built in memory
+			headers := map[string][]byte{"server-side": buildHeader(o, nil, false, &s3.HeadObjectOutput{Metadata: metadata}).Data}
+			data, _, err := tarGroup(ctx, store.client(), []*S3Obj{o}, &S3TarS3Options{PreservePOSIXMetadata: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers["in memory"] = data
+			for path, data := range headers {
+				hdr, err := tar.NewReader(bytes.NewReader(data)).Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := [3]time.Time{hdr.ModTime, hdr.AccessTime, hdr.ChangeTime}
+				for i := range got {
+					if !got[i].Equal(tt.want[i]) {
+						t.Errorf("%s times = %v, want %v", path, got, tt.want)
+						break
+					}
+				}
+				if hdr.Mode != 0755 {
+					t.Errorf("%s mode = %o, want the preserved 0755", path, hdr.Mode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// validateHybridThreshold checks opts.HybridThreshold. Objects under it are
+// downloaded and the others copied server-side, which --concat-in-memory and
+// --server-side-only both rule out.
+func validateHybridThreshold(opts *S3TarS3Options) error {
+	switch {
+	case opts.HybridThreshold < 0:
+		return fmt.Errorf("%w: the hybrid threshold can't be negative", ErrInvalidArgument)
+	case opts.HybridThreshold == 0:
+	case opts.ConcatInMemory:
+		return fmt.Errorf("%w: --hybrid-threshold and --concat-in-memory can't be used together", ErrInvalidArgument)
+	case opts.ServerSideOnly:
+		return fmt.Errorf("%w: --hybrid-threshold and --server-side-only can't be used together", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// isHybridSmall reports whether o is downloaded instead of copied server-side,
+// because it's under opts.HybridThreshold.
+func isHybridSmall(o *S3Obj, opts *S3TarS3Options) bool {
+	return opts.HybridThreshold > 0 && *o.Size > 0 && *o.Size < opts.HybridThreshold
+}
+
+// coalesceParts downloads the objects of parts under opts.HybridThreshold with
+// client and merges every run of consecutive parts held in memory, the headers
+// and the small objects, into a single part. A run of small objects is then
+// written with one UploadPart instead of a merge per header and per object,
+// the larger objects are still copied with UploadPartCopy.
+func coalesceParts(ctx context.Context, client *s3.Client, parts []*S3Obj, opts *S3TarS3Options) ([]*S3Obj, error) {
+	var coalesced []*S3Obj
+	var run *S3Obj
+	for _, p := range parts {
+		if *p.Size == 0 {
+			continue
+		}
+		if len(p.Data) == 0 && isHybridSmall(p, opts) {
+			data, _, _, err := downloadWithPolicy(ctx, client, p, OnErrorFail)
+			if err != nil {
+				return nil, err
+			}
+			trackDownloaded(ctx, int64(len(data)))
+			p = &S3Obj{Object: p.Object, Bucket: p.Bucket, Data: data}
+		}
+		if len(p.Data) == 0 {
+			run = nil
+			coalesced = append(coalesced, p)
+			continue
+		}
+		if run == nil || int64(len(run.Data)+len(p.Data)) > partSizeMax {
+			run = &S3Obj{Object: types.Object{Key: aws.String("coalesced"), Size: aws.Int64(0)}}
+			coalesced = append(coalesced, run)
+		}
+		run.Data = append(run.Data, p.Data...)
+		*run.Size = int64(len(run.Data))
+	}
+	return coalesced, nil
+}
@@ -0,0 +1,48 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidateHybridThreshold(t *testing.T) {
+	tests := []struct {
+		opts    S3TarS3Options
+		wantErr bool
+	}{
+		{opts: S3TarS3Options{}},
+		{opts: S3TarS3Options{HybridThreshold: 1024 * 1024}},
+		{opts: S3TarS3Options{ConcatInMemory: true}},
+		{opts: S3TarS3Options{HybridThreshold: -1}, wantErr: true},
+		{opts: S3TarS3Options{HybridThreshold: 1024 * 1024, ConcatInMemory: true}, wantErr: true},
+		{opts: S3TarS3Options{HybridThreshold: 1024 * 1024, ServerSideOnly: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := validateHybridThreshold(&tt.opts); (err != nil) != tt.wantErr {
+			t.Errorf("validateHybridThreshold(%+v) error = %v, wantErr %v", tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCoalesceParts(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	headers := testObjects(512, 1024, 512)
+	large := NewS3ObjOptions(WithBucketAndKey("bucket", "large"), WithSize(fileSizeMin))
+	empty := NewS3ObjOptions(WithBucketAndKey("bucket", "empty"), WithSize(0))
+	parts := []*S3Obj{headers[0], large, headers[1], empty, headers[2]}
+
+	// no object is under the threshold, only the headers are coalesced
+	got, err := coalesceParts(ctx, nil, parts, &S3TarS3Options{HybridThreshold: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[1] != large {
+		t.Fatalf("coalesceParts() = %d parts, want header, large object, headers", len(got))
+	}
+	if *got[0].Size != 512 || *got[2].Size != 1024+512 || int64(len(got[2].Data)) != *got[2].Size {
+		t.Errorf("coalesceParts() sizes = %d, %d, want 512, %d", *got[0].Size, *got[2].Size, 1024+512)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// OverwritePolicy decides what happens when the archive already exists.
+type OverwritePolicy string
+
+const (
+	// OverwriteAlways replaces the existing archive.
+	OverwriteAlways OverwritePolicy = "always"
+	// OverwriteNever fails the run, as NoClobber.
+	OverwriteNever OverwritePolicy = "never"
+	// OverwriteIfDifferent skips the run when the archive was created from
+	// the same manifest hash and replaces it otherwise, as Idempotent.
+	OverwriteIfDifferent OverwritePolicy = "if-different"
+)
+
+// validateOverwritePolicy sets NoClobber and Idempotent from opts.Overwrite.
+func validateOverwritePolicy(opts *S3TarS3Options) error {
+	switch opts.Overwrite {
+	case "", OverwriteAlways:
+	case OverwriteNever:
+		opts.NoClobber = true
+	case OverwriteIfDifferent:
+		opts.Idempotent = true
+	default:
+		return fmt.Errorf("%w: unknown overwrite policy %q", ErrInvalidArgument, opts.Overwrite)
+	}
+	return nil
+}
+
+// manifestHashKey is the user metadata of the archive that records the hash
+// of the manifest it was created from.
+const manifestHashKey = "s3tar-manifest-hash"
+
+// manifestHash returns a hash of the objects to archive, the headers of their
+// members and the options that change the archive or its TOC, so two runs get
+// the same hash only when they would create the same archive.
+func manifestHash(objectList []*S3Obj, format tar.Format, opts *S3TarS3Options) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "format=%s concat-in-memory=%t posix-metadata=%t storage-class=%s kms=%q sse=%s tags=%q\n",
+		format, opts.ConcatInMemory, opts.PreservePOSIXMetadata, opts.storageClass, opts.KMSKeyID, opts.SSEAlgo, TagsToUrlEncodedString(opts.ObjectTags))
+	fmt.Fprintf(h, "external-toc=%q toc-checksums=%t toc-extended=%t part-padding=%s min-part-size=%d max-part-size=%d\n",
+		opts.ExternalToc, opts.TocChecksums, opts.TocExtended, opts.PartPadding, opts.UserMinPartSize, opts.UserMaxPartSize)
+	for _, o := range objectList {
+		writeObjectHash(h, o)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func writeObjectHash(h hash.Hash, o *S3Obj) {
+	hdr := &tar.Header{Name: o.MemberName(), Mode: 0600}
+	if o.Size != nil {
+		hdr.Size = *o.Size
+	}
+	if o.LastModified != nil {
+		hdr.ModTime = *o.LastModified
+	}
+	applyHeaderTransforms(o, hdr)
+	var etag string
+	if o.ETag != nil {
+		etag = *o.ETag
+	}
+	fmt.Fprintf(h, "%q %q %q %d %q %o %d %d %q %q %d",
+		o.Bucket, *o.Key, etag, hdr.Size, hdr.Name, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname, hdr.ModTime.Unix())
+	keys := make([]string, 0, len(o.PAXRecords))
+	for k := range o.PAXRecords {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, " %q=%q", k, o.PAXRecords[k])
+	}
+	h.Write([]byte("\n"))
+}
+
+// existingArchive returns the archive at the destination when its manifest
+// hash is hash, nil when there is none or it was created from another manifest.
+func existingArchive(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, hash string) (*S3Obj, error) {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &opts.DstBucket, Key: &opts.DstKey})
+	if err != nil {
+		if err = classifyError(err); errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if head.Metadata[manifestHashKey] != hash {
+		Debugf(ctx, "s3://%s/%s has manifest hash %q, want %s", opts.DstBucket, opts.DstKey, head.Metadata[manifestHashKey], hash)
+		return nil, nil
+	}
+	return &S3Obj{
+		Bucket: opts.DstBucket,
+		Object: types.Object{
+			Key:          &opts.DstKey,
+			ETag:         head.ETag,
+			Size:         head.ContentLength,
+			LastModified: head.LastModified,
+		},
+	}, nil
+}
+
+// archiveMetadata is the user metadata the archive is written with, its
+// manifest hash and the run that wrote it.
+func archiveMetadata(opts *S3TarS3Options) map[string]string {
+	metadata := map[string]string{}
+	if opts.manifestHash != "" {
+		metadata[manifestHashKey] = opts.manifestHash
+	}
+	if opts.RunID != "" {
+		metadata[runIDKey] = opts.RunID
+	}
+	if opts.chain != nil {
+		metadata[chainVolumeKey] = opts.chain.volume
+		metadata[chainManifestKey] = opts.chain.manifest
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
@@ -0,0 +1,124 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestManifestHash(t *testing.T) {
+	objects := func() []*S3Obj {
+		return []*S3Obj{
+			{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(10), ETag: aws.String(`"abc"`)}},
+			{Bucket: "bucket", Object: types.Object{Key: aws.String("b.txt"), Size: aws.Int64(20), ETag: aws.String(`"def"`)}},
+		}
+	}
+	opts := &S3TarS3Options{}
+	want := manifestHash(objects(), tar.FormatPAX, opts)
+	if got := manifestHash(objects(), tar.FormatPAX, opts); got != want {
+		t.Fatalf("manifestHash() = %s then %s for the same objects", want, got)
+	}
+
+	mode, err := Ownership("0644", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		change func([]*S3Obj, *S3TarS3Options) tar.Format
+	}{
+		{name: "etag", change: func(o []*S3Obj, _ *S3TarS3Options) tar.Format {
+			o[1].ETag = aws.String(`"changed"`)
+			return tar.FormatPAX
+		}},
+		{name: "new object", change: func(o []*S3Obj, _ *S3TarS3Options) tar.Format {
+			o[1].Key = aws.String("c.txt")
+			return tar.FormatPAX
+		}},
+		{name: "member name", change: func(o []*S3Obj, _ *S3TarS3Options) tar.Format {
+			o[0].Name = "dir/a.txt"
+			return tar.FormatPAX
+		}},
+		{name: "header transform", change: func(o []*S3Obj, _ *S3TarS3Options) tar.Format {
+			setHeaderTransforms(o, []HeaderTransform{mode})
+			return tar.FormatPAX
+		}},
+		{name: "pax record", change: func(o []*S3Obj, _ *S3TarS3Options) tar.Format {
+			o[0].PAXRecords = map[string]string{"ACME.id": "1"}
+			return tar.FormatPAX
+		}},
+		{name: "format", change: func(o []*S3Obj, _ *S3TarS3Options) tar.Format {
+			return tar.FormatGNU
+		}},
+		{name: "storage class", change: func(o []*S3Obj, opts *S3TarS3Options) tar.Format {
+			opts.storageClass = types.StorageClassGlacier
+			return tar.FormatPAX
+		}},
+		{name: "toc checksums", change: func(o []*S3Obj, opts *S3TarS3Options) tar.Format {
+			opts.TocChecksums = true
+			return tar.FormatPAX
+		}},
+		{name: "extended toc", change: func(o []*S3Obj, opts *S3TarS3Options) tar.Format {
+			opts.TocExtended = true
+			return tar.FormatPAX
+		}},
+		{name: "external toc", change: func(o []*S3Obj, opts *S3TarS3Options) tar.Format {
+			opts.ExternalToc = "s3://bucket/a.toc.csv"
+			return tar.FormatPAX
+		}},
+		{name: "part padding", change: func(o []*S3Obj, opts *S3TarS3Options) tar.Format {
+			opts.PartPadding = PartPaddingExactFit
+			return tar.FormatPAX
+		}},
+		{name: "part size", change: func(o []*S3Obj, opts *S3TarS3Options) tar.Format {
+			opts.UserMaxPartSize = 64
+			return tar.FormatPAX
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objectList, opts := objects(), &S3TarS3Options{}
+			format := tt.change(objectList, opts)
+			if got := manifestHash(objectList, format, opts); got == want {
+				t.Errorf("manifestHash() didn't change")
+			}
+		})
+	}
+
+	if got := archiveMetadata(&S3TarS3Options{}); got != nil {
+		t.Errorf("archiveMetadata() = %v, want nil", got)
+	}
+	if got := archiveMetadata(&S3TarS3Options{manifestHash: want}); got[manifestHashKey] != want {
+		t.Errorf("archiveMetadata() = %v, want %s", got, want)
+	}
+}
+
+func TestValidateOverwritePolicy(t *testing.T) {
+	tests := []struct {
+		policy     OverwritePolicy
+		noClobber  bool
+		idempotent bool
+		wantErr    bool
+	}{
+		{policy: ""},
+		{policy: OverwriteAlways},
+		{policy: OverwriteNever, noClobber: true},
+		{policy: OverwriteIfDifferent, idempotent: true},
+		{policy: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		opts := &S3TarS3Options{Overwrite: tt.policy}
+		err := validateOverwritePolicy(opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateOverwritePolicy(%q) error = %v, wantErr %v", tt.policy, err, tt.wantErr)
+		}
+		if opts.NoClobber != tt.noClobber || opts.Idempotent != tt.idempotent {
+			t.Errorf("validateOverwritePolicy(%q) NoClobber = %t, Idempotent = %t", tt.policy, opts.NoClobber, opts.Idempotent)
+		}
+	}
+}
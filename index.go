@@ -0,0 +1,68 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// IndexFormat is the format of an index of the members of an archive for
+// third-party random access tools, see WriteIndex.
+type IndexFormat string
+
+const (
+	// IndexTarindexer is the index of tarindexer, a line per member with its
+	// name, the offset of its data and its size, separated by spaces.
+	IndexTarindexer IndexFormat = "tarindexer"
+	// IndexSQLite is a SQLite database with a row per archive in the table
+	// archives and a row per member in the table members, with the fields
+	// of the TOC, so the members of one or many archives can be queried with
+	// the sqlite3 shell or any SQLite library.
+	IndexSQLite IndexFormat = "sqlite"
+)
+
+// IndexedArchive is an archive of an index: its s3:// url, empty when it
+// isn't known, and its TOC.
+type IndexedArchive struct {
+	Archive string
+	Toc     TOC
+}
+
+// WriteIndex writes the index of the members of toc in format to a local
+// path or an s3:// url, so tools that read members at their offsets can use
+// an archive without indexing it again.
+func WriteIndex(ctx context.Context, svc *s3.Client, location string, toc TOC, format IndexFormat) error {
+	return WriteArchivesIndex(ctx, svc, location, []IndexedArchive{{Toc: toc}}, format)
+}
+
+// WriteArchivesIndex writes the index of the members of archives in format
+// to a local path or an s3:// url. Only an IndexSQLite index holds more than
+// one archive.
+func WriteArchivesIndex(ctx context.Context, svc *s3.Client, location string, archives []IndexedArchive, format IndexFormat) error {
+	if len(archives) != 1 && format != IndexSQLite {
+		return fmt.Errorf("%w: a %s index has a single archive, not %d", ErrInvalidArgument, format, len(archives))
+	}
+	buf := bytes.Buffer{}
+	switch format {
+	case IndexTarindexer:
+		for _, m := range archives[0].Toc {
+			// the lines are split on the last two spaces, names can have
+			// spaces but not new lines
+			if strings.ContainsAny(m.Filename, "\r\n") {
+				return fmt.Errorf("%w: %q can't be in a %s index", ErrInvalidArgument, m.Filename, format)
+			}
+			fmt.Fprintf(&buf, "%s %d %d\n", m.Filename, m.Start, m.Size)
+		}
+	case IndexSQLite:
+		buf.Write(sqliteIndex(archives))
+	default:
+		return fmt.Errorf("%w: unknown index format %q, use %s or %s", ErrInvalidArgument, format, IndexTarindexer, IndexSQLite)
+	}
+	return saveFile(ctx, svc, location, buf.Bytes())
+}
@@ -0,0 +1,49 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteIndex(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	tests := map[string]struct {
+		toc     TOC
+		format  IndexFormat
+		want    string
+		wantErr error
+	}{
+		"tarindexer": {
+			toc:    TOC{{Filename: "a.txt", Start: 1536, Size: 5}, {Filename: "dir/with space.txt", Start: 2560, Size: 0}},
+			format: IndexTarindexer,
+			want:   "a.txt 1536 5\ndir/with space.txt 2560 0\n",
+		},
+		"new line":       {toc: TOC{{Filename: "a\nb", Start: 1536}}, format: IndexTarindexer, wantErr: ErrInvalidArgument},
+		"unknown format": {format: "ratarmount", wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "index")
+			err := WriteIndex(ctx, nil, location, tt.toc, tt.format)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WriteIndex() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := os.ReadFile(location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("WriteIndex() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,9 +6,9 @@ package s3tar
 import (
 	"context"
 	"encoding/csv"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"io"
-	"log"
 	"net/url"
 	"strconv"
 )
@@ -19,10 +19,10 @@ func LoadCSV(ctx context.Context, svc *s3.Client, fpath string, skipHeader, urlD
 		return nil, 0, err
 	}
 	defer r.Close()
-	return parseCSV(r, skipHeader, urlDecode)
+	return parseCSV(ctx, r, skipHeader, urlDecode)
 }
 
-func parseCSV(f io.Reader, skipHeader bool, urlDecode bool) ([]*S3Obj, int64, error) {
+func parseCSV(ctx context.Context, f io.Reader, skipHeader bool, urlDecode bool) ([]*S3Obj, int64, error) {
 
 	var data []*S3Obj
 	var accum int64
@@ -39,13 +39,13 @@ func parseCSV(f io.Reader, skipHeader bool, urlDecode bool) ([]*S3Obj, int64, er
 			continue
 		}
 		if len(record) < 3 {
-			log.Printf("not enough values in csv line. skipping line %d", lineNumber+1)
+			Warnf(ctx, "not enough values in csv line. skipping line %d", lineNumber+1)
 			continue
 		}
 
 		size, err := strconv.ParseInt(record[2], 10, 64)
 		if err != nil {
-			log.Printf("unable to parse size. setting to zero")
+			Warnf(ctx, "unable to parse size on line %d. setting to zero", lineNumber+1)
 			size = 0
 		}
 
@@ -57,6 +57,20 @@ func parseCSV(f io.Reader, skipHeader bool, urlDecode bool) ([]*S3Obj, int64, er
 			}
 		}
 
+		if isPresignedURL(record[0]) {
+			checksum := ""
+			if len(record) > 3 {
+				checksum = record[3]
+			}
+			obj, err := presignedObject(record[0], key, size, checksum)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", lineNumber+1, err)
+			}
+			data = append(data, obj)
+			accum += estimateObjectSize(size)
+			continue
+		}
+
 		opts := []func(*S3Obj){
 			WithBucketAndKey(record[0], key),
 			WithSize(size),
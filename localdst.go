@@ -0,0 +1,201 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// localKey is the key of a local file, its absolute path without the leading
+// slash, so file:///<key> is the file.
+func localKey(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
+
+// archiveURL returns the s3:// url of the archive of opts, or its file:// url
+// when it's written to DstPath.
+func archiveURL(opts *S3TarS3Options) string {
+	if opts.DstPath != "" {
+		return objectURL("", localKey(opts.DstPath))
+	}
+	return objectURL(opts.DstBucket, opts.DstKey)
+}
+
+// checkLocalDestination validates the options of a run writing the archive to
+// the local file DstPath. It's built in memory, there is no bucket to copy
+// the objects to server-side or to record the manifest hash in.
+func checkLocalDestination(opts *S3TarS3Options) error {
+	if opts.DstPath == "" {
+		return nil
+	}
+	if opts.ServerSideOnly {
+		return fmt.Errorf("%w: a local archive can't be created with --server-side-only", ErrInvalidArgument)
+	}
+	if opts.Idempotent {
+		return fmt.Errorf("%w: --idempotent and --overwrite if-different need an Amazon S3 destination", ErrInvalidArgument)
+	}
+	if opts.Scratch != "" {
+		return fmt.Errorf("%w: --scratch needs an Amazon S3 destination", ErrInvalidArgument)
+	}
+	if opts.SignTocKeyID != "" {
+		return fmt.Errorf("%w: --sign-toc needs an Amazon S3 destination", ErrInvalidArgument)
+	}
+	if opts.Provenance {
+		return fmt.Errorf("%w: --provenance needs an Amazon S3 destination", ErrInvalidArgument)
+	}
+	if opts.TocTable.Name != "" {
+		return fmt.Errorf("%w: --dynamodb-table needs an Amazon S3 destination", ErrInvalidArgument)
+	}
+	path, err := filepath.Abs(opts.DstPath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	opts.DstPath = path
+	opts.ConcatInMemory = true
+	return nil
+}
+
+// checkLocalNoClobber fails early when the local archive exists.
+func checkLocalNoClobber(opts *S3TarS3Options) error {
+	if _, err := os.Stat(opts.DstPath); err == nil {
+		return &ObjectError{Key: localKey(opts.DstPath), Err: ErrDestinationExists}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// localUpload is the multipart upload of a local archive. The parts are
+// written as files under <archive>.parts/<run id>/ next to it and
+// concatenated in order into the archive when it's completed, so a run that
+// fails or is interrupted never leaves a partial archive behind.
+type localUpload struct {
+	path      string
+	dir       string
+	noClobber bool
+}
+
+func createLocalUpload(opts *S3TarS3Options) (*localUpload, error) {
+	u := &localUpload{path: opts.DstPath, dir: fmt.Sprintf("%s.parts/%s", opts.DstPath, opts.RunID), noClobber: opts.NoClobber}
+	if err := os.MkdirAll(u.dir, 0755); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *localUpload) partPath(partNum int32) string {
+	return filepath.Join(u.dir, fmt.Sprintf("part-%05d", partNum))
+}
+
+func (u *localUpload) uploadPart(ctx context.Context, partNum int32, data []byte) (types.CompletedPart, error) {
+	if err := os.WriteFile(u.partPath(partNum), data, 0644); err != nil {
+		return types.CompletedPart{}, err
+	}
+	etag := fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(data)))
+	return types.CompletedPart{ETag: &etag, PartNumber: &partNum}, nil
+}
+
+func (u *localUpload) complete(ctx context.Context, parts []types.CompletedPart) (*S3Obj, error) {
+	archive := filepath.Join(u.dir, "archive")
+	f, err := os.OpenFile(archive, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		if err := appendFile(f, u.partPath(*p.PartNumber)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := closeSynced(f); err != nil {
+		return nil, err
+	}
+	if err := commitLocalFile(archive, u.path, u.noClobber); err != nil {
+		return nil, err
+	}
+	u.abort(ctx)
+	return localArchive(u.path), nil
+}
+
+// abort removes the parts of the upload, and the parts directory of the
+// archive once no other run uses it.
+func (u *localUpload) abort(ctx context.Context) {
+	if err := os.RemoveAll(u.dir); err != nil {
+		Warnf(ctx, "unable to remove %s: %s", u.dir, err.Error())
+	}
+	os.Remove(filepath.Dir(u.dir))
+}
+
+// writeLocalArchive writes an archive built as a single part to opts.DstPath.
+func writeLocalArchive(ctx context.Context, data []byte, opts *S3TarS3Options) (*S3Obj, error) {
+	tmp := fmt.Sprintf("%s.%s.tmp", opts.DstPath, opts.RunID)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := closeSynced(f); err != nil {
+		return nil, err
+	}
+	if err := commitLocalFile(tmp, opts.DstPath, opts.NoClobber); err != nil {
+		return nil, err
+	}
+	trackUploaded(ctx, int64(len(data)))
+	complete := localArchive(opts.DstPath)
+	complete.Size = aws.Int64(int64(len(data)))
+	return complete, nil
+}
+
+// commitLocalFile moves the archive written to tmp to path. With noClobber it
+// fails with ErrDestinationExists instead of replacing an existing file.
+func commitLocalFile(tmp, path string, noClobber bool) error {
+	if !noClobber {
+		return os.Rename(tmp, path)
+	}
+	if err := os.Link(tmp, path); err != nil {
+		if os.IsExist(err) {
+			return &ObjectError{Key: localKey(path), Err: ErrDestinationExists}
+		}
+		return err
+	}
+	return os.Remove(tmp)
+}
+
+func appendFile(f *os.File, path string) error {
+	part, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+	_, err = io.Copy(f, part)
+	return err
+}
+
+func closeSynced(f *os.File) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func localArchive(path string) *S3Obj {
+	now := time.Now()
+	key := localKey(path)
+	return &S3Obj{Object: types.Object{Key: &key, LastModified: &now}}
+}
@@ -0,0 +1,86 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestLocalDestination(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	client := s3.New(s3.Options{Region: "us-west-2"})
+	tests := map[string][]int{
+		"single part": {100, 2000},
+		"multipart":   {6 * 1024 * 1024, 100, 3 * 1024 * 1024, 6 * 1024 * 1024},
+	}
+	for name, sizes := range tests {
+		src, dst := t.TempDir(), t.TempDir()
+		for i, size := range sizes {
+			data := bytes.Repeat([]byte{byte('a' + i)}, size)
+			if err := os.WriteFile(filepath.Join(src, string(rune('a'+i))), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		objectList, _, err := ListLocalFiles(ctx, src, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := filepath.Join(dst, "archive.tar")
+		opts := &S3TarS3Options{DstPath: archive, Threads: 2, UserMaxPartSize: 5}
+		if err := createFromList(ctx, client, objectList, opts); err != nil {
+			t.Fatalf("%s: createFromList() error = %v", name, err)
+		}
+
+		f, err := os.Open(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(f)
+		members := 0
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: reading the archive: %v", name, err)
+			}
+			n, err := io.Copy(io.Discard, tr)
+			if err != nil || n != hdr.Size {
+				t.Errorf("%s: %s has %d bytes, want %d", name, hdr.Name, n, hdr.Size)
+			}
+			members++
+		}
+		f.Close()
+		if members != len(sizes) {
+			t.Errorf("%s: archive has %d members, want %d", name, members, len(sizes))
+		}
+		toc, err := os.ReadFile(archive + ".toc.csv")
+		if err != nil || strings.Count(string(toc), "\n") != len(sizes) {
+			t.Errorf("%s: toc = %q, %v", name, toc, err)
+		}
+		if _, err := os.Stat(archive + ".parts"); !os.IsNotExist(err) {
+			t.Errorf("%s: the parts directory is left behind", name)
+		}
+
+		opts = &S3TarS3Options{DstPath: archive, Threads: 2, NoClobber: true}
+		if err := createFromList(ctx, client, objectList, opts); !errors.Is(err, ErrDestinationExists) {
+			t.Errorf("%s: createFromList(no clobber) error = %v, want ErrDestinationExists", name, err)
+		}
+	}
+
+	if err := checkLocalDestination(&S3TarS3Options{DstPath: "archive.tar", ServerSideOnly: true}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("checkLocalDestination(server-side-only) error = %v", err)
+	}
+}
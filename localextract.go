@@ -0,0 +1,354 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// maxWindowsPath is MAX_PATH without its terminating NUL, the longest
+	// path most Windows programs can open.
+	maxWindowsPath = 259
+	// maxWindowsName is the longest name of a file or a directory on NTFS.
+	maxWindowsName = 255
+	// windowsInvalid are the characters Windows doesn't allow in names, with
+	// the control characters.
+	windowsInvalid = `<>:"|?*`
+)
+
+// windowsReserved are the device names Windows doesn't allow as names, with
+// or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// RenamedMember is a member extracted to a local path other than its name,
+// and why.
+type RenamedMember struct {
+	Member string `json:"member"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// memberPath returns the slash separated path a member is extracted to under
+// the destination. Leading slashes are removed, as tar does, and with windows
+// a drive letter and backslashes are too. A name with a ".." component, which
+// could climb out of the destination, is rejected with ErrUnsafeName.
+func memberPath(name string, windows bool) (string, error) {
+	p := name
+	if windows {
+		p = strings.ReplaceAll(p, `\`, "/")
+		if len(p) >= 2 && p[1] == ':' && (p[0]|0x20) >= 'a' && (p[0]|0x20) <= 'z' {
+			p = p[2:]
+		}
+	}
+	var parts []string
+	for _, part := range strings.Split(p, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("%w: %q climbs out of the destination", ErrUnsafeName, name)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("%w: %q has no name", ErrUnsafeName, name)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// windowsLen is the length of s in the UTF-16 code units Windows counts.
+func windowsLen(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// windowsComponent returns a name Windows accepts for a component of a path:
+// invalid characters, and the trailing dots and spaces Windows would drop,
+// are replaced with _, and a reserved device name gets a _ prefix.
+func windowsComponent(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 32 || strings.ContainsRune(windowsInvalid, r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	c := b.String()
+	trimmed := strings.TrimRight(c, ". ")
+	c = trimmed + strings.Repeat("_", len(c)-len(trimmed))
+	base, _, _ := strings.Cut(c, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))] {
+		c = "_" + c
+	}
+	if windowsLen(c) > maxWindowsName {
+		c = shortenName(c, maxWindowsName)
+	}
+	return c
+}
+
+// shortenName returns name cut to max UTF-16 code units, keeping a short
+// extension and ending with a hash of the whole name, so names that share a
+// beginning stay apart. It returns name when max is too short for that.
+func shortenName(name string, max int) string {
+	sum := sha1.Sum([]byte(name))
+	suffix := "~" + hex.EncodeToString(sum[:4])
+	ext := path.Ext(name)
+	if windowsLen(ext) > 16 {
+		ext = ""
+	}
+	stem := []rune(strings.TrimSuffix(name, ext))
+	avail := max - windowsLen(suffix+ext)
+	if avail < 1 {
+		return name
+	}
+	for windowsLen(string(stem)) > avail {
+		stem = stem[:len(stem)-1]
+	}
+	return string(stem) + suffix + ext
+}
+
+// localPaths returns the slash separated paths under dir the members named
+// names are extracted to, and the members whose path isn't their name. Every
+// name is checked with memberPath before any is named with a localNamer.
+func localPaths(names []string, dir string, windows bool) ([]string, []RenamedMember, error) {
+	paths := make([]string, len(names))
+	for i, name := range names {
+		p, err := memberPath(name, windows)
+		if err != nil {
+			return nil, nil, err
+		}
+		paths[i] = p
+	}
+	renamed := []RenamedMember{}
+	namer := newLocalNamer(dir, windows)
+	for i, name := range names {
+		p, reason := namer.name(paths[i])
+		if p != paths[i] {
+			renamed = append(renamed, RenamedMember{Member: name, Path: p, Reason: reason})
+		}
+		paths[i] = p
+	}
+	return paths, renamed, nil
+}
+
+// localNamer names the members extracted to a local directory, one at a
+// time in the order of the archive. With windows the names are made valid on
+// Windows, the last component of a path over MAX_PATH is shortened, and
+// names that differ only in case from one named before, which Windows can't
+// tell apart, get a ~N suffix.
+type localNamer struct {
+	windows bool
+	// dirLen is the length of the directory and the separator, they count
+	// towards MAX_PATH
+	dirLen int
+	used   map[string]bool
+}
+
+func newLocalNamer(dir string, windows bool) *localNamer {
+	return &localNamer{windows: windows, dirLen: windowsLen(filepath.Clean(dir)) + 1, used: map[string]bool{}}
+}
+
+// name returns the path of the member at p, a path returned by memberPath,
+// and why it was renamed, empty when it's p.
+func (n *localNamer) name(p string) (string, string) {
+	if !n.windows {
+		return p, ""
+	}
+	parts := strings.Split(p, "/")
+	reason := ""
+	for j, part := range parts {
+		if c := windowsComponent(part); c != part {
+			parts[j] = c
+			reason = "invalid or reserved name"
+		}
+	}
+	named := strings.Join(parts, "/")
+	if over := n.dirLen + windowsLen(named) - maxWindowsPath; over > 0 {
+		last := parts[len(parts)-1]
+		if short := shortenName(last, windowsLen(last)-over); short != last {
+			parts[len(parts)-1] = short
+			named = strings.Join(parts, "/")
+			reason = "path over 259 characters"
+		}
+	}
+	if n.used[strings.ToLower(named)] {
+		ext := path.Ext(named)
+		for i := 2; ; i++ {
+			candidate := fmt.Sprintf("%s~%d%s", strings.TrimSuffix(named, ext), i, ext)
+			if !n.used[strings.ToLower(candidate)] {
+				named = candidate
+				break
+			}
+		}
+		reason = "same name as another member on a case-insensitive filesystem"
+	}
+	n.used[strings.ToLower(named)] = true
+	return named, reason
+}
+
+// windowsNames reports whether the names of the members extracted to a local
+// directory are made valid on Windows, on Windows or with opts.WindowsNames.
+func windowsNames(opts *S3TarS3Options) bool {
+	return opts.WindowsNames || runtime.GOOS == "windows"
+}
+
+// extractLocal extracts members of archive to the local directory
+// opts.DstPath. The paths of every member are checked before anything is
+// written, and the files already there are handled with opts.OnConflict. The
+// members renamed for Windows or a conflict are written to opts.NameMap as
+// csv, or logged when it isn't set.
+func extractLocal(ctx context.Context, svc *s3.Client, archive *archiveReader, members TOC, opts *S3TarS3Options) error {
+	dir, err := filepath.Abs(opts.DstPath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.Filename
+	}
+	paths, renamed, err := localPaths(names, dir, windowsNames(opts))
+	if err != nil {
+		return err
+	}
+	resolved, err := resolveConflicts(paths, objectURL("", localKey(dir)), opts.OnConflict, localExists(dir))
+	if err != nil {
+		return err
+	}
+	for i, p := range resolved {
+		switch {
+		case p == "":
+			Infof(ctx, "skipping %s, %s exists", names[i], filepath.Join(dir, filepath.FromSlash(paths[i])))
+		case p != paths[i]:
+			renamed = append(renamed, RenamedMember{Member: names[i], Path: p, Reason: "already exists"})
+		}
+	}
+	paths = resolved
+	if err := writeNameMap(ctx, svc, opts.NameMap, renamed); err != nil {
+		return err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for i, m := range members {
+		if paths[i] == "" {
+			continue
+		}
+		m, target := m, filepath.Join(dir, filepath.FromSlash(paths[i]))
+		trackParts(ctx, 1)
+		g.Go(func() error {
+			if err := extractLocalFile(gctx, archive, m, target, opts); err != nil {
+				return &ObjectError{Key: localKey(target), Err: err}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// extractLocalFile writes the member m of archive to the file target, or
+// creates the directory when m is one.
+func extractLocalFile(ctx context.Context, archive *archiveReader, m *FileMetadata, target string, opts *S3TarS3Options) error {
+	if strings.HasSuffix(m.Filename, "/") && m.Size == 0 {
+		trackPartDone(ctx)
+		return os.MkdirAll(target, 0755)
+	}
+	var hdr *tar.Header
+	if opts.PreservePOSIXMetadata {
+		var err error
+		if hdr, _, err = archive.headerEnding(m.Start); err != nil {
+			Warnf(ctx, "unable to extract tar header for %s, cannot set permissions", m.Filename)
+		}
+	}
+	body := io.NopCloser(strings.NewReader(""))
+	if m.Size > 0 {
+		var err error
+		if body, err = archive.get(m.Start, m.Start+m.Size-1); err != nil {
+			return classifyError(err)
+		}
+	}
+	defer body.Close()
+	if err := writeLocalFile(target, body, hdr); err != nil {
+		return err
+	}
+	trackCopied(ctx, m.Size)
+	trackPartDone(ctx)
+	Infof(ctx, "x %s", objectURL("", localKey(target)))
+	return nil
+}
+
+// writeLocalFile writes the data of a member read from r to the file target,
+// creating its directory. With hdr, its permissions and times are set from
+// the header of the member.
+func writeLocalFile(target string, r io.Reader, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if hdr == nil {
+		return nil
+	}
+	if err := os.Chmod(target, fs.FileMode(hdr.Mode).Perm()); err != nil {
+		return err
+	}
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	return os.Chtimes(target, atime, hdr.ModTime)
+}
+
+// writeNameMap writes the members extracted to another path to location, a
+// local file or an s3:// url, as csv lines of member,path,reason, or logs
+// them when location is empty.
+func writeNameMap(ctx context.Context, svc *s3.Client, location string, renamed []RenamedMember) error {
+	if location == "" {
+		for _, r := range renamed {
+			Warnf(ctx, "%s is extracted to %s: %s", r.Member, r.Path, r.Reason)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"member", "path", "reason"})
+	for _, r := range renamed {
+		w.Write([]string{r.Member, r.Path, r.Reason})
+	}
+	w.Flush()
+	if err := saveFile(ctx, svc, location, buf.Bytes()); err != nil {
+		return fmt.Errorf("writing the name map %s: %w", location, err)
+	}
+	Infof(ctx, "%d members renamed, see %s", len(renamed), location)
+	return nil
+}
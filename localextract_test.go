@@ -0,0 +1,236 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemberPath(t *testing.T) {
+	tests := map[string]struct {
+		name    string
+		windows bool
+		want    string
+		wantErr error
+	}{
+		"plain":              {name: "dir/a.txt", want: "dir/a.txt"},
+		"absolute":           {name: "/etc/passwd", want: "etc/passwd"},
+		"dot components":     {name: "./dir//./a.txt", want: "dir/a.txt"},
+		"directory":          {name: "dir/", want: "dir"},
+		"parent":             {name: "../a.txt", wantErr: ErrUnsafeName},
+		"nested parent":      {name: "dir/../../a.txt", wantErr: ErrUnsafeName},
+		"backslash on linux": {name: `..\a.txt`, want: `..\a.txt`},
+		"backslash parent":   {name: `dir\..\..\a.txt`, windows: true, wantErr: ErrUnsafeName},
+		"drive letter":       {name: `C:\Windows\a.txt`, windows: true, want: "Windows/a.txt"},
+		"drive relative":     {name: `c:..\a.txt`, windows: true, wantErr: ErrUnsafeName},
+		"empty":              {name: "./", wantErr: ErrUnsafeName},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := memberPath(tt.name, tt.windows)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("memberPath(%q) error = %v, want %v", tt.name, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("memberPath(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalPaths(t *testing.T) {
+	tests := map[string]struct {
+		names   []string
+		dir     string
+		windows bool
+		want    []string
+		renamed int
+		wantErr error
+	}{
+		"unchanged": {
+			names: []string{"a.txt", "dir/b.txt"}, dir: "/tmp/x", windows: true,
+			want: []string{"a.txt", "dir/b.txt"},
+		},
+		"not windows": {
+			names: []string{"CON", "a:b", "A.txt", "a.txt"}, dir: "/tmp/x",
+			want: []string{"CON", "a:b", "A.txt", "a.txt"},
+		},
+		"reserved": {
+			names: []string{"CON", "dir/nul.txt", "com1/a", "console"}, dir: "/tmp/x", windows: true,
+			want: []string{"_CON", "dir/_nul.txt", "_com1/a", "console"}, renamed: 3,
+		},
+		"invalid characters": {
+			names: []string{"ab:c?.txt", "dir./c ", "tab\tname"}, dir: "/tmp/x", windows: true,
+			want: []string{"ab_c_.txt", "dir_/c_", "tab_name"}, renamed: 3,
+		},
+		"case collision": {
+			names: []string{"README.md", "readme.md", "Readme.md"}, dir: "/tmp/x", windows: true,
+			want: []string{"README.md", "readme~2.md", "Readme~3.md"}, renamed: 2,
+		},
+		"traversal": {
+			names: []string{"a.txt", "../b.txt"}, dir: "/tmp/x", windows: true,
+			wantErr: ErrUnsafeName,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, renamed, err := localPaths(tt.names, tt.dir, tt.windows)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("localPaths() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("localPaths() = %q, want %q", got, tt.want)
+			}
+			if len(renamed) != tt.renamed {
+				t.Errorf("renamed %d members, want %d: %+v", len(renamed), tt.renamed, renamed)
+			}
+		})
+	}
+}
+
+func TestShortenName(t *testing.T) {
+	tests := map[string]struct {
+		name    string
+		max     int
+		wantLen int
+		wantExt string
+	}{
+		"extension kept":    {name: strings.Repeat("a", 300) + ".txt", max: 255, wantLen: 255, wantExt: ".txt"},
+		"long extension":    {name: "a." + strings.Repeat("b", 300), max: 255, wantLen: 255},
+		"utf-16 units":      {name: strings.Repeat("\U0001F600", 200), max: 255, wantLen: 255},
+		"too short to hash": {name: strings.Repeat("a", 20), max: 5, wantLen: 20},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := shortenName(tt.name, tt.max)
+			if n := windowsLen(got); n > tt.wantLen || n < tt.wantLen-1 {
+				t.Errorf("shortenName() is %d units long, want %d: %s", n, tt.wantLen, got)
+			}
+			if tt.wantExt != "" && !strings.HasSuffix(got, tt.wantExt) {
+				t.Errorf("shortenName() = %s, want the extension %s", got, tt.wantExt)
+			}
+		})
+	}
+	if shortenName(strings.Repeat("a", 300)+"1", 255) == shortenName(strings.Repeat("a", 300)+"2", 255) {
+		t.Error("names with the same beginning are shortened to the same name")
+	}
+}
+
+func TestLocalPathsMaxPath(t *testing.T) {
+	dir := "/" + strings.Repeat("d", 100)
+	name := strings.Repeat("s", 100) + "/" + strings.Repeat("f", 100) + ".txt"
+	got, renamed, err := localPaths([]string{name}, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dir) + 1 + len(got[0]); n != maxWindowsPath {
+		t.Errorf("path of %d characters, want %d: %s", n, maxWindowsPath, got[0])
+	}
+	if !strings.HasSuffix(got[0], ".txt") || !strings.HasPrefix(got[0], strings.Repeat("s", 100)+"/") {
+		t.Errorf("path = %s, want the directory and the extension kept", got[0])
+	}
+	if len(renamed) != 1 || renamed[0].Member != name {
+		t.Errorf("renamed = %+v, want %s", renamed, name)
+	}
+}
+
+func TestExtractLocal(t *testing.T) {
+	data := []byte("helloworld")
+	archive := &archiveReader{bucket: "b", key: "a.tar", size: int64(len(data)), get: func(start, end int64) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data[start : end+1])), nil
+	}}
+	tests := map[string]struct {
+		members TOC
+		// existing are the files in the destination before the extraction
+		existing map[string]string
+		policy   ConflictPolicy
+		want     map[string]string
+		wantErr  error
+	}{
+		"files": {
+			members: TOC{{Filename: "a.txt", Start: 0, Size: 5}, {Filename: "/dir/b.txt", Start: 5, Size: 5}, {Filename: "empty/", Size: 0}},
+			want:    map[string]string{"a.txt": "hello", "dir/b.txt": "world"},
+		},
+		"traversal": {
+			members: TOC{{Filename: "a.txt", Start: 0, Size: 5}, {Filename: "dir/../../b.txt", Start: 5, Size: 5}},
+			want:    map[string]string{},
+			wantErr: ErrUnsafeName,
+		},
+		"overwrite": {
+			members:  TOC{{Filename: "a.txt", Start: 0, Size: 5}, {Filename: "dir/b.txt", Start: 5, Size: 5}},
+			existing: map[string]string{"a.txt": "old"},
+			want:     map[string]string{"a.txt": "hello", "dir/b.txt": "world"},
+		},
+		"skip": {
+			members:  TOC{{Filename: "a.txt", Start: 0, Size: 5}, {Filename: "dir/b.txt", Start: 5, Size: 5}},
+			existing: map[string]string{"a.txt": "old"},
+			policy:   ConflictSkip,
+			want:     map[string]string{"a.txt": "old", "dir/b.txt": "world"},
+		},
+		"rename": {
+			members:  TOC{{Filename: "a.txt", Start: 0, Size: 5}, {Filename: "dir/b.txt", Start: 5, Size: 5}},
+			existing: map[string]string{"a.txt": "old"},
+			policy:   ConflictRename,
+			want:     map[string]string{"a.txt": "old", "a-1.txt": "hello", "dir/b.txt": "world"},
+		},
+		"error": {
+			members:  TOC{{Filename: "dir/b.txt", Start: 5, Size: 5}, {Filename: "a.txt", Start: 0, Size: 5}},
+			existing: map[string]string{"a.txt": "old"},
+			policy:   ConflictError,
+			want:     map[string]string{"a.txt": "old"},
+			wantErr:  ErrDestinationExists,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for file, data := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts := &S3TarS3Options{DstPath: dir, Threads: 2, OnConflict: tt.policy}
+			err := extractLocal(context.Background(), nil, archive, tt.members, opts)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("extractLocal() error = %v, want %v", err, tt.wantErr)
+			}
+			files := map[string]string{}
+			filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					data, _ := os.ReadFile(p)
+					rel, _ := filepath.Rel(dir, p)
+					files[filepath.ToSlash(rel)] = string(data)
+				}
+				return err
+			})
+			if len(files) != len(tt.want) {
+				t.Errorf("extracted %v, want %v", files, tt.want)
+			}
+			for file, want := range tt.want {
+				if files[file] != want {
+					t.Errorf("%s = %q, want %q", file, files[file], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// localScheme is the prefix of a local path given as the source or the
+// destination.
+const localScheme = "file://"
+
+// IsLocalURL reports whether s is a local path given as file:///path.
+func IsLocalURL(s string) bool {
+	return strings.HasPrefix(s, localScheme)
+}
+
+// LocalPath returns the path of a file:///path url.
+func LocalPath(s string) string {
+	return strings.TrimPrefix(s, localScheme)
+}
+
+// ListLocalFiles lists the regular files under root, or only those directly
+// in it with flat, as objects read from the local filesystem, and their total
+// size. The key of a file is its absolute path without the leading slash, so
+// file:///<key> is the file, and its member is named after its slash separated
+// path relative to root. Symbolic links and other special files are skipped.
+func ListLocalFiles(ctx context.Context, root string, flat bool) ([]*S3Obj, int64, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	var objectList []*S3Obj
+	var total int64
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%w: %s", ErrNotFound, err.Error())
+			}
+			return err
+		}
+		if d.IsDir() {
+			if flat && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			Warnf(ctx, "skipping %s, it isn't a regular file", path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		o := localObject(path, info)
+		o.Name = filepath.ToSlash(rel)
+		o.localName = o.Name
+		objectList = append(objectList, o)
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return objectList, total, nil
+}
+
+func localObject(path string, info fs.FileInfo) *S3Obj {
+	return &S3Obj{
+		Object: types.Object{
+			Key:          aws.String(localKey(path)),
+			Size:         aws.Int64(info.Size()),
+			LastModified: aws.Time(info.ModTime()),
+		},
+		localPath: path,
+	}
+}
+
+// objectURL returns s3://bucket/key, file:///key for a local file, which has
+// no bucket, or <scheme>://<host>/key for a presigned url.
+func objectURL(bucket, key string) string {
+	if bucket == "" {
+		return localScheme + "/" + key
+	}
+	if isPresignedURL(bucket) {
+		return bucket + "/" + key
+	}
+	return fmt.Sprintf("s3://%s/%s", bucket, key)
+}
+
+// isLocal reports whether o is read from the local filesystem.
+func (o *S3Obj) isLocal() bool {
+	return o.localPath != ""
+}
+
+// checkLocalSource validates the options of a run with local files, which
+// can only be archived in memory: they are read and uploaded, there is
+// nothing to copy server-side or to lock.
+func checkLocalSource(objectList []*S3Obj, opts *S3TarS3Options) error {
+	local := false
+	for _, o := range objectList {
+		local = local || o.isLocal()
+	}
+	if !local {
+		return nil
+	}
+	if opts.ServerSideOnly {
+		return fmt.Errorf("%w: local files can't be archived with --server-side-only", ErrInvalidArgument)
+	}
+	if opts.Lock != LockNone {
+		return fmt.Errorf("%w: --lock needs an Amazon S3 source", ErrInvalidArgument)
+	}
+	opts.ConcatInMemory = true
+	return nil
+}
+
+// openLocalFile opens the file of o, with an output that describes it as a
+// GetObject would: its size, its modification time and, for
+// PreservePOSIXMetadata, its permissions, owner and group.
+func openLocalFile(o *S3Obj) (*s3.GetObjectOutput, *os.File, error) {
+	f, err := os.Open(o.localPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
+		return nil, nil, &ObjectError{Key: *o.Key, Err: err}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, &ObjectError{Key: *o.Key, Err: err}
+	}
+	metadata := map[string]string{
+		"file-permissions": fmt.Sprintf("%#o", info.Mode().Perm()),
+		"file-mtime":       strconv.FormatInt(info.ModTime().UnixMilli(), 10),
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		metadata["file-owner"] = strconv.Itoa(uid)
+		metadata["file-group"] = strconv.Itoa(gid)
+	}
+	output := &s3.GetObjectOutput{
+		ContentLength: aws.Int64(info.Size()),
+		LastModified:  aws.Time(info.ModTime()),
+		Metadata:      metadata,
+	}
+	if err := checkUnchanged(o, output); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return output, f, nil
+}
+
+// localHeaderRoom is left in the part of the first range of a local file for
+// the header of its member.
+const localHeaderRoom = 1024 * 1024
+
+// localRange is the bytes [start, end) of a local file over a part, archived
+// as a part of its own.
+type localRange struct {
+	file       *S3Obj
+	start, end int64
+}
+
+// splitLocalFiles takes the local files over partSize out of objectList and
+// returns a group for every range they are archived in, before the groups of
+// the other objects. A file is never read in memory at once, and one over
+// the part size limit can be archived too.
+func splitLocalFiles(objectList []*S3Obj, partSize, maxPartSize int64) ([]*S3Obj, [][]*S3Obj) {
+	var rest []*S3Obj
+	var groups [][]*S3Obj
+	for _, o := range objectList {
+		if !o.isLocal() || *o.Size <= partSize {
+			rest = append(rest, o)
+			continue
+		}
+		for _, rng := range fileRanges(*o.Size, partSize, maxPartSize-localHeaderRoom) {
+			groups = append(groups, []*S3Obj{{
+				Object: types.Object{
+					Key:          o.Key,
+					Size:         aws.Int64(rng[1] - rng[0]),
+					LastModified: o.LastModified,
+				},
+				localPath:  o.localPath,
+				localRange: &localRange{file: o, start: rng[0], end: rng[1]},
+			}})
+		}
+	}
+	return rest, groups
+}
+
+// fileRanges splits size bytes in ranges of at least partSize bytes, so none
+// is under the minimum part size, and of at most maxSize bytes.
+func fileRanges(size, partSize, maxSize int64) [][2]int64 {
+	n := size / partSize
+	if n < 1 {
+		n = 1
+	}
+	if (size+n-1)/n > maxSize {
+		n = (size + maxSize - 1) / maxSize
+	}
+	rangeSize := (size + n - 1) / n
+	ranges := make([][2]int64, 0, n)
+	for start := int64(0); start < size; start += rangeSize {
+		end := start + rangeSize
+		if end > size {
+			end = size
+		}
+		ranges = append(ranges, [2]int64{start, end})
+	}
+	return ranges
+}
+
+// isLocalRange reports whether group is the range of a local file of
+// splitLocalFiles.
+func isLocalRange(group []*S3Obj) bool {
+	return len(group) == 1 && group[0].localRange != nil
+}
+
+// tarLocalRange returns the bytes of the range o in the archive, after the
+// header of the member for the first range and before the padding to the end
+// of its last block for the last one. The TOC of the member is returned with
+// the first range, the MD5 of the file is read from it at once.
+func tarLocalRange(ctx context.Context, o *S3Obj, opts *S3TarS3Options) ([]byte, TOC, error) {
+	r := o.localRange
+	output, f, err := openLocalFile(r.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	buf := bytes.Buffer{}
+	var toc TOC
+	if r.start == 0 {
+		h := inMemoryHeader(r.file)
+		if opts.PreservePOSIXMetadata {
+			preserveMetadata(r.file, h, output.Metadata)
+		}
+		// the writer isn't closed, the data of the member follows in the
+		// next parts
+		if err := tar.NewWriter(&buf).WriteHeader(h); err != nil {
+			return nil, nil, err
+		}
+		sum := md5.New()
+		if _, err := io.Copy(sum, io.NewSectionReader(f, 0, *r.file.Size)); err != nil {
+			return nil, nil, &ObjectError{Key: *o.Key, Err: err}
+		}
+		m := &FileMetadata{Filename: h.Name, Start: int64(buf.Len()), Size: h.Size}
+		m.Etag = fmt.Sprintf("%q", hex.EncodeToString(sum.Sum(nil)))
+		m.Origin = origin(r.file)
+		if opts.TocExtended {
+			m.setDetails(r.file, responseDetails(output))
+		}
+		toc = append(toc, m)
+	}
+	n, err := io.Copy(&buf, io.NewSectionReader(f, r.start, r.end-r.start))
+	if err != nil {
+		return nil, nil, &ObjectError{Key: *o.Key, Err: err}
+	}
+	if n != r.end-r.start {
+		return nil, nil, &ObjectError{Key: *o.Key, Err: fmt.Errorf("%w: read %d bytes of the range %d-%d", ErrSourceChanged, n, r.start, r.end-1)}
+	}
+	trackDownloaded(ctx, n)
+	if r.end == *r.file.Size {
+		buf.Write(make([]byte, findPadding(r.end)))
+	}
+	return buf.Bytes(), toc, nil
+}
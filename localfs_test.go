@@ -0,0 +1,220 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestListLocalFiles(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	files := map[string]string{"a.txt": "a", "dir/b.txt": "bb", "dir/sub/c.txt": "ccc"}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objectList, total, err := ListLocalFiles(ctx, root, false)
+	if err != nil {
+		t.Fatalf("ListLocalFiles() error = %v", err)
+	}
+	if len(objectList) != 3 || total != 6 {
+		t.Fatalf("ListLocalFiles() = %d files of %d bytes, want 3 of 6", len(objectList), total)
+	}
+	prefix := strings.TrimPrefix(filepath.ToSlash(root), "/") + "/"
+	var names []string
+	for _, o := range objectList {
+		if !o.isLocal() || o.Bucket != "" {
+			t.Errorf("%s isn't a local object", *o.Key)
+		}
+		if *o.Key != prefix+o.MemberName() {
+			t.Errorf("key = %s, want the absolute path of %s", *o.Key, o.MemberName())
+		}
+		names = append(names, o.MemberName())
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "a.txt,dir/b.txt,dir/sub/c.txt" {
+		t.Errorf("ListLocalFiles() member names = %s", got)
+	}
+	upper, err := NameCase("upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyNameTransforms(objectList, upper); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range objectList {
+		if strings.HasSuffix(*o.Key, "/dir/b.txt") && o.MemberName() != "DIR/B.TXT" {
+			t.Errorf("member name of dir/b.txt with --name-case upper = %s, want DIR/B.TXT", o.MemberName())
+		}
+	}
+
+	flat, _, err := ListLocalFiles(ctx, root, true)
+	if err != nil {
+		t.Fatalf("ListLocalFiles(flat) error = %v", err)
+	}
+	if len(flat) != 1 || *flat[0].Key != prefix+"a.txt" {
+		t.Errorf("ListLocalFiles(flat) = %d files, want a.txt", len(flat))
+	}
+
+	if _, _, err := ListLocalFiles(ctx, filepath.Join(root, "missing"), false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ListLocalFiles(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTarLocalFiles(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	content := []byte("local file content")
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), content, 0640); err != nil {
+		t.Fatal(err)
+	}
+	objectList, _, err := ListLocalFiles(ctx, root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &S3TarS3Options{PreservePOSIXMetadata: true}
+	data, toc, err := tarMembers(ctx, nil, objectList, opts)
+	if err != nil {
+		t.Fatalf("tarMembers() error = %v", err)
+	}
+	if want := fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(content))); len(toc) != 1 || toc[0].Etag != want {
+		t.Fatalf("toc = %+v, want the MD5 %s as the ETag", toc, want)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Mode != 0640 {
+		t.Errorf("mode = %o, want 640", hdr.Mode)
+	}
+	got, err := io.ReadAll(tr)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("member = %q, %v, want %q", got, err, content)
+	}
+
+	if err := checkLocalSource(objectList, &S3TarS3Options{ServerSideOnly: true}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("checkLocalSource(server-side-only) error = %v", err)
+	}
+	opts = &S3TarS3Options{}
+	if err := checkLocalSource(objectList, opts); err != nil || !opts.ConcatInMemory {
+		t.Errorf("checkLocalSource() = %v, in memory %v", err, opts.ConcatInMemory)
+	}
+	if got := (&ObjectError{Key: "tmp/a.txt", Err: ErrNotFound}).Error(); !strings.HasPrefix(got, "file:///tmp/a.txt") {
+		t.Errorf("ObjectError.Error() = %s", got)
+	}
+}
+
+func TestLocalFileRanges(t *testing.T) {
+	const mb = 1 << 20
+	ctx := SetupLogger(context.Background())
+	root := t.TempDir()
+	small := bytes.Repeat([]byte("a"), mb)
+	big := make([]byte, 13*mb)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), small, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "big.bin"), big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	objectList, total, err := ListLocalFiles(ctx, root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	// big.bin is over the part size limit of the provider
+	opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 2, provider: Provider{MaxPartSize: 8 * mb}}
+	if _, err := buildInMemoryConcat(ctx, store.client(), objectList, total, opts); err != nil {
+		t.Fatalf("buildInMemoryConcat() error = %v", err)
+	}
+
+	parts := store.parts["dst/a.tar"]
+	if len(parts) != 3 {
+		t.Fatalf("the archive has %d parts, want 2 ranges of big.bin and a.txt", len(parts))
+	}
+	for n, part := range parts {
+		if len(part) > 8*mb || (n < len(parts) && len(part) < fileSizeMin) {
+			t.Errorf("part %d has %d bytes, want between 5MiB and 8MiB", n, len(part))
+		}
+	}
+	data := store.objects["dst/a.tar"]
+	want := map[string][]byte{"a.txt": small, "big.bin": big}
+	tr := tar.NewReader(bytes.NewReader(data))
+	var members []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(hdr.Name, ".s3tar-padding/") {
+			continue
+		}
+		got, err := io.ReadAll(tr)
+		if err != nil || !bytes.Equal(got, want[hdr.Name]) {
+			t.Errorf("member %s has %d bytes, %v, want %d", hdr.Name, len(got), err, len(want[hdr.Name]))
+		}
+		members = append(members, hdr.Name)
+	}
+	if got := strings.Join(members, ","); got != "big.bin,a.txt" {
+		t.Errorf("the archive has the members %s, want big.bin,a.txt", got)
+	}
+
+	toc, err := parseTocCSV(bytes.NewReader(store.objects["dst/a.tar.toc.csv"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range toc {
+		if got := data[m.Start : m.Start+m.Size]; !bytes.Equal(got, want[m.Filename]) {
+			t.Errorf("the TOC offset of %s is %d, it isn't the member data", m.Filename, m.Start)
+		}
+		if etag := fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(want[m.Filename]))); m.Etag != etag {
+			t.Errorf("the TOC ETag of %s = %s, want %s", m.Filename, m.Etag, etag)
+		}
+	}
+}
+
+func TestFileRanges(t *testing.T) {
+	tests := map[string]struct {
+		size, partSize, maxSize int64
+		want                    [][2]int64
+	}{
+		"two parts":         {size: 13, partSize: 5, maxSize: 7, want: [][2]int64{{0, 7}, {7, 13}}},
+		"under the maximum": {size: 11, partSize: 5, maxSize: 20, want: [][2]int64{{0, 6}, {6, 11}}},
+		"over the maximum":  {size: 30, partSize: 8, maxSize: 9, want: [][2]int64{{0, 8}, {8, 16}, {16, 24}, {24, 30}}},
+		"a single part":     {size: 6, partSize: 5, maxSize: 10, want: [][2]int64{{0, 6}}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := fileRanges(tt.size, tt.partSize, tt.maxSize)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("fileRanges(%d, %d, %d) = %v, want %v", tt.size, tt.partSize, tt.maxSize, got, tt.want)
+			}
+		})
+	}
+}
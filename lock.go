@@ -0,0 +1,200 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// LockMode decides how the source objects are marked while an archive is
+// created, so other automation can leave them alone. The lock is advisory,
+// nothing stops a client that doesn't check it.
+type LockMode string
+
+const (
+	// LockNone doesn't mark the source.
+	LockNone LockMode = ""
+	// LockTag adds the s3tar-lock tag to every source object.
+	LockTag LockMode = "tag"
+	// LockObject writes a .s3tar.lock object under the source prefix.
+	LockObject LockMode = "object"
+)
+
+const (
+	lockTagKey     = "s3tar-lock"
+	lockObjectName = ".s3tar.lock"
+	// maxObjectTags is the number of tags Amazon S3 allows on an object.
+	maxObjectTags = 10
+)
+
+// lockInfo is the content of the lock object.
+type lockInfo struct {
+	RunID   string    `json:"run_id,omitempty"`
+	Archive string    `json:"archive"`
+	Objects int       `json:"objects"`
+	Started time.Time `json:"started"`
+}
+
+func validateLockMode(opts *S3TarS3Options) error {
+	switch opts.Lock {
+	case LockNone, LockTag:
+	case LockObject:
+		if opts.SrcBucket == "" {
+			return fmt.Errorf("%w: --lock object needs a source bucket and prefix", ErrInvalidArgument)
+		}
+	default:
+		return fmt.Errorf("%w: unknown lock mode %q", ErrInvalidArgument, opts.Lock)
+	}
+	return nil
+}
+
+// lockObjectKey is the key of the lock object of the source prefix.
+func lockObjectKey(prefix string) string {
+	if prefix == "" {
+		return lockObjectName
+	}
+	return path.Join(prefix, lockObjectName)
+}
+
+// lockTagValue returns archive with the characters not allowed in tag values
+// replaced, cut to the 256 characters a tag value can have.
+func lockTagValue(archive string) string {
+	value := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || strings.ContainsRune("+-=._:/@", r) {
+			return r
+		}
+		return '_'
+	}, archive)
+	if r := []rune(value); len(r) > 256 {
+		value = string(r[:256])
+	}
+	return value
+}
+
+// lockSource marks the source of the archive following opts.Lock and returns
+// the function that removes the mark. The source is read with svc.
+func lockSource(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) (func(), error) {
+	archive := fmt.Sprintf("s3://%s/%s", opts.DstBucket, opts.DstKey)
+	switch opts.Lock {
+	case LockTag:
+		tagged, err := tagObjects(ctx, svc, objectList, lockTagValue(archive), opts.Threads)
+		unlock := func() {
+			untagObjects(ctx, svc, tagged, opts.Threads)
+		}
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		Infof(ctx, "tagged %d source objects with %s", len(tagged), lockTagKey)
+		return unlock, nil
+	case LockObject:
+		bucket, key := opts.SrcBucket, lockObjectKey(opts.SrcPrefix)
+		data, err := json.MarshalIndent(lockInfo{RunID: opts.RunID, Archive: archive, Objects: len(objectList), Started: time.Now().UTC()}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		_, err = svc.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        &bucket,
+			Key:           &key,
+			Body:          bytes.NewReader(data),
+			ContentLength: aws.Int64(int64(len(data))),
+			ContentType:   aws.String("application/json"),
+		}, ifNoneMatchAny)
+		if err != nil {
+			if err := destinationError(bucket, key, err); errors.Is(err, ErrDestinationExists) {
+				return nil, &ObjectError{Bucket: bucket, Key: key, Err: fmt.Errorf("%w: another archive is being created, delete the lock if a previous run was interrupted", ErrSourceLocked)}
+			}
+			return nil, classifyError(err)
+		}
+		Infof(ctx, "locked s3://%s/%s", bucket, key)
+		return func() {
+			if _, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key}); err != nil {
+				Warnf(ctx, "unable to remove the lock s3://%s/%s: %s", bucket, key, err.Error())
+			}
+		}, nil
+	}
+	return func() {}, nil
+}
+
+// tagObjects adds the lock tag to the objects, keeping their tags. It returns
+// the objects tagged, also when it fails half way.
+func tagObjects(ctx context.Context, svc *s3.Client, objectList []*S3Obj, value string, threads int) ([]*S3Obj, error) {
+	var m sync.Mutex
+	var tagged []*S3Obj
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for _, o := range objectList {
+		o := o
+		g.Go(func() error {
+			output, err := readClient(svc, o).GetObjectTagging(gctx, &s3.GetObjectTaggingInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o)})
+			if err != nil {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: classifyError(err)}
+			}
+			for _, tag := range output.TagSet {
+				if *tag.Key == lockTagKey {
+					return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: locked by %s, remove the %s tag if a previous run was interrupted", ErrSourceLocked, *tag.Value, lockTagKey)}
+				}
+			}
+			if len(output.TagSet) >= maxObjectTags {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: the object has %d tags, the lock tag can't be added", ErrInvalidArgument, len(output.TagSet))}
+			}
+			tagSet := append(output.TagSet, types.Tag{Key: aws.String(lockTagKey), Value: &value})
+			_, err = readClient(svc, o).PutObjectTagging(gctx, &s3.PutObjectTaggingInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o), Tagging: &types.Tagging{TagSet: tagSet}})
+			if err != nil {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: classifyError(err)}
+			}
+			m.Lock()
+			tagged = append(tagged, o)
+			m.Unlock()
+			return nil
+		})
+	}
+	err := g.Wait()
+	return tagged, err
+}
+
+// untagObjects removes the lock tag from the objects. Failures are logged, an
+// object left tagged doesn't fail the run.
+func untagObjects(ctx context.Context, svc *s3.Client, objectList []*S3Obj, threads int) {
+	g := new(errgroup.Group)
+	g.SetLimit(threads)
+	for _, o := range objectList {
+		o := o
+		g.Go(func() error {
+			output, err := readClient(svc, o).GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o)})
+			if err == nil {
+				var tagSet []types.Tag
+				for _, tag := range output.TagSet {
+					if *tag.Key != lockTagKey {
+						tagSet = append(tagSet, tag)
+					}
+				}
+				if len(tagSet) == 0 {
+					_, err = readClient(svc, o).DeleteObjectTagging(ctx, &s3.DeleteObjectTaggingInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o)})
+				} else {
+					_, err = readClient(svc, o).PutObjectTagging(ctx, &s3.PutObjectTaggingInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o), Tagging: &types.Tagging{TagSet: tagSet}})
+				}
+			}
+			if err != nil {
+				Warnf(ctx, "unable to remove the %s tag from s3://%s/%s: %s", lockTagKey, o.Bucket, *o.Key, err.Error())
+			}
+			return nil
+		})
+	}
+	g.Wait()
+}
@@ -0,0 +1,40 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLockMode(t *testing.T) {
+	tests := []struct {
+		opts    S3TarS3Options
+		wantErr bool
+	}{
+		{opts: S3TarS3Options{}},
+		{opts: S3TarS3Options{Lock: LockTag, SrcManifest: "manifest.csv"}},
+		{opts: S3TarS3Options{Lock: LockObject, SrcBucket: "bucket", SrcPrefix: "logs/"}},
+		{opts: S3TarS3Options{Lock: LockObject, SrcManifest: "manifest.csv"}, wantErr: true},
+		{opts: S3TarS3Options{Lock: "file"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := validateLockMode(&tt.opts); (err != nil) != tt.wantErr {
+			t.Errorf("validateLockMode(%q) error = %v, wantErr %v", tt.opts.Lock, err, tt.wantErr)
+		}
+	}
+
+	for prefix, want := range map[string]string{"": ".s3tar.lock", "logs/": "logs/.s3tar.lock", "logs/2023": "logs/2023/.s3tar.lock"} {
+		if got := lockObjectKey(prefix); got != want {
+			t.Errorf("lockObjectKey(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+
+	if got, want := lockTagValue("s3://bucket/a b/c#d?.tar"), "s3://bucket/a b/c_d_.tar"; got != want {
+		t.Errorf("lockTagValue() = %q, want %q", got, want)
+	}
+	if got := lockTagValue("s3://bucket/" + strings.Repeat("a", 300)); len(got) != 256 {
+		t.Errorf("lockTagValue() has %d characters, want 256", len(got))
+	}
+}
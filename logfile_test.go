@@ -0,0 +1,126 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := map[string]struct {
+		maxFiles int
+		lines    []string
+		want     map[string]string
+	}{
+		"rotated": {
+			maxFiles: 2,
+			lines:    []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:     map[string]string{"s3tar.log": "cccc\n", "s3tar.log.1": "bbbb\n", "s3tar.log.2": "aaaa\n"},
+		},
+		"oldest removed": {
+			maxFiles: 1,
+			lines:    []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:     map[string]string{"s3tar.log": "cccc\n", "s3tar.log.1": "bbbb\n"},
+		},
+		"no rotated files": {
+			maxFiles: 0,
+			lines:    []string{"aaaa\n", "bbbb\n"},
+			want:     map[string]string{"s3tar.log": "bbbb\n"},
+		},
+		"under the size": {
+			maxFiles: 2,
+			lines:    []string{"aa\n", "bb\n"},
+			want:     map[string]string{"s3tar.log": "aa\nbb\n"},
+		},
+		"long line": {
+			maxFiles: 2,
+			lines:    []string{"aa\n", "bbbbbbbbbbbb\n"},
+			want:     map[string]string{"s3tar.log": "bbbbbbbbbbbb\n", "s3tar.log.1": "aa\n"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			r, err := OpenRotatingFile(filepath.Join(dir, "s3tar.log"), 8, tt.maxFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range tt.lines {
+				if _, err := r.Write([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Errorf("%d log files, want %d", len(entries), len(tt.want))
+			}
+			for file, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(dir, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", file, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	tests := map[string]struct {
+		level string
+		want  []string
+	}{
+		"error": {level: "error", want: []string{"ERROR e"}},
+		"warn":  {level: "warn", want: []string{"WARN w", "ERROR e"}},
+		"info":  {level: "info", want: []string{"INFO i", "WARN w", "ERROR e"}},
+		"debug": {level: "DEBUG", want: []string{"DEBUG d", "INFO i", "WARN w", "ERROR e"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			level, err := ParseLogLevel(tt.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b strings.Builder
+			ctx := SetLogLevel(SetLogOutput(context.Background(), &b), level)
+			Debugf(ctx, "d")
+			Infof(ctx, "i")
+			Warnf(ctx, "w")
+			Errorf(ctx, "e")
+			lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("logged %q, want %q", lines, tt.want)
+			}
+			for i, line := range lines {
+				// after the date and the time
+				if fields := strings.SplitN(line, " ", 3); len(fields) != 3 || fields[2] != tt.want[i] {
+					t.Errorf("line %d = %q, want %q", i, line, tt.want[i])
+				}
+			}
+		})
+	}
+	// the warnings are logged without a level
+	var b strings.Builder
+	ctx := SetLogOutput(context.Background(), &b)
+	Infof(ctx, "i")
+	Warnf(ctx, "w")
+	if fields := strings.SplitN(strings.TrimSpace(b.String()), " ", 3); len(fields) != 3 || fields[2] != "WARN w" {
+		t.Errorf("logged %q without a level, want the warning only", b.String())
+	}
+	if _, err := ParseLogLevel("trace"); err == nil {
+		t.Error("ParseLogLevel(trace) succeeded")
+	}
+}
@@ -6,13 +6,26 @@ package s3tar
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 )
 
 const (
 	contextKeyLogger      = contextKey("logger")
 	contextKeyLoggerLevel = contextKey("logger-level")
+	contextKeyLoggerFile  = contextKey("logger-file")
+)
+
+// The log levels of --log-level, each logs the messages of the levels below
+// it. The warnings are logged by default, -v, -vv and -vvv are the levels 1
+// to 3: -v and -vv add the progress and -vvv the debug messages.
+const (
+	LogLevelError = -1
+	LogLevelWarn  = 0
+	LogLevelInfo  = 1
+	LogLevelDebug = 3
 )
 
 type logWriter struct {
@@ -22,6 +35,21 @@ func (writer logWriter) Write(bytes []byte) (int, error) {
 	return fmt.Print(string(bytes))
 }
 
+// ParseLogLevel returns the log level named debug, info, warn or error.
+func ParseLogLevel(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	return 0, fmt.Errorf("%w: log level %q, want debug, info, warn or error", ErrInvalidArgument, name)
+}
+
 func SetLogLevel(ctx context.Context, level int) context.Context {
 	return context.WithValue(ctx, contextKeyLoggerLevel, level)
 }
@@ -32,34 +60,55 @@ func SetupLogger(incoming context.Context) context.Context {
 	return context.WithValue(incoming, contextKeyLogger, logger)
 }
 
+// SetLogOutput logs to w, e.g. a RotatingFile, instead of stdout. The lines
+// have a UTC timestamp and their severity.
+func SetLogOutput(incoming context.Context, w io.Writer) context.Context {
+	logger := log.New(w, "", log.LstdFlags|log.Lmicroseconds|log.LUTC)
+	ctx := context.WithValue(incoming, contextKeyLoggerFile, true)
+	return context.WithValue(ctx, contextKeyLogger, logger)
+}
+
 func Debugf(ctx context.Context, format string, v ...interface{}) {
 	logger, level := getValues(ctx)
-	if level > 2 && level <= 3 {
-		logger.Printf(format, v...)
+	if level >= LogLevelDebug {
+		logf(ctx, logger, "DEBUG", format, v...)
 	}
 }
 
 func Warnf(ctx context.Context, format string, v ...interface{}) {
 	logger, level := getValues(ctx)
-	if level > 1 && level <= 3 {
-		logger.Printf(format, v...)
+	if level >= LogLevelWarn {
+		logf(ctx, logger, "WARN", format, v...)
 	}
 }
 
 // Errorf, always log regardless of log level, but don't stop the application
 func Errorf(ctx context.Context, format string, v ...interface{}) {
 	logger, _ := getValues(ctx)
-	logger.Printf(format, v...)
+	logf(ctx, logger, "ERROR", format, v...)
 }
 func Fatalf(ctx context.Context, format string, v ...interface{}) {
-	log.Fatalf(format, v...)
+	logger, _ := getValues(ctx)
+	if toFile, _ := ctx.Value(contextKeyLoggerFile).(bool); toFile {
+		// the log file has the reason too
+		logf(ctx, logger, "FATAL", format, v...)
+	}
+	log.Fatalf(runPrefix(ctx)+format, v...)
 }
 
 func Infof(ctx context.Context, format string, v ...interface{}) {
 	logger, level := getValues(ctx)
-	if level >= 1 {
-		logger.Printf(format, v...)
+	if level >= LogLevelInfo {
+		logf(ctx, logger, "INFO", format, v...)
+	}
+}
+
+// logf writes a line, with its severity in a log file.
+func logf(ctx context.Context, logger *log.Logger, severity, format string, v ...interface{}) {
+	if toFile, _ := ctx.Value(contextKeyLoggerFile).(bool); toFile {
+		format = severity + " " + format
 	}
+	logger.Printf(runPrefix(ctx)+format, v...)
 }
 
 func getValues(ctx context.Context) (*log.Logger, int) {
@@ -74,7 +123,7 @@ func getValues(ctx context.Context) (*log.Logger, int) {
 	if _level, ok := ctx.Value(contextKeyLoggerLevel).(int); ok {
 		level = _level
 	} else {
-		level = 0
+		level = LogLevelWarn
 	}
 	return logger, level
 }
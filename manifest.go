@@ -32,6 +32,7 @@ func buildToc(ctx context.Context, objectList []*S3Obj) (*S3Obj, *S3Obj, error)
 	tocObj := NewS3Obj()
 	tocObj.Key = aws.String("toc.csv")
 	tocObj.AddData(toc.Bytes())
+	tocObj.tarFormat = objectList[0].tarFormat
 	// passing nil as we don't need to set permissions/owner/group for toc.csv
 	tocHeader := buildHeader(tocObj, nil, false, nil)
 	tocHeader.Bucket = objectList[0].Bucket
@@ -68,35 +69,42 @@ func _buildToc(ctx context.Context, headers []*S3Obj, objectList []*S3Obj) (*byt
 
 func createCSVTOC(offset int64, headers []*S3Obj, objectList []*S3Obj) (*bytes.Buffer, error) {
 	headerOffset := paxTarHeaderSize
-	if tarFormat == tar.FormatGNU {
+	if objectList[0].format() == tar.FormatGNU {
 		headerOffset = gnuTarHeaderSize
 	}
 	var currLocation int64 = offset + headerOffset
 	currLocation = currLocation + findPadding(currLocation)
 	buf := bytes.Buffer{}
-	toc := [][]string{}
-
+	toc := TOC{}
+	checksums, extended := false, false
 	for i := 0; i < len(objectList); i++ {
+		o := objectList[i]
 		currLocation += *headers[i].Size
-		line := []string{}
-		line = append(line,
-			*objectList[i].Key,
-			fmt.Sprintf("%d", currLocation),
-			fmt.Sprintf("%d", *objectList[i].Size),
-			*objectList[i].ETag)
-		toc = append(toc, line)
-		currLocation += *objectList[i].Size
+		m := &FileMetadata{Filename: o.MemberName(), Start: currLocation, Size: *o.Size, Etag: *o.ETag, Checksum: o.Checksum, Origin: origin(o)}
+		if o.details != nil {
+			m.setDetails(o, *o.details)
+			extended = true
+		}
+		checksums = checksums || o.Checksum != ""
+		toc = append(toc, m)
+		currLocation += *o.Size
 	}
+	fields := tocFields(toc, checksums, extended)
 	cw := csv.NewWriter(&buf)
-	if err := cw.WriteAll(toc); err != nil {
-		return nil, err
+	for _, m := range toc {
+		if err := cw.Write(tocRecord(m, fields)); err != nil {
+			return nil, err
+		}
 	}
 	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return nil, err
+	}
 
 	return &buf, nil
 }
 
-func buildFirstPart(csvData []byte) *S3Obj {
+func buildFirstPart(csvData []byte, format tar.Format) *S3Obj {
 	buf := &bytes.Buffer{}
 	tw := tar.NewWriter(buf)
 	hdr := &tar.Header{
@@ -106,7 +114,7 @@ func buildFirstPart(csvData []byte) *S3Obj {
 		ModTime:    time.Now(),
 		ChangeTime: time.Now(),
 		AccessTime: time.Now(),
-		Format:     tarFormat,
+		Format:     format,
 	}
 	buf.Write(pad)
 	if err := tw.WriteHeader(hdr); err != nil {
@@ -130,42 +138,22 @@ func buildFirstPart(csvData []byte) *S3Obj {
 	return endPadding
 }
 
-func tryParseHeader(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, start int64) (*tar.Header, int64, error) {
-	var i int64 = 512
-	var windowStart int64 = start
-	var header *tar.Header
-	var offset int64 = 0
-	data := make([]byte, blockSize*10)
-
-	for ; i < (512 * 10); windowStart, i = windowStart+blockSize, i+blockSize {
-		Debugf(ctx, "trying to parse header from %d-%d\n", start, start+i)
-		Debugf(ctx, "downloading from %d-%d\n", windowStart, windowStart+blockSize)
-		r, err := getObjectRange(ctx, svc, opts.SrcBucket, opts.SrcKey, windowStart, windowStart+blockSize-1)
-		if err != nil {
-			panic(err)
-		}
-		defer r.Close()
-
-		all, _ := io.ReadAll(r)
-		copy(data[i-blockSize:i], all)
-
-		if i == blockSize*2 {
-			endBlock := make([]byte, blockSize*2)
-			if bytes.Compare(endBlock, data[0:1024]) == 0 {
-				return nil, offset, io.EOF
-			}
-		}
-
-		nr := bytes.NewReader(data[0:i])
-		tr := tar.NewReader(nr)
-		h, err := tr.Next()
-		if err == nil {
-			header = h
-			offset = start + i
-			break
-		}
+// scanToc walks the tar headers of an archive in Amazon S3 with range requests
+// and returns where each member starts, up to the end-of-archive marker or the
+// first corrupt header.
+func scanToc(ctx context.Context, svc *s3.Client, bucket, key string) (TOC, error) {
+	archive, err := headArchive(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	report := &ValidationReport{Size: archive.size, CorruptOffset: -1}
+	if _, err := walkHeaders(ctx, archive.readAt, report, 0); err != nil {
+		return nil, err
 	}
-	return header, offset, nil
+	if !report.Valid() {
+		Warnf(ctx, "s3://%s/%s is corrupt at offset %d: %s, the toc stops there", bucket, key, report.CorruptOffset, report.Problem)
+	}
+	return report.Toc, nil
 }
 
 // GenerateToc creates a TOC csv of an existing TAR file (not created by s3tar)
@@ -175,54 +163,41 @@ func GenerateToc(ctx context.Context, svc *s3.Client, tarFile, outputToc string,
 
 	if strings.Contains(tarFile, "s3://") {
 		// remote file on s3
-		fmt.Printf("file is on s3")
+		Debugf(ctx, "file is on s3")
+
+		toc, err := scanToc(ctx, svc, opts.SrcBucket, opts.SrcKey)
+		if err != nil {
+			return err
+		}
 
 		w, err := os.Create(outputToc)
 		if err != nil {
-			log.Fatal(err.Error())
+			return err
 		}
 		defer w.Close()
 		cw := csv.NewWriter(w)
-
-		var start int64 = 0
-		for {
-
-			header, offset, err := tryParseHeader(ctx, svc, opts, start)
-			if err == io.EOF {
-				Debugf(ctx, "reached EOF")
-				break
-			}
-			if err != nil {
-				// log something
-				break
-			}
-
-			offsetStr := fmt.Sprintf("%d", offset)
-			size := fmt.Sprintf("%d", header.Size)
-			record := []string{header.Name, offsetStr, size, ""}
+		for _, m := range toc {
+			record := []string{m.Filename, fmt.Sprintf("%d", m.Start), fmt.Sprintf("%d", m.Size), m.Etag}
 			if err = cw.Write(record); err != nil {
 				return err
 			}
-
-			start = offset + header.Size + findPadding(offset+header.Size)
-			Debugf(ctx, "next start: %d\n", start)
 		}
 		cw.Flush()
 
 		return nil
 	} else {
 		// local file
-		fmt.Printf("file is local")
+		Debugf(ctx, "file is local")
 
 		r, err := os.Open(tarFile)
 		if err != nil {
-			log.Fatal(err.Error())
+			return err
 		}
 		defer r.Close()
 
 		w, err := os.Create(outputToc)
 		if err != nil {
-			log.Fatal(err.Error())
+			return err
 		}
 		defer w.Close()
 
@@ -254,3 +229,35 @@ func GenerateToc(ctx context.Context, svc *s3.Client, tarFile, outputToc string,
 		return nil
 	}
 }
+
+// externalTocLocation is where the TOC of an archive built in memory, which
+// has no toc.csv member, is written: next to the archive.
+func externalTocLocation(bucket, key string) string {
+	return fmt.Sprintf("s3://%s/%s.toc.csv", bucket, key)
+}
+
+// writeExternalToc writes the TOC recorded while the archive was built in
+// memory, in the format of --external-toc.
+func writeExternalToc(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, toc TOC) error {
+	buf := bytes.Buffer{}
+	cw := csv.NewWriter(&buf)
+	fields := tocFields(toc, opts.TocChecksums, opts.TocExtended)
+	for _, m := range toc {
+		if err := cw.Write(tocRecord(m, fields)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	location := externalTocLocation(opts.DstBucket, opts.DstKey)
+	if opts.DstPath != "" {
+		location = opts.DstPath + ".toc.csv"
+	}
+	if err := saveFile(ctx, svc, location, buf.Bytes()); err != nil {
+		return fmt.Errorf("writing toc %s: %w", location, err)
+	}
+	Infof(ctx, "toc written to %s", location)
+	return nil
+}
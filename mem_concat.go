@@ -7,33 +7,55 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"golang.org/x/sync/errgroup"
 )
 
 func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S3Obj, estimatedSize int64, opts *S3TarS3Options) (*S3Obj, error) {
 
-	largestObjectSize := findLargestObject(objectList)
+	largestObjectSize := findLargestObject(ctx, objectList)
 
-	if largestObjectSize > partSizeMax {
-		return nil, fmt.Errorf("largest object is over the 5GiB limit\n")
+	// local files over a part are read in ranges, see splitLocalFiles
+	for _, o := range objectList {
+		if !o.isLocal() && *o.Size > opts.provider.maxPartSize() {
+			return nil, fmt.Errorf("%w: largest object is over the %s limit", ErrObjectTooLarge, formatBytes(opts.provider.maxPartSize()))
+		}
 	}
 
-	if estimatedSize < fileSizeMin {
-		data, err := tarGroup(ctx, client, objectList, opts)
+	if estimatedSize < mpuThreshold(opts) {
+		data, toc, err := tarGroup(ctx, sourceClient(client, opts), objectList, opts)
+		if err != nil {
+			return nil, err
+		}
+		var complete *S3Obj
+		if opts.DstPath != "" {
+			complete, err = writeLocalArchive(ctx, data, opts)
+		} else {
+			complete, err = uploadObject(ctx, client, opts.DstBucket, opts.DstKey, data, opts)
+		}
 		if err != nil {
 			return nil, err
 		}
-		return uploadObject(ctx, client, opts.DstBucket, opts.DstKey, data, opts)
+		return complete, writeExternalToc(ctx, client, opts, toc)
 	} else {
 
-		sizeLimit := findMinimumPartSize(estimatedSize, opts.UserMaxPartSize)
+		sizeLimit, err := findMinimumPartSize(estimatedSize, opts.UserMinPartSize, opts.UserMaxPartSize, opts.provider)
+		if err != nil {
+			return nil, err
+		}
 
 		Infof(ctx, "mpu partsize: %s, largestObject: %d\n", formatBytes(sizeLimit), largestObjectSize)
 
@@ -44,109 +66,297 @@ func buildInMemoryConcat(ctx context.Context, client *s3.Client, objectList []*S
 		// }
 		// objectList = append([]*S3Obj{tocObj}, objectList...)
 
-		groups := splitSliceBySizeLimit(sizeLimit, objectList)
-		if len(groups) > maxPartNumLimit {
-			return nil, fmt.Errorf("number of parts (%d) exceeded the number of mpu parts allowed (10k)\n", len(groups))
+		grouped, ranges := splitLocalFiles(objectList, sizeLimit, opts.provider.maxPartSize())
+		// the ranges go first, the last group of the others can be under the
+		// minimum part size
+		groups := append(ranges, groupingOf(opts).Groups(grouped, sizeLimit)...)
+		// a resumed job keeps the parts it was started with
+		job := opts.job
+		if job != nil {
+			sizeLimit, groups = job.PartSize, job.plan(objectList)
 		}
-
-		Infof(ctx, "number of parts: %d\n", len(groups))
-
-		tags := TagsToUrlEncodedString(opts.ObjectTags)
-
-		// create MPU
-		mpu, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
-			Bucket:               &opts.DstBucket,
-			Key:                  &opts.DstKey,
-			StorageClass:         opts.storageClass,
-			ChecksumAlgorithm:    types.ChecksumAlgorithmSha256,
-			Tagging:              &tags,
-			ACL:                  types.ObjectCannedACLBucketOwnerFullControl,
-			SSEKMSKeyId:          &opts.KMSKeyID,
-			ServerSideEncryption: opts.SSEAlgo,
-		})
-		if err != nil {
-			Errorf(ctx, "unable to create multipart")
-			return nil, err
+		ctx = withPartSize(ctx, sizeLimit)
+		if len(groups) > opts.provider.maxParts() {
+			return nil, fmt.Errorf("%w: number of parts (%d) exceeded the number of mpu parts allowed (%d)", ErrTooManyParts, len(groups), opts.provider.maxParts())
+		}
+		for i, group := range groups {
+			if size := objectsSize(group); size > opts.provider.maxPartSize() {
+				return nil, fmt.Errorf("%w: part %d has %s of objects, over the %s limit", ErrObjectTooLarge, i+1, formatBytes(size), formatBytes(opts.provider.maxPartSize()))
+			}
 		}
 
-		parts := make([]types.CompletedPart, len(groups))
-		partsSizeList := make([]int64, len(groups))
-
-		processGroups := func() error {
-			g, _ := errgroup.WithContext(context.Background())
-			g.SetLimit(threads)
+		Infof(ctx, "number of parts: %d, plan digest: %s\n", len(groups), planDigest(sizeLimit, groups))
+		trackParts(ctx, len(groups))
 
-			for i, group := range groups {
-				i, group := i, group
+		var upload partUpload
+		if job != nil {
+			upload = &s3Upload{client: client, opts: opts, uploadId: job.UploadId}
+			job.restoreSkipped(ctx)
+		} else {
+			var err error
+			upload, err = createPartUpload(ctx, client, opts)
+			if err != nil {
+				return nil, err
+			}
+			if opts.State != "" {
+				job = newJobState(ctx, opts, groups, sizeLimit, upload.(*s3Upload).uploadId)
+				if err := job.save(ctx, client); err != nil {
+					upload.abort(detachedContext{ctx})
+					return nil, err
+				}
+				Infof(ctx, "job state: %s, resume with --resume %s", opts.State, opts.State)
+			}
+		}
 
-				g.Go(func() error {
+		var partsMu sync.Mutex
+		parts := make([]types.CompletedPart, 0, len(groups))
+		// the members of every group, the offsets of its TOC start where the
+		// groups before it end
+		groupSizes := make([]int64, len(groups))
+		tocs := make([]TOC, len(groups))
+		// the groups uploaded by this run, for the checkpoint of a drain
+		done := make([]*statePart, len(groups))
+		firstPart := make([]int32, len(groups))
+		var fit *exactFit
+		if opts.PartPadding == PartPaddingExactFit {
+			fit = newExactFit(sizeLimit, len(groups))
+		}
 
-					Infof(ctx, "Part %d of %d has %d objects\n", i+1, len(groups), len(group))
-					data, err := tarGroup(ctx, client, group, opts)
+		buildPart := func(ctx context.Context, i int) ([]byte, error) {
+			group := groups[i]
+			partNum := int32(i + 1)
+			Infof(ctx, "Part %d of %d has %d objects\n", partNum, len(groups), len(group))
+			var data []byte
+			err := withPartRetries(ctx, partNum, opts, func() error {
+				var members []byte
+				var toc TOC
+				var err error
+				if isLocalRange(group) {
+					members, toc, err = tarLocalRange(ctx, group[0], opts)
+				} else {
+					members, toc, err = tarMembers(ctx, sourceClient(client, opts), group, opts)
+				}
+				if err != nil {
+					return err
+				}
+				// the member of a local range goes on in the next part, it
+				// isn't padded
+				if fit == nil && i != len(groups)-1 && !isLocalRange(group) {
+					members, toc, err = padPart(ctx, members, toc, partNum, opts, len(toc) < len(group))
 					if err != nil {
 						return err
 					}
-					partNum := int32(i + 1)
-
-					if i != len(groups)-1 { // only on the last iteration we leave the 2 block padding tar EOF.
-						data = data[0 : len(data)-1024]
-					}
-
-					rc, err := uploadPart(ctx, client, *mpu.UploadId, opts.DstBucket, opts.DstKey, data, &partNum)
+				}
+				data, tocs[i], groupSizes[i] = members, toc, int64(len(members))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if fit != nil {
+				data, firstPart[i], err = fit.add(ctx, i, data)
+				return data, err
+			}
+			if i == len(groups)-1 {
+				data = append(data, endOfArchive()...)
+			}
+			firstPart[i] = partNum
+			return data, nil
+		}
+		uploadBuiltPart := func(ctx context.Context, i int, data []byte) error {
+			// a group is uploaded as one part, or as the parts it completed
+			// with exact-fit
+			chunks := [][]byte{data}
+			if fit != nil {
+				chunks = splitPart(data, sizeLimit)
+			}
+			var uploaded types.CompletedPart
+			for j, chunk := range chunks {
+				chunk := chunk
+				partNum := firstPart[i] + int32(j)
+				err := withPartRetries(ctx, partNum, opts, func() error {
+					part, err := upload.uploadPart(ctx, partNum, chunk)
 					if err != nil {
 						return err
 					}
-					parts[i] = types.CompletedPart{
-						ETag:           rc.ETag,
-						PartNumber:     &partNum,
-						ChecksumSHA256: rc.ChecksumSHA256,
-					}
-					partsSizeList[i] = int64(len(data))
+					partsMu.Lock()
+					parts = append(parts, part)
+					partsMu.Unlock()
+					uploaded = part
+					trackUploaded(ctx, int64(len(chunk)))
 					return nil
 				})
+				if err != nil {
+					return err
+				}
+			}
+			// there is no exact-fit with a state, a group is a single part
+			if job != nil {
+				if err := job.savePart(ctx, client, i, groups[i], uploaded, groupSizes[i], tocs[i]); err != nil {
+					return err
+				}
+			}
+			done[i] = &statePart{Group: i, Part: uploaded, Size: groupSizes[i], Toc: tocs[i]}
+			trackPartDone(ctx)
+			return nil
+		}
 
+		// the groups of a resumed job uploaded already are only added to the
+		// archive, the others are built in the part order
+		pending := make([]int, 0, len(groups))
+		var pendingSizes []int64
+		for i := range groups {
+			p, ok := job.uploaded(i)
+			if !ok {
+				pending = append(pending, i)
+				pendingSizes = append(pendingSizes, objectsSize(groups[i]))
+				continue
 			}
+			parts = append(parts, p.Part)
+			tocs[i], groupSizes[i] = p.Toc, p.Size
+			trackPartDone(ctx)
+		}
+		order := partOrder(opts.PartOrder, pendingSizes)
 
-			Infof(ctx, "waiting for threads to finish")
-			return g.Wait()
+		buffered := bufferedParts(opts, sizeLimit)
+		workers := opts.Threads
+		if workers > buffered {
+			workers = buffered
+		}
+		Infof(ctx, "building up to %d parts in memory at once", buffered)
+		err = runPipeline(ctx, len(pending), workers, buffered,
+			func(ctx context.Context, j int) ([]byte, error) { return buildPart(ctx, pending[order[j]]) },
+			func(ctx context.Context, j int, data []byte) error {
+				return uploadBuiltPart(ctx, pending[order[j]], data)
+			})
+		if errors.Is(err, errDrained) {
+			return nil, drainedJob(detachedContext{ctx}, client, job, upload, objectList, groups, sizeLimit, done, opts)
 		}
-		err = processGroups()
 		if err != nil {
+			if job != nil {
+				// the parts uploaded are kept for the next run
+				Warnf(ctx, "the job can be resumed with --resume %s", opts.State)
+				return nil, err
+			}
+			// the parts uploaded aren't kept, also when the run was canceled
+			upload.abort(detachedContext{ctx})
 			return nil, err
 		}
 
+		sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
 		Infof(ctx, "completing mpu-object")
-		mpuOutput, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
-			UploadId: mpu.UploadId,
-			Bucket:   &opts.DstBucket,
-			Key:      &opts.DstKey,
-			MultipartUpload: &types.CompletedMultipartUpload{
-				Parts: parts,
-			},
-		})
+		complete, err := upload.complete(ctx, parts)
 		if err != nil {
-			Errorf(ctx, "unable to complete mpu")
 			return nil, err
 		}
+		if job != nil {
+			job.Completed = true
+			if err := job.save(ctx, client); err != nil {
+				Warnf(ctx, "unable to mark the job complete: %s", err.Error())
+			}
+		}
+		complete.Size = aws.Int64(sumSlice[int64](groupSizes) + int64(len(endOfArchive())))
+		recordParts(ctx, newPartReports(groups, tocs, groupSizes, parts, fit != nil, sizeLimit))
+
+		// the offsets of every part start where the parts before it end
+		var toc TOC
+		var offset int64
+		for i, partToc := range tocs {
+			for _, m := range partToc {
+				m.Start += offset
+				toc = append(toc, m)
+			}
+			offset += groupSizes[i]
+		}
+
+		Infof(ctx, "total files: %d", len(objectList))
+		return complete, writeExternalToc(ctx, client, opts, toc)
+	}
 
-		totalSize := sumSlice[int64](partsSizeList)
+}
 
-		now := time.Now()
-		complete := &S3Obj{
-			Bucket: *mpuOutput.Bucket,
-			Object: types.Object{
-				Key:          mpuOutput.Key,
-				ETag:         mpuOutput.ETag,
-				Size:         aws.Int64(totalSize),
-				LastModified: &now,
-			},
-		}
+// partUpload is the multipart upload the parts of an archive built in memory
+// are written to, in Amazon S3 or, with DstPath, in a local file.
+type partUpload interface {
+	uploadPart(ctx context.Context, partNum int32, data []byte) (types.CompletedPart, error)
+	// complete assembles the parts, sorted by part number, into the archive.
+	complete(ctx context.Context, parts []types.CompletedPart) (*S3Obj, error)
+	abort(ctx context.Context)
+}
 
-		// once the TOC is working we need to subtract 1 to the number of files we report
-		fmt.Printf("total files: %d\n", len(objectList))
-		return complete, nil
+func createPartUpload(ctx context.Context, client *s3.Client, opts *S3TarS3Options) (partUpload, error) {
+	if opts.DstPath != "" {
+		return createLocalUpload(opts)
+	}
+	tags := TagsToUrlEncodedString(archiveTags(opts))
+	mpu, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               &opts.DstBucket,
+		Key:                  &opts.DstKey,
+		StorageClass:         opts.storageClass,
+		ChecksumAlgorithm:    opts.provider.checksumAlgorithm(),
+		Tagging:              &tags,
+		ACL:                  opts.provider.acl(),
+		SSEKMSKeyId:          &opts.KMSKeyID,
+		ServerSideEncryption: opts.SSEAlgo,
+		Metadata:             archiveMetadata(opts),
+	}, accelerate(opts)...)
+	if err != nil {
+		Errorf(ctx, "unable to create multipart")
+		return nil, err
 	}
+	return &s3Upload{client: client, opts: opts, uploadId: *mpu.UploadId}, nil
+}
+
+// s3Upload is the multipart upload of an archive in Amazon S3.
+type s3Upload struct {
+	client   *s3.Client
+	opts     *S3TarS3Options
+	uploadId string
+}
 
+func (u *s3Upload) uploadPart(ctx context.Context, partNum int32, data []byte) (types.CompletedPart, error) {
+	rc, err := uploadPart(ctx, u.client, u.uploadId, u.opts.DstBucket, u.opts.DstKey, data, &partNum, u.opts.provider.checksumAlgorithm(), accelerate(u.opts)...)
+	if err != nil {
+		return types.CompletedPart{}, err
+	}
+	return types.CompletedPart{
+		ETag:           rc.ETag,
+		PartNumber:     &partNum,
+		ChecksumSHA256: rc.ChecksumSHA256,
+	}, nil
+}
+
+func (u *s3Upload) complete(ctx context.Context, parts []types.CompletedPart) (*S3Obj, error) {
+	opts := u.opts
+	mpuOutput, err := u.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		UploadId: &u.uploadId,
+		Bucket:   &opts.DstBucket,
+		Key:      &opts.DstKey,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	}, append(noClobber(opts), accelerate(opts)...)...)
+	if err != nil {
+		Errorf(ctx, "unable to complete mpu")
+		err = destinationError(opts.DstBucket, opts.DstKey, err)
+		if errors.Is(err, ErrDestinationExists) {
+			u.abort(ctx)
+		}
+		return nil, err
+	}
+	now := time.Now()
+	return &S3Obj{
+		Bucket: *mpuOutput.Bucket,
+		Object: types.Object{
+			Key:          mpuOutput.Key,
+			ETag:         mpuOutput.ETag,
+			LastModified: &now,
+		},
+	}, nil
+}
+
+func (u *s3Upload) abort(ctx context.Context) {
+	abortUpload(ctx, u.client, u.opts.DstBucket, u.opts.DstKey, u.uploadId)
 }
 
 func sumSlice[T int | int32 | int64 | float64](i []T) (o T) {
@@ -156,7 +366,7 @@ func sumSlice[T int | int32 | int64 | float64](i []T) (o T) {
 	return
 }
 
-func findLargestObject(objectList []*S3Obj) int64 {
+func findLargestObject(ctx context.Context, objectList []*S3Obj) int64 {
 	var largestObject int64 = 0
 	var largestObjectKey string
 	for _, o := range objectList {
@@ -165,24 +375,28 @@ func findLargestObject(objectList []*S3Obj) int64 {
 			largestObjectKey = *o.Key
 		}
 	}
-	fmt.Printf("largestObjectKey: %s\n", largestObjectKey)
+	Debugf(ctx, "largestObjectKey: %s", largestObjectKey)
 	return largestObject
 }
 
 func uploadObject(ctx context.Context, client *s3.Client, bucket, key string, data []byte, opts *S3TarS3Options) (*S3Obj, error) {
 
+	tags := TagsToUrlEncodedString(archiveTags(opts))
 	rc, err := client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:               &bucket,
 		Key:                  &key,
-		ChecksumAlgorithm:    types.ChecksumAlgorithmSha256,
+		ChecksumAlgorithm:    opts.provider.checksumAlgorithm(),
 		StorageClass:         opts.storageClass,
+		Tagging:              &tags,
 		Body:                 bytes.NewReader(data),
 		SSEKMSKeyId:          &opts.KMSKeyID,
 		ServerSideEncryption: opts.SSEAlgo,
-	})
+		Metadata:             archiveMetadata(opts),
+	}, append(noClobber(opts), accelerate(opts)...)...)
 	if err != nil {
-		return nil, err
+		return nil, destinationError(bucket, key, err)
 	}
+	trackUploaded(ctx, int64(len(data)))
 
 	now := time.Now()
 	var complete *S3Obj
@@ -198,7 +412,9 @@ func uploadObject(ctx context.Context, client *s3.Client, bucket, key string, da
 
 	return complete, nil
 }
-func uploadPart(ctx context.Context, client *s3.Client, uploadId, bucket, key string, data []byte, partNum *int32) (*s3.UploadPartOutput, error) {
+func uploadPart(ctx context.Context, client *s3.Client, uploadId, bucket, key string, data []byte, partNum *int32, checksum types.ChecksumAlgorithm, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	ctx, cancel := partContext(ctx)
+	defer cancel()
 
 	body := io.ReadSeeker(bytes.NewReader(data))
 
@@ -208,62 +424,137 @@ func uploadPart(ctx context.Context, client *s3.Client, uploadId, bucket, key st
 		Key:               &key,
 		PartNumber:        partNum,
 		Body:              body,
-		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
-	})
+		ChecksumAlgorithm: checksum,
+	}, optFns...)
 
 	return rc, err
 
 }
 
-func tarGroup(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) ([]byte, error) {
+// tarGroup returns the objects as a whole tar, their members followed by the
+// end of the archive, and the TOC of its members.
+func tarGroup(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) ([]byte, TOC, error) {
+	data, toc, err := tarMembers(ctx, client, objectList, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(data, endOfArchive()...), toc, nil
+}
+
+// tarMembers downloads the objects, opts.Prefetch of them ahead of the one
+// being written, and returns their tar members, without the end of the
+// archive, and the TOC of the members recorded as they are written, with
+// offsets from the start of the members.
+func tarMembers(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) ([]byte, TOC, error) {
 	buf := bytes.Buffer{}
 	tw := tar.NewWriter(&buf)
+	var toc TOC
+	prefetch := prefetchObjects(ctx, client, objectList, opts)
+	defer prefetch.stop()
 
-	for _, o := range objectList {
+	for i, o := range objectList {
 		var r io.ReadCloser
-		var s3metadata map[string]string
+		var output *s3.GetObjectOutput
 		var err error
 		if len(o.Data) > 0 {
-			s3metadata = nil
 			r = io.NopCloser(bytes.NewReader(o.Data))
 		} else {
-			r, s3metadata, err = downloadS3Data(ctx, client, o)
+			r, output, err = prefetch.open(i)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+			if r == nil {
+				continue
 			}
 		}
 		defer r.Close()
-		h := tar.Header{
-			Name:       *o.Key,
-			Size:       *o.Size,
-			Mode:       0600,
-			ModTime:    *o.LastModified,
-			ChangeTime: *o.LastModified,
-			AccessTime: *o.LastModified,
-			Format:     tarFormat,
-		}
-		if opts.PreservePOSIXMetadata {
-			setHeaderPermissions(&h, s3metadata)
+		h := inMemoryHeader(o)
+		if output != nil {
+			if opts.PreservePOSIXMetadata {
+				preserveMetadata(o, h, output.Metadata)
+			}
+			setOriginVersion(h, output.VersionId)
 		}
 
-		if err := tw.WriteHeader(&h); err != nil {
-			return nil, err
+		if err := tw.WriteHeader(h); err != nil {
+			return nil, nil, err
 		}
-		if _, err := io.Copy(tw, r); err != nil {
-			return nil, err
+		// the header is written, the data starts at the end of the buffer
+		m := &FileMetadata{Filename: h.Name, Start: int64(buf.Len()), Size: h.Size}
+		if o.ETag != nil {
+			m.Etag = *o.ETag
+		}
+		m.Checksum = responseChecksum(output)
+		m.Origin = origin(o)
+		if opts.TocExtended {
+			m.setDetails(o, responseDetails(output))
+		}
+		toc = append(toc, m)
+		if len(o.Data) > 0 {
+			if _, err := io.Copy(tw, r); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		// the MD5 is only computed when there is an ETag to compare it to and
+		// the SDK doesn't validate the body against the checksum of the object,
+		// or for the TOC of a local file, which has no ETag. The SHA-256 of a
+		// presigned url is compared with the one of the manifest.
+		var hash hash.Hash
+		src := io.Reader(r)
+		if _, ok := md5ETag(o, output); o.isPresigned() && o.Checksum != "" {
+			hash = sha256.New()
+		} else if (ok && !checksumValidated(output)) || o.isLocal() {
+			hash = md5.New()
+		}
+		if hash != nil {
+			src = io.TeeReader(r, hash)
+		}
+		n, err := io.Copy(tw, src)
+		if errors.Is(err, tar.ErrWriteTooLong) {
+			return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: read more than the %d bytes of the header", ErrChecksumMismatch, h.Size)}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		// the memory of a prefetched object is free for the next ones
+		r.Close()
+		trackDownloaded(ctx, n)
+		var sum []byte
+		if hash != nil {
+			sum = hash.Sum(nil)
+		}
+		if err := verifyObject(o, output, h.Size, n, sum); err != nil {
+			return nil, nil, err
+		}
+		if o.isLocal() {
+			m.Etag = fmt.Sprintf("%q", hex.EncodeToString(sum))
 		}
 
 	}
 
 	if err := tw.Flush(); err != nil {
-		return nil, err
-	}
-	if err := tw.Close(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return buf.Bytes(), nil
+	return buf.Bytes(), toc, nil
+
+}
 
+// inMemoryHeader returns the tar header tarGroup writes in front of o.
+func inMemoryHeader(o *S3Obj) *tar.Header {
+	hdr := &tar.Header{
+		Name:       o.MemberName(),
+		Size:       *o.Size,
+		Mode:       0600,
+		ModTime:    *o.LastModified,
+		ChangeTime: *o.LastModified,
+		AccessTime: *o.LastModified,
+		Format:     o.format(),
+		PAXRecords: paxRecords(o),
+	}
+	applyHeaderTransforms(o, hdr)
+	return hdr
 }
 
 func splitSliceBySizeLimit(groupSizeLimit int64, objectList []*S3Obj) [][]*S3Obj {
@@ -296,11 +587,74 @@ func splitSliceBySizeLimit(groupSizeLimit int64, objectList []*S3Obj) [][]*S3Obj
 	return groups
 }
 
-func downloadS3Data(ctx context.Context, client *s3.Client, object *S3Obj) (io.ReadCloser, map[string]string, error) {
-	resp, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &object.Bucket, Key: object.Key})
+func downloadS3Data(ctx context.Context, client *s3.Client, object *S3Obj) (*s3.GetObjectOutput, error) {
+	input := &s3.GetObjectInput{Bucket: &object.Bucket, Key: object.Key, VersionId: versionID(object), IfMatch: ifMatch(object)}
+	var optFns []func(*s3.Options)
+	// the checksums stored with an object don't describe what an Object
+	// Lambda access point returns
+	if !isObjectLambda(object.Bucket) && !providerOf(ctx).NoChecksums {
+		optFns = append(optFns, withChecksumValidation(input))
+	}
+	resp, err := client.GetObject(ctx, input, optFns...)
 	if err != nil {
-		fmt.Printf("error downloading: s3://%s/%s\n", object.Bucket, *object.Key)
-		return nil, nil, err
+		Errorf(ctx, "error downloading: s3://%s/%s", object.Bucket, *object.Key)
+		return nil, &ObjectError{Bucket: object.Bucket, Key: *object.Key, Err: classifyError(err)}
+	}
+	if err := checkUnchanged(object, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// verifyObject checks that the n bytes copied into a member of size bytes are
+// the whole object and, when the ETag of the object is the MD5 of its content,
+// that sum matches it, unless sum is nil because the SDK validated the data. A mismatch would leave the archive misaligned or with
+// corrupt data, so it fails the part before it's uploaded.
+func verifyObject(o *S3Obj, output *s3.GetObjectOutput, size, n int64, sum []byte) error {
+	if n != size {
+		return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: read %d bytes, the header has %d", ErrChecksumMismatch, n, size)}
+	}
+	if o.isPresigned() && o.Checksum != "" && sum != nil {
+		return verifyPresigned(o, sum)
+	}
+	etag, ok := md5ETag(o, output)
+	if !ok || sum == nil {
+		return nil
+	}
+	if got := hex.EncodeToString(sum); got != etag {
+		return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: MD5 of the data read is %s, the ETag is %s", ErrChecksumMismatch, got, etag)}
+	}
+	return nil
+}
+
+// md5ETag returns the ETag of the object read when it's the MD5 of its content,
+// which isn't the case for multipart uploads, objects encrypted with SSE-KMS or
+// SSE-C and objects transformed by an Object Lambda access point.
+func md5ETag(o *S3Obj, output *s3.GetObjectOutput) (string, bool) {
+	if isObjectLambda(o.Bucket) {
+		return "", false
+	}
+	etag := o.ETag
+	if output != nil {
+		if output.ServerSideEncryption == types.ServerSideEncryptionAwsKms ||
+			output.ServerSideEncryption == types.ServerSideEncryptionAwsKmsDsse ||
+			output.SSECustomerAlgorithm != nil {
+			return "", false
+		}
+		if output.ETag != nil {
+			etag = output.ETag
+		}
+	}
+	if etag == nil {
+		return "", false
+	}
+	s := strings.ToLower(strings.Trim(*etag, `"`))
+	if len(s) != 2*md5.Size {
+		return "", false
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", false
 	}
-	return resp.Body, resp.Metadata, nil
+	return s, true
 }
@@ -0,0 +1,74 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// MemberInfo describes the member returned by MemberReader.
+type MemberInfo struct {
+	// FileMetadata is the line of the member in the TOC: its name, the offset
+	// of its data, its size, ETag and the columns of TocChecksums and
+	// TocExtended when the archive has them.
+	FileMetadata
+	// Header is the tar header of the member, with its mode, owner and
+	// times, nil when it can't be parsed.
+	Header *tar.Header
+}
+
+// MemberReader returns the data of the member memberName of archive, an
+// s3://bucket/key url, streamed with a single range request, and its
+// details. The member is found in the TOC of the archive, the error wraps
+// ErrNotFound when it isn't there. The caller closes the reader.
+func MemberReader(ctx context.Context, client *s3.Client, archive, memberName string) (io.ReadCloser, *MemberInfo, error) {
+	bucket, key := ExtractBucketAndPath(archive)
+	r, err := headArchive(ctx, client, bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	toc, err := readToc(ctx, client, r)
+	if err != nil {
+		return nil, nil, classifyError(err)
+	}
+	m := findMember(toc, memberName)
+	if m == nil {
+		return nil, nil, fmt.Errorf("%w: %s isn't a member of %s", ErrNotFound, memberName, archive)
+	}
+	info := &MemberInfo{FileMetadata: *m}
+	// the header ends where the data starts, a small read of its own
+	r.readAhead = paxTarHeaderSize
+	if info.Header, _, err = r.headerEnding(m.Start); err != nil {
+		Warnf(ctx, "unable to parse the tar header of %s: %s", m.Filename, err)
+	}
+	if m.Size == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), info, nil
+	}
+	body, err := getObjectRange(ctx, client, bucket, key, m.Start, m.Start+m.Size-1)
+	if err != nil {
+		return nil, nil, classifyError(err)
+	}
+	return body, info, nil
+}
+
+// findMember returns the member of toc named name, ignoring a leading ./ or
+// /, the last one when there are several, as tar does.
+func findMember(toc TOC, name string) *FileMetadata {
+	clean := func(n string) string { return path.Clean("/" + n) }
+	want := clean(name)
+	var found *FileMetadata
+	for _, m := range toc {
+		if clean(m.Filename) == want {
+			found = m
+		}
+	}
+	return found
+}
@@ -0,0 +1,37 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import "testing"
+
+func TestFindMember(t *testing.T) {
+	toc := TOC{
+		{Filename: "a.txt", Start: 1536},
+		{Filename: "./dir/b.txt", Start: 2560},
+		{Filename: "a.txt", Start: 4096},
+	}
+	tests := map[string]struct {
+		name string
+		want int64
+	}{
+		"exact":         {name: "dir/b.txt", want: 2560},
+		"leading dot":   {name: "./dir/b.txt", want: 2560},
+		"leading slash": {name: "/dir/b.txt", want: 2560},
+		"last of two":   {name: "a.txt", want: 4096},
+		"missing":       {name: "c.txt", want: -1},
+		"directory":     {name: "dir", want: -1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := findMember(toc, tt.name)
+			got := int64(-1)
+			if m != nil {
+				got = m.Start
+			}
+			if got != tt.want {
+				t.Errorf("findMember(%q) at %d, want %d", tt.name, got, tt.want)
+			}
+		})
+	}
+}
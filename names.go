@@ -0,0 +1,327 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameTransform rewrites the name an object is stored with inside the archive.
+// name is the result of the previous transforms, the object key for the first.
+type NameTransform func(o *S3Obj, name string) (string, error)
+
+// WithNameTransforms appends transforms applied in order to every member name.
+func WithNameTransforms(transforms ...NameTransform) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.nameTransforms = append(opts.nameTransforms, transforms...)
+	}
+}
+
+// CollisionPolicy decides what happens when two objects get the same member name.
+type CollisionPolicy string
+
+const (
+	// CollisionError fails the run.
+	CollisionError CollisionPolicy = "error"
+	// CollisionSuffix adds -1, -2... before the extension of the later names.
+	CollisionSuffix CollisionPolicy = "suffix"
+	// CollisionHash places the later names under a directory named after the
+	// hash of their bucket and key.
+	CollisionHash CollisionPolicy = "hash"
+)
+
+// WithNameCollisions sets how duplicated member names are resolved, by default
+// they are kept as they are.
+func WithNameCollisions(policy string) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.nameCollisions = CollisionPolicy(policy)
+	}
+}
+
+// Flatten stores the object under its base name only.
+func Flatten() NameTransform {
+	return func(o *S3Obj, name string) (string, error) {
+		return path.Base(name), nil
+	}
+}
+
+// ResolveNameCollisions renames the objects that have the same member name as
+// one before them in objectList.
+func ResolveNameCollisions(objectList []*S3Obj, policy CollisionPolicy) error {
+	switch policy {
+	case "":
+		return nil
+	case CollisionError, CollisionSuffix, CollisionHash:
+	default:
+		return fmt.Errorf("%w: unknown collision policy %q", ErrInvalidArgument, policy)
+	}
+	seen := make(map[string]bool, len(objectList))
+	for _, o := range objectList {
+		name := o.MemberName()
+		if !seen[name] {
+			seen[name] = true
+			continue
+		}
+		switch policy {
+		case CollisionError:
+			return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: member name %s is already in the archive", ErrInvalidArgument, name)}
+		case CollisionSuffix:
+			ext := path.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			for i := 1; seen[name]; i++ {
+				name = fmt.Sprintf("%s-%d%s", base, i, ext)
+			}
+		case CollisionHash:
+			sum := sha256.Sum256([]byte(o.Bucket + "/" + *o.Key))
+			name = fmt.Sprintf("%x/%s", sum[:4], name)
+		}
+		seen[name] = true
+		o.Name = name
+	}
+	return nil
+}
+
+// ApplyNameTransforms sets the member name of every object in objectList.
+func ApplyNameTransforms(objectList []*S3Obj, transforms ...NameTransform) error {
+	for _, o := range objectList {
+		if len(transforms) == 0 && o.source == nil {
+			continue
+		}
+		name := *o.Key
+		if o.localName != "" {
+			// a local file is named relative to the directory archived
+			name = o.localName
+		}
+		for _, t := range transforms {
+			var err error
+			name, err = t(o, name)
+			if err != nil {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+			}
+		}
+		if name == "" {
+			return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: member name is empty after the transforms", ErrInvalidArgument)}
+		}
+		o.Name = sourceName(o, name)
+	}
+	return nil
+}
+
+// NameTemplate names the member with a Go template executed with the
+// ObjectTemplateData of the object, e.g. {{.Dir}}/{{.Base | lower}}.
+func NameTemplate(text string) (NameTransform, error) {
+	t, err := parseObjectTemplate("name", text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: name template: %w", ErrInvalidArgument, err)
+	}
+	return func(o *S3Obj, name string) (string, error) {
+		name, err := executeObjectTemplate(t, o, name)
+		if err != nil {
+			return "", fmt.Errorf("name template: %w", err)
+		}
+		return name, nil
+	}, nil
+}
+
+// NameCase converts member names to "lower" or "upper" case, for archives
+// extracted on case-insensitive filesystems.
+func NameCase(c string) (NameTransform, error) {
+	var convert func(string) string
+	switch c {
+	case "lower":
+		convert = strings.ToLower
+	case "upper":
+		convert = strings.ToUpper
+	default:
+		return nil, fmt.Errorf("%w: name case %q must be lower or upper", ErrInvalidArgument, c)
+	}
+	return func(o *S3Obj, name string) (string, error) {
+		return convert(name), nil
+	}, nil
+}
+
+// NormalizeNames converts member names to the Unicode normalization form
+// "NFC" or "NFD". macOS stores names decomposed, most other systems composed.
+func NormalizeNames(form string) (NameTransform, error) {
+	var f norm.Form
+	switch strings.ToUpper(form) {
+	case "NFC":
+		f = norm.NFC
+	case "NFD":
+		f = norm.NFD
+	default:
+		return nil, fmt.Errorf("%w: normalization %q must be NFC or NFD", ErrInvalidArgument, form)
+	}
+	return func(o *S3Obj, name string) (string, error) {
+		return f.String(name), nil
+	}, nil
+}
+
+// StripComponents removes the first n "/" separated components of the name,
+// project/team/2024/raw/a.csv becomes raw/a.csv with n = 3.
+func StripComponents(n int) NameTransform {
+	return func(o *S3Obj, name string) (string, error) {
+		parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+		if len(parts) <= n {
+			return "", fmt.Errorf("%w: %s has %d components, can't strip %d", ErrInvalidArgument, name, len(parts), n)
+		}
+		return strings.Join(parts[n:], "/"), nil
+	}
+}
+
+// AddPrefix places every member under the directory prefix.
+func AddPrefix(prefix string) NameTransform {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	return func(o *S3Obj, name string) (string, error) {
+		return prefix + strings.TrimPrefix(name, "/"), nil
+	}
+}
+
+// SedTransform parses a GNU tar --transform expression, s/regexp/replacement/flags,
+// where / can be any delimiter. As in GNU tar the regexp is a POSIX basic
+// regexp, groups are \( \), unless the x flag makes it extended. The replacement
+// can refer to the match with & and to groups with \1 to \9. Flags are g to
+// replace every match, a number N to replace the Nth match (and the ones after
+// it with g), i to ignore case and x.
+func SedTransform(expr string) (NameTransform, error) {
+	if len(expr) < 4 || expr[0] != 's' {
+		return nil, fmt.Errorf("%w: transform %q must be s/regexp/replacement/flags", ErrInvalidArgument, expr)
+	}
+	parts, err := splitSedExpression(expr[2:], expr[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: transform %q: %w", ErrInvalidArgument, expr, err)
+	}
+	pattern, replacement, flags := parts[0], parts[1], parts[2]
+
+	global, extended, nth := false, false, 1
+	ignoreCase := false
+	digits := ""
+	for _, f := range flags {
+		switch {
+		case f == 'g':
+			global = true
+		case f == 'i':
+			ignoreCase = true
+		case f == 'x':
+			extended = true
+		case f >= '0' && f <= '9':
+			digits += string(f)
+		default:
+			return nil, fmt.Errorf("%w: transform %q: unknown flag %q", ErrInvalidArgument, expr, f)
+		}
+	}
+	if digits != "" {
+		nth, _ = strconv.Atoi(digits)
+		if nth < 1 {
+			return nil, fmt.Errorf("%w: transform %q: occurrence must be 1 or more", ErrInvalidArgument, expr)
+		}
+	}
+	if !extended {
+		pattern = basicToExtended(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: transform %q: %w", ErrInvalidArgument, expr, err)
+	}
+
+	return func(o *S3Obj, name string) (string, error) {
+		matches := re.FindAllStringSubmatchIndex(name, -1)
+		var b strings.Builder
+		last := 0
+		for i, m := range matches {
+			if i+1 < nth || (i+1 > nth && !global) {
+				continue
+			}
+			b.WriteString(name[last:m[0]])
+			expandSedReplacement(&b, replacement, name, m)
+			last = m[1]
+		}
+		b.WriteString(name[last:])
+		return b.String(), nil
+	}, nil
+}
+
+// splitSedExpression splits regexp/replacement/flags on the unescaped delim,
+// unescaping the delimiter itself.
+func splitSedExpression(s string, delim byte) ([]string, error) {
+	var parts []string
+	var cur strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			cur.WriteByte(delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			cur.WriteByte(s[i])
+			cur.WriteByte(s[i+1])
+			i++
+		case s[i] == delim && len(parts) < 2:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("missing %q delimiter", delim)
+	}
+	return append(parts, cur.String()), nil
+}
+
+// basicToExtended rewrites a POSIX basic regexp into the syntax of regexp,
+// where ( ) { } | + ? are special unless escaped instead of the opposite.
+func basicToExtended(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern) && strings.IndexByte("(){}|+?", pattern[i+1]) >= 0:
+			b.WriteByte(pattern[i+1])
+			i++
+		case c == '\\' && i+1 < len(pattern):
+			b.WriteByte(c)
+			b.WriteByte(pattern[i+1])
+			i++
+		case strings.IndexByte("(){}|+?", c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func expandSedReplacement(b *strings.Builder, replacement, name string, m []int) {
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		switch {
+		case c == '&':
+			b.WriteString(name[m[0]:m[1]])
+		case c == '\\' && i+1 < len(replacement):
+			i++
+			next := replacement[i]
+			if next >= '1' && next <= '9' {
+				g := int(next - '0')
+				if 2*g+1 < len(m) && m[2*g] >= 0 {
+					b.WriteString(name[m[2*g]:m[2*g+1]])
+				}
+			} else {
+				b.WriteByte(next)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestSedTransform(t *testing.T) {
+	tests := []struct {
+		expr string
+		name string
+		want string
+	}{
+		{expr: "s/raw/processed/", name: "data/raw/raw.csv", want: "data/processed/raw.csv"},
+		{expr: "s/raw/processed/g", name: "data/raw/raw.csv", want: "data/processed/processed.csv"},
+		{expr: "s/raw/processed/2", name: "data/raw/raw.csv", want: "data/raw/processed.csv"},
+		{expr: "s/a/b/2g", name: "aaaa", want: "abbb"},
+		{expr: "s/RAW/x/i", name: "raw.csv", want: "x.csv"},
+		{expr: `s,^\([^/]*\)/\(.*\)$,\2/&,`, name: "top/file", want: "file/top/file"},
+		{expr: `s/^([0-9]{4})-([0-9]{2})/\1\/\2/x`, name: "2024-05-01.log", want: "2024/05-01.log"},
+		{expr: `s|^|backup/|`, name: "file", want: "backup/file"},
+		{expr: `s/(1)/x/`, name: "a(1).txt", want: "ax.txt"},
+		{expr: `s/\.txt$/\&.bak/`, name: "a.txt", want: "a&.bak"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			transform, err := SedTransform(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := transform(nil, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("SedTransform(%s)(%s) = %s, want %s", tt.expr, tt.name, got, tt.want)
+			}
+		})
+	}
+
+	for _, expr := range []string{"", "y/a/b/", "s/a/b", "s/a/b/q", "s/(/b/x"} {
+		if _, err := SedTransform(expr); err == nil {
+			t.Errorf("SedTransform(%q) expected an error", expr)
+		}
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	lastModified := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		template string
+		name     string
+		want     string
+		wantErr  bool
+	}{
+		{template: "{{.Dir}}/{{.Base | lower}}", name: "logs/App.LOG", want: "logs/app.log"},
+		{template: `{{.LastModified.Format "2006/01/02"}}/{{.Base}}`, name: "a/b.csv", want: "2024/03/05/b.csv"},
+		{template: `{{.Dir}}/{{trimSuffix .Ext .Base}}.txt`, name: "a/b.log", want: "a/b.txt"},
+		{template: "{{.Bucket}}/{{.Key}}", name: "renamed", want: "bucket/a/b.log"},
+		{template: "{{.Nope}}", name: "a", wantErr: true},
+		{template: "{{.Dir", name: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		o := &S3Obj{Object: types.Object{Key: aws.String("a/b.log"), LastModified: &lastModified}, Bucket: "bucket"}
+		transform, err := NameTemplate(tt.template)
+		if err == nil {
+			var got string
+			got, err = transform(o, tt.name)
+			if err == nil && got != tt.want {
+				t.Errorf("NameTemplate(%s)(%s) = %s, want %s", tt.template, tt.name, got, tt.want)
+			}
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NameTemplate(%s)(%s) error = %v, wantErr %v", tt.template, tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNameCase(t *testing.T) {
+	if _, err := NameCase("title"); err == nil {
+		t.Errorf("NameCase(title) expected an error")
+	}
+	o := &S3Obj{Object: types.Object{Key: aws.String("a")}}
+	for c, want := range map[string]string{"lower": "dir/readme.md", "upper": "DIR/README.MD"} {
+		transform, err := NameCase(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := transform(o, "Dir/ReadMe.md"); got != want {
+			t.Errorf("NameCase(%s) = %s, want %s", c, got, want)
+		}
+	}
+}
+
+func TestNormalizeNames(t *testing.T) {
+	if _, err := NormalizeNames("NFKC"); err == nil {
+		t.Errorf("NormalizeNames(NFKC) expected an error")
+	}
+	composed, decomposed := "caf\u00e9.txt", "cafe\u0301.txt"
+	o := &S3Obj{Object: types.Object{Key: aws.String("a")}}
+	tests := []struct {
+		form string
+		name string
+		want string
+	}{
+		{form: "NFC", name: decomposed, want: composed},
+		{form: "nfc", name: composed, want: composed},
+		{form: "NFD", name: composed, want: decomposed},
+	}
+	for _, tt := range tests {
+		transform, err := NormalizeNames(tt.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := transform(o, tt.name); got != tt.want {
+			t.Errorf("NormalizeNames(%s)(%q) = %q, want %q", tt.form, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripComponents(t *testing.T) {
+	strip := StripComponents(3)
+	got, err := strip(nil, "project/team/2024/raw/a.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "raw/a.csv" {
+		t.Errorf("StripComponents(3) = %s, want raw/a.csv", got)
+	}
+	if _, err := strip(nil, "project/team/a.csv"); err == nil {
+		t.Errorf("StripComponents(3) expected an error for a key with 3 components")
+	}
+}
+
+func TestAddPrefix(t *testing.T) {
+	for _, prefix := range []string{"backup-2024", "backup-2024/"} {
+		got, _ := AddPrefix(prefix)(nil, "logs/a.log")
+		if got != "backup-2024/logs/a.log" {
+			t.Errorf("AddPrefix(%s) = %s, want backup-2024/logs/a.log", prefix, got)
+		}
+	}
+}
+
+func TestResolveNameCollisions(t *testing.T) {
+	keys := []string{"a/report.csv", "b/report.csv", "c/report.csv", "c/other.csv"}
+	tests := []struct {
+		policy  CollisionPolicy
+		want    []string
+		wantErr bool
+	}{
+		{policy: CollisionSuffix, want: []string{"report.csv", "report-1.csv", "report-2.csv", "other.csv"}},
+		{policy: CollisionError, wantErr: true},
+		{policy: "rename", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			var objectList []*S3Obj
+			for _, key := range keys {
+				objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("bucket", key)))
+			}
+			if err := ApplyNameTransforms(objectList, Flatten()); err != nil {
+				t.Fatal(err)
+			}
+			err := ResolveNameCollisions(objectList, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveNameCollisions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, want := range tt.want {
+				if got := objectList[i].MemberName(); got != want {
+					t.Errorf("MemberName() = %s, want %s", got, want)
+				}
+			}
+		})
+	}
+
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("bucket", "a/report.csv")),
+		NewS3ObjOptions(WithBucketAndKey("bucket", "b/report.csv")),
+	}
+	ApplyNameTransforms(objectList, Flatten())
+	if err := ResolveNameCollisions(objectList, CollisionHash); err != nil {
+		t.Fatal(err)
+	}
+	if objectList[0].MemberName() != "report.csv" || !strings.HasSuffix(objectList[1].MemberName(), "/report.csv") {
+		t.Errorf("ResolveNameCollisions(hash) = %s, %s", objectList[0].MemberName(), objectList[1].MemberName())
+	}
+}
+
+func TestApplyNameTransforms(t *testing.T) {
+	first, _ := SedTransform("s/^logs/archive/")
+	second, _ := SedTransform("s/archive/old/")
+	objectList := []*S3Obj{NewS3ObjOptions(WithBucketAndKey("bucket", "logs/a.log"))}
+	if err := ApplyNameTransforms(objectList, first, second); err != nil {
+		t.Fatal(err)
+	}
+	if got := objectList[0].MemberName(); got != "old/a.log" {
+		t.Errorf("MemberName() = %s, want old/a.log", got)
+	}
+	if *objectList[0].Key != "logs/a.log" {
+		t.Errorf("Key = %s, want logs/a.log", *objectList[0].Key)
+	}
+
+	empty, _ := SedTransform("s/.*//")
+	objectList = []*S3Obj{{Object: objectList[0].Object}}
+	objectList[0].Key = aws.String("a")
+	if err := ApplyNameTransforms(objectList, empty); err == nil {
+		t.Errorf("ApplyNameTransforms() expected an error for an empty name")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isObjectLambda reports whether bucket is the ARN of an Object Lambda access
+// point. The objects read through it are transformed, their size and ETag
+// aren't the ones listed.
+func isObjectLambda(bucket string) bool {
+	return strings.HasPrefix(bucket, "arn:") && strings.Contains(bucket, ":s3-object-lambda:")
+}
+
+// checkObjectLambda fails when objects are read through an Object Lambda
+// access point without opts.ConcatInMemory, they can't be copied server-side.
+func checkObjectLambda(objectList []*S3Obj, opts *S3TarS3Options) error {
+	if opts.ConcatInMemory {
+		return nil
+	}
+	for _, o := range objectList {
+		if isObjectLambda(o.Bucket) {
+			return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: objects read through an Object Lambda access point need --concat-in-memory", ErrInvalidArgument)}
+		}
+	}
+	return nil
+}
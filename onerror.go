@@ -0,0 +1,382 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// ErrorPolicy decides what happens when a source object cannot be read.
+type ErrorPolicy string
+
+const (
+	// OnErrorFail aborts the run on the first object that cannot be read.
+	OnErrorFail ErrorPolicy = "fail"
+	// OnErrorSkip leaves the object out of the archive and continues.
+	OnErrorSkip ErrorPolicy = "skip"
+	// OnErrorRetryThenSkip retries the object a few times before skipping it.
+	OnErrorRetryThenSkip ErrorPolicy = "retry-then-skip"
+)
+
+// ChangePolicy decides what happens when a source object changed after it was
+// listed, detected by its ETag or its size.
+type ChangePolicy string
+
+const (
+	// OnChangeFail aborts the run.
+	OnChangeFail ChangePolicy = "fail"
+	// OnChangeSkip leaves the object out of the archive, as OnErrorSkip.
+	OnChangeSkip ChangePolicy = "skip"
+	// OnChangeRefetch archives the current version of the object.
+	OnChangeRefetch ChangePolicy = "refetch"
+)
+
+// ArchivedPolicy decides what happens to a source object in the Glacier
+// Flexible Retrieval or Deep Archive storage classes or an archive tier of
+// Intelligent-Tiering that isn't restored.
+type ArchivedPolicy string
+
+const (
+	// OnArchivedFail aborts the run before anything is written.
+	OnArchivedFail ArchivedPolicy = "fail"
+	// OnArchivedSkip leaves the object out of the archive, as OnErrorSkip.
+	OnArchivedSkip ArchivedPolicy = "skip"
+)
+
+const (
+	contextKeySkipped  = contextKey("skipped")
+	onErrorMaxAttempts = 4
+	onErrorBackoff     = time.Second
+)
+
+func validateErrorPolicy(opts *S3TarS3Options) error {
+	switch opts.OnError {
+	case "":
+		opts.OnError = OnErrorFail
+	case OnErrorFail, OnErrorSkip, OnErrorRetryThenSkip:
+	default:
+		return fmt.Errorf("%w: unknown error policy %q", ErrInvalidArgument, opts.OnError)
+	}
+	return nil
+}
+
+func validateArchivedPolicy(opts *S3TarS3Options) error {
+	switch opts.OnArchived {
+	case "":
+		opts.OnArchived = OnArchivedFail
+	case OnArchivedFail, OnArchivedSkip:
+	default:
+		return fmt.Errorf("%w: unknown archived policy %q", ErrInvalidArgument, opts.OnArchived)
+	}
+	return nil
+}
+
+func validateChangePolicy(ctx context.Context, opts *S3TarS3Options) error {
+	switch opts.OnChange {
+	case "":
+		opts.OnChange = OnChangeFail
+	case OnChangeFail:
+	case OnChangeSkip, OnChangeRefetch:
+		if !opts.ConcatInMemory {
+			Warnf(ctx, "--on-change %s only applies to objects downloaded with --concat-in-memory, objects copied server-side fail the run when they change", opts.OnChange)
+		}
+	default:
+		return fmt.Errorf("%w: unknown change policy %q", ErrInvalidArgument, opts.OnChange)
+	}
+	return nil
+}
+
+// SkippedObject is a source object left out of the archive and the reason why.
+type SkippedObject struct {
+	Bucket   string
+	Key      string
+	Size     int64
+	ETag     string
+	Attempts int
+	Err      error
+}
+
+// ErrorClass returns a short name for the cause of the failure, the Amazon S3
+// error code when there is one.
+func (o *SkippedObject) ErrorClass() string {
+	var ae smithy.APIError
+	var se *stateError
+	switch {
+	case errors.As(o.Err, &se):
+		return se.class
+	case errors.Is(o.Err, ErrAccessDenied):
+		return "AccessDenied"
+	case errors.Is(o.Err, errDeleteMarker):
+		return "DeleteMarker"
+	case errors.Is(o.Err, ErrNotFound):
+		return "NotFound"
+	case errors.Is(o.Err, ErrChecksumMismatch):
+		return "ChecksumMismatch"
+	case errors.Is(o.Err, ErrObjectArchived):
+		return "ObjectArchived"
+	case errors.Is(o.Err, context.DeadlineExceeded):
+		return "Timeout"
+	case errors.Is(o.Err, context.Canceled):
+		return "Canceled"
+	case errors.As(o.Err, &ae):
+		return ae.ErrorCode()
+	}
+	return "Other"
+}
+
+type skipTracker struct {
+	mu      sync.Mutex
+	objects []*SkippedObject
+}
+
+func withSkipTracker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeySkipped, &skipTracker{})
+}
+
+func skipObject(ctx context.Context, o *S3Obj, attempts int, err error) {
+	Warnf(ctx, "skipping s3://%s/%s after %d attempts: %s", o.Bucket, *o.Key, attempts, err.Error())
+	skipped := &SkippedObject{Bucket: o.Bucket, Key: *o.Key, Attempts: attempts, Err: err}
+	if o.Size != nil {
+		skipped.Size = *o.Size
+	}
+	if o.ETag != nil {
+		skipped.ETag = strings.Trim(*o.ETag, `"`)
+	}
+	recordSkipped(ctx, skipped)
+}
+
+func recordSkipped(ctx context.Context, skipped *SkippedObject) {
+	if t, ok := ctx.Value(contextKeySkipped).(*skipTracker); ok {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		// a retried part skips its objects again, they are recorded once
+		for i, s := range t.objects {
+			if s.Bucket == skipped.Bucket && s.Key == skipped.Key {
+				t.objects[i] = skipped
+				return
+			}
+		}
+		t.objects = append(t.objects, skipped)
+	}
+}
+
+func skippedObjects(ctx context.Context) []*SkippedObject {
+	t, ok := ctx.Value(contextKeySkipped).(*skipTracker)
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*SkippedObject{}, t.objects...)
+}
+
+// errorManifestHeader are the columns of the error manifest. The first four
+// match the columns of an input manifest, the last one is the archive the
+// objects are missing from.
+var errorManifestHeader = []string{"bucket", "key", "size", "etag", "error_class", "attempts", "error", "archive"}
+
+// errorManifestLocation is where the error manifest of the archive is written,
+// next to the archive unless opts.ErrorManifest is set.
+func errorManifestLocation(opts *S3TarS3Options) string {
+	if opts.ErrorManifest != "" {
+		return opts.ErrorManifest
+	}
+	if opts.DstPath != "" {
+		return opts.DstPath + ".errors.csv"
+	}
+	return fmt.Sprintf("s3://%s/%s.errors.csv", opts.DstBucket, opts.DstKey)
+}
+
+// WriteErrorManifest writes the objects skipped from archive as csv to a local
+// path or an s3:// url.
+func WriteErrorManifest(ctx context.Context, svc *s3.Client, location, archive string, skipped []*SkippedObject) error {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+	if err := w.Write(errorManifestHeader); err != nil {
+		return err
+	}
+	for _, o := range skipped {
+		record := []string{
+			o.Bucket,
+			o.Key,
+			strconv.FormatInt(o.Size, 10),
+			o.ETag,
+			o.ErrorClass(),
+			strconv.Itoa(o.Attempts),
+			o.Err.Error(),
+			archive,
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return saveFile(ctx, svc, location, buf.Bytes())
+}
+
+// openObject downloads o following the error and change policies of opts. It
+// returns a nil reader when o is skipped.
+func openObject(ctx context.Context, client *s3.Client, o *S3Obj, opts *S3TarS3Options) (io.ReadCloser, *s3.GetObjectOutput, error) {
+	var r io.ReadCloser
+	var output *s3.GetObjectOutput
+	var err error
+	attempts := 1
+	skipErrors := opts.OnError == OnErrorSkip || opts.OnError == OnErrorRetryThenSkip
+	if skipErrors {
+		var data []byte
+		data, output, attempts, err = downloadWithPolicy(ctx, client, o, opts.OnError)
+		if err == nil {
+			r = io.NopCloser(bytes.NewReader(data))
+		}
+	} else {
+		output, r, err = streamObject(ctx, client, o)
+	}
+	if errors.Is(err, ErrSourceChanged) {
+		switch opts.OnChange {
+		case OnChangeSkip:
+			skipObject(ctx, o, attempts, err)
+			return nil, nil, nil
+		case OnChangeRefetch:
+			Warnf(ctx, "s3://%s/%s changed since it was listed, archiving its current version", o.Bucket, *o.Key)
+			o.ETag = nil
+			output, r, err = streamObject(ctx, client, o)
+			if err == nil {
+				o.Size, o.ETag, o.LastModified = output.ContentLength, output.ETag, output.LastModified
+			}
+		}
+	}
+	if err != nil {
+		if skipErrors && !errors.Is(err, ErrSourceChanged) {
+			skipObject(ctx, o, attempts, err)
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if isObjectLambda(o.Bucket) {
+		// the header is written with the size of the transformed object
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+		}
+		o.Size = aws.Int64(int64(len(data)))
+		r = io.NopCloser(bytes.NewReader(data))
+	}
+	return r, output, nil
+}
+
+// checkUnchanged returns an error wrapping ErrSourceChanged when the object
+// read isn't the size o was listed with.
+func checkUnchanged(o *S3Obj, output *s3.GetObjectOutput) error {
+	if isObjectLambda(o.Bucket) {
+		return nil
+	}
+	if o.Size != nil && output.ContentLength != nil && *o.Size != *output.ContentLength {
+		return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: listed with %d bytes, read %d bytes", ErrSourceChanged, *o.Size, *output.ContentLength)}
+	}
+	return nil
+}
+
+// ifMatch returns the ETag o was listed with as an If-Match condition, nil
+// when it isn't known or o is transformed by an Object Lambda access point.
+func ifMatch(o *S3Obj) *string {
+	if o.ETag == nil || isObjectLambda(o.Bucket) || strings.Trim(*o.ETag, `"`) == "" {
+		return nil
+	}
+	etag := `"` + strings.Trim(*o.ETag, `"`) + `"`
+	return &etag
+}
+
+// downloadWithPolicy reads the whole object so a failure half way through the
+// body, or data that doesn't match the object, does not leave a partial member
+// in the archive. With OnErrorRetryThenSkip
+// the download is retried with an exponential backoff.
+// It returns the number of attempts made, the body of the output is already read.
+func downloadWithPolicy(ctx context.Context, client *s3.Client, o *S3Obj, policy ErrorPolicy) ([]byte, *s3.GetObjectOutput, int, error) {
+	attempts := 1
+	if policy == OnErrorRetryThenSkip {
+		attempts = onErrorMaxAttempts
+	}
+	var err error
+	backoff := onErrorBackoff
+	i := 0
+	for ; i < attempts; i++ {
+		if i > 0 {
+			Debugf(ctx, "retrying s3://%s/%s in %s", o.Bucket, *o.Key, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, nil, i, ctx.Err()
+			}
+			backoff *= 2
+		}
+		var output *s3.GetObjectOutput
+		var data []byte
+		data, output, err = readObject(ctx, client, o)
+		if errors.Is(err, ErrSourceChanged) {
+			return nil, nil, i + 1, err
+		}
+		if err != nil {
+			continue
+		}
+		if isObjectLambda(o.Bucket) {
+			// the size and checksum of a transformed object are only known once it's read
+			return data, output, i + 1, nil
+		}
+		var sum []byte
+		if !checksumValidated(output) {
+			md5sum := md5.Sum(data)
+			sum = md5sum[:]
+		}
+		if err = verifyObject(o, output, *o.Size, int64(len(data)), sum); err == nil {
+			return data, output, i + 1, nil
+		}
+	}
+	return nil, nil, i, err
+}
+
+// readObject downloads the whole object within the object timeout of ctx.
+func readObject(ctx context.Context, client *s3.Client, o *S3Obj) ([]byte, *s3.GetObjectOutput, error) {
+	ctx, cancel := objectContext(ctx)
+	defer cancel()
+	output, body, err := openObjectBody(ctx, client, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+	}
+	return data, output, nil
+}
+
+// streamObject opens the body of the object, which is read within the object
+// timeout of ctx until it's closed.
+func streamObject(ctx context.Context, client *s3.Client, o *S3Obj) (*s3.GetObjectOutput, io.ReadCloser, error) {
+	ctx, cancel := objectContext(ctx)
+	output, body, err := openObjectBody(ctx, client, o)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return output, cancelOnClose{body, cancel}, nil
+}
@@ -0,0 +1,65 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PartOrder decides the order the parts of an archive are built, or copied
+// server-side, in. Every part keeps its place in the archive whatever the
+// order.
+type PartOrder string
+
+const (
+	// PartOrderListing processes the parts in the order of the listing.
+	PartOrderListing PartOrder = ""
+	// PartOrderLargestFirst processes the largest parts first, so a large
+	// part isn't left running alone at the end of a run whose parts have
+	// very different sizes.
+	PartOrderLargestFirst PartOrder = "largest-first"
+	// PartOrderSmallestFirst processes the smallest parts first.
+	PartOrderSmallestFirst PartOrder = "smallest-first"
+)
+
+func validatePartOrder(opts *S3TarS3Options) error {
+	switch opts.PartOrder {
+	case PartOrderListing, PartOrderLargestFirst, PartOrderSmallestFirst:
+	case "listing":
+		opts.PartOrder = PartOrderListing
+	default:
+		return fmt.Errorf("%w: unknown part order %q", ErrInvalidArgument, opts.PartOrder)
+	}
+	// the members of exact-fit parts are cut in the order of the archive
+	if opts.PartOrder != PartOrderListing && opts.PartPadding == PartPaddingExactFit {
+		return fmt.Errorf("%w: part order %s can't be used with exact-fit parts", ErrInvalidArgument, opts.PartOrder)
+	}
+	return nil
+}
+
+// partOrder returns the indexes of the parts of sizes in the order they are
+// processed, parts of the same size in the order of the listing.
+func partOrder(order PartOrder, sizes []int64) []int {
+	indexes := make([]int, len(sizes))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	switch order {
+	case PartOrderLargestFirst:
+		sort.SliceStable(indexes, func(i, j int) bool { return sizes[indexes[i]] > sizes[indexes[j]] })
+	case PartOrderSmallestFirst:
+		sort.SliceStable(indexes, func(i, j int) bool { return sizes[indexes[i]] < sizes[indexes[j]] })
+	}
+	return indexes
+}
+
+// objectsSize returns the size of the data of objects.
+func objectsSize(objects []*S3Obj) int64 {
+	var size int64
+	for _, o := range objects {
+		size += *o.Size
+	}
+	return size
+}
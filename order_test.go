@@ -0,0 +1,40 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPartOrder(t *testing.T) {
+	sizes := []int64{5, 20, 5, 1, 20}
+	tests := map[string]struct {
+		opts    S3TarS3Options
+		want    []int
+		wantErr error
+	}{
+		"listing":        {opts: S3TarS3Options{PartOrder: "listing"}, want: []int{0, 1, 2, 3, 4}},
+		"default":        {want: []int{0, 1, 2, 3, 4}},
+		"largest-first":  {opts: S3TarS3Options{PartOrder: PartOrderLargestFirst}, want: []int{1, 4, 0, 2, 3}},
+		"smallest-first": {opts: S3TarS3Options{PartOrder: PartOrderSmallestFirst}, want: []int{3, 0, 2, 1, 4}},
+		"unknown":        {opts: S3TarS3Options{PartOrder: "random"}, wantErr: ErrInvalidArgument},
+		"exact-fit":      {opts: S3TarS3Options{PartOrder: PartOrderLargestFirst, PartPadding: PartPaddingExactFit}, wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validatePartOrder(&tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validatePartOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := partOrder(tt.opts.PartOrder, sizes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("partOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
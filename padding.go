@@ -0,0 +1,162 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PartPadding decides how the parts of an archive built in memory end. Every
+// part but the last one has to be at least 5MiB, and the archive ends with two
+// zero blocks that can't appear anywhere else.
+type PartPadding string
+
+const (
+	// PartPaddingZeroBlocks ends every part at the end of a member, and only
+	// the last one with the two zero blocks. A part left under 5MiB by
+	// skipped objects is filled with a pad member as with PartPaddingPadFile,
+	// one left under 5MiB by re-fetched objects fails the run.
+	PartPaddingZeroBlocks PartPadding = ""
+	// PartPaddingPadFile is PartPaddingZeroBlocks, but a part left under 5MiB
+	// is filled with a member of zeros named .s3tar-padding/part-NNNNN,
+	// listed in the TOC and never extracted.
+	PartPaddingPadFile PartPadding = "pad-file"
+	// PartPaddingExactFit writes the members as a single stream cut into
+	// parts of exactly the part size, a member can start in a part and end in
+	// the next one.
+	PartPaddingExactFit PartPadding = "exact-fit"
+)
+
+// padMemberPrefix is the directory of the members written by
+// PartPaddingPadFile.
+const padMemberPrefix = ".s3tar-padding/"
+
+func validatePartPadding(opts *S3TarS3Options) error {
+	switch opts.PartPadding {
+	case PartPaddingZeroBlocks, PartPaddingPadFile, PartPaddingExactFit:
+	case "zero-blocks":
+		opts.PartPadding = PartPaddingZeroBlocks
+	default:
+		return fmt.Errorf("%w: unknown part padding %q", ErrInvalidArgument, opts.PartPadding)
+	}
+	return nil
+}
+
+// endOfArchive returns the two zero blocks written after the last member.
+func endOfArchive() []byte {
+	return make([]byte, 2*blockSize)
+}
+
+// isPadMember reports whether name is a member written by PartPaddingPadFile.
+func isPadMember(name string) bool {
+	return strings.HasPrefix(name, padMemberPrefix)
+}
+
+// padPart fills data, the members of part partNum, up to the 5MiB minimum
+// with a pad member when opts.PartPadding is PartPaddingPadFile, or when
+// objects of the part were skipped, and returns the TOC of the part with the
+// pad member. Parts already over the minimum are returned unchanged, other
+// short parts fail.
+func padPart(ctx context.Context, data []byte, toc TOC, partNum int32, opts *S3TarS3Options, skipped bool) ([]byte, TOC, error) {
+	if int64(len(data)) >= fileSizeMin {
+		return data, toc, nil
+	}
+	name := fmt.Sprintf("%spart-%05d", padMemberPrefix, partNum)
+	switch {
+	case opts.PartPadding == PartPaddingPadFile:
+	case skipped:
+		Warnf(ctx, "part %d is under the 5MiB minimum after skipping objects, filling it with %s", partNum, name)
+	default:
+		return nil, nil, fmt.Errorf("part %d is under the 5MiB minimum after re-fetching objects", partNum)
+	}
+	// the header of the pad member takes a block, its data is padded to one
+	size := fileSizeMin - int64(len(data)) - blockSize
+	if size < 0 {
+		size = 0
+	}
+	size += findPadding(size)
+	buf := bytes.NewBuffer(data)
+	tw := tar.NewWriter(buf)
+	hdr := &tar.Header{Name: name, Size: size, Mode: 0600, ModTime: time.Unix(0, 0), Format: opts.tarFormat}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, nil, err
+	}
+	start := int64(buf.Len())
+	if _, err := tw.Write(make([]byte, size)); err != nil {
+		return nil, nil, err
+	}
+	if err := tw.Flush(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), append(toc, &FileMetadata{Filename: name, Start: start, Size: size}), nil
+}
+
+// exactFit cuts the members of the groups of an archive, added in order, into
+// parts of size bytes. Parts are numbered as they are cut.
+type exactFit struct {
+	size  int64
+	turns []chan struct{}
+	carry []byte
+	parts int32
+}
+
+func newExactFit(size int64, groups int) *exactFit {
+	e := &exactFit{size: size, turns: make([]chan struct{}, groups)}
+	for i := range e.turns {
+		e.turns[i] = make(chan struct{})
+	}
+	close(e.turns[0])
+	return e
+}
+
+// add waits for the members of the groups before group i to be added, appends
+// the members of group i and returns the data of the parts completed by them,
+// a multiple of the part size, and the number of the first one. The last
+// group completes a last part with whatever is left and the end of the
+// archive.
+func (e *exactFit) add(ctx context.Context, i int, members []byte) ([]byte, int32, error) {
+	select {
+	case <-e.turns[i]:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	last := i == len(e.turns)-1
+	data := append(e.carry, members...)
+	n := int64(len(data)) / e.size * e.size
+	if last {
+		data = append(data, endOfArchive()...)
+		n = int64(len(data))
+	}
+	e.carry = append([]byte(nil), data[n:]...)
+	first := e.parts + 1
+	e.parts += int32(partCount(n, e.size))
+	if !last {
+		close(e.turns[i+1])
+	}
+	return data[:n], first, nil
+}
+
+// splitPart splits data into parts of size bytes, the last one shorter.
+func splitPart(data []byte, size int64) [][]byte {
+	var parts [][]byte
+	for int64(len(data)) > size {
+		parts = append(parts, data[:size])
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		parts = append(parts, data)
+	}
+	return parts
+}
+
+// partCount is the number of parts of size bytes, the last one shorter, in n
+// bytes.
+func partCount(n, size int64) int64 {
+	return (n + size - 1) / size
+}
@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"testing"
+)
+
+func TestPadPart(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	objectList := testObjects(10, 700)
+	opts := &S3TarS3Options{ConcatInMemory: true, PartPadding: PartPaddingPadFile}
+	members, toc, err := tarMembers(ctx, nil, objectList, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := padPart(ctx, members, toc, 3, &S3TarS3Options{}, false); err == nil {
+		t.Errorf("padPart() of a short part with zero-blocks succeeded, want an error")
+	}
+	if data, _, err := padPart(ctx, members, toc, 3, &S3TarS3Options{}, true); err != nil || int64(len(data)) < fileSizeMin {
+		t.Errorf("padPart() of a part short of skipped objects with zero-blocks = %d bytes, %v", len(data), err)
+	}
+	data, toc, err := padPart(ctx, members, toc, 3, opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) < fileSizeMin || int64(len(data))%blockSize != 0 {
+		t.Errorf("padPart() = %d bytes, want at least %d in whole blocks", len(data), fileSizeMin)
+	}
+	pad := toc[len(toc)-1]
+	if len(toc) != 3 || pad.Filename != ".s3tar-padding/part-00003" || !isPadMember(pad.Filename) || pad.Start+pad.Size != int64(len(data)) {
+		t.Errorf("padPart() toc = %d members, the last %+v", len(toc), pad)
+	}
+	tr := tar.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(endOfArchive())))
+	n := 0
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("padded part has %d members, want 3", n)
+	}
+}
+
+func TestSkippedShortPart(t *testing.T) {
+	const mb = 1 << 20
+	ctx := SetupLogger(context.Background())
+	store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}, failing: map[string]bool{"src/b.bin": true}}
+	var objectList []*S3Obj
+	for _, o := range []struct {
+		key  string
+		size int
+	}{{"a.bin", 3 * mb}, {"b.bin", 3 * mb}, {"c.bin", 6 * mb}} {
+		store.objects["src/"+o.key] = bytes.Repeat([]byte(o.key[:1]), o.size)
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src", o.key), WithSize(int64(o.size))))
+	}
+	opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 1, OnError: OnErrorSkip}
+	if err := createFromList(ctx, store.client(), objectList, opts); err != nil {
+		t.Fatal(err)
+	}
+	parts := store.parts["dst/a.tar"]
+	if len(parts) != 2 || len(parts[1]) < fileSizeMin {
+		t.Fatalf("the archive has %d parts, the first of %d bytes, want 2 parts, the first of at least 5MiB", len(parts), len(parts[1]))
+	}
+	var members []string
+	tr := tar.NewReader(bytes.NewReader(store.objects["dst/a.tar"]))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, hdr.Name)
+	}
+	sort.Strings(members)
+	if len(members) != 3 || members[0] != ".s3tar-padding/part-00001" || members[1] != "a.bin" || members[2] != "c.bin" {
+		t.Errorf("the archive has the members %v, want a.bin, c.bin and the pad member of part 1", members)
+	}
+}
+
+func TestExactFit(t *testing.T) {
+	ctx := context.Background()
+	fit := newExactFit(4, 3)
+	groups := [][]byte{[]byte("abcdef"), []byte("g"), []byte("hij")}
+	want := []struct {
+		data  string
+		first int32
+	}{{"abcd", 1}, {"", 2}, {"efghij" + string(endOfArchive()), 2}}
+	done := make(chan struct{})
+	go func() {
+		// the last group waits for the others to be added
+		data, first, err := fit.add(ctx, 2, groups[2])
+		if err != nil || string(data) != want[2].data || first != want[2].first {
+			t.Errorf("add(2) = %q, %d, %v", data, first, err)
+		}
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		data, first, err := fit.add(ctx, i, groups[i])
+		if err != nil || string(data) != want[i].data || first != want[i].first {
+			t.Errorf("add(%d) = %q, %d, %v, want %q, %d", i, data, first, err, want[i].data, want[i].first)
+		}
+	}
+	<-done
+	if got := splitPart([]byte("efghij"), 4); len(got) != 2 || string(got[1]) != "ij" {
+		t.Errorf("splitPart() = %q", got)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := newExactFit(4, 2).add(canceled, 1, nil); err != context.Canceled {
+		t.Errorf("add() of a group whose previous one never comes = %v, want context.Canceled", err)
+	}
+}
+
+func TestValidatePartPadding(t *testing.T) {
+	for mode, wantErr := range map[PartPadding]bool{"": false, "zero-blocks": false, "pad-file": false, "exact-fit": false, "trim": true} {
+		if err := validatePartPadding(&S3TarS3Options{PartPadding: mode}); (err != nil) != wantErr {
+			t.Errorf("validatePartPadding(%q) error = %v, want error %v", mode, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// partRetryBackoff is the wait before the first retry of a part, doubled
+// before every other one.
+var partRetryBackoff = 2 * time.Second
+
+func validatePartRetries(opts *S3TarS3Options) error {
+	if opts.PartRetries < 0 {
+		return fmt.Errorf("%w: the number of part retries can't be negative", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// retryablePartError reports whether a part that failed with err can succeed
+// when it's built again. Failures the SDK already retried, a timeout or a
+// connection reset half way through a body, are; a missing object, a denied
+// request or a changed source are not.
+func retryablePartError(err error) bool {
+	err = classifyError(err)
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, ErrInvalidArgument),
+		errors.Is(err, ErrAccessDenied),
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrObjectTooLarge),
+		errors.Is(err, ErrSourceChanged),
+		errors.Is(err, ErrObjectArchived),
+		errors.Is(err, ErrDestinationExists):
+		return false
+	}
+	return true
+}
+
+// withPartRetries runs fn, which builds and uploads part partNum, and runs it
+// again up to opts.PartRetries times when it fails with a retryable error. The
+// backoff only delays that part, the other parts of the run keep going.
+func withPartRetries(ctx context.Context, partNum int32, opts *S3TarS3Options, fn func() error) error {
+	backoff := partRetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt >= opts.PartRetries || !retryablePartError(err) {
+			return err
+		}
+		Warnf(ctx, "part %d failed, retrying in %s (%d of %d): %s", partNum, backoff, attempt+1, opts.PartRetries, err.Error())
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWithPartRetries(t *testing.T) {
+	defer func(b time.Duration) { partRetryBackoff = b }(partRetryBackoff)
+	partRetryBackoff = time.Millisecond
+
+	transient := errors.New("connection reset by peer")
+	tests := []struct {
+		name         string
+		retries      int
+		failures     int
+		err          error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "no failure", retries: 2, wantAttempts: 1},
+		{name: "recovers", retries: 2, failures: 2, err: transient, wantAttempts: 3},
+		{name: "out of retries", retries: 2, failures: 3, err: transient, wantAttempts: 3, wantErr: true},
+		{name: "no retries", retries: 0, failures: 1, err: transient, wantAttempts: 1, wantErr: true},
+		{name: "not found", retries: 2, failures: 1, err: fmt.Errorf("%w: s3://bucket/key", ErrNotFound), wantAttempts: 1, wantErr: true},
+		{name: "source changed", retries: 2, failures: 1, err: &ObjectError{Bucket: "bucket", Key: "key", Err: ErrSourceChanged}, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		err := withPartRetries(context.Background(), 1, &S3TarS3Options{PartRetries: tt.retries}, func() error {
+			attempts++
+			if attempts <= tt.failures {
+				return tt.err
+			}
+			return nil
+		})
+		if (err != nil) != tt.wantErr || attempts != tt.wantAttempts {
+			t.Errorf("%s: withPartRetries() error = %v after %d attempts, want error %v after %d", tt.name, err, attempts, tt.wantErr, tt.wantAttempts)
+		}
+	}
+}
+
+func TestValidatePartRetries(t *testing.T) {
+	if err := validatePartRetries(&S3TarS3Options{PartRetries: -1}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("validatePartRetries(-1) error = %v, want ErrInvalidArgument", err)
+	}
+	if err := validatePartRetries(&S3TarS3Options{PartRetries: 3}); err != nil {
+		t.Errorf("validatePartRetries(3) error = %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultBufferSize is the memory the parts built in memory can take when
+// S3TarS3Options.MaxBufferedParts isn't set.
+const defaultBufferSize = 2 * 1024 * 1024 * 1024
+
+func validateMaxBufferedParts(opts *S3TarS3Options) error {
+	if opts.MaxBufferedParts < 0 {
+		return fmt.Errorf("%w: the number of buffered parts can't be negative", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// bufferedParts returns the number of parts of partSize bytes held in memory
+// at once: opts.MaxBufferedParts, or as many as fit in defaultBufferSize, at
+// least 2 so a part is built while another one is uploaded, and no more than
+// opts.Threads.
+func bufferedParts(opts *S3TarS3Options, partSize int64) int {
+	if opts.MaxBufferedParts > 0 {
+		return opts.MaxBufferedParts
+	}
+	n := int(defaultBufferSize / partSize)
+	if n > opts.Threads {
+		n = opts.Threads
+	}
+	if n < 2 {
+		n = 2
+	}
+	return n
+}
+
+const contextKeyDrain = contextKey("drain")
+
+// errDrained is returned by runPipeline when it stopped scheduling parts to
+// drain.
+var errDrained = errors.New("drained")
+
+// drainer cancels the context of a run when a drain is requested, unless
+// pipelines are running, which finish the parts they started instead. The
+// context is canceled once the last of them is done.
+type drainer struct {
+	mu        sync.Mutex
+	drain     <-chan struct{}
+	cancel    context.CancelFunc
+	requested bool
+	pipelines int
+}
+
+// WithDrain returns a context whose runs stop gracefully once drain is
+// closed, e.g. on SIGTERM. An archive built in memory stops scheduling new
+// parts, waits for the parts being built and uploaded, and keeps its
+// multipart upload with a checkpoint that Resume continues from; the run
+// returns an error wrapping ErrResumable. The other runs are canceled at
+// once, as is the context when ctx is.
+func WithDrain(ctx context.Context, drain <-chan struct{}) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	d := &drainer{drain: drain, cancel: cancel}
+	go func() {
+		select {
+		case <-drain:
+		case <-ctx.Done():
+			return
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.requested = true
+		if d.pipelines == 0 {
+			cancel()
+		}
+	}()
+	return context.WithValue(ctx, contextKeyDrain, d)
+}
+
+// startDrain registers a pipeline that drains instead of being canceled. It
+// returns the channel closed when it should drain, nil without WithDrain, and
+// the func to call once it's done.
+func startDrain(ctx context.Context) (<-chan struct{}, func()) {
+	d, ok := ctx.Value(contextKeyDrain).(*drainer)
+	if !ok {
+		return nil, func() {}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pipelines++
+	return d.drain, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.pipelines--
+		if d.requested && d.pipelines == 0 {
+			d.cancel()
+		}
+	}
+}
+
+type builtPart struct {
+	i    int
+	data []byte
+}
+
+// runPipeline builds the n parts of an archive with build and uploads them
+// with upload, workers of each at a time. A part takes one of buffered slots
+// from the start of its build to the end of its upload, so when the uploads
+// are slower than the downloads the builds wait for them instead of piling up
+// parts in memory. The first error cancels the other parts. When a drain is
+// requested no part is started anymore, the parts started are finished and
+// errDrained is returned, also when they were all started already: the
+// context of the run is canceled once the pipeline is done.
+func runPipeline(ctx context.Context, n, workers, buffered int, build func(ctx context.Context, i int) ([]byte, error), upload func(ctx context.Context, i int, data []byte) error) error {
+	drain, done := startDrain(ctx)
+	defer done()
+	g, ctx := errgroup.WithContext(ctx)
+	slots := make(chan struct{}, buffered)
+	next := make(chan int)
+	// never full, there are at most buffered parts between build and upload
+	built := make(chan builtPart, buffered)
+	drained := false
+
+	g.Go(func() error {
+		defer close(next)
+		for i := 0; i < n; i++ {
+			select {
+			case <-drain:
+				drained = true
+				return nil
+			default:
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-drain:
+				drained = true
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	var builders sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		builders.Add(1)
+		g.Go(func() error {
+			defer builders.Done()
+			for i := range next {
+				data, err := build(ctx, i)
+				if err != nil {
+					return err
+				}
+				built <- builtPart{i: i, data: data}
+			}
+			return nil
+		})
+	}
+	go func() {
+		builders.Wait()
+		close(built)
+	}()
+
+	for w := 0; w < workers; w++ {
+		g.Go(func() error {
+			for p := range built {
+				if ctx.Err() != nil {
+					<-slots
+					continue
+				}
+				err := upload(ctx, p.i, p.data)
+				<-slots
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	select {
+	case <-drain:
+		drained = true
+	default:
+	}
+	if drained {
+		return errDrained
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunPipeline(t *testing.T) {
+	const n, workers, buffered = 20, 4, 3
+	var inMemory, peak int32
+	uploaded := make([]bool, n)
+	var mu sync.Mutex
+	build := func(ctx context.Context, i int) ([]byte, error) {
+		held := atomic.AddInt32(&inMemory, 1)
+		mu.Lock()
+		if held > peak {
+			peak = held
+		}
+		mu.Unlock()
+		return []byte{byte(i)}, nil
+	}
+	upload := func(ctx context.Context, i int, data []byte) error {
+		// uploads slower than builds
+		time.Sleep(time.Millisecond)
+		if int(data[0]) != i {
+			t.Errorf("part %d uploaded with the data of part %d", i, data[0])
+		}
+		mu.Lock()
+		uploaded[i] = true
+		mu.Unlock()
+		atomic.AddInt32(&inMemory, -1)
+		return nil
+	}
+	if err := runPipeline(context.Background(), n, workers, buffered, build, upload); err != nil {
+		t.Fatal(err)
+	}
+	if peak > buffered {
+		t.Errorf("runPipeline() held %d parts in memory, want at most %d", peak, buffered)
+	}
+	for i, ok := range uploaded {
+		if !ok {
+			t.Errorf("part %d not uploaded", i)
+		}
+	}
+}
+
+func TestRunPipeline_Error(t *testing.T) {
+	failed := errors.New("upload failed")
+	var builds int32
+	build := func(ctx context.Context, i int) ([]byte, error) {
+		atomic.AddInt32(&builds, 1)
+		return []byte{byte(i)}, nil
+	}
+	upload := func(ctx context.Context, i int, data []byte) error {
+		if i == 2 {
+			return failed
+		}
+		return nil
+	}
+	if err := runPipeline(context.Background(), 1000, 2, 2, build, upload); !errors.Is(err, failed) {
+		t.Errorf("runPipeline() error = %v, want %v", err, failed)
+	}
+	if builds == 1000 {
+		t.Errorf("runPipeline() built every part after an upload failed")
+	}
+
+	if err := runPipeline(context.Background(), 5, 2, 2, func(ctx context.Context, i int) ([]byte, error) {
+		return nil, failed
+	}, upload); !errors.Is(err, failed) {
+		t.Errorf("runPipeline() error = %v, want %v", err, failed)
+	}
+}
+
+func TestRunPipeline_Drain(t *testing.T) {
+	drain := make(chan struct{})
+	ctx := WithDrain(context.Background(), drain)
+	var once sync.Once
+	var uploads int32
+	build := func(ctx context.Context, i int) ([]byte, error) {
+		return []byte{byte(i)}, nil
+	}
+	upload := func(ctx context.Context, i int, data []byte) error {
+		once.Do(func() { close(drain) })
+		// the parts started are finished, ctx isn't canceled
+		time.Sleep(time.Millisecond)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		atomic.AddInt32(&uploads, 1)
+		return nil
+	}
+	if err := runPipeline(ctx, 100, 2, 2, build, upload); !errors.Is(err, errDrained) {
+		t.Fatalf("runPipeline() error = %v, want %v", err, errDrained)
+	}
+	if uploads == 0 || uploads == 100 {
+		t.Errorf("runPipeline() uploaded %d of 100 parts after the drain", uploads)
+	}
+	if err := runPipeline(WithDrain(context.Background(), make(chan struct{})), 5, 2, 2, build, func(context.Context, int, []byte) error { return nil }); err != nil {
+		t.Errorf("runPipeline() error = %v without a drain", err)
+	}
+	// a drain requested while a pipeline runs cancels the run once it's done
+	drain = make(chan struct{})
+	ctx = WithDrain(context.Background(), drain)
+	_, done := startDrain(ctx)
+	close(drain)
+	time.Sleep(10 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Error("the context is canceled while a pipeline is draining")
+	}
+	done()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("the context isn't canceled once the pipeline is done")
+	}
+	// without a pipeline running the run is canceled
+	closed := make(chan struct{})
+	close(closed)
+	select {
+	case <-WithDrain(context.Background(), closed).Done():
+	case <-time.After(time.Second):
+		t.Error("the context isn't canceled by the drain")
+	}
+}
+
+func TestBufferedParts(t *testing.T) {
+	tests := []struct {
+		opts     S3TarS3Options
+		partSize int64
+		want     int
+	}{
+		{opts: S3TarS3Options{Threads: 100}, partSize: fileSizeMin, want: 100},
+		{opts: S3TarS3Options{Threads: 100}, partSize: 256 * 1024 * 1024, want: 8},
+		{opts: S3TarS3Options{Threads: 100}, partSize: partSizeMax, want: 2},
+		{opts: S3TarS3Options{Threads: 100, MaxBufferedParts: 5}, partSize: fileSizeMin, want: 5},
+	}
+	for _, tt := range tests {
+		if got := bufferedParts(&tt.opts, tt.partSize); got != tt.want {
+			t.Errorf("bufferedParts(%d, %d) = %d, want %d", tt.opts.MaxBufferedParts, tt.partSize, got, tt.want)
+		}
+	}
+}
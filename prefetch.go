@@ -0,0 +1,160 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/semaphore"
+)
+
+// prefetchBufferSize is the memory the objects downloaded ahead of a part
+// being built can take. Larger objects are streamed when they are reached.
+const prefetchBufferSize = 16 * 1024 * 1024
+
+// tinyObjectSize is the largest object downloaded ahead in the window of
+// opts.TinyPrefetch, whose requests take longer than their transfer.
+const tinyObjectSize = 64 * 1024
+
+func validatePrefetch(opts *S3TarS3Options) error {
+	if opts.Prefetch < 0 || opts.TinyPrefetch < 0 {
+		return fmt.Errorf("%w: the number of prefetched objects can't be negative", ErrInvalidArgument)
+	}
+	return nil
+}
+
+type prefetched struct {
+	r      io.ReadCloser
+	output *s3.GetObjectOutput
+	err    error
+}
+
+// prefetcher downloads the objects of a group ahead of tarGroup, at most
+// opts.Prefetch objects, opts.TinyPrefetch more of at most tinyObjectSize, and
+// prefetchBufferSize bytes at a time, so the next objects are read while the
+// current one is written to the tar stream.
+type prefetcher struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	client    *s3.Client
+	opts      *S3TarS3Options
+	objects   []*S3Obj
+	results   []chan prefetched
+	ahead     chan struct{}
+	tinyAhead chan struct{}
+	memory    *semaphore.Weighted
+}
+
+// prefetchObjects starts downloading the objects of objectList that fit in
+// prefetchBufferSize. The objects are then opened in order with open, and
+// stop cancels the downloads still running.
+func prefetchObjects(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) *prefetcher {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &prefetcher{ctx: ctx, cancel: cancel, client: client, opts: opts, objects: objectList, results: make([]chan prefetched, len(objectList))}
+	if opts.Prefetch <= 0 && opts.TinyPrefetch <= 0 {
+		return p
+	}
+	p.ahead = make(chan struct{}, opts.Prefetch)
+	p.tinyAhead = make(chan struct{}, opts.TinyPrefetch)
+	p.memory = semaphore.NewWeighted(prefetchBufferSize)
+	for i, o := range objectList {
+		if prefetchable(ctx, o) && cap(p.window(o)) > 0 {
+			p.results[i] = make(chan prefetched, 1)
+		}
+	}
+	go p.run()
+	return p
+}
+
+// window returns the slots of the objects downloaded ahead o takes one of:
+// tiny objects have their own when opts.TinyPrefetch is set, so a part of
+// many of them keeps that many GETs in flight on its connections.
+func (p *prefetcher) window(o *S3Obj) chan struct{} {
+	if cap(p.tinyAhead) > 0 && *o.Size <= tinyObjectSize {
+		return p.tinyAhead
+	}
+	return p.ahead
+}
+
+// prefetchable reports whether o is downloaded ahead: objects with their data
+// in memory already, objects downloaded in ranges and objects larger than the
+// prefetch buffer are not.
+func prefetchable(ctx context.Context, o *S3Obj) bool {
+	if len(o.Data) > 0 || o.Size == nil || *o.Size > prefetchBufferSize {
+		return false
+	}
+	_, ranged := rangesOf(ctx, o)
+	return !ranged
+}
+
+func (p *prefetcher) run() {
+	for i, o := range p.objects {
+		if p.results[i] == nil {
+			continue
+		}
+		select {
+		case p.window(o) <- struct{}{}:
+		case <-p.ctx.Done():
+			return
+		}
+		size := *o.Size
+		if err := p.memory.Acquire(p.ctx, size); err != nil {
+			return
+		}
+		go func(i int, o *S3Obj) {
+			r, output, err := openObject(p.ctx, p.client, o, p.opts)
+			if err != nil || r == nil {
+				p.memory.Release(size)
+				p.results[i] <- prefetched{err: err}
+				return
+			}
+			data, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				p.memory.Release(size)
+				p.results[i] <- prefetched{err: &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}}
+				return
+			}
+			release := &sync.Once{}
+			p.results[i] <- prefetched{r: releaseOnClose{Reader: bytes.NewReader(data), release: func() { release.Do(func() { p.memory.Release(size) }) }}, output: output}
+		}(i, o)
+	}
+}
+
+// open returns the body of the i-th object as openObject does, the body of a
+// prefetched object has to be closed for the objects after it to be
+// downloaded.
+func (p *prefetcher) open(i int) (io.ReadCloser, *s3.GetObjectOutput, error) {
+	if p.results[i] == nil {
+		return openObject(p.ctx, p.client, p.objects[i], p.opts)
+	}
+	select {
+	case r := <-p.results[i]:
+		<-p.window(p.objects[i])
+		return r.r, r.output, r.err
+	case <-p.ctx.Done():
+		return nil, nil, p.ctx.Err()
+	}
+}
+
+func (p *prefetcher) stop() {
+	p.cancel()
+}
+
+// releaseOnClose gives the memory of a prefetched object back when its body
+// is closed.
+type releaseOnClose struct {
+	io.Reader
+	release func()
+}
+
+func (r releaseOnClose) Close() error {
+	r.release()
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestPrefetchable(t *testing.T) {
+	ctx := withRanges(context.Background(), &S3TarS3Options{RangeSize: 8 * 1024 * 1024, RangeConcurrency: 4})
+	object := func(size int64) *S3Obj {
+		return &S3Obj{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(size)}}
+	}
+	tests := map[string]struct {
+		o    *S3Obj
+		want bool
+	}{
+		"small":  {o: object(1024), want: true},
+		"ranged": {o: object(9 * 1024 * 1024)},
+		"data":   {o: &S3Obj{Object: types.Object{Key: aws.String("toc.csv"), Size: aws.Int64(3)}, Data: []byte("a,b")}},
+	}
+	for name, tt := range tests {
+		if got := prefetchable(ctx, tt.o); got != tt.want {
+			t.Errorf("%s: prefetchable() = %v, want %v", name, got, tt.want)
+		}
+	}
+	if prefetchable(context.Background(), object(prefetchBufferSize+1)) {
+		t.Errorf("prefetchable() = true for an object larger than the prefetch buffer")
+	}
+	if err := validatePrefetch(&S3TarS3Options{Prefetch: -1}); err == nil {
+		t.Errorf("validatePrefetch(-1) error = nil")
+	}
+	if err := validatePrefetch(&S3TarS3Options{TinyPrefetch: -1}); err == nil {
+		t.Errorf("validatePrefetch(tiny -1) error = nil")
+	}
+}
+
+func TestPrefetchWindow(t *testing.T) {
+	object := func(size int64) *S3Obj {
+		return &S3Obj{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(size)}}
+	}
+	objects := []*S3Obj{object(1024), object(tinyObjectSize), object(tinyObjectSize + 1)}
+	tests := map[string]struct {
+		opts S3TarS3Options
+		want []bool
+	}{
+		"prefetch":      {opts: S3TarS3Options{Prefetch: 4}, want: []bool{true, true, true}},
+		"tiny prefetch": {opts: S3TarS3Options{TinyPrefetch: 32}, want: []bool{true, true, false}},
+		"both":          {opts: S3TarS3Options{Prefetch: 4, TinyPrefetch: 32}, want: []bool{true, true, true}},
+		"no prefetch":   {want: []bool{false, false, false}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := prefetchObjects(context.Background(), nil, nil, &tt.opts)
+			defer p.stop()
+			for i, o := range objects {
+				prefetched := p.ahead != nil && cap(p.window(o)) > 0
+				if prefetched != tt.want[i] {
+					t.Errorf("object of %d bytes prefetched = %t, want %t", *o.Size, prefetched, tt.want[i])
+				}
+				if tiny := p.window(o) == p.tinyAhead; prefetched && tiny != (tt.opts.TinyPrefetch > 0 && i < 2) {
+					t.Errorf("object of %d bytes in the tiny window = %t", *o.Size, tiny)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// PreflightMode decides what is checked about the source objects before
+// anything is written.
+type PreflightMode string
+
+const (
+	// PreflightNone starts writing right away, the objects are first read
+	// when their part is built.
+	PreflightNone PreflightMode = ""
+	// PreflightHead requests the HEAD of every object first, so objects that
+	// can't be read fail the run before the multipart upload is created.
+	PreflightHead PreflightMode = "head"
+)
+
+func validatePreflight(opts *S3TarS3Options) error {
+	switch opts.Preflight {
+	case PreflightNone, PreflightHead:
+	case "none":
+		opts.Preflight = PreflightNone
+	default:
+		return fmt.Errorf("%w: unknown preflight %q", ErrInvalidArgument, opts.Preflight)
+	}
+	return nil
+}
+
+// preflight requests the HEAD of every object of objectList with
+// PreflightHead, and of the objects listed in a storage class that can be
+// archived otherwise, and returns the objects that can be read. Objects that
+// are denied, missing, archived or changed since they were listed fail the
+// run, or are skipped as they would have been when read, with opts.OnError,
+// opts.OnChange and opts.OnArchived.
+func preflight(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) ([]*S3Obj, error) {
+	checked := make([]bool, len(objectList))
+	count := 0
+	for i, o := range objectList {
+		if len(o.Data) > 0 || o.NoHeaderRequired || isObjectLambda(o.Bucket) || o.isLocal() || o.isPresigned() {
+			continue
+		}
+		if opts.Preflight == PreflightHead || archiveClass(o) {
+			checked[i] = true
+			count++
+		}
+	}
+	if count == 0 {
+		return objectList, nil
+	}
+	if opts.Preflight == PreflightHead {
+		Infof(ctx, "preflight: checking %d objects", count)
+	} else {
+		Infof(ctx, "preflight: checking the tier of %d objects in the Glacier or Intelligent-Tiering storage classes", count)
+	}
+	failures := make([]error, len(objectList))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for i, o := range objectList {
+		i, o := i, o
+		if !checked[i] {
+			continue
+		}
+		g.Go(func() error {
+			head, err := readClient(svc, o).HeadObject(gctx, &s3.HeadObjectInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o)})
+			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				failures[i] = &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: classifyError(err)}
+				return nil
+			}
+			failures[i] = checkHead(o, head)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var first error
+	failed, archived := 0, 0
+	readable := make([]*S3Obj, 0, len(objectList))
+	for i, o := range objectList {
+		err := failures[i]
+		switch {
+		case err == nil, errors.Is(err, ErrSourceChanged) && opts.OnChange == OnChangeRefetch:
+			readable = append(readable, o)
+		case errors.Is(err, ErrSourceChanged) && opts.OnChange == OnChangeSkip,
+			errors.Is(err, ErrObjectArchived) && opts.OnArchived == OnArchivedSkip,
+			!errors.Is(err, ErrSourceChanged) && opts.OnError != OnErrorFail:
+			skipObject(ctx, o, 1, err)
+		default:
+			Errorf(ctx, "preflight: %s", err.Error())
+			if first == nil {
+				first = err
+			}
+			failed++
+			if errors.Is(err, ErrObjectArchived) {
+				archived++
+			}
+		}
+	}
+	if archived > 0 {
+		Warnf(ctx, "preflight: %d objects have to be restored, with --restore-and-wait, or left out with --on-archived skip", archived)
+	}
+	if first != nil {
+		return nil, fmt.Errorf("preflight: %d of %d objects can't be archived, the first: %w", failed, len(objectList), first)
+	}
+	if opts.Preflight == PreflightHead {
+		Infof(ctx, "preflight: %d objects can be archived", len(readable))
+	}
+	return readable, nil
+}
+
+// archiveClass reports whether o was listed in a storage class whose objects
+// can be archived and have to be restored before they are read. The tier of
+// an Intelligent-Tiering object is only known from its HEAD.
+func archiveClass(o *S3Obj) bool {
+	switch o.StorageClass {
+	case types.ObjectStorageClassGlacier, types.ObjectStorageClassDeepArchive, types.ObjectStorageClassIntelligentTiering:
+		return true
+	}
+	return false
+}
+
+// checkHead returns an error when the HEAD of o shows it can't be read: it's
+// in an archive storage class or tier without a completed restore, or it
+// changed since it was listed.
+func checkHead(o *S3Obj, head *s3.HeadObjectOutput) error {
+	archived, _, restored := restoreStatus(head)
+	if archived && !restored {
+		class := string(head.StorageClass)
+		if head.ArchiveStatus != "" {
+			class = string(head.ArchiveStatus)
+		}
+		return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: %s, it has to be restored first", ErrObjectArchived, class)}
+	}
+	if o.Size != nil && head.ContentLength != nil && *o.Size != *head.ContentLength {
+		return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: listed with %d bytes, now %d bytes", ErrSourceChanged, *o.Size, *head.ContentLength)}
+	}
+	if etag := ifMatch(o); etag != nil && head.ETag != nil && *etag != *head.ETag {
+		return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: listed with ETag %s, now %s", ErrSourceChanged, *etag, *head.ETag)}
+	}
+	return nil
+}
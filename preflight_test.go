@@ -0,0 +1,72 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestCheckHead(t *testing.T) {
+	o := &S3Obj{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(10), ETag: aws.String(`"abc"`)}}
+	tests := []struct {
+		name string
+		head s3.HeadObjectOutput
+		want error
+	}{
+		{name: "standard", head: s3.HeadObjectOutput{ContentLength: aws.Int64(10), ETag: aws.String(`"abc"`)}},
+		{name: "glacier", head: s3.HeadObjectOutput{StorageClass: types.StorageClassGlacier}, want: ErrObjectArchived},
+		{name: "restoring", head: s3.HeadObjectOutput{StorageClass: types.StorageClassDeepArchive, Restore: aws.String(`ongoing-request="true"`)}, want: ErrObjectArchived},
+		{name: "restored", head: s3.HeadObjectOutput{StorageClass: types.StorageClassGlacier, Restore: aws.String(`ongoing-request="false", expiry-date="Fri, 23 Dec 2026 00:00:00 GMT"`)}},
+		{name: "archive tier", head: s3.HeadObjectOutput{StorageClass: types.StorageClassIntelligentTiering, ArchiveStatus: types.ArchiveStatusArchiveAccess}, want: ErrObjectArchived},
+		{name: "instant retrieval", head: s3.HeadObjectOutput{StorageClass: types.StorageClassGlacierIr}},
+		{name: "resized", head: s3.HeadObjectOutput{ContentLength: aws.Int64(11)}, want: ErrSourceChanged},
+		{name: "overwritten", head: s3.HeadObjectOutput{ContentLength: aws.Int64(10), ETag: aws.String(`"def"`)}, want: ErrSourceChanged},
+	}
+	for _, tt := range tests {
+		err := checkHead(o, &tt.head)
+		if (tt.want == nil && err != nil) || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: checkHead() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if err := classifyError(&smithy.GenericAPIError{Code: "InvalidObjectState"}); !errors.Is(err, ErrObjectArchived) {
+		t.Errorf("classifyError(InvalidObjectState) = %v, want ErrObjectArchived", err)
+	}
+}
+
+func TestValidatePreflight(t *testing.T) {
+	for mode, wantErr := range map[PreflightMode]bool{"": false, "none": false, "head": false, "get": true} {
+		opts := &S3TarS3Options{Preflight: mode}
+		if err := validatePreflight(opts); (err != nil) != wantErr {
+			t.Errorf("validatePreflight(%q) error = %v, wantErr %v", mode, err, wantErr)
+		}
+	}
+}
+
+func TestArchiveClass(t *testing.T) {
+	tests := map[types.ObjectStorageClass]bool{
+		types.ObjectStorageClassStandard:           false,
+		types.ObjectStorageClassGlacierIr:          false,
+		types.ObjectStorageClassGlacier:            true,
+		types.ObjectStorageClassDeepArchive:        true,
+		types.ObjectStorageClassIntelligentTiering: true,
+	}
+	for class, want := range tests {
+		o := &S3Obj{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), StorageClass: class}}
+		if got := archiveClass(o); got != want {
+			t.Errorf("archiveClass(%s) = %v, want %v", class, got, want)
+		}
+	}
+	for policy, wantErr := range map[ArchivedPolicy]bool{"": false, "fail": false, "skip": false, "restore": true} {
+		opts := &S3TarS3Options{OnArchived: policy}
+		if err := validateArchivedPolicy(opts); (err != nil) != wantErr || (err == nil && opts.OnArchived == "") {
+			t.Errorf("validateArchivedPolicy(%q) error = %v, wantErr %v", policy, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// isPresignedURL reports whether the first column of a manifest line is a
+// presigned GET url instead of a bucket.
+func isPresignedURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// presignedObject returns the object of a manifest line that starts with a
+// presigned GET url: url,key,size and an optional checksum. The key, the name
+// of the member, defaults to the path of the url. The checksum is the MD5 of
+// the object in hex, compared as an ETag is, or its SHA-256 as sha256:<hex>
+// or sha256:<base64>. The object is reported as <scheme>://<host>/<key>, the
+// signature in the query of the url is never logged.
+func presignedObject(rawURL, key string, size int64, checksum string) (*S3Obj, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid presigned url", ErrInvalidArgument)
+	}
+	if key == "" {
+		key = strings.TrimPrefix(u.Path, "/")
+	}
+	if key == "" {
+		return nil, fmt.Errorf("%w: presigned url of %s://%s has no path, the key column is required", ErrInvalidArgument, u.Scheme, u.Host)
+	}
+	o := NewS3ObjOptions(WithBucketAndKey(u.Scheme+"://"+u.Host, key), WithSize(size))
+	o.presignedURL = rawURL
+	if sum, ok := strings.CutPrefix(checksum, "sha256:"); ok {
+		b, err := hex.DecodeString(sum)
+		if err != nil {
+			b, err = base64.StdEncoding.DecodeString(sum)
+		}
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("%w: invalid SHA-256 %q of %s", ErrInvalidArgument, sum, key)
+		}
+		o.Checksum = "sha256:" + base64.StdEncoding.EncodeToString(b)
+	} else if checksum != "" {
+		o.ETag = aws.String(checksum)
+	}
+	return o, nil
+}
+
+// isPresigned reports whether o is read from a presigned url.
+func (o *S3Obj) isPresigned() bool {
+	return o.presignedURL != ""
+}
+
+// checkPresignedSource validates the options of a run with presigned urls,
+// which are downloaded as plain HTTP: they can only be archived in memory,
+// there is nothing to copy server-side or to lock.
+func checkPresignedSource(objectList []*S3Obj, opts *S3TarS3Options) error {
+	presigned := false
+	for _, o := range objectList {
+		presigned = presigned || o.isPresigned()
+	}
+	if !presigned {
+		return nil
+	}
+	if opts.ServerSideOnly {
+		return fmt.Errorf("%w: presigned urls can't be archived with --server-side-only", ErrInvalidArgument)
+	}
+	if opts.Lock != LockNone {
+		return fmt.Errorf("%w: --lock needs an Amazon S3 source", ErrInvalidArgument)
+	}
+	opts.ConcatInMemory = true
+	return nil
+}
+
+// openPresigned sends the GET of the presigned url of o with the HTTP client
+// of client, with an output that describes the response as a GetObject would:
+// its size, its modification time, the user metadata of an Amazon S3 object
+// and the checksum of the manifest.
+func openPresigned(ctx context.Context, client *s3.Client, o *S3Obj) (*s3.GetObjectOutput, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.presignedURL, nil)
+	if err != nil {
+		return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: invalid presigned url", ErrInvalidArgument)}
+	}
+	resp, err := client.Options().HTTPClient.Do(req)
+	if err != nil {
+		// the error of the client has the url, and its signature, in it
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := fmt.Errorf("GET returned %s", resp.Status)
+		switch resp.StatusCode {
+		case http.StatusForbidden:
+			err = fmt.Errorf("%w: the presigned url is denied or expired", ErrAccessDenied)
+		case http.StatusNotFound:
+			err = fmt.Errorf("%w: %s", ErrNotFound, err.Error())
+		}
+		return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+	}
+	output := &s3.GetObjectOutput{
+		LastModified: o.LastModified,
+		Metadata:     map[string]string{},
+	}
+	if resp.ContentLength >= 0 {
+		output.ContentLength = aws.Int64(resp.ContentLength)
+	}
+	if t, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified")); err == nil {
+		output.LastModified = aws.Time(t)
+	}
+	for name, values := range resp.Header {
+		if k, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok && len(values) > 0 {
+			output.Metadata[k] = values[0]
+		}
+	}
+	if sum, ok := strings.CutPrefix(o.Checksum, "sha256:"); ok {
+		output.ChecksumSHA256 = aws.String(sum)
+	}
+	if err := checkUnchanged(o, output); err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+	return output, resp, nil
+}
+
+// verifyPresigned compares sum, the SHA-256 of the data read from the
+// presigned url of o, with the checksum of the manifest.
+func verifyPresigned(o *S3Obj, sum []byte) error {
+	want := strings.TrimPrefix(o.Checksum, "sha256:")
+	if got := base64.StdEncoding.EncodeToString(sum); got != want {
+		return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("%w: SHA-256 of the data read is %s, the manifest has %s", ErrChecksumMismatch, got, want)}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPresignedObject(t *testing.T) {
+	const sha256Hex = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	const sha256Base64 = "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	tests := map[string]struct {
+		url, key, checksum string
+		wantKey            string
+		wantETag           string
+		wantChecksum       string
+		wantErr            bool
+	}{
+		"key from the path": {url: "https://bucket.s3.amazonaws.com/dir/a.txt?X-Amz-Signature=x", wantKey: "dir/a.txt"},
+		"key column":        {url: "https://bucket.s3.amazonaws.com/dir/a.txt?X-Amz-Signature=x", key: "b.txt", wantKey: "b.txt"},
+		"md5":               {url: "https://host/a", checksum: "5d41402abc4b2a76b9719d911017c592", wantKey: "a", wantETag: "5d41402abc4b2a76b9719d911017c592"},
+		"sha256 hex":        {url: "https://host/a", checksum: "sha256:" + sha256Hex, wantKey: "a", wantChecksum: "sha256:" + sha256Base64},
+		"sha256 base64":     {url: "https://host/a", checksum: "sha256:" + sha256Base64, wantKey: "a", wantChecksum: "sha256:" + sha256Base64},
+		"invalid sha256":    {url: "https://host/a", checksum: "sha256:abc", wantErr: true},
+		"no path":           {url: "https://host?X-Amz-Signature=x", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			o, err := presignedObject(tt.url, tt.key, 5, tt.checksum)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("presignedObject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidArgument) || strings.Contains(err.Error(), "Signature") {
+					t.Errorf("presignedObject() error = %v", err)
+				}
+				return
+			}
+			if !o.isPresigned() || *o.Key != tt.wantKey || *o.ETag != tt.wantETag || o.Checksum != tt.wantChecksum {
+				t.Errorf("presignedObject() = key %s, etag %s, checksum %s", *o.Key, *o.ETag, o.Checksum)
+			}
+			if got := objectURL(o.Bucket, *o.Key); strings.Contains(got, "?") || !strings.HasSuffix(got, "/"+tt.wantKey) {
+				t.Errorf("objectURL() = %s", got)
+			}
+		})
+	}
+}
+
+func TestParseCSVPresigned(t *testing.T) {
+	manifest := "bucket,key.txt,10,\nhttps://host/a.txt?X-Amz-Signature=x,,5,\n"
+	objectList, _, err := parseCSV(context.Background(), strings.NewReader(manifest), false, false)
+	if err != nil {
+		t.Fatalf("parseCSV() error = %v", err)
+	}
+	if len(objectList) != 2 || objectList[0].isPresigned() || !objectList[1].isPresigned() {
+		t.Fatalf("parseCSV() = %d objects, want an object and a presigned url", len(objectList))
+	}
+
+	opts := &S3TarS3Options{}
+	if err := checkPresignedSource(objectList, opts); err != nil || !opts.ConcatInMemory {
+		t.Errorf("checkPresignedSource() error = %v, ConcatInMemory = %v", err, opts.ConcatInMemory)
+	}
+	opts = &S3TarS3Options{ServerSideOnly: true}
+	if err := checkPresignedSource(objectList, opts); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("checkPresignedSource(ServerSideOnly) error = %v, want ErrInvalidArgument", err)
+	}
+}
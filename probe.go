@@ -0,0 +1,324 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// The statuses of a ProbeCheck.
+const (
+	ProbeOK      = "ok"
+	ProbeWarning = "warning"
+	ProbeProblem = "problem"
+)
+
+// ProbeCheck is a permission or a setting the archive needs, checked by
+// Probe. A check with a problem would fail the run, one with a warning
+// wouldn't but leaves something behind or couldn't be checked.
+type ProbeCheck struct {
+	Check  string `json:"check"`
+	Target string `json:"target"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ProbeReport has the checks of Probe, in the order they were made.
+type ProbeReport struct {
+	Checks   []ProbeCheck `json:"checks"`
+	Problems int          `json:"problems"`
+	Warnings int          `json:"warnings"`
+	first    error
+}
+
+func (r *ProbeReport) add(check, target string, err error) {
+	if err != nil {
+		r.problem(check, target, err)
+		return
+	}
+	r.Checks = append(r.Checks, ProbeCheck{Check: check, Target: target, Status: ProbeOK})
+}
+
+func (r *ProbeReport) problem(check, target string, err error) {
+	err = classifyError(err)
+	r.Checks = append(r.Checks, ProbeCheck{Check: check, Target: target, Status: ProbeProblem, Detail: err.Error()})
+	r.Problems++
+	if r.first == nil {
+		r.first = fmt.Errorf("%s %s: %w", check, target, err)
+	}
+}
+
+func (r *ProbeReport) warn(check, target, format string, v ...any) {
+	r.Checks = append(r.Checks, ProbeCheck{Check: check, Target: target, Status: ProbeWarning, Detail: fmt.Sprintf(format, v...)})
+	r.Warnings++
+}
+
+func (r *ProbeReport) note(check, target, format string, v ...any) {
+	r.Checks = append(r.Checks, ProbeCheck{Check: check, Target: target, Status: ProbeOK, Detail: fmt.Sprintf(format, v...)})
+}
+
+// Probe checks, without creating the archive of options, that the run can
+// list and read the sources, write and delete its intermediate objects,
+// create, upload to and abort the multipart upload of the archive with its
+// encryption, tags and ACL, sign its TOC and export it to DynamoDB, and reads
+// the Object Ownership and Object Lock settings of the destination bucket.
+// Every check is made and reported, the error is the first problem found. The
+// objects written are deleted and the multipart upload aborted.
+func Probe(ctx context.Context, svc *s3.Client, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) (*ProbeReport, error) {
+	opts := options.Copy()
+	if err := checkCreateArgs(&opts); err != nil {
+		return nil, err
+	}
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if err := validateStorageClass(&opts); err != nil {
+		return nil, err
+	}
+	if err := checkClient(svc, &opts); err != nil {
+		return nil, err
+	}
+	if err := validateProvider(&opts); err != nil {
+		return nil, err
+	}
+	if opts.RunID == "" {
+		opts.RunID = NewRunID()
+	}
+	ctx = WithRunID(ctx, opts.RunID)
+
+	r := &ProbeReport{Checks: []ProbeCheck{}}
+	first := probeSources(ctx, sourceClient(svc, &opts), &opts, r)
+	if opts.DstPath == "" {
+		probeBucket(ctx, svc, &opts, r)
+		probeWrites(ctx, svc, &opts, first, r)
+	}
+	if opts.SignTocKeyID != "" {
+		_, err := signToc(ctx, newKMSClient(svc), opts.SignTocKeyID, &TocAttestation{Archive: archiveURL(&opts)})
+		r.add("sign", opts.SignTocKeyID, err)
+	}
+	if opts.TocTable.Name != "" {
+		r.add("describe table", opts.TocTable.Name, checkTocTable(ctx, newDynamoClient(svc), opts.TocTable))
+	}
+	if r.first != nil {
+		return r, fmt.Errorf("probe: %d problems, the first: %w", r.Problems, r.first)
+	}
+	return r, nil
+}
+
+// probeSources checks that the sources can be listed and their first object
+// read, and returns that object, nil when there's none in Amazon S3.
+func probeSources(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, r *ProbeReport) *S3Obj {
+	var first *S3Obj
+	switch {
+	case opts.SrcManifest != "":
+		objectList, _, err := LoadCSV(ctx, svc, opts.SrcManifest, opts.SkipManifestHeader, opts.UrlDecode)
+		r.add("read manifest", opts.SrcManifest, err)
+		for _, o := range objectList {
+			if !o.isLocal() && !o.isPresigned() {
+				first = o
+				break
+			}
+		}
+	case opts.SrcPath != "":
+		return nil
+	default:
+		sources := opts.Sources
+		if len(sources) == 0 {
+			sources = []Source{{Bucket: opts.SrcBucket, Prefix: opts.SrcPrefix}}
+		}
+		for _, s := range sources {
+			client := svc
+			if s.Client != nil {
+				client = s.Client
+			}
+			target := objectURL(s.Bucket, s.Prefix)
+			out, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(s.Bucket), Prefix: aws.String(s.Prefix), MaxKeys: aws.Int32(1)})
+			switch {
+			case err != nil:
+				r.problem("list", target, err)
+			case len(out.Contents) == 0:
+				r.problem("list", target, fmt.Errorf("%w: matched zero objects", ErrNotFound))
+			default:
+				r.add("list", target, nil)
+				if first == nil {
+					first = &S3Obj{Bucket: s.Bucket, Object: out.Contents[0]}
+				}
+			}
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	// a byte is enough to check the object and its AWS KMS key can be read
+	target := objectURL(first.Bucket, *first.Key)
+	client := readClient(svc, first)
+	if aws.ToInt64(first.Size) == 0 {
+		_, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &first.Bucket, Key: first.Key, VersionId: versionID(first)})
+		r.add("read", target, err)
+		return first
+	}
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &first.Bucket, Key: first.Key, VersionId: versionID(first), Range: aws.String("bytes=0-0")})
+	if err == nil {
+		io.Copy(io.Discard, out.Body)
+		out.Body.Close()
+	}
+	r.add("read", target, err)
+	return first
+}
+
+// probeBucket checks the destination bucket exists and reads its Object
+// Ownership and Object Lock settings.
+func probeBucket(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, r *ProbeReport) {
+	target := objectURL(opts.DstBucket, "")
+	if _, err := svc.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &opts.DstBucket}); err != nil {
+		r.problem("bucket", target, err)
+		return
+	}
+	r.add("bucket", target, nil)
+
+	ownership, err := svc.GetBucketOwnershipControls(ctx, &s3.GetBucketOwnershipControlsInput{Bucket: &opts.DstBucket})
+	switch {
+	case apiErrorCode(err) == "OwnershipControlsNotFoundError":
+		r.note("ownership", target, "%s, the archive is owned by the account that writes it and the bucket owner gets full control with an ACL", types.ObjectOwnershipObjectWriter)
+	case err != nil:
+		r.warn("ownership", target, "unable to read the Object Ownership setting: %s", classifyError(err))
+	case ownership.OwnershipControls != nil && len(ownership.OwnershipControls.Rules) > 0:
+		setting := ownership.OwnershipControls.Rules[0].ObjectOwnership
+		if setting == types.ObjectOwnershipBucketOwnerEnforced {
+			// as the run, which writes without an ACL
+			opts.provider.NoACLs = true
+			r.note("ownership", target, "%s, ACLs are disabled, the archive is written without an ACL and owned by the bucket owner", setting)
+		} else {
+			r.note("ownership", target, "%s, the bucket owner gets full control of the archive with an ACL", setting)
+		}
+	}
+
+	lock, err := svc.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{Bucket: &opts.DstBucket})
+	switch {
+	case apiErrorCode(err) == "ObjectLockConfigurationNotFoundError":
+		r.note("object lock", target, "disabled")
+	case err != nil:
+		r.warn("object lock", target, "unable to read the Object Lock configuration: %s", classifyError(err))
+	case lock.ObjectLockConfiguration != nil && lock.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled:
+		if opts.provider.NoChecksums {
+			r.problem("object lock", target, fmt.Errorf("%w: the bucket has Object Lock, which needs a checksum on every write, and the provider %s writes without", ErrInvalidArgument, opts.provider.Name))
+			return
+		}
+		rule := lock.ObjectLockConfiguration.Rule
+		if rule == nil || rule.DefaultRetention == nil {
+			r.note("object lock", target, "enabled, without a default retention")
+			return
+		}
+		scratch, _ := scratchLocation(opts)
+		if scratch == opts.DstBucket && !opts.ConcatInMemory {
+			r.warn("object lock", target, "the default retention (%s) keeps the intermediate objects of the run until it expires, write them to another bucket with --scratch", describeRetention(rule.DefaultRetention))
+			return
+		}
+		r.note("object lock", target, "the archive gets the default retention (%s)", describeRetention(rule.DefaultRetention))
+	default:
+		r.note("object lock", target, "disabled")
+	}
+}
+
+func describeRetention(d *types.DefaultRetention) string {
+	period := fmt.Sprintf("%d days", aws.ToInt32(d.Days))
+	if d.Years != nil {
+		period = fmt.Sprintf("%d years", aws.ToInt32(d.Years))
+	}
+	return fmt.Sprintf("%s, %s", d.Mode, period)
+}
+
+// apiErrorCode returns the error code of the service in err, empty when it
+// has none.
+func apiErrorCode(err error) string {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode()
+	}
+	return ""
+}
+
+// probeWrites writes and deletes an intermediate object, and creates the
+// multipart upload of the archive with the encryption, tags and ACL of the
+// run, uploads a part to it, copies a byte of first to it when the run
+// copies, and aborts it.
+func probeWrites(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, first *S3Obj, r *ProbeReport) {
+	tags := TagsToUrlEncodedString(archiveTags(opts))
+
+	bucket, _ := scratchLocation(opts)
+	key := scratchKey(opts, "probe")
+	target := objectURL(bucket, key)
+	_, err := svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               &bucket,
+		Key:                  &key,
+		Body:                 bytes.NewReader([]byte("s3tar")),
+		ChecksumAlgorithm:    opts.provider.checksumAlgorithm(),
+		Tagging:              &tags,
+		ACL:                  opts.provider.acl(),
+		SSEKMSKeyId:          &opts.KMSKeyID,
+		ServerSideEncryption: opts.SSEAlgo,
+		Metadata:             runMetadata(ctx),
+	})
+	switch {
+	case err != nil:
+		r.problem("put", target, err)
+	case opts.KMSKeyID != "":
+		r.note("put", target, "encrypted with %s and the key %s", opts.SSEAlgo, opts.KMSKeyID)
+	default:
+		r.add("put", target, nil)
+	}
+	if err == nil {
+		_, err = svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
+		r.add("delete", target, err)
+	}
+
+	target = objectURL(opts.DstBucket, opts.DstKey)
+	mpu, err := svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               &opts.DstBucket,
+		Key:                  &opts.DstKey,
+		StorageClass:         opts.storageClass,
+		ChecksumAlgorithm:    opts.provider.checksumAlgorithm(),
+		Tagging:              &tags,
+		ACL:                  opts.provider.acl(),
+		SSEKMSKeyId:          &opts.KMSKeyID,
+		ServerSideEncryption: opts.SSEAlgo,
+		Metadata:             archiveMetadata(opts),
+	}, accelerate(opts)...)
+	if err != nil {
+		r.problem("multipart upload", target, err)
+		return
+	}
+	r.add("multipart upload", target, nil)
+	_, err = svc.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:            &opts.DstBucket,
+		Key:               &opts.DstKey,
+		UploadId:          mpu.UploadId,
+		PartNumber:        aws.Int32(1),
+		Body:              bytes.NewReader([]byte("s3tar")),
+		ChecksumAlgorithm: opts.provider.checksumAlgorithm(),
+	}, accelerate(opts)...)
+	r.add("upload part", target, err)
+	if first != nil && !opts.ConcatInMemory && aws.ToInt64(first.Size) > 0 {
+		_, err = svc.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &opts.DstBucket,
+			Key:             &opts.DstKey,
+			UploadId:        mpu.UploadId,
+			PartNumber:      aws.Int32(2),
+			CopySource:      copySource(first),
+			CopySourceRange: aws.String("bytes=0-0"),
+		})
+		r.add("upload part copy", objectURL(first.Bucket, *first.Key), err)
+	}
+	_, err = svc.AbortMultipartUpload(detachedContext{ctx}, &s3.AbortMultipartUploadInput{Bucket: &opts.DstBucket, Key: &opts.DstKey, UploadId: mpu.UploadId})
+	r.add("abort multipart upload", target, err)
+}
@@ -0,0 +1,152 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeOperation names the operation of a request to a bucket.
+func fakeOperation(req *http.Request) string {
+	q := req.URL.Query()
+	bucketOnly := strings.Count(strings.Trim(req.URL.Path, "/"), "/") == 0
+	switch {
+	case req.Method == http.MethodGet && q.Has("list-type"):
+		return "ListObjectsV2"
+	case req.Method == http.MethodGet && q.Has("ownershipControls"):
+		return "GetBucketOwnershipControls"
+	case req.Method == http.MethodGet && q.Has("object-lock"):
+		return "GetObjectLockConfiguration"
+	case req.Method == http.MethodGet && q.Has("encryption"):
+		return "GetBucketEncryption"
+	case req.Method == http.MethodGet && q.Has("requestPayment"):
+		return "GetBucketRequestPayment"
+	case req.Method == http.MethodGet:
+		return "GetObject"
+	case req.Method == http.MethodHead && bucketOnly:
+		return "HeadBucket"
+	case req.Method == http.MethodPost && q.Has("uploads"):
+		return "CreateMultipartUpload"
+	case req.Method == http.MethodPut && q.Has("uploadId") && req.Header.Get("X-Amz-Copy-Source") != "":
+		return "UploadPartCopy"
+	case req.Method == http.MethodPut && q.Has("uploadId"):
+		return "UploadPart"
+	case req.Method == http.MethodPut:
+		return "PutObject"
+	case req.Method == http.MethodDelete && q.Has("uploadId"):
+		return "AbortMultipartUpload"
+	case req.Method == http.MethodDelete:
+		return "DeleteObject"
+	}
+	return req.Method
+}
+
+type probeResponse struct {
+	status int
+	body   string
+}
+
+// probeBodies are the answers of a bucket without Object Lock where
+// everything is allowed.
+var probeBodies = map[string]string{
+	"ListObjectsV2":              `<ListBucketResult><Name>src</Name><KeyCount>1</KeyCount><Contents><Key>a.txt</Key><Size>10</Size><ETag>"abc"</ETag></Contents></ListBucketResult>`,
+	"GetObject":                  "x",
+	"GetBucketOwnershipControls": `<OwnershipControls><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>`,
+	"CreateMultipartUpload":      `<InitiateMultipartUploadResult><Bucket>dst</Bucket><Key>a.tar</Key><UploadId>u</UploadId></InitiateMultipartUploadResult>`,
+	"UploadPartCopy":             `<CopyPartResult><ETag>"def"</ETag></CopyPartResult>`,
+}
+
+func TestProbe(t *testing.T) {
+	tests := map[string]struct {
+		// responses replaces the status and body of operations
+		responses map[string]probeResponse
+		opts      S3TarS3Options
+		want      map[string]string
+		wantErr   error
+	}{
+		"allowed": {
+			want: map[string]string{"list": ProbeOK, "read": ProbeOK, "bucket": ProbeOK, "ownership": ProbeOK, "object lock": ProbeOK, "put": ProbeOK,
+				"delete": ProbeOK, "multipart upload": ProbeOK, "upload part": ProbeOK, "upload part copy": ProbeOK, "abort multipart upload": ProbeOK},
+		},
+		"in memory": {
+			opts: S3TarS3Options{ConcatInMemory: true},
+			want: map[string]string{"list": ProbeOK, "read": ProbeOK, "bucket": ProbeOK, "ownership": ProbeOK, "object lock": ProbeOK, "put": ProbeOK,
+				"delete": ProbeOK, "multipart upload": ProbeOK, "upload part": ProbeOK, "abort multipart upload": ProbeOK},
+		},
+		"denied writes": {
+			responses: map[string]probeResponse{
+				"PutObject":             {403, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`},
+				"CreateMultipartUpload": {403, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`},
+			},
+			want:    map[string]string{"list": ProbeOK, "read": ProbeOK, "bucket": ProbeOK, "ownership": ProbeOK, "object lock": ProbeOK, "put": ProbeProblem, "multipart upload": ProbeProblem},
+			wantErr: ErrAccessDenied,
+		},
+		"empty source": {
+			responses: map[string]probeResponse{"ListObjectsV2": {200, `<ListBucketResult><Name>src</Name><KeyCount>0</KeyCount></ListBucketResult>`}},
+			want: map[string]string{"list": ProbeProblem, "bucket": ProbeOK, "ownership": ProbeOK, "object lock": ProbeOK, "put": ProbeOK,
+				"delete": ProbeOK, "multipart upload": ProbeOK, "upload part": ProbeOK, "abort multipart upload": ProbeOK},
+			wantErr: ErrNotFound,
+		},
+		"default retention": {
+			responses: map[string]probeResponse{
+				"GetObjectLockConfiguration": {200, `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>30</Days></DefaultRetention></Rule></ObjectLockConfiguration>`},
+				"GetBucketOwnershipControls": {403, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`},
+			},
+			want: map[string]string{"list": ProbeOK, "read": ProbeOK, "bucket": ProbeOK, "ownership": ProbeWarning, "object lock": ProbeWarning, "put": ProbeOK,
+				"delete": ProbeOK, "multipart upload": ProbeOK, "upload part": ProbeOK, "upload part copy": ProbeOK, "abort multipart upload": ProbeOK},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			do := doFunc(func(req *http.Request) (*http.Response, error) {
+				if req.Body != nil {
+					io.Copy(io.Discard, req.Body)
+				}
+				op := fakeOperation(req)
+				status, body := 200, probeBodies[op]
+				if op == "GetObjectLockConfiguration" {
+					status, body = 404, `<Error><Code>ObjectLockConfigurationNotFoundError</Code><Message>Object Lock configuration does not exist for this bucket</Message></Error>`
+				}
+				if r, ok := tt.responses[op]; ok {
+					status, body = r.status, r.body
+				}
+				header := http.Header{"Etag": {`"etag"`}}
+				return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(body)), ContentLength: int64(len(body)), Request: req}, nil
+			})
+			client := s3.New(s3.Options{
+				Region:       "us-west-2",
+				BaseEndpoint: aws.String("http://s3.local"),
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+				HTTPClient:   do,
+			})
+			opts := tt.opts
+			opts.SrcBucket, opts.DstBucket, opts.DstKey = "src", "dst", "a.tar"
+			report, err := Probe(context.Background(), client, &opts)
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Probe() error = %v, want %v", err, tt.wantErr)
+			}
+			got := map[string]string{}
+			for _, c := range report.Checks {
+				got[c.Check] = c.Status
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Probe() checks = %+v, want %v", report.Checks, tt.want)
+			}
+			for check, status := range tt.want {
+				if got[check] != status {
+					t.Errorf("check %s = %q, want %q", check, got[check], status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	contextKeyProgress = contextKey("progress")
+	progressInterval   = 10 * time.Second
+)
+
+// Progress is a snapshot of the work done so far by a run.
+type Progress struct {
+	BytesDownloaded int64
+	BytesUploaded   int64
+	BytesCopied     int64 // bytes copied server-side with UploadPartCopy
+	PartsCompleted  int64
+	PartsTotal      int64
+	Elapsed         time.Duration
+	Throughput      float64 // bytes per second across all workers
+	ETA             time.Duration
+}
+
+type progressTracker struct {
+	start      time.Time
+	downloaded int64
+	uploaded   int64
+	copied     int64
+	parts      int64
+	partsTotal int64
+}
+
+func (p *progressTracker) snapshot() Progress {
+	s := Progress{
+		BytesDownloaded: atomic.LoadInt64(&p.downloaded),
+		BytesUploaded:   atomic.LoadInt64(&p.uploaded),
+		BytesCopied:     atomic.LoadInt64(&p.copied),
+		PartsCompleted:  atomic.LoadInt64(&p.parts),
+		PartsTotal:      atomic.LoadInt64(&p.partsTotal),
+		Elapsed:         time.Since(p.start),
+	}
+	if secs := s.Elapsed.Seconds(); secs > 0 {
+		s.Throughput = float64(s.BytesDownloaded+s.BytesUploaded+s.BytesCopied) / secs
+	}
+	if s.PartsCompleted > 0 && s.PartsTotal > s.PartsCompleted {
+		remaining := s.PartsTotal - s.PartsCompleted
+		s.ETA = time.Duration(int64(s.Elapsed) / s.PartsCompleted * remaining)
+	}
+	return s
+}
+
+// startProgress attaches a tracker to ctx and logs the aggregated progress every
+// progressInterval, calling fn with every snapshot when it is not nil.
+// The returned function stops the reporting and emits a last snapshot.
+func startProgress(ctx context.Context, fn func(Progress)) (context.Context, func()) {
+	p := &progressTracker{start: time.Now()}
+	ctx = context.WithValue(ctx, contextKeyProgress, p)
+	report := func() {
+		s := p.snapshot()
+		Infof(ctx, "progress: %d/%d parts, %s downloaded, %s uploaded, %s copied, %s/s, eta %s",
+			s.PartsCompleted, s.PartsTotal, formatBytes(s.BytesDownloaded), formatBytes(s.BytesUploaded),
+			formatBytes(s.BytesCopied), formatBytes(int64(s.Throughput)), s.ETA.Round(time.Second))
+		if fn != nil {
+			fn(s)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		report()
+	}
+}
+
+func getProgress(ctx context.Context) *progressTracker {
+	if p, ok := ctx.Value(contextKeyProgress).(*progressTracker); ok {
+		return p
+	}
+	return nil
+}
+
+func trackDownloaded(ctx context.Context, n int64) {
+	if p := getProgress(ctx); p != nil {
+		atomic.AddInt64(&p.downloaded, n)
+	}
+}
+
+func trackUploaded(ctx context.Context, n int64) {
+	if p := getProgress(ctx); p != nil {
+		atomic.AddInt64(&p.uploaded, n)
+	}
+}
+
+func trackCopied(ctx context.Context, n int64) {
+	if p := getProgress(ctx); p != nil {
+		atomic.AddInt64(&p.copied, n)
+	}
+}
+
+func trackParts(ctx context.Context, n int) {
+	if p := getProgress(ctx); p != nil {
+		atomic.AddInt64(&p.partsTotal, int64(n))
+	}
+}
+
+func trackPartDone(ctx context.Context) {
+	if p := getProgress(ctx); p != nil {
+		atomic.AddInt64(&p.parts, 1)
+	}
+}
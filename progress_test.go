@@ -0,0 +1,85 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProgressTracker_snapshot(t *testing.T) {
+	tests := map[string]struct {
+		tracker        progressTracker
+		wantThroughput float64
+		wantETA        time.Duration
+	}{
+		"nothing done": {
+			tracker: progressTracker{partsTotal: 4},
+		},
+		"half the parts": {
+			tracker:        progressTracker{downloaded: 10 << 20, uploaded: 8 << 20, copied: 2 << 20, parts: 2, partsTotal: 4},
+			wantThroughput: 2 << 20,
+			wantETA:        10 * time.Second,
+		},
+		"all the parts": {
+			tracker:        progressTracker{uploaded: 20 << 20, parts: 4, partsTotal: 4},
+			wantThroughput: 2 << 20,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := tt.tracker
+			p.start = time.Now().Add(-10 * time.Second)
+			s := p.snapshot()
+			if s.BytesDownloaded != p.downloaded || s.BytesUploaded != p.uploaded || s.BytesCopied != p.copied || s.PartsCompleted != p.parts || s.PartsTotal != p.partsTotal {
+				t.Errorf("snapshot() = %+v, want the counters of %+v", s, p)
+			}
+			// the snapshot is taken a little after 10s
+			if s.Throughput > tt.wantThroughput || s.Throughput < tt.wantThroughput*0.9 {
+				t.Errorf("snapshot() Throughput = %f, want %f", s.Throughput, tt.wantThroughput)
+			}
+			if s.ETA < tt.wantETA || s.ETA > tt.wantETA+time.Second {
+				t.Errorf("snapshot() ETA = %s, want %s", s.ETA, tt.wantETA)
+			}
+		})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	const mb = 1 << 20
+	ctx := SetupLogger(context.Background())
+	store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	var objectList []*S3Obj
+	for _, o := range []struct {
+		key  string
+		size int
+	}{{"a.bin", 6 * mb}, {"b.bin", 3 * mb}, {"c.bin", 4 * mb}} {
+		store.objects["src/"+o.key] = bytes.Repeat([]byte(o.key[:1]), o.size)
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src", o.key), WithSize(int64(o.size))))
+	}
+	var snapshots []Progress
+	opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 1,
+		ProgressFn: func(p Progress) { snapshots = append(snapshots, p) }}
+	if err := createFromList(ctx, store.client(), objectList, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) == 0 {
+		t.Fatal("ProgressFn wasn't called when the run ended")
+	}
+	last := snapshots[len(snapshots)-1]
+	if last.PartsTotal != 2 || last.PartsCompleted != last.PartsTotal {
+		t.Errorf("progress = %d/%d parts, want 2/2", last.PartsCompleted, last.PartsTotal)
+	}
+	if last.BytesDownloaded != 13*mb {
+		t.Errorf("progress BytesDownloaded = %d, want %d", last.BytesDownloaded, 13*mb)
+	}
+	if want := int64(len(store.objects["dst/a.tar"])); last.BytesUploaded != want {
+		t.Errorf("progress BytesUploaded = %d, want the %d bytes of the archive", last.BytesUploaded, want)
+	}
+	if last.ETA != 0 {
+		t.Errorf("progress ETA = %s, want 0 when the run is done", last.ETA)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// The types of the provenance written by --provenance, an in-toto statement
+// with a SLSA provenance predicate.
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	provenanceBuildType = "https://github.com/awslabs/amazon-s3-tar-tool/create/v1"
+	provenanceBuilderID = "https://github.com/awslabs/amazon-s3-tar-tool"
+)
+
+// ProvenanceStatement is the in-toto statement of the provenance of an
+// archive, written next to it to <archive>.provenance.json.
+type ProvenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []ProvenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     ProvenancePredicate `json:"predicate"`
+}
+
+// ProvenanceSubject is an artifact the statement is about, the archive and
+// its TOC, with its digests by algorithm. The digest of the archive is its
+// ETag, as s3etag, and its additional checksum when it has one.
+type ProvenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// ProvenancePredicate is the SLSA provenance of the archive: how it was
+// created and from what, and the run that created it.
+type ProvenancePredicate struct {
+	BuildDefinition struct {
+		BuildType string `json:"buildType"`
+		// ExternalParameters are the sources, the destination and the options
+		// of the run that shape the archive.
+		ExternalParameters map[string]any `json:"externalParameters"`
+		// InternalParameters has the identity the run used.
+		InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+		ResolvedDependencies []ProvenanceResource `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string    `json:"invocationId"`
+			StartedOn    time.Time `json:"startedOn"`
+			FinishedOn   time.Time `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// ProvenanceResource is an input of the run, the objects archived from a
+// source, with the manifest hash of the objects as digest.
+type ProvenanceResource struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// provenanceLocation is where the provenance of an archive is written: next
+// to the archive.
+func provenanceLocation(bucket, key string) string {
+	return fmt.Sprintf("s3://%s/%s.provenance.json", bucket, key)
+}
+
+// provenanceParameters are the externalParameters of the provenance of the
+// archive of opts.
+func provenanceParameters(opts *S3TarS3Options) map[string]any {
+	params := map[string]any{
+		"destination":             archiveURL(opts),
+		"format":                  opts.tarFormat.String(),
+		"concat_in_memory":        opts.ConcatInMemory,
+		"server_side_only":        opts.ServerSideOnly,
+		"preserve_posix_metadata": opts.PreservePOSIXMetadata,
+		"record_origin":           opts.RecordOrigin,
+		"toc_checksums":           opts.TocChecksums,
+		"toc_extended":            opts.TocExtended,
+		"storage_class":           string(opts.storageClass),
+		"on_error":                string(opts.OnError),
+	}
+	if opts.KMSKeyID != "" {
+		params["sse"] = string(opts.SSEAlgo)
+		params["sse_kms_key_id"] = opts.KMSKeyID
+	}
+	if tags := TagsToUrlEncodedString(opts.ObjectTags); tags != "" {
+		params["tags"] = tags
+	}
+	if opts.Provider != "" {
+		params["provider"] = opts.Provider
+	}
+	if opts.chain != nil {
+		params["chain_volume"] = opts.chain.volume
+		params["chain_manifest"] = opts.chain.manifest
+	}
+	return params
+}
+
+// provenanceSources returns the sources of the run, as urls.
+func provenanceSources(opts *S3TarS3Options) []string {
+	var sources []string
+	switch {
+	case opts.SrcManifest != "":
+		sources = append(sources, opts.SrcManifest)
+	case opts.SrcPath != "":
+		sources = append(sources, objectURL("", localKey(opts.SrcPath)))
+	case len(opts.Sources) > 0:
+		for _, s := range opts.Sources {
+			sources = append(sources, objectURL(s.Bucket, s.Prefix))
+		}
+	case opts.SrcBucket != "":
+		sources = append(sources, objectURL(opts.SrcBucket, opts.SrcPrefix))
+	}
+	return sources
+}
+
+// newProvenance returns the provenance of the archive described by att,
+// created by the run of opts between started and finished with identity.
+func newProvenance(opts *S3TarS3Options, att *TocAttestation, checksum, identity string, started, finished time.Time) *ProvenanceStatement {
+	archive := ProvenanceSubject{Name: att.Archive, Digest: map[string]string{"s3etag": att.ETag}}
+	if checksum != "" {
+		archive.Digest["s3checksum"] = checksum
+	}
+	statement := &ProvenanceStatement{
+		Type: inTotoStatementType,
+		Subject: []ProvenanceSubject{
+			archive,
+			{Name: att.Archive + "#" + att.Toc, Digest: map[string]string{"sha256": att.TocSHA256}},
+		},
+		PredicateType: slsaProvenanceType,
+	}
+	if att.Toc != "toc.csv" {
+		// the TOC next to the archive
+		statement.Subject[1].Name = att.Toc
+	}
+	p := &statement.Predicate
+	p.BuildDefinition.BuildType = provenanceBuildType
+	p.BuildDefinition.ExternalParameters = provenanceParameters(opts)
+	if identity != "" {
+		p.BuildDefinition.InternalParameters = map[string]any{"identity": identity}
+	}
+	p.BuildDefinition.ResolvedDependencies = []ProvenanceResource{}
+	for _, source := range provenanceSources(opts) {
+		r := ProvenanceResource{URI: source}
+		if opts.manifestHash != "" {
+			r.Digest = map[string]string{"s3tarManifestSha256": opts.manifestHash}
+		}
+		p.BuildDefinition.ResolvedDependencies = append(p.BuildDefinition.ResolvedDependencies, r)
+	}
+	p.RunDetails.Builder.ID = provenanceBuilderID
+	if opts.ToolVersion != "" {
+		p.RunDetails.Builder.Version = map[string]string{"s3tar": opts.ToolVersion}
+	}
+	p.RunDetails.Metadata.InvocationID = opts.RunID
+	p.RunDetails.Metadata.StartedOn = started.UTC()
+	p.RunDetails.Metadata.FinishedOn = finished.UTC()
+	return statement
+}
+
+// callerIdentity returns the ARN of the identity of the credentials of svc,
+// empty when it can't be read.
+func callerIdentity(ctx context.Context, svc *s3.Client) string {
+	o := svc.Options()
+	client := sts.New(sts.Options{Region: o.Region, Credentials: o.Credentials, HTTPClient: o.HTTPClient, Logger: o.Logger})
+	out, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		Warnf(ctx, "the provenance has no identity, unable to read it: %s", err)
+		return ""
+	}
+	return aws.ToString(out.Arn)
+}
+
+// archiveChecksum returns the additional checksum of the archive
+// s3://bucket/key, as in the TOC, empty when it has none.
+func archiveChecksum(ctx context.Context, svc *s3.Client, bucket, key string) string {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), ChecksumMode: types.ChecksumModeEnabled})
+	if err != nil {
+		return ""
+	}
+	return formatChecksum(head.ChecksumCRC32, head.ChecksumCRC32C, head.ChecksumSHA1, head.ChecksumSHA256)
+}
+
+// writeProvenance writes the provenance of the archive of opts, created by a
+// run started at started, next to the archive.
+func writeProvenance(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, started time.Time) error {
+	att, err := attestArchive(ctx, svc, opts.DstBucket, opts.DstKey)
+	if err != nil {
+		return fmt.Errorf("reading the archive for its provenance: %w", err)
+	}
+	statement := newProvenance(opts, att, archiveChecksum(ctx, svc, opts.DstBucket, opts.DstKey), callerIdentity(ctx, svc), started, time.Now())
+	data, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return err
+	}
+	location := provenanceLocation(opts.DstBucket, opts.DstKey)
+	if err := saveFile(ctx, svc, location, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing the provenance %s: %w", location, err)
+	}
+	Infof(ctx, "provenance written to %s", location)
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNewProvenance(t *testing.T) {
+	started := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		opts        *S3TarS3Options
+		att         *TocAttestation
+		checksum    string
+		wantSubject []string
+		wantDeps    []string
+	}{
+		"toc member": {
+			opts:        &S3TarS3Options{SrcBucket: "src", SrcPrefix: "data/", DstBucket: "dst", DstKey: "a.tar", RunID: "run", manifestHash: "ff"},
+			att:         &TocAttestation{Archive: "s3://dst/a.tar", ETag: "e-2", Toc: "toc.csv", TocSHA256: "00"},
+			wantSubject: []string{"s3://dst/a.tar", "s3://dst/a.tar#toc.csv"},
+			wantDeps:    []string{"s3://src/data/"},
+		},
+		"external toc": {
+			opts:        &S3TarS3Options{Sources: []Source{{Bucket: "b1"}, {Bucket: "b2", Prefix: "p/"}}, DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true},
+			att:         &TocAttestation{Archive: "s3://dst/a.tar", ETag: "e-2", Toc: "s3://dst/a.tar.toc.csv", TocSHA256: "00"},
+			checksum:    "crc32c:AAAAAA==-2",
+			wantSubject: []string{"s3://dst/a.tar", "s3://dst/a.tar.toc.csv"},
+			wantDeps:    []string{"s3://b1/", "s3://b2/p/"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newProvenance(tt.opts, tt.att, tt.checksum, "arn:aws:iam::1:user/u", started, started.Add(time.Minute))
+			if p.Type != inTotoStatementType || p.PredicateType != slsaProvenanceType {
+				t.Errorf("newProvenance() types = %s %s", p.Type, p.PredicateType)
+			}
+			if len(p.Subject) != len(tt.wantSubject) {
+				t.Fatalf("newProvenance() subjects = %+v, want %v", p.Subject, tt.wantSubject)
+			}
+			for i, s := range p.Subject {
+				if s.Name != tt.wantSubject[i] {
+					t.Errorf("subject %d = %s, want %s", i, s.Name, tt.wantSubject[i])
+				}
+			}
+			if p.Subject[0].Digest["s3etag"] != tt.att.ETag || p.Subject[0].Digest["s3checksum"] != tt.checksum {
+				t.Errorf("archive digest = %v, want the ETag and %q", p.Subject[0].Digest, tt.checksum)
+			}
+			deps := p.Predicate.BuildDefinition.ResolvedDependencies
+			if len(deps) != len(tt.wantDeps) {
+				t.Fatalf("resolvedDependencies = %+v, want %v", deps, tt.wantDeps)
+			}
+			for i, d := range deps {
+				if d.URI != tt.wantDeps[i] || d.Digest["s3tarManifestSha256"] != tt.opts.manifestHash {
+					t.Errorf("dependency %d = %+v, want %s with the manifest hash", i, d, tt.wantDeps[i])
+				}
+			}
+			if _, err := json.Marshal(p); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const contextKeyProvider = contextKey("provider")
+
+// Provider describes how an S3-compatible service differs from Amazon S3. The
+// zero value is Amazon S3.
+type Provider struct {
+	Name string
+	// PathStyle addresses the bucket in the path of the endpoint,
+	// https://endpoint/bucket/key, instead of its host name.
+	PathStyle bool
+	// NoChecksums writes without the additional SHA-256 checksums, which the
+	// SDK sends in a header or, over HTTPS, as an aws-chunked trailer, and
+	// reads without asking for them.
+	NoChecksums bool
+	// NoACLs writes without the canned ACL bucket-owner-full-control, to a
+	// bucket whose Object Ownership disables ACLs.
+	NoACLs bool
+	// MaxParts, MaxPartSize and MaxObjectSize are the multipart upload limits
+	// of the service, those of Amazon S3 when zero.
+	MaxParts      int
+	MaxPartSize   int64
+	MaxObjectSize int64
+}
+
+// providers are the profiles of --provider, with the multipart upload limits
+// each service documents.
+var providers = map[string]Provider{
+	"aws": {Name: "aws", MaxParts: maxPartNumLimit, MaxPartSize: partSizeMax, MaxObjectSize: fileSizeMax},
+	"minio": {Name: "minio", PathStyle: true,
+		MaxParts: 10000, MaxPartSize: 5 * 1024 * 1024 * 1024, MaxObjectSize: 50 * 1024 * 1024 * 1024 * 1024},
+	// the defaults of the RADOS Gateway, rgw_multipart_part_upload_limit and
+	// rgw_max_put_size
+	"ceph": {Name: "ceph", PathStyle: true, NoChecksums: true,
+		MaxParts: 10000, MaxPartSize: 5 * 1024 * 1024 * 1024, MaxObjectSize: 5 * 1024 * 1024 * 1024 * 1024},
+	"wasabi": {Name: "wasabi", NoChecksums: true,
+		MaxParts: 10000, MaxPartSize: 5 * 1024 * 1024 * 1024, MaxObjectSize: 5 * 1024 * 1024 * 1024 * 1024},
+}
+
+// ProviderProfile returns the profile of a provider by name, "" being Amazon
+// S3.
+func ProviderProfile(name string) (Provider, error) {
+	if name == "" {
+		return providers["aws"], nil
+	}
+	p, ok := providers[strings.ToLower(name)]
+	if !ok {
+		return Provider{}, fmt.Errorf("%w: unknown provider %q, one of %s", ErrInvalidArgument, name, strings.Join(ProviderNames(), ", "))
+	}
+	return p, nil
+}
+
+// ProviderNames returns the names of the provider profiles.
+func ProviderNames() []string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClientOptions sets the addressing of p on the options of an s3.Client.
+func (p Provider) ClientOptions(o *s3.Options) {
+	o.UsePathStyle = p.PathStyle
+}
+
+func (p Provider) maxParts() int {
+	if p.MaxParts > 0 {
+		return p.MaxParts
+	}
+	return maxPartNumLimit
+}
+
+func (p Provider) maxPartSize() int64 {
+	if p.MaxPartSize > 0 {
+		return p.MaxPartSize
+	}
+	return partSizeMax
+}
+
+func (p Provider) maxObjectSize() int64 {
+	if p.MaxObjectSize > 0 {
+		return p.MaxObjectSize
+	}
+	return fileSizeMax
+}
+
+// checksumAlgorithm is the additional checksum of the archive written to p.
+func (p Provider) checksumAlgorithm() types.ChecksumAlgorithm {
+	if p.NoChecksums {
+		return ""
+	}
+	return types.ChecksumAlgorithmSha256
+}
+
+// acl is the canned ACL of the objects written to p.
+func (p Provider) acl() types.ObjectCannedACL {
+	if p.NoACLs {
+		return ""
+	}
+	return types.ObjectCannedACLBucketOwnerFullControl
+}
+
+func validateProvider(opts *S3TarS3Options) error {
+	p, err := ProviderProfile(opts.Provider)
+	if err != nil {
+		return err
+	}
+	if p.NoChecksums && opts.TocChecksums {
+		return fmt.Errorf("%w: --toc-checksums needs a provider with additional checksums, %s has none", ErrInvalidArgument, p.Name)
+	}
+	opts.provider = p
+	return nil
+}
+
+// withProvider returns a context that carries the provider of opts to the
+// downloads of the run.
+func withProvider(ctx context.Context, opts *S3TarS3Options) context.Context {
+	return context.WithValue(ctx, contextKeyProvider, opts.provider)
+}
+
+func providerOf(ctx context.Context) Provider {
+	p, _ := ctx.Value(contextKeyProvider).(Provider)
+	return p
+}
+
+// probeProvider checks the destination bucket of a provider other than Amazon
+// S3 before anything is written: that it's reachable with the addressing of
+// the profile and, unless the profile has none, that it accepts additional
+// checksums, which are turned off with a warning when it doesn't. The probe
+// object is written under the intermediate objects of the run and deleted.
+func probeProvider(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	p := &opts.provider
+	if opts.Provider == "" || p.Name == "aws" || opts.DstPath != "" {
+		return nil
+	}
+	if _, err := svc.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &opts.DstBucket}); err != nil {
+		addressing := "virtual-hosted"
+		if p.PathStyle {
+			addressing = "path-style"
+		}
+		return fmt.Errorf("provider %s: s3://%s isn't reachable with %s addressing: %w", p.Name, opts.DstBucket, addressing, classifyError(err))
+	}
+	if p.NoChecksums {
+		return nil
+	}
+	bucket, _ := scratchLocation(opts)
+	key := scratchKey(opts, "provider-probe")
+	_, err := svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:            &bucket,
+		Key:               &key,
+		Body:              bytes.NewReader([]byte("s3tar")),
+		ChecksumAlgorithm: p.checksumAlgorithm(),
+	})
+	var ae smithy.APIError
+	if errors.As(err, &ae) && !errors.Is(classifyError(err), ErrAccessDenied) {
+		Warnf(ctx, "provider %s: s3://%s rejects additional checksums (%s), writing without them", p.Name, bucket, ae.ErrorCode())
+		p.NoChecksums = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("provider %s: writing s3://%s/%s: %w", p.Name, bucket, key, classifyError(err))
+	}
+	if _, err := svc.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: aws.String(key)}); err != nil {
+		Warnf(ctx, "unable to delete s3://%s/%s: %s", bucket, key, err.Error())
+	}
+	return nil
+}
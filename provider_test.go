@@ -0,0 +1,142 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestProviderProfile(t *testing.T) {
+	for _, name := range []string{"", "aws", "MinIO", "ceph", "wasabi"} {
+		if _, err := ProviderProfile(name); err != nil {
+			t.Errorf("ProviderProfile(%q) error = %v", name, err)
+		}
+	}
+	if _, err := ProviderProfile("gcs"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("ProviderProfile(gcs) error = %v, want ErrInvalidArgument", err)
+	}
+
+	minio, _ := ProviderProfile("minio")
+	o := &s3.Options{}
+	minio.ClientOptions(o)
+	if !o.UsePathStyle {
+		t.Errorf("minio doesn't use path-style addressing")
+	}
+	if minio.checksumAlgorithm() == "" || minio.maxParts() != maxPartNumLimit || minio.maxObjectSize() <= fileSizeMax {
+		t.Errorf("minio = %+v, want checksums, 10000 parts and objects over 5TiB", minio)
+	}
+	ceph, _ := ProviderProfile("ceph")
+	if ceph.checksumAlgorithm() != "" {
+		t.Errorf("ceph checksum = %q, want none", ceph.checksumAlgorithm())
+	}
+
+	opts := &S3TarS3Options{Provider: "wasabi", TocChecksums: true}
+	if err := validateProvider(opts); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("validateProvider(wasabi, toc checksums) error = %v, want ErrInvalidArgument", err)
+	}
+	opts = &S3TarS3Options{Provider: "ceph"}
+	if err := validateProvider(opts); err != nil || opts.provider.Name != "ceph" {
+		t.Errorf("validateProvider(ceph) = %v, provider %q", err, opts.provider.Name)
+	}
+}
+
+func TestProviderLimits(t *testing.T) {
+	for _, name := range ProviderNames() {
+		p, _ := ProviderProfile(name)
+		if p.MaxParts == 0 || p.MaxPartSize == 0 || p.MaxObjectSize == 0 {
+			t.Errorf("%s = %+v, want its multipart upload limits", name, p)
+		}
+		if p.MaxPartSize < fileSizeMin || p.MaxObjectSize < p.MaxPartSize {
+			t.Errorf("%s parts are %d to %d bytes in objects of %d", name, fileSizeMin, p.MaxPartSize, p.MaxObjectSize)
+		}
+	}
+}
+
+func TestCreateGroupsProvider(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	var objectList []*S3Obj
+	for i := 0; i < 2000; i++ {
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("bucket", fmt.Sprintf("%04d", i)), WithSize(1<<30)))
+	}
+	tests := map[string]struct {
+		provider  Provider
+		maxGroups int
+	}{
+		"aws":        {provider: providers["aws"], maxGroups: maxPartNumLimit},
+		"1000 parts": {provider: Provider{MaxParts: 1000}, maxGroups: 1000},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			groups, _ := createGroups(ctx, objectList, &S3TarS3Options{provider: tt.provider})
+			if len(groups) > tt.maxGroups {
+				t.Errorf("createGroups() = %d groups, want at most %d", len(groups), tt.maxGroups)
+			}
+		})
+	}
+}
+
+func TestFindMinimumPartSizeProvider(t *testing.T) {
+	const size = 100 * 1024 * 1024 * 1024
+	if got, _ := findMinimumPartSize(size, 0, 0, Provider{}); got != 15*1024*1024 {
+		t.Errorf("findMinimumPartSize(aws) = %d, want 15MiB", got)
+	}
+	// a provider that allows 1000 parts needs parts 10 times larger
+	if got, _ := findMinimumPartSize(size, 0, 0, Provider{MaxParts: 1000}); got != 105*1024*1024 {
+		t.Errorf("findMinimumPartSize(1000 parts) = %d, want 105MiB", got)
+	}
+}
+
+func TestFindMinimumPartSize_UserSizes(t *testing.T) {
+	const size = 100 * 1024 * 1024 * 1024
+	tests := map[string]struct {
+		min, max int64
+		want     int64
+		wantErr  error
+	}{
+		"default":          {want: 15},
+		"few huge parts":   {min: 512, want: 512},
+		"within the range": {min: 5, max: 100, want: 15},
+		"max over needed":  {max: 5000, want: 15},
+		"max too small":    {max: 10, wantErr: ErrTooManyParts},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := findMinimumPartSize(size, tt.min, tt.max, Provider{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("findMinimumPartSize() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want*1024*1024 {
+				t.Errorf("findMinimumPartSize() = %d, want %dMiB", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePartSizes(t *testing.T) {
+	tests := map[string]struct {
+		min, max  int64
+		threshold int64
+		wantErr   error
+	}{
+		"unset":         {},
+		"threshold":     {threshold: 6 << 30, wantErr: ErrInvalidArgument},
+		"both":          {min: 64, max: 512},
+		"under 5MB":     {min: 4, wantErr: ErrInvalidArgument},
+		"over the part": {max: 6000, wantErr: ErrInvalidArgument},
+		"min over max":  {min: 512, max: 64, wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := &S3TarS3Options{UserMinPartSize: tt.min, UserMaxPartSize: tt.max, MPUThreshold: tt.threshold}
+			if err := validatePartSizes(opts); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validatePartSizes() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	contextKeyRanges = contextKey("ranges")
+	// defaultRangeConcurrency is the number of ranges of an object downloaded
+	// at once when S3TarS3Options.RangeConcurrency isn't set.
+	defaultRangeConcurrency = 4
+	// minLargeMemberRange is the smallest range a large member is split in,
+	// smaller ones cost more in requests than they save in time.
+	minLargeMemberRange = 8 * 1024 * 1024
+)
+
+// ranges is how objects over size are downloaded: concurrency GETs of size
+// bytes at a time. With largeMembers, the objects of at least large bytes,
+// half a part, are split in concurrency ranges whatever size is.
+type ranges struct {
+	size         int64
+	concurrency  int
+	largeMembers bool
+	large        int64
+}
+
+func validateRanges(opts *S3TarS3Options) error {
+	if opts.RangeSize < 0 || opts.RangeConcurrency < 0 {
+		return fmt.Errorf("%w: the range size and concurrency can't be negative", ErrInvalidArgument)
+	}
+	if (opts.RangeSize > 0 || opts.RangeBigMembers) && opts.RangeConcurrency == 0 {
+		opts.RangeConcurrency = defaultRangeConcurrency
+	}
+	return nil
+}
+
+// withRanges returns a context that carries the ranged downloads of opts to
+// the downloads of the run.
+func withRanges(ctx context.Context, opts *S3TarS3Options) context.Context {
+	return context.WithValue(ctx, contextKeyRanges, ranges{size: opts.RangeSize, concurrency: opts.RangeConcurrency, largeMembers: opts.RangeBigMembers})
+}
+
+// withPartSize returns ctx with the members of at least half a part of
+// partSize bytes downloaded in ranges, when its ranges split large members.
+func withPartSize(ctx context.Context, partSize int64) context.Context {
+	r, _ := ctx.Value(contextKeyRanges).(ranges)
+	if !r.largeMembers {
+		return ctx
+	}
+	r.large = partSize / 2
+	return context.WithValue(ctx, contextKeyRanges, r)
+}
+
+// rangesOf returns the ranged downloads of ctx that apply to o, objects of an
+// Object Lambda access point are transformed as a whole and never split.
+func rangesOf(ctx context.Context, o *S3Obj) (ranges, bool) {
+	r, _ := ctx.Value(contextKeyRanges).(ranges)
+	if o.Size == nil || isObjectLambda(o.Bucket) || o.isLocal() || o.isPresigned() {
+		return ranges{}, false
+	}
+	if r.size > 0 && *o.Size > r.size {
+		return r, true
+	}
+	if r.large > 0 && *o.Size >= r.large {
+		// a member that nearly fills its part would take the time of a single
+		// GET while the other parts are done
+		if r.concurrency <= 0 {
+			r.concurrency = defaultRangeConcurrency
+		}
+		size := rangeCount(*o.Size, int64(r.concurrency))
+		if size < minLargeMemberRange {
+			size = minLargeMemberRange
+		}
+		if *o.Size > size {
+			return ranges{size: size, concurrency: r.concurrency}, true
+		}
+	}
+	return ranges{}, false
+}
+
+// openObjectBody returns the output and the body of o, read from concurrent
+// ranged GETs when o is over the range size of ctx. The output describes the
+// whole object in both cases.
+func openObjectBody(ctx context.Context, client *s3.Client, o *S3Obj) (*s3.GetObjectOutput, io.ReadCloser, error) {
+	client = readClient(client, o)
+	if o.isLocal() {
+		return openLocalFile(o)
+	}
+	if o.isPresigned() {
+		output, resp, err := openPresigned(ctx, client, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		return output, resp.Body, nil
+	}
+	r, ok := rangesOf(ctx, o)
+	if !ok {
+		output, err := downloadS3Data(ctx, client, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		return output, output.Body, nil
+	}
+	return downloadRanges(ctx, client, o, r)
+}
+
+// downloadRanges downloads the first range of o and returns a body that reads
+// the others in order as they are downloaded, at most r.concurrency ranges
+// ahead of the reader. The other ranges are read with If-Match on the ETag of
+// the first, so the body can't mix two versions of the object.
+func downloadRanges(ctx context.Context, client *s3.Client, o *S3Obj, r ranges) (*s3.GetObjectOutput, io.ReadCloser, error) {
+	first, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o), IfMatch: ifMatch(o), Range: byteRange(0, r.size)})
+	if err != nil {
+		return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: classifyError(err)}
+	}
+	data, err := io.ReadAll(first.Body)
+	first.Body.Close()
+	if err != nil {
+		return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+	}
+	size, err := rangeTotal(first.ContentRange)
+	if err != nil {
+		return nil, nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+	}
+	output := *first
+	output.ContentLength, output.ContentRange, output.Body = aws.Int64(size), nil, nil
+	if err := checkUnchanged(o, &output); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	n := int(rangeCount(size, r.size))
+	rr := &rangedReader{ctx: ctx, cancel: cancel, chunks: make([]chan rangeChunk, n), sem: make(chan struct{}, r.concurrency), cur: data}
+	for i := range rr.chunks {
+		rr.chunks[i] = make(chan rangeChunk, 1)
+	}
+	go func() {
+		for i := 1; i < n; i++ {
+			select {
+			case rr.sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int) {
+				start := int64(i) * r.size
+				data, err := getRange(ctx, client, o, first.ETag, start, r.size)
+				rr.chunks[i] <- rangeChunk{data: data, err: err}
+			}(i)
+		}
+	}()
+	return &output, rr, nil
+}
+
+// getRange downloads length bytes of o from start, fewer for the last range.
+func getRange(ctx context.Context, client *s3.Client, o *S3Obj, etag *string, start, length int64) ([]byte, error) {
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o), IfMatch: etag, Range: byteRange(start, length)})
+	if err != nil {
+		return nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: classifyError(err)}
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: err}
+	}
+	return data, nil
+}
+
+// rangeCount is the number of ranges of rangeSize bytes an object of size
+// bytes is downloaded in.
+func rangeCount(size, rangeSize int64) int64 {
+	return (size + rangeSize - 1) / rangeSize
+}
+
+func byteRange(start, length int64) *string {
+	return aws.String(fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+}
+
+// rangeTotal returns the size of the object from the Content-Range of a
+// ranged GET, "bytes 0-99/1234".
+func rangeTotal(contentRange *string) (int64, error) {
+	if contentRange == nil {
+		return 0, fmt.Errorf("ranged GET without a Content-Range")
+	}
+	i := strings.LastIndex(*contentRange, "/")
+	if i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", *contentRange)
+	}
+	size, err := strconv.ParseInt((*contentRange)[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", *contentRange)
+	}
+	return size, nil
+}
+
+type rangeChunk struct {
+	data []byte
+	err  error
+}
+
+// rangedReader reads the ranges of an object in order. A range is released,
+// and another one downloaded, once the reader is done with it.
+type rangedReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	chunks []chan rangeChunk
+	sem    chan struct{}
+	next   int
+	cur    []byte
+	err    error
+}
+
+func (r *rangedReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.next++; r.next >= len(r.chunks) {
+			r.err = io.EOF
+			continue
+		}
+		select {
+		case c := <-r.chunks[r.next]:
+			<-r.sem
+			r.cur, r.err = c.data, c.err
+		case <-r.ctx.Done():
+			r.err = r.ctx.Err()
+		}
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// Close cancels the ranges still being downloaded.
+func (r *rangedReader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func newTestRangedReader(first string, chunks ...rangeChunk) *rangedReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &rangedReader{ctx: ctx, cancel: cancel, chunks: make([]chan rangeChunk, len(chunks)+1), sem: make(chan struct{}, len(chunks)), cur: []byte(first)}
+	r.chunks[0] = make(chan rangeChunk, 1)
+	for i, c := range chunks {
+		r.chunks[i+1] = make(chan rangeChunk, 1)
+		r.chunks[i+1] <- c
+		r.sem <- struct{}{}
+	}
+	return r
+}
+
+func TestRangedReader(t *testing.T) {
+	r := newTestRangedReader("abc", rangeChunk{data: []byte("def")}, rangeChunk{data: []byte("gh")})
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "abcdefgh" {
+		t.Errorf("ReadAll() = %q, %v, want abcdefgh", data, err)
+	}
+
+	failed := errors.New("connection reset")
+	r = newTestRangedReader("abc", rangeChunk{err: failed}, rangeChunk{data: []byte("gh")})
+	if data, err := io.ReadAll(r); !errors.Is(err, failed) || string(data) != "abc" {
+		t.Errorf("ReadAll() = %q, %v, want abc and the error of the range", data, err)
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, failed) {
+		t.Errorf("Read() after a failed range = %v, want the error of the range", err)
+	}
+
+	r = newTestRangedReader("abc", rangeChunk{data: []byte("def")})
+	r.Close()
+	r.chunks[1] = make(chan rangeChunk)
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadAll() after Close = %v, want context.Canceled", err)
+	}
+}
+
+func TestRangeTotal(t *testing.T) {
+	if size, err := rangeTotal(aws.String("bytes 0-8388607/1073741824")); err != nil || size != 1073741824 {
+		t.Errorf("rangeTotal() = %d, %v", size, err)
+	}
+	for _, s := range []*string{nil, aws.String("bytes 0-99"), aws.String("bytes 0-99/*")} {
+		if _, err := rangeTotal(s); err == nil {
+			t.Errorf("rangeTotal(%v) error = nil", s)
+		}
+	}
+	if got := rangeCount(10, 4); got != 3 {
+		t.Errorf("rangeCount(10, 4) = %d, want 3", got)
+	}
+}
+
+func TestRangesOf(t *testing.T) {
+	const partSize = 64 << 20
+	object := func(size int64) *S3Obj {
+		return NewS3ObjOptions(WithBucketAndKey("bucket", "a.bin"), WithSize(size))
+	}
+	tests := map[string]struct {
+		opts     S3TarS3Options
+		o        *S3Obj
+		wantSize int64
+	}{
+		"under the range size": {opts: S3TarS3Options{RangeSize: 8 << 20}, o: object(8 << 20)},
+		"over the range size":  {opts: S3TarS3Options{RangeSize: 8 << 20}, o: object(60 << 20), wantSize: 8 << 20},
+		"big member":           {opts: S3TarS3Options{RangeBigMembers: true, RangeConcurrency: 4}, o: object(60 << 20), wantSize: 15 << 20},
+		"small range":          {opts: S3TarS3Options{RangeBigMembers: true, RangeConcurrency: 16}, o: object(40 << 20), wantSize: minLargeMemberRange},
+		"under half a part":    {opts: S3TarS3Options{RangeBigMembers: true, RangeConcurrency: 4}, o: object(30 << 20)},
+		"no big members":       {opts: S3TarS3Options{RangeConcurrency: 4}, o: object(60 << 20)},
+		"range size first":     {opts: S3TarS3Options{RangeSize: 10 << 20, RangeBigMembers: true, RangeConcurrency: 4}, o: object(60 << 20), wantSize: 10 << 20},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := withPartSize(withRanges(context.Background(), &tt.opts), partSize)
+			r, ok := rangesOf(ctx, tt.o)
+			if ok != (tt.wantSize > 0) || r.size != tt.wantSize {
+				t.Errorf("rangesOf() = %+v, %t, want ranges of %d bytes", r, ok, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestEstimateArchive_Ranges(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	objectList := testObjects(3*fileSizeMin, 700)
+	e, err := EstimateArchive(ctx, objectList, &S3TarS3Options{ConcatInMemory: true, RangeSize: fileSizeMin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.RangedGets != 2 || e.Requests.Get != 4 {
+		t.Errorf("EstimateArchive() RangedGets = %d, Get = %d, want 2, 4", e.RangedGets, e.Requests.Get)
+	}
+}
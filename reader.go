@@ -0,0 +1,192 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// readAheadSize is the least a range request of an archiveReader reads, so
+// the headers of consecutive small members, or the first header and the
+// toc.csv that follows it, are read with a single request.
+const readAheadSize = 256 * 1024
+
+// archiveReader reads the headers and members of an archive in Amazon S3 at
+// their offsets with range requests, the one way list, extract, validate and
+// salvage read an archive, so none of them downloads more of it than it
+// needs. Reads start on a block boundary and read ahead at least
+// readAheadSize bytes, which are kept for the reads that follow.
+type archiveReader struct {
+	bucket string
+	key    string
+	// get reads the bytes from start to end, included.
+	get func(start, end int64) (io.ReadCloser, error)
+	// size is the size of the archive, -1 when it isn't known.
+	size    int64
+	modTime time.Time
+	// etag is the ETag of the archive HEAD returned, empty when it wasn't
+	// read with a HEAD.
+	etag      string
+	readAhead int64
+	// frames is the seek table of a seekable archive, whose offsets are in
+	// the decompressed tar: its members can't be copied server-side.
+	frames seekTable
+
+	mu         sync.Mutex
+	cache      []byte
+	cacheStart int64
+	requests   int
+}
+
+// newArchiveReader returns a reader of s3://bucket/key, of size bytes or -1
+// when the size isn't known.
+func newArchiveReader(ctx context.Context, client *s3.Client, bucket, key string, size int64) *archiveReader {
+	get := func(start, end int64) (io.ReadCloser, error) {
+		return getObjectRange(ctx, client, bucket, key, start, end)
+	}
+	return &archiveReader{bucket: bucket, key: key, get: get, size: size, readAhead: readAheadSize}
+}
+
+// headArchive returns a reader of s3://bucket/key with the size, the last
+// modified time and the ETag of its HEAD.
+func headArchive(ctx context.Context, client *s3.Client, bucket, key string) (*archiveReader, error) {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	r := newArchiveReader(ctx, client, bucket, key, *head.ContentLength)
+	r.modTime = aws.ToTime(head.LastModified)
+	r.etag = aws.ToString(head.ETag)
+	return r, nil
+}
+
+// readAt returns the n bytes at offset, fewer at the end of the archive.
+func (r *archiveReader) readAt(offset, n int64) ([]byte, error) {
+	if offset < 0 || n < 0 {
+		return nil, fmt.Errorf("invalid read of %d bytes at %d", n, offset)
+	}
+	if r.size >= 0 && offset+n > r.size {
+		n = r.size - offset
+	}
+	if n <= 0 {
+		return []byte{}, nil
+	}
+	r.mu.Lock()
+	if offset >= r.cacheStart && offset+n <= r.cacheStart+int64(len(r.cache)) {
+		data := r.cache[offset-r.cacheStart : offset-r.cacheStart+n]
+		r.mu.Unlock()
+		return append([]byte{}, data...), nil
+	}
+	r.mu.Unlock()
+
+	start := offset - offset%blockSize
+	end := offset + n
+	if end-start < r.readAhead {
+		end = start + r.readAhead
+	}
+	end += findPadding(end)
+	if r.size >= 0 && end > r.size {
+		end = r.size
+	}
+	body, err := r.get(start, end-1)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cache, r.cacheStart = data, start
+	r.requests++
+	r.mu.Unlock()
+	if offset-start >= int64(len(data)) {
+		return []byte{}, nil
+	}
+	data = data[offset-start:]
+	if int64(len(data)) > n {
+		data = data[:n]
+	}
+	return append([]byte{}, data...), nil
+}
+
+// ReadAt implements io.ReaderAt with readAt.
+func (r *archiveReader) ReadAt(p []byte, off int64) (int, error) {
+	data, err := r.readAt(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// header parses the header at offset, with its PAX or GNU extension headers,
+// and returns it with the offset of the data of the member.
+func (r *archiveReader) header(offset int64) (*tar.Header, int64, error) {
+	size := r.size
+	if size < 0 {
+		size = 1 << 62
+	}
+	cr := &countingReader{r: io.NewSectionReader(r, offset, size-offset)}
+	hdr, err := tar.NewReader(cr).Next()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, fmt.Errorf("%w: no tar header at offset %d of s3://%s/%s: %w", ErrInvalidArchive, offset, r.bucket, r.key, err)
+	}
+	return hdr, offset + cr.n, nil
+}
+
+// headerEnding parses the header of the member whose data starts at end.
+// The last block of a PAX header resembles a USTAR header, the PAX header
+// is tried first.
+func (r *archiveReader) headerEnding(end int64) (*tar.Header, int64, error) {
+	for _, size := range []int64{paxTarHeaderSize, gnuTarHeaderSize} {
+		if end < size {
+			continue
+		}
+		hdr, dataStart, err := r.header(end - size)
+		if err == nil && dataStart == end {
+			return hdr, size, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: unable to parse the header ending at %d of s3://%s/%s", ErrInvalidArchive, end, r.bucket, r.key)
+}
+
+// member returns the data of the member at start with size bytes.
+func (r *archiveReader) member(start, size int64) ([]byte, error) {
+	data, err := r.readAt(start, size)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, fmt.Errorf("%w: s3://%s/%s ends %d bytes into the member at %d of %d bytes", ErrInvalidArchive, r.bucket, r.key, len(data), start, size)
+	}
+	return data, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
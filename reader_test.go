@@ -0,0 +1,100 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// bytesArchive is an archiveReader of data that records the ranges it gets.
+func bytesArchive(data []byte, ranges *[][2]int64) *archiveReader {
+	get := func(start, end int64) (io.ReadCloser, error) {
+		*ranges = append(*ranges, [2]int64{start, end})
+		return io.NopCloser(bytes.NewReader(data[start : end+1])), nil
+	}
+	return &archiveReader{bucket: "b", key: "k", get: get, size: int64(len(data)), readAhead: 4 * blockSize}
+}
+
+func TestArchiveReaderReadAt(t *testing.T) {
+	data := make([]byte, 10*blockSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	tests := map[string]struct {
+		reads      [][2]int64
+		wantRanges [][2]int64
+	}{
+		"read ahead":        {reads: [][2]int64{{0, 10}, {600, 100}, {1500, 548}}, wantRanges: [][2]int64{{0, 2047}}},
+		"block aligned":     {reads: [][2]int64{{1000, 10}}, wantRanges: [][2]int64{{512, 2559}}},
+		"longer than ahead": {reads: [][2]int64{{0, 3000}}, wantRanges: [][2]int64{{0, 3071}}},
+		"end of archive":    {reads: [][2]int64{{5000, 1000}}, wantRanges: [][2]int64{{4608, 5219}}},
+		"past the cache":    {reads: [][2]int64{{0, 10}, {2048, 10}, {0, 10}}, wantRanges: [][2]int64{{0, 2047}, {2048, 4095}, {0, 2047}}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ranges [][2]int64
+			r := bytesArchive(data, &ranges)
+			for _, rd := range tt.reads {
+				got, err := r.readAt(rd[0], rd[1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				end := rd[0] + rd[1]
+				if end > int64(len(data)) {
+					end = int64(len(data))
+				}
+				if !bytes.Equal(got, data[rd[0]:end]) {
+					t.Errorf("readAt(%d, %d) returned the wrong bytes", rd[0], rd[1])
+				}
+			}
+			if len(ranges) != len(tt.wantRanges) {
+				t.Fatalf("readAt() requested %v, want %v", ranges, tt.wantRanges)
+			}
+			for i := range ranges {
+				if ranges[i] != tt.wantRanges[i] {
+					t.Errorf("readAt() requested %v, want %v", ranges, tt.wantRanges)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveReaderHeaders(t *testing.T) {
+	data := testArchive(t, nil, 100, 1024)
+	var ranges [][2]int64
+	r := bytesArchive(data, &ranges)
+	r.readAhead = readAheadSize
+
+	hdr, dataStart, err := r.header(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "toc.csv" || dataStart != blockSize {
+		t.Errorf("header(0) = %s at %d, want toc.csv at %d", hdr.Name, dataStart, blockSize)
+	}
+	file1 := int64(4*blockSize) + blockSize
+	hdr, size, err := r.headerEnding(file1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "file1.txt" || size != gnuTarHeaderSize {
+		t.Errorf("headerEnding(%d) = %s of %d bytes, want file1.txt of %d", file1, hdr.Name, size, gnuTarHeaderSize)
+	}
+	if body, err := r.member(file1, hdr.Size); err != nil || int64(len(body)) != hdr.Size {
+		t.Errorf("member() = %d bytes, %v, want %d", len(body), err, hdr.Size)
+	}
+	if len(ranges) != 1 {
+		t.Errorf("reading the headers and a member took %d requests, want 1", len(ranges))
+	}
+
+	if _, _, err := r.headerEnding(file1 + 100); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("headerEnding() in a member error = %v, want ErrInvalidArchive", err)
+	}
+	if _, err := r.member(file1, int64(len(data))); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("member() past the end error = %v, want ErrInvalidArchive", err)
+	}
+}
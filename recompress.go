@@ -0,0 +1,222 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultCompressionLevel is the zstd level of Recompress when none is set.
+const defaultCompressionLevel = 3
+
+// RecompressReport is the result of Recompress.
+type RecompressReport struct {
+	Archive        string `json:"archive"`
+	Destination    string `json:"destination"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressed_size"`
+	Frames         int    `json:"frames"`
+}
+
+// Recompress streams the uncompressed archive s3://SrcBucket/SrcKey of opts
+// through zstd to the seekable archive s3://DstBucket/DstKey, with a single
+// read of the archive and without writing it anywhere else. The frames are
+// compressed with opts.Threads workers at opts.CompressionLevel. The TOC of
+// the archive, next to it or its toc.csv member, is written next to the new
+// one with the offset of the frame of every member, a toc.csv member stays in
+// the first frame. The archive isn't changed or deleted.
+func Recompress(ctx context.Context, svc *s3.Client, options *S3TarS3Options, optFns ...func(*S3TarS3Options)) (*RecompressReport, error) {
+	opts := options.Copy()
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if err := checkRecompressArgs(&opts); err != nil {
+		return nil, err
+	}
+	src := sourceClient(svc, &opts)
+	archiveURL, dstURL := objectURL(opts.SrcBucket, opts.SrcKey), objectURL(opts.DstBucket, opts.DstKey)
+	head, err := src.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &opts.SrcBucket, Key: &opts.SrcKey})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	size := aws.ToInt64(head.ContentLength)
+	archive := newArchiveReader(ctx, src, opts.SrcBucket, opts.SrcKey, size)
+	format, err := readFormat(archive)
+	if err != nil {
+		return nil, err
+	}
+	if !isTarFormat(format) {
+		return nil, unsupportedFormat(archiveURL, format, "only uncompressed tar archives can be recompressed")
+	}
+	toc, location, err := readTocBytes(ctx, src, archive)
+	if err != nil {
+		Warnf(ctx, "%s has no TOC, %s will be extracted by reading it from start to end: %s", archiveURL, dstURL, err.Error())
+	}
+
+	if err := validateProvider(&opts); err != nil {
+		return nil, err
+	}
+	svc, err = checkDestinationBucket(ctx, svc, &opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withProvider(ctx, &opts)
+	ctx, stopProgress := startProgress(ctx, opts.ProgressFn)
+	defer stopProgress()
+	trackParts(ctx, int((size+seekableFrameSize-1)/seekableFrameSize))
+
+	output, err := src.GetObject(ctx, &s3.GetObjectInput{Bucket: &opts.SrcBucket, Key: &opts.SrcKey, IfMatch: head.ETag})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer output.Body.Close()
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(opts.CompressionLevel)), zstd.WithEncoderConcurrency(opts.Threads))
+	if err != nil {
+		return nil, err
+	}
+	defer encoder.Close()
+	upload, err := newStreamUpload(ctx, svc, &opts, size)
+	if err != nil {
+		return nil, err
+	}
+	Infof(ctx, "recompressing %s to %s at zstd level %d", archiveURL, dstURL, opts.CompressionLevel)
+
+	var table seekTable
+	for read := int64(0); ; {
+		// a batch of frames is read, compressed in parallel and uploaded in order
+		batch := make([][]byte, 0, opts.Threads)
+		for len(batch) < opts.Threads && read < size {
+			n := int64(seekableFrameSize)
+			if size-read < n {
+				n = size - read
+			}
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(output.Body, frame); err != nil {
+				upload.abort(ctx)
+				return nil, fmt.Errorf("reading %s at %d: %w", archiveURL, read, classifyError(err))
+			}
+			batch = append(batch, frame)
+			read += n
+		}
+		if len(batch) == 0 {
+			break
+		}
+		compressed := make([][]byte, len(batch))
+		g, _ := errgroup.WithContext(ctx)
+		for i := range batch {
+			i := i
+			g.Go(func() error {
+				compressed[i] = encoder.EncodeAll(batch[i], nil)
+				return nil
+			})
+		}
+		g.Wait()
+		for i, frame := range compressed {
+			if err := upload.write(ctx, frame); err != nil {
+				upload.abort(ctx)
+				return nil, err
+			}
+			table = table.add(int64(len(frame)), int64(len(batch[i])))
+			trackCopied(ctx, int64(len(batch[i])))
+			trackPartDone(ctx)
+		}
+	}
+	if table.size() != size {
+		upload.abort(ctx)
+		return nil, fmt.Errorf("%w: read %d bytes of %s, want %d", ErrSourceChanged, table.size(), archiveURL, size)
+	}
+	if err := upload.write(ctx, table.marshal()); err != nil {
+		upload.abort(ctx)
+		return nil, err
+	}
+	if err := upload.complete(ctx); err != nil {
+		return nil, err
+	}
+
+	if toc != nil {
+		framed, err := framedToc(toc, table)
+		if err != nil {
+			return nil, err
+		}
+		tocURL := externalTocLocation(opts.DstBucket, opts.DstKey)
+		if err := saveFile(ctx, svc, tocURL, framed); err != nil {
+			return nil, fmt.Errorf("writing the TOC %s: %w", tocURL, err)
+		}
+		Infof(ctx, "copied the TOC %s to %s with the offsets of the frames", location, tocURL)
+	}
+	report := &RecompressReport{Archive: archiveURL, Destination: dstURL, Size: size, CompressedSize: upload.size, Frames: len(table)}
+	Infof(ctx, "%s recompressed to %s, %d bytes to %d in %d frames", archiveURL, dstURL, size, upload.size, len(table))
+	return report, nil
+}
+
+// framedToc returns the csv TOC toc with the offset of the frame of table
+// that holds the start of every member.
+func framedToc(toc []byte, table seekTable) ([]byte, error) {
+	members, err := parseTocCSV(bytes.NewReader(toc))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		i := sort.Search(len(table), func(i int) bool { return table[i].offset+table[i].size > m.Start })
+		if i == len(table) {
+			return nil, fmt.Errorf("%w: %s starts at %d, after the end of the archive", ErrInvalidArchive, m.Filename, m.Start)
+		}
+		m.FrameOffset = aws.Int64(table[i].compressedOffset)
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	fields := tocFields(members, false, false)
+	for _, m := range members {
+		if err := cw.Write(tocRecord(m, fields)); err != nil {
+			return nil, err
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+func checkRecompressArgs(opts *S3TarS3Options) error {
+	if err := checkRewriteArgs(opts); err != nil {
+		return err
+	}
+	switch {
+	case opts.CompressionLevel == 0:
+		opts.CompressionLevel = defaultCompressionLevel
+	case opts.CompressionLevel < 1 || opts.CompressionLevel > 22:
+		return fmt.Errorf("%w: zstd level %d, want 1 to 22", ErrInvalidArgument, opts.CompressionLevel)
+	}
+	return nil
+}
+
+// checkRewriteArgs checks the options of the runs that read the archive
+// s3://SrcBucket/SrcKey once and write it in another format to
+// s3://DstBucket/DstKey.
+func checkRewriteArgs(opts *S3TarS3Options) error {
+	if opts.SrcBucket == "" || opts.SrcKey == "" {
+		return fmt.Errorf("%w: src bucket and key required", ErrInvalidArgument)
+	}
+	if opts.DstPath != "" {
+		return fmt.Errorf("%w: the new archive is written to Amazon S3, not to %s", ErrInvalidArgument, opts.DstPath)
+	}
+	if opts.DstBucket == "" || opts.DstKey == "" {
+		return fmt.Errorf("%w: destination bucket and key required", ErrInvalidArgument)
+	}
+	if opts.DstBucket == opts.SrcBucket && opts.DstKey == opts.SrcKey {
+		return fmt.Errorf("%w: the new archive can't replace %s while it's read", ErrInvalidArgument, objectURL(opts.SrcBucket, opts.SrcKey))
+	}
+	if opts.Threads == 0 {
+		opts.Threads = defaultThreads
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"golang.org/x/sync/errgroup"
+)
+
+// RestoreOptions are the restores started by RestoreAndWait.
+type RestoreOptions struct {
+	// Tier is the retrieval tier, Bulk when empty.
+	Tier types.Tier
+	// Days the restored copy of a Glacier object is kept, 7 when 0. Objects in
+	// an archive tier of Intelligent-Tiering move back to the frequent access
+	// tier instead.
+	Days int32
+	// Threads is the number of objects checked at once, 1 when 0.
+	Threads int
+}
+
+// RestoreState is the pending set of restores, persisted between runs.
+type RestoreState struct {
+	Started time.Time       `json:"started"`
+	Tier    types.Tier      `json:"tier"`
+	Days    int32           `json:"days"`
+	Pending []RestoreObject `json:"pending"`
+}
+
+// RestoreObject is an object whose restore isn't complete.
+type RestoreObject struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionId string `json:"version_id,omitempty"`
+}
+
+// RestoreAndWait makes sure the archived objects of objectList are restored
+// before they are read, across several runs. The first run, without a state
+// at location, starts the restore of every object in the Glacier Flexible
+// Retrieval or Deep Archive storage classes or an archive tier of
+// Intelligent-Tiering and writes the pending set to location, a local file or
+// s3:// url. The next runs only check the pending set. It returns nil once
+// every restore is complete, ErrRestorePending while some aren't.
+func RestoreAndWait(ctx context.Context, svc, client *s3.Client, location string, objectList []*S3Obj, opts RestoreOptions) error {
+	if opts.Tier == "" {
+		opts.Tier = types.TierBulk
+	}
+	if opts.Days == 0 {
+		opts.Days = 7
+	}
+	if opts.Days < 0 {
+		return fmt.Errorf("%w: restore days must be positive", ErrInvalidArgument)
+	}
+	state, err := loadRestoreState(ctx, svc, location)
+	if err != nil {
+		return fmt.Errorf("reading restore state %s: %w", location, err)
+	}
+
+	var candidates []*S3Obj
+	if state == nil {
+		state = &RestoreState{Started: time.Now().UTC(), Tier: opts.Tier, Days: opts.Days}
+		candidates = filter(objectList, mayBeArchived)
+		Infof(ctx, "restore: checking %d objects that can be archived", len(candidates))
+	} else {
+		opts.Tier, opts.Days = state.Tier, state.Days
+		candidates = state.listed(ctx, objectList)
+		Infof(ctx, "restore: checking %d pending restores started %s", len(candidates), state.Started.Format(time.RFC3339))
+	}
+
+	pending, err := restoreObjects(ctx, client, candidates, opts)
+	if err != nil {
+		return err
+	}
+	state.Pending = state.Pending[:0]
+	for _, o := range pending {
+		state.Pending = append(state.Pending, RestoreObject{Bucket: o.Bucket, Key: *o.Key, VersionId: o.VersionId})
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := saveFile(ctx, svc, location, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing restore state %s: %w", location, err)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d objects are still being restored with the %s tier, run again later", ErrRestorePending, len(pending), opts.Tier)
+	}
+	Infof(ctx, "restore: every object can be read")
+	return nil
+}
+
+// loadRestoreState reads the state written by a previous run, nil when
+// there is none.
+func loadRestoreState(ctx context.Context, svc *s3.Client, location string) (*RestoreState, error) {
+	r, err := loadFile(ctx, svc, location)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(classifyError(err), ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	state := &RestoreState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	return state, nil
+}
+
+// listed returns the objects of objectList whose restore is pending. Pending
+// objects that aren't listed anymore are dropped.
+func (s *RestoreState) listed(ctx context.Context, objectList []*S3Obj) []*S3Obj {
+	byKey := map[RestoreObject]*S3Obj{}
+	for _, o := range objectList {
+		byKey[RestoreObject{Bucket: o.Bucket, Key: *o.Key, VersionId: o.VersionId}] = o
+	}
+	var list []*S3Obj
+	for _, p := range s.Pending {
+		o, ok := byKey[p]
+		if !ok {
+			Warnf(ctx, "restore: s3://%s/%s isn't in the source anymore", p.Bucket, p.Key)
+			continue
+		}
+		list = append(list, o)
+	}
+	return list
+}
+
+// mayBeArchived reports whether o can be in an archive storage class, from
+// the class it was listed with. Objects of a manifest have none.
+func mayBeArchived(o *S3Obj) bool {
+	if len(o.Data) > 0 || o.NoHeaderRequired || o.deleteMarker || isObjectLambda(o.Bucket) || o.isLocal() || o.isPresigned() {
+		return false
+	}
+	return o.StorageClass == "" || archiveClass(o)
+}
+
+// restoreObjects requests the HEAD of every object of objectList, starts the
+// restore of the archived ones and returns the objects whose restore isn't
+// complete.
+func restoreObjects(ctx context.Context, client *s3.Client, objectList []*S3Obj, opts RestoreOptions) ([]*S3Obj, error) {
+	pending := make([]bool, len(objectList))
+	started := make([]bool, len(objectList))
+	if opts.Threads < 1 {
+		opts.Threads = 1
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for i, o := range objectList {
+		i, o := i, o
+		g.Go(func() error {
+			var err error
+			pending[i], started[i], err = restoreObject(gctx, readClient(client, o), o, opts)
+			if err != nil {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: classifyError(err)}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	var list []*S3Obj
+	count := 0
+	for i, o := range objectList {
+		if started[i] {
+			count++
+		}
+		if pending[i] {
+			list = append(list, o)
+		}
+	}
+	if count > 0 {
+		Infof(ctx, "restore: started %d restores with the %s tier", count, opts.Tier)
+	}
+	return list, nil
+}
+
+// restoreObject starts the restore of o when it's archived without a
+// restore, and reports whether its restore is pending and whether it was
+// started.
+func restoreObject(ctx context.Context, client *s3.Client, o *S3Obj, opts RestoreOptions) (bool, bool, error) {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o)})
+	if err != nil {
+		return false, false, err
+	}
+	archived, ongoing, restored := restoreStatus(head)
+	if !archived || restored {
+		return false, false, nil
+	}
+	if ongoing {
+		return true, false, nil
+	}
+	request := &types.RestoreRequest{GlacierJobParameters: &types.GlacierJobParameters{Tier: opts.Tier}}
+	if head.ArchiveStatus == "" {
+		request.Days = aws.Int32(opts.Days)
+	}
+	_, err = client.RestoreObject(ctx, &s3.RestoreObjectInput{Bucket: &o.Bucket, Key: o.Key, VersionId: versionID(o), RestoreRequest: request})
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "RestoreAlreadyInProgress" {
+		return true, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, true, nil
+}
+
+// restoreStatus reports whether the object of head is in an archive storage
+// class or tier, whether its restore is in progress and whether it's
+// complete.
+func restoreStatus(head *s3.HeadObjectOutput) (archived, ongoing, restored bool) {
+	archived = head.StorageClass == types.StorageClassGlacier || head.StorageClass == types.StorageClassDeepArchive || head.ArchiveStatus != ""
+	if head.Restore != nil {
+		ongoing = strings.Contains(*head.Restore, `ongoing-request="true"`)
+		restored = strings.Contains(*head.Restore, `ongoing-request="false"`)
+	}
+	return archived, ongoing, restored
+}
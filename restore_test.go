@@ -0,0 +1,70 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestRestoreStatus(t *testing.T) {
+	tests := map[string]struct {
+		head                            s3.HeadObjectOutput
+		wantArchived, wantOngoing, want bool
+	}{
+		"standard":         {head: s3.HeadObjectOutput{}},
+		"glacier":          {head: s3.HeadObjectOutput{StorageClass: types.StorageClassGlacier}, wantArchived: true},
+		"restore ongoing":  {head: s3.HeadObjectOutput{StorageClass: types.StorageClassDeepArchive, Restore: aws.String(`ongoing-request="true"`)}, wantArchived: true, wantOngoing: true},
+		"restored":         {head: s3.HeadObjectOutput{StorageClass: types.StorageClassGlacier, Restore: aws.String(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)}, wantArchived: true, want: true},
+		"intelligent tier": {head: s3.HeadObjectOutput{StorageClass: types.StorageClassIntelligentTiering, ArchiveStatus: types.ArchiveStatusArchiveAccess}, wantArchived: true},
+		"glacier instant":  {head: s3.HeadObjectOutput{StorageClass: types.StorageClassGlacierIr}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			archived, ongoing, restored := restoreStatus(&tt.head)
+			if archived != tt.wantArchived || ongoing != tt.wantOngoing || restored != tt.want {
+				t.Errorf("restoreStatus() = %v, %v, %v, want %v, %v, %v", archived, ongoing, restored, tt.wantArchived, tt.wantOngoing, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	location := filepath.Join(t.TempDir(), "restore.json")
+	if state, err := loadRestoreState(ctx, nil, location); state != nil || err != nil {
+		t.Fatalf("loadRestoreState(missing) = %v, %v, want no state", state, err)
+	}
+
+	glacier := NewS3ObjOptions(WithBucketAndKey("b", "glacier"), WithSize(1))
+	glacier.StorageClass = types.ObjectStorageClassGlacier
+	standard := NewS3ObjOptions(WithBucketAndKey("b", "standard"), WithSize(1))
+	standard.StorageClass = types.ObjectStorageClassStandard
+	manifest := NewS3ObjOptions(WithBucketAndKey("b", "manifest"), WithSize(1))
+	versioned := NewS3ObjOptions(WithBucketAndKey("b", "versioned"), WithSize(1))
+	versioned.StorageClass = types.ObjectStorageClassDeepArchive
+	versioned.VersionId = "v1"
+	objectList := []*S3Obj{glacier, standard, manifest, versioned}
+
+	if got := filter(objectList, mayBeArchived); len(got) != 3 || got[0] != glacier || got[1] != manifest || got[2] != versioned {
+		t.Errorf("mayBeArchived() kept %d objects, want glacier, manifest and versioned", len(got))
+	}
+
+	if err := os.WriteFile(location, []byte(`{"tier":"Bulk","days":7,"pending":[{"bucket":"b","key":"versioned","version_id":"v1"},{"bucket":"b","key":"gone"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadRestoreState(ctx, nil, location)
+	if err != nil {
+		t.Fatalf("loadRestoreState() error = %v", err)
+	}
+	if got := state.listed(ctx, objectList); len(got) != 1 || got[0] != versioned {
+		t.Errorf("listed() = %d objects, want versioned", len(got))
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// LoadErrorManifest reads an error manifest written by a previous run and
+// returns the objects to archive again, their estimated tar size and the
+// archive they are missing from.
+func LoadErrorManifest(ctx context.Context, svc *s3.Client, fpath string) ([]*S3Obj, int64, string, error) {
+	r, err := loadFile(ctx, svc, fpath)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	objectList, estimatedSize, err := parseCSV(ctx, bytes.NewReader(data), true, false)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if len(objectList) == 0 {
+		return nil, 0, "", fmt.Errorf("%w: error manifest %s has no objects", ErrInvalidArgument, fpath)
+	}
+
+	var archive string
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, 0, "", err
+	}
+	for _, record := range records[1:] {
+		if len(record) == len(errorManifestHeader) {
+			archive = record[len(record)-1]
+			break
+		}
+	}
+	return objectList, estimatedSize, archive, nil
+}
+
+// archiveToc returns the members of an archive, from its toc.csv when it was
+// created with one, the toc next to it when it was built in memory or by
+// walking the tar headers otherwise.
+func archiveToc(ctx context.Context, svc *s3.Client, bucket, key string) (TOC, error) {
+	hdr, _, err := extractTarHeader(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name == "toc.csv" {
+		return extractCSVToc(ctx, svc, bucket, key, "")
+	}
+	if r, err := loadFile(ctx, svc, externalTocLocation(bucket, key)); err == nil {
+		defer r.Close()
+		return parseTocCSV(r)
+	}
+	return scanToc(ctx, svc, bucket, key)
+}
+
+// MergeToc writes a single csv TOC (archive,filename,start,size,etag) for all
+// the archives, so the members of an archive and the supplemental archives
+// created by retrying its error manifest can be found in one place.
+func MergeToc(ctx context.Context, svc *s3.Client, location string, archives ...string) error {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+	for _, archive := range archives {
+		bucket, key := ExtractBucketAndPath(archive)
+		toc, err := archiveToc(ctx, svc, bucket, key)
+		if err != nil {
+			return fmt.Errorf("reading toc of %s: %w", archive, err)
+		}
+		for _, m := range toc {
+			record := []string{
+				archive,
+				m.Filename,
+				strconv.FormatInt(m.Start, 10),
+				strconv.FormatInt(m.Size, 10),
+				m.Etag,
+			}
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return saveFile(ctx, svc, location, buf.Bytes())
+}
+
+// archivedMember is the name and ETag of a member of an existing archive.
+type archivedMember struct {
+	name string
+	etag string
+}
+
+// ArchivedObjects are the members of existing archives, returned by
+// LoadArchived.
+type ArchivedObjects map[archivedMember]bool
+
+// LoadArchived reads the TOC of existing archives. A location ending in .csv
+// is a csv TOC, anything else an archive whose TOC is read as with --list.
+// Both can be local files or s3:// urls.
+func LoadArchived(ctx context.Context, svc *s3.Client, locations ...string) (ArchivedObjects, error) {
+	archived := ArchivedObjects{}
+	for _, location := range locations {
+		var toc TOC
+		var err error
+		if strings.HasSuffix(location, ".csv") {
+			var r io.ReadCloser
+			if r, err = loadFile(ctx, svc, location); err == nil {
+				toc, err = parseTocCSV(r)
+				r.Close()
+			}
+		} else {
+			bucket, key := ExtractBucketAndPath(location)
+			toc, err = archiveToc(ctx, svc, bucket, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading toc of %s: %w", location, err)
+		}
+		withoutETag := 0
+		for _, m := range toc {
+			etag := strings.Trim(m.Etag, `"`)
+			if etag == "" {
+				withoutETag++
+				continue
+			}
+			archived[archivedMember{name: m.Filename, etag: etag}] = true
+		}
+		if withoutETag > 0 {
+			Warnf(ctx, "%d members of %s have no ETag in the toc and can't be matched", withoutETag, location)
+		}
+	}
+	return archived, nil
+}
+
+// SkipArchived returns the objects whose key and ETag aren't already in
+// archived, and their estimated tar size. Members are matched by name, the
+// archives must have been created without renaming the keys.
+func SkipArchived(objectList []*S3Obj, archived ArchivedObjects) ([]*S3Obj, int64) {
+	var remaining []*S3Obj
+	var estimatedSize int64
+	for _, o := range objectList {
+		if o.ETag != nil && archived[archivedMember{name: *o.Key, etag: strings.Trim(*o.ETag, `"`)}] {
+			continue
+		}
+		remaining = append(remaining, o)
+		estimatedSize += estimateObjectSize(*o.Size)
+	}
+	return remaining, estimatedSize
+}
@@ -0,0 +1,73 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	contextKeyRunID = contextKey("run-id")
+	// runIDKey is the user metadata and the tag that record the run that
+	// wrote an object.
+	runIDKey = "s3tar-run-id"
+)
+
+// NewRunID returns an id for a run: the UTC time it started and a random
+// suffix, so ids sort by start time and concurrent runs don't share one.
+func NewRunID() string {
+	suffix, err := randomHex(4)
+	if err != nil {
+		suffix = "00000000"
+	}
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + suffix
+}
+
+// WithRunID returns a context whose log lines are prefixed with the run id.
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKeyRunID, id)
+}
+
+func runID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRunID).(string)
+	return id
+}
+
+// runPrefix is the prefix of the log lines of the run.
+func runPrefix(ctx context.Context) string {
+	if id := runID(ctx); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
+
+// runMetadata is the user metadata the intermediate objects of the run are
+// written with, so orphaned ones can be attributed.
+func runMetadata(ctx context.Context) map[string]string {
+	if id := runID(ctx); id != "" {
+		return map[string]string{runIDKey: id}
+	}
+	return nil
+}
+
+// archiveTags returns the tags of the archive: opts.ObjectTags and the run id
+// when there are tags, tagging needs s3:PutObjectTagging, and room for it.
+func archiveTags(opts *S3TarS3Options) types.Tagging {
+	tags := opts.ObjectTags
+	if opts.RunID == "" || len(tags.TagSet) == 0 || len(tags.TagSet) >= maxObjectTags {
+		return tags
+	}
+	for _, tag := range tags.TagSet {
+		if *tag.Key == runIDKey {
+			return tags
+		}
+	}
+	tagSet := append([]types.Tag{}, tags.TagSet...)
+	tagSet = append(tagSet, types.Tag{Key: aws.String(runIDKey), Value: aws.String(opts.RunID)})
+	return types.Tagging{TagSet: tagSet}
+}
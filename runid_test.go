@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestNewRunID(t *testing.T) {
+	id := NewRunID()
+	if !regexp.MustCompile(`^\d{8}T\d{6}Z-[0-9a-f]{8}$`).MatchString(id) {
+		t.Errorf("NewRunID() = %q", id)
+	}
+	if other := NewRunID(); other == id {
+		t.Errorf("NewRunID() returned %q twice", id)
+	}
+	ctx := WithRunID(context.Background(), id)
+	if got := runPrefix(ctx); got != "["+id+"] " {
+		t.Errorf("runPrefix() = %q", got)
+	}
+	if got := runMetadata(ctx)[runIDKey]; got != id {
+		t.Errorf("runMetadata() = %q, want %q", got, id)
+	}
+	if runPrefix(context.Background()) != "" || runMetadata(context.Background()) != nil {
+		t.Errorf("a context without a run id has a prefix or metadata")
+	}
+}
+
+func TestArchiveTags(t *testing.T) {
+	tags := func(n int) types.Tagging {
+		var tagging types.Tagging
+		for i := 0; i < n; i++ {
+			tagging.TagSet = append(tagging.TagSet, types.Tag{Key: aws.String(string(rune('a' + i))), Value: aws.String("v")})
+		}
+		return tagging
+	}
+	tests := []struct {
+		opts S3TarS3Options
+		want int
+	}{
+		{opts: S3TarS3Options{RunID: "id"}, want: 0},
+		{opts: S3TarS3Options{RunID: "id", ObjectTags: tags(2)}, want: 3},
+		{opts: S3TarS3Options{RunID: "id", ObjectTags: tags(maxObjectTags)}, want: maxObjectTags},
+		{opts: S3TarS3Options{ObjectTags: tags(2)}, want: 2},
+	}
+	for _, tt := range tests {
+		if got := archiveTags(&tt.opts); len(got.TagSet) != tt.want {
+			t.Errorf("archiveTags(%d tags, run id %q) = %d tags, want %d", len(tt.opts.ObjectTags.TagSet), tt.opts.RunID, len(got.TagSet), tt.want)
+		}
+	}
+	opts := &S3TarS3Options{RunID: "id", ObjectTags: tags(1)}
+	if got := archiveTags(opts); *got.TagSet[1].Key != runIDKey || *got.TagSet[1].Value != "id" || len(opts.ObjectTags.TagSet) != 1 {
+		t.Errorf("archiveTags() = %v, want the run id tag added to a copy", got.TagSet)
+	}
+}
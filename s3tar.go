@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -33,14 +34,13 @@ const (
 	fileSizeMax     = 1024 * 1024 * 1024 * 1024 * 5 // 5TB
 	partSizeMax     = 1024 * 1024 * 1024 * 5        // 5GB
 	maxPartNumLimit = 10000
+	// defaultThreads is the number of concurrent requests of a run when
+	// S3TarS3Options.Threads isn't set.
+	defaultThreads = 100
 )
 
 var (
-	accum     int64 = 0
-	pad             = make([]byte, beginningPad)
-	tarFormat       = tar.FormatPAX
-	rc        *RecursiveConcat
-	threads   = 100
+	pad = make([]byte, beginningPad)
 )
 
 func ServerSideTar(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
@@ -49,141 +49,392 @@ func ServerSideTar(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) er
 	var err error
 	if opts.SrcManifest != "" {
 		Infof(ctx, "using manifest file %s", opts.SrcManifest)
-		objectList, _, err = LoadCSV(ctx, svc, opts.SrcManifest, opts.SkipManifestHeader, opts.UrlDecode)
+		objectList, _, err = LoadCSV(ctx, sourceClient(svc, opts), opts.SrcManifest, opts.SkipManifestHeader, opts.UrlDecode)
+	} else if len(opts.Sources) > 0 {
+		Infof(ctx, "using %d sources", len(opts.Sources))
+		objectList, _, err = ListSources(ctx, sourceClient(svc, opts), opts.Sources, opts.Flat, opts.DeleteMarkers)
+	} else if opts.SrcPath != "" {
+		Infof(ctx, "using local directory '%s'", opts.SrcPath)
+		objectList, _, err = ListLocalFiles(ctx, opts.SrcPath, opts.Flat)
+		if err == nil && len(objectList) == 0 {
+			return fmt.Errorf("%w: %s has no files", ErrNotFound, opts.SrcPath)
+		}
 	} else if opts.SrcBucket != "" {
 		Infof(ctx, "using source bucket '%s' and prefix '%s'", opts.SrcBucket, opts.SrcPrefix)
-		objectList, _, err = ListAllObjects(ctx, svc, opts.SrcBucket, opts.SrcPrefix)
+		var filterFns []func(types.Object) bool
+		if opts.Flat {
+			filterFns = append(filterFns, FlatFilter(opts.SrcPrefix))
+		}
+		objectList, _, err = ListAllObjectVersions(ctx, sourceClient(svc, opts), opts.SrcBucket, opts.SrcPrefix, opts.DeleteMarkers, filterFns...)
 	} else {
-		return fmt.Errorf("manifest file or source bucket required")
+		return fmt.Errorf("%w: manifest file or source bucket required", ErrInvalidArgument)
 	}
 	if err != nil {
 		return err
 	}
+	if len(objectList) == 0 && opts.SrcManifest == "" {
+		return fmt.Errorf("%w: s3://%s/%s matched zero objects", ErrNotFound, opts.SrcBucket, opts.SrcPrefix)
+	}
 
 	return createFromList(ctx, svc, objectList, opts)
 }
 
 func createFromList(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) error {
 
-	tarFormat = opts.tarFormat
-	if tarFormat == tar.FormatUnknown {
-		tarFormat = tar.FormatPAX
+	if opts.tarFormat == tar.FormatUnknown {
+		opts.tarFormat = tar.FormatPAX
+	}
+	if opts.Threads <= 0 {
+		opts.Threads = defaultThreads
+	}
+	if err := validateErrorPolicy(opts); err != nil {
+		return err
+	}
+	if err := validateChangePolicy(ctx, opts); err != nil {
+		return err
+	}
+	if err := validateArchivedPolicy(opts); err != nil {
+		return err
+	}
+	if err := validateLockMode(opts); err != nil {
+		return err
+	}
+	if err := validateOverwritePolicy(opts); err != nil {
+		return err
+	}
+	if err := checkObjectLambda(objectList, opts); err != nil {
+		return err
+	}
+	if err := checkLocalSource(objectList, opts); err != nil {
+		return err
+	}
+	if err := checkPresignedSource(objectList, opts); err != nil {
+		return err
+	}
+	if err := checkLocalDestination(opts); err != nil {
+		return err
+	}
+	if opts.ServerSideOnly && opts.ConcatInMemory {
+		return fmt.Errorf("%w: --server-side-only and --concat-in-memory can't be used together", ErrInvalidArgument)
+	}
+	if err := validateHybridThreshold(opts); err != nil {
+		return err
+	}
+	if err := validateScratch(opts); err != nil {
+		return err
+	}
+	if err := validatePartRetries(opts); err != nil {
+		return err
+	}
+	if err := validateTimeouts(opts); err != nil {
+		return err
+	}
+	ctx = withTimeouts(ctx, opts)
+	if err := validateRanges(opts); err != nil {
+		return err
+	}
+	ctx = withRanges(ctx, opts)
+	if err := validateMaxBufferedParts(opts); err != nil {
+		return err
+	}
+	if err := validatePreflight(opts); err != nil {
+		return err
+	}
+	if err := validatePrefetch(opts); err != nil {
+		return err
+	}
+	if err := validatePartPadding(opts); err != nil {
+		return err
+	}
+	if err := validatePartOrder(opts); err != nil {
+		return err
+	}
+	if err := validateProvider(opts); err != nil {
+		return err
+	}
+	if err := validatePartSizes(opts); err != nil {
+		return err
+	}
+	if err := validateAccelerate(opts); err != nil {
+		return err
+	}
+	if err := validateDeleteMarkers(opts); err != nil {
+		return err
+	}
+	if err := validateState(objectList, opts); err != nil {
+		return err
+	}
+	if opts.RunID == "" {
+		opts.RunID = NewRunID()
+	}
+	ctx = WithRunID(ctx, opts.RunID)
+	Infof(ctx, "run id %s", opts.RunID)
+	objectList, deleted := splitDeleteMarkers(objectList)
+	if len(objectList) == 0 && len(deleted) > 0 {
+		return fmt.Errorf("%w: the latest version of every key is a delete marker", ErrNotFound)
 	}
-	threads = opts.Threads
+	if len(objectList) == 0 {
+		return fmt.Errorf("%w: no objects to archive", ErrNotFound)
+	}
+	// the objects of a resumed job are resolved already
+	if opts.job == nil {
+		if err := resolveMembers(objectList, opts); err != nil {
+			return err
+		}
+	}
+	if opts.Idempotent {
+		existing, err := existingArchive(ctx, svc, opts, opts.manifestHash)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			Infof(ctx, "s3://%s/%s was already created from the same manifest, skipping", opts.DstBucket, opts.DstKey)
+			if opts.SummaryFn != nil {
+				opts.SummaryFn(newRunSummary(ctx, svc, existing, len(objectList), 0, 0))
+			}
+			return nil
+		}
+	}
+	if err := checkNoClobber(ctx, svc, opts); err != nil {
+		return err
+	}
+	if err := probeProvider(ctx, svc, opts); err != nil {
+		return err
+	}
+	svc, err := checkDestinationBucket(ctx, svc, opts)
+	if err != nil {
+		return err
+	}
+	ctx = withProvider(ctx, opts)
+	if err := checkAccelerate(ctx, svc, opts); err != nil {
+		return err
+	}
+	unlock, err := lockSource(ctx, sourceClient(svc, opts), objectList, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	ctx = context.WithValue(ctx, contextKeyS3Client, svc)
+	ctx = withSkipTracker(ctx)
+	ctx = withPartTracker(ctx)
+	reportDeleteMarkers(ctx, deleted, opts.DeleteMarkers)
+	ctx, stopProgress := startProgress(ctx, opts.ProgressFn)
 	start := time.Now()
+	retries := clientRetries(svc)
 
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Printf("%v\n", r)
-			fmt.Printf("recovered from a panic. Trying to clean up.\n")
+			Errorf(ctx, "%v", r)
+			Errorf(ctx, "recovered from a panic. Trying to clean up.")
 		}
 		if !opts.ConcatInMemory {
 			cleanUp(ctx, svc, opts)
 		}
+		if skipped := skippedObjects(ctx); len(skipped) > 0 {
+			location := errorManifestLocation(opts)
+			archive := archiveURL(opts)
+			if err := WriteErrorManifest(ctx, svc, location, archive, skipped); err != nil {
+				Errorf(ctx, "unable to write error manifest %s: %s", location, err.Error())
+			} else {
+				Warnf(ctx, "%d objects were skipped, see %s", len(skipped), location)
+			}
+		}
+		stopProgress()
 		elapsed := time.Since(start)
 		Infof(ctx, "Time elapsed: %s", elapsed)
 	}()
 
 	Infof(ctx, "processing %d Amazon S3 Objects", len(objectList))
+	members := len(objectList) + len(deleted)
+	// the deleted keys left out by DeleteMarkersIgnore aren't skipped members
+	if opts.DeleteMarkers == DeleteMarkersIgnore {
+		opts.ignoredDeleteMarkers = len(deleted)
+		members -= len(deleted)
+	}
+	// the objects of a resumed job were checked when it started
+	if opts.job == nil {
+		objectList, err = preflight(ctx, sourceClient(svc, opts), objectList, opts)
+		if err != nil {
+			return err
+		}
+	}
+	if len(objectList) == 0 {
+		return fmt.Errorf("%w: none of the objects can be archived", ErrNotFound)
+	}
 
-	smallFiles := false
+	totalSize, _ := archiveSizes(objectList, opts)
+	Infof(ctx, "final size %s (without tar headers + padding)", formatBytes(totalSize))
+
+	if !fitsOneArchive(objectList, totalSize, opts) {
+		if opts.DstPath != "" {
+			return fmt.Errorf("%w: total size (%d) of all objects is more than %s. Reduce the number of objects", ErrArchiveTooLarge, totalSize, formatBytes(opts.provider.maxObjectSize()))
+		}
+		if opts.State != "" {
+			return fmt.Errorf("%w: --state can't be used with an archive split into a chain", ErrInvalidArgument)
+		}
+		return createChain(ctx, svc, objectList, opts, start, retries)
+	}
+
+	concatObj, err := buildArchive(ctx, svc, objectList, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Provenance {
+		if err := writeProvenance(ctx, svc, opts, start); err != nil {
+			return err
+		}
+	}
+
+	if opts.SummaryFn != nil {
+		skipped := skippedObjects(ctx)
+		summary := newRunSummary(ctx, svc, concatObj, members-len(skipped), time.Since(start), clientRetries(svc)-retries)
+		summary.Parts = partReports(ctx)
+		summary.IgnoredDeleteMarkers = opts.ignoredDeleteMarkers
+		for _, o := range skipped {
+			summary.Skipped = append(summary.Skipped, objectURL(o.Bucket, o.Key))
+		}
+		if len(skipped) > 0 {
+			summary.ErrorManifest = errorManifestLocation(opts)
+		}
+		opts.SummaryFn(summary)
+	}
+	return nil
+}
+
+// resolveMembers applies the name and header transforms and the PAX records
+// of opts to the objects of objectList and sets the hash of their manifest.
+func resolveMembers(objectList []*S3Obj, opts *S3TarS3Options) error {
+	if err := ApplyNameTransforms(objectList, opts.nameTransforms...); err != nil {
+		return err
+	}
+	if err := ResolveNameCollisions(objectList, opts.nameCollisions); err != nil {
+		return err
+	}
+	setHeaderTransforms(objectList, opts.headerTransforms)
+	setTarFormat(objectList, opts.tarFormat)
+	if opts.RecordOrigin {
+		if opts.tarFormat != tar.FormatPAX {
+			return fmt.Errorf("%w: recording the origin needs the PAX format", ErrInvalidArgument)
+		}
+		recordOrigin(objectList)
+	}
+	if len(opts.paxRecords) > 0 {
+		if opts.tarFormat != tar.FormatPAX {
+			return fmt.Errorf("%w: pax records need the PAX format", ErrInvalidArgument)
+		}
+		if err := setPAXRecords(objectList, opts.paxRecords); err != nil {
+			return err
+		}
+	}
+	if opts.Idempotent || opts.Provenance {
+		opts.manifestHash = manifestHash(objectList, opts.tarFormat, opts)
+	}
+	return nil
+}
 
+// archiveSizes returns the size of the data of objectList and whether some
+// of its objects are archived by the small files path.
+func archiveSizes(objectList []*S3Obj, opts *S3TarS3Options) (int64, bool) {
+	smallFiles := false
 	totalSize := int64(0)
 	for _, o := range objectList {
 		totalSize += *o.Size
-		if *o.Size < int64(beginningPad) {
+		// objects under the hybrid threshold are downloaded by the small files path
+		if *o.Size < int64(beginningPad) || *o.Size < opts.HybridThreshold {
 			smallFiles = true
 		}
 	}
-	Infof(ctx, "final size %s (without tar headers + padding)", formatBytes(totalSize))
-
-	if totalSize > fileSizeMax {
-		return fmt.Errorf("total size (%d) of all objects is more than 5TB. Reduce the number of objects", totalSize)
-	}
+	return totalSize, smallFiles
+}
 
+// buildArchive writes the archive of objectList to the destination of opts.
+func buildArchive(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) (*S3Obj, error) {
+	totalSize, smallFiles := archiveSizes(objectList, opts)
 	concatObj := NewS3Obj()
-	if opts.ConcatInMemory || totalSize < fileSizeMin {
+	if (opts.TocChecksums || opts.TocExtended) && !useInMemory(opts, totalSize) {
+		// objects copied server-side are never read, their checksums are
+		// requested for the toc.csv written in front of them
+		fetch := fetchChecksums
+		if opts.TocExtended {
+			fetch = fetchDetails
+		}
+		if err := fetch(ctx, sourceClient(svc, opts), objectList, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Accelerate && !useInMemory(opts, totalSize) {
+		Warnf(ctx, "--accelerate only applies to archives built in memory, this one is assembled server-side")
+	}
+	if useInMemory(opts, totalSize) {
 		Debugf(ctx, "Processing small files in-memory")
 		var err error
 		concatObj, err = buildInMemoryConcat(ctx, svc, objectList, totalSize, opts)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else if smallFiles {
 		Debugf(ctx, "Processing small files")
-		var err error
-		rc, err = NewRecursiveConcat(ctx, RecursiveConcatOptions{
+		scratchBucket, _ := scratchLocation(opts)
+		rc, err := NewRecursiveConcat(ctx, RecursiveConcatOptions{
 			Client:      svc,
-			Bucket:      opts.DstBucket,
+			Bucket:      scratchBucket,
 			DstPrefix:   opts.DstPrefix,
 			DstKey:      opts.DstKey,
+			PartsPrefix: scratchKey(opts),
 			Region:      opts.Region,
 			EndpointUrl: opts.EndpointUrl,
 		})
 		if err != nil {
-			return err
-		}
-		headList := make([]*s3.HeadObjectOutput, len(objectList))
-		if opts.PreservePOSIXMetadata {
-			var wg sync.WaitGroup
-			for i, obj := range objectList {
-				wg.Add(1)
-				go func(i int, obj *S3Obj) {
-					defer wg.Done()
-					if obj.NoHeaderRequired {
-						headList[i] = nil
-					} else {
-						head := fetchS3ObjectHead(ctx, svc, obj)
-						headList[i] = head
-					}
-				}(i, obj)
-			}
-			wg.Wait()
+			return nil, err
 		}
-
 		Debugf(ctx, "building toc")
 		manifestObj, _, err := buildToc(ctx, objectList)
 		if err != nil {
-			fmt.Printf("buildToc: %s", err.Error())
-			return err
+			return nil, fmt.Errorf("building the toc: %w", err)
 		}
 		objectList = append([]*S3Obj{manifestObj}, objectList...)
-		headList = append([]*s3.HeadObjectOutput{nil}, headList...)
 		Debugf(ctx, "prepended toc: %s Size: %d len.Data: %d", *manifestObj.Key, *manifestObj.Size, len(manifestObj.Data))
-		concatObj, err = processSmallFiles(ctx, svc, objectList, headList, opts.DstKey, opts)
+		concatObj, err = processSmallFiles(ctx, svc, rc, objectList, opts.DstKey, opts)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		Debugf(ctx, "Processing large files")
 		var err error
 		concatObj, err = processLargeFiles(ctx, svc, objectList, opts)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	Infof(ctx, "Final Object: s3://%s/%s", concatObj.Bucket, *concatObj.Key)
-	return nil
-}
-
-func cleanUp(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) {
-	Infof(ctx, "deleting all intermediate objects")
-	scratchDirs := []string{
-		filepath.Join(opts.DstPrefix, opts.DstKey+".parts"),
-		filepath.Join(opts.DstPrefix, opts.DstKey, "headers"),
-	}
-	for _, path := range scratchDirs {
-		if path == "" || path == "/" {
-			continue
+	Infof(ctx, "Final Object: %s", objectURL(concatObj.Bucket, *concatObj.Key))
+	if opts.SignTocKeyID != "" {
+		if err := writeTocSignature(ctx, svc, opts); err != nil {
+			return nil, err
 		}
-		deleteList, _, _ := ListAllObjects(ctx, svc, opts.DstBucket, path)
-		err := deleteObjectList(ctx, svc, opts, deleteList)
-		if err != nil {
-			Warnf(ctx, "Unable to delete intermediate objects at: %s %s", opts.DstBucket, path)
+	}
+	if opts.TocTable.Name != "" {
+		if err := exportArchiveToc(ctx, svc, opts); err != nil {
+			return nil, err
 		}
 	}
+	return concatObj, nil
+}
+
+// useInMemory reports whether the archive of objects of totalSize bytes is
+// built by downloading them, with ConcatInMemory or when it's too small for
+// multipart copies or under the MPU threshold, unless ServerSideOnly is set.
+func useInMemory(opts *S3TarS3Options, totalSize int64) bool {
+	return opts.ConcatInMemory || ((totalSize < fileSizeMin || totalSize < mpuThreshold(opts)) && !opts.ServerSideOnly)
+}
+
+// mpuThreshold is the size from which an archive built in memory is uploaded
+// with a multipart upload instead of a single PutObject, MPUThreshold or 5MB.
+func mpuThreshold(opts *S3TarS3Options) int64 {
+	if opts.MPUThreshold > 0 {
+		return opts.MPUThreshold
+	}
+	return fileSizeMin
 }
 
 func generateLastBlock(s int64, opts *S3TarS3Options) *S3Obj {
@@ -208,11 +459,13 @@ type concatresult struct {
 func concatObjAndHeader(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) ([]*S3Obj, error) {
 
 	ctx = context.WithValue(ctx, contextKeyS3Client, svc)
+	scratchBucket, _ := scratchLocation(opts)
 	concater, err := NewRecursiveConcat(ctx, RecursiveConcatOptions{
 		Client:      svc,
-		Bucket:      opts.DstBucket,
+		Bucket:      scratchBucket,
 		DstPrefix:   opts.DstPrefix,
 		DstKey:      opts.DstKey,
+		PartsPrefix: scratchKey(opts),
 		Region:      opts.Region,
 		EndpointUrl: opts.EndpointUrl,
 	})
@@ -223,8 +476,8 @@ func concatObjAndHeader(ctx context.Context, svc *s3.Client, objectList []*S3Obj
 	if err != nil {
 		return nil, err
 	}
-	firstPart := buildFirstPart(manifestObj.Data)
-	firstPart.Bucket = opts.DstBucket
+	firstPart := buildFirstPart(manifestObj.Data, opts.tarFormat)
+	firstPart.Bucket = scratchBucket
 	objectList = append([]*S3Obj{firstPart}, objectList...)
 
 	wg := sizedwaitgroup.New(opts.Threads)
@@ -241,17 +494,17 @@ func concatObjAndHeader(ctx context.Context, svc *s3.Client, objectList []*S3Obj
 		}
 
 		name := fmt.Sprintf("%d.part-%d.hdr", i, nextIndex)
-		key := filepath.Join(opts.DstPrefix, opts.DstKey+".parts", name)
+		key := scratchKey(opts, name)
 		wg.Add()
 		go func(nextObject *S3Obj, obj *S3Obj, key string, partNum int) {
 			var p1 = obj
 			var p2 *S3Obj = nil
 			if notLastBlock {
-				var head *s3.HeadObjectOutput
-				if opts.PreservePOSIXMetadata {
-					head = fetchS3ObjectHead(ctx, svc, nextObject)
-				} else {
-					head = nil
+				head, err := objectHead(ctx, svc, nextObject, opts)
+				if err != nil {
+					resultsChan <- concatresult{nil, err}
+					wg.Done()
+					return
 				}
 
 				h := buildHeader(nextObject, p1, false, head)
@@ -263,7 +516,7 @@ func concatObjAndHeader(ctx context.Context, svc *s3.Client, objectList []*S3Obj
 			}
 			var pairs = []*S3Obj{p1, p2}
 
-			res, err := concater.ConcatObjects(ctx, pairs, opts.DstBucket, key)
+			res, err := concater.ConcatObjects(ctx, pairs, scratchBucket, key)
 			if err != nil {
 				Infof(ctx, err.Error())
 			}
@@ -281,7 +534,7 @@ func concatObjAndHeader(ctx context.Context, svc *s3.Client, objectList []*S3Obj
 	var results []*S3Obj
 	for r := range resultsChan {
 		if r.err != nil {
-			return nil, err
+			return nil, r.err
 		}
 		results = append(results, r.result)
 	}
@@ -289,16 +542,23 @@ func concatObjAndHeader(ctx context.Context, svc *s3.Client, objectList []*S3Obj
 	return results, nil
 }
 
-func fetchS3ObjectHead(ctx context.Context, svc *s3.Client, nextObject *S3Obj) *s3.HeadObjectOutput {
-	Debugf(ctx, "fetching head for %s/%s", *&nextObject.Bucket, *nextObject.Key)
-	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(nextObject.Bucket),
-		Key:    nextObject.Key,
+// objectHead returns the HEAD of o when its header needs the POSIX metadata of
+// the object, nil otherwise. It's requested when the header is written, the
+// layout of the archive is planned from the listing alone.
+func objectHead(ctx context.Context, svc *s3.Client, o *S3Obj, opts *S3TarS3Options) (*s3.HeadObjectOutput, error) {
+	if !opts.PreservePOSIXMetadata || o.NoHeaderRequired || len(o.Data) > 0 {
+		return nil, nil
+	}
+	Debugf(ctx, "fetching head for %s/%s", o.Bucket, *o.Key)
+	head, err := readClient(sourceClient(svc, opts), o).HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(o.Bucket),
+		Key:       o.Key,
+		VersionId: versionID(o),
 	})
 	if err != nil {
-		Fatalf(ctx, err.Error())
+		return nil, &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: classifyError(err)}
 	}
-	return head
+	return head, nil
 }
 
 type batchGroup struct {
@@ -320,7 +580,8 @@ func breakUpList(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts
 	for e := l.Front(); e != nil; {
 		o := e.Value.(*S3Obj)
 		temp := accum + *o.Size
-		if temp < partSizeMax {
+		if temp < partSizeMax || len(accumList) == 0 {
+			// an object over the limit is a batch of its own
 			accum = temp
 			o.PartNum = partCounter
 			partCounter += 1
@@ -343,16 +604,21 @@ func breakUpList(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts
 		g, ctx := errgroup.WithContext(ctx)
 		g.SetLimit(opts.Threads)
 		results := make([]*S3Obj, len(batchGoupList))
+		sizes := make([]int64, len(batchList))
 		for i, batch := range batchList {
-			i, batch := i, batch
+			sizes[i] = objectsSize(batch)
+		}
+		for _, i := range partOrder(opts.PartOrder, sizes) {
+			i, batch := i, batchList[i]
 			g.Go(func() error {
 				Debugf(ctx, "processing batch: %d\n", i)
 				fn, err := randomHex(12)
 				if err != nil {
 					return err
 				}
-				tempKey := filepath.Join(opts.DstPrefix, opts.DstKey+".parts", fn)
-				obj, err := concatObjects(ctx, svc, 0, batch, opts.DstBucket, tempKey)
+				scratchBucket, _ := scratchLocation(opts)
+				tempKey := scratchKey(opts, fn)
+				obj, err := concatObjects(ctx, svc, 0, batch, scratchBucket, tempKey, opts.Threads)
 				if err == nil {
 					obj.PartNum = i + 1
 					results[i] = obj
@@ -376,8 +642,8 @@ func processLargeFiles(ctx context.Context, svc *s3.Client, objectList []*S3Obj,
 		return nil, err
 	}
 
-	if len(results) > 10000 {
-		Infof(ctx, "objectList is larger than 10,000 files. processing in batches\n")
+	if copyParts(results) > 10000 {
+		Infof(ctx, "objectList is larger than 10,000 parts. processing in batches\n")
 		var err error
 		results, err = breakUpList(ctx, svc, results, opts)
 		if err != nil {
@@ -386,13 +652,14 @@ func processLargeFiles(ctx context.Context, svc *s3.Client, objectList []*S3Obj,
 	}
 	Debugf(ctx, "list reduced\n")
 
-	tempKey := filepath.Join(opts.DstPrefix, opts.DstKey+".parts", "output.temp")
-	concatObj, err := concatObjects(ctx, svc, 0, results, opts.DstBucket, tempKey)
+	scratchBucket, _ := scratchLocation(opts)
+	tempKey := scratchKey(opts, "output.temp")
+	concatObj, err := concatObjects(ctx, svc, 0, results, scratchBucket, tempKey, opts.Threads)
 	if err != nil {
 		return nil, err
 	}
 
-	finalObject, err := redistribute(ctx, svc, concatObj, beginningPad, opts.DstBucket, opts.DstKey, opts.storageClass, opts.ObjectTags)
+	finalObject, err := redistribute(ctx, svc, concatObj, beginningPad, opts.DstBucket, opts.DstKey, opts.storageClass, archiveTags(opts), archiveMetadata(opts), opts.Threads, noClobber(opts)...)
 	if err != nil {
 		return nil, err
 	}
@@ -404,19 +671,11 @@ func processLargeFiles(ctx context.Context, svc *s3.Client, objectList []*S3Obj,
 
 // redistribute will try to evenly distribute the object into equal size parts.
 // it will also trim whatever offset passed, helpful to remove the front padding
-func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset int64, bucket, key string, storageClass types.StorageClass, tagSet types.Tagging) (*S3Obj, error) {
+// The object is written with metadata by up to threads concurrent copies, optFns
+// are applied to the request that completes it.
+func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset int64, bucket, key string, storageClass types.StorageClass, tagSet types.Tagging, metadata map[string]string, threads int, optFns ...func(*s3.Options)) (*S3Obj, error) {
 	finalSize := *obj.Size - trimoffset
-	min, max, mid := findMinMaxPartRange(finalSize)
-	var r int64 = 0
-	for i := max; i >= min; i-- {
-		r = finalSize % i
-		if r == 0 {
-			mid = i
-			break
-		}
-	}
-
-	partSize := finalSize / mid
+	mid, partSize := redistributePartSize(finalSize)
 	Warnf(ctx, "redistribute calculations")
 	Warnf(ctx, "parts: %d", mid)
 	Warnf(ctx, "FinalSize:\t%d", finalSize)
@@ -449,7 +708,8 @@ func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset
 		Key:          aws.String(key),
 		StorageClass: storageClass,
 		Tagging:      &tags,
-		ACL:          types.ObjectCannedACLBucketOwnerFullControl,
+		ACL:          providerOf(ctx).acl(),
+		Metadata:     metadata,
 	})
 	if err != nil {
 		Infof(ctx, err.Error())
@@ -475,7 +735,9 @@ func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset
 					CopySourceRange: aws.String(copySourceRange),
 				}
 				Debugf(ctx, "UploadPartCopy (s3://%s/%s) into:\n\ts3://%s/%s", *input.Bucket, *input.Key, bucket, key)
-				rc, err := client.UploadPartCopy(ctx, &input)
+				partCtx, cancel := partContext(ctx)
+				defer cancel()
+				rc, err := client.UploadPartCopy(partCtx, &input)
 				if err != nil {
 					Debugf(ctx, "error for s3://%s/%s", *input.Bucket, *input.Key)
 					Debugf(ctx, "CopySourceRange %s", *input.CopySourceRange)
@@ -484,6 +746,8 @@ func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset
 				parts[i] = types.CompletedPart{
 					ETag:       rc.CopyPartResult.ETag,
 					PartNumber: input.PartNumber}
+				trackCopied(ctx, r.End-r.Start)
+				trackPartDone(ctx)
 				return nil
 			})
 		}
@@ -493,6 +757,7 @@ func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset
 		return parts, nil
 	}
 
+	trackParts(ctx, len(indexList))
 	parts, err := Redistribute(ctx, indexList)
 	if err != nil {
 		return nil, err
@@ -506,9 +771,13 @@ func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset
 		MultipartUpload: &types.CompletedMultipartUpload{
 			Parts: parts,
 		},
-	})
+	}, optFns...)
 	if err != nil {
 		Infof(ctx, err.Error())
+		err = destinationError(bucket, key, err)
+		if errors.Is(err, ErrDestinationExists) {
+			abortUpload(ctx, client, bucket, key, uploadId)
+		}
 		return nil, err
 	}
 	now := time.Now()
@@ -525,14 +794,30 @@ func redistribute(ctx context.Context, client *s3.Client, obj *S3Obj, trimoffset
 
 }
 
-func processSmallFiles(ctx context.Context, client *s3.Client, objectList []*S3Obj, headList []*s3.HeadObjectOutput, dstKey string, opts *S3TarS3Options) (*S3Obj, error) {
+// redistributePartSize picks the number of parts redistribute aims for and the
+// size of each part, preferring a part count that divides finalSize evenly.
+// Archives under the minimum part size are a single part.
+func redistributePartSize(finalSize int64) (int64, int64) {
+	if finalSize < fileSizeMin {
+		return 1, finalSize
+	}
+	min, max, mid := findMinMaxPartRange(finalSize)
+	for i := max; i >= min; i-- {
+		if finalSize%i == 0 {
+			mid = i
+			break
+		}
+	}
+	return mid, finalSize / mid
+}
+
+func processSmallFiles(ctx context.Context, client *s3.Client, rc *RecursiveConcat, objectList []*S3Obj, dstKey string, opts *S3TarS3Options) (*S3Obj, error) {
 
 	Debugf(ctx, "processSmallFiles path")
 
-	indexList, totalSize := createGroups(ctx, objectList)
+	indexList, totalSize := createGroups(ctx, objectList, opts)
 	eofPadding := generateLastBlock(totalSize, opts)
 	objectList = append(objectList, eofPadding)
-	headList = append(headList, nil)
 	indexList[len(indexList)-1].End = len(objectList) - 1
 
 	g := new(errgroup.Group)
@@ -540,18 +825,24 @@ func processSmallFiles(ctx context.Context, client *s3.Client, objectList []*S3O
 	groups := make([]*S3Obj, len(indexList))
 
 	Debugf(ctx, "Created %d parts", len(indexList))
+	trackParts(ctx, len(indexList))
+	sizes := make([]int64, len(indexList))
 	for i, p := range indexList {
-		i, p := i, p
+		sizes[i] = int64(p.Size)
+	}
+	for _, i := range partOrder(opts.PartOrder, sizes) {
+		i, p := i, indexList[i]
 		start := p.Start
 		end := p.End
 		Debugf(ctx, "Part %06d range: %d - %d", i+1, p.Start, p.End)
 		g.Go(func() error {
-			newPart, err := _processSmallFiles(ctx, objectList, headList, start, end, opts)
+			newPart, err := _processSmallFiles(ctx, client, rc, objectList, start, end, opts)
 			if err != nil {
 				return err
 			}
 			newPart.PartNum = start
 			groups[i] = newPart
+			trackPartDone(ctx)
 			return nil
 		})
 	}
@@ -575,6 +866,9 @@ func processSmallFiles(ctx context.Context, client *s3.Client, objectList []*S3O
 	}
 	groups[len(groups)-1].PartNum = len(groups) // setup the last PartNum since we skipped it
 
+	// the archive is assembled next to the parts and copied to dstKey at the end
+	scratchBucket, _ := scratchLocation(opts)
+	tempKey := scratchKey(opts, "output.temp")
 	finalObject := NewS3Obj()
 	if recursiveConcat {
 		padObject := &S3Obj{
@@ -596,39 +890,40 @@ func processSmallFiles(ctx context.Context, client *s3.Client, objectList []*S3O
 				trim = beginningPad
 			}
 			Debugf(ctx, "Concat(%s,%s)", *pair[0].Key, *pair[1].Key)
-			finalObject, err = concatObjects(ctx, client, trim, pair, opts.DstBucket, opts.DstKey)
+			finalObject, err = concatObjects(ctx, client, trim, pair, scratchBucket, tempKey, opts.Threads)
 			if err != nil {
-				fmt.Print(err.Error())
-				return NewS3Obj(), err
+				return NewS3Obj(), fmt.Errorf("concatenating %s and %s: %w", *pair[0].Key, *pair[1].Key, err)
 			}
 		}
 	} else {
 		var err error
-		finalObject, err = concatObjects(ctx, client, 0, groups, opts.DstBucket, opts.DstKey)
+		finalObject, err = concatObjects(ctx, client, 0, groups, scratchBucket, tempKey, opts.Threads)
 		if err != nil {
 			Debugf(ctx, "error recursion on final\n%s", err.Error())
 			return NewS3Obj(), err
 		}
 	}
 
-	return redistribute(ctx, client, finalObject, 0, opts.DstBucket, opts.DstKey, opts.storageClass, opts.ObjectTags)
+	return redistribute(ctx, client, finalObject, 0, opts.DstBucket, opts.DstKey, opts.storageClass, archiveTags(opts), archiveMetadata(opts), opts.Threads, noClobber(opts)...)
 
 }
 
-// _processSmallFiles processes a range of small files from the given objectList and headList.
+// _processSmallFiles processes a range of small files from the given objectList.
 // It generates tar headers for each file and concatenates them into a finalPart.
 // If a file does not require a tar header, it is appended directly to the parts list.
-// The headList is either the results of S3 HEAD requests or nil.
-//
-//	if present, the head is used to set POSIX file permissions, owner and group.
+// Files under opts.HybridThreshold are downloaded with the source client of client
+// and coalesced with their neighbours, see coalesceParts.
+// With PreservePOSIXMetadata the HEAD of every file is requested as its header
+// is generated and used to set POSIX file permissions, owner and group.
 //
 // The generated parts are then concatenated using the rc.ConcatObjects function.
 // The resulting finalPart is returned along with any error encountered during the process.
 //
 // Parameters:
 //   - ctx: The context.Context for the operation.
+//   - client: The *s3.Client the archive is written with.
+//   - rc: The RecursiveConcat of the run the parts are concatenated with.
 //   - objectList: A slice of S3Obj representing the list of objects to process.
-//   - headList: A slice of s3.HeadObjectOutput or nil, used to set permissions, uid and gid
 //   - start: The starting index of the range of files to process.
 //   - end: The ending index of the range of files to process.
 //   - opts: A pointer to S3TarS3Options containing the options for S3 operations.
@@ -636,8 +931,8 @@ func processSmallFiles(ctx context.Context, client *s3.Client, objectList []*S3O
 // Returns:
 //   - *S3Obj: The final concatenated part.
 //   - error: Any error encountered during the process.
-func _processSmallFiles(ctx context.Context, objectList []*S3Obj, headList []*s3.HeadObjectOutput, start, end int, opts *S3TarS3Options) (*S3Obj, error) {
-	parentPartsKey := filepath.Join(opts.DstPrefix, opts.DstKey+".parts")
+func _processSmallFiles(ctx context.Context, client *s3.Client, rc *RecursiveConcat, objectList []*S3Obj, start, end int, opts *S3TarS3Options) (*S3Obj, error) {
+	scratchBucket, parentPartsKey := scratchLocation(opts)
 	parts := []*S3Obj{}
 	for i, partNum := start, 0; i <= end; i, partNum = i+1, partNum+1 {
 		Debugf(ctx, "Processing: %s", *objectList[i].Key)
@@ -649,8 +944,12 @@ func _processSmallFiles(ctx context.Context, objectList []*S3Obj, headList []*s3
 			if (i - 1) >= 0 {
 				prev = objectList[i-1]
 			}
-			header := buildHeader(objectList[i], prev, false, headList[i])
-			header.Bucket = opts.DstBucket
+			head, err := objectHead(ctx, client, objectList[i], opts)
+			if err != nil {
+				return NewS3Obj(), err
+			}
+			header := buildHeader(objectList[i], prev, false, head)
+			header.Bucket = scratchBucket
 			pairs := []*S3Obj{&header, {
 				Object:  objectList[i].Object, // fix this
 				Bucket:  objectList[i].Bucket,
@@ -662,9 +961,17 @@ func _processSmallFiles(ctx context.Context, objectList []*S3Obj, headList []*s3
 
 	}
 
+	if opts.HybridThreshold > 0 {
+		var err error
+		parts, err = coalesceParts(ctx, sourceClient(client, opts), parts, opts)
+		if err != nil {
+			return NewS3Obj(), err
+		}
+	}
+
 	batchName := fmt.Sprintf("%d-%d", start, end)
 	dstKey := filepath.Join(parentPartsKey, strings.Join([]string{"iteration", "batch", batchName}, "."))
-	finalPart, err := rc.ConcatObjects(ctx, parts, opts.DstBucket, dstKey)
+	finalPart, err := rc.ConcatObjects(ctx, parts, scratchBucket, dstKey)
 	if err != nil {
 		Debugf(ctx, "%s", dstKey)
 		Debugf(ctx, "error recursion on final\n%s", err.Error())
@@ -676,46 +983,64 @@ func _processSmallFiles(ctx context.Context, objectList []*S3Obj, headList []*s3
 
 // findMinimumPartSize is for the case when we want to optimize as many parts
 // as possible. This is helpful to parallelize the workload even more.
-// findMinimumPartSize will start at 5MB and increment by 5MB until we're
-// within the 10,000 MPU part limit
-func findMinimumPartSize(finalSizeBytes, userMaxSize int64) int64 {
+// findMinimumPartSize will start at 5MB, or the minimum of the user in MB, and
+// increment by 5MB until we're within the MPU part limit of the provider,
+// 10,000 for Amazon S3. An archive that needs parts over the maximum of the
+// user, or of the provider, fails with ErrTooManyParts.
+func findMinimumPartSize(finalSizeBytes, userMinSize, userMaxSize int64, p Provider) (int64, error) {
 
 	const fiveMB = beginningPad
 	partSize := int64(fiveMB)
 
-	if userMaxSize > 0 {
-		partSize = userMaxSize * 1024 * 1024
+	if userMinSize > 0 {
+		partSize = userMinSize * 1024 * 1024
+	}
+	maxSize := p.maxPartSize()
+	if userMaxSize > 0 && userMaxSize*1024*1024 < maxSize {
+		maxSize = userMaxSize * 1024 * 1024
 	}
 
-	for ; partSize <= partSizeMax; partSize = partSize + fiveMB {
-		if finalSizeBytes/int64(partSize) < maxPartNumLimit {
-			break
+	for ; partSize <= maxSize; partSize = partSize + fiveMB {
+		if finalSizeBytes/int64(partSize) < int64(p.maxParts()) {
+			return partSize, nil
 		}
 	}
+	return 0, fmt.Errorf("%w: %s needs parts over %s to fit in %d parts", ErrTooManyParts, formatBytes(finalSizeBytes), formatBytes(maxSize), p.maxParts())
+}
 
-	if partSize > partSizeMax {
-		log.Fatal("part size maximum cannot exceed 5GiB")
+// validatePartSizes checks the minimum and the maximum part sizes of the user,
+// in MB, against the 5MB floor and the part size limit of the provider, and
+// the MPU threshold against the largest PutObject, the same limit.
+func validatePartSizes(opts *S3TarS3Options) error {
+	if opts.MPUThreshold < 0 || opts.MPUThreshold > opts.provider.maxPartSize() {
+		return fmt.Errorf("%w: the MPU threshold %s isn't between 0 and %s", ErrInvalidArgument, formatBytes(opts.MPUThreshold), formatBytes(opts.provider.maxPartSize()))
 	}
-
-	return partSize
+	limit := opts.provider.maxPartSize() / 1024 / 1024
+	for _, size := range []struct {
+		name string
+		mb   int64
+	}{{"minimum", opts.UserMinPartSize}, {"maximum", opts.UserMaxPartSize}} {
+		if size.mb != 0 && (size.mb < beginningPad/1024/1024 || size.mb > limit) {
+			return fmt.Errorf("%w: the %s part size %dMB isn't between %dMB and %dMB", ErrInvalidArgument, size.name, size.mb, beginningPad/1024/1024, limit)
+		}
+	}
+	if opts.UserMinPartSize > 0 && opts.UserMaxPartSize > 0 && opts.UserMinPartSize > opts.UserMaxPartSize {
+		return fmt.Errorf("%w: the minimum part size %dMB is over the maximum %dMB", ErrInvalidArgument, opts.UserMinPartSize, opts.UserMaxPartSize)
+	}
+	return nil
 }
 
-// estimateFinalSize takes the total of all object
-// then multiplies the number of objects by the header size
-// then multiplies 512 by every object (the padding -- worst case scenario)
+// estimateFinalSize adds up the encoded tar header, the data and the block
+// padding of every object in the list.
 func estimateFinalSize(objectList []*S3Obj) int64 {
-	headerSize := paxTarHeaderSize
-	if tarFormat == tar.FormatGNU {
-		headerSize = gnuTarHeaderSize
-	}
 	estimatedSize := int64(0)
 	for _, o := range objectList {
-		estimatedSize += *o.Size + int64(headerSize+blockSize)
+		estimatedSize += tarHeaderSize(objectHeader(o)) + *o.Size + findPadding(*o.Size)
 	}
 	return estimatedSize
 }
 
-func createGroups(ctx context.Context, objectList []*S3Obj) ([]Index, int64) {
+func createGroups(ctx context.Context, objectList []*S3Obj, opts *S3TarS3Options) ([]Index, int64) {
 
 	// Walk through all the parts and build groups of 500MB
 	// so we can parallelize.
@@ -723,7 +1048,10 @@ func createGroups(ctx context.Context, objectList []*S3Obj) ([]Index, int64) {
 	last := 0
 
 	estimatedSize := estimateFinalSize(objectList)
-	partSize := findMinimumPartSize(estimatedSize, 0)
+	partSize, err := findMinimumPartSize(estimatedSize, 0, 0, opts.provider)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 	Infof(ctx, "estimated final size: %d bytes (with headers + padding)\nmultipart part-size: %d bytes\n", estimatedSize, partSize)
 
 	// passing nil for head, header is only used to estimate size, so permissions are not needed
@@ -763,12 +1091,13 @@ func createGroups(ctx context.Context, objectList []*S3Obj) ([]Index, int64) {
 	return indexList, totalSize
 }
 
-func concatObjects(ctx context.Context, client *s3.Client, trimFirstBytes int, objectList []*S3Obj, bucket, key string) (*S3Obj, error) {
+func concatObjects(ctx context.Context, client *s3.Client, trimFirstBytes int, objectList []*S3Obj, bucket, key string, threads int) (*S3Obj, error) {
 	complete := NewS3Obj()
 	output, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
-		Bucket: &bucket,
-		Key:    &key,
-		ACL:    types.ObjectCannedACLBucketOwnerFullControl,
+		Bucket:   &bucket,
+		Key:      &key,
+		ACL:      providerOf(ctx).acl(),
+		Metadata: runMetadata(ctx),
 	})
 	if err != nil {
 		return complete, err
@@ -777,70 +1106,89 @@ func concatObjects(ctx context.Context, client *s3.Client, trimFirstBytes int, o
 	uploadId := *output.UploadId
 	var parts []types.CompletedPart
 	m := sync.RWMutex{}
+	var copyErr error
 	swg := sizedwaitgroup.New(threads)
+	partNum := int32(0)
 	for i, object := range objectList {
-		partNum := int32(i + 1)
 		if len(object.Data) > 0 {
+			partNum++
 			accumSize += int64(len(object.Data))
 			input := &s3.UploadPartInput{
 				Bucket:     &bucket,
 				Key:        &key,
-				PartNumber: &partNum,
+				PartNumber: aws.Int32(partNum),
 				UploadId:   &uploadId,
 				Body:       io.ReadSeeker(bytes.NewReader(object.Data)),
 			}
 			swg.Add()
-			go func(input *s3.UploadPartInput) {
+			go func(input *s3.UploadPartInput, size int64) {
 				defer swg.Done()
 				Debugf(ctx, "UploadPart (bytes) into: %s/%s", *input.Bucket, *input.Key)
-				r, err := client.UploadPart(ctx, input)
+				partCtx, cancel := partContext(ctx)
+				defer cancel()
+				r, err := client.UploadPart(partCtx, input)
 				if err != nil {
 					Debugf(ctx, "error for s3://%s/%s", *input.Bucket, *input.Key)
 					panic(err)
 				}
+				trackUploaded(ctx, size)
 				m.Lock()
 				parts = append(parts, types.CompletedPart{
 					ETag:       r.ETag,
 					PartNumber: input.PartNumber})
 				m.Unlock()
-			}(input)
+			}(input, int64(len(object.Data)))
 		} else {
-			var copySourceRange string
+			start := int64(0)
 			if i == 0 && trimFirstBytes > 0 {
-				copySourceRange = fmt.Sprintf("bytes=%d-%d", trimFirstBytes, *object.Size-1)
-				accumSize += *object.Size - int64(trimFirstBytes)
-			} else {
-				copySourceRange = fmt.Sprintf("bytes=0-%d", *object.Size-1)
-				accumSize += *object.Size
+				start = int64(trimFirstBytes)
 			}
 			sourceKey := object.Bucket + "/" + url.QueryEscape(*object.Key)
-			input := s3.UploadPartCopyInput{
-				Bucket:          &bucket,
-				Key:             &key,
-				PartNumber:      &partNum,
-				UploadId:        &uploadId,
-				CopySource:      aws.String(sourceKey),
-				CopySourceRange: aws.String(copySourceRange),
-			}
-			swg.Add()
-			go func(input s3.UploadPartCopyInput) {
-				defer swg.Done()
-				Debugf(ctx, "UploadPartCopy (s3://%s/%s) into:\n\ts3://%s/%s", *input.Bucket, *input.Key, bucket, key)
-				r, err := client.UploadPartCopy(ctx, &input)
-				if err != nil {
-					Debugf(ctx, "error for s3://%s/%s", *input.Bucket, *input.Key)
-					panic(err)
+			// objects over the part size limit are copied in several ranges
+			for _, rng := range copyRanges(start, *object.Size) {
+				partNum++
+				copySize := rng[1] - rng[0]
+				accumSize += copySize
+				input := s3.UploadPartCopyInput{
+					Bucket:            &bucket,
+					Key:               &key,
+					PartNumber:        aws.Int32(partNum),
+					UploadId:          &uploadId,
+					CopySource:        aws.String(sourceKey),
+					CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", rng[0], rng[1]-1)),
+					CopySourceIfMatch: ifMatch(object),
 				}
-				m.Lock()
-				parts = append(parts, types.CompletedPart{
-					ETag:       r.CopyPartResult.ETag,
-					PartNumber: input.PartNumber})
-				m.Unlock()
-			}(input)
+				swg.Add()
+				go func(input s3.UploadPartCopyInput, size int64) {
+					defer swg.Done()
+					Debugf(ctx, "UploadPartCopy (s3://%s/%s) into:\n\ts3://%s/%s", *input.Bucket, *input.Key, bucket, key)
+					partCtx, cancel := partContext(ctx)
+					defer cancel()
+					r, err := client.UploadPartCopy(partCtx, &input)
+					if err != nil {
+						Debugf(ctx, "error for s3://%s/%s", *input.Bucket, *input.Key)
+						m.Lock()
+						if copyErr == nil {
+							copyErr = fmt.Errorf("copying %s: %w", *input.CopySource, classifyError(err))
+						}
+						m.Unlock()
+						return
+					}
+					trackCopied(ctx, size)
+					m.Lock()
+					parts = append(parts, types.CompletedPart{
+						ETag:       r.CopyPartResult.ETag,
+						PartNumber: input.PartNumber})
+					m.Unlock()
+				}(input, copySize)
+			}
 		}
 	}
 
 	swg.Wait()
+	if copyErr != nil {
+		return complete, copyErr
+	}
 	sort.Slice(parts, func(i, j int) bool {
 		return *parts[i].PartNumber < *parts[j].PartNumber
 	})
@@ -0,0 +1,210 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/sync/errgroup"
+)
+
+// salvageScanSize is how much of the archive is read at once when looking for
+// the next header after a corrupt region.
+const salvageScanSize = 8 * 1024 * 1024
+
+// RecoveredMember is a member found by Salvage.
+type RecoveredMember struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	Size  int64  `json:"size"`
+}
+
+// RecoveryReport is the result of Salvage. Extracted are the members before
+// the corruption, Recoverable the members found after it by looking for the
+// next valid header, which are listed but not extracted.
+type RecoveryReport struct {
+	Archive       string             `json:"archive"`
+	CorruptOffset int64              `json:"corrupt_offset"`
+	Problem       string             `json:"problem,omitempty"`
+	Extracted     []*RecoveredMember `json:"extracted"`
+	Recoverable   []*RecoveredMember `json:"recoverable"`
+}
+
+// recoveryReportLocation is where the recovery report of the archive is
+// written, next to the archive unless location is set.
+func recoveryReportLocation(opts *S3TarS3Options, location string) string {
+	if location != "" {
+		return location
+	}
+	return fmt.Sprintf("s3://%s/%s.recovery.json", opts.SrcBucket, opts.SrcKey)
+}
+
+// Salvage extracts the members of a corrupt archive found before the first
+// corrupt offset to opts.DstBucket and opts.DstPrefix, or the local directory
+// opts.DstPath, and looks for members after it by scanning for the next valid
+// header. The recovery report is written to location, by default next to the
+// archive.
+func Salvage(ctx context.Context, svc *s3.Client, options *S3TarS3Options, location string, optFns ...func(*S3TarS3Options)) (*RecoveryReport, error) {
+	opts := options.Copy()
+	if err := checkExtractArgs(&opts); err != nil {
+		return nil, err
+	}
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	src := sourceClient(svc, &opts)
+	bucket, key := opts.SrcBucket, opts.SrcKey
+	archive, err := headArchive(ctx, src, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	// a compressed archive would be scanned for headers to the end in vain
+	format, err := readFormat(archive)
+	if err != nil {
+		return nil, err
+	}
+	if format != "" && !isTarFormat(format) {
+		return nil, unsupportedFormat(objectURL(bucket, key), format, "only uncompressed tar archives can be salvaged")
+	}
+	report, err := salvagePlan(ctx, archive.readAt, archive.size)
+	if err != nil {
+		return nil, err
+	}
+	report.Archive = fmt.Sprintf("s3://%s/%s", bucket, key)
+	if report.CorruptOffset < 0 {
+		Infof(ctx, "%s is valid, extracting every member", report.Archive)
+	} else {
+		Warnf(ctx, "%s is corrupt at offset %d: %s", report.Archive, report.CorruptOffset, report.Problem)
+	}
+
+	ctx, stopProgress := startProgress(ctx, opts.ProgressFn)
+	defer stopProgress()
+	if opts.DstPath != "" {
+		members := make(TOC, len(report.Extracted))
+		for i, m := range report.Extracted {
+			members[i] = &FileMetadata{Filename: m.Name, Start: m.Start, Size: m.Size}
+		}
+		if err := extractLocal(ctx, svc, archive, members, &opts); err != nil {
+			return report, err
+		}
+	} else {
+		names := make([]string, len(report.Extracted))
+		for i, m := range report.Extracted {
+			names[i] = m.Name
+		}
+		keys, err := extractKeys(ctx, svc, names, &opts)
+		if err != nil {
+			return report, err
+		}
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(opts.Threads)
+		for i, m := range report.Extracted {
+			m, dstKey := m, keys[i]
+			if dstKey == "" {
+				continue
+			}
+			trackParts(gctx, 1)
+			g.Go(func() error {
+				return extractRange(gctx, svc, archive, opts.DstBucket, dstKey, m.Start, m.Size, &opts)
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return report, err
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return report, err
+	}
+	location = recoveryReportLocation(&opts, location)
+	if err := saveFile(ctx, svc, location, append(data, '\n')); err != nil {
+		return report, fmt.Errorf("writing recovery report %s: %w", location, err)
+	}
+	Infof(ctx, "recovery report written to %s", location)
+	return report, nil
+}
+
+// salvagePlan validates the archive and returns the members to extract and
+// the ones found after the corruption.
+func salvagePlan(ctx context.Context, readAt func(offset, n int64) ([]byte, error), size int64) (*RecoveryReport, error) {
+	validation, err := validateArchive(ctx, readAt, size)
+	if err != nil {
+		return nil, err
+	}
+	report := &RecoveryReport{
+		CorruptOffset: validation.CorruptOffset,
+		Problem:       validation.Problem,
+		Extracted:     []*RecoveredMember{},
+		Recoverable:   []*RecoveredMember{},
+	}
+	for i, m := range validation.Toc {
+		if i == 0 && m.Filename == "toc.csv" {
+			continue
+		}
+		if !validation.Valid() && m.Start+m.Size > validation.CorruptOffset {
+			break
+		}
+		report.Extracted = append(report.Extracted, &RecoveredMember{Name: m.Filename, Start: m.Start, Size: m.Size})
+	}
+	if validation.Valid() {
+		return report, nil
+	}
+
+	from := validation.CorruptOffset + findPadding(validation.CorruptOffset)
+	if from == validation.CorruptOffset {
+		from += blockSize
+	}
+	for from+blockSize <= size {
+		offset, err := nextHeader(readAt, size, from)
+		if err != nil {
+			return nil, err
+		}
+		if offset < 0 {
+			break
+		}
+		segment := &ValidationReport{Size: size, CorruptOffset: -1}
+		end, err := walkHeaders(ctx, readAt, segment, offset)
+		if err != nil {
+			return nil, err
+		}
+		Debugf(ctx, "found %d members from offset %d", len(segment.Toc), offset)
+		for _, m := range segment.Toc {
+			report.Recoverable = append(report.Recoverable, &RecoveredMember{Name: m.Filename, Start: m.Start, Size: m.Size})
+		}
+		from = end + blockSize
+	}
+	return report, nil
+}
+
+// nextHeader returns the offset of the first block from offset on that is a
+// valid ustar header, or -1 when there is none.
+func nextHeader(readAt func(offset, n int64) ([]byte, error), size, offset int64) (int64, error) {
+	for offset+blockSize <= size {
+		n := size - offset
+		if n > salvageScanSize {
+			n = salvageScanSize
+		}
+		n -= n % blockSize
+		data, err := readAt(offset, n)
+		if err != nil {
+			return -1, err
+		}
+		for i := int64(0); i+blockSize <= int64(len(data)); i += blockSize {
+			block := data[i : i+blockSize]
+			if !bytes.HasPrefix(block[257:], []byte("ustar")) {
+				continue
+			}
+			if _, _, err := parseHeaderBlock(block); err == nil {
+				return offset + i, nil
+			}
+		}
+		offset += n
+	}
+	return -1, nil
+}
@@ -0,0 +1,80 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSalvagePlan(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	valid := testArchive(t, nil, 100, 1024, 0, 700)
+	// toc.csv header, toc.csv, file0.txt header, file0.txt, file1.txt header...
+	file1 := int64(4 * blockSize)
+	file2 := file1 + blockSize + 1024
+
+	names := func(members []*RecoveredMember) []string {
+		var s []string
+		for _, m := range members {
+			s = append(s, m.Name)
+		}
+		return s
+	}
+	tests := []struct {
+		name        string
+		corrupt     func(d []byte) []byte
+		extracted   []string
+		recoverable []string
+	}{
+		{
+			name:      "valid",
+			corrupt:   func(d []byte) []byte { return d },
+			extracted: []string{"file0.txt", "file1.txt", "file2.txt", "file3.txt"},
+		},
+		{
+			name: "corrupt header",
+			corrupt: func(d []byte) []byte {
+				copy(d[file1:file1+blockSize], make([]byte, 100))
+				d[file1+200] = 'x'
+				return d
+			},
+			extracted:   []string{"file0.txt"},
+			recoverable: []string{"file2.txt", "file3.txt"},
+		},
+		{
+			name: "corrupt data and header",
+			corrupt: func(d []byte) []byte {
+				for i := file1 + blockSize + 100; i < file2+100; i++ {
+					d[i] = 'z'
+				}
+				return d
+			},
+			extracted:   []string{"file0.txt", "file1.txt"},
+			recoverable: []string{"file3.txt"},
+		},
+		{
+			name:        "truncated",
+			corrupt:     func(d []byte) []byte { return d[:file2+blockSize+blockSize+100] },
+			extracted:   []string{"file0.txt", "file1.txt", "file2.txt"},
+			recoverable: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.corrupt(append([]byte{}, valid...))
+			report, err := salvagePlan(ctx, readAtBytes(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(report.Extracted); !reflect.DeepEqual(got, tt.extracted) {
+				t.Errorf("salvagePlan() Extracted = %v, want %v", got, tt.extracted)
+			}
+			if got := names(report.Recoverable); !reflect.DeepEqual(got, tt.recoverable) {
+				t.Errorf("salvagePlan() Recoverable = %v, want %v", got, tt.recoverable)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// cleanUpTimeout bounds the clean up of the intermediate objects, which also
+// runs after the context of the run is canceled.
+const cleanUpTimeout = 5 * time.Minute
+
+// validateScratch checks opts.Scratch is an s3:// url.
+func validateScratch(opts *S3TarS3Options) error {
+	if opts.Scratch == "" {
+		return nil
+	}
+	if !strings.HasPrefix(opts.Scratch, "s3://") {
+		return fmt.Errorf("%w: the scratch location %q must be an s3:// url", ErrInvalidArgument, opts.Scratch)
+	}
+	if bucket, _ := ExtractBucketAndPath(opts.Scratch); bucket == "" {
+		return fmt.Errorf("%w: the scratch location %q has no bucket", ErrInvalidArgument, opts.Scratch)
+	}
+	return nil
+}
+
+// scratchLocation returns the bucket and the prefix the intermediate objects
+// of the archive are written to, <archive>.parts/<run id> next to the archive
+// or under opts.Scratch. Only that prefix is cleaned up, archives and runs can
+// share a scratch location.
+func scratchLocation(opts *S3TarS3Options) (string, string) {
+	bucket, prefix := opts.DstBucket, filepath.Join(opts.DstPrefix, opts.DstKey+".parts")
+	if opts.Scratch != "" {
+		bucket, prefix = ExtractBucketAndPath(opts.Scratch)
+		prefix = filepath.Join(prefix, opts.DstKey+".parts")
+	}
+	return bucket, filepath.Join(prefix, opts.RunID)
+}
+
+// scratchKey is the key of the intermediate object name of the archive, in the
+// bucket returned by scratchLocation.
+func scratchKey(opts *S3TarS3Options, name ...string) string {
+	_, prefix := scratchLocation(opts)
+	return filepath.Join(append([]string{prefix}, name...)...)
+}
+
+// detachedContext keeps the values of its parent, the logger and the client,
+// without its deadline and cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// cleanUp deletes the intermediate objects of the archive and aborts the
+// multipart uploads left under the scratch prefix, also when ctx is canceled,
+// unless opts.KeepScratch is set.
+func cleanUp(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) {
+	bucket, prefix := scratchLocation(opts)
+	if opts.KeepScratch {
+		Infof(ctx, "keeping the intermediate objects at s3://%s/%s/", bucket, prefix)
+		return
+	}
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, cleanUpTimeout)
+	defer cancel()
+
+	Infof(ctx, "deleting all intermediate objects")
+	scratchDirs := []string{prefix + "/"}
+	if opts.Scratch == "" {
+		scratchDirs = append(scratchDirs, filepath.Join(opts.DstPrefix, opts.DstKey, "headers"))
+	}
+	for _, path := range scratchDirs {
+		if path == "" || path == "/" {
+			continue
+		}
+		deleteList, _, _ := ListAllObjects(ctx, svc, bucket, path)
+		err := deleteObjectList(ctx, svc, opts, deleteList)
+		if err != nil {
+			Warnf(ctx, "Unable to delete intermediate objects at: %s %s", bucket, path)
+		}
+	}
+	abortUploads(ctx, svc, bucket, prefix+"/")
+}
+
+// abortUploads aborts the multipart uploads in progress under prefix, those of
+// merges interrupted by a failure or a cancellation.
+func abortUploads(ctx context.Context, svc *s3.Client, bucket, prefix string) {
+	p := s3.NewListMultipartUploadsPaginator(svc, &s3.ListMultipartUploadsInput{Bucket: &bucket, Prefix: &prefix})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			Warnf(ctx, "unable to list the multipart uploads at s3://%s/%s: %s", bucket, prefix, err.Error())
+			return
+		}
+		for _, upload := range page.Uploads {
+			abortUpload(ctx, svc, bucket, *upload.Key, *upload.UploadId)
+		}
+	}
+}
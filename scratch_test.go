@@ -0,0 +1,67 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScratchLocation(t *testing.T) {
+	tests := []struct {
+		opts       S3TarS3Options
+		wantBucket string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{
+			opts:       S3TarS3Options{DstBucket: "dst", DstPrefix: "archives", DstKey: "archives/a.tar"},
+			wantBucket: "dst",
+			wantPrefix: "archives/archives/a.tar.parts",
+		},
+		{
+			opts:       S3TarS3Options{DstBucket: "dst", DstPrefix: "archives", DstKey: "archives/a.tar", Scratch: "s3://tmp/run-1/"},
+			wantBucket: "tmp",
+			wantPrefix: "run-1/archives/a.tar.parts",
+		},
+		{
+			opts:       S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", Scratch: "s3://tmp"},
+			wantBucket: "tmp",
+			wantPrefix: "a.tar.parts",
+		},
+		{
+			opts:       S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", Scratch: "s3://tmp/scratch", RunID: "20261017T120000Z-0a1b2c3d"},
+			wantBucket: "tmp",
+			wantPrefix: "scratch/a.tar.parts/20261017T120000Z-0a1b2c3d",
+		},
+		{opts: S3TarS3Options{Scratch: "/tmp/run-1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := validateScratch(&tt.opts); (err != nil) != tt.wantErr {
+			t.Errorf("validateScratch(%q) error = %v, wantErr %v", tt.opts.Scratch, err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		bucket, prefix := scratchLocation(&tt.opts)
+		if bucket != tt.wantBucket || prefix != tt.wantPrefix {
+			t.Errorf("scratchLocation(%q) = %s, %s, want %s, %s", tt.opts.Scratch, bucket, prefix, tt.wantBucket, tt.wantPrefix)
+		}
+		if got, want := scratchKey(&tt.opts, "output.temp"), tt.wantPrefix+"/output.temp"; got != want {
+			t.Errorf("scratchKey() = %s, want %s", got, want)
+		}
+	}
+}
+
+func TestDetachedContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey("test"), "value"))
+	cancel()
+	detached := detachedContext{ctx}
+	if detached.Err() != nil || detached.Done() != nil {
+		t.Errorf("detachedContext is canceled with its parent")
+	}
+	if detached.Value(contextKey("test")) != "value" {
+		t.Errorf("detachedContext lost the values of its parent")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// TocQuery selects members of a TOC, see SearchToc.
+type TocQuery struct {
+	// Patterns are shell patterns, as path.Match, a member matches when one
+	// of them matches its name or, for patterns without a /, its base name.
+	// Every member matches when there are none.
+	Patterns []string
+	// Exclude are shell patterns as Patterns, a member that one of them
+	// matches doesn't match.
+	Exclude []string
+	// MinSize is the smallest size of the members that match.
+	MinSize int64
+	// MaxSize is the largest size of the members that match, no limit when 0.
+	MaxSize int64
+}
+
+// SearchToc returns the members of toc that match q, in the order of the
+// archive, so a member can be found without extracting anything.
+func SearchToc(toc TOC, q TocQuery) (TOC, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	var found TOC
+	for _, m := range toc {
+		if q.match(m.Filename, m.Size) {
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+func (q TocQuery) validate() error {
+	for _, p := range append(append([]string{}, q.Patterns...), q.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("%w: pattern %q: %w", ErrInvalidArgument, p, err)
+		}
+	}
+	if q.MinSize < 0 || q.MaxSize < 0 || (q.MaxSize > 0 && q.MaxSize < q.MinSize) {
+		return fmt.Errorf("%w: invalid size range %d to %d", ErrInvalidArgument, q.MinSize, q.MaxSize)
+	}
+	return nil
+}
+
+// match reports whether the member name of size bytes matches q.
+func (q TocQuery) match(name string, size int64) bool {
+	if size < q.MinSize || (q.MaxSize > 0 && size > q.MaxSize) {
+		return false
+	}
+	if len(q.Patterns) > 0 && !matchesAny(name, q.Patterns) {
+		return false
+	}
+	return !matchesAny(name, q.Exclude)
+}
+
+// ExtractFilter selects the members Extract extracts, see WithExtractFilter.
+type ExtractFilter struct {
+	TocQuery
+	// NewerThan and OlderThan bound the modification time of the members,
+	// read from their headers, without a bound when zero.
+	NewerThan time.Time
+	OlderThan time.Time
+}
+
+// WithExtractFilter makes Extract extract only the members that match f,
+// among the ones under its prefix.
+func WithExtractFilter(f ExtractFilter) func(*S3TarS3Options) {
+	return func(opts *S3TarS3Options) {
+		opts.extractFilter = &f
+	}
+}
+
+// filterMembers returns the members that match f, in the order of the
+// archive. The TOC has no modification times, with a time bound the header
+// of every member that matches the rest of f is read, threads at a time.
+func filterMembers(ctx context.Context, archive *archiveReader, members TOC, f *ExtractFilter, threads int) (TOC, error) {
+	if f == nil {
+		return members, nil
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	members, err := SearchToc(members, f.TocQuery)
+	if err != nil || f.NewerThan.IsZero() && f.OlderThan.IsZero() {
+		return members, err
+	}
+	keep := make([]bool, len(members))
+	g, _ := errgroup.WithContext(ctx)
+	g.SetLimit(threads)
+	for i, m := range members {
+		i, m := i, m
+		g.Go(func() error {
+			hdr, _, err := archive.headerEnding(m.Start)
+			if err != nil {
+				return fmt.Errorf("reading the modification time of %s: %w", m.Filename, err)
+			}
+			keep[i] = f.matchTime(hdr.ModTime)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	var found TOC
+	for i, m := range members {
+		if keep[i] {
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+func (f *ExtractFilter) validate() error {
+	if !f.NewerThan.IsZero() && !f.OlderThan.IsZero() && !f.OlderThan.After(f.NewerThan) {
+		return fmt.Errorf("%w: nothing is newer than %s and older than %s", ErrInvalidArgument, f.NewerThan.Format(time.RFC3339), f.OlderThan.Format(time.RFC3339))
+	}
+	return f.TocQuery.validate()
+}
+
+// matchTime reports whether a member modified at t is within the time
+// bounds of f.
+func (f *ExtractFilter) matchTime(t time.Time) bool {
+	return (f.NewerThan.IsZero() || t.After(f.NewerThan)) && (f.OlderThan.IsZero() || t.Before(f.OlderThan))
+}
+
+// ParseTimeBound parses the time of --newer-than and --older-than: a date as
+// ParseMtime, or an age before now such as 36h, 90m or 7d.
+func ParseTimeBound(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	t, err := ParseMtime(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: time %q must be an age such as 36h or 7d, RFC 3339, YYYY-MM-DD or @seconds", ErrInvalidArgument, s)
+	}
+	return t, nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, _ := path.Match(p, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseSize parses a size in bytes or with a KB, MB, GB or TB suffix, powers
+// of 1000, or KiB, MiB, GiB or TiB, powers of 1024.
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		unit   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	}
+	value, unit := strings.TrimSpace(s), int64(1)
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(value, u.suffix); ok {
+			value, unit = strings.TrimSpace(v), u.unit
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return 0, fmt.Errorf("%w: invalid size %q", ErrInvalidArgument, s)
+	}
+	return n * unit, nil
+}
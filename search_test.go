@@ -0,0 +1,179 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchToc(t *testing.T) {
+	toc := TOC{
+		{Filename: "reports/2024-01.csv", Size: 2_000_000},
+		{Filename: "reports/small.csv", Size: 10},
+		{Filename: "images/a.jpg", Size: 5_000_000},
+		{Filename: "top.csv", Size: 3_000_000},
+	}
+	tests := map[string]struct {
+		query   TocQuery
+		want    []string
+		wantErr bool
+	}{
+		"everything":      {query: TocQuery{}, want: []string{"reports/2024-01.csv", "reports/small.csv", "images/a.jpg", "top.csv"}},
+		"base name":       {query: TocQuery{Patterns: []string{"*.csv"}}, want: []string{"reports/2024-01.csv", "reports/small.csv", "top.csv"}},
+		"full name":       {query: TocQuery{Patterns: []string{"reports/*"}}, want: []string{"reports/2024-01.csv", "reports/small.csv"}},
+		"pattern and min": {query: TocQuery{Patterns: []string{"*.csv"}, MinSize: 1_000_000}, want: []string{"reports/2024-01.csv", "top.csv"}},
+		"two patterns":    {query: TocQuery{Patterns: []string{"*.jpg", "top.*"}}, want: []string{"images/a.jpg", "top.csv"}},
+		"size range":      {query: TocQuery{MinSize: 1_000_000, MaxSize: 2_500_000}, want: []string{"reports/2024-01.csv"}},
+		"exclude":         {query: TocQuery{Patterns: []string{"*.csv"}, Exclude: []string{"reports/*"}}, want: []string{"top.csv"}},
+		"bad exclude":     {query: TocQuery{Exclude: []string{"[a"}}, wantErr: true},
+		"no match":        {query: TocQuery{Patterns: []string{"*.parquet"}}},
+		"bad pattern":     {query: TocQuery{Patterns: []string{"[a"}}, wantErr: true},
+		"bad range":       {query: TocQuery{MinSize: 10, MaxSize: 5}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			found, err := SearchToc(toc, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SearchToc() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidArgument) {
+					t.Errorf("SearchToc() error = %v, want ErrInvalidArgument", err)
+				}
+				return
+			}
+			var names []string
+			for _, m := range found {
+				names = append(names, m.Filename)
+			}
+			if len(names) != len(tt.want) {
+				t.Fatalf("SearchToc() = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("SearchToc() = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]struct {
+		want    int64
+		wantErr bool
+	}{
+		"0":      {want: 0},
+		"512":    {want: 512},
+		"1MB":    {want: 1_000_000},
+		"512KiB": {want: 512 << 10},
+		"2 GiB":  {want: 2 << 30},
+		"1TB":    {want: 1_000_000_000_000},
+		"10B":    {want: 10},
+		"-1":     {wantErr: true},
+		"1XB":    {wantErr: true},
+		"MB":     {wantErr: true},
+	}
+	for s, tt := range tests {
+		got, err := ParseSize(s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", s, got, err, tt.want)
+		}
+	}
+}
+
+func TestFilterMembers(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	var toc TOC
+	for _, m := range []struct {
+		name    string
+		size    int
+		modTime time.Time
+	}{
+		{"etc/app.conf", 10, day(20)},
+		{"etc/old.conf", 10, day(1)},
+		{"data/big.bin", 4000, day(20)},
+		{"etc/mid.conf", 10, day(10)},
+	} {
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0600, Size: int64(m.size), ModTime: m.modTime, Format: tar.FormatUSTAR}); err != nil {
+			t.Fatal(err)
+		}
+		toc = append(toc, &FileMetadata{Filename: m.name, Start: int64(buf.Len()), Size: int64(m.size)})
+		tw.Write(bytes.Repeat([]byte{'a'}, m.size))
+		tw.Flush()
+	}
+	tw.Close()
+
+	tests := map[string]struct {
+		filter  *ExtractFilter
+		want    []string
+		wantErr error
+	}{
+		"none":         {want: []string{"etc/app.conf", "etc/old.conf", "data/big.bin", "etc/mid.conf"}},
+		"newer":        {filter: &ExtractFilter{NewerThan: day(5)}, want: []string{"etc/app.conf", "data/big.bin", "etc/mid.conf"}},
+		"older":        {filter: &ExtractFilter{OlderThan: day(15)}, want: []string{"etc/old.conf", "etc/mid.conf"}},
+		"between":      {filter: &ExtractFilter{NewerThan: day(5), OlderThan: day(15)}, want: []string{"etc/mid.conf"}},
+		"recent small": {filter: &ExtractFilter{TocQuery: TocQuery{MaxSize: 100}, NewerThan: day(15)}, want: []string{"etc/app.conf"}},
+		"pattern":      {filter: &ExtractFilter{TocQuery: TocQuery{Patterns: []string{"*.conf"}}, OlderThan: day(2)}, want: []string{"etc/old.conf"}},
+		"empty range":  {filter: &ExtractFilter{NewerThan: day(15), OlderThan: day(5)}, wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ranges [][2]int64
+			got, err := filterMembers(context.Background(), bytesArchive(buf.Bytes(), &ranges), toc, tt.filter, 2)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("filterMembers() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, m := range got {
+				names = append(names, m.Filename)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("filterMembers() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		"days":     {value: "7d", want: time.Date(2024, 6, 8, 12, 0, 0, 0, time.UTC)},
+		"duration": {value: "36h", want: time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC)},
+		"date":     {value: "2024-01-02", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		"rfc 3339": {value: "2024-01-02T03:04:05Z", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		"epoch":    {value: "@1700000000", want: time.Unix(1700000000, 0).UTC()},
+		"negative": {value: "-7d", wantErr: true},
+		"invalid":  {value: "last week", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseTimeBound(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeBound(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("ParseTimeBound(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// An archive written by Recompress is in the seekable format of zstd
+// (contrib/seekable_format in the zstd repository): the tar is compressed in
+// independent frames of seekableFrameSize bytes, followed by a skippable
+// frame, ignored by other decompressors, with the compressed and
+// decompressed size of every frame. A range of the tar is read by
+// decompressing only the frames that hold it, so the archive is listed and
+// its members extracted with range requests as an uncompressed one.
+const (
+	seekableFrameSize = 4 * 1024 * 1024
+	// seekTableMagic is the magic of the skippable frame of the seek table.
+	seekTableMagic = 0x184d2a5e
+	// seekableMagic ends the footer of the seek table.
+	seekableMagic = 0x8f92eab1
+	// seekFooterSize is the size of the footer: the number of frames, the
+	// descriptor and seekableMagic.
+	seekFooterSize = 9
+	// seekChecksumFlag is the bit of the descriptor set when every entry
+	// has a checksum of the frame.
+	seekChecksumFlag = 0x80
+)
+
+// seekFrame is a frame of a seekable archive: where it is in the object and
+// the range of the tar it holds.
+type seekFrame struct {
+	compressedOffset int64
+	compressedSize   int64
+	offset           int64
+	size             int64
+}
+
+type seekTable []seekFrame
+
+// size is the size of the tar.
+func (t seekTable) size() int64 {
+	if len(t) == 0 {
+		return 0
+	}
+	last := t[len(t)-1]
+	return last.offset + last.size
+}
+
+// add records a frame of compressedSize bytes holding size bytes of the tar
+// after the ones before it.
+func (t seekTable) add(compressedSize, size int64) seekTable {
+	var frame seekFrame
+	if len(t) > 0 {
+		last := t[len(t)-1]
+		frame.compressedOffset = last.compressedOffset + last.compressedSize
+		frame.offset = last.offset + last.size
+	}
+	frame.compressedSize, frame.size = compressedSize, size
+	return append(t, frame)
+}
+
+// marshal returns the skippable frame of the seek table, without checksums.
+func (t seekTable) marshal() []byte {
+	data := make([]byte, 8, 8+8*len(t)+seekFooterSize)
+	binary.LittleEndian.PutUint32(data, seekTableMagic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(8*len(t)+seekFooterSize))
+	for _, f := range t {
+		data = binary.LittleEndian.AppendUint32(data, uint32(f.compressedSize))
+		data = binary.LittleEndian.AppendUint32(data, uint32(f.size))
+	}
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(t)))
+	data = append(data, 0)
+	return binary.LittleEndian.AppendUint32(data, seekableMagic)
+}
+
+// readSeekTable reads the seek table at the end of an object of size bytes
+// with readAt, nil when the object doesn't end with one.
+func readSeekTable(readAt func(offset, n int64) ([]byte, error), size int64) (seekTable, error) {
+	if size < 8+seekFooterSize {
+		return nil, nil
+	}
+	footer, err := readAt(size-seekFooterSize, seekFooterSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(footer) != seekFooterSize || binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, nil
+	}
+	frames := int64(binary.LittleEndian.Uint32(footer))
+	entrySize := int64(8)
+	if footer[4]&seekChecksumFlag != 0 {
+		entrySize = 12
+	}
+	tableSize := 8 + frames*entrySize + seekFooterSize
+	if tableSize > size {
+		return nil, fmt.Errorf("%w: a seek table of %d frames in %d bytes", ErrInvalidArchive, frames, size)
+	}
+	data, err := readAt(size-tableSize, tableSize)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != tableSize || binary.LittleEndian.Uint32(data) != seekTableMagic ||
+		int64(binary.LittleEndian.Uint32(data[4:])) != tableSize-8 {
+		return nil, fmt.Errorf("%w: the seek table of %d frames has no skippable frame header", ErrInvalidArchive, frames)
+	}
+	table := make(seekTable, 0, frames)
+	for entry := data[8 : tableSize-seekFooterSize]; len(entry) > 0; entry = entry[entrySize:] {
+		table = table.add(int64(binary.LittleEndian.Uint32(entry)), int64(binary.LittleEndian.Uint32(entry[4:])))
+	}
+	if len(table) == 0 {
+		return nil, nil
+	}
+	if end := table[len(table)-1]; end.compressedOffset+end.compressedSize != size-tableSize {
+		return nil, fmt.Errorf("%w: the frames of the seek table end at %d, the table starts at %d", ErrInvalidArchive, end.compressedOffset+end.compressedSize, size-tableSize)
+	}
+	return table, nil
+}
+
+// openSeekable returns a reader of the tar compressed in s3://bucket/key
+// when it's a seekable archive, nil when it isn't.
+func openSeekable(ctx context.Context, client *s3.Client, bucket, key string) (*archiveReader, error) {
+	raw, err := headArchive(ctx, client, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	table, err := readSeekTable(raw.readAt, raw.size)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", objectURL(bucket, key), err)
+	}
+	if len(table) == 0 {
+		return nil, nil
+	}
+	Debugf(ctx, "%s is seekable, %d frames of %d bytes", objectURL(bucket, key), len(table), table.size())
+	r := newArchiveReader(ctx, client, bucket, key, table.size())
+	r.modTime = raw.modTime
+	r.frames = table
+	r.get = func(start, end int64) (io.ReadCloser, error) {
+		return readFrames(ctx, client, bucket, key, table, start, end)
+	}
+	return r, nil
+}
+
+// readFrames returns the bytes of the tar from start to end, included,
+// decompressed from the frames of table that hold them, read with a single
+// range request.
+func readFrames(ctx context.Context, client *s3.Client, bucket, key string, table seekTable, start, end int64) (io.ReadCloser, error) {
+	if end >= table.size() {
+		end = table.size() - 1
+	}
+	first := sort.Search(len(table), func(i int) bool { return table[i].offset+table[i].size > start })
+	last := sort.Search(len(table), func(i int) bool { return table[i].offset > end }) - 1
+	if start > end || first > last {
+		return io.NopCloser(&io.LimitedReader{}), nil
+	}
+	body, err := getObjectRange(ctx, client, bucket, key, table[first].compressedOffset, table[last].compressedOffset+table[last].compressedSize-1)
+	if err != nil {
+		return nil, err
+	}
+	d, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, d, start-table[first].offset); err != nil {
+		d.Close()
+		body.Close()
+		return nil, fmt.Errorf("%w: decompressing s3://%s/%s: %w", ErrInvalidArchive, bucket, key, err)
+	}
+	return &frameReader{Reader: io.LimitReader(d, end-start+1), decoder: d, body: body}, nil
+}
+
+// frameReader reads the decompressed frames of a seekable archive.
+type frameReader struct {
+	io.Reader
+	decoder *zstd.Decoder
+	body    io.Closer
+}
+
+func (r *frameReader) Close() error {
+	r.decoder.Close()
+	return r.body.Close()
+}
+
+// extractFrames uploads the member of the seekable archive at start with
+// size bytes to s3://dstBucket/dstKey, decompressed from the frames that
+// hold it.
+func extractFrames(ctx context.Context, svc *s3.Client, archive *archiveReader, dstBucket, dstKey string, start, size int64, opts *S3TarS3Options) error {
+	var metadata map[string]string
+	if opts.PreservePOSIXMetadata {
+		if hdr, _, err := archive.headerEnding(start); err != nil {
+			Warnf(ctx, "unable to extract tar header for %s, cannot set permissions", dstKey)
+		} else {
+			metadata = posixMetadata(hdr)
+		}
+	}
+	body := io.NopCloser(&io.LimitedReader{})
+	if size > 0 {
+		var err error
+		if body, err = archive.get(start, start+size-1); err != nil {
+			return classifyError(err)
+		}
+	}
+	defer body.Close()
+	if err := putStream(ctx, svc, dstBucket, dstKey, body, size, metadata); err != nil {
+		return err
+	}
+	trackCopied(ctx, size)
+	trackPartDone(ctx)
+	Infof(ctx, "x %s", objectURL(dstBucket, dstKey))
+	return nil
+}
@@ -0,0 +1,362 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestReadSeekTable(t *testing.T) {
+	var table seekTable
+	table = table.add(100, seekableFrameSize).add(50, 1000)
+	frames := bytes.Repeat([]byte{0}, 150)
+	// the entries of a table with checksums are 12 bytes long
+	withChecksums := binary.LittleEndian.AppendUint32(nil, seekTableMagic)
+	withChecksums = binary.LittleEndian.AppendUint32(withChecksums, 2*12+seekFooterSize)
+	for _, f := range table {
+		withChecksums = binary.LittleEndian.AppendUint32(withChecksums, uint32(f.compressedSize))
+		withChecksums = binary.LittleEndian.AppendUint32(withChecksums, uint32(f.size))
+		withChecksums = binary.LittleEndian.AppendUint32(withChecksums, 0xdeadbeef)
+	}
+	withChecksums = binary.LittleEndian.AppendUint32(withChecksums, 2)
+	withChecksums = append(withChecksums, seekChecksumFlag)
+	withChecksums = binary.LittleEndian.AppendUint32(withChecksums, seekableMagic)
+
+	tests := map[string]struct {
+		data    []byte
+		want    seekTable
+		wantErr error
+	}{
+		"seekable":       {data: append(append([]byte{}, frames...), table.marshal()...), want: table},
+		"with checksums": {data: append(append([]byte{}, frames...), withChecksums...), want: table},
+		"not seekable":   {data: compressedArchive(t, compressionZstd, [][2]string{{"a.txt", "a"}})},
+		"short":          {data: []byte{1, 2, 3}},
+		"frames missing": {data: append(append([]byte{}, frames[:100]...), table.marshal()...), wantErr: ErrInvalidArchive},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			readAt := func(offset, n int64) ([]byte, error) {
+				return tt.data[offset : offset+n], nil
+			}
+			got, err := readSeekTable(readAt, int64(len(tt.data)))
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readSeekTable() error = %v, want %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("readSeekTable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeStore is an Amazon S3 of the objects it holds, keyed by bucket/key,
+// with multipart uploads. The settings of the buckets aren't found and the
+// objects of failing can't be read nor copied to. uploaded is called for
+// every part.
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[int][]byte
+	failing map[string]bool
+	// etags are the ETags of the objects, "etag" when they have none.
+	etags map[string]string
+	// metadata is the user metadata of the objects.
+	metadata map[string]map[string]string
+	uploaded func()
+}
+
+func (f *fakeStore) client() *s3.Client {
+	do := doFunc(func(req *http.Request) (*http.Response, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		name := strings.TrimPrefix(req.URL.Path, "/")
+		q := req.URL.Query()
+		header := http.Header{"Etag": {`"etag"`}}
+		if etag, ok := f.etags[name]; ok {
+			header.Set("Etag", etag)
+		}
+		status, body := http.StatusOK, []byte(nil)
+		switch op := fakeOperation(req); {
+		case op == "ListObjectsV2":
+			var keys []string
+			for k := range f.objects {
+				if strings.HasPrefix(k, name+"/"+q.Get("prefix")) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			var contents string
+			for _, k := range keys {
+				contents += fmt.Sprintf("<Contents><Key>%s</Key><Size>%d</Size></Contents>", strings.TrimPrefix(k, name+"/"), len(f.objects[k]))
+			}
+			body = []byte(fmt.Sprintf(`<ListBucketResult><KeyCount>%d</KeyCount>%s</ListBucketResult>`, len(keys), contents))
+		case (op == "GetObject" || op == "UploadPartCopy") && f.failing[name]:
+			status, body = http.StatusInternalServerError, []byte(`<Error><Code>InternalError</Code></Error>`)
+		case strings.HasPrefix(op, "GetBucket"), op == "GetObjectLockConfiguration":
+			status, body = http.StatusNotFound, []byte(`<Error><Code>NoSuchConfiguration</Code></Error>`)
+		case op == "PutObject":
+			f.objects[name], _ = io.ReadAll(req.Body)
+		case op == "CreateMultipartUpload":
+			f.parts[name] = map[int][]byte{}
+			body = []byte(`<InitiateMultipartUploadResult><UploadId>` + name + `</UploadId></InitiateMultipartUploadResult>`)
+		case op == "UploadPartCopy":
+			src, _ := url.PathUnescape(strings.TrimPrefix(req.Header.Get("X-Amz-Copy-Source"), "/"))
+			data := f.objects[src]
+			var start, end int64
+			if _, err := fmt.Sscanf(req.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); err == nil {
+				data = data[start : end+1]
+			}
+			var n int
+			fmt.Sscan(q.Get("partNumber"), &n)
+			f.parts[name][n] = data
+			body = []byte(`<CopyPartResult><ETag>"etag"</ETag></CopyPartResult>`)
+		case op == "UploadPart":
+			var n int
+			fmt.Sscan(q.Get("partNumber"), &n)
+			f.parts[name][n], _ = io.ReadAll(req.Body)
+			if f.uploaded != nil {
+				f.uploaded()
+			}
+		case op == "POST" && q.Has("uploadId"):
+			var data []byte
+			for n := 1; n <= len(f.parts[name]); n++ {
+				data = append(data, f.parts[name][n]...)
+			}
+			f.objects[name] = data
+			bucket, key, _ := strings.Cut(name, "/")
+			body = []byte(`<CompleteMultipartUploadResult><Bucket>` + bucket + `</Bucket><Key>` + key + `</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+		case op == "AbortMultipartUpload":
+			delete(f.parts, name)
+		case op == "DeleteObject":
+			delete(f.objects, name)
+		case req.Method == http.MethodHead, op == "GetObject":
+			data, ok := f.objects[name]
+			if !ok {
+				status, body = http.StatusNotFound, []byte(`<Error><Code>NoSuchKey</Code></Error>`)
+				break
+			}
+			header.Set("Last-Modified", time.Unix(1700000000, 0).UTC().Format(http.TimeFormat))
+			for k, v := range f.metadata[name] {
+				header.Set("X-Amz-Meta-"+k, v)
+			}
+			body = data
+			var start, end int64
+			if _, err := fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+				if end >= int64(len(data)) {
+					end = int64(len(data)) - 1
+				}
+				status, body = http.StatusPartialContent, data[start:end+1]
+				header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			}
+		}
+		header.Set("Content-Length", fmt.Sprint(len(body)))
+		if req.Method == http.MethodHead {
+			body = nil
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(bytes.NewReader(body)), ContentLength: int64(len(body)), Request: req}, nil
+	})
+	return s3.New(s3.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String("http://s3.local"),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   do,
+		Retryer:      aws.NopRetryer{},
+	})
+}
+
+// tarWithToc returns an uncompressed tar of members, name and data, and the
+// csv TOC of its members kept next to it.
+func tarWithToc(t *testing.T, members [][2]string) ([]byte, []byte) {
+	var buf, toc bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m[0], Mode: 0640, Size: int64(len(m[1])), ModTime: time.Unix(1700000000, 0), Typeflag: tar.TypeReg, Format: tar.FormatUSTAR}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&toc, "%s,%d,%d,etag\n", m[0], buf.Len(), len(m[1]))
+		tw.Write([]byte(m[1]))
+		tw.Flush()
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), toc.Bytes()
+}
+
+func TestRecompress(t *testing.T) {
+	// big.bin spans the first two frames, b.txt is in the middle of the second
+	big := strings.Repeat("0123456789abcdef", seekableFrameSize/16+1000)
+	members := [][2]string{{"dir/big.bin", big}, {"dir/b.txt", "hello"}, {"c.txt", "world"}}
+	data, toc := tarWithToc(t, members)
+	store := &fakeStore{objects: map[string][]byte{"src/a.tar": data, "src/a.tar.toc.csv": toc}, parts: map[string]map[int][]byte{}}
+	client := store.client()
+	ctx := context.Background()
+
+	report, err := Recompress(ctx, client, &S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", DstKey: "a.tar.zst", Threads: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFrames := (len(data) + seekableFrameSize - 1) / seekableFrameSize
+	if report.Frames != wantFrames || report.Size != int64(len(data)) || report.CompressedSize != int64(len(store.objects["dst/a.tar.zst"])) {
+		t.Errorf("report = %+v, want %d frames of %d bytes", report, wantFrames, len(data))
+	}
+	// any zstd decompressor reads it, the seek table is skipped
+	d, err := zstd.NewReader(bytes.NewReader(store.objects["dst/a.tar.zst"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(d)
+	d.Close()
+	if err != nil || !bytes.Equal(decompressed, data) {
+		t.Fatalf("decompressed %d bytes, want the %d of the archive: %v", len(decompressed), len(data), err)
+	}
+	// the TOC next to the archive has the frame of every member
+	framed, err := parseTocCSV(bytes.NewReader(store.objects["dst/a.tar.zst.toc.csv"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantToc, err := parseTocCSV(bytes.NewReader(toc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := readSeekTable(func(offset, n int64) ([]byte, error) {
+		return store.objects["dst/a.tar.zst"][offset : offset+n], nil
+	}, int64(len(store.objects["dst/a.tar.zst"])))
+	if err != nil || len(table) < 2 {
+		t.Fatalf("readSeekTable() = %d frames, %v", len(table), err)
+	}
+	frames := []int64{0, table[1].compressedOffset, table[1].compressedOffset}
+	if len(framed) != len(wantToc) {
+		t.Fatalf("TOC next to the archive has %d lines, want %d", len(framed), len(wantToc))
+	}
+	for i, m := range framed {
+		if m.Filename != wantToc[i].Filename || m.Start != wantToc[i].Start || m.FrameOffset == nil || *m.FrameOffset != frames[i] {
+			t.Errorf("TOC line %d = %+v, want %+v in the frame at %d", i+1, m, wantToc[i], frames[i])
+		}
+	}
+
+	list, err := List(ctx, client, "dst", "a.tar.zst", &S3TarS3Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(members) || list[1].Filename != "dir/b.txt" {
+		t.Errorf("List() = %v, want %d members", list, len(members))
+	}
+
+	dir := t.TempDir()
+	opts := &S3TarS3Options{SrcBucket: "dst", SrcKey: "a.tar.zst", DstPath: dir, Threads: 2}
+	if err := Extract(ctx, client, "dir/", opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range members[:2] {
+		got, err := os.ReadFile(filepath.Join(dir, m[0]))
+		if err != nil || string(got) != m[1] {
+			t.Errorf("%s = %d bytes, want %d: %v", m[0], len(got), len(m[1]), err)
+		}
+	}
+	opts = &S3TarS3Options{SrcBucket: "dst", SrcKey: "a.tar.zst", DstBucket: "out", DstPrefix: "x", Threads: 2}
+	if err := Extract(ctx, client, "", opts); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k, v := range store.objects {
+		if strings.HasPrefix(k, "out/") {
+			keys = append(keys, fmt.Sprintf("%s=%d", k, len(v)))
+		}
+	}
+	sort.Strings(keys)
+	want := fmt.Sprintf("out/x/c.txt=5,out/x/dir/b.txt=5,out/x/dir/big.bin=%d", len(big))
+	if strings.Join(keys, ",") != want {
+		t.Errorf("extracted %v, want %s", keys, want)
+	}
+}
+
+func TestSeekableMemberExtract(t *testing.T) {
+	// x.txt is between two members of three frames each of data that doesn't
+	// compress
+	random := func(n int) string {
+		data := make([]byte, n)
+		rand.New(rand.NewSource(int64(n))).Read(data)
+		return string(data)
+	}
+	members := [][2]string{{"a.bin", random(3 * seekableFrameSize)}, {"x.txt", "hello"}, {"b.bin", random(3*seekableFrameSize + 1)}}
+	data, toc := tarWithToc(t, members)
+	store := &fakeStore{objects: map[string][]byte{"src/a.tar": data, "src/a.tar.toc.csv": toc}, parts: map[string]map[int][]byte{}}
+	ctx := context.Background()
+	if _, err := Recompress(ctx, store.client(), &S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", DstKey: "a.tar.zst", Threads: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var read int64
+	client := s3.New(store.client().Options(), func(o *s3.Options) {
+		next := o.HTTPClient
+		o.HTTPClient = doFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.Do(req)
+			if err == nil && req.Method == http.MethodGet && req.URL.Path == "/dst/a.tar.zst" {
+				mu.Lock()
+				read += resp.ContentLength
+				mu.Unlock()
+			}
+			return resp, err
+		})
+	})
+	dir := t.TempDir()
+	if err := Extract(ctx, client, "x.txt", &S3TarS3Options{SrcBucket: "dst", SrcKey: "a.tar.zst", DstPath: dir, Threads: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "x.txt")); err != nil || string(got) != "hello" {
+		t.Fatalf("x.txt = %q, %v", got, err)
+	}
+	// the first frame, for the format, and the one of x.txt are read
+	if size := int64(len(store.objects["dst/a.tar.zst"])); read == 0 || read > size/2 {
+		t.Errorf("extracting x.txt read %d bytes of the %d of the archive", read, size)
+	}
+}
+
+func TestRecompressArgs(t *testing.T) {
+	store := &fakeStore{objects: map[string][]byte{
+		"src/a.tar.gz": compressedArchive(t, compressionGzip, [][2]string{{"a.txt", "a"}}),
+	}, parts: map[string]map[int][]byte{}}
+	tests := map[string]struct {
+		opts    S3TarS3Options
+		wantErr error
+	}{
+		"compressed":    {opts: S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar.gz", DstBucket: "dst", DstKey: "a.tar.zst"}, wantErr: ErrUnsupportedFormat},
+		"same object":   {opts: S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "src", DstKey: "a.tar"}, wantErr: ErrInvalidArgument},
+		"level":         {opts: S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst", DstKey: "a.tar.zst", CompressionLevel: 23}, wantErr: ErrInvalidArgument},
+		"local":         {opts: S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstPath: "/tmp"}, wantErr: ErrInvalidArgument},
+		"missing key":   {opts: S3TarS3Options{SrcBucket: "src", SrcKey: "a.tar", DstBucket: "dst"}, wantErr: ErrInvalidArgument},
+		"missing input": {opts: S3TarS3Options{DstBucket: "dst", DstKey: "a.tar.zst"}, wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Recompress(context.Background(), store.client(), &tt.opts); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Recompress() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// maxServedArchives is the number of archives whose TOC a handler keeps,
+	// the least recently requested is dropped first.
+	maxServedArchives = 64
+	// archiveRecheck is how long the HEAD of a kept archive is trusted before
+	// its ETag is checked again.
+	archiveRecheck = 30 * time.Second
+)
+
+// archiveHandler serves the members of the archives under a prefix, see
+// NewArchiveHandler.
+type archiveHandler struct {
+	ctx     context.Context
+	client  *s3.Client
+	bucket  string
+	prefix  string
+	recheck time.Duration
+	max     int
+
+	mu sync.Mutex
+	// archives are the elements of lru by key, the most recently requested
+	// archive is at the front.
+	archives map[string]*list.Element
+	lru      *list.List
+}
+
+// servedArchive is an archive kept by a handler, its TOC read for the ETag
+// of the HEAD made at checked.
+type servedArchive struct {
+	key     string
+	fsys    *ArchiveFS
+	checked time.Time
+}
+
+// NewArchiveHandler returns an http.Handler that serves GET and HEAD
+// /<archive>/<member>, where <archive> is the key of an archive under
+// s3://bucket/prefix and <member> a member of its TOC. A member is read with
+// a single range request of the archive, the Range header of the request is
+// passed through as the range of the member it reads, and If-None-Match is
+// answered from the ETag of the member. A path that ends with a / lists a
+// directory of the archive. The TOC of the last archives requested is kept,
+// the HEAD of an archive is made again after a while and its TOC read again
+// when its ETag changed.
+func NewArchiveHandler(ctx context.Context, client *s3.Client, bucket, prefix string) http.Handler {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &archiveHandler{
+		ctx:      ctx,
+		client:   client,
+		bucket:   bucket,
+		prefix:   prefix,
+		recheck:  archiveRecheck,
+		max:      maxServedArchives,
+		archives: map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (h *archiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fsys, key, name, err := h.open(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		Warnf(h.ctx, "serve: %s: %s", r.URL.Path, err)
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	Debugf(h.ctx, "serve: %s %s member %q of s3://%s/%s", r.Method, r.URL.Path, name, h.bucket, key)
+	if name == "." || strings.HasSuffix(r.URL.Path, "/") {
+		h.serveDir(w, r, fsys, name)
+		return
+	}
+	m, ok := fsys.files[name]
+	if !ok {
+		if _, ok := fsys.dirs[name]; ok {
+			http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	h.serveMember(w, r, fsys, m)
+}
+
+// open returns the archive p starts with, its key and the name of the member
+// that follows it. Archives can be under any number of prefixes, the shortest
+// key that is an archive is used, one that was already opened first.
+func (h *archiveHandler) open(p string) (*ArchiveFS, string, string, error) {
+	type candidate struct{ key, name string }
+	var candidates []candidate
+	for i := 1; i <= len(p); i++ {
+		if i == len(p) {
+			candidates = append(candidates, candidate{h.prefix + p, "."})
+		} else if p[i] == '/' {
+			name := path.Clean("/" + p[i+1:])[1:]
+			if name == "" {
+				name = "."
+			}
+			candidates = append(candidates, candidate{h.prefix + p[:i], name})
+		}
+	}
+	// the archives kept are tried first
+	h.mu.Lock()
+	var kept []candidate
+	for _, c := range candidates {
+		if _, ok := h.archives[c.key]; ok {
+			kept = append(kept, c)
+		}
+	}
+	h.mu.Unlock()
+
+	err := fmt.Errorf("%w: no archive in %q", ErrNotFound, p)
+	tried := map[string]bool{}
+	for _, c := range append(kept, candidates...) {
+		if tried[c.key] {
+			continue
+		}
+		tried[c.key] = true
+		fsys, openErr := h.archive(c.key)
+		switch {
+		case openErr == nil:
+			return fsys, c.key, c.name, nil
+		case errors.Is(openErr, ErrInvalidArchive):
+			err = openErr
+		case !errors.Is(openErr, ErrNotFound):
+			return nil, "", "", openErr
+		}
+	}
+	return nil, "", "", err
+}
+
+// archive returns the archive key, the one kept while the ETag of its HEAD
+// is the same. The HEAD of a kept archive is trusted for h.recheck.
+func (h *archiveHandler) archive(key string) (*ArchiveFS, error) {
+	h.mu.Lock()
+	if e, ok := h.archives[key]; ok {
+		h.lru.MoveToFront(e)
+		if a := e.Value.(*servedArchive); time.Since(a.checked) < h.recheck {
+			h.mu.Unlock()
+			return a.fsys, nil
+		}
+	}
+	h.mu.Unlock()
+
+	archive, err := headArchive(h.ctx, h.client, h.bucket, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			h.remove(key)
+		}
+		return nil, err
+	}
+	h.mu.Lock()
+	if e, ok := h.archives[key]; ok {
+		if a := e.Value.(*servedArchive); a.fsys.archive.etag == archive.etag {
+			a.checked = time.Now()
+			h.mu.Unlock()
+			return a.fsys, nil
+		}
+	}
+	h.mu.Unlock()
+
+	toc, err := readToc(h.ctx, h.client, archive)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	fsys := newArchiveFS(archive, toc)
+	Infof(h.ctx, "serve: opened s3://%s/%s, %d members", h.bucket, key, len(fsys.files))
+	h.add(key, fsys)
+	return fsys, nil
+}
+
+// add keeps fsys as the archive key, dropping the least recently requested
+// archives over h.max.
+func (h *archiveHandler) add(key string, fsys *ArchiveFS) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.archives[key]; ok {
+		h.lru.Remove(e)
+	}
+	h.archives[key] = h.lru.PushFront(&servedArchive{key: key, fsys: fsys, checked: time.Now()})
+	for h.lru.Len() > h.max {
+		oldest := h.lru.Remove(h.lru.Back()).(*servedArchive)
+		delete(h.archives, oldest.key)
+	}
+}
+
+// remove drops the archive key, when it's kept.
+func (h *archiveHandler) remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.archives[key]; ok {
+		h.lru.Remove(e)
+		delete(h.archives, key)
+	}
+}
+
+// serveMember writes m, or the range of it the request asks for, read with a
+// single range request of the archive.
+func (h *archiveHandler) serveMember(w http.ResponseWriter, r *http.Request, fsys *ArchiveFS, m *FileMetadata) {
+	contentType := mime.TypeByExtension(path.Ext(m.Filename))
+	if contentType == "" {
+		contentType = m.ContentType
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Type", contentType)
+	// the ETags of the TOC are quoted already, those of older TOCs aren't
+	etag := ""
+	if m.Etag != "" {
+		etag = `"` + strings.Trim(m.Etag, `"`) + `"`
+		header.Set("ETag", etag)
+	}
+	if !fsys.archive.modTime.IsZero() {
+		header.Set("Last-Modified", fsys.archive.modTime.UTC().Format(http.TimeFormat))
+	}
+	if etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	start, length, status := int64(0), m.Size, http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		var ok bool
+		start, length, ok = parseRange(rangeHeader, m.Size)
+		if !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", m.Size))
+			http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if length != m.Size {
+			status = http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, m.Size))
+		}
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead || length == 0 {
+		w.WriteHeader(status)
+		return
+	}
+	body, err := getObjectRange(r.Context(), h.client, h.bucket, fsys.archive.key, m.Start+start, m.Start+start+length-1)
+	if err != nil {
+		err = classifyError(err)
+		Warnf(h.ctx, "serve: %s: %s", r.URL.Path, err)
+		header.Del("Content-Range")
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	defer body.Close()
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, body, length); err != nil {
+		Debugf(h.ctx, "serve: %s: %s", r.URL.Path, err)
+	}
+}
+
+// serveDir writes an HTML list of the entries of the directory name.
+func (h *archiveHandler) serveDir(w http.ResponseWriter, r *http.Request, fsys *ArchiveFS, name string) {
+	entries, err := fsys.ReadDir(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "<!doctype html>\n<pre>\n")
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+		link := url.URL{Path: n}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(link.String()), html.EscapeString(n))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// parseRange parses a Range header of a member of size bytes into the start
+// and the length it reads. A header with several ranges reads the whole
+// member, as servers may answer those.
+func parseRange(s string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	if strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if first == "" {
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+// etagMatches reports whether the If-None-Match header ifNoneMatch matches
+// etag, with the weak comparison it's made with.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// httpStatus is the status of the response to a request that failed with err.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidArchive):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+// ServeArchives serves the archives under s3://bucket/prefix on addr with
+// NewArchiveHandler until ctx is done.
+func ServeArchives(ctx context.Context, client *s3.Client, addr, bucket, prefix string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           NewArchiveHandler(ctx, client, bucket, prefix),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	Infof(ctx, "serving s3://%s/%s on %s", bucket, prefix, addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-done
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := map[string]struct {
+		header      string
+		size        int64
+		start, want int64
+		ok          bool
+	}{
+		"range":           {header: "bytes=10-19", size: 100, start: 10, want: 10, ok: true},
+		"open ended":      {header: "bytes=90-", size: 100, start: 90, want: 10, ok: true},
+		"suffix":          {header: "bytes=-5", size: 100, start: 95, want: 5, ok: true},
+		"suffix too long": {header: "bytes=-500", size: 100, start: 0, want: 100, ok: true},
+		"past the end":    {header: "bytes=90-200", size: 100, start: 90, want: 10, ok: true},
+		"several ranges":  {header: "bytes=0-1,5-6", size: 100, start: 0, want: 100, ok: true},
+		"start too large": {header: "bytes=100-", size: 100},
+		"inverted":        {header: "bytes=20-10", size: 100},
+		"empty member":    {header: "bytes=-5", size: 0},
+		"not bytes":       {header: "items=0-1", size: 100},
+		"no dash":         {header: "bytes=10", size: 100},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			start, length, ok := parseRange(tt.header, tt.size)
+			if ok != tt.ok || (ok && (start != tt.start || length != tt.want)) {
+				t.Errorf("parseRange(%q, %d) = %d, %d, %v, want %d, %d, %v", tt.header, tt.size, start, length, ok, tt.start, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestArchiveHandler(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	data, toc := tarWithToc(t, [][2]string{{"dir/b.txt", "hello world"}, {"c.txt", "abc"}})
+	// the ETags of a TOC are quoted, as the ETags of Amazon S3
+	toc = bytes.ReplaceAll(toc, []byte(",etag\n"), []byte(`,"""etag"""`+"\n"))
+	store := &fakeStore{objects: map[string][]byte{"bucket/archives/a.tar": data, "bucket/archives/a.tar.toc.csv": toc}, etags: map[string]string{}}
+	h := NewArchiveHandler(ctx, store.client(), "bucket", "archives")
+
+	tests := map[string]struct {
+		path   string
+		header http.Header
+		status int
+		body   string
+		want   http.Header
+	}{
+		"member":         {path: "/a.tar/dir/b.txt", status: http.StatusOK, body: "hello world", want: http.Header{"Etag": {`"etag"`}, "Content-Length": {"11"}}},
+		"range":          {path: "/a.tar/dir/b.txt", header: http.Header{"Range": {"bytes=6-"}}, status: http.StatusPartialContent, body: "world", want: http.Header{"Content-Range": {"bytes 6-10/11"}}},
+		"invalid range":  {path: "/a.tar/c.txt", header: http.Header{"Range": {"bytes=5-"}}, status: http.StatusRequestedRangeNotSatisfiable, want: http.Header{"Content-Range": {"bytes */3"}}},
+		"if-none-match":  {path: "/a.tar/c.txt", header: http.Header{"If-None-Match": {`W/"other", "etag"`}}, status: http.StatusNotModified},
+		"changed":        {path: "/a.tar/c.txt", header: http.Header{"If-None-Match": {`"other"`}}, status: http.StatusOK, body: "abc"},
+		"directory":      {path: "/a.tar/", status: http.StatusOK, body: `<a href="c.txt">c.txt</a>`},
+		"missing member": {path: "/a.tar/x.txt", status: http.StatusNotFound},
+		"missing":        {path: "/b.tar/x.txt", status: http.StatusNotFound},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			}
+			if tt.body != "" && !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("GET %s body = %q, want %q", tt.path, rec.Body.String(), tt.body)
+			}
+			for k := range tt.want {
+				if got := rec.Header().Get(k); got != tt.want.Get(k) {
+					t.Errorf("GET %s %s = %s, want %s", tt.path, k, got, tt.want.Get(k))
+				}
+			}
+		})
+	}
+
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Body.String()
+	}
+	// the archive is replaced, its TOC is read again when its HEAD is checked
+	h.(*archiveHandler).recheck = 0
+	data, toc = tarWithToc(t, [][2]string{{"c.txt", "new content"}})
+	store.objects["bucket/archives/a.tar"], store.objects["bucket/archives/a.tar.toc.csv"] = data, toc
+	store.etags["bucket/archives/a.tar"] = `"v2"`
+	if got := get("/a.tar/c.txt"); got != "new content" {
+		t.Errorf("GET /a.tar/c.txt of the new ETag = %q, want new content", got)
+	}
+
+	// the least recently requested archive is dropped
+	h.(*archiveHandler).max = 1
+	store.objects["bucket/archives/b.tar"], store.objects["bucket/archives/b.tar.toc.csv"] = data, toc
+	if got := get("/b.tar/c.txt"); got != "new content" {
+		t.Errorf("GET /b.tar/c.txt = %q", got)
+	}
+	if _, ok := h.(*archiveHandler).archives["archives/a.tar"]; ok || len(h.(*archiveHandler).archives) != 1 {
+		t.Errorf("the handler keeps %d archives, want only b.tar", len(h.(*archiveHandler).archives))
+	}
+}
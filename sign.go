@@ -0,0 +1,238 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TocAttestation is the statement signed by --sign-toc: the archive, as it
+// was written, and the digest of its TOC.
+type TocAttestation struct {
+	Archive string `json:"archive"`
+	ETag    string `json:"etag"`
+	Size    int64  `json:"size"`
+	// Toc is where the TOC was read, toc.csv for the member of the archive or
+	// the url of the TOC next to it.
+	Toc       string    `json:"toc"`
+	TocSHA256 string    `json:"toc_sha256"`
+	SignedAt  time.Time `json:"signed_at"`
+}
+
+// TocSignature is the signature of a TocAttestation by an asymmetric AWS KMS
+// key, written next to the archive to <archive>.toc.sig. Payload is the
+// signed statement, as it was signed.
+type TocSignature struct {
+	Payload   []byte `json:"payload"`
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	Signature []byte `json:"signature"`
+}
+
+// kmsAPI is the part of the AWS KMS client used to sign and verify TOCs.
+type kmsAPI interface {
+	DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+	Verify(ctx context.Context, params *kms.VerifyInput, optFns ...func(*kms.Options)) (*kms.VerifyOutput, error)
+}
+
+// newKMSClient returns an AWS KMS client in the region and with the
+// credentials of svc.
+func newKMSClient(svc *s3.Client) *kms.Client {
+	o := svc.Options()
+	return kms.New(kms.Options{Region: o.Region, Credentials: o.Credentials, HTTPClient: o.HTTPClient, Logger: o.Logger})
+}
+
+// tocSignatureLocation is where the signature of the TOC of an archive is
+// written: next to the archive.
+func tocSignatureLocation(bucket, key string) string {
+	return fmt.Sprintf("s3://%s/%s.toc.sig", bucket, key)
+}
+
+// signatureDigest returns the digest of payload that algorithm signs, which
+// AWS KMS is given instead of the payload so it can be of any size.
+func signatureDigest(algorithm kmstypes.SigningAlgorithmSpec, payload []byte) ([]byte, error) {
+	var h hash.Hash
+	switch {
+	case strings.HasSuffix(string(algorithm), "_SHA_256"):
+		h = sha256.New()
+	case strings.HasSuffix(string(algorithm), "_SHA_384"):
+		h = sha512.New384()
+	case strings.HasSuffix(string(algorithm), "_SHA_512"):
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("%w: signing algorithm %s isn't supported", ErrInvalidArgument, algorithm)
+	}
+	h.Write(payload)
+	return h.Sum(nil), nil
+}
+
+// signToc signs att with the asymmetric KMS key keyID, with the first signing
+// algorithm of the key signatureDigest supports.
+func signToc(ctx context.Context, client kmsAPI, keyID string, att *TocAttestation) (*TocSignature, error) {
+	key, err := client.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("describing the key %s: %w", keyID, err)
+	}
+	if key.KeyMetadata.KeyUsage != kmstypes.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("%w: %s isn't a signing key, its usage is %s", ErrInvalidArgument, keyID, key.KeyMetadata.KeyUsage)
+	}
+	payload, err := json.Marshal(att)
+	if err != nil {
+		return nil, err
+	}
+	for _, algorithm := range key.KeyMetadata.SigningAlgorithms {
+		digest, err := signatureDigest(algorithm, payload)
+		if err != nil {
+			continue
+		}
+		out, err := client.Sign(ctx, &kms.SignInput{
+			KeyId:            key.KeyMetadata.Arn,
+			Message:          digest,
+			MessageType:      kmstypes.MessageTypeDigest,
+			SigningAlgorithm: algorithm,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("signing the TOC with %s: %w", keyID, err)
+		}
+		return &TocSignature{Payload: payload, KeyID: aws.ToString(out.KeyId), Algorithm: string(algorithm), Signature: out.Signature}, nil
+	}
+	return nil, fmt.Errorf("%w: the key %s has no supported signing algorithm", ErrInvalidArgument, keyID)
+}
+
+// verifyToc checks with AWS KMS that sig is a signature by the key keyID and
+// returns the statement it signs. The key recorded in sig isn't trusted, a
+// signature by any other key is invalid.
+func verifyToc(ctx context.Context, client kmsAPI, keyID string, sig *TocSignature) (*TocAttestation, error) {
+	algorithm := kmstypes.SigningAlgorithmSpec(sig.Algorithm)
+	digest, err := signatureDigest(algorithm, sig.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	out, err := client.Verify(ctx, &kms.VerifyInput{
+		KeyId:            aws.String(keyID),
+		Message:          digest,
+		MessageType:      kmstypes.MessageTypeDigest,
+		Signature:        sig.Signature,
+		SigningAlgorithm: algorithm,
+	})
+	var invalid *kmstypes.KMSInvalidSignatureException
+	if errors.As(err, &invalid) || (err == nil && !out.SignatureValid) {
+		return nil, fmt.Errorf("%w: the signature isn't by %s", ErrInvalidSignature, keyID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("verifying the signature with %s: %w", keyID, err)
+	}
+	att := &TocAttestation{}
+	if err := json.Unmarshal(sig.Payload, att); err != nil {
+		return nil, fmt.Errorf("%w: the signed statement can't be read: %w", ErrInvalidSignature, err)
+	}
+	return att, nil
+}
+
+// attestArchive returns the statement of the archive s3://bucket/key as it is
+// now: its ETag, size and the digest of its TOC.
+func attestArchive(ctx context.Context, svc *s3.Client, bucket, key string) (*TocAttestation, error) {
+	head, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, &ObjectError{Bucket: bucket, Key: key, Err: classifyError(err)}
+	}
+	archive := newArchiveReader(ctx, svc, bucket, key, aws.ToInt64(head.ContentLength))
+	toc, location, err := readTocBytes(ctx, svc, archive)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(toc)
+	return &TocAttestation{
+		Archive:   objectURL(bucket, key),
+		ETag:      strings.Trim(aws.ToString(head.ETag), `"`),
+		Size:      aws.ToInt64(head.ContentLength),
+		Toc:       location,
+		TocSHA256: hex.EncodeToString(digest[:]),
+	}, nil
+}
+
+// writeTocSignature signs the TOC of the archive of opts with the key
+// opts.SignTocKeyID and writes the signature next to the archive.
+func writeTocSignature(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	att, err := attestArchive(ctx, svc, opts.DstBucket, opts.DstKey)
+	if err != nil {
+		return fmt.Errorf("reading the TOC to sign: %w", err)
+	}
+	att.SignedAt = time.Now().UTC()
+	sig, err := signToc(ctx, newKMSClient(svc), opts.SignTocKeyID, att)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return err
+	}
+	location := tocSignatureLocation(opts.DstBucket, opts.DstKey)
+	if err := saveFile(ctx, svc, location, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing the signature %s: %w", location, err)
+	}
+	Infof(ctx, "TOC signed with %s in %s", sig.KeyID, location)
+	return nil
+}
+
+// VerifyTocSignature checks with AWS KMS that the signature written by
+// --sign-toc next to the archive s3://bucket/key is by the key keyID, and
+// that the archive and its TOC are the ones it signs. It returns the signed
+// statement, and an error wrapping ErrInvalidSignature when the signature or
+// the archive don't match.
+func VerifyTocSignature(ctx context.Context, svc *s3.Client, bucket, key, keyID string) (*TocAttestation, error) {
+	location := tocSignatureLocation(bucket, key)
+	r, err := loadFile(ctx, svc, location)
+	if err != nil {
+		return nil, fmt.Errorf("reading the signature %s: %w", location, classifyError(err))
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sig := &TocSignature{}
+	if err := json.Unmarshal(data, sig); err != nil {
+		return nil, fmt.Errorf("%w: %s can't be read: %w", ErrInvalidSignature, location, err)
+	}
+	signed, err := verifyToc(ctx, newKMSClient(svc), keyID, sig)
+	if err != nil {
+		return nil, err
+	}
+	current, err := attestArchive(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return signed, compareAttestations(signed, current)
+}
+
+// compareAttestations checks that the archive described by current is the
+// one signed.
+func compareAttestations(signed, current *TocAttestation) error {
+	switch {
+	case signed.Archive != current.Archive:
+		return fmt.Errorf("%w: the signature is of %s, not %s", ErrInvalidSignature, signed.Archive, current.Archive)
+	case signed.TocSHA256 != current.TocSHA256:
+		return fmt.Errorf("%w: the TOC of %s changed since it was signed", ErrInvalidSignature, current.Archive)
+	case signed.ETag != current.ETag || signed.Size != current.Size:
+		return fmt.Errorf("%w: %s changed since it was signed, its ETag is %s, the signed one %s", ErrInvalidSignature, current.Archive, current.ETag, signed.ETag)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// fakeKMS signs with local ECDSA keys, by key id.
+type fakeKMS struct {
+	keys map[string]*ecdsa.PrivateKey
+}
+
+func (f *fakeKMS) DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	return &kms.DescribeKeyOutput{KeyMetadata: &kmstypes.KeyMetadata{
+		Arn:               params.KeyId,
+		KeyUsage:          kmstypes.KeyUsageTypeSignVerify,
+		SigningAlgorithms: []kmstypes.SigningAlgorithmSpec{kmstypes.SigningAlgorithmSpecEcdsaSha256},
+	}}, nil
+}
+
+func (f *fakeKMS) Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error) {
+	sig, err := ecdsa.SignASN1(rand.Reader, f.keys[*params.KeyId], params.Message)
+	return &kms.SignOutput{KeyId: params.KeyId, Signature: sig}, err
+}
+
+func (f *fakeKMS) Verify(ctx context.Context, params *kms.VerifyInput, optFns ...func(*kms.Options)) (*kms.VerifyOutput, error) {
+	if !ecdsa.VerifyASN1(&f.keys[*params.KeyId].PublicKey, params.Message, params.Signature) {
+		return nil, &kmstypes.KMSInvalidSignatureException{Message: aws.String("invalid")}
+	}
+	return &kms.VerifyOutput{SignatureValid: true}, nil
+}
+
+func TestSignToc(t *testing.T) {
+	client := &fakeKMS{keys: map[string]*ecdsa.PrivateKey{}}
+	for _, id := range []string{"signer", "other"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.keys[id] = key
+	}
+	att := &TocAttestation{Archive: "s3://b/archive.tar", ETag: "abc-2", Size: 10240, Toc: "toc.csv", TocSHA256: "00ff"}
+	sig, err := signToc(context.Background(), client, "signer", att)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Algorithm != "ECDSA_SHA_256" || sig.KeyID != "signer" {
+		t.Errorf("signToc() = %s by %s, want ECDSA_SHA_256 by signer", sig.Algorithm, sig.KeyID)
+	}
+
+	tampered := *sig
+	tampered.Payload = []byte(`{"archive":"s3://b/archive.tar","etag":"abc-2","size":10240,"toc":"toc.csv","toc_sha256":"0000"}`)
+	tests := map[string]struct {
+		keyID string
+		sig   *TocSignature
+		fail  bool
+	}{
+		"valid":     {keyID: "signer", sig: sig},
+		"other key": {keyID: "other", sig: sig, fail: true},
+		"tampered":  {keyID: "signer", sig: &tampered, fail: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			signed, err := verifyToc(context.Background(), client, tt.keyID, tt.sig)
+			if tt.fail {
+				if !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("verifyToc() error = %v, want ErrInvalidSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *signed != *att {
+				t.Errorf("verifyToc() = %+v, want %+v", signed, att)
+			}
+		})
+	}
+}
+
+func TestCompareAttestations(t *testing.T) {
+	signed := &TocAttestation{Archive: "s3://b/a.tar", ETag: "e", Size: 10, TocSHA256: "00"}
+	tests := map[string]struct {
+		current TocAttestation
+		fail    bool
+	}{
+		"same":          {current: TocAttestation{Archive: "s3://b/a.tar", ETag: "e", Size: 10, TocSHA256: "00"}},
+		"other archive": {current: TocAttestation{Archive: "s3://b/b.tar", ETag: "e", Size: 10, TocSHA256: "00"}, fail: true},
+		"toc changed":   {current: TocAttestation{Archive: "s3://b/a.tar", ETag: "e", Size: 10, TocSHA256: "01"}, fail: true},
+		"rewritten":     {current: TocAttestation{Archive: "s3://b/a.tar", ETag: "f", Size: 10, TocSHA256: "00"}, fail: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := compareAttestations(signed, &tt.current)
+			if tt.fail != errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("compareAttestations() error = %v, want failure %v", err, tt.fail)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Source is one of the prefixes merged into a single archive.
+type Source struct {
+	Bucket string
+	Prefix string
+	// Name is the directory the objects of the source are archived under,
+	// the bucket when empty.
+	Name string
+	// Region is the region of the bucket, the region of the run when empty,
+	// for the command line to create Client.
+	Region string
+	// Client reads the source, the source client of the run when nil.
+	Client *s3.Client
+}
+
+// ParseSource parses s3://bucket/prefix, followed by ?name=<name> to archive
+// its objects under another directory than the bucket and &region=<region>
+// for a bucket in another region than the run.
+func ParseSource(spec string) (Source, error) {
+	location, query, _ := strings.Cut(spec, "?")
+	if !strings.HasPrefix(location, "s3://") {
+		return Source{}, fmt.Errorf("%w: source %q isn't an s3:// url", ErrInvalidArgument, spec)
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return Source{}, fmt.Errorf("%w: source %q: %w", ErrInvalidArgument, spec, err)
+	}
+	s := Source{Name: values.Get("name"), Region: values.Get("region")}
+	for k := range values {
+		if k != "name" && k != "region" {
+			return Source{}, fmt.Errorf("%w: source %q: unknown parameter %q, name or region", ErrInvalidArgument, spec, k)
+		}
+	}
+	s.Bucket, s.Prefix = ExtractBucketAndPath(location)
+	if s.Bucket == "" {
+		return Source{}, fmt.Errorf("%w: source %q has no bucket", ErrInvalidArgument, spec)
+	}
+	return s, nil
+}
+
+// name is the directory the objects of s are archived under.
+func (s *Source) name() string {
+	if s.Name != "" {
+		return strings.Trim(s.Name, "/")
+	}
+	return s.Bucket
+}
+
+func validateSources(sources []Source) error {
+	names := map[string]bool{}
+	for _, s := range sources {
+		name := s.name()
+		if name == "" || path.Clean(name) != name || strings.HasPrefix(name, "../") || name == ".." {
+			return fmt.Errorf("%w: invalid name %q of s3://%s/%s", ErrInvalidArgument, s.Name, s.Bucket, s.Prefix)
+		}
+		if names[name] {
+			return fmt.Errorf("%w: two sources are archived under %s, give them different names", ErrInvalidArgument, name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// ListSources lists the objects of every source, in order, as
+// ListAllObjectVersions does with deleteMarkers, each one read with the
+// client of its source and archived under its name: s3://logs/2024/a.log of
+// a source named logs is the member logs/2024/a.log. The TOC of the archive
+// has the s3:// url of each member as a sixth column.
+func ListSources(ctx context.Context, client *s3.Client, sources []Source, flat bool, deleteMarkers DeleteMarkerPolicy) ([]*S3Obj, int64, error) {
+	if err := validateSources(sources); err != nil {
+		return nil, 0, err
+	}
+	var list []*S3Obj
+	var accum int64
+	for i := range sources {
+		s := &sources[i]
+		c := client
+		if s.Client != nil {
+			c = s.Client
+		}
+		var filterFns []func(types.Object) bool
+		if flat {
+			filterFns = append(filterFns, FlatFilter(s.Prefix))
+		}
+		objectList, size, err := ListAllObjectVersions(ctx, c, s.Bucket, s.Prefix, deleteMarkers, filterFns...)
+		if err != nil {
+			return nil, 0, fmt.Errorf("listing s3://%s/%s: %w", s.Bucket, s.Prefix, err)
+		}
+		if len(objectList) == 0 {
+			Warnf(ctx, "s3://%s/%s matched zero objects", s.Bucket, s.Prefix)
+		}
+		for _, o := range objectList {
+			o.source = s
+			o.PartNum = len(list) + 1
+			list = append(list, o)
+		}
+		accum += size
+	}
+	return list, accum, nil
+}
+
+// readClient is the client o is read with, client unless its source has
+// its own.
+func readClient(client *s3.Client, o *S3Obj) *s3.Client {
+	if o.source != nil && o.source.Client != nil {
+		return o.source.Client
+	}
+	return client
+}
+
+// sourceName prefixes name, the member name of o, with the name of its
+// source.
+func sourceName(o *S3Obj, name string) string {
+	if o.source == nil {
+		return name
+	}
+	return o.source.name() + "/" + name
+}
+
+// origin is the s3:// url of o recorded in the TOC of an archive merged from
+// several sources, empty otherwise.
+func origin(o *S3Obj) string {
+	if o.source == nil {
+		return ""
+	}
+	return objectURL(o.Bucket, *o.Key)
+}
@@ -0,0 +1,86 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := map[string]struct {
+		spec    string
+		want    Source
+		wantErr bool
+	}{
+		"bucket and prefix": {spec: "s3://logs/2024/", want: Source{Bucket: "logs", Prefix: "2024/"}},
+		"name and region":   {spec: "s3://logs/2024/?name=east&region=us-east-1", want: Source{Bucket: "logs", Prefix: "2024/", Name: "east", Region: "us-east-1"}},
+		"not s3":            {spec: "file:///tmp", wantErr: true},
+		"no bucket":         {spec: "s3://", wantErr: true},
+		"unknown parameter": {spec: "s3://logs/?profile=x", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseSource(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidArgument) {
+					t.Errorf("ParseSource() error = %v, want ErrInvalidArgument", err)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSource() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSources(t *testing.T) {
+	if err := validateSources([]Source{{Bucket: "a"}, {Bucket: "b"}, {Bucket: "a", Name: "a-east"}}); err != nil {
+		t.Errorf("validateSources() error = %v", err)
+	}
+	if err := validateSources([]Source{{Bucket: "a"}, {Bucket: "b", Name: "a"}}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("validateSources(same name) error = %v, want ErrInvalidArgument", err)
+	}
+	if err := validateSources([]Source{{Bucket: "a", Name: "../up"}}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("validateSources(../up) error = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestSourceNamesAndOrigins(t *testing.T) {
+	sources := []Source{{Bucket: "b1", Prefix: "logs/"}, {Bucket: "b2", Prefix: "logs/", Name: "east"}}
+	o1 := NewS3ObjOptions(WithBucketAndKey("b1", "logs/a"), WithSize(3))
+	o1.source = &sources[0]
+	o2 := NewS3ObjOptions(WithBucketAndKey("b2", "logs/a"), WithSize(4))
+	o2.source = &sources[1]
+	plain := NewS3ObjOptions(WithBucketAndKey("b3", "dir/c"), WithSize(1))
+	objectList := []*S3Obj{o1, o2, plain}
+
+	if err := ApplyNameTransforms(objectList, StripComponents(1)); err != nil {
+		t.Fatalf("ApplyNameTransforms() error = %v", err)
+	}
+	if o1.MemberName() != "b1/a" || o2.MemberName() != "east/a" || plain.MemberName() != "c" {
+		t.Errorf("ApplyNameTransforms() = %s, %s, %s, want b1/a, east/a, c", o1.MemberName(), o2.MemberName(), plain.MemberName())
+	}
+
+	headers := []*S3Obj{}
+	for range objectList {
+		headers = append(headers, NewS3ObjOptions(WithSize(blockSize)))
+	}
+	buf, err := createCSVTOC(0, headers, objectList[:2])
+	if err != nil {
+		t.Fatalf("createCSVTOC() error = %v", err)
+	}
+	toc, err := parseTocCSV(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("parseTocCSV() error = %v", err)
+	}
+	if len(toc) != 2 || toc[0].Origin != "s3://b1/logs/a" || toc[1].Origin != "s3://b2/logs/a" {
+		t.Errorf("createCSVTOC() = %q", buf.String())
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// spotInstanceAction is the path of the instance metadata where EC2 posts the
+// interruption notice of a Spot instance, two minutes before it's stopped.
+const spotInstanceAction = "spot/instance-action"
+
+// spotPollInterval is how often the interruption notice is checked for, as
+// recommended by EC2.
+var spotPollInterval = 5 * time.Second
+
+// SpotInterruption is the interruption notice of an EC2 Spot instance.
+type SpotInterruption struct {
+	// Action is terminate, stop or hibernate.
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+type imdsAPI interface {
+	GetMetadata(ctx context.Context, params *imds.GetMetadataInput, optFns ...func(*imds.Options)) (*imds.GetMetadataOutput, error)
+}
+
+// WatchSpotInterruption polls the instance metadata for the interruption
+// notice of the EC2 Spot instance the process runs on, and returns the
+// channel closed once it's posted, to drain the runs with WithDrain before
+// the instance is stopped. When the instance metadata can't be read at
+// first, e.g. off EC2, it isn't watched and the channel is never closed.
+func WatchSpotInterruption(ctx context.Context, client *imds.Client) <-chan struct{} {
+	return watchSpotInterruption(ctx, client)
+}
+
+func watchSpotInterruption(ctx context.Context, client imdsAPI) <-chan struct{} {
+	interrupted := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(spotPollInterval)
+		defer ticker.Stop()
+		for first := true; ; first = false {
+			notice, err := spotInterruption(ctx, client)
+			switch {
+			case notice != nil:
+				Warnf(ctx, "the spot instance will %s at %s", notice.Action, notice.Time.Format(time.RFC3339))
+				close(interrupted)
+				return
+			case err != nil && ctx.Err() != nil:
+				return
+			case err != nil && first:
+				Warnf(ctx, "spot interruptions aren't watched, the instance metadata can't be read: %s", err.Error())
+				return
+			case err != nil:
+				Debugf(ctx, "reading the spot interruption notice: %s", err.Error())
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return interrupted
+}
+
+// spotInterruption returns the interruption notice of the instance, nil
+// when it has none.
+func spotInterruption(ctx context.Context, client imdsAPI) (*SpotInterruption, error) {
+	out, err := client.GetMetadata(ctx, &imds.GetMetadataInput{Path: spotInstanceAction})
+	var re *smithyhttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Content.Close()
+	data, err := io.ReadAll(out.Content)
+	if err != nil {
+		return nil, err
+	}
+	notice := &SpotInterruption{}
+	if err := json.Unmarshal(data, notice); err != nil {
+		return nil, err
+	}
+	return notice, nil
+}
@@ -0,0 +1,69 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// fakeIMDS answers the requests for the interruption notice with the status
+// codes of responses in turn, the notice for a 200, and the last one after.
+type fakeIMDS struct {
+	mu        sync.Mutex
+	responses []int
+}
+
+func (f *fakeIMDS) GetMetadata(ctx context.Context, params *imds.GetMetadataInput, optFns ...func(*imds.Options)) (*imds.GetMetadataOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := f.responses[0]
+	if len(f.responses) > 1 {
+		f.responses = f.responses[1:]
+	}
+	if status != http.StatusOK {
+		return nil, &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}}, Err: errors.New(http.StatusText(status))}
+	}
+	notice := `{"action": "terminate", "time": "2026-10-17T08:22:00Z"}`
+	return &imds.GetMetadataOutput{Content: io.NopCloser(strings.NewReader(notice))}, nil
+}
+
+func TestWatchSpotInterruption(t *testing.T) {
+	spotPollInterval = time.Millisecond
+	tests := map[string]struct {
+		responses   []int
+		interrupted bool
+	}{
+		"notice":          {responses: []int{404, 404, 200}, interrupted: true},
+		"transient error": {responses: []int{404, 500, 200}, interrupted: true},
+		"no notice":       {responses: []int{404}},
+		"off EC2":         {responses: []int{500, 200}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(SetupLogger(context.Background()))
+			defer cancel()
+			interrupted := watchSpotInterruption(ctx, &fakeIMDS{responses: tt.responses})
+			select {
+			case <-interrupted:
+				if !tt.interrupted {
+					t.Error("interrupted without a notice")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.interrupted {
+					t.Error("not interrupted by the notice")
+				}
+			}
+		})
+	}
+}
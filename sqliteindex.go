@@ -0,0 +1,305 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The SQLite index is a database file in the SQLite 3 format
+// (https://www.sqlite.org/fileformat.html), written directly rather than
+// through a driver: the tables are built once, in rowid order, so every
+// b-tree is written bottom up from its leaves without balancing.
+const (
+	sqlitePageSize = 4096
+	sqliteHeader   = "SQLite format 3\x00"
+	// sqliteVersion is the SQLITE_VERSION_NUMBER recorded as the writer.
+	sqliteVersion = 3040000
+
+	sqliteLeafPage     = 0x0d
+	sqliteInteriorPage = 0x05
+	// sqliteInteriorChildren is how many children an interior page holds
+	// with cells of the largest size, a page number and a 9 bytes rowid.
+	sqliteInteriorChildren = (sqlitePageSize-12)/(2+4+9) + 1
+)
+
+// sqliteArchivesTable and sqliteMembersTable are the schema of the index,
+// an archive per row of archives and a member per row of members.
+const (
+	sqliteArchivesTable = "CREATE TABLE archives (id INTEGER PRIMARY KEY, url TEXT, members INTEGER)"
+	sqliteMembersTable  = "CREATE TABLE members (id INTEGER PRIMARY KEY, archive_id INTEGER REFERENCES archives(id), name TEXT, offset INTEGER, size INTEGER, " +
+		"etag TEXT, checksum TEXT, origin TEXT, version_id TEXT, storage_class TEXT, content_type TEXT, owner TEXT)"
+)
+
+// sqliteIndex returns the SQLite database of the members of archives.
+func sqliteIndex(archives []IndexedArchive) []byte {
+	db := &sqliteFile{pages: [][]byte{nil}}
+	var archiveRows, memberRows [][]any
+	for i, a := range archives {
+		for _, m := range a.Toc {
+			memberRows = append(memberRows, []any{nil, int64(i + 1), m.Filename, m.Start, m.Size,
+				nullable(m.Etag), nullable(m.Checksum), nullable(m.Origin), nullable(m.VersionId),
+				nullable(m.StorageClass), nullable(m.ContentType), nullable(m.Owner)})
+		}
+		archiveRows = append(archiveRows, []any{nil, nullable(a.Archive), int64(len(a.Toc))})
+	}
+	archivesRoot := db.table(archiveRows)
+	membersRoot := db.table(memberRows)
+
+	// page 1 starts with the header of the file and is the root of the
+	// schema, which fits in a single leaf
+	schema := [][]any{
+		{"table", "archives", "archives", int64(archivesRoot), sqliteArchivesTable},
+		{"table", "members", "members", int64(membersRoot), sqliteMembersTable},
+	}
+	var cells [][]byte
+	for i, row := range schema {
+		cells = append(cells, db.leafCell(int64(i+1), record(row)))
+	}
+	db.pages[0] = leafPage(cells, 100)
+	db.writeHeader()
+	data := make([]byte, 0, len(db.pages)*sqlitePageSize)
+	for _, page := range db.pages {
+		data = append(data, page...)
+	}
+	return data
+}
+
+// nullable is NULL for an empty string.
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sqliteFile is a database being written, page i+1 is pages[i].
+type sqliteFile struct {
+	pages [][]byte
+}
+
+// add appends page and returns its number.
+func (db *sqliteFile) add(page []byte) int {
+	db.pages = append(db.pages, page)
+	return len(db.pages)
+}
+
+// table writes the b-tree of a table with rows, their rowids counted from 1,
+// and returns its root page.
+func (db *sqliteFile) table(rows [][]any) int {
+	type child struct {
+		page  int
+		rowid int64
+	}
+	var leaves []child
+	var cells [][]byte
+	used := 8
+	for i, row := range rows {
+		cell := db.leafCell(int64(i+1), record(row))
+		if used+2+len(cell) > sqlitePageSize {
+			leaves = append(leaves, child{page: db.add(leafPage(cells, 0)), rowid: int64(i)})
+			cells, used = nil, 8
+		}
+		cells = append(cells, cell)
+		used += 2 + len(cell)
+	}
+	leaves = append(leaves, child{page: db.add(leafPage(cells, 0)), rowid: int64(len(rows))})
+
+	// every level points to the pages of the one below until one is left,
+	// the children are spread evenly so no page is left with only its
+	// right-most one
+	for level := leaves; len(level) > 1; {
+		pages := (len(level) + sqliteInteriorChildren - 1) / sqliteInteriorChildren
+		var next []child
+		for p, i := 0, 0; p < pages; p++ {
+			n := len(level) / pages
+			if p < len(level)%pages {
+				n++
+			}
+			var cells [][]byte
+			for _, c := range level[i : i+n-1] {
+				cells = append(cells, appendVarint(binary.BigEndian.AppendUint32(nil, uint32(c.page)), uint64(c.rowid)))
+			}
+			right := level[i+n-1]
+			next = append(next, child{page: db.add(interiorPage(cells, right.page)), rowid: right.rowid})
+			i += n
+		}
+		level = next
+	}
+	return len(db.pages)
+}
+
+// leafCell returns the cell of a table leaf with the record payload at
+// rowid, the end of a payload too large for the page in overflow pages.
+func (db *sqliteFile) leafCell(rowid int64, payload []byte) []byte {
+	cell := appendVarint(nil, uint64(len(payload)))
+	cell = appendVarint(cell, uint64(rowid))
+	local := sqliteLocalPayload(len(payload))
+	if local == len(payload) {
+		return append(cell, payload...)
+	}
+	cell = append(cell, payload[:local]...)
+	// the overflow pages are a list, written from its end so every page
+	// knows the next one
+	next := 0
+	rest := payload[local:]
+	chunks := (len(rest) + sqlitePageSize - 5) / (sqlitePageSize - 4)
+	for i := chunks - 1; i >= 0; i-- {
+		end := (i + 1) * (sqlitePageSize - 4)
+		if end > len(rest) {
+			end = len(rest)
+		}
+		page := make([]byte, sqlitePageSize)
+		binary.BigEndian.PutUint32(page, uint32(next))
+		copy(page[4:], rest[i*(sqlitePageSize-4):end])
+		next = db.add(page)
+	}
+	return binary.BigEndian.AppendUint32(cell, uint32(next))
+}
+
+// sqliteLocalPayload is how much of a payload of n bytes is kept in the cell
+// of a table leaf.
+func sqliteLocalPayload(n int) int {
+	maxLocal := sqlitePageSize - 35
+	if n <= maxLocal {
+		return n
+	}
+	minLocal := (sqlitePageSize-12)*32/255 - 23
+	local := minLocal + (n-minLocal)%(sqlitePageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	return local
+}
+
+// leafPage returns a table leaf with cells, its header at offset, 100 on
+// page 1.
+func leafPage(cells [][]byte, offset int) []byte {
+	page := btreePage(cells, offset, 8)
+	page[offset] = sqliteLeafPage
+	return page
+}
+
+// interiorPage returns an interior page of a table with cells and the
+// right-most child right.
+func interiorPage(cells [][]byte, right int) []byte {
+	page := btreePage(cells, 0, 12)
+	page[0] = sqliteInteriorPage
+	binary.BigEndian.PutUint32(page[8:], uint32(right))
+	return page
+}
+
+// btreePage lays out cells from the end of a page, with the array of their
+// offsets after the header of headerSize bytes at offset.
+func btreePage(cells [][]byte, offset, headerSize int) []byte {
+	page := make([]byte, sqlitePageSize)
+	content := sqlitePageSize
+	for i, cell := range cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[offset+headerSize+2*i:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(cells)))
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(content))
+	return page
+}
+
+// writeHeader writes the header of the file at the start of page 1.
+func (db *sqliteFile) writeHeader() {
+	h := db.pages[0]
+	copy(h, sqliteHeader)
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18], h[19] = 1, 1 // legacy journal
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1) // change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(db.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1)
+	binary.BigEndian.PutUint32(h[96:], sqliteVersion)
+}
+
+// record returns the SQLite record of values, nil, int64 or string.
+func record(values []any) []byte {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = appendVarint(types, 0)
+		case int64:
+			t, b := sqliteInteger(v)
+			types = appendVarint(types, t)
+			body = append(body, b...)
+		case string:
+			types = appendVarint(types, uint64(2*len(v)+13))
+			body = append(body, v...)
+		default:
+			panic(fmt.Sprintf("a %T can't be in a SQLite record", v))
+		}
+	}
+	// the size of the header counts the varint of the size
+	size := len(types) + 1
+	for len(types)+len(appendVarint(nil, uint64(size))) != size {
+		size++
+	}
+	data := appendVarint(nil, uint64(size))
+	data = append(data, types...)
+	return append(data, body...)
+}
+
+// sqliteInteger returns the serial type of v and its big-endian bytes, with
+// the fewest bytes that hold it.
+func sqliteInteger(v int64) (uint64, []byte) {
+	switch {
+	case v == 0:
+		return 8, nil
+	case v == 1:
+		return 9, nil
+	}
+	b := binary.BigEndian.AppendUint64(nil, uint64(v))
+	for _, t := range []struct {
+		serial uint64
+		size   int
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+		bits := 8*t.size - 1
+		if v >= -(1<<bits) && v < 1<<bits {
+			return t.serial, b[8-t.size:]
+		}
+	}
+	return 6, b
+}
+
+// appendVarint appends the SQLite varint of v: big-endian groups of 7 bits
+// with the high bit set on all but the last byte, which has 8 bits when it's
+// the ninth.
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var groups [8]byte
+		for i := 7; i >= 0; i-- {
+			groups[i] = byte(v>>(8+7*(7-i)))&0x7f | 0x80
+		}
+		b = append(b, groups[:]...)
+		return append(b, byte(v))
+	}
+	var groups [8]byte
+	n := 0
+	for {
+		groups[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		if i > 0 {
+			b = append(b, groups[i]|0x80)
+		} else {
+			b = append(b, groups[i])
+		}
+	}
+	return b
+}
@@ -0,0 +1,185 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAppendVarint(t *testing.T) {
+	tests := map[string]struct {
+		v    uint64
+		want []byte
+	}{
+		"zero":     {v: 0, want: []byte{0}},
+		"one byte": {v: 127, want: []byte{0x7f}},
+		"two":      {v: 128, want: []byte{0x81, 0x00}},
+		"three":    {v: 16384, want: []byte{0x81, 0x80, 0x00}},
+		"nine":     {v: 1 << 63, want: []byte{0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := appendVarint(nil, tt.v)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appendVarint(%d) = %x, want %x", tt.v, got, tt.want)
+			}
+			if v, n := readVarint(got); v != tt.v || n != len(got) {
+				t.Errorf("read %d of %d bytes back, want %d", v, n, tt.v)
+			}
+		})
+	}
+}
+
+func TestSQLiteIndex(t *testing.T) {
+	long := strings.Repeat("long/", 2000)
+	tests := map[string]struct {
+		archives []IndexedArchive
+		members  int
+	}{
+		"empty": {archives: nil},
+		"archives": {
+			archives: []IndexedArchive{
+				{Archive: "s3://bucket/a.tar", Toc: TOC{{Filename: "a.txt", Start: 1536, Size: 5, Etag: "e1"}, {Filename: long, Start: 3072, Size: 1 << 40, Owner: "id"}}},
+				{Toc: TOC{{Filename: "b.txt", Start: 1024, Size: 0, StorageClass: "GLACIER"}}},
+			},
+			members: 3,
+		},
+		"many members": {archives: []IndexedArchive{{Archive: "s3://bucket/big.tar", Toc: manyMembers(50000)}}, members: 50000},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := sqliteIndex(tt.archives)
+			if string(db[:16]) != sqliteHeader || len(db)%sqlitePageSize != 0 || int(binary.BigEndian.Uint32(db[28:])) != len(db)/sqlitePageSize {
+				t.Fatalf("the header of the file is %q, %d pages in %d bytes", db[:16], binary.BigEndian.Uint32(db[28:]), len(db))
+			}
+			schema := readTable(t, db, 1)
+			if len(schema) != 2 || schema[0][4] != sqliteArchivesTable || schema[1][4] != sqliteMembersTable {
+				t.Fatalf("schema = %v", schema)
+			}
+			archives := readTable(t, db, int(schema[0][3].(int64)))
+			members := readTable(t, db, int(schema[1][3].(int64)))
+			if len(archives) != len(tt.archives) || len(members) != tt.members {
+				t.Fatalf("the index has %d archives and %d members, want %d and %d", len(archives), len(members), len(tt.archives), tt.members)
+			}
+			i := 0
+			for a, archive := range tt.archives {
+				want := []any{int64(a + 1), nullable(archive.Archive), int64(len(archive.Toc))}
+				if !reflect.DeepEqual(archives[a], want) {
+					t.Errorf("archive %d = %v, want %v", a+1, archives[a], want)
+				}
+				for _, m := range archive.Toc {
+					want := []any{int64(i + 1), int64(a + 1), m.Filename, m.Start, m.Size, nullable(m.Etag), nullable(m.Checksum), nullable(m.Origin),
+						nullable(m.VersionId), nullable(m.StorageClass), nullable(m.ContentType), nullable(m.Owner)}
+					if !reflect.DeepEqual(members[i], want) {
+						t.Errorf("member %d = %.100v, want %.100v", i+1, members[i], want)
+					}
+					i++
+				}
+			}
+		})
+	}
+}
+
+func manyMembers(n int) TOC {
+	toc := make(TOC, n)
+	for i := range toc {
+		toc[i] = &FileMetadata{Filename: fmt.Sprintf("dir/file-%d.txt", i), Start: int64(512 + 1024*i), Size: int64(i)}
+	}
+	return toc
+}
+
+// readTable returns the rows of the table with its b-tree at root in db,
+// with their rowid in place of a NULL first column, the INTEGER PRIMARY KEY.
+func readTable(t *testing.T, db []byte, root int) [][]any {
+	t.Helper()
+	page := db[(root-1)*sqlitePageSize : root*sqlitePageSize]
+	header := page
+	if root == 1 {
+		header = page[100:]
+	}
+	cells := int(binary.BigEndian.Uint16(header[3:]))
+	var rows [][]any
+	switch header[0] {
+	case sqliteInteriorPage:
+		for i := 0; i < cells; i++ {
+			cell := page[binary.BigEndian.Uint16(header[12+2*i:]):]
+			rows = append(rows, readTable(t, db, int(binary.BigEndian.Uint32(cell)))...)
+		}
+		return append(rows, readTable(t, db, int(binary.BigEndian.Uint32(header[8:])))...)
+	case sqliteLeafPage:
+	default:
+		t.Fatalf("page %d is of type %#x", root, header[0])
+	}
+	for i := 0; i < cells; i++ {
+		cell := page[binary.BigEndian.Uint16(header[8+2*i:]):]
+		size, n := readVarint(cell)
+		rowid, m := readVarint(cell[n:])
+		cell = cell[n+m:]
+		local := sqliteLocalPayload(int(size))
+		payload := append([]byte{}, cell[:local]...)
+		for next := uint32(0); len(payload) < int(size); {
+			if next == 0 {
+				next = binary.BigEndian.Uint32(cell[local:])
+			}
+			overflow := db[(next-1)*sqlitePageSize : next*sqlitePageSize]
+			end := sqlitePageSize
+			if rest := int(size) - len(payload); rest < end-4 {
+				end = 4 + rest
+			}
+			payload = append(payload, overflow[4:end]...)
+			next = binary.BigEndian.Uint32(overflow)
+		}
+		row := readRecord(t, payload)
+		if row[0] == nil {
+			row[0] = int64(rowid)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func readRecord(t *testing.T, payload []byte) []any {
+	t.Helper()
+	size, n := readVarint(payload)
+	types, body := payload[n:size], payload[size:]
+	var values []any
+	for len(types) > 0 {
+		serial, n := readVarint(types)
+		types = types[n:]
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial == 8, serial == 9:
+			values = append(values, int64(serial-8))
+		case serial >= 1 && serial <= 6:
+			size := []int{1, 2, 3, 4, 6, 8}[serial-1]
+			v := int64(int8(body[0]))
+			for _, b := range body[1:size] {
+				v = v<<8 | int64(b)
+			}
+			values, body = append(values, v), body[size:]
+		case serial >= 13 && serial%2 == 1:
+			size := (serial - 13) / 2
+			values, body = append(values, string(body[:size])), body[size:]
+		default:
+			t.Fatalf("unexpected serial type %d", serial)
+		}
+	}
+	return values
+}
+
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
@@ -0,0 +1,391 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// The state of a job is kept under S3TarS3Options.State as job.json, written
+// once its multipart upload is created, and a file per part in parts/,
+// written as the part is uploaded. stateVersion is the version of the
+// layout, a job persisted with another one isn't resumed.
+const (
+	stateVersion = 1
+	stateJob     = "job.json"
+	stateParts   = "parts/"
+)
+
+// jobState is what a job needs to continue on another machine: the options
+// it was started with, the objects resolved from its listing, the plan of
+// its parts and the multipart upload they are uploaded to.
+type jobState struct {
+	Version      int                `json:"version"`
+	Archive      string             `json:"archive"`
+	Options      S3TarS3Options     `json:"options"`
+	TarFormat    tar.Format         `json:"tar_format"`
+	StorageClass types.StorageClass `json:"storage_class,omitempty"`
+	ManifestHash string             `json:"manifest_hash,omitempty"`
+	Objects      []stateObject      `json:"objects"`
+	PartSize     int64              `json:"part_size"`
+	// Groups is the number of objects of every part, in order.
+	Groups   []int  `json:"groups"`
+	UploadId string `json:"upload_id"`
+	// PlanDigest is the planDigest of the groups, to check that a resumed
+	// job writes the parts it was started with.
+	PlanDigest string `json:"plan_digest,omitempty"`
+	// Skipped are the objects skipped before the upload was created, delete
+	// markers and objects left out by the preflight.
+	Skipped   []stateSkipped `json:"skipped,omitempty"`
+	Completed bool           `json:"completed"`
+
+	// parts are the parts uploaded when the job was loaded, by group.
+	parts map[int]*statePart
+}
+
+// stateObject is an object of a job with the header written in front of it,
+// after the transforms of the job, and the source it was listed from.
+type stateObject struct {
+	*S3Obj
+	Header *tar.Header `json:"header"`
+	Source *Source     `json:"source,omitempty"`
+}
+
+// statePart is a part uploaded by a job, with the size and the TOC of the
+// members of its group and the objects skipped from it.
+type statePart struct {
+	Group   int                 `json:"group"`
+	Part    types.CompletedPart `json:"part"`
+	Size    int64               `json:"size"`
+	Toc     TOC                 `json:"toc"`
+	Skipped []stateSkipped      `json:"skipped,omitempty"`
+}
+
+// stateSkipped is a SkippedObject as it's persisted.
+type stateSkipped struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	ETag     string `json:"etag,omitempty"`
+	Attempts int    `json:"attempts"`
+	Class    string `json:"error_class"`
+	Error    string `json:"error"`
+}
+
+// stateError is the error of an object skipped before the job was resumed,
+// with the class it had then.
+type stateError struct {
+	class, msg string
+}
+
+func (e *stateError) Error() string {
+	return e.msg
+}
+
+func newStateSkipped(o *SkippedObject) stateSkipped {
+	return stateSkipped{Bucket: o.Bucket, Key: o.Key, Size: o.Size, ETag: o.ETag, Attempts: o.Attempts, Class: o.ErrorClass(), Error: o.Err.Error()}
+}
+
+func (s stateSkipped) object() *SkippedObject {
+	return &SkippedObject{Bucket: s.Bucket, Key: s.Key, Size: s.Size, ETag: s.ETag, Attempts: s.Attempts, Err: &stateError{class: s.Class, msg: s.Error}}
+}
+
+// validateState checks that the job of opts can be resumed from its state.
+// Only archives built in memory and uploaded to Amazon S3 in parts are, from
+// objects any machine reads with the clients of the run.
+func validateState(objectList []*S3Obj, opts *S3TarS3Options) error {
+	if opts.State == "" {
+		return nil
+	}
+	if !strings.HasPrefix(opts.State, "s3://") {
+		return fmt.Errorf("%w: the state %s isn't an s3:// url", ErrInvalidArgument, opts.State)
+	}
+	switch {
+	case !opts.ConcatInMemory:
+		return fmt.Errorf("%w: --state needs --concat-in-memory", ErrInvalidArgument)
+	case opts.DstPath != "":
+		return fmt.Errorf("%w: --state can't be used with a local destination", ErrInvalidArgument)
+	case opts.PartPadding == PartPaddingExactFit:
+		return fmt.Errorf("%w: --state can't be used with --part-padding %s", ErrInvalidArgument, PartPaddingExactFit)
+	}
+	for _, o := range objectList {
+		if o.isLocal() || o.isPresigned() {
+			return fmt.Errorf("%w: --state can't be used with local files or presigned urls, another machine can't read them", ErrInvalidArgument)
+		}
+		if o.source != nil && o.source.Client != nil {
+			return fmt.Errorf("%w: --state can't be used with a source in another region, s3://%s/%s", ErrInvalidArgument, o.source.Bucket, o.source.Prefix)
+		}
+	}
+	return nil
+}
+
+// stateLocation is the s3:// url of name under the state prefix.
+func stateLocation(state, name string) string {
+	return strings.TrimSuffix(state, "/") + "/" + name
+}
+
+// newJobState returns the state of the job of opts uploading groups, the
+// objects of every part, in parts of partSize to the upload uploadId.
+func newJobState(ctx context.Context, opts *S3TarS3Options, groups [][]*S3Obj, partSize int64, uploadId string) *jobState {
+	options := *opts
+	options.Sources, options.job = nil, nil
+	job := &jobState{
+		Version:      stateVersion,
+		Archive:      archiveURL(opts),
+		Options:      options,
+		TarFormat:    opts.tarFormat,
+		StorageClass: opts.storageClass,
+		ManifestHash: opts.manifestHash,
+		PartSize:     partSize,
+		UploadId:     uploadId,
+		PlanDigest:   planDigest(partSize, groups),
+	}
+	for _, group := range groups {
+		job.Groups = append(job.Groups, len(group))
+		for _, o := range group {
+			so := stateObject{S3Obj: o, Header: inMemoryHeader(o)}
+			if o.source != nil {
+				source := *o.source
+				so.Source = &source
+			}
+			job.Objects = append(job.Objects, so)
+		}
+	}
+	for _, s := range skippedObjects(ctx) {
+		job.Skipped = append(job.Skipped, newStateSkipped(s))
+	}
+	return job
+}
+
+// save writes job.json.
+func (j *jobState) save(ctx context.Context, svc *s3.Client) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	location := stateLocation(j.Options.State, stateJob)
+	if err := saveFile(ctx, svc, location, data); err != nil {
+		return fmt.Errorf("writing job state %s: %w", location, err)
+	}
+	return nil
+}
+
+// savePart writes the state of the part of group i, uploaded as part, with
+// the members of group, size bytes described by toc.
+func (j *jobState) savePart(ctx context.Context, svc *s3.Client, i int, group []*S3Obj, part types.CompletedPart, size int64, toc TOC) error {
+	p := &statePart{Group: i, Part: part, Size: size, Toc: toc}
+	for _, s := range skippedObjects(ctx) {
+		for _, o := range group {
+			if s.Bucket == o.Bucket && s.Key == *o.Key {
+				p.Skipped = append(p.Skipped, newStateSkipped(s))
+				break
+			}
+		}
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	location := stateLocation(j.Options.State, fmt.Sprintf("%s%05d.json", stateParts, i+1))
+	if err := saveFile(ctx, svc, location, data); err != nil {
+		return fmt.Errorf("writing part state %s: %w", location, err)
+	}
+	return nil
+}
+
+// loadJobState reads the state of the job under state with its uploaded
+// parts.
+func loadJobState(ctx context.Context, svc *s3.Client, state string) (*jobState, error) {
+	if !strings.HasPrefix(state, "s3://") {
+		return nil, fmt.Errorf("%w: the state %s isn't an s3:// url", ErrInvalidArgument, state)
+	}
+	job := &jobState{}
+	location := stateLocation(state, stateJob)
+	if err := loadJSON(ctx, svc, location, job); err != nil {
+		if errors.Is(classifyError(err), ErrNotFound) {
+			return nil, fmt.Errorf("%w: there is no job state under %s", ErrNotFound, state)
+		}
+		return nil, fmt.Errorf("reading job state %s: %w", location, err)
+	}
+	if job.Version != stateVersion {
+		return nil, fmt.Errorf("%w: the job state %s is version %d, this version of s3tar resumes version %d", ErrInvalidArgument, location, job.Version, stateVersion)
+	}
+	if sumSlice(job.Groups) != len(job.Objects) {
+		return nil, fmt.Errorf("%w: the parts of the job state %s have %d objects, it has %d", ErrInvalidArgument, location, sumSlice(job.Groups), len(job.Objects))
+	}
+	if job.PlanDigest != "" && planDigest(job.PartSize, job.plan(job.objectList())) != job.PlanDigest {
+		return nil, fmt.Errorf("%w: the parts of the job state %s don't match its plan digest %s", ErrInvalidArgument, location, job.PlanDigest)
+	}
+	job.Options.State = state
+
+	bucket, prefix := ExtractBucketAndPath(stateLocation(state, stateParts))
+	list, _, err := ListAllObjects(ctx, svc, bucket, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing the parts of the job state %s: %w", state, err)
+	}
+	var mu sync.Mutex
+	job.parts = map[int]*statePart{}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(defaultThreads)
+	for _, o := range list {
+		location := objectURL(o.Bucket, *o.Key)
+		g.Go(func() error {
+			p := &statePart{}
+			if err := loadJSON(gctx, svc, location, p); err != nil {
+				return fmt.Errorf("reading part state %s: %w", location, err)
+			}
+			if p.Group < 0 || p.Group >= len(job.Groups) || p.Part.PartNumber == nil {
+				return fmt.Errorf("%w: %s isn't a part of the job", ErrInvalidArgument, location)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			job.parts[p.Group] = p
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// loadJSON decodes the JSON file at location into v.
+func loadJSON(ctx context.Context, svc *s3.Client, location string, v any) error {
+	r, err := loadFile(ctx, svc, location)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	return nil
+}
+
+// objectList returns the objects of the job, written with the headers they
+// were resolved to.
+func (j *jobState) objectList() []*S3Obj {
+	list := make([]*S3Obj, 0, len(j.Objects))
+	for _, so := range j.Objects {
+		o := so.S3Obj
+		o.source = so.Source
+		o.tarFormat = j.TarFormat
+		if so.Header != nil {
+			o.headerTransforms = []HeaderTransform{restoredHeader(*so.Header)}
+		}
+		list = append(list, o)
+	}
+	return list
+}
+
+// restoredHeader is a HeaderTransform to the header saved, with the size and
+// the PAX records of the object written.
+func restoredHeader(saved tar.Header) HeaderTransform {
+	return func(o *S3Obj, hdr *tar.Header) {
+		size, records := hdr.Size, hdr.PAXRecords
+		*hdr = saved
+		hdr.Size, hdr.PAXRecords = size, records
+	}
+}
+
+// plan splits objectList, the objects of the job, into the groups of its
+// parts.
+func (j *jobState) plan(objectList []*S3Obj) [][]*S3Obj {
+	groups := make([][]*S3Obj, 0, len(j.Groups))
+	for _, n := range j.Groups {
+		groups = append(groups, objectList[:n])
+		objectList = objectList[n:]
+	}
+	return groups
+}
+
+// uploaded returns the part of group i when it was uploaded before the job
+// was resumed, there is none without a job.
+func (j *jobState) uploaded(i int) (*statePart, bool) {
+	if j == nil {
+		return nil, false
+	}
+	p, ok := j.parts[i]
+	return p, ok
+}
+
+// restoreSkipped records the objects the job skipped before it was resumed.
+func (j *jobState) restoreSkipped(ctx context.Context) {
+	for _, s := range j.Skipped {
+		recordSkipped(ctx, s.object())
+	}
+	for _, p := range j.parts {
+		for _, s := range p.Skipped {
+			recordSkipped(ctx, s.object())
+		}
+	}
+}
+
+// drainedJob keeps the upload of a job drained before all its parts were
+// uploaded for Resume, with the state of the groups done by the run. A job
+// without a state gets one next to the archive, a job that can't be resumed
+// is aborted.
+func drainedJob(ctx context.Context, client *s3.Client, job *jobState, upload partUpload, objectList []*S3Obj, groups [][]*S3Obj, partSize int64, done []*statePart, opts *S3TarS3Options) error {
+	if job == nil {
+		checkpoint := *opts
+		checkpoint.State = fmt.Sprintf("s3://%s/%s.state/", opts.DstBucket, opts.DstKey)
+		s3Upload, ok := upload.(*s3Upload)
+		if err := validateState(objectList, &checkpoint); err != nil || !ok || opts.chain != nil {
+			upload.abort(ctx)
+			return fmt.Errorf("%w: stopped before the archive was complete, it can't be resumed", context.Canceled)
+		}
+		job = newJobState(ctx, &checkpoint, groups, partSize, s3Upload.uploadId)
+		err := job.save(ctx, client)
+		for _, p := range done {
+			if p != nil && err == nil {
+				err = job.savePart(ctx, client, p.Group, groups[p.Group], p.Part, p.Size, p.Toc)
+			}
+		}
+		if err != nil {
+			upload.abort(ctx)
+			return err
+		}
+	}
+	return fmt.Errorf("%w: stopped before the archive was complete, resume it with --resume %s", ErrResumable, job.Options.State)
+}
+
+// Resume continues the job whose state a run with S3TarS3Options.State
+// persisted under state, on this machine or any other. The parts uploaded
+// already are kept, the others are built and uploaded to the same multipart
+// upload, and the archive is completed with the options the job was started
+// with. optFns set what isn't persisted: the source client, the callbacks
+// and Threads. A job that was completed is left as it is.
+func Resume(ctx context.Context, svc *s3.Client, state string, optFns ...func(*S3TarS3Options)) error {
+	job, err := loadJobState(ctx, svc, state)
+	if err != nil {
+		return err
+	}
+	if job.Completed {
+		Infof(ctx, "%s was completed by run %s, there is nothing to resume", job.Archive, job.Options.RunID)
+		return nil
+	}
+	opts := job.Options
+	opts.job = job
+	opts.tarFormat, opts.storageClass, opts.manifestHash = job.TarFormat, job.StorageClass, job.ManifestHash
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	Infof(ctx, "resuming %s: %d of %d parts are uploaded", job.Archive, len(job.parts), len(job.Groups))
+	return classifyError(createFromList(ctx, svc, job.objectList(), &opts))
+}
@@ -0,0 +1,186 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	const size = 6 << 20
+	store := &fakeStore{objects: map[string][]byte{
+		"src/a.bin": bytes.Repeat([]byte("a"), size),
+		"src/b.bin": bytes.Repeat([]byte("b"), size),
+		// c.bin changed since it was listed, the first run fails on it
+		"src/c.bin": bytes.Repeat([]byte("c"), size+1),
+	}, parts: map[string]map[int][]byte{}, failing: map[string]bool{}}
+	client := store.client()
+	var objectList []*S3Obj
+	for _, o := range []struct {
+		key  string
+		size int64
+	}{{"missing.txt", 10}, {"a.bin", size}, {"b.bin", size}, {"c.bin", size}} {
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src", o.key), WithSize(o.size)))
+	}
+	const state = "s3://state/job/"
+	opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 1, OnError: OnErrorSkip, State: state}
+	if err := createFromList(ctx, client, objectList, opts); !errors.Is(err, ErrSourceChanged) {
+		t.Fatalf("createFromList() error = %v, want %v", err, ErrSourceChanged)
+	}
+	if _, ok := store.parts["dst/a.tar"]; !ok {
+		t.Fatal("the multipart upload was aborted")
+	}
+	job, err := loadJobState(ctx, client, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(job.Groups) != 3 || job.Completed {
+		t.Fatalf("the job has %d parts, completed %t, want 3 parts", len(job.Groups), job.Completed)
+	}
+
+	// the objects of the parts uploaded aren't read again
+	groups := job.plan(job.objectList())
+	for i := range job.parts {
+		for _, o := range groups[i] {
+			store.failing[o.Bucket+"/"+*o.Key] = true
+		}
+	}
+	store.objects["src/c.bin"] = store.objects["src/c.bin"][:size]
+	var summary *RunSummary
+	if err := Resume(ctx, client, state, WithSummary(func(s *RunSummary) { summary = s })); err != nil {
+		t.Fatal(err)
+	}
+	var members []string
+	tr := tar.NewReader(bytes.NewReader(store.objects["dst/a.tar"]))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		members = append(members, fmt.Sprintf("%s=%d%c", hdr.Name, len(data), data[0]))
+	}
+	if want := fmt.Sprintf("a.bin=%[1]da,b.bin=%[1]db,c.bin=%[1]dc", size); strings.Join(members, ",") != want {
+		t.Errorf("the archive has %v, want %s", members, want)
+	}
+	if summary == nil || len(summary.Skipped) != 1 || summary.Skipped[0] != "s3://src/missing.txt" {
+		t.Errorf("summary = %+v, want missing.txt skipped", summary)
+	}
+	if manifest := string(store.objects["dst/a.tar.errors.csv"]); !strings.Contains(manifest, "src,missing.txt,10,,NotFound") {
+		t.Errorf("error manifest = %q, want missing.txt not found", manifest)
+	}
+
+	// a completed job is left as it is
+	if job, err = loadJobState(ctx, client, state); err != nil || !job.Completed {
+		t.Fatalf("the job isn't completed: %v", err)
+	}
+	delete(store.objects, "dst/a.tar")
+	if err := Resume(ctx, client, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.objects["dst/a.tar"]; ok {
+		t.Error("the completed job was run again")
+	}
+	if err := Resume(ctx, client, "s3://state/other/"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resume(no state) error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	const size = 6 << 20
+	store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	var objectList []*S3Obj
+	for _, key := range []string{"a.bin", "b.bin", "c.bin"} {
+		store.objects["src/"+key] = bytes.Repeat([]byte(key[:1]), size)
+		objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src", key), WithSize(size)))
+	}
+	drain := make(chan struct{})
+	var once sync.Once
+	store.uploaded = func() { once.Do(func() { close(drain) }) }
+	client := store.client()
+	ctx := WithDrain(SetupLogger(context.Background()), drain)
+	opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 1}
+	if err := createFromList(ctx, client, objectList, opts); !errors.Is(err, ErrResumable) {
+		t.Fatalf("createFromList() error = %v, want %v", err, ErrResumable)
+	}
+	if _, ok := store.parts["dst/a.tar"]; !ok {
+		t.Fatal("the multipart upload was aborted")
+	}
+	if _, ok := store.objects["dst/a.tar"]; ok {
+		t.Fatal("the archive was completed")
+	}
+	if err := Resume(SetupLogger(context.Background()), client, "s3://dst/a.tar.state/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(store.objects["dst/a.tar"]), 3*(size+512)+1024; got < want {
+		t.Errorf("the archive has %d bytes, want at least %d", got, want)
+	}
+}
+
+func TestLoadJobState_PlanDigest(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	client := store.client()
+	objectList := testObjects(10, 20, 30)
+	opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", State: "s3://state/job/"}
+	job := newJobState(ctx, opts, [][]*S3Obj{objectList[:1], objectList[1:]}, fileSizeMin, "upload")
+	if err := job.save(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadJobState(ctx, client, opts.State); err != nil {
+		t.Fatal(err)
+	}
+	// the same objects in other parts
+	job.Groups = []int{2, 1}
+	if err := job.save(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadJobState(ctx, client, opts.State); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("loadJobState() error = %v, want %v", err, ErrInvalidArgument)
+	}
+}
+
+func TestValidateState(t *testing.T) {
+	local := NewS3ObjOptions(WithBucketAndKey("", "a.txt"), WithSize(1))
+	local.localPath = "/tmp/a.txt"
+	other := NewS3ObjOptions(WithBucketAndKey("src", "a.txt"), WithSize(1))
+	other.source = &Source{Bucket: "src", Client: (&fakeStore{}).client()}
+	tests := map[string]struct {
+		opts    S3TarS3Options
+		object  *S3Obj
+		wantErr error
+	}{
+		"in memory":      {opts: S3TarS3Options{State: "s3://state/", ConcatInMemory: true}},
+		"no state":       {opts: S3TarS3Options{}, object: local},
+		"local state":    {opts: S3TarS3Options{State: "/tmp/state", ConcatInMemory: true}, wantErr: ErrInvalidArgument},
+		"server-side":    {opts: S3TarS3Options{State: "s3://state/"}, wantErr: ErrInvalidArgument},
+		"local archive":  {opts: S3TarS3Options{State: "s3://state/", ConcatInMemory: true, DstPath: "/tmp/a.tar"}, wantErr: ErrInvalidArgument},
+		"exact-fit":      {opts: S3TarS3Options{State: "s3://state/", ConcatInMemory: true, PartPadding: PartPaddingExactFit}, wantErr: ErrInvalidArgument},
+		"local file":     {opts: S3TarS3Options{State: "s3://state/", ConcatInMemory: true}, object: local, wantErr: ErrInvalidArgument},
+		"another region": {opts: S3TarS3Options{State: "s3://state/", ConcatInMemory: true}, object: other, wantErr: ErrInvalidArgument},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			objectList := []*S3Obj{NewS3ObjOptions(WithBucketAndKey("src", "b.txt"), WithSize(1))}
+			if tt.object != nil {
+				objectList = append(objectList, tt.object)
+			}
+			if err := validateState(objectList, &tt.opts); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateState() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
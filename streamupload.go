@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// streamPartGrowth is the number of parts of a streamUpload after which its
+// part size doubles, so an object of unknown size fits in maxPartNumLimit
+// parts: 16MiB parts grow to over 5TB in ten steps.
+const streamPartGrowth = 1000
+
+// streamUpload writes an object of unknown size with a multipart upload of
+// the data given to it in order. Its parts double in size every
+// streamPartGrowth parts, up to the part size limit of the provider.
+type streamUpload struct {
+	svc      *s3.Client
+	opts     *S3TarS3Options
+	uploadId string
+	partSize int64
+	buf      []byte
+	parts    []types.CompletedPart
+	// size is the number of bytes written.
+	size int64
+}
+
+// newStreamUpload creates the multipart upload of s3://DstBucket/DstKey of
+// opts, about estimate bytes, or of an unknown size when estimate is -1,
+// written in parts of the largest size of the provider.
+func newStreamUpload(ctx context.Context, svc *s3.Client, opts *S3TarS3Options, estimate int64) (*streamUpload, error) {
+	partSize := int64(streamPartSize)
+	// room for data that grows when it's compressed
+	if n := (estimate + estimate/64 + maxPartNumLimit - 1) / maxPartNumLimit; n > partSize {
+		partSize = n
+	}
+	if estimate < 0 || partSize > opts.provider.maxPartSize() {
+		partSize = opts.provider.maxPartSize()
+	}
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:               &opts.DstBucket,
+		Key:                  &opts.DstKey,
+		StorageClass:         opts.storageClass,
+		ChecksumAlgorithm:    opts.provider.checksumAlgorithm(),
+		ACL:                  opts.provider.acl(),
+		ServerSideEncryption: opts.SSEAlgo,
+		Metadata:             archiveMetadata(opts),
+	}
+	if opts.KMSKeyID != "" {
+		input.SSEKMSKeyId = &opts.KMSKeyID
+	}
+	if len(opts.ObjectTags.TagSet) > 0 {
+		input.Tagging = aws.String(TagsToUrlEncodedString(opts.ObjectTags))
+	}
+	mpu, err := svc.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return &streamUpload{svc: svc, opts: opts, uploadId: *mpu.UploadId, partSize: partSize}, nil
+}
+
+// write adds data to the object, and uploads the parts filled.
+func (u *streamUpload) write(ctx context.Context, data []byte) error {
+	u.buf = append(u.buf, data...)
+	u.size += int64(len(data))
+	for int64(len(u.buf)) >= u.partSize {
+		if err := u.uploadPart(ctx, u.buf[:u.partSize]); err != nil {
+			return err
+		}
+		u.buf = append(u.buf[:0], u.buf[u.partSize:]...)
+	}
+	return nil
+}
+
+func (u *streamUpload) uploadPart(ctx context.Context, data []byte) error {
+	partNum := int32(len(u.parts) + 1)
+	if partNum > maxPartNumLimit {
+		return fmt.Errorf("%w: %s needs more than %d parts", ErrTooManyParts, objectURL(u.opts.DstBucket, u.opts.DstKey), maxPartNumLimit)
+	}
+	res, err := uploadPart(ctx, u.svc, u.uploadId, u.opts.DstBucket, u.opts.DstKey, data, &partNum, u.opts.provider.checksumAlgorithm())
+	if err != nil {
+		return classifyError(err)
+	}
+	trackUploaded(ctx, int64(len(data)))
+	u.parts = append(u.parts, types.CompletedPart{ETag: res.ETag, PartNumber: aws.Int32(partNum), ChecksumSHA256: res.ChecksumSHA256})
+	if len(u.parts)%streamPartGrowth == 0 && 2*u.partSize <= u.opts.provider.maxPartSize() {
+		u.partSize *= 2
+	}
+	return nil
+}
+
+// complete uploads the last part and assembles the object.
+func (u *streamUpload) complete(ctx context.Context) error {
+	if len(u.buf) > 0 || len(u.parts) == 0 {
+		if err := u.uploadPart(ctx, u.buf); err != nil {
+			u.abort(ctx)
+			return err
+		}
+	}
+	_, err := u.svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &u.opts.DstBucket,
+		Key:             &u.opts.DstKey,
+		UploadId:        &u.uploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: u.parts},
+	})
+	if err != nil {
+		u.abort(ctx)
+		return classifyError(err)
+	}
+	return nil
+}
+
+func (u *streamUpload) abort(ctx context.Context) {
+	abortUpload(ctx, u.svc, u.opts.DstBucket, u.opts.DstKey, u.uploadId)
+}
+
+// uploadWriter writes to a streamUpload as an io.Writer.
+type uploadWriter struct {
+	ctx    context.Context
+	upload *streamUpload
+}
+
+func (w uploadWriter) Write(p []byte) (int, error) {
+	if err := w.upload.write(w.ctx, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
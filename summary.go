@@ -0,0 +1,227 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// RunSummary describes the archive produced by a run.
+type RunSummary struct {
+	RunID           string       `json:"run_id,omitempty"`
+	Bucket          string       `json:"bucket"`
+	Key             string       `json:"key"`
+	ETag            string       `json:"etag"`
+	Size            int64        `json:"size"`
+	Members         int          `json:"members"`
+	Duration        string       `json:"duration"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Retries         int64        `json:"retries"`
+	Skipped         []string     `json:"skipped"`
+	ErrorManifest   string       `json:"error_manifest,omitempty"`
+	Toc             *TocLocation `json:"toc,omitempty"`
+	Parts           []PartReport `json:"parts,omitempty"`
+	// IgnoredDeleteMarkers is the number of keys left out for their delete
+	// marker with DeleteMarkersIgnore.
+	IgnoredDeleteMarkers int `json:"ignored_delete_markers,omitempty"`
+}
+
+// TocLocation is the byte range of the toc.csv member inside the archive.
+type TocLocation struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	Size  int64  `json:"size"`
+}
+
+// PartReport is a part of the multipart upload of an archive built in memory,
+// with the members whose data it holds, to trace a part whose checksum
+// doesn't match back to the objects in it.
+type PartReport struct {
+	PartNumber int32 `json:"part_number"`
+	// Offset is where the part starts in the archive.
+	Offset   int64        `json:"offset"`
+	Size     int64        `json:"size"`
+	ETag     string       `json:"etag"`
+	Checksum string       `json:"checksum,omitempty"`
+	Members  []PartMember `json:"members"`
+}
+
+// PartMember is the data of a member held by a part, Size bytes from Offset
+// in the part. MemberOffset is where they start in the data of the member,
+// not 0 when the member started in a part before with exact-fit parts.
+type PartMember struct {
+	Name         string `json:"name"`
+	Source       string `json:"source,omitempty"`
+	Offset       int64  `json:"offset"`
+	Size         int64  `json:"size"`
+	MemberOffset int64  `json:"member_offset,omitempty"`
+}
+
+const contextKeyParts = contextKey("parts")
+
+// partTracker holds the parts of the archive built last.
+type partTracker struct {
+	parts []PartReport
+}
+
+func withPartTracker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyParts, &partTracker{})
+}
+
+func recordParts(ctx context.Context, parts []PartReport) {
+	if t, ok := ctx.Value(contextKeyParts).(*partTracker); ok {
+		t.parts = parts
+	}
+}
+
+func partReports(ctx context.Context) []PartReport {
+	if t, ok := ctx.Value(contextKeyParts).(*partTracker); ok {
+		return t.parts
+	}
+	return nil
+}
+
+// newPartReports returns the reports of the parts of an archive built in
+// memory from groups, whose members are in tocs with offsets from the start
+// of their group of groupSizes bytes. A group is a part, or with exact-fit
+// the members are cut into parts of partSize. The parts are sorted by number.
+func newPartReports(groups [][]*S3Obj, tocs []TOC, groupSizes []int64, parts []types.CompletedPart, exactFit bool, partSize int64) []PartReport {
+	reports := make([]PartReport, len(parts))
+	var offset int64
+	for k, part := range parts {
+		size := partSize
+		if !exactFit {
+			size = groupSizes[k]
+		}
+		if k == len(parts)-1 {
+			size = sumSlice(groupSizes) + int64(len(endOfArchive())) - offset
+		}
+		reports[k] = PartReport{
+			PartNumber: aws.ToInt32(part.PartNumber),
+			Offset:     offset,
+			Size:       size,
+			ETag:       strings.Trim(aws.ToString(part.ETag), `"`),
+			Checksum:   formatChecksum(part.ChecksumCRC32, part.ChecksumCRC32C, part.ChecksumSHA1, part.ChecksumSHA256),
+			Members:    []PartMember{},
+		}
+		offset += size
+	}
+
+	k := 0
+	var groupStart int64
+	for i, toc := range tocs {
+		// the members are the objects of the group in order, but the ones
+		// skipped, and the padding member of the part
+		objects := groups[i]
+		for _, m := range toc {
+			source := ""
+			for len(objects) > 0 && inMemoryHeader(objects[0]).Name != m.Filename {
+				objects = objects[1:]
+			}
+			if len(objects) > 0 {
+				source, objects = objectURL(objects[0].Bucket, *objects[0].Key), objects[1:]
+			}
+			start, end := groupStart+m.Start, groupStart+m.Start+m.Size
+			for k < len(reports)-1 && reports[k].Offset+reports[k].Size <= start {
+				k++
+			}
+			for p := k; p < len(reports); p++ {
+				r := &reports[p]
+				from, to := start, end
+				if from < r.Offset {
+					from = r.Offset
+				}
+				if to > r.Offset+r.Size {
+					to = r.Offset + r.Size
+				}
+				if from > to || (from == to && m.Size > 0) {
+					break
+				}
+				r.Members = append(r.Members, PartMember{Name: m.Filename, Source: source, Offset: from - r.Offset, Size: to - from, MemberOffset: from - start})
+				if to == end {
+					break
+				}
+			}
+		}
+		groupStart += groupSizes[i]
+	}
+	return reports
+}
+
+// RetryCounter wraps an aws.Retryer and counts every retry it grants, so runs
+// can report how many requests had to be retried.
+type RetryCounter struct {
+	aws.Retryer
+	retries int64
+}
+
+func NewRetryCounter(r aws.Retryer) *RetryCounter {
+	return &RetryCounter{Retryer: r}
+}
+
+func (r *RetryCounter) RetryDelay(attempt int, opErr error) (time.Duration, error) {
+	atomic.AddInt64(&r.retries, 1)
+	return r.Retryer.RetryDelay(attempt, opErr)
+}
+
+// Retries returns the number of retries so far.
+func (r *RetryCounter) Retries() int64 {
+	return atomic.LoadInt64(&r.retries)
+}
+
+func clientRetries(svc *s3.Client) int64 {
+	if rc, ok := svc.Options().Retryer.(*RetryCounter); ok {
+		return rc.Retries()
+	}
+	return 0
+}
+
+func newRunSummary(ctx context.Context, svc *s3.Client, obj *S3Obj, members int, elapsed time.Duration, retries int64) *RunSummary {
+	summary := &RunSummary{
+		RunID:           runID(ctx),
+		Bucket:          obj.Bucket,
+		Key:             *obj.Key,
+		Members:         members,
+		Duration:        elapsed.String(),
+		DurationSeconds: elapsed.Seconds(),
+		Retries:         retries,
+		Skipped:         []string{},
+	}
+	if obj.ETag != nil {
+		summary.ETag = *obj.ETag
+	}
+	if obj.Size != nil {
+		summary.Size = *obj.Size
+	}
+	if obj.Bucket == "" {
+		// a local archive, built in memory without a toc.csv member
+		return summary
+	}
+	hdr, offset, err := extractTarHeader(ctx, svc, obj.Bucket, *obj.Key)
+	if err == nil && hdr.Name == "toc.csv" {
+		summary.Toc = &TocLocation{Name: hdr.Name, Start: offset, Size: hdr.Size}
+	}
+	return summary
+}
+
+// WriteSummary writes the summaries as JSON lines to a local path or an s3:// url.
+func WriteSummary(ctx context.Context, svc *s3.Client, location string, summaries ...*RunSummary) error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, s := range summaries {
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	return saveFile(ctx, svc, location, buf.Bytes())
+}
@@ -0,0 +1,162 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestPartReports(t *testing.T) {
+	const mb = 1 << 20
+	tests := map[string]struct {
+		padding   PartPadding
+		wantParts int
+	}{
+		"a part per group": {wantParts: 2},
+		"exact-fit":        {padding: PartPaddingExactFit, wantParts: 3},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := SetupLogger(context.Background())
+			store := &fakeStore{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+			var objectList []*S3Obj
+			for _, o := range []struct {
+				key  string
+				size int
+			}{{"a.bin", 6 * mb}, {"b.bin", 3 * mb}, {"c.bin", 4 * mb}} {
+				store.objects["src/"+o.key] = bytes.Repeat([]byte(o.key[:1]), o.size)
+				objectList = append(objectList, NewS3ObjOptions(WithBucketAndKey("src", o.key), WithSize(int64(o.size))))
+			}
+			var summary *RunSummary
+			opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 1, PartPadding: tt.padding,
+				SummaryFn: func(s *RunSummary) { summary = s }}
+			if err := createFromList(ctx, store.client(), objectList, opts); err != nil {
+				t.Fatal(err)
+			}
+			if summary == nil || len(summary.Parts) != tt.wantParts {
+				t.Fatalf("summary = %+v, want %d parts", summary, tt.wantParts)
+			}
+			read := map[string]int64{}
+			var offset int64
+			for _, p := range summary.Parts {
+				data := store.parts["dst/a.tar"][int(p.PartNumber)]
+				if p.Offset != offset || p.Size != int64(len(data)) {
+					t.Errorf("part %d is %d bytes at %d, want %d at %d", p.PartNumber, p.Size, p.Offset, len(data), offset)
+				}
+				offset += p.Size
+				for _, m := range p.Members {
+					source := store.objects[m.Source[len("s3://"):]]
+					if !bytes.Equal(data[m.Offset:m.Offset+m.Size], source[m.MemberOffset:m.MemberOffset+m.Size]) {
+						t.Errorf("part %d doesn't have the data of %s at %d", p.PartNumber, m.Name, m.Offset)
+					}
+					read[m.Source] += m.Size
+				}
+			}
+			for _, o := range objectList {
+				if got := read[objectURL(o.Bucket, *o.Key)]; got != *o.Size {
+					t.Errorf("the parts have %d bytes of %s, want %d", got, *o.Key, *o.Size)
+				}
+			}
+		})
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	store := &fakeStore{objects: map[string][]byte{"src/a.txt": []byte("hello"), "src/b.txt": []byte("world!")}, parts: map[string]map[int][]byte{}}
+	objectList := []*S3Obj{
+		NewS3ObjOptions(WithBucketAndKey("src", "a.txt"), WithSize(5)),
+		NewS3ObjOptions(WithBucketAndKey("src", "b.txt"), WithSize(6)),
+	}
+	var summary *RunSummary
+	opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 1,
+		SummaryFn: func(s *RunSummary) { summary = s }}
+	if err := createFromList(ctx, store.client(), objectList, opts); err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil {
+		t.Fatal("SummaryFn wasn't called")
+	}
+	archive := store.objects["dst/a.tar"]
+	if summary.Bucket != "dst" || summary.Key != "a.tar" || summary.Size != int64(len(archive)) || summary.Members != 2 {
+		t.Errorf("summary = %+v, want dst/a.tar of %d bytes and 2 members", summary, len(archive))
+	}
+	if summary.ETag == "" || summary.Retries != 0 || len(summary.Skipped) != 0 {
+		t.Errorf("summary ETag = %q, Retries = %d, Skipped = %v, want an ETag, no retries and no skipped objects", summary.ETag, summary.Retries, summary.Skipped)
+	}
+	// the summaries are written as JSON lines
+	location := filepath.Join(t.TempDir(), "summary.json")
+	if err := WriteSummary(ctx, store.client(), location, summary, summary); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("WriteSummary() wrote %d lines, want 2", len(lines))
+	}
+	var got RunSummary
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != summary.Key || got.ETag != summary.ETag || got.Size != summary.Size || got.Members != summary.Members {
+		t.Errorf("WriteSummary() = %+v, want %+v", got, summary)
+	}
+}
+
+func TestNewRunSummary_toc(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	withToc, _ := tarWithToc(t, [][2]string{{"toc.csv", "a.txt,1536,5,etag\n"}, {"a.txt", "hello"}})
+	withoutToc, _ := tarWithToc(t, [][2]string{{"a.txt", "hello"}})
+	tests := map[string]struct {
+		archive []byte
+		want    *TocLocation
+	}{
+		"toc.csv member":    {archive: withToc, want: &TocLocation{Name: "toc.csv", Start: blockSize, Size: 18}},
+		"no toc.csv member": {archive: withoutToc},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			store := &fakeStore{objects: map[string][]byte{"dst/a.tar": tt.archive}}
+			obj := NewS3ObjOptions(WithBucketAndKey("dst", "a.tar"), WithSize(int64(len(tt.archive))), WithETag("etag"))
+			summary := newRunSummary(ctx, store.client(), obj, 1, time.Second, 2)
+			if summary.ETag != "etag" || summary.Size != int64(len(tt.archive)) || summary.DurationSeconds != 1 || summary.Retries != 2 {
+				t.Errorf("newRunSummary() = %+v", summary)
+			}
+			if (summary.Toc == nil) != (tt.want == nil) || tt.want != nil && *summary.Toc != *tt.want {
+				t.Errorf("newRunSummary() Toc = %+v, want %+v", summary.Toc, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryCounter(t *testing.T) {
+	r := NewRetryCounter(aws.NopRetryer{})
+	for i := 1; i <= 3; i++ {
+		r.RetryDelay(i, errors.New("throttled"))
+	}
+	if got := r.Retries(); got != 3 {
+		t.Errorf("Retries() = %d, want 3", got)
+	}
+	svc := s3.New(s3.Options{Region: "us-west-2", Retryer: r})
+	if got := clientRetries(svc); got != 3 {
+		t.Errorf("clientRetries() = %d, want 3", got)
+	}
+	if got := clientRetries(s3.New(s3.Options{Region: "us-west-2"})); got != 0 {
+		t.Errorf("clientRetries() = %d without a RetryCounter, want 0", got)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ObjectTemplateData is the data templates are executed with, one per object.
+// Name is the member name, Dir, Base and Ext are the parts of it.
+type ObjectTemplateData struct {
+	Bucket       string
+	Key          string
+	Name         string
+	Dir          string
+	Base         string
+	Ext          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+func newObjectTemplateData(o *S3Obj, name string) *ObjectTemplateData {
+	d := &ObjectTemplateData{
+		Bucket: o.Bucket,
+		Key:    *o.Key,
+		Name:   name,
+		Dir:    path.Dir(name),
+		Base:   path.Base(name),
+		Ext:    path.Ext(name),
+	}
+	if o.Size != nil {
+		d.Size = *o.Size
+	}
+	if o.ETag != nil {
+		d.ETag = strings.Trim(*o.ETag, `"`)
+	}
+	if o.LastModified != nil {
+		d.LastModified = *o.LastModified
+	}
+	return d
+}
+
+var templateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+}
+
+// parseObjectTemplate parses text as a template executed with ObjectTemplateData.
+func parseObjectTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func executeObjectTemplate(t *template.Template, o *S3Obj, name string) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, newObjectTemplateData(o, name)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
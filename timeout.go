@@ -0,0 +1,66 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+const contextKeyTimeouts = contextKey("timeouts")
+
+// timeouts bound a single download of an object and a single upload or copy
+// of a part, so a hung connection fails that request instead of stalling the
+// run. Zero leaves them unbounded.
+type timeouts struct {
+	object time.Duration
+	part   time.Duration
+}
+
+func validateTimeouts(opts *S3TarS3Options) error {
+	if opts.ObjectTimeout < 0 || opts.PartTimeout < 0 {
+		return fmt.Errorf("%w: timeouts can't be negative", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// withTimeouts returns a context that carries the timeouts of opts to the
+// requests of the run.
+func withTimeouts(ctx context.Context, opts *S3TarS3Options) context.Context {
+	return context.WithValue(ctx, contextKeyTimeouts, timeouts{object: opts.ObjectTimeout, part: opts.PartTimeout})
+}
+
+// objectContext returns the context of the download of an object, body
+// included.
+func objectContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	t, _ := ctx.Value(contextKeyTimeouts).(timeouts)
+	return withOptionalTimeout(ctx, t.object)
+}
+
+// partContext returns the context of the upload or the copy of a part.
+func partContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	t, _ := ctx.Value(contextKeyTimeouts).(timeouts)
+	return withOptionalTimeout(ctx, t.part)
+}
+
+func withOptionalTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// cancelOnClose releases the context of a body that is streamed after the
+// request returned, once the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,50 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutContexts(t *testing.T) {
+	ctx := withTimeouts(context.Background(), &S3TarS3Options{ObjectTimeout: time.Minute})
+	objectCtx, cancel := objectContext(ctx)
+	defer cancel()
+	if deadline, ok := objectCtx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("objectContext() deadline = %v, %v, want within a minute", deadline, ok)
+	}
+	partCtx, cancel := partContext(ctx)
+	defer cancel()
+	if _, ok := partCtx.Deadline(); ok {
+		t.Errorf("partContext() has a deadline without a part timeout")
+	}
+	if _, ok := func() (time.Time, bool) {
+		ctx, cancel := objectContext(context.Background())
+		defer cancel()
+		return ctx.Deadline()
+	}(); ok {
+		t.Errorf("objectContext() has a deadline without timeouts in the context")
+	}
+
+	if err := validateTimeouts(&S3TarS3Options{PartTimeout: -time.Second}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("validateTimeouts(-1s) error = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestCancelOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := cancelOnClose{io.NopCloser(strings.NewReader("data")), cancel}
+	if ctx.Err() != nil {
+		t.Fatalf("context canceled before the body is closed")
+	}
+	r.Close()
+	if ctx.Err() == nil {
+		t.Errorf("context not canceled when the body is closed")
+	}
+}
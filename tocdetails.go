@@ -0,0 +1,137 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// The columns of a csv TOC, in order. A TOC has the first four, the checksum
+// with TocChecksums, the origin of the member for archives merged from several
+// sources, the ones up to the owner with TocExtended and all of them next to
+// an archive written by Recompress.
+const (
+	tocFieldsBasic    = 4
+	tocFieldsChecksum = 5
+	tocFieldsOrigin   = 6
+	tocFieldsExtended = 10
+	tocFieldsFramed   = 11
+)
+
+// objectDetails are the columns of an extended TOC that aren't known from the
+// listing of an object, read from its HEAD for the archives built
+// server-side.
+type objectDetails struct {
+	versionId    *string
+	storageClass string
+	contentType  *string
+}
+
+// setDetails sets the extended columns of m, the member of o, from the
+// response of the request that read o, or its HEAD. Amazon S3 leaves out the
+// storage class of STANDARD objects.
+func (m *FileMetadata) setDetails(o *S3Obj, d objectDetails) {
+	m.VersionId = aws.ToString(d.versionId)
+	if m.VersionId == "" {
+		m.VersionId = o.VersionId
+	}
+	m.StorageClass = d.storageClass
+	if m.StorageClass == "" {
+		m.StorageClass = string(o.StorageClass)
+	}
+	if m.StorageClass == "" && !o.isLocal() && !o.isPresigned() {
+		m.StorageClass = string(types.StorageClassStandard)
+	}
+	m.ContentType = aws.ToString(d.contentType)
+	if o.Owner != nil {
+		m.Owner = aws.ToString(o.Owner.ID)
+	}
+}
+
+// responseDetails are the details of the object read with output.
+func responseDetails(output *s3.GetObjectOutput) objectDetails {
+	if output == nil {
+		return objectDetails{}
+	}
+	return objectDetails{versionId: output.VersionId, storageClass: string(output.StorageClass), contentType: output.ContentType}
+}
+
+// fetchDetails sets the checksum and the details of every object of
+// objectList with HeadObject, for the extended TOC of archives built
+// server-side, whose objects are never read.
+func fetchDetails(ctx context.Context, svc *s3.Client, objectList []*S3Obj, opts *S3TarS3Options) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Threads)
+	for _, o := range objectList {
+		o := o
+		if len(o.Data) > 0 || o.NoHeaderRequired || isObjectLambda(o.Bucket) {
+			continue
+		}
+		g.Go(func() error {
+			head, err := readClient(svc, o).HeadObject(gctx, &s3.HeadObjectInput{
+				Bucket:       &o.Bucket,
+				Key:          o.Key,
+				VersionId:    versionID(o),
+				ChecksumMode: types.ChecksumModeEnabled,
+			})
+			if err != nil {
+				return &ObjectError{Bucket: o.Bucket, Key: *o.Key, Err: fmt.Errorf("reading its details: %w", classifyError(err))}
+			}
+			o.Checksum = formatChecksum(head.ChecksumCRC32, head.ChecksumCRC32C, head.ChecksumSHA1, head.ChecksumSHA256)
+			o.details = &objectDetails{versionId: head.VersionId, storageClass: string(head.StorageClass), contentType: head.ContentType}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// tocFields is the number of columns of every line of toc.
+func tocFields(toc TOC, checksums, extended bool) int {
+	for _, m := range toc {
+		if m.FrameOffset != nil {
+			return tocFieldsFramed
+		}
+	}
+	if extended {
+		return tocFieldsExtended
+	}
+	fields := tocFieldsBasic
+	if checksums {
+		fields = tocFieldsChecksum
+	}
+	for _, m := range toc {
+		if m.Origin != "" {
+			return tocFieldsOrigin
+		}
+	}
+	return fields
+}
+
+// tocRecord is the line of m in a csv TOC with fields columns.
+func tocRecord(m *FileMetadata, fields int) []string {
+	record := []string{
+		m.Filename,
+		strconv.FormatInt(m.Start, 10),
+		strconv.FormatInt(m.Size, 10),
+		m.Etag,
+		m.Checksum,
+		m.Origin,
+		m.VersionId,
+		m.StorageClass,
+		m.ContentType,
+		m.Owner,
+		"",
+	}
+	if m.FrameOffset != nil {
+		record[10] = strconv.FormatInt(*m.FrameOffset, 10)
+	}
+	return record[:fields]
+}
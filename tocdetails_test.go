@@ -0,0 +1,91 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestExtendedToc(t *testing.T) {
+	a := NewS3ObjOptions(WithBucketAndKey("b", "a.txt"), WithSize(5), WithETag(`"abc"`))
+	a.Owner = &types.Owner{ID: aws.String("owner-id")}
+	a.Checksum = "sha256:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	a.details = &objectDetails{versionId: aws.String("v1"), storageClass: "GLACIER_IR", contentType: aws.String("text/plain")}
+	b := NewS3ObjOptions(WithBucketAndKey("b", "b.txt"), WithSize(5), WithETag(`"def"`))
+	b.details = &objectDetails{}
+	objectList := []*S3Obj{a, b}
+	headers := []*S3Obj{NewS3ObjOptions(WithSize(blockSize)), NewS3ObjOptions(WithSize(blockSize))}
+
+	buf, err := createCSVTOC(0, headers, objectList)
+	if err != nil {
+		t.Fatalf("createCSVTOC() error = %v", err)
+	}
+	toc, err := parseTocCSV(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("parseTocCSV() error = %v", err)
+	}
+	want := FileMetadata{Filename: "a.txt", Start: toc[0].Start, Size: 5, Etag: `"abc"`, Checksum: a.Checksum, VersionId: "v1", StorageClass: "GLACIER_IR", ContentType: "text/plain", Owner: "owner-id"}
+	if len(toc) != 2 || *toc[0] != want {
+		t.Fatalf("parseTocCSV() = %q, want %+v", buf.String(), want)
+	}
+	if toc[1].StorageClass != "STANDARD" || toc[1].VersionId != "" || toc[1].Owner != "" {
+		t.Errorf("parseTocCSV() b.txt = %+v, want STANDARD without a version or owner", toc[1])
+	}
+
+	m := &FileMetadata{}
+	m.setDetails(b, responseDetails(&s3.GetObjectOutput{VersionId: aws.String("v2"), StorageClass: types.StorageClassStandardIa, ContentType: aws.String("image/png")}))
+	if m.VersionId != "v2" || m.StorageClass != "STANDARD_IA" || m.ContentType != "image/png" {
+		t.Errorf("setDetails() = %+v", m)
+	}
+}
+
+func TestTocFields(t *testing.T) {
+	toc := TOC{{Filename: "a"}}
+	tests := map[string]struct {
+		toc                 TOC
+		checksums, extended bool
+		want                int
+	}{
+		"basic":     {toc: toc, want: 4},
+		"checksums": {toc: toc, checksums: true, want: 5},
+		"origins":   {toc: TOC{{Filename: "a", Origin: "s3://b/a"}}, want: 6},
+		"extended":  {toc: toc, extended: true, want: 10},
+	}
+	for name, tt := range tests {
+		if got := tocFields(tt.toc, tt.checksums, tt.extended); got != tt.want {
+			t.Errorf("%s: tocFields() = %d, want %d", name, got, tt.want)
+		}
+		if got := len(tocRecord(tt.toc[0], tt.want)); got != tt.want {
+			t.Errorf("%s: tocRecord() has %d fields, want %d", name, got, tt.want)
+		}
+	}
+}
+
+func TestFileMetadataJSON(t *testing.T) {
+	tests := map[string]struct {
+		m    *FileMetadata
+		want string
+	}{
+		"toc":      {m: &FileMetadata{Filename: "a.txt", Start: 1536, Size: 5, Etag: `"e1"`}, want: `{"name":"a.txt","offset":1536,"size":5,"etag":"\"e1\""}`},
+		"empty":    {m: &FileMetadata{Filename: "empty", Start: 2560}, want: `{"name":"empty","offset":2560,"size":0}`},
+		"extended": {m: &FileMetadata{Filename: "b", Checksum: "c", VersionId: "v", StorageClass: "GLACIER", ContentType: "text/plain", Owner: "o"}, want: `{"name":"b","offset":0,"size":0,"checksum":"c","version_id":"v","storage_class":"GLACIER","content_type":"text/plain","owner":"o"}`},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := json.Marshal(tt.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("json.Marshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// HTTPOptions tunes the HTTP transport of the Amazon S3 clients. Zero values
+// keep the defaults of the SDK, which keeps 10 idle connections per host: with
+// more concurrent requests the others are closed and dialed again.
+type HTTPOptions struct {
+	MaxIdleConnsPerHost   int
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// DisableHTTP2 keeps every connection on HTTP/1.1.
+	DisableHTTP2 bool
+}
+
+// NewHTTPClient returns the HTTP client of o, to load the client configuration
+// with config.WithHTTPClient.
+func NewHTTPClient(o HTTPOptions) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		if o.MaxIdleConnsPerHost > 0 {
+			tr.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+			if tr.MaxIdleConns < o.MaxIdleConnsPerHost {
+				tr.MaxIdleConns = o.MaxIdleConnsPerHost
+			}
+		}
+		if o.TLSHandshakeTimeout > 0 {
+			tr.TLSHandshakeTimeout = o.TLSHandshakeTimeout
+		}
+		if o.ResponseHeaderTimeout > 0 {
+			tr.ResponseHeaderTimeout = o.ResponseHeaderTimeout
+		}
+		if o.DisableHTTP2 {
+			tr.ForceAttemptHTTP2 = false
+			tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	}).WithDialerOptions(func(d *net.Dialer) {
+		if o.DialTimeout > 0 {
+			d.Timeout = o.DialTimeout
+		}
+	})
+}
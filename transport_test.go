@@ -0,0 +1,39 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	c := NewHTTPClient(HTTPOptions{
+		MaxIdleConnsPerHost:   200,
+		DialTimeout:           5 * time.Second,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		DisableHTTP2:          true,
+	})
+	tr := c.GetTransport()
+	if tr.MaxIdleConnsPerHost != 200 || tr.MaxIdleConns < 200 {
+		t.Errorf("MaxIdleConnsPerHost = %d, MaxIdleConns = %d, want 200", tr.MaxIdleConnsPerHost, tr.MaxIdleConns)
+	}
+	if tr.TLSHandshakeTimeout != 3*time.Second || tr.ResponseHeaderTimeout != time.Minute {
+		t.Errorf("TLSHandshakeTimeout = %s, ResponseHeaderTimeout = %s", tr.TLSHandshakeTimeout, tr.ResponseHeaderTimeout)
+	}
+	if tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil {
+		t.Errorf("HTTP/2 isn't disabled")
+	}
+	if d := c.GetDialer(); d.Timeout != 5*time.Second {
+		t.Errorf("dial timeout = %s, want 5s", d.Timeout)
+	}
+
+	tr = NewHTTPClient(HTTPOptions{}).GetTransport()
+	if tr.MaxIdleConnsPerHost != awshttp.DefaultHTTPTransportMaxIdleConnsPerHost || !tr.ForceAttemptHTTP2 {
+		t.Errorf("zero HTTPOptions changed the SDK defaults")
+	}
+}
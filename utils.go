@@ -10,6 +10,7 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -24,6 +25,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 type contextKey string
@@ -34,33 +36,111 @@ const (
 
 var (
 	extractS3 = regexp.MustCompile(`s3://(.[^/]*)/?(.*)`)
+	// access point ARNs have a / between accesspoint and the name
+	extractS3AccessPoint = regexp.MustCompile(`s3://(arn:[^:/]+:s3(?:-object-lambda)?:[^:/]*:[^:/]*:accesspoint/[^/]+)/?(.*)`)
 )
 
 // S3TarS3Options options to create an archive
 type S3TarS3Options struct {
-	SrcManifest           string
-	SkipManifestHeader    bool
-	SrcBucket             string
-	SrcPrefix             string
-	SrcKey                string
-	DstBucket             string
-	DstPrefix             string
-	DstKey                string
-	Threads               int
-	DeleteSource          bool
-	Region                string
-	EndpointUrl           string
-	ExternalToc           string
-	tarFormat             tar.Format
-	storageClass          types.StorageClass
-	extractPrefix         string
-	ConcatInMemory        bool
-	UrlDecode             bool
-	UserMaxPartSize       int64
-	ObjectTags            types.Tagging
-	KMSKeyID              string
-	SSEAlgo               types.ServerSideEncryption
+	SrcManifest        string
+	SkipManifestHeader bool
+	SrcBucket          string
+	SrcPrefix          string
+	SrcKey             string
+	SrcPath            string
+	Sources            []Source
+	DstBucket          string
+	DstPrefix          string
+	DstKey             string
+	DstPath            string
+	Threads            int
+	DeleteSource       bool
+	Region             string
+	EndpointUrl        string
+	ExternalToc        string
+	tarFormat          tar.Format
+	storageClass       types.StorageClass
+	extractPrefix      string
+	extractFilter      *ExtractFilter
+	srcClient          *s3.Client
+	nameTransforms     []NameTransform
+	nameCollisions     CollisionPolicy
+	grouping           Grouping
+	headerTransforms   []HeaderTransform
+	paxRecords         []PAXRecord
+	ConcatInMemory     bool
+	UrlDecode          bool
+	UserMaxPartSize    int64
+	UserMinPartSize    int64
+	MPUThreshold       int64
+	ObjectTags         types.Tagging
+	KMSKeyID           string
+	SSEAlgo            types.ServerSideEncryption
+	SignTocKeyID       string
+	// TocTable is the Amazon DynamoDB table the TOC of the archive is
+	// exported to once it's created, see ExportToc.
+	TocTable   TocTable
+	Provenance bool
+	// ToolVersion is the version of s3tar recorded in the provenance.
+	ToolVersion           string
 	PreservePOSIXMetadata bool
+	// CompressionLevel is the zstd level of Recompress, 1 to 22, 3 when
+	// it's 0.
+	CompressionLevel int
+	// ZipStore stores the members of the zip file written by Convert
+	// uncompressed, for members that are compressed already.
+	ZipStore bool
+	// WindowsNames makes the names of the members extracted to DstPath
+	// valid on Windows, as on Windows itself.
+	WindowsNames bool
+	// NameMap is where the members extracted to DstPath under another name
+	// are listed, a local path or an s3:// url.
+	NameMap          string
+	Flat             bool
+	RecordOrigin     bool
+	OnError          ErrorPolicy
+	OnChange         ChangePolicy
+	OnArchived       ArchivedPolicy
+	OnConflict       ConflictPolicy
+	ErrorManifest    string
+	NoClobber        bool
+	Lock             LockMode
+	Idempotent       bool
+	Overwrite        OverwritePolicy
+	ServerSideOnly   bool
+	HybridThreshold  int64
+	PartRetries      int
+	ObjectTimeout    time.Duration
+	PartTimeout      time.Duration
+	RangeSize        int64
+	RangeConcurrency int
+	RangeBigMembers  bool
+	MaxBufferedParts int
+	Preflight        PreflightMode
+	TocChecksums     bool
+	TocExtended      bool
+	Prefetch         int
+	TinyPrefetch     int
+	PartPadding      PartPadding
+	PartOrder        PartOrder
+	Accelerate       bool
+	Provider         string
+	provider         Provider
+	DeleteMarkers    DeleteMarkerPolicy
+	Scratch          string
+	KeepScratch      bool
+	RunID            string
+	manifestHash     string
+	chain            *chainLink
+	// ignoredDeleteMarkers is the number of deleted keys DeleteMarkersIgnore
+	// left out of the run.
+	ignoredDeleteMarkers int
+	// State is the s3:// prefix the state of the job is persisted under,
+	// for Resume to continue it on any machine.
+	State      string
+	job        *jobState
+	ProgressFn func(Progress)    `json:"-"`
+	SummaryFn  func(*RunSummary) `json:"-"`
 }
 
 func TagsToUrlEncodedString(tagging types.Tagging) string {
@@ -73,6 +153,47 @@ func TagsToUrlEncodedString(tagging types.Tagging) string {
 
 }
 
+// sourceClient returns the client used to read source objects, which defaults
+// to the client used to write the destination.
+func sourceClient(svc *s3.Client, opts *S3TarS3Options) *s3.Client {
+	if opts.srcClient != nil {
+		return opts.srcClient
+	}
+	return svc
+}
+
+// noClobber returns the options that make the write of the archive fail when
+// the destination key already exists, with opts.NoClobber.
+func noClobber(opts *S3TarS3Options) []func(*s3.Options) {
+	if !opts.NoClobber {
+		return nil
+	}
+	return []func(*s3.Options){ifNoneMatchAny}
+}
+
+// ifNoneMatchAny makes a write fail when the key already exists.
+var ifNoneMatchAny = s3.WithAPIOptions(smithyhttp.AddHeaderValue("If-None-Match", "*"))
+
+// checkNoClobber fails early when opts.NoClobber is set and the archive
+// exists. The conditional write still protects against a run creating it in
+// the meantime.
+func checkNoClobber(ctx context.Context, svc *s3.Client, opts *S3TarS3Options) error {
+	if !opts.NoClobber {
+		return nil
+	}
+	if opts.DstPath != "" {
+		return checkLocalNoClobber(opts)
+	}
+	_, err := svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &opts.DstBucket, Key: &opts.DstKey})
+	if err == nil {
+		return &ObjectError{Bucket: opts.DstBucket, Key: opts.DstKey, Err: ErrDestinationExists}
+	}
+	if err = classifyError(err); !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
 func (o *S3TarS3Options) Copy() S3TarS3Options {
 	to := *o
 	return to
@@ -183,6 +304,46 @@ type S3Obj struct {
 	PartNum          int
 	Data             []byte
 	NoHeaderRequired bool
+	// Name is the member name inside the archive, the key when empty.
+	Name string
+	// PAXRecords are added to the member header.
+	PAXRecords map[string]string
+	// Checksum is the additional checksum of the object, see formatChecksum.
+	Checksum         string
+	headerTransforms []HeaderTransform
+	// tarFormat is the format of the member header, PAX when unknown, see
+	// setTarFormat.
+	tarFormat tar.Format
+	// localPath is the file the object is read from when it's local, see
+	// ListLocalFiles.
+	localPath string
+	// localName is the path of a local file relative to the directory
+	// archived, the member name the transforms start from.
+	localName string
+	// localRange is the bytes of a local file over a part archived by the
+	// object, see splitLocalFiles.
+	localRange *localRange
+	// presignedURL is the url the object is read from when it's listed in a
+	// manifest of presigned urls, see presignedObject.
+	presignedURL string
+	// VersionId is the version of the object that is read, the latest one
+	// when empty.
+	VersionId string
+	// deleteMarker is set on the keys listed by ListAllObjectVersions whose
+	// latest version is a delete marker and that aren't archived.
+	deleteMarker bool
+	// source is the source of the object when it's listed by ListSources.
+	source *Source
+	// details are the columns of an extended TOC read by fetchDetails.
+	details *objectDetails
+}
+
+// MemberName returns the name the object is stored with inside the archive.
+func (s *S3Obj) MemberName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return *s.Key
 }
 
 func (s *S3Obj) AddData(data []byte) {
@@ -216,7 +377,10 @@ type Logger struct {
 
 // ExtractBucketAndPath helper function to extract bucket and key from s3://bucket/prefix/key URLs
 func ExtractBucketAndPath(s3url string) (bucket string, path string) {
-	parts := extractS3.FindAllStringSubmatch(s3url, -1)
+	parts := extractS3AccessPoint.FindAllStringSubmatch(s3url, -1)
+	if len(parts) == 0 {
+		parts = extractS3.FindAllStringSubmatch(s3url, -1)
+	}
 	if len(parts) > 0 && len(parts[0]) > 2 {
 		bucket = parts[0][1]
 		path = parts[0][2]
@@ -241,10 +405,19 @@ func removeDirs(object types.Object) bool {
 	return true
 }
 
+// FlatFilter keeps only the objects at the level of prefix, leaving out the
+// ones under a deeper "/" delimited prefix.
+func FlatFilter(prefix string) func(types.Object) bool {
+	return func(object types.Object) bool {
+		return !strings.Contains(strings.TrimPrefix(*object.Key, prefix), "/")
+	}
+}
+
 func ListAllObjects(ctx context.Context, client *s3.Client, Bucket, Prefix string, filterFns ...func(types.Object) bool) ([]*S3Obj, int64, error) {
 	input := &s3.ListObjectsV2Input{
-		Bucket: &Bucket,
-		Prefix: &Prefix,
+		Bucket:     &Bucket,
+		Prefix:     &Prefix,
+		FetchOwner: aws.Bool(true),
 	}
 	var accum int64
 
@@ -261,7 +434,7 @@ func ListAllObjects(ctx context.Context, client *s3.Client, Bucket, Prefix strin
 		}
 		output, err := p.NextPage(ctx)
 		if err != nil {
-			log.Print(err.Error())
+			Errorf(ctx, "%s", err.Error())
 			return list, accum, err
 		}
 		contents := output.Contents
@@ -350,6 +523,27 @@ func loadFile(ctx context.Context, svc *s3.Client, path string) (io.ReadCloser,
 	}
 }
 
+// LoadFile opens path, an s3://bucket/key url or a local file, as the
+// manifests and TOCs given to a run are read.
+func LoadFile(ctx context.Context, svc *s3.Client, path string) (io.ReadCloser, error) {
+	return loadFile(ctx, svc, path)
+}
+
+// SaveFile writes data to path, an s3://bucket/key url or a local file, as
+// the TOCs and reports of a run are written.
+func SaveFile(ctx context.Context, svc *s3.Client, path string, data []byte) error {
+	return saveFile(ctx, svc, path, data)
+}
+
+func saveFile(ctx context.Context, svc *s3.Client, path string, data []byte) error {
+	if strings.Contains(path, "s3://") {
+		bucket, key := ExtractBucketAndPath(path)
+		_, err := putObject(ctx, svc, bucket, key, data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 // DeleteAllMultiparts helper function to clear ALL MultipartUploads in a bucket. This will delete all incomplete (or in progress) MPUs for a bucket.
 func DeleteAllMultiparts(client *s3.Client, bucket string) error {
 	output, err := client.ListMultipartUploads(context.TODO(), &s3.ListMultipartUploadsInput{Bucket: &bucket})
@@ -372,6 +566,15 @@ func DeleteAllMultiparts(client *s3.Client, bucket string) error {
 	return nil
 }
 
+// abortUpload aborts a multipart upload that can't be completed so its parts
+// aren't kept.
+func abortUpload(ctx context.Context, client *s3.Client, bucket, key, uploadId string) {
+	_, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: &bucket, Key: &key, UploadId: &uploadId})
+	if err != nil {
+		Warnf(ctx, "unable to abort multipart upload %s of s3://%s/%s: %s", uploadId, bucket, key, err.Error())
+	}
+}
+
 func _deleteObjectList(ctx context.Context, client *s3.Client, opts *S3TarS3Options, objectList []*S3Obj) error {
 	objects := make([]types.ObjectIdentifier, len(objectList))
 	for i := 0; i < len(objectList); i++ {
@@ -3,7 +3,21 @@
 
 package s3tar
 
-import "testing"
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
 
 func TestExtractBucketAndPath(t *testing.T) {
 	type args struct {
@@ -33,6 +47,18 @@ func TestExtractBucketAndPath(t *testing.T) {
 			wantBucket: "bucket",
 			wantPath:   "",
 		},
+		{
+			name:       "object lambda access point",
+			args:       args{s3url: "s3://arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/redact/logs/a.txt"},
+			wantBucket: "arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/redact",
+			wantPath:   "logs/a.txt",
+		},
+		{
+			name:       "access point, no prefix",
+			args:       args{s3url: "s3://arn:aws:s3:us-west-2:123456789012:accesspoint/logs"},
+			wantBucket: "arn:aws:s3:us-west-2:123456789012:accesspoint/logs",
+			wantPath:   "",
+		},
 		{
 			name:       "invalid path",
 			args:       args{s3url: "/home/yanko"},
@@ -58,3 +84,256 @@ func TestExtractBucketAndPath(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy  ErrorPolicy
+		want    ErrorPolicy
+		wantErr bool
+	}{
+		{policy: "", want: OnErrorFail},
+		{policy: OnErrorSkip, want: OnErrorSkip},
+		{policy: OnErrorRetryThenSkip, want: OnErrorRetryThenSkip},
+		{policy: "ignore", want: "ignore", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			opts := &S3TarS3Options{OnError: tt.policy}
+			err := validateErrorPolicy(opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateErrorPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if opts.OnError != tt.want {
+				t.Errorf("validateErrorPolicy() policy = %v, want %v", opts.OnError, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceChanged(t *testing.T) {
+	o := &S3Obj{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(10), ETag: aws.String("abc")}}
+	if got := ifMatch(o); got == nil || *got != `"abc"` {
+		t.Errorf("ifMatch() = %v, want \"abc\"", got)
+	}
+	if got := ifMatch(&S3Obj{Object: types.Object{ETag: aws.String(`""`)}}); got != nil {
+		t.Errorf("ifMatch() = %v, want nil", *got)
+	}
+	if err := checkUnchanged(o, &s3.GetObjectOutput{ContentLength: aws.Int64(10)}); err != nil {
+		t.Errorf("checkUnchanged() error = %v", err)
+	}
+	if err := checkUnchanged(o, &s3.GetObjectOutput{ContentLength: aws.Int64(11)}); !errors.Is(err, ErrSourceChanged) {
+		t.Errorf("checkUnchanged() error = %v, want ErrSourceChanged", err)
+	}
+	err := classifyError(&smithy.GenericAPIError{Code: "PreconditionFailed"})
+	if !errors.Is(err, ErrSourceChanged) {
+		t.Errorf("classifyError(PreconditionFailed) = %v, want ErrSourceChanged", err)
+	}
+	for _, policy := range []ChangePolicy{"", OnChangeSkip, OnChangeRefetch} {
+		opts := &S3TarS3Options{OnChange: policy, ConcatInMemory: true}
+		if err := validateChangePolicy(context.Background(), opts); err != nil || opts.OnChange == "" {
+			t.Errorf("validateChangePolicy(%s) = %v, policy %s", policy, err, opts.OnChange)
+		}
+	}
+	if err := validateChangePolicy(context.Background(), &S3TarS3Options{OnChange: "ignore"}); err == nil {
+		t.Errorf("validateChangePolicy(ignore) expected an error")
+	}
+}
+
+func TestObjectLambda(t *testing.T) {
+	arn := "arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/redact"
+	o := &S3Obj{Bucket: arn, Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(10), ETag: aws.String(`"0cc175b9c0f1b6a831c399e269772661"`)}}
+	if !isObjectLambda(arn) || isObjectLambda("arn:aws:s3:us-west-2:123456789012:accesspoint/logs") || isObjectLambda("bucket") {
+		t.Errorf("isObjectLambda() only matches Object Lambda access points")
+	}
+	if got := ifMatch(o); got != nil {
+		t.Errorf("ifMatch() = %s, want nil", *got)
+	}
+	if err := checkUnchanged(o, &s3.GetObjectOutput{ContentLength: aws.Int64(5)}); err != nil {
+		t.Errorf("checkUnchanged() error = %v", err)
+	}
+	if err := verifyObject(o, &s3.GetObjectOutput{}, 5, 5, make([]byte, md5.Size)); err != nil {
+		t.Errorf("verifyObject() error = %v", err)
+	}
+	if err := checkObjectLambda([]*S3Obj{o}, &S3TarS3Options{}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("checkObjectLambda() error = %v, want ErrInvalidArgument", err)
+	}
+	if err := checkObjectLambda([]*S3Obj{o}, &S3TarS3Options{ConcatInMemory: true}); err != nil {
+		t.Errorf("checkObjectLambda() error = %v", err)
+	}
+}
+
+func TestVerifyObject(t *testing.T) {
+	data := []byte("hello")
+	sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	o := &S3Obj{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(5), ETag: aws.String(etag)}}
+	tests := []struct {
+		name   string
+		output *s3.GetObjectOutput
+		n      int64
+		sum    []byte
+		fail   bool
+	}{
+		{name: "match", n: 5, sum: sum[:]},
+		{name: "short", n: 4, sum: sum[:], fail: true},
+		{name: "md5 mismatch", n: 5, sum: make([]byte, md5.Size), fail: true},
+		{name: "multipart", output: &s3.GetObjectOutput{ETag: aws.String(`"abc-2"`)}, n: 5, sum: make([]byte, md5.Size)},
+		{name: "kms", output: &s3.GetObjectOutput{ETag: aws.String(etag), ServerSideEncryption: types.ServerSideEncryptionAwsKms}, n: 5, sum: make([]byte, md5.Size)},
+		{name: "output etag", output: &s3.GetObjectOutput{ETag: aws.String(`"00000000000000000000000000000000"`)}, n: 5, sum: sum[:], fail: true},
+		{name: "checksum validated", output: &s3.GetObjectOutput{ChecksumSHA256: aws.String("LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=")}, n: 5},
+		{name: "checksum validated short", output: &s3.GetObjectOutput{ChecksumSHA256: aws.String("LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=")}, n: 4, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyObject(o, tt.output, 5, tt.n, tt.sum)
+			if tt.fail != errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("verifyObject() error = %v, want mismatch %v", err, tt.fail)
+			}
+		})
+	}
+}
+
+func TestNoClobber(t *testing.T) {
+	if got := noClobber(&S3TarS3Options{}); got != nil {
+		t.Errorf("noClobber() = %d options, want none", len(got))
+	}
+	if got := noClobber(&S3TarS3Options{NoClobber: true}); len(got) != 1 {
+		t.Errorf("noClobber() = %d options, want 1", len(got))
+	}
+	err := destinationError("bucket", "a.tar", &smithy.GenericAPIError{Code: "PreconditionFailed"})
+	if !errors.Is(err, ErrDestinationExists) || errors.Is(err, ErrSourceChanged) {
+		t.Errorf("destinationError(PreconditionFailed) = %v, want ErrDestinationExists", err)
+	}
+	other := &smithy.GenericAPIError{Code: "InternalError"}
+	if err := destinationError("bucket", "a.tar", other); err != other {
+		t.Errorf("destinationError(InternalError) = %v, want it unchanged", err)
+	}
+}
+
+func TestUseInMemory(t *testing.T) {
+	tests := []struct {
+		opts      S3TarS3Options
+		totalSize int64
+		want      bool
+	}{
+		{opts: S3TarS3Options{}, totalSize: fileSizeMin, want: false},
+		{opts: S3TarS3Options{}, totalSize: 1024, want: true},
+		{opts: S3TarS3Options{ConcatInMemory: true}, totalSize: fileSizeMin, want: true},
+		{opts: S3TarS3Options{ServerSideOnly: true}, totalSize: 1024, want: false},
+	}
+	for _, tt := range tests {
+		if got := useInMemory(&tt.opts, tt.totalSize); got != tt.want {
+			t.Errorf("useInMemory(%+v, %d) = %t, want %t", tt.opts, tt.totalSize, got, tt.want)
+		}
+	}
+}
+
+func TestObjectHead(t *testing.T) {
+	// no HEAD is requested for these, the client is never used
+	object := &S3Obj{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(10)}}
+	data := &S3Obj{Object: types.Object{Key: aws.String("toc.csv")}, Data: []byte("toc")}
+	padding := &S3Obj{NoHeaderRequired: true}
+	tests := []struct {
+		o    *S3Obj
+		opts S3TarS3Options
+	}{
+		{o: object, opts: S3TarS3Options{}},
+		{o: data, opts: S3TarS3Options{PreservePOSIXMetadata: true}},
+		{o: padding, opts: S3TarS3Options{PreservePOSIXMetadata: true}},
+	}
+	for _, tt := range tests {
+		if head, err := objectHead(context.Background(), nil, tt.o, &tt.opts); head != nil || err != nil {
+			t.Errorf("objectHead(%+v) = %v, %v, want nil without a request", tt.o, head, err)
+		}
+	}
+}
+
+func TestWriteErrorManifest(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "errors.csv")
+	skipped := []*SkippedObject{
+		{Bucket: "bucket", Key: "a.txt", Size: 10, ETag: "abc", Attempts: 4, Err: fmt.Errorf("%w: denied", ErrAccessDenied)},
+		{Bucket: "bucket", Key: "b.txt", Size: 20, Attempts: 1, Err: errors.New("connection reset")},
+	}
+	if err := WriteErrorManifest(context.Background(), nil, location, "s3://bucket/archive.tar", skipped); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "bucket,key,size,etag,error_class,attempts,error,archive\n" +
+		"bucket,a.txt,10,abc,AccessDenied,4,access denied: denied,s3://bucket/archive.tar\n" +
+		"bucket,b.txt,20,,Other,1,connection reset,s3://bucket/archive.tar\n"
+	if string(data) != want {
+		t.Errorf("WriteErrorManifest() = %q, want %q", data, want)
+	}
+}
+
+func TestLoadErrorManifest(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "errors.csv")
+	skipped := []*SkippedObject{
+		{Bucket: "bucket", Key: "a.txt", Size: 10, ETag: "abc", Attempts: 4, Err: errors.New("read: connection reset, retrying")},
+		{Bucket: "other", Key: "b.txt", Size: 20, Attempts: 1, Err: errors.New("EOF")},
+	}
+	if err := WriteErrorManifest(context.Background(), nil, location, "s3://bucket/archive.tar", skipped); err != nil {
+		t.Fatal(err)
+	}
+	objectList, _, archive, err := LoadErrorManifest(context.Background(), nil, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive != "s3://bucket/archive.tar" {
+		t.Errorf("LoadErrorManifest() archive = %s, want s3://bucket/archive.tar", archive)
+	}
+	if len(objectList) != len(skipped) {
+		t.Fatalf("LoadErrorManifest() got %d objects, want %d", len(objectList), len(skipped))
+	}
+	for i, o := range objectList {
+		if o.Bucket != skipped[i].Bucket || *o.Key != skipped[i].Key || *o.Size != skipped[i].Size {
+			t.Errorf("LoadErrorManifest() object %d = s3://%s/%s (%d)", i, o.Bucket, *o.Key, *o.Size)
+		}
+	}
+}
+
+func TestSkipArchived(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "toc.csv")
+	toc := "a.txt,1024,10,\"\"\"abc\"\"\"\nb.txt,2048,20,def\nc.txt,3072,30,\n"
+	if err := os.WriteFile(location, []byte(toc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := LoadArchived(SetupLogger(context.Background()), nil, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectList := []*S3Obj{
+		{Bucket: "bucket", Object: types.Object{Key: aws.String("a.txt"), Size: aws.Int64(10), ETag: aws.String(`"abc"`)}},
+		{Bucket: "bucket", Object: types.Object{Key: aws.String("b.txt"), Size: aws.Int64(20), ETag: aws.String(`"changed"`)}},
+		{Bucket: "bucket", Object: types.Object{Key: aws.String("c.txt"), Size: aws.Int64(30), ETag: aws.String(`"ghi"`)}},
+		{Bucket: "bucket", Object: types.Object{Key: aws.String("d.txt"), Size: aws.Int64(40), ETag: aws.String(`"def"`)}},
+	}
+	remaining, estimatedSize := SkipArchived(objectList, archived)
+	var keys []string
+	for _, o := range remaining {
+		keys = append(keys, *o.Key)
+	}
+	if fmt.Sprint(keys) != "[b.txt c.txt d.txt]" {
+		t.Errorf("SkipArchived() = %v, want [b.txt c.txt d.txt]", keys)
+	}
+	if want := estimateObjectSize(20) + estimateObjectSize(30) + estimateObjectSize(40); estimatedSize != want {
+		t.Errorf("SkipArchived() estimated size = %d, want %d", estimatedSize, want)
+	}
+}
+
+func TestFlatFilter(t *testing.T) {
+	keep := FlatFilter("logs/")
+	tests := map[string]bool{
+		"logs/a.txt":      true,
+		"logs/2024/b.txt": false,
+		"logs/2024/":      false,
+	}
+	for key, want := range tests {
+		if got := keep(types.Object{Key: aws.String(key)}); got != want {
+			t.Errorf("FlatFilter(logs/)(%s) = %t, want %t", key, got, want)
+		}
+	}
+}
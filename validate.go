@@ -0,0 +1,365 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// maxExtensionSize is the largest PAX or GNU long name extension accepted
+	// before the header that follows it is considered corrupt.
+	maxExtensionSize = 1024 * 1024
+	// maxTrailingSize is the most bytes after the end-of-archive marker read to
+	// check they are zeros, tar implementations pad archives to their record size.
+	maxTrailingSize = 1024 * 1024
+)
+
+// ValidationReport is the result of ValidateArchive. CorruptOffset is -1 when
+// the archive is valid.
+type ValidationReport struct {
+	Archive       string `json:"archive"`
+	Size          int64  `json:"size"`
+	Members       int    `json:"members"`
+	TocMembers    int    `json:"toc_members"`
+	CorruptOffset int64  `json:"corrupt_offset"`
+	Problem       string `json:"problem,omitempty"`
+	// Format is the tar variant of the first header: pax, ustar, gnu or v7,
+	// empty when it isn't a valid header.
+	Format string `json:"format,omitempty"`
+	// Toc are the members found before the corruption.
+	Toc TOC `json:"-"`
+}
+
+// Valid reports whether no problem was found.
+func (r *ValidationReport) Valid() bool {
+	return r.CorruptOffset < 0
+}
+
+// fail records a problem unless one was found before offset.
+func (r *ValidationReport) fail(offset int64, format string, v ...interface{}) {
+	if r.CorruptOffset >= 0 && r.CorruptOffset <= offset {
+		return
+	}
+	r.CorruptOffset = offset
+	r.Problem = fmt.Sprintf(format, v...)
+}
+
+// ValidateArchive walks every header of an archive in Amazon S3 with range
+// requests, without reading the member data, and checks the headers, the
+// sizes, the end-of-archive marker and, for archives created with a toc.csv,
+// that the TOC matches the members. A corrupt archive is reported with an
+// error wrapping ErrInvalidArchive, and a compressed archive or one in
+// another format with an error wrapping ErrUnsupportedFormat.
+func ValidateArchive(ctx context.Context, svc *s3.Client, bucket, key string) (*ValidationReport, error) {
+	archive, err := headArchive(ctx, svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	// the first block is kept by the reader for the walk of the headers
+	format, err := readFormat(archive)
+	if err != nil {
+		return nil, err
+	}
+	if format != "" && !isTarFormat(format) {
+		return nil, unsupportedFormat(objectURL(bucket, key), format, "only the headers of uncompressed tar archives can be validated")
+	}
+	report, err := validateArchive(ctx, archive.readAt, archive.size)
+	if err != nil {
+		return nil, err
+	}
+	report.Archive = fmt.Sprintf("s3://%s/%s", bucket, key)
+	report.Format = format
+	if !report.Valid() {
+		return report, fmt.Errorf("%w: %s at offset %d: %s", ErrInvalidArchive, report.Archive, report.CorruptOffset, report.Problem)
+	}
+	return report, nil
+}
+
+// validateArchive does the work of ValidateArchive reading the archive of size
+// bytes with readAt. Errors reading are returned, problems with the archive
+// are in the report.
+func validateArchive(ctx context.Context, readAt func(offset, n int64) ([]byte, error), size int64) (*ValidationReport, error) {
+	report := &ValidationReport{Size: size, CorruptOffset: -1}
+	offset, err := walkHeaders(ctx, readAt, report, 0)
+	if err != nil {
+		return nil, err
+	}
+	if report.Valid() {
+		if err := checkArchiveEnd(readAt, report, offset); err != nil {
+			return nil, err
+		}
+	}
+	if len(report.Toc) > 0 && report.Toc[0].Filename == "toc.csv" {
+		if err := checkToc(readAt, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// walkHeaders adds the members from the header at offset to the report until
+// the end-of-archive marker or a problem, and returns the offset it stopped at.
+func walkHeaders(ctx context.Context, readAt func(offset, n int64) ([]byte, error), report *ValidationReport, offset int64) (int64, error) {
+	size := report.Size
+	for {
+		if offset+blockSize > size {
+			report.fail(offset, "archive ends without the end-of-archive marker")
+			return offset, nil
+		}
+		block, err := readAt(offset, blockSize)
+		if err != nil {
+			return offset, err
+		}
+		if int64(len(block)) != blockSize {
+			return offset, fmt.Errorf("read %d bytes at %d, want %d", len(block), offset, blockSize)
+		}
+		if isZeroBlock(block) {
+			return offset, nil
+		}
+
+		// extension headers (PAX, GNU long names) come before the header they describe
+		start := offset
+		raw := append([]byte{}, block...)
+		for {
+			typeflag, extSize, err := parseHeaderBlock(block)
+			if err != nil {
+				report.fail(offset, "%s", err.Error())
+				return start, nil
+			}
+			if typeflag != tar.TypeXHeader && typeflag != tar.TypeGNULongName && typeflag != tar.TypeGNULongLink {
+				break
+			}
+			if extSize > maxExtensionSize {
+				report.fail(offset, "extension header of %d bytes", extSize)
+				return start, nil
+			}
+			extEnd := offset + blockSize + extSize + findPadding(extSize)
+			if extEnd+blockSize > size {
+				report.fail(offset, "extension header runs past the end of the archive")
+				return start, nil
+			}
+			data, err := readAt(offset+blockSize, extEnd-offset)
+			if err != nil {
+				return start, err
+			}
+			if int64(len(data)) != extEnd-offset {
+				return start, fmt.Errorf("read %d bytes at %d, want %d", len(data), offset+blockSize, extEnd-offset)
+			}
+			raw = append(raw, data...)
+			offset = extEnd
+			block = data[int64(len(data))-blockSize:]
+		}
+
+		hdr, err := tar.NewReader(bytes.NewReader(raw)).Next()
+		if err != nil {
+			report.fail(start, "unable to parse header: %s", err.Error())
+			return start, nil
+		}
+		dataStart := offset + blockSize
+		dataSize := hdr.Size
+		if isHeaderOnly(hdr.Typeflag) {
+			dataSize = 0
+		}
+		dataEnd := dataStart + dataSize
+		if dataEnd+findPadding(dataEnd) > size {
+			report.fail(start, "member %s needs %d bytes, the archive is truncated at %d", hdr.Name, dataSize, size)
+			return start, nil
+		}
+		Debugf(ctx, "member %s at %d, %d bytes", hdr.Name, dataStart, dataSize)
+		report.Toc = append(report.Toc, &FileMetadata{Filename: hdr.Name, Start: dataStart, Size: dataSize})
+		report.Members++
+		offset = dataEnd + findPadding(dataEnd)
+	}
+}
+
+// checkArchiveEnd checks the two zero blocks at offset and that whatever
+// follows them is zeros.
+func checkArchiveEnd(readAt func(offset, n int64) ([]byte, error), report *ValidationReport, offset int64) error {
+	if offset+2*blockSize > report.Size {
+		report.fail(offset, "archive ends after the first block of the end-of-archive marker")
+		return nil
+	}
+	block, err := readAt(offset+blockSize, blockSize)
+	if err != nil {
+		return err
+	}
+	if !isZeroBlock(block) {
+		report.fail(offset+blockSize, "single zero block in front of a header")
+		return nil
+	}
+	trailing := report.Size - offset - 2*blockSize
+	if trailing == 0 {
+		return nil
+	}
+	if trailing > maxTrailingSize {
+		report.fail(offset+2*blockSize, "%d bytes after the end-of-archive marker", trailing)
+		return nil
+	}
+	data, err := readAt(offset+2*blockSize, trailing)
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexFunc(data, func(r rune) bool { return r != 0 }); i >= 0 {
+		report.fail(offset+2*blockSize+int64(i), "data after the end-of-archive marker")
+	}
+	return nil
+}
+
+// checkToc compares the toc.csv of an archive created by s3tar with the
+// members found after it.
+func checkToc(readAt func(offset, n int64) ([]byte, error), report *ValidationReport) error {
+	m := report.Toc[0]
+	if m.Size == 0 {
+		report.fail(m.Start, "toc.csv is empty")
+		return nil
+	}
+	data, err := readAt(m.Start, m.Size)
+	if err != nil {
+		return err
+	}
+	toc, err := parseTocCSV(bytes.NewReader(data))
+	if err != nil {
+		report.fail(m.Start, "%s", err.Error())
+		return nil
+	}
+	report.TocMembers = len(toc)
+	members := report.Toc[1:]
+	for i, entry := range toc {
+		if i >= len(members) {
+			if report.Valid() {
+				report.fail(entry.Start, "toc.csv lists %s but the archive ends before it", entry.Filename)
+			}
+			return nil
+		}
+		got := members[i]
+		if got.Filename != entry.Filename || got.Start != entry.Start || got.Size != entry.Size {
+			report.fail(got.Start, "toc.csv lists %s at %d with %d bytes, the archive has %s at %d with %d bytes",
+				entry.Filename, entry.Start, entry.Size, got.Filename, got.Start, got.Size)
+			return nil
+		}
+		*got = *entry
+	}
+	if len(members) > len(toc) {
+		report.fail(members[len(toc)].Start, "member %s isn't in toc.csv", members[len(toc)].Filename)
+	}
+	return nil
+}
+
+// parseTocCSV reads the name,start,size,etag lines of a csv TOC, followed by
+// the checksum of the object in TOCs written with TocChecksums, the origin
+// of the object in TOCs of archives merged from several sources and the
+// version id, storage class, content type and owner with TocExtended, and the
+// offset of the frame of the member next to an archive written by Recompress.
+func parseTocCSV(r io.Reader) (TOC, error) {
+	var toc TOC
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to parse csv TOC: %w", ErrInvalidArchive, err)
+	}
+	for i, record := range records {
+		if (len(record) < tocFieldsBasic || len(record) > tocFieldsOrigin) && len(record) != tocFieldsExtended && len(record) != tocFieldsFramed {
+			return nil, fmt.Errorf("%w: line %d of the csv TOC has %d fields, want %d to %d, %d or %d", ErrInvalidArchive, i+1, len(record), tocFieldsBasic, tocFieldsOrigin, tocFieldsExtended, tocFieldsFramed)
+		}
+		start, err := strconv.ParseInt(record[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d of the csv TOC: %w", ErrInvalidArchive, i+1, err)
+		}
+		size, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d of the csv TOC: %w", ErrInvalidArchive, i+1, err)
+		}
+		m := &FileMetadata{Filename: record[0], Start: start, Size: size, Etag: record[3]}
+		if len(record) > 4 {
+			m.Checksum = record[4]
+		}
+		if len(record) > 5 {
+			m.Origin = record[5]
+		}
+		if len(record) >= tocFieldsExtended {
+			m.VersionId, m.StorageClass, m.ContentType, m.Owner = record[6], record[7], record[8], record[9]
+		}
+		if len(record) == tocFieldsFramed {
+			frame, err := strconv.ParseInt(record[10], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d of the csv TOC: %w", ErrInvalidArchive, i+1, err)
+			}
+			m.FrameOffset = &frame
+		}
+		toc = append(toc, m)
+	}
+	return toc, nil
+}
+
+// parseHeaderBlock checks the checksum of a tar header block and returns its
+// type and the size of the data that follows it.
+func parseHeaderBlock(block []byte) (byte, int64, error) {
+	if int64(len(block)) < blockSize {
+		return 0, 0, fmt.Errorf("short header block of %d bytes", len(block))
+	}
+	want, err := parseOctal(block[148:156])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid header checksum field: %w", err)
+	}
+	var unsigned, signed int64
+	for i, c := range block[:blockSize] {
+		if i >= 148 && i < 156 {
+			c = ' '
+		}
+		unsigned += int64(c)
+		signed += int64(int8(c))
+	}
+	if want != unsigned && want != signed {
+		return 0, 0, fmt.Errorf("header checksum is %d, want %d", want, unsigned)
+	}
+
+	var size int64
+	if field := block[124:136]; field[0]&0x80 != 0 {
+		// base-256 encoding of GNU tar for sizes over 8GiB
+		for _, c := range field[1:] {
+			size = size<<8 | int64(c)
+		}
+	} else if size, err = parseOctal(field); err != nil {
+		return 0, 0, fmt.Errorf("invalid header size field: %w", err)
+	}
+	return block[156], size, nil
+}
+
+func parseOctal(field []byte) (int64, error) {
+	s := strings.Trim(string(field), " \x00")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 8, 64)
+}
+
+func isZeroBlock(block []byte) bool {
+	for _, c := range block {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isHeaderOnly reports whether members of the type have no data whatever
+// their size field says.
+func isHeaderOnly(typeflag byte) bool {
+	switch typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return true
+	}
+	return false
+}
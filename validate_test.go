@@ -0,0 +1,163 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchive returns a tar with a toc.csv in front of members of the given
+// sizes, as archives created by s3tar. tocFn can change the TOC lines.
+func testArchive(t *testing.T, tocFn func([]string) []string, sizes ...int) []byte {
+	t.Helper()
+	// the offsets in the TOC depend on the padded size of the TOC itself
+	var toc string
+	for padded := int64(0); ; {
+		var lines []string
+		start := blockSize + padded
+		for i, size := range sizes {
+			start += blockSize
+			lines = append(lines, fmt.Sprintf("file%d.txt,%d,%d,etag%d", i, start, size, i))
+			start += int64(size) + findPadding(int64(size))
+		}
+		if tocFn != nil {
+			lines = tocFn(lines)
+		}
+		toc = strings.Join(lines, "\n") + "\n"
+		n := int64(len(toc)) + findPadding(int64(len(toc)))
+		if n == padded {
+			break
+		}
+		padded = n
+	}
+
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	write := func(name string, data []byte) {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Unix(1700000000, 0), Format: tar.FormatUSTAR}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("toc.csv", []byte(toc))
+	for i, size := range sizes {
+		write(fmt.Sprintf("file%d.txt", i), bytes.Repeat([]byte{'a'}, size))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readAtBytes(data []byte) func(offset, n int64) ([]byte, error) {
+	return func(offset, n int64) ([]byte, error) {
+		end := offset + n
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		return data[offset:end], nil
+	}
+}
+
+func TestValidateArchive(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	valid := testArchive(t, nil, 100, 1024, 0)
+	// toc.csv header, toc.csv, file0.txt header, file0.txt, file1.txt header...
+	file1 := int64(blockSize + blockSize + blockSize + blockSize)
+	end := int64(len(valid)) - 2*blockSize
+
+	tests := []struct {
+		name    string
+		data    []byte
+		problem string
+		offset  int64
+	}{
+		{name: "valid", data: valid, offset: -1},
+		{name: "record padding", data: append(append([]byte{}, valid...), make([]byte, 10*blockSize)...), offset: -1},
+		{name: "truncated member", data: valid[:file1+blockSize+100], problem: "truncated", offset: file1},
+		{name: "bad checksum", data: func() []byte {
+			d := append([]byte{}, valid...)
+			d[file1] ^= 0xff
+			return d
+		}(), problem: "checksum", offset: file1},
+		{name: "missing end", data: valid[:end], problem: "end-of-archive", offset: end},
+		{name: "half end", data: valid[:end+blockSize], problem: "end-of-archive", offset: end},
+		{name: "trailing data", data: append(append([]byte{}, valid...), 'x'), problem: "after the end-of-archive", offset: int64(len(valid))},
+		{name: "toc mismatch", data: testArchive(t, func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], ",1024,", ",1000,", 1)
+			return lines
+		}, 100, 1024, 0), problem: "toc.csv lists file1.txt", offset: file1 + blockSize},
+		{name: "toc missing member", data: testArchive(t, func(lines []string) []string {
+			return lines[:2]
+		}, 100, 1024, 0), problem: "isn't in toc.csv", offset: file1 + blockSize + 1024 + blockSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := validateArchive(ctx, readAtBytes(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.offset == -1 {
+				if !report.Valid() {
+					t.Fatalf("validateArchive() problem at %d: %s", report.CorruptOffset, report.Problem)
+				}
+				if report.Members != 4 || report.TocMembers != 3 {
+					t.Errorf("validateArchive() Members = %d, TocMembers = %d, want 4 and 3", report.Members, report.TocMembers)
+				}
+				return
+			}
+			if report.Valid() {
+				t.Fatalf("validateArchive() found no problem, want %q", tt.problem)
+			}
+			if !strings.Contains(report.Problem, tt.problem) {
+				t.Errorf("validateArchive() Problem = %q, want %q", report.Problem, tt.problem)
+			}
+			if report.CorruptOffset != tt.offset {
+				t.Errorf("validateArchive() CorruptOffset = %d, want %d", report.CorruptOffset, tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateArchive_PAX(t *testing.T) {
+	ctx := SetupLogger(context.Background())
+	objectList := testObjects(10, 700, 513)
+	opts := &S3TarS3Options{ConcatInMemory: true, RecordOrigin: true}
+	if _, err := EstimateArchive(ctx, objectList, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, toc, err := tarGroup(ctx, nil, objectList, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 2*blockSize)...)
+	report, err := validateArchive(ctx, readAtBytes(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || report.Members != len(objectList) {
+		t.Errorf("validateArchive() Members = %d, problem %q, want %d members", report.Members, report.Problem, len(objectList))
+	}
+	for i, m := range report.Toc {
+		if m.Filename != *objectList[i].Key || m.Size != *objectList[i].Size {
+			t.Errorf("Toc[%d] = %s %d, want %s %d", i, m.Filename, m.Size, *objectList[i].Key, *objectList[i].Size)
+		}
+		// the toc recorded while writing matches the members found walking the headers
+		if i < len(toc) && (toc[i].Filename != m.Filename || toc[i].Start != m.Start || toc[i].Size != m.Size) {
+			t.Errorf("tarGroup() toc[%d] = %s %d %d, want %s %d %d", i, toc[i].Filename, toc[i].Start, toc[i].Size, m.Filename, m.Start, m.Size)
+		}
+	}
+	if len(toc) != len(objectList) {
+		t.Errorf("tarGroup() toc has %d members, want %d", len(toc), len(objectList))
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DeleteMarkerPolicy decides what happens to the keys of a versioned source
+// whose latest version is a delete marker, which a listing leaves out.
+type DeleteMarkerPolicy string
+
+const (
+	// DeleteMarkersOff lists the source with ListObjectsV2, the deleted keys
+	// aren't listed.
+	DeleteMarkersOff DeleteMarkerPolicy = ""
+	// DeleteMarkersIgnore lists the versions of the source and leaves the
+	// deleted keys out with a warning each, they are counted in the summary
+	// but aren't skipped objects.
+	DeleteMarkersIgnore DeleteMarkerPolicy = "ignore"
+	// DeleteMarkersReport lists the versions of the source and skips the
+	// deleted keys, they are reported as skipped objects.
+	DeleteMarkersReport DeleteMarkerPolicy = "report"
+	// DeleteMarkersInclude lists the versions of the source and archives the
+	// newest version of a deleted key before its delete marker. Keys with
+	// nothing but delete markers are reported as with DeleteMarkersReport.
+	DeleteMarkersInclude DeleteMarkerPolicy = "include"
+)
+
+// errDeleteMarker is the error of the keys skipped for their delete marker.
+var errDeleteMarker = fmt.Errorf("%w: the latest version is a delete marker", ErrNotFound)
+
+func validateDeleteMarkers(opts *S3TarS3Options) error {
+	switch opts.DeleteMarkers {
+	case DeleteMarkersOff, DeleteMarkersIgnore, DeleteMarkersReport, DeleteMarkersInclude:
+	default:
+		return fmt.Errorf("%w: unknown delete marker policy %q", ErrInvalidArgument, opts.DeleteMarkers)
+	}
+	return nil
+}
+
+// ListAllObjectVersions lists the objects of a versioned prefix as
+// ListAllObjects does, but from the versions of the keys, so the keys whose
+// latest version is a delete marker are handled with policy instead of left
+// out. DeleteMarkersOff is ListAllObjects. The deleted keys that aren't
+// archived are listed without a size and left out by CreateFromList.
+func ListAllObjectVersions(ctx context.Context, client *s3.Client, Bucket, Prefix string, policy DeleteMarkerPolicy, filterFns ...func(types.Object) bool) ([]*S3Obj, int64, error) {
+	if policy == DeleteMarkersOff {
+		return ListAllObjects(ctx, client, Bucket, Prefix, filterFns...)
+	}
+	type keyVersions struct {
+		newest  *types.ObjectVersion
+		deleted bool
+	}
+	keys := map[string]*keyVersions{}
+	entry := func(key string) *keyVersions {
+		k, ok := keys[key]
+		if !ok {
+			k = &keyVersions{}
+			keys[key] = k
+		}
+		return k
+	}
+	p := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{Bucket: &Bucket, Prefix: &Prefix})
+	for p.HasMorePages() {
+		output, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		// the versions of a key are listed from the newest, the first one
+		// seen is the one archived
+		for i := range output.Versions {
+			v := &output.Versions[i]
+			if k := entry(*v.Key); k.newest == nil {
+				k.newest = v
+			}
+		}
+		for _, m := range output.DeleteMarkers {
+			if aws.ToBool(m.IsLatest) {
+				entry(*m.Key).deleted = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	allFilters := append([]func(types.Object) bool{removeDirs}, filterFns...)
+	var list []*S3Obj
+	var accum int64
+	deleted := 0
+	for _, key := range names {
+		k := keys[key]
+		o := &S3Obj{Object: types.Object{Key: aws.String(key), Size: aws.Int64(0), LastModified: aws.Time(time.Now())}, Bucket: Bucket}
+		if v := k.newest; v != nil && (!k.deleted || policy == DeleteMarkersInclude) {
+			o.Object = types.Object{Key: v.Key, Size: v.Size, ETag: v.ETag, LastModified: v.LastModified, StorageClass: types.ObjectStorageClass(v.StorageClass), Owner: v.Owner}
+			if k.deleted {
+				o.VersionId = aws.ToString(v.VersionId)
+			}
+		} else {
+			o.deleteMarker = true
+		}
+		if !keep(o.Object, allFilters) {
+			continue
+		}
+		if k.deleted {
+			deleted++
+		}
+		o.PartNum = len(list) + 1
+		list = append(list, o)
+		if !o.deleteMarker {
+			accum += estimateObjectSize(*o.Size)
+		}
+	}
+	if deleted > 0 {
+		Infof(ctx, "s3://%s/%s has %d keys whose latest version is a delete marker", Bucket, Prefix, deleted)
+	}
+	return list, accum, nil
+}
+
+func keep(o types.Object, filterFns []func(types.Object) bool) bool {
+	for _, f := range filterFns {
+		if !f(o) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitDeleteMarkers returns the objects of objectList to archive and the
+// deleted keys listed by ListAllObjectVersions, which are skipped.
+func splitDeleteMarkers(objectList []*S3Obj) ([]*S3Obj, []*S3Obj) {
+	var deleted []*S3Obj
+	for _, o := range objectList {
+		if o.deleteMarker {
+			deleted = append(deleted, o)
+		}
+	}
+	if len(deleted) == 0 {
+		return objectList, nil
+	}
+	return filter(objectList, func(o *S3Obj) bool { return !o.deleteMarker }), deleted
+}
+
+// reportDeleteMarkers records the deleted keys of a run as skipped objects,
+// or with DeleteMarkersIgnore only warns about them.
+func reportDeleteMarkers(ctx context.Context, deleted []*S3Obj, policy DeleteMarkerPolicy) {
+	for _, o := range deleted {
+		if policy == DeleteMarkersIgnore {
+			Warnf(ctx, "leaving out s3://%s/%s, its latest version is a delete marker", o.Bucket, *o.Key)
+			continue
+		}
+		Warnf(ctx, "skipping s3://%s/%s, its latest version is a delete marker", o.Bucket, *o.Key)
+		recordSkipped(ctx, &SkippedObject{Bucket: o.Bucket, Key: *o.Key, Err: errDeleteMarker})
+	}
+}
+
+// versionID is the version of o that is read, nil for the latest one.
+func versionID(o *S3Obj) *string {
+	if o.VersionId == "" {
+		return nil
+	}
+	return aws.String(o.VersionId)
+}
+
+// copySource is the CopySource of UploadPartCopy for o, with its version.
+func copySource(o *S3Obj) *string {
+	source := o.Bucket + "/" + url.QueryEscape(*o.Key)
+	if o.VersionId != "" {
+		source += "?versionId=" + url.QueryEscape(o.VersionId)
+	}
+	return aws.String(source)
+}
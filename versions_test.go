@@ -0,0 +1,105 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package s3tar
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestValidateDeleteMarkers(t *testing.T) {
+	tests := map[string]struct {
+		policy  DeleteMarkerPolicy
+		want    DeleteMarkerPolicy
+		wantErr bool
+	}{
+		"default": {policy: "", want: DeleteMarkersOff},
+		"ignore":  {policy: "ignore", want: DeleteMarkersIgnore},
+		"report":  {policy: "report", want: DeleteMarkersReport},
+		"include": {policy: "include", want: DeleteMarkersInclude},
+		"unknown": {policy: "restore", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := &S3TarS3Options{DeleteMarkers: tt.policy}
+			err := validateDeleteMarkers(opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateDeleteMarkers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && opts.DeleteMarkers != tt.want {
+				t.Errorf("validateDeleteMarkers() = %q, want %q", opts.DeleteMarkers, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitDeleteMarkers(t *testing.T) {
+	a := NewS3ObjOptions(WithBucketAndKey("b", "a"), WithSize(1))
+	deleted := NewS3ObjOptions(WithBucketAndKey("b", "deleted"), WithSize(0))
+	deleted.deleteMarker = true
+	c := NewS3ObjOptions(WithBucketAndKey("b", "c"), WithSize(1))
+	c.VersionId = "v1"
+
+	objectList, skipped := splitDeleteMarkers([]*S3Obj{a, deleted, c})
+	if len(objectList) != 2 || objectList[0] != a || objectList[1] != c {
+		t.Errorf("splitDeleteMarkers() = %d objects, want a and c", len(objectList))
+	}
+	if len(skipped) != 1 || skipped[0] != deleted {
+		t.Errorf("splitDeleteMarkers() deleted = %d objects, want the deleted key", len(skipped))
+	}
+
+	if got := *copySource(a); got != "b/a" {
+		t.Errorf("copySource(a) = %s", got)
+	}
+	if got := *copySource(c); got != "b/c?versionId=v1" {
+		t.Errorf("copySource(c) = %s", got)
+	}
+	if versionID(a) != nil || aws.ToString(versionID(c)) != "v1" {
+		t.Errorf("versionID() = %v, %v", versionID(a), versionID(c))
+	}
+
+	s := &SkippedObject{Bucket: "b", Key: "deleted", Err: errDeleteMarker}
+	if got := s.ErrorClass(); got != "DeleteMarker" || !errors.Is(s.Err, ErrNotFound) {
+		t.Errorf("ErrorClass() = %s", got)
+	}
+}
+
+func TestDeleteMarkersSummary(t *testing.T) {
+	tests := map[string]struct {
+		policy      DeleteMarkerPolicy
+		wantIgnored int
+		wantSkipped int
+	}{
+		"ignore": {policy: DeleteMarkersIgnore, wantIgnored: 1},
+		"report": {policy: DeleteMarkersReport, wantSkipped: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := SetupLogger(context.Background())
+			store := &fakeStore{objects: map[string][]byte{"src/a.txt": []byte("hello"), "src/c.txt": []byte("world")}, parts: map[string]map[int][]byte{}}
+			deleted := NewS3ObjOptions(WithBucketAndKey("src", "b.txt"), WithSize(0))
+			deleted.deleteMarker = true
+			objectList := []*S3Obj{
+				NewS3ObjOptions(WithBucketAndKey("src", "a.txt"), WithSize(5)),
+				deleted,
+				NewS3ObjOptions(WithBucketAndKey("src", "c.txt"), WithSize(5)),
+			}
+			var summary *RunSummary
+			opts := &S3TarS3Options{DstBucket: "dst", DstKey: "a.tar", ConcatInMemory: true, Threads: 1, DeleteMarkers: tt.policy,
+				SummaryFn: func(s *RunSummary) { summary = s }}
+			if err := createFromList(ctx, store.client(), objectList, opts); err != nil {
+				t.Fatal(err)
+			}
+			if summary.Members != 2 || summary.IgnoredDeleteMarkers != tt.wantIgnored || len(summary.Skipped) != tt.wantSkipped {
+				t.Errorf("summary = %d members, %d ignored, skipped %v, want 2, %d, %d skipped", summary.Members, summary.IgnoredDeleteMarkers, summary.Skipped, tt.wantIgnored, tt.wantSkipped)
+			}
+			if _, ok := store.objects["dst/a.tar.errors.csv"]; ok != (tt.wantSkipped > 0) {
+				t.Errorf("error manifest written = %t, want %t", ok, tt.wantSkipped > 0)
+			}
+		})
+	}
+}